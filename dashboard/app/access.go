@@ -90,6 +90,8 @@ func checkTextAccess(c context.Context, r *http.Request, tag string, id int64) (
 			return crash, err
 		}
 		return nil, checkJobTextAccess(c, r, "CrashReport", id)
+	case textDiagnosis:
+		return checkCrashTextAccess(c, r, "Diagnosis", id)
 	case textReproSyz:
 		return checkCrashTextAccess(c, r, "ReproSyz", id)
 	case textReproC:
@@ -42,6 +42,7 @@ func dropNamespace(c context.Context, w http.ResponseWriter, r *http.Request) er
 		{textError, ""},
 		{textCrashLog, ""},
 		{textCrashReport, ""},
+		{textDiagnosis, ""},
 		{"Build", ""},
 		{"Manager", "ManagerStats"},
 		{"Bug", "Crash"},
@@ -554,9 +554,19 @@ func reportCrash(c context.Context, build *Build, req *dashapi.Crash) (*Bug, err
 	if err != nil {
 		return nil, err
 	}
-	if active, err := isActiveBug(c, bug); err != nil {
+	active, err := isActiveBug(c, bug)
+	if err != nil {
 		return nil, err
-	} else if !active {
+	}
+	if !active && !req.Corrupted {
+		// Bugs reported before titles were changed (e.g. normalized) are still found by alt titles.
+		bug, bugKey, err = findActiveBugForAltTitles(c, ns, req.AltTitles)
+		if err != nil {
+			return nil, err
+		}
+		active = bug != nil
+	}
+	if !active {
 		bug, bugKey, err = createBugForCrash(c, ns, req)
 		if err != nil {
 			return nil, err
@@ -587,7 +597,11 @@ func reportCrash(c context.Context, build *Build, req *dashapi.Crash) (*Bug, err
 		if err := datastore.Get(c, bugKey, bug); err != nil {
 			return fmt.Errorf("failed to get bug: %v", err)
 		}
-		bug.NumCrashes++
+		if req.Occurrences > 1 {
+			bug.NumCrashes += int64(req.Occurrences)
+		} else {
+			bug.NumCrashes++
+		}
 		bug.LastTime = now
 		if save {
 			bug.LastSavedCrash = now
@@ -645,6 +659,9 @@ func saveCrash(c context.Context, ns string, req *dashapi.Crash, bugKey *datasto
 	if crash.Report, err = putText(c, ns, textCrashReport, req.Report, false); err != nil {
 		return err
 	}
+	if crash.Diagnosis, err = putText(c, ns, textDiagnosis, req.Diagnosis, false); err != nil {
+		return err
+	}
 	if crash.ReproSyz, err = putText(c, ns, textReproSyz, req.ReproSyz, false); err != nil {
 		return err
 	}
@@ -709,6 +726,9 @@ func purgeOldCrashes(c context.Context, bug *Bug, bugKey *datastore.Key) {
 		if crash.Report != 0 {
 			toDelete = append(toDelete, datastore.NewKey(c, textCrashReport, "", crash.Report, nil))
 		}
+		if crash.Diagnosis != 0 {
+			toDelete = append(toDelete, datastore.NewKey(c, textDiagnosis, "", crash.Diagnosis, nil))
+		}
 		if crash.ReproSyz != 0 {
 			toDelete = append(toDelete, datastore.NewKey(c, textReproSyz, "", crash.ReproSyz, nil))
 		}
@@ -830,6 +850,21 @@ func findBugForCrash(c context.Context, ns, title string) (*Bug, *datastore.Key,
 	return bugs[0], keys[0], nil
 }
 
+func findActiveBugForAltTitles(c context.Context, ns string, titles []string) (*Bug, *datastore.Key, error) {
+	for _, title := range titles {
+		bug, bugKey, err := findBugForCrash(c, ns, limitLength(title, maxTextLen))
+		if err != nil {
+			return nil, nil, err
+		}
+		if active, err := isActiveBug(c, bug); err != nil {
+			return nil, nil, err
+		} else if active {
+			return bug, bugKey, nil
+		}
+	}
+	return nil, nil, nil
+}
+
 func createBugForCrash(c context.Context, ns string, req *dashapi.Crash) (*Bug, *datastore.Key, error) {
 	var bug *Bug
 	var bugKey *datastore.Key
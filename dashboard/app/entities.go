@@ -105,6 +105,7 @@ type Crash struct {
 	Maintainers []string  `datastore:",noindex"`
 	Log         int64     // reference to CrashLog text entity
 	Report      int64     // reference to CrashReport text entity
+	Diagnosis   int64     // reference to CrashDiagnosis text entity
 	ReproOpts   []byte    `datastore:",noindex"`
 	ReproSyz    int64     // reference to ReproSyz text entity
 	ReproC      int64     // reference to ReproC text entity
@@ -174,6 +175,7 @@ type Text struct {
 const (
 	textCrashLog     = "CrashLog"
 	textCrashReport  = "CrashReport"
+	textDiagnosis    = "CrashDiagnosis"
 	textReproSyz     = "ReproSyz"
 	textReproC       = "ReproC"
 	textKernelConfig = "KernelConfig"
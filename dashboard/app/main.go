@@ -126,13 +126,14 @@ type uiBug struct {
 }
 
 type uiCrash struct {
-	Manager      string
-	Time         time.Time
-	Maintainers  string
-	LogLink      string
-	ReportLink   string
-	ReproSyzLink string
-	ReproCLink   string
+	Manager       string
+	Time          time.Time
+	Maintainers   string
+	LogLink       string
+	ReportLink    string
+	DiagnosisLink string
+	ReproSyzLink  string
+	ReproCLink    string
 	*uiBuild
 }
 
@@ -344,6 +345,8 @@ func textFilename(tag string) string {
 		return "log.txt"
 	case textCrashReport:
 		return "report.txt"
+	case textDiagnosis:
+		return "diagnosis.txt"
 	case textReproSyz:
 		return "repro.syz"
 	case textReproC:
@@ -680,14 +683,15 @@ func loadCrashesForBug(c context.Context, bug *Bug) ([]*uiCrash, []byte, error)
 			builds[crash.BuildID] = build
 		}
 		ui := &uiCrash{
-			Manager:      crash.Manager,
-			Time:         crash.Time,
-			Maintainers:  strings.Join(crash.Maintainers, ", "),
-			LogLink:      textLink(textCrashLog, crash.Log),
-			ReportLink:   textLink(textCrashReport, crash.Report),
-			ReproSyzLink: textLink(textReproSyz, crash.ReproSyz),
-			ReproCLink:   textLink(textReproC, crash.ReproC),
-			uiBuild:      makeUIBuild(build),
+			Manager:       crash.Manager,
+			Time:          crash.Time,
+			Maintainers:   strings.Join(crash.Maintainers, ", "),
+			LogLink:       textLink(textCrashLog, crash.Log),
+			ReportLink:    textLink(textCrashReport, crash.Report),
+			DiagnosisLink: textLink(textDiagnosis, crash.Diagnosis),
+			ReproSyzLink:  textLink(textReproSyz, crash.ReproSyz),
+			ReproCLink:    textLink(textReproC, crash.ReproC),
+			uiBuild:       makeUIBuild(build),
 		}
 		results = append(results, ui)
 	}
@@ -417,3 +417,27 @@ func TestReportingFilter(t *testing.T) {
 	rep4 := c.client.pollBug()
 	c.expectEQ(string(rep4.Config), `{"Index":2}`)
 }
+
+// Test that crashes with changed titles are attributed to the existing bug via alt titles.
+func TestReportingAltTitles(t *testing.T) {
+	c := NewCtx(t)
+	defer c.Close()
+
+	build := testBuild(1)
+	c.client.UploadBuild(build)
+
+	crash1 := testCrash(build, 1)
+	crash1.Title = "possible deadlock in do_ip_setsockopt"
+	c.client.ReportCrash(crash1)
+	rep := c.client.pollBug()
+
+	crash2 := testCrash(build, 2)
+	crash2.Title = "possible deadlock in sk_lock-AF_INET -> rtnl_mutex"
+	crash2.AltTitles = []string{crash1.Title}
+	c.client.ReportCrash(crash2)
+	c.client.pollBugs(0)
+
+	bug, _, _ := c.loadBug(rep.ID)
+	c.expectEQ(bug.Title, crash1.Title)
+	c.expectEQ(bug.NumCrashes, int64(2))
+}
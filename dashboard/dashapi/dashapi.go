@@ -165,16 +165,34 @@ func (dash *Dashboard) ReportBuildError(req *BuildErrorReq) error {
 type Crash struct {
 	BuildID     string // refers to Build.ID
 	Title       string
-	Corrupted   bool // report is corrupted (corrupted title, no stacks, etc)
+	AltTitles   []string // alternative titles used to find an existing bug (e.g. titles of older syzkaller versions)
+	Corrupted   bool     // report is corrupted (corrupted title, no stacks, etc)
 	Maintainers []string
+	Severity    string // priority of the crash assigned by the manager (see severities config)
 	Log         []byte
 	Report      []byte
+	// Output of the VM Diagnose request (e.g. sysrq task dumps) printed after the crash,
+	// it's not a part of Report.
+	Diagnosis []byte
+	// Number of occurrences of the crash this report stands for
+	// (managers can batch several occurrences into one report, 0 means 1).
+	Occurrences int
+	// Occurrences of the crash per kernel build as seen by the manager (including crashes reported before),
+	// in the order the builds were first seen. Allows to scope bisection without the dashboard history.
+	Builds []CrashBuild
 	// The following is optional and is filled only after repro.
 	ReproOpts []byte
 	ReproSyz  []byte
 	ReproC    []byte
 }
 
+type CrashBuild struct {
+	BuildID   string // build tag, with the kernel tag if the manager runs several kernels
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Count     int
+}
+
 type ReportCrashResp struct {
 	NeedRepro bool
 }
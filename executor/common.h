@@ -223,6 +223,11 @@ static int inject_fault(int nth)
 	return 0;
 }
 #endif
+#if SYZ_FAULT_INJECTION
+static void setup_fault()
+{
+}
+#endif
 #if SYZ_EXECUTOR
 static int fault_injected(int fail_fd)
 {
@@ -467,6 +472,17 @@ again:
 }
 #endif
 
+#if !SYZ_EXECUTOR && (SYZ_PROG_PROLOGUE || SYZ_PROG_EPILOGUE)
+#include <stdlib.h>
+
+// Runs prologue/epilogue shell command specified in manager config.
+static void run_prog_hook(const char* cmd)
+{
+	if (system(cmd)) {
+	}
+}
+#endif
+
 #if SYZ_EXECUTOR || SYZ_REPEAT
 static void execute_one(void);
 #if SYZ_EXECUTOR_USES_FORK_SERVER
@@ -518,6 +534,9 @@ static void loop(void)
 #endif
 #if SYZ_EXECUTOR
 		receive_execute();
+#endif
+#if SYZ_PROG_PROLOGUE
+		run_prog_hook([[PROG_PROLOGUE]]);
 #endif
 		int pid = fork();
 		if (pid < 0)
@@ -599,6 +618,9 @@ static void loop(void)
 			kill_and_wait(pid, &status);
 			break;
 		}
+#if SYZ_PROG_EPILOGUE
+		run_prog_hook([[PROG_EPILOGUE]]);
+#endif
 #if SYZ_EXECUTOR
 		status = WEXITSTATUS(status);
 		if (status == kFailStatus)
@@ -615,7 +637,13 @@ static void loop(void)
 #else
 static void loop(void)
 {
+#if SYZ_PROG_PROLOGUE
+	run_prog_hook([[PROG_PROLOGUE]]);
+#endif
 	execute_one();
+#if SYZ_PROG_EPILOGUE
+	run_prog_hook([[PROG_EPILOGUE]]);
+#endif
 }
 #endif
 #endif
@@ -661,14 +689,23 @@ int main(void)
 #if SYZ_HANDLE_SEGV
 	install_segv_handler();
 #endif
+#if SYZ_FAULT_INJECTION
+	setup_fault();
+#endif
 #if SYZ_PROCS
 	for (procid = 0; procid < [[PROCS]]; procid++) {
 		if (fork() == 0) {
 #endif
 #if SYZ_USE_TMP_DIR || SYZ_SANDBOX_ANDROID_UNTRUSTED_APP
 			use_temporary_dir();
+#endif
+#if !SYZ_REPEAT && SYZ_PROG_PROLOGUE
+			run_prog_hook([[PROG_PROLOGUE]]);
 #endif
 			[[SANDBOX_FUNC]]
+#if !SYZ_REPEAT && SYZ_PROG_EPILOGUE
+			run_prog_hook([[PROG_EPILOGUE]]);
+#endif
 #if SYZ_PROCS
 		}
 	}
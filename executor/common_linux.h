@@ -632,6 +632,206 @@ static long syz_genetlink_get_family_id(long name)
 }
 #endif
 
+#if SYZ_EXECUTOR || __NR_syz_io_uring_setup || __NR_syz_io_uring_submit || __NR_syz_io_uring_complete
+#include <errno.h>
+#include <string.h>
+#include <sys/mman.h>
+#include <unistd.h>
+
+#ifndef __NR_io_uring_setup
+#define __NR_io_uring_setup 425
+#endif
+
+// Parts of linux/io_uring.h (the header is missing on older systems).
+struct syz_io_sqring_offsets {
+	uint32 head;
+	uint32 tail;
+	uint32 ring_mask;
+	uint32 ring_entries;
+	uint32 flags;
+	uint32 dropped;
+	uint32 array;
+	uint32 resv[3];
+};
+
+struct syz_io_cqring_offsets {
+	uint32 head;
+	uint32 tail;
+	uint32 ring_mask;
+	uint32 ring_entries;
+	uint32 overflow;
+	uint32 cqes;
+	uint32 resv[4];
+};
+
+struct syz_io_uring_params {
+	uint32 sq_entries;
+	uint32 cq_entries;
+	uint32 flags;
+	uint32 sq_thread_cpu;
+	uint32 sq_thread_idle;
+	uint32 features;
+	uint32 wq_fd;
+	uint32 resv[3];
+	struct syz_io_sqring_offsets sq_off;
+	struct syz_io_cqring_offsets cq_off;
+};
+
+struct syz_io_uring_cqe {
+	uint64 user_data;
+	uint32 res;
+	uint32 flags;
+};
+
+#define SYZ_IORING_OFF_SQ_RING 0
+#define SYZ_IORING_OFF_CQ_RING 0x8000000
+#define SYZ_IORING_OFF_SQES 0x10000000
+#define SYZ_IORING_FEAT_SINGLE_MMAP 1
+#define SYZ_IO_URING_SQE_SIZE 64
+
+// Rings of io_uring instances created by syz_io_uring_setup.
+// Programs refer to them by the address of the SQ ring (the io_uring_ring resource),
+// the kernel offsets of the ring fields are kept here.
+struct syz_io_uring {
+	char* sq;
+	char* cq;
+	char* sqes;
+	struct syz_io_uring_params params;
+};
+
+static struct syz_io_uring syz_io_urings[8];
+static unsigned syz_io_uring_next;
+
+static struct syz_io_uring* syz_io_uring_lookup(long handle)
+{
+	for (unsigned i = 0; i < sizeof(syz_io_urings) / sizeof(syz_io_urings[0]); i++) {
+		if (syz_io_urings[i].sq && syz_io_urings[i].sq == (char*)handle)
+			return &syz_io_urings[i];
+	}
+	return NULL;
+}
+
+static char* syz_io_uring_mmap(size_t size, int fd, long offset)
+{
+	void* res = mmap(NULL, size, PROT_READ | PROT_WRITE, MAP_SHARED | MAP_POPULATE, fd, offset);
+	return res == MAP_FAILED ? NULL : (char*)res;
+}
+
+static long syz_io_uring_setup(long a0, long a1, long a2)
+{
+	// syz_io_uring_setup(entries int32, params ptr[in, io_uring_params], ring_ptr ptr[out, io_uring_ring]) fd_io_uring
+	struct syz_io_uring_params params;
+	memset(&params, 0, sizeof(params));
+	NONFAILING(memcpy(&params, (void*)a1, sizeof(params)));
+	int fd = syscall(__NR_io_uring_setup, a0, &params);
+	if (fd == -1)
+		return -1;
+	NONFAILING(memcpy((void*)a1, &params, sizeof(params)));
+	size_t sq_size = params.sq_off.array + params.sq_entries * sizeof(uint32);
+	size_t cq_size = params.cq_off.cqes + params.cq_entries * sizeof(struct syz_io_uring_cqe);
+	char* sq = NULL;
+	char* cq = NULL;
+	if (params.features & SYZ_IORING_FEAT_SINGLE_MMAP) {
+		if (cq_size > sq_size)
+			sq_size = cq_size;
+		sq = syz_io_uring_mmap(sq_size, fd, SYZ_IORING_OFF_SQ_RING);
+		cq = sq;
+	} else {
+		// Older kernels map the rings separately.
+		sq = syz_io_uring_mmap(sq_size, fd, SYZ_IORING_OFF_SQ_RING);
+		if (sq)
+			cq = syz_io_uring_mmap(cq_size, fd, SYZ_IORING_OFF_CQ_RING);
+	}
+	char* sqes = NULL;
+	if (cq)
+		sqes = syz_io_uring_mmap(params.sq_entries * SYZ_IO_URING_SQE_SIZE, fd, SYZ_IORING_OFF_SQES);
+	if (sqes == NULL) {
+		int err = errno;
+		debug("syz_io_uring_setup: mmap failed: %d\n", err);
+		if (cq && cq != sq)
+			munmap(cq, cq_size);
+		if (sq)
+			munmap(sq, sq_size);
+		close(fd);
+		errno = err;
+		return -1;
+	}
+	// Programs create few rings, the oldest ring is forgotten if there are more.
+	struct syz_io_uring* ring = &syz_io_urings[syz_io_uring_next++ % (sizeof(syz_io_urings) / sizeof(syz_io_urings[0]))];
+	ring->sq = sq;
+	ring->cq = cq;
+	ring->sqes = sqes;
+	ring->params = params;
+	NONFAILING(*(long*)a2 = (long)sq);
+	return fd;
+}
+
+static long syz_io_uring_queue(struct syz_io_uring* ring, long sqe)
+{
+	const struct syz_io_sqring_offsets* off = &ring->params.sq_off;
+	uint32* tail = (uint32*)(ring->sq + off->tail);
+	uint32 t = *tail;
+	if (t - __atomic_load_n((uint32*)(ring->sq + off->head), __ATOMIC_ACQUIRE) >= ring->params.sq_entries) {
+		errno = EBUSY;
+		return -1;
+	}
+	uint32 index = t & *(uint32*)(ring->sq + off->ring_mask);
+	memcpy(ring->sqes + index * SYZ_IO_URING_SQE_SIZE, (void*)sqe, SYZ_IO_URING_SQE_SIZE);
+	((uint32*)(ring->sq + off->array))[index] = index;
+	__atomic_store_n(tail, t + 1, __ATOMIC_RELEASE);
+	return 0;
+}
+
+static long syz_io_uring_submit(long a0, long a1)
+{
+	// syz_io_uring_submit(ring io_uring_ring, sqe ptr[in, io_uring_sqe])
+	struct syz_io_uring* ring = syz_io_uring_lookup(a0);
+	if (ring == NULL) {
+		errno = EINVAL;
+		return -1;
+	}
+	// The rings may have been unmapped by the program.
+	long res = -1;
+	errno = EFAULT;
+	NONFAILING(res = syz_io_uring_queue(ring, a1));
+	return res;
+}
+
+static long syz_io_uring_reap(struct syz_io_uring* ring)
+{
+	const struct syz_io_cqring_offsets* off = &ring->params.cq_off;
+	uint32* head = (uint32*)(ring->cq + off->head);
+	uint32 h = *head;
+	if (h == __atomic_load_n((uint32*)(ring->cq + off->tail), __ATOMIC_ACQUIRE)) {
+		errno = EAGAIN;
+		return -1;
+	}
+	uint32 index = h & *(uint32*)(ring->cq + off->ring_mask);
+	struct syz_io_uring_cqe* cqe = (struct syz_io_uring_cqe*)(ring->cq + off->cqes) + index;
+	int res = (int)cqe->res;
+	__atomic_store_n(head, h + 1, __ATOMIC_RELEASE);
+	if (res < 0) {
+		errno = -res;
+		return -1;
+	}
+	return res;
+}
+
+static long syz_io_uring_complete(long a0)
+{
+	// syz_io_uring_complete(ring io_uring_ring)
+	struct syz_io_uring* ring = syz_io_uring_lookup(a0);
+	if (ring == NULL) {
+		errno = EINVAL;
+		return -1;
+	}
+	long res = -1;
+	errno = EFAULT;
+	NONFAILING(res = syz_io_uring_reap(ring));
+	return res;
+}
+#endif
+
 #if SYZ_EXECUTOR || __NR_syz_mount_image || __NR_syz_read_part_table
 #include <errno.h>
 #include <fcntl.h>
@@ -2047,6 +2247,30 @@ static int inject_fault(int nth)
 }
 #endif
 
+#if SYZ_FAULT_INJECTION
+// setup_fault configures fault injection the same way as syz-fuzzer/syz-execprog do (see pkg/host),
+// otherwise the injected fault may not fail the allocation that failed during fuzzing.
+static void setup_fault()
+{
+	static struct {
+		const char* file;
+		const char* val;
+		bool fatal;
+	} files[] = {
+	    {"/sys/kernel/debug/failslab/ignore-gfp-wait", "N", true},
+	    {"/sys/kernel/debug/fail_futex/ignore-private", "N", false},
+	    {"/sys/kernel/debug/fail_page_alloc/ignore-gfp-highmem", "N", false},
+	    {"/sys/kernel/debug/fail_page_alloc/ignore-gfp-wait", "N", false},
+	    {"/sys/kernel/debug/fail_page_alloc/min-order", "0", false},
+	};
+	unsigned i;
+	for (i = 0; i < sizeof(files) / sizeof(files[0]); i++) {
+		if (!write_file(files[i].file, files[i].val) && files[i].fatal)
+			fail("failed to write %s", files[i].file);
+	}
+}
+#endif
+
 #if SYZ_EXECUTOR
 static int fault_injected(int fail_fd)
 {
@@ -60,7 +60,7 @@
 
 #if GOARCH_386
 #define GOARCH "386"
-#define SYZ_REVISION "41b7d68957439995599a13c27f4e53a5ef94c6ef"
+#define SYZ_REVISION "88d88a14b2b263e4e48446031d0a604fd36ac18f"
 #define SYZ_EXECUTOR_USES_FORK_SERVER 1
 #define SYZ_EXECUTOR_USES_SHMEM 1
 #define SYZ_PAGE_SIZE 4096
@@ -70,7 +70,7 @@
 
 #if GOARCH_amd64
 #define GOARCH "amd64"
-#define SYZ_REVISION "b7e39f3128ea124913623c5b44f8f4cf11321d3b"
+#define SYZ_REVISION "5aea501fb4032c6a34550730e93b43846546a847"
 #define SYZ_EXECUTOR_USES_FORK_SERVER 1
 #define SYZ_EXECUTOR_USES_SHMEM 1
 #define SYZ_PAGE_SIZE 4096
@@ -80,7 +80,7 @@
 
 #if GOARCH_arm
 #define GOARCH "arm"
-#define SYZ_REVISION "34b155a4d1654ac354700f5d731b25f9b7fdcc76"
+#define SYZ_REVISION "c5ffafea16905c836fb86d55e9383fefc25e573e"
 #define SYZ_EXECUTOR_USES_FORK_SERVER 1
 #define SYZ_EXECUTOR_USES_SHMEM 1
 #define SYZ_PAGE_SIZE 4096
@@ -90,7 +90,7 @@
 
 #if GOARCH_arm64
 #define GOARCH "arm64"
-#define SYZ_REVISION "26f57d8563b809b5879e620054577dcb38668de8"
+#define SYZ_REVISION "c7444ff068b65230cb8faf7f6b95aee45fd13794"
 #define SYZ_EXECUTOR_USES_FORK_SERVER 1
 #define SYZ_EXECUTOR_USES_SHMEM 1
 #define SYZ_PAGE_SIZE 4096
@@ -100,7 +100,7 @@
 
 #if GOARCH_ppc64le
 #define GOARCH "ppc64le"
-#define SYZ_REVISION "2e2cda69f198c8832cb15174493082aab944dd2c"
+#define SYZ_REVISION "04c96bf35d6a6ec36f58b09bbae920f077f28ac3"
 #define SYZ_EXECUTOR_USES_FORK_SERVER 1
 #define SYZ_EXECUTOR_USES_SHMEM 1
 #define SYZ_PAGE_SIZE 4096
@@ -56,6 +56,7 @@ const int kMaxThreads = 16;
 const int kInPipeFd = kMaxFd - 1; // remapped from stdin
 const int kOutPipeFd = kMaxFd - 2; // remapped from stdout
 const int kCoverFd = kOutPipeFd - kMaxThreads;
+const int kTracingFd = kCoverFd - 1;
 const int kMaxArgs = 9;
 const int kCoverSize = 256 << 10;
 const int kFailStatus = 67;
@@ -119,12 +120,16 @@ static sandbox_type flag_sandbox;
 static bool flag_enable_tun;
 static bool flag_enable_net_dev;
 static bool flag_enable_fault_injection;
+static bool flag_enable_profile;
 
 static bool flag_collect_cover;
 static bool flag_dedup_cover;
 static bool flag_threaded;
 static bool flag_collide;
 
+// If true, then kernel tracing is enabled while the program runs (sampled profiling).
+static bool flag_profile;
+
 // If true, then executor should write the comparisons data to fuzzer.
 static bool flag_collect_comps;
 
@@ -299,6 +304,8 @@ static uint64 swap(uint64 v, uint64 size, uint64 bf);
 static void copyin(char* addr, uint64 val, uint64 size, uint64 bf, uint64 bf_off, uint64 bf_len);
 static bool copyout(char* addr, uint64 size, uint64* res);
 static void setup_control_pipes();
+static void profile_open();
+static void profile_enable(bool on);
 
 #include "syscalls.h"
 
@@ -369,6 +376,8 @@ int main(int argc, char** argv)
 			cover_open(&threads[i].cov);
 		}
 	}
+	if (flag_enable_profile)
+		profile_open();
 
 	int status = 0;
 	switch (flag_sandbox) {
@@ -434,6 +443,35 @@ void setup_control_pipes()
 		fail("dup2(2, 0) failed");
 }
 
+// profile_open opens the kernel tracing switch for sampled profiling of programs.
+// It's opened before sandboxing, so that sandboxed processes can still toggle tracing through it.
+// The fuzzer sets up the tracer itself, the executor only turns tracing on/off around programs.
+void profile_open()
+{
+#if GOOS_linux
+	const char* files[] = {"/sys/kernel/debug/tracing/tracing_on", "/sys/kernel/tracing/tracing_on"};
+	for (unsigned i = 0; i < sizeof(files) / sizeof(files[0]); i++) {
+		int fd = open(files[i], O_WRONLY);
+		if (fd == -1)
+			continue;
+		if (dup2(fd, kTracingFd) < 0)
+			fail("dup2(%d, kTracingFd) failed", fd);
+		close(fd);
+		return;
+	}
+#endif
+	debug("failed to open tracing_on, profiling is disabled\n");
+	flag_enable_profile = false;
+}
+
+void profile_enable(bool on)
+{
+	if (!flag_enable_profile || !flag_profile)
+		return;
+	if (write(kTracingFd, on ? "1" : "0", 1) != 1)
+		debug("failed to toggle tracing\n");
+}
+
 void parse_env_flags(uint64 flags)
 {
 	// Note: Values correspond to ordering in pkg/ipc/ipc.go, e.g. FlagSandboxNamespace
@@ -449,6 +487,7 @@ void parse_env_flags(uint64 flags)
 	flag_enable_tun = flags & (1 << 5);
 	flag_enable_net_dev = flags & (1 << 6);
 	flag_enable_fault_injection = flags & (1 << 7);
+	flag_enable_profile = flags & (1 << 8);
 }
 
 #if SYZ_EXECUTOR_USES_FORK_SERVER
@@ -492,14 +531,15 @@ void receive_execute()
 	flag_collect_comps = req.exec_flags & (1 << 3);
 	flag_threaded = req.exec_flags & (1 << 4);
 	flag_collide = req.exec_flags & (1 << 5);
+	flag_profile = req.exec_flags & (1 << 6);
 	flag_fault_call = req.fault_call;
 	flag_fault_nth = req.fault_nth;
 	if (!flag_threaded)
 		flag_collide = false;
-	debug("[%llums] exec opts: procid=%llu threaded=%d collide=%d cover=%d comps=%d dedup=%d fault=%d/%d/%d prog=%llu\n",
+	debug("[%llums] exec opts: procid=%llu threaded=%d collide=%d cover=%d comps=%d dedup=%d fault=%d/%d/%d profile=%d prog=%llu\n",
 	      current_time_ms() - start_time_ms, procid, flag_threaded, flag_collide,
 	      flag_collect_cover, flag_collect_comps, flag_dedup_cover, flag_inject_fault,
-	      flag_fault_call, flag_fault_nth, req.prog_size);
+	      flag_fault_call, flag_fault_nth, flag_profile, req.prog_size);
 	if (SYZ_EXECUTOR_USES_SHMEM) {
 		if (req.prog_size)
 			fail("need_prog: no program");
@@ -553,6 +593,7 @@ void execute_one()
 	write_output(0); // Number of executed syscalls (updated later).
 #endif
 	uint64 start = current_time_ms();
+	profile_enable(true);
 
 retry:
 	uint64* input_pos = (uint64*)input_data;
@@ -727,6 +768,7 @@ retry:
 		collide = colliding = true;
 		goto retry;
 	}
+	profile_enable(false);
 }
 
 thread_t* schedule_call(int call_index, int call_num, bool colliding, uint64 copyout_index, uint64 num_args, uint64* args, uint64* pos)
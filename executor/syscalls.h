@@ -1767,6 +1767,14 @@ const call_t syscalls[] = {
     {"io_pgetevents", 385},
     {"io_setup", 245},
     {"io_submit", 248},
+    {"io_uring_enter", 426},
+    {"io_uring_register$IORING_REGISTER_BUFFERS", 427},
+    {"io_uring_register$IORING_REGISTER_EVENTFD", 427},
+    {"io_uring_register$IORING_REGISTER_FILES", 427},
+    {"io_uring_register$IORING_UNREGISTER_BUFFERS", 427},
+    {"io_uring_register$IORING_UNREGISTER_EVENTFD", 427},
+    {"io_uring_register$IORING_UNREGISTER_FILES", 427},
+    {"io_uring_setup", 425},
     {"ioctl", 54},
     {"ioctl$ASHMEM_GET_NAME", 54},
     {"ioctl$ASHMEM_GET_PIN_STATUS", 54},
@@ -3481,6 +3489,9 @@ const call_t syscalls[] = {
     {"syz_init_net_socket$llc", 0, (syscall_t)syz_init_net_socket},
     {"syz_init_net_socket$nfc_llcp", 0, (syscall_t)syz_init_net_socket},
     {"syz_init_net_socket$nfc_raw", 0, (syscall_t)syz_init_net_socket},
+    {"syz_io_uring_complete", 0, (syscall_t)syz_io_uring_complete},
+    {"syz_io_uring_setup", 0, (syscall_t)syz_io_uring_setup},
+    {"syz_io_uring_submit", 0, (syscall_t)syz_io_uring_submit},
     {"syz_kvm_setup_cpu$arm64", 0, (syscall_t)syz_kvm_setup_cpu},
     {"syz_kvm_setup_cpu$x86", 0, (syscall_t)syz_kvm_setup_cpu},
     {"syz_mount_image$bfs", 0, (syscall_t)syz_mount_image},
@@ -4143,6 +4154,14 @@ const call_t syscalls[] = {
     {"io_pgetevents", 333},
     {"io_setup", 206},
     {"io_submit", 209},
+    {"io_uring_enter", 426},
+    {"io_uring_register$IORING_REGISTER_BUFFERS", 427},
+    {"io_uring_register$IORING_REGISTER_EVENTFD", 427},
+    {"io_uring_register$IORING_REGISTER_FILES", 427},
+    {"io_uring_register$IORING_UNREGISTER_BUFFERS", 427},
+    {"io_uring_register$IORING_UNREGISTER_EVENTFD", 427},
+    {"io_uring_register$IORING_UNREGISTER_FILES", 427},
+    {"io_uring_setup", 425},
     {"ioctl", 16},
     {"ioctl$ASHMEM_GET_NAME", 16},
     {"ioctl$ASHMEM_GET_PIN_STATUS", 16},
@@ -5899,6 +5918,9 @@ const call_t syscalls[] = {
     {"syz_init_net_socket$llc", 0, (syscall_t)syz_init_net_socket},
     {"syz_init_net_socket$nfc_llcp", 0, (syscall_t)syz_init_net_socket},
     {"syz_init_net_socket$nfc_raw", 0, (syscall_t)syz_init_net_socket},
+    {"syz_io_uring_complete", 0, (syscall_t)syz_io_uring_complete},
+    {"syz_io_uring_setup", 0, (syscall_t)syz_io_uring_setup},
+    {"syz_io_uring_submit", 0, (syscall_t)syz_io_uring_submit},
     {"syz_kvm_setup_cpu$arm64", 0, (syscall_t)syz_kvm_setup_cpu},
     {"syz_kvm_setup_cpu$x86", 0, (syscall_t)syz_kvm_setup_cpu},
     {"syz_mount_image$bfs", 0, (syscall_t)syz_mount_image},
@@ -6549,6 +6571,14 @@ const call_t syscalls[] = {
     {"io_pgetevents", 399},
     {"io_setup", 243},
     {"io_submit", 246},
+    {"io_uring_enter", 426},
+    {"io_uring_register$IORING_REGISTER_BUFFERS", 427},
+    {"io_uring_register$IORING_REGISTER_EVENTFD", 427},
+    {"io_uring_register$IORING_REGISTER_FILES", 427},
+    {"io_uring_register$IORING_UNREGISTER_BUFFERS", 427},
+    {"io_uring_register$IORING_UNREGISTER_EVENTFD", 427},
+    {"io_uring_register$IORING_UNREGISTER_FILES", 427},
+    {"io_uring_setup", 425},
     {"ioctl", 54},
     {"ioctl$ASHMEM_GET_NAME", 54},
     {"ioctl$ASHMEM_GET_PIN_STATUS", 54},
@@ -8269,6 +8299,9 @@ const call_t syscalls[] = {
     {"syz_init_net_socket$llc", 0, (syscall_t)syz_init_net_socket},
     {"syz_init_net_socket$nfc_llcp", 0, (syscall_t)syz_init_net_socket},
     {"syz_init_net_socket$nfc_raw", 0, (syscall_t)syz_init_net_socket},
+    {"syz_io_uring_complete", 0, (syscall_t)syz_io_uring_complete},
+    {"syz_io_uring_setup", 0, (syscall_t)syz_io_uring_setup},
+    {"syz_io_uring_submit", 0, (syscall_t)syz_io_uring_submit},
     {"syz_kvm_setup_cpu$arm64", 0, (syscall_t)syz_kvm_setup_cpu},
     {"syz_kvm_setup_cpu$x86", 0, (syscall_t)syz_kvm_setup_cpu},
     {"syz_mount_image$bfs", 0, (syscall_t)syz_mount_image},
@@ -8908,6 +8941,14 @@ const call_t syscalls[] = {
     {"io_pgetevents", 292},
     {"io_setup", 0},
     {"io_submit", 2},
+    {"io_uring_enter", 426},
+    {"io_uring_register$IORING_REGISTER_BUFFERS", 427},
+    {"io_uring_register$IORING_REGISTER_EVENTFD", 427},
+    {"io_uring_register$IORING_REGISTER_FILES", 427},
+    {"io_uring_register$IORING_UNREGISTER_BUFFERS", 427},
+    {"io_uring_register$IORING_UNREGISTER_EVENTFD", 427},
+    {"io_uring_register$IORING_UNREGISTER_FILES", 427},
+    {"io_uring_setup", 425},
     {"ioctl", 29},
     {"ioctl$ASHMEM_GET_NAME", 29},
     {"ioctl$ASHMEM_GET_PIN_STATUS", 29},
@@ -10613,6 +10654,9 @@ const call_t syscalls[] = {
     {"syz_init_net_socket$llc", 0, (syscall_t)syz_init_net_socket},
     {"syz_init_net_socket$nfc_llcp", 0, (syscall_t)syz_init_net_socket},
     {"syz_init_net_socket$nfc_raw", 0, (syscall_t)syz_init_net_socket},
+    {"syz_io_uring_complete", 0, (syscall_t)syz_io_uring_complete},
+    {"syz_io_uring_setup", 0, (syscall_t)syz_io_uring_setup},
+    {"syz_io_uring_submit", 0, (syscall_t)syz_io_uring_submit},
     {"syz_kvm_setup_cpu$arm64", 0, (syscall_t)syz_kvm_setup_cpu},
     {"syz_kvm_setup_cpu$x86", 0, (syscall_t)syz_kvm_setup_cpu},
     {"syz_mount_image$bfs", 0, (syscall_t)syz_mount_image},
@@ -11260,6 +11304,14 @@ const call_t syscalls[] = {
     {"io_pgetevents", 388},
     {"io_setup", 227},
     {"io_submit", 230},
+    {"io_uring_enter", 426},
+    {"io_uring_register$IORING_REGISTER_BUFFERS", 427},
+    {"io_uring_register$IORING_REGISTER_EVENTFD", 427},
+    {"io_uring_register$IORING_REGISTER_FILES", 427},
+    {"io_uring_register$IORING_UNREGISTER_BUFFERS", 427},
+    {"io_uring_register$IORING_UNREGISTER_EVENTFD", 427},
+    {"io_uring_register$IORING_UNREGISTER_FILES", 427},
+    {"io_uring_setup", 425},
     {"ioctl", 54},
     {"ioctl$ASHMEM_GET_NAME", 54},
     {"ioctl$ASHMEM_GET_PIN_STATUS", 54},
@@ -12834,6 +12886,9 @@ const call_t syscalls[] = {
     {"syz_init_net_socket$llc", 0, (syscall_t)syz_init_net_socket},
     {"syz_init_net_socket$nfc_llcp", 0, (syscall_t)syz_init_net_socket},
     {"syz_init_net_socket$nfc_raw", 0, (syscall_t)syz_init_net_socket},
+    {"syz_io_uring_complete", 0, (syscall_t)syz_io_uring_complete},
+    {"syz_io_uring_setup", 0, (syscall_t)syz_io_uring_setup},
+    {"syz_io_uring_submit", 0, (syscall_t)syz_io_uring_submit},
     {"syz_kvm_setup_cpu$arm64", 0, (syscall_t)syz_kvm_setup_cpu},
     {"syz_kvm_setup_cpu$x86", 0, (syscall_t)syz_kvm_setup_cpu},
     {"syz_mount_image$bfs", 0, (syscall_t)syz_mount_image},
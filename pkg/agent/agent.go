@@ -0,0 +1,83 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// Package agent implements a simple protocol for controlling test machines
+// over a byte stream (e.g. a virtio-serial port) without ssh.
+// The guest side (Serve) is run by syz-agent inside of the test machine,
+// the host side (Client) is used by VM backends.
+//
+// The protocol is a sequence of frames, each frame is a fixed-size header
+// (data length, request id, message type) followed by data.
+// Client sends requests with unique ids, server replies to each request with
+// RespDone or RespError with the same id. Exec requests additionally produce
+// any number of RespOutput messages before the final reply.
+package agent
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+type Type uint8
+
+const (
+	ReqPing  Type = iota + 1 // no data
+	ReqExec                  // data: shell command
+	ReqPush                  // data: file name, zero byte, file contents
+	ReqPull                  // data: file name
+	ReqDmesg                 // no data
+	ReqKill                  // data: id of the exec request to kill
+
+	RespOutput // data: chunk of output of an exec request
+	RespDone   // data: result of the request (if any)
+	RespError  // data: error message
+)
+
+type Message struct {
+	Type Type
+	ID   uint32
+	Data []byte
+}
+
+const (
+	headerSize = 9
+	// MaxDataSize limits size of data in a single message.
+	MaxDataSize = 256 << 20
+)
+
+func WriteMessage(w io.Writer, msg *Message) error {
+	if len(msg.Data) > MaxDataSize {
+		return fmt.Errorf("message is too large: %v", len(msg.Data))
+	}
+	buf := make([]byte, headerSize+len(msg.Data))
+	binary.LittleEndian.PutUint32(buf[0:], uint32(len(msg.Data)))
+	binary.LittleEndian.PutUint32(buf[4:], msg.ID)
+	buf[8] = byte(msg.Type)
+	copy(buf[headerSize:], msg.Data)
+	_, err := w.Write(buf)
+	return err
+}
+
+func ReadMessage(r io.Reader) (*Message, error) {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(hdr[0:])
+	if size > MaxDataSize {
+		return nil, fmt.Errorf("message is too large: %v", size)
+	}
+	msg := &Message{
+		Type: Type(hdr[8]),
+		ID:   binary.LittleEndian.Uint32(hdr[4:]),
+		Data: make([]byte, size),
+	}
+	if _, err := io.ReadFull(r, msg.Data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg, nil
+}
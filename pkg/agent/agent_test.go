@@ -0,0 +1,117 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package agent
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFraming(t *testing.T) {
+	msgs := []*Message{
+		{Type: ReqPing, ID: 1, Data: []byte{}},
+		{Type: ReqExec, ID: 2, Data: []byte("echo foo")},
+		{Type: RespOutput, ID: 0xdeadbeef, Data: bytes.Repeat([]byte{0, 1, 2}, 1000)},
+	}
+	buf := new(bytes.Buffer)
+	for _, msg := range msgs {
+		if err := WriteMessage(buf, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, want := range msgs {
+		got, err := ReadMessage(buf)
+		if err != nil {
+			t.Fatalf("message #%v: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("message #%v: got %+v, want %+v", i, got, want)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("%v bytes left unread", buf.Len())
+	}
+	if err := WriteMessage(buf, msgs[1]); err != nil {
+		t.Fatal(err)
+	}
+	buf.Truncate(buf.Len() - 1)
+	if _, err := ReadMessage(buf); err == nil {
+		t.Fatalf("truncated message is parsed successfully")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("exec requires /bin/sh")
+	}
+	dir, err := ioutil.TempDir("", "syz-agent-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hostConn, guestConn := net.Pipe()
+	go Serve(guestConn)
+	c := NewClient(hostConn)
+	defer c.Close()
+
+	if err := c.Ping(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "file")
+	data := []byte("#!/bin/sh\necho foo\necho bar >&2\n")
+	if err := c.Push(file, data); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Pull(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("pulled wrong data: %q, want %q", got, data)
+	}
+	if _, err := c.Pull(filepath.Join(dir, "nonexistent")); err == nil {
+		t.Fatalf("pulled nonexistent file")
+	}
+
+	output := new(bytes.Buffer)
+	p, err := c.Exec("cd "+dir+" && ./file", output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if got := output.String(); !strings.Contains(got, "foo\n") || !strings.Contains(got, "bar\n") {
+		t.Fatalf("bad exec output: %q", got)
+	}
+	p, err = c.Exec("exit 3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Wait(); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Fatalf("bad exec error: %v", err)
+	}
+
+	p, err = c.Exec("sleep 1000", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Ping(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Wait(); err == nil {
+		t.Fatalf("killed process exited successfully")
+	}
+}
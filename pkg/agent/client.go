@@ -0,0 +1,200 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package agent
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Client is the host side of the agent protocol.
+// All methods can be called concurrently.
+type Client struct {
+	conn  io.ReadWriteCloser
+	wmu   sync.Mutex
+	mu    sync.Mutex
+	id    uint32
+	calls map[uint32]*call
+	err   error
+}
+
+type call struct {
+	output io.Writer
+	res    chan *Message
+}
+
+func NewClient(conn io.ReadWriteCloser) *Client {
+	c := &Client{
+		conn:  conn,
+		calls: make(map[uint32]*call),
+	}
+	go c.loop()
+	return c
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Ping checks that the agent is responsive.
+func (c *Client) Ping(timeout time.Duration) error {
+	id, cl, err := c.start(ReqPing, nil, nil)
+	if err != nil {
+		return err
+	}
+	select {
+	case msg := <-cl.res:
+		_, err := result(msg)
+		return err
+	case <-time.After(timeout):
+		c.mu.Lock()
+		delete(c.calls, id)
+		c.mu.Unlock()
+		return fmt.Errorf("agent did not respond in %v", timeout)
+	}
+}
+
+// Push writes data to the file in the test machine. The file is created executable.
+func (c *Client) Push(file string, data []byte) error {
+	req := append([]byte(file), 0)
+	_, err := c.call(ReqPush, append(req, data...))
+	return err
+}
+
+// Pull returns contents of the file in the test machine.
+func (c *Client) Pull(file string) ([]byte, error) {
+	return c.call(ReqPull, []byte(file))
+}
+
+// Dmesg returns contents of the kernel log buffer.
+func (c *Client) Dmesg() ([]byte, error) {
+	return c.call(ReqDmesg, nil)
+}
+
+// Process is a command started with Exec.
+type Process struct {
+	c    *Client
+	id   uint32
+	done chan bool
+	err  error
+}
+
+// Exec starts the shell command in the test machine.
+// Combined stdout/stderr of the command is written to output.
+func (c *Client) Exec(command string, output io.Writer) (*Process, error) {
+	id, cl, err := c.start(ReqExec, []byte(command), output)
+	if err != nil {
+		return nil, err
+	}
+	p := &Process{
+		c:    c,
+		id:   id,
+		done: make(chan bool),
+	}
+	go func() {
+		_, p.err = result(<-cl.res)
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// Wait waits for the command to exit and returns its exit error (if any).
+func (p *Process) Wait() error {
+	<-p.done
+	return p.err
+}
+
+func (p *Process) Kill() error {
+	select {
+	case <-p.done:
+		return nil
+	default:
+	}
+	var req [4]byte
+	binary.LittleEndian.PutUint32(req[:], p.id)
+	_, err := p.c.call(ReqKill, req[:])
+	return err
+}
+
+func (c *Client) call(typ Type, data []byte) ([]byte, error) {
+	_, cl, err := c.start(typ, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	return result(<-cl.res)
+}
+
+func (c *Client) start(typ Type, data []byte, output io.Writer) (uint32, *call, error) {
+	cl := &call{
+		output: output,
+		res:    make(chan *Message, 1),
+	}
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return 0, nil, err
+	}
+	c.id++
+	id := c.id
+	c.calls[id] = cl
+	c.mu.Unlock()
+	c.wmu.Lock()
+	err := WriteMessage(c.conn, &Message{Type: typ, ID: id, Data: data})
+	c.wmu.Unlock()
+	if err != nil {
+		c.mu.Lock()
+		delete(c.calls, id)
+		c.mu.Unlock()
+		return 0, nil, fmt.Errorf("failed to send agent request: %v", err)
+	}
+	return id, cl, nil
+}
+
+func (c *Client) loop() {
+	for {
+		msg, err := ReadMessage(c.conn)
+		if err != nil {
+			c.mu.Lock()
+			c.err = fmt.Errorf("lost connection to agent: %v", err)
+			for id, cl := range c.calls {
+				cl.res <- &Message{Type: RespError, ID: id, Data: []byte(c.err.Error())}
+			}
+			c.calls = nil
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Lock()
+		cl := c.calls[msg.ID]
+		if msg.Type != RespOutput {
+			delete(c.calls, msg.ID)
+		}
+		c.mu.Unlock()
+		if cl == nil {
+			continue // the caller is not interested anymore (e.g. timed out ping)
+		}
+		if msg.Type == RespOutput {
+			if cl.output != nil {
+				cl.output.Write(msg.Data)
+			}
+			continue
+		}
+		cl.res <- msg
+	}
+}
+
+func result(msg *Message) ([]byte, error) {
+	switch msg.Type {
+	case RespDone:
+		return msg.Data, nil
+	case RespError:
+		return nil, errors.New(string(msg.Data))
+	default:
+		return nil, fmt.Errorf("unexpected agent response type %v", msg.Type)
+	}
+}
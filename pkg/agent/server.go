@@ -0,0 +1,146 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package agent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/osutil"
+)
+
+type server struct {
+	conn  io.Writer
+	wmu   sync.Mutex
+	mu    sync.Mutex
+	procs map[uint32]*process
+}
+
+type process struct {
+	cmd    *exec.Cmd
+	output io.Closer
+}
+
+// Serve handles agent requests received over conn until conn is closed.
+func Serve(conn io.ReadWriter) error {
+	s := &server{
+		conn:  conn,
+		procs: make(map[uint32]*process),
+	}
+	for {
+		msg, err := ReadMessage(conn)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if msg.Type == ReqExec {
+			// Start the command synchronously, so that subsequent kill requests find it.
+			s.exec(msg)
+			continue
+		}
+		go s.handle(msg)
+	}
+}
+
+func (s *server) handle(msg *Message) {
+	var res []byte
+	var err error
+	switch msg.Type {
+	case ReqPing:
+	case ReqPush:
+		pos := bytes.IndexByte(msg.Data, 0)
+		if pos == -1 {
+			err = fmt.Errorf("bad push request")
+			break
+		}
+		err = osutil.WriteExecFile(string(msg.Data[:pos]), msg.Data[pos+1:])
+	case ReqPull:
+		res, err = ioutil.ReadFile(string(msg.Data))
+	case ReqDmesg:
+		res, err = osutil.RunCmd(time.Minute, "", "dmesg")
+	case ReqKill:
+		err = s.kill(msg.Data)
+	default:
+		err = fmt.Errorf("unknown request type %v", msg.Type)
+	}
+	s.reply(msg.ID, res, err)
+}
+
+func (s *server) exec(msg *Message) {
+	// Output goes through our own pipe rather than through exec.Cmd copying,
+	// because background children of a killed command can hold the pipe open indefinitely.
+	rpipe, wpipe, err := osutil.LongPipe()
+	if err != nil {
+		go s.reply(msg.ID, nil, err)
+		return
+	}
+	cmd := osutil.Command("/bin/sh", "-c", string(msg.Data))
+	cmd.Stdout = wpipe
+	cmd.Stderr = wpipe
+	if err := cmd.Start(); err != nil {
+		rpipe.Close()
+		wpipe.Close()
+		go s.reply(msg.ID, nil, err)
+		return
+	}
+	wpipe.Close()
+	s.mu.Lock()
+	s.procs[msg.ID] = &process{cmd, rpipe}
+	s.mu.Unlock()
+	go func() {
+		io.Copy(&outputWriter{s, msg.ID}, rpipe)
+		rpipe.Close()
+		err := cmd.Wait()
+		s.mu.Lock()
+		delete(s.procs, msg.ID)
+		s.mu.Unlock()
+		s.reply(msg.ID, nil, err)
+	}()
+}
+
+func (s *server) kill(data []byte) error {
+	if len(data) != 4 {
+		return fmt.Errorf("bad kill request")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if proc := s.procs[binary.LittleEndian.Uint32(data)]; proc != nil {
+		proc.cmd.Process.Kill()
+		proc.output.Close()
+	}
+	return nil
+}
+
+func (s *server) reply(id uint32, res []byte, err error) {
+	msg := &Message{Type: RespDone, ID: id, Data: res}
+	if err != nil {
+		msg.Type = RespError
+		msg.Data = []byte(err.Error())
+	}
+	s.send(msg)
+}
+
+func (s *server) send(msg *Message) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	WriteMessage(s.conn, msg)
+}
+
+type outputWriter struct {
+	s  *server
+	id uint32
+}
+
+func (w *outputWriter) Write(data []byte) (int, error) {
+	w.s.send(&Message{Type: RespOutput, ID: w.id, Data: append([]byte{}, data...)})
+	return len(data), nil
+}
@@ -25,6 +25,7 @@ type Config struct {
 	Syzkaller SyzkallerConfig
 	Repro     ReproConfig
 	Manager   mgrconfig.Config
+	Minimize  MinimizeParams // used only by MinimizeConfig
 }
 
 type KernelConfig struct {
@@ -214,6 +215,10 @@ func (env *env) commitRangeForBug() (*vcs.Commit, string, string, error) {
 }
 
 func (env *env) test() (vcs.BisectResult, error) {
+	return env.testConfig(env.cfg.Kernel.Config)
+}
+
+func (env *env) testConfig(kernelConfig []byte) (vcs.BisectResult, error) {
 	cfg := env.cfg
 	env.numTests++
 	current, err := env.repo.HeadCommit()
@@ -235,7 +240,7 @@ func (env *env) test() (vcs.BisectResult, error) {
 		return 0, fmt.Errorf("kernel clean failed: %v", err)
 	}
 	err = env.inst.BuildKernel(be.compiler, cfg.Kernel.Userspace,
-		cfg.Kernel.Cmdline, cfg.Kernel.Sysctl, cfg.Kernel.Config)
+		cfg.Kernel.Cmdline, cfg.Kernel.Sysctl, kernelConfig)
 	env.buildTime += time.Since(buildStart)
 	if err != nil {
 		if verr, ok := err.(*osutil.VerboseError); ok {
@@ -0,0 +1,241 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package bisect
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/syzkaller/pkg/build"
+	"github.com/google/syzkaller/pkg/instance"
+	"github.com/google/syzkaller/pkg/vcs"
+)
+
+// Config minimization answers "does the crash reproduce with a smaller (defconfig-ish) kernel config":
+// candidate groups of debug/extra configs are disabled (first all at once, then recursively
+// halves of the groups that don't disable cleanly), the kernel is rebuilt and the reproducer
+// is re-run after each step. The result is the config with as many groups disabled as possible.
+
+type MinimizeParams struct {
+	Groups      []*ConfigGroup // candidate groups to disable
+	MaxRebuilds int            // max number of kernel builds including the original config, 0 means no limit
+	TimeBudget  time.Duration  // no new builds are started after that, 0 means no limit
+}
+
+// ConfigGroup is a group of kernel configs that are disabled together.
+type ConfigGroup struct {
+	Name    string
+	Configs []string // config names, e.g. CONFIG_KASAN
+}
+
+type ConfigResult struct {
+	Config   []byte         // the original config with the Disabled groups disabled
+	Disabled []*ConfigGroup // groups that can be disabled with the crash still reproducing
+	Required []*ConfigGroup // groups that are required for the crash or weren't tested (if !Complete)
+	Complete bool           // all groups were tested before the limits were reached
+}
+
+var configNameRe = regexp.MustCompile(`\bCONFIG_[A-Za-z0-9_]+`)
+
+// ParseConfigGroup parses a config fragment (e.g. "CONFIG_KASAN=y" lines) into a group.
+func ParseConfigGroup(name string, data []byte) (*ConfigGroup, error) {
+	group := &ConfigGroup{Name: name}
+	dedup := make(map[string]bool)
+	for _, cfg := range configNameRe.FindAllString(string(data), -1) {
+		if !dedup[cfg] {
+			dedup[cfg] = true
+			group.Configs = append(group.Configs, cfg)
+		}
+	}
+	if len(group.Configs) == 0 {
+		return nil, fmt.Errorf("config group %v does not contain any configs", name)
+	}
+	return group, nil
+}
+
+// MinimizeConfig finds the smallest config (in terms of cfg.Minimize.Groups) that still reproduces the crash.
+func MinimizeConfig(cfg *Config) (*ConfigResult, error) {
+	if err := checkConfig(cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Minimize.Groups) == 0 {
+		return nil, fmt.Errorf("no config groups to minimize")
+	}
+	repo, err := vcs.NewRepo(cfg.Manager.TargetOS, cfg.Manager.Type, cfg.Manager.KernelSrc)
+	if err != nil {
+		return nil, err
+	}
+	env := &env{
+		cfg:  cfg,
+		repo: repo,
+	}
+	env.log("minimizing kernel config on %v with %v candidate groups", cfg.Kernel.Commit, len(cfg.Minimize.Groups))
+	start := time.Now()
+	res, err := env.minimizeConfig(start)
+	env.log("configs tested: %v, total time: %v (build: %v, test: %v)",
+		env.numTests, time.Since(start), env.buildTime, env.testTime)
+	if err != nil {
+		env.log("error: %v", err)
+		return nil, err
+	}
+	if !res.Complete {
+		env.log("limits are reached, not all groups were tested")
+	}
+	env.log("disabled groups: %v", groupNames(res.Disabled))
+	env.log("required groups: %v", groupNames(res.Required))
+	return res, nil
+}
+
+func (env *env) minimizeConfig(start time.Time) (*ConfigResult, error) {
+	cfg := env.cfg
+	var err error
+	if env.inst, err = instance.NewEnv(&cfg.Manager); err != nil {
+		return nil, err
+	}
+	if err := build.Clean(cfg.Manager.TargetOS, cfg.Manager.TargetVMArch,
+		cfg.Manager.Type, cfg.Manager.KernelSrc); err != nil {
+		return nil, fmt.Errorf("kernel clean failed: %v", err)
+	}
+	env.log("building syzkaller on %v", cfg.Syzkaller.Commit)
+	if err := env.inst.BuildSyzkaller(cfg.Syzkaller.Repo, cfg.Syzkaller.Commit); err != nil {
+		return nil, err
+	}
+	if _, err := env.repo.SwitchCommit(cfg.Kernel.Commit); err != nil {
+		return nil, err
+	}
+	env.log("testing the original config")
+	if res, err := env.testConfig(cfg.Kernel.Config); err != nil {
+		return nil, err
+	} else if res != vcs.BisectBad {
+		return nil, fmt.Errorf("the crash wasn't reproduced with the original config")
+	}
+	stop := func() bool {
+		limits := cfg.Minimize
+		return limits.MaxRebuilds != 0 && env.numTests >= limits.MaxRebuilds ||
+			limits.TimeBudget != 0 && time.Since(start) >= limits.TimeBudget
+	}
+	disabled, complete, err := minimizeGroups(cfg.Minimize.Groups, stop, func(groups []*ConfigGroup) (bool, error) {
+		env.log("testing with disabled groups: %v", groupNames(groups))
+		res, err := env.testConfig(disableGroups(cfg.Kernel.Config, groups))
+		return res == vcs.BisectBad, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := &ConfigResult{
+		Config:   disableGroups(cfg.Kernel.Config, disabled),
+		Disabled: disabled,
+		Complete: complete,
+	}
+	for _, group := range cfg.Minimize.Groups {
+		if !containsGroup(disabled, group) {
+			res.Required = append(res.Required, group)
+		}
+	}
+	return res, nil
+}
+
+// minimizeGroups returns groups that can be disabled together with pred still returning true.
+// It first tries to disable all groups at once, and if that fails recursively splits the groups in halves.
+// Once stop returns true, the remaining groups are not tested and complete is false.
+func minimizeGroups(groups []*ConfigGroup, stop func() bool,
+	pred func(disabled []*ConfigGroup) (bool, error)) (disabled []*ConfigGroup, complete bool, err error) {
+	complete = true
+	var minimize func(candidates []*ConfigGroup) error
+	minimize = func(candidates []*ConfigGroup) error {
+		if len(candidates) == 0 {
+			return nil
+		}
+		if stop() {
+			complete = false
+			return nil
+		}
+		try := append(append([]*ConfigGroup{}, disabled...), candidates...)
+		ok, err := pred(try)
+		if err != nil {
+			return err
+		}
+		if ok {
+			disabled = try
+			return nil
+		}
+		if len(candidates) == 1 {
+			return nil
+		}
+		half := len(candidates) / 2
+		if err := minimize(candidates[:half]); err != nil {
+			return err
+		}
+		return minimize(candidates[half:])
+	}
+	if err := minimize(groups); err != nil {
+		return nil, false, err
+	}
+	return disabled, complete, nil
+}
+
+// disableGroups returns kernelConfig with all configs of the groups disabled.
+// Configs absent from kernelConfig are explicitly disabled as well, otherwise oldconfig may enable them.
+func disableGroups(kernelConfig []byte, groups []*ConfigGroup) []byte {
+	disable := make(map[string]bool)
+	for _, group := range groups {
+		for _, cfg := range group.Configs {
+			disable[cfg] = true
+		}
+	}
+	buf := new(bytes.Buffer)
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSuffix(string(kernelConfig), "\n"), "\n") {
+		if cfg := configLineName(line); disable[cfg] {
+			if !seen[cfg] {
+				fmt.Fprintf(buf, "# %v is not set\n", cfg)
+			}
+			seen[cfg] = true
+			continue
+		}
+		fmt.Fprintf(buf, "%v\n", line)
+	}
+	for _, group := range groups {
+		for _, cfg := range group.Configs {
+			if !seen[cfg] {
+				fmt.Fprintf(buf, "# %v is not set\n", cfg)
+				seen[cfg] = true
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// configLineName returns name of the config set/unset on the line, or "" for other lines.
+func configLineName(line string) string {
+	if strings.HasPrefix(line, "CONFIG_") {
+		if pos := strings.IndexByte(line, '='); pos != -1 {
+			return line[:pos]
+		}
+	}
+	if strings.HasPrefix(line, "# CONFIG_") && strings.HasSuffix(line, " is not set") {
+		return strings.TrimSuffix(strings.TrimPrefix(line, "# "), " is not set")
+	}
+	return ""
+}
+
+func containsGroup(groups []*ConfigGroup, group *ConfigGroup) bool {
+	for _, g := range groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+func groupNames(groups []*ConfigGroup) []string {
+	var names []string
+	for _, group := range groups {
+		names = append(names, group.Name)
+	}
+	return names
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package bisect
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigGroup(t *testing.T) {
+	group, err := ParseConfigGroup("kasan", []byte(`
+# KASAN configs.
+CONFIG_KASAN=y
+CONFIG_KASAN_INLINE=y
+# CONFIG_KASAN_OUTLINE is not set
+CONFIG_KASAN=y
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"CONFIG_KASAN", "CONFIG_KASAN_INLINE", "CONFIG_KASAN_OUTLINE"}
+	if fmt.Sprint(group.Configs) != fmt.Sprint(want) {
+		t.Fatalf("got configs %v, want %v", group.Configs, want)
+	}
+	if _, err := ParseConfigGroup("empty", []byte("# nothing\n")); err == nil {
+		t.Fatalf("no error for an empty group")
+	}
+}
+
+func TestDisableGroups(t *testing.T) {
+	config := []byte(`CONFIG_A=y
+CONFIG_B=m
+# CONFIG_C is not set
+CONFIG_AB=y
+CONFIG_D="foo"
+`)
+	groups := []*ConfigGroup{
+		{Name: "x", Configs: []string{"CONFIG_A", "CONFIG_C"}},
+		{Name: "y", Configs: []string{"CONFIG_D", "CONFIG_E"}},
+	}
+	want := `# CONFIG_A is not set
+CONFIG_B=m
+# CONFIG_C is not set
+CONFIG_AB=y
+# CONFIG_D is not set
+# CONFIG_E is not set
+`
+	if got := string(disableGroups(config, groups)); got != want {
+		t.Fatalf("got config:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMinimizeGroups(t *testing.T) {
+	var groups []*ConfigGroup
+	for i := 0; i < 8; i++ {
+		groups = append(groups, &ConfigGroup{Name: fmt.Sprint(i)})
+	}
+	tests := []struct {
+		required    string // groups required for the crash
+		maxTests    int
+		wantTests   int
+		wantDisable string
+		complete    bool
+	}{
+		{"", 0, 1, "[0 1 2 3 4 5 6 7]", true},
+		{"3", 0, 7, "[0 1 2 4 5 6 7]", true},
+		{"0 7", 0, 11, "[1 2 3 4 5 6]", true},
+		{"3", 3, 3, "[0 1]", false},
+	}
+	for i, test := range tests {
+		required := make(map[string]bool)
+		for _, name := range strings.Fields(test.required) {
+			required[name] = true
+		}
+		numTests := 0
+		disabled, complete, err := minimizeGroups(groups, func() bool {
+			return test.maxTests != 0 && numTests >= test.maxTests
+		}, func(disabled []*ConfigGroup) (bool, error) {
+			numTests++
+			for _, group := range disabled {
+				if required[group.Name] {
+					return false, nil
+				}
+			}
+			return true, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(groupNames(disabled)); got != test.wantDisable ||
+			complete != test.complete || numTests != test.wantTests {
+			t.Errorf("#%v: disabled %v, complete %v, tests %v; want %v, %v, %v",
+				i, got, complete, numTests, test.wantDisable, test.complete, test.wantTests)
+		}
+	}
+}
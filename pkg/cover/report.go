@@ -14,6 +14,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/google/syzkaller/pkg/hash"
 	"github.com/google/syzkaller/pkg/osutil"
@@ -26,6 +27,16 @@ type ReportGenerator struct {
 	arch     string
 	symbols  []symbol
 	coverPCs []uint64
+
+	locMu     sync.Mutex
+	locations map[uint64]Location // symbolization cache for Locations
+}
+
+// Location is a source location of a coverage PC.
+type Location struct {
+	Func string
+	File string // relative to the kernel source dir if the file is inside of it
+	Line int
 }
 
 type symbol struct {
@@ -76,6 +87,64 @@ func (rg *ReportGenerator) Do(w io.Writer, pcs []uint64) error {
 	return rg.generate(w, prefix, covered, uncovered)
 }
 
+// Locations returns source locations of coverage pcs (as returned by the kernel).
+// PCs that can't be symbolized are not present in the result.
+// Locations are cached, so repeated queries for the same PCs don't run the symbolizer again.
+func (rg *ReportGenerator) Locations(pcs []uint64) (map[uint64]Location, error) {
+	rg.locMu.Lock()
+	defer rg.locMu.Unlock()
+	if rg.locations == nil {
+		rg.locations = make(map[uint64]Location)
+	}
+	res := make(map[uint64]Location)
+	prevPCs := make(map[uint64]uint64)
+	var missing []uint64
+	for _, pc := range pcs {
+		if loc, ok := rg.locations[pc]; ok {
+			if loc.File != "" {
+				res[pc] = loc
+			}
+			continue
+		}
+		prev := PreviousInstructionPC(rg.arch, pc)
+		if _, ok := prevPCs[prev]; !ok {
+			prevPCs[prev] = pc
+			missing = append(missing, prev)
+		}
+	}
+	if len(missing) == 0 {
+		return res, nil
+	}
+	symb := symbolizer.NewSymbolizer()
+	defer symb.Close()
+	frames, err := symb.SymbolizeArray(rg.vmlinux, missing)
+	if err != nil {
+		return nil, err
+	}
+	srcDir := filepath.Clean(rg.srcDir) + string(filepath.Separator)
+	for _, frame := range frames {
+		pc := prevPCs[frame.PC]
+		if _, ok := rg.locations[pc]; ok {
+			// Only the innermost inlined frame is used.
+			continue
+		}
+		loc := Location{
+			Func: frame.Func,
+			File: strings.TrimPrefix(frame.File, srcDir),
+			Line: frame.Line,
+		}
+		rg.locations[pc] = loc
+		res[pc] = loc
+	}
+	for _, prev := range missing {
+		if pc := prevPCs[prev]; rg.locations[pc] == (Location{}) {
+			// Remember PCs without debug info too.
+			rg.locations[pc] = Location{}
+		}
+	}
+	return res, nil
+}
+
 func (rg *ReportGenerator) generate(w io.Writer, prefix string, covered, uncovered []symbolizer.Frame) error {
 	var d templateData
 	for f, covered := range fileSet(covered, uncovered) {
@@ -0,0 +1,577 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// Package crashdir implements the on-disk layout of saved crashes (workdir/crashes).
+// Each crash type (title) has own directory named after hash of the title that contains:
+//
+//	description        - crash title
+//	log{N}             - console output of N-th saved occurrence
+//	report{N}          - parsed report of N-th occurrence (if any)
+//	tag{N}             - kernel build tag of N-th occurrence (if any)
+//	origin{N}          - origin of N-th occurrence (e.g. "external", if any)
+//	meta{N}.json       - structured metadata of N-th occurrence (see Meta)
+//	recording{N}       - recorded VM execution of N-th occurrence for deterministic replay (if any)
+//	builds.json        - first/last seen time and number of occurrences per kernel build (see Build),
+//	                     it is not rotated with logs, so it covers all occurrences ever saved
+//	repro.{prog,cprog,log,report,tag,stats} - successful reproducer
+//	repro.descriptions - revision of syscall descriptions the reproducer was last checked against
+//	repro.stale        - why the reproducer needs re-verification (if it does)
+//	repro{N}           - stats of N-th failed reproduction attempt
+//
+// All readers and writers of crash directories should use this package.
+package crashdir
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/hash"
+	"github.com/google/syzkaller/pkg/osutil"
+)
+
+const (
+	// MaxCrashes is the number of occurrences saved per crash type.
+	// If we already have that many, the oldest one is overwritten.
+	MaxCrashes = 100
+	// MaxReproAttempts is the number of failed reproduction attempts after which
+	// the crash is considered non-reproducible.
+	MaxReproAttempts = 3
+)
+
+// Meta is structured metadata of a single crash occurrence.
+type Meta struct {
+	Title string    `json:"title"`
+	Time  time.Time `json:"time"` // host time of the crash
+	// Guest uptime at the time of the crash in seconds (0 if unknown).
+	GuestUptime float64 `json:"guest_uptime,omitempty"`
+	VMIndex     int     `json:"vm_index"` // -1 if the crash did not come from one of our VMs
+	BuildID     string  `json:"build_id,omitempty"`
+	Revision    string  `json:"syzkaller_revision,omitempty"`
+	// Revision of syscall descriptions (prog.Target.Revision).
+	Descriptions string `json:"descriptions_revision,omitempty"`
+	// Hashes of programs that were executing at the time of the crash (last program of each proc).
+	Programs        []string `json:"programs,omitempty"`
+	HasRepro        bool     `json:"has_repro"`
+	HasCRepro       bool     `json:"has_c_repro"`
+	Corrupted       bool     `json:"corrupted"`
+	CorruptedReason string   `json:"corrupted_reason,omitempty"`
+	// Console output was likely lossy, consider using virtio console.
+	IncompleteReason string `json:"incomplete_reason,omitempty"`
+	// Reporter that detected the crash (set only if secondary_reporter is configured).
+	ReportOrigin string   `json:"report_origin,omitempty"`
+	GuiltyFile   string   `json:"guilty_file,omitempty"`
+	Maintainers  []string `json:"maintainers,omitempty"`
+	// Additional information about the VM (see vmimpl.Infoer).
+	Info string `json:"info,omitempty"`
+	// Command that replays the saved recording of the VM execution (see vmimpl.Recorder).
+	ReplayCommand string `json:"replay_command,omitempty"`
+	// PRNG seed of the fuzzer that was running in the VM (set only if seed is configured).
+	Seed int64 `json:"seed,omitempty"`
+	// Tag of the kernel the VM was running if the VM pool runs several kernels (see vmimpl.KernelTagger).
+	KernelTag string `json:"kernel_tag,omitempty"`
+	// Reproducer of a sibling manager that caused the crash ("<manager>/<title>", see sibling_managers).
+	SeededFrom string `json:"seeded_from,omitempty"`
+	// Number of fuzzer procs in the VM (differs between VMs with adaptive_procs).
+	Procs int `json:"procs,omitempty"`
+	// Number of repeats of the warning that were not reported separately (see warnings config).
+	Repeats int `json:"repeats,omitempty"`
+	// Number of crashes the occurrence stands for if it's a batch that failed to upload to dashboard
+	// (see dashboard_batch_period), 0 means 1.
+	Occurrences int `json:"occurrences,omitempty"`
+	// Guest memory state at the time of the crash (see crash_mem_state config).
+	MemState string `json:"mem_state,omitempty"`
+	// Priority of the crash assigned by its title (see severities config).
+	Severity string `json:"severity,omitempty"`
+	// Class of the report, empty for crashes (see report.ClassSecurityEvent).
+	Class string `json:"class,omitempty"`
+	// Whether the kernel kept executing programs after the oops (see liveness_check config).
+	Liveness string `json:"liveness,omitempty"`
+	// Kernel command line the VM was booted with (see crash_cmdline config).
+	Cmdline string `json:"cmdline,omitempty"`
+	// Stack frames of the symbolized report with source locations (see report.ParseFrames).
+	Frames []Frame `json:"frames,omitempty"`
+}
+
+// Frame is a stack frame of the report of a crash occurrence.
+type Frame struct {
+	Func   string `json:"func"`
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Inline bool   `json:"inline,omitempty"`
+	// Index of the line of the report{N} file the frame was parsed from.
+	ReportLine int `json:"report_line"`
+}
+
+// Occurrence is a single crash occurrence to be saved with SaveCrash.
+type Occurrence struct {
+	Log    []byte
+	Report []byte
+	Tag    string
+	Origin string
+	Meta   *Meta
+	// Diagnosis is debugging output the VM produced after the crash (see vmimpl.Instance.Diagnose).
+	Diagnosis []byte
+	// TimedConsole is console output with timing (see timed_console_log).
+	TimedConsole []byte
+	// Ftrace is the kernel ftrace buffer at the time of the crash (see crash_ftrace).
+	Ftrace []byte
+	// Recording is a file with recorded VM execution, it is moved into the crash directory.
+	// References to the file in Meta.ReplayCommand are updated accordingly.
+	Recording string
+}
+
+// Repro is a successful reproducer to be saved with SaveRepro or read with ReadRepro.
+type Repro struct {
+	Prog   []byte
+	CProg  []byte
+	Log    []byte
+	Report []byte
+	Tag    string
+	Stats  []byte
+	// Revision of syscall descriptions the reproducer was created with (or last checked against).
+	Descriptions string
+	// Why the reproducer needs re-verification, e.g. it changed meaning with new descriptions
+	// (set by ReadRepro only, see MarkRepro).
+	Stale string
+}
+
+// Build is statistics of occurrences of a crash type on a single kernel build.
+type Build struct {
+	ID        string    `json:"id"` // build tag, with the kernel tag if the VM pool runs several kernels
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Count     int       `json:"count"`
+}
+
+// Type describes a crash type directory.
+type Type struct {
+	ID            string
+	Title         string
+	LastTime      time.Time // modification time of the description file
+	HasRepro      bool
+	HasCRepro     bool
+	ReproStale    bool // the reproducer needs re-verification
+	ReproAttempts int
+	Crashes       []*Crash
+	Builds        []*Build // in the order of FirstSeen (filled only by Read)
+}
+
+// Crash describes a single saved occurrence.
+// File names are relative to the crash type directory.
+type Crash struct {
+	Index     int
+	Time      time.Time
+	Log       string
+	Report    string // empty if there is no report
+	Tag       string
+	Origin    string
+	Recording string // empty if there is no recording
+	Diagnosis string // empty if there is no Diagnose output
+	Meta      *Meta  // nil for occurrences saved without metadata
+	// Console output with timing, empty if it was not recorded (see timed_console_log).
+	TimedConsole string
+	Ftrace       string // empty if the ftrace buffer was not collected (see crash_ftrace)
+}
+
+// ID returns name of the directory for crashes with the given title.
+func ID(title string) string {
+	return hash.String([]byte(title))
+}
+
+func logFile(index int) string       { return fmt.Sprintf("log%v", index) }
+func reportFile(index int) string    { return fmt.Sprintf("report%v", index) }
+func tagFile(index int) string       { return fmt.Sprintf("tag%v", index) }
+func originFile(index int) string    { return fmt.Sprintf("origin%v", index) }
+func metaFile(index int) string      { return fmt.Sprintf("meta%v.json", index) }
+func recordingFile(index int) string { return fmt.Sprintf("recording%v", index) }
+func diagnosisFile(index int) string { return fmt.Sprintf("diagnosis%v", index) }
+func timedFile(index int) string     { return fmt.Sprintf("console%v.timed", index) }
+func ftraceFile(index int) string    { return fmt.Sprintf("ftrace%v", index) }
+
+// SaveCrash saves the occurrence of a crash with the given title in crashdir.
+// Returns index of the occurrence and whether it is the first occurrence of the crash.
+// The meta repro availability fields are filled in by SaveCrash.
+func SaveCrash(crashdir, title string, occ *Occurrence) (int, bool, error) {
+	dir, err := writeDescription(crashdir, title)
+	if err != nil {
+		return 0, false, err
+	}
+	// Newer reports are generally more useful. Overwriting is also needed
+	// to be able to understand if a particular bug still happens or already fixed.
+	index, first := 0, false
+	var oldestTime time.Time
+	for i := 0; i < MaxCrashes; i++ {
+		info, err := os.Stat(filepath.Join(dir, logFile(i)))
+		if err != nil {
+			index, first = i, i == 0
+			break
+		}
+		if oldestTime.IsZero() || info.ModTime().Before(oldestTime) {
+			index = i
+			oldestTime = info.ModTime()
+		}
+	}
+	if err := osutil.WriteFile(filepath.Join(dir, logFile(index)), occ.Log); err != nil {
+		return 0, false, fmt.Errorf("failed to write crash log: %v", err)
+	}
+	writeOptional(filepath.Join(dir, tagFile(index)), []byte(occ.Tag))
+	writeOptional(filepath.Join(dir, reportFile(index)), occ.Report)
+	writeOptional(filepath.Join(dir, originFile(index)), []byte(occ.Origin))
+	writeOptional(filepath.Join(dir, diagnosisFile(index)), occ.Diagnosis)
+	writeOptional(filepath.Join(dir, timedFile(index)), occ.TimedConsole)
+	writeOptional(filepath.Join(dir, ftraceFile(index)), occ.Ftrace)
+	recordingName := filepath.Join(dir, recordingFile(index))
+	os.Remove(recordingName)
+	if occ.Recording != "" {
+		if err := osutil.Rename(occ.Recording, recordingName); err != nil {
+			return 0, false, fmt.Errorf("failed to save recording: %v", err)
+		}
+		if occ.Meta != nil {
+			occ.Meta.ReplayCommand = strings.Replace(occ.Meta.ReplayCommand,
+				occ.Recording, recordingName, -1)
+		}
+	}
+	metaName := filepath.Join(dir, metaFile(index))
+	os.Remove(metaName)
+	if occ.Meta != nil {
+		occ.Meta.HasRepro = osutil.IsExist(filepath.Join(dir, "repro.prog"))
+		occ.Meta.HasCRepro = osutil.IsExist(filepath.Join(dir, "repro.cprog"))
+		data, err := json.MarshalIndent(occ.Meta, "", "\t")
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to marshal crash meta: %v", err)
+		}
+		if err := osutil.WriteFile(metaName, data); err != nil {
+			return 0, false, fmt.Errorf("failed to write crash meta: %v", err)
+		}
+	}
+	return index, first, nil
+}
+
+// buildsMu protects read-modify-write of builds files.
+var buildsMu sync.Mutex
+
+// RecordBuild records an occurrence of the crash with the given title on the kernel build
+// at the given time. Returns statistics for all builds the crash was seen on (see ReadBuilds).
+func RecordBuild(crashdir, title, build string, when time.Time) ([]*Build, error) {
+	buildsMu.Lock()
+	defer buildsMu.Unlock()
+	dir, err := writeDescription(crashdir, title)
+	if err != nil {
+		return nil, err
+	}
+	builds := readBuilds(dir)
+	var b *Build
+	for _, b1 := range builds {
+		if b1.ID == build {
+			b = b1
+			break
+		}
+	}
+	if b == nil {
+		b = &Build{ID: build, FirstSeen: when}
+		builds = append(builds, b)
+	}
+	if when.Before(b.FirstSeen) {
+		b.FirstSeen = when
+	}
+	if when.After(b.LastSeen) {
+		b.LastSeen = when
+	}
+	b.Count++
+	sortBuilds(builds)
+	data, err := json.MarshalIndent(builds, "", "\t")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal crash builds: %v", err)
+	}
+	if err := osutil.WriteFile(filepath.Join(dir, "builds.json"), data); err != nil {
+		return nil, fmt.Errorf("failed to write crash builds: %v", err)
+	}
+	return builds, nil
+}
+
+// ReadBuilds returns statistics of the crash type with the given id per kernel build
+// in the order the builds were first seen. Returns nil if no builds were recorded.
+func ReadBuilds(crashdir, id string) []*Build {
+	buildsMu.Lock()
+	defer buildsMu.Unlock()
+	return readBuilds(filepath.Join(crashdir, id))
+}
+
+func readBuilds(dir string) []*Build {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "builds.json"))
+	if err != nil {
+		return nil
+	}
+	var builds []*Build
+	if err := json.Unmarshal(data, &builds); err != nil {
+		return nil
+	}
+	sortBuilds(builds)
+	return builds
+}
+
+func sortBuilds(builds []*Build) {
+	sort.SliceStable(builds, func(i, j int) bool {
+		return builds[i].FirstSeen.Before(builds[j].FirstSeen)
+	})
+}
+
+// SaveRepro saves a successful reproducer for the crash with the given title in crashdir.
+func SaveRepro(crashdir, title string, repro *Repro) error {
+	dir, err := writeDescription(crashdir, title)
+	if err != nil {
+		return err
+	}
+	if err := osutil.WriteFile(filepath.Join(dir, "repro.prog"), repro.Prog); err != nil {
+		return fmt.Errorf("failed to write repro: %v", err)
+	}
+	writeOptional(filepath.Join(dir, "repro.tag"), []byte(repro.Tag))
+	writeOptional(filepath.Join(dir, "repro.log"), repro.Log)
+	writeOptional(filepath.Join(dir, "repro.report"), repro.Report)
+	writeOptional(filepath.Join(dir, "repro.cprog"), repro.CProg)
+	writeOptional(filepath.Join(dir, "repro.descriptions"), []byte(repro.Descriptions))
+	writeOptional(filepath.Join(dir, "repro.stale"), nil)
+	return osutil.WriteFile(filepath.Join(dir, "repro.stats"), repro.Stats)
+}
+
+// MarkRepro records that the reproducer for the crash type with the given id was checked
+// against the given revision of syscall descriptions. If stale is not empty, the reproducer
+// is marked as needing re-verification for that reason (until a new reproducer is saved).
+func MarkRepro(crashdir, id, descriptions, stale string) error {
+	dir := filepath.Join(crashdir, id)
+	if !osutil.IsExist(filepath.Join(dir, "repro.prog")) {
+		return fmt.Errorf("crash %v has no reproducer", id)
+	}
+	if stale != "" {
+		if err := osutil.WriteFile(filepath.Join(dir, "repro.stale"), []byte(stale)); err != nil {
+			return err
+		}
+	}
+	return osutil.WriteFile(filepath.Join(dir, "repro.descriptions"), []byte(descriptions))
+}
+
+// SaveFailedRepro records a failed reproduction attempt for the crash with the given title.
+func SaveFailedRepro(crashdir, title string, stats []byte) error {
+	dir := filepath.Join(crashdir, ID(title))
+	if err := osutil.MkdirAll(dir); err != nil {
+		return err
+	}
+	for i := 0; i < MaxReproAttempts; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("repro%v", i))
+		if !osutil.IsExist(name) {
+			return osutil.WriteFile(name, stats)
+		}
+	}
+	return nil
+}
+
+// NeedRepro says if the crash with the given title has neither a reproducer
+// nor MaxReproAttempts failed reproduction attempts.
+func NeedRepro(crashdir, title string) bool {
+	dir := filepath.Join(crashdir, ID(title))
+	if osutil.IsExist(filepath.Join(dir, "repro.prog")) {
+		return false
+	}
+	for i := 0; i < MaxReproAttempts; i++ {
+		if !osutil.IsExist(filepath.Join(dir, fmt.Sprintf("repro%v", i))) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasTag says if an occurrence of the crash with the given title was saved with the tag
+// (an empty tag matches occurrences saved without a tag).
+func HasTag(crashdir, title, tag string) bool {
+	dir := filepath.Join(crashdir, ID(title))
+	for i := 0; i < MaxCrashes; i++ {
+		if !osutil.IsExist(filepath.Join(dir, logFile(i))) {
+			continue
+		}
+		data, _ := ioutil.ReadFile(filepath.Join(dir, tagFile(i)))
+		if string(data) == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns all crash types in crashdir.
+// Crashes of the returned types contain only indexes.
+func List(crashdir string) ([]*Type, error) {
+	dirs, err := osutil.ListDir(crashdir)
+	if err != nil {
+		return nil, err
+	}
+	var types []*Type
+	for _, id := range dirs {
+		if typ, err := readType(crashdir, id, false); err == nil {
+			types = append(types, typ)
+		}
+	}
+	return types, nil
+}
+
+// Read returns the crash type with the given id with full information
+// about all occurrences, the most recent occurrence first.
+func Read(crashdir, id string) (*Type, error) {
+	return readType(crashdir, id, true)
+}
+
+// ReadRepro returns reproducer for the crash type with the given id.
+// Returns nil if there is no reproducer.
+func ReadRepro(crashdir, id string) *Repro {
+	dir := filepath.Join(crashdir, id)
+	prog, err := ioutil.ReadFile(filepath.Join(dir, "repro.prog"))
+	if err != nil {
+		return nil
+	}
+	repro := &Repro{Prog: prog}
+	repro.CProg, _ = ioutil.ReadFile(filepath.Join(dir, "repro.cprog"))
+	repro.Log, _ = ioutil.ReadFile(filepath.Join(dir, "repro.log"))
+	repro.Report, _ = ioutil.ReadFile(filepath.Join(dir, "repro.report"))
+	repro.Stats, _ = ioutil.ReadFile(filepath.Join(dir, "repro.stats"))
+	tag, _ := ioutil.ReadFile(filepath.Join(dir, "repro.tag"))
+	repro.Tag = string(tag)
+	descriptions, _ := ioutil.ReadFile(filepath.Join(dir, "repro.descriptions"))
+	repro.Descriptions = string(descriptions)
+	stale, _ := ioutil.ReadFile(filepath.Join(dir, "repro.stale"))
+	repro.Stale = string(stale)
+	return repro
+}
+
+// ResolveLog returns the crash log to use for path.
+// If path is a crash type directory, the log of the most recent occurrence is returned,
+// otherwise path is assumed to be a log file itself.
+func ResolveLog(path string) (string, error) {
+	if !osutil.IsExist(filepath.Join(path, "description")) {
+		return path, nil
+	}
+	path = filepath.Clean(path)
+	typ, err := Read(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return "", err
+	}
+	if len(typ.Crashes) == 0 {
+		return "", fmt.Errorf("crash directory %v does not contain any logs", path)
+	}
+	return filepath.Join(path, typ.Crashes[0].Log), nil
+}
+
+func readType(crashdir, id string, full bool) (*Type, error) {
+	if len(id) != 40 {
+		return nil, fmt.Errorf("bad crash id %q", id)
+	}
+	dir := filepath.Join(crashdir, id)
+	descFile := filepath.Join(dir, "description")
+	desc, err := ioutil.ReadFile(descFile)
+	if err != nil {
+		return nil, err
+	}
+	title := strings.TrimRight(string(desc), "\n")
+	if title == "" {
+		return nil, fmt.Errorf("empty crash description in %v", dir)
+	}
+	stat, err := os.Stat(descFile)
+	if err != nil {
+		return nil, err
+	}
+	files, err := osutil.ListDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	typ := &Type{
+		ID:       id,
+		Title:    title,
+		LastTime: stat.ModTime(),
+	}
+	for _, f := range files {
+		switch {
+		case strings.HasPrefix(f, "log"):
+			if index, err := strconv.ParseUint(f[3:], 10, 64); err == nil {
+				typ.Crashes = append(typ.Crashes, &Crash{
+					Index: int(index),
+					Log:   f,
+				})
+			}
+		case f == "repro.prog":
+			typ.HasRepro = true
+		case f == "repro.cprog":
+			typ.HasCRepro = true
+		case f == "repro.stale":
+			typ.ReproStale = true
+		case strings.HasPrefix(f, "repro") && len(f) > len("repro") &&
+			f[len("repro")] >= '0' && f[len("repro")] <= '9':
+			typ.ReproAttempts++
+		}
+	}
+	if full {
+		for _, crash := range typ.Crashes {
+			readCrash(dir, crash)
+		}
+		sort.Slice(typ.Crashes, func(i, j int) bool {
+			return typ.Crashes[i].Time.After(typ.Crashes[j].Time)
+		})
+		typ.Builds = ReadBuilds(crashdir, id)
+	}
+	return typ, nil
+}
+
+func readCrash(dir string, crash *Crash) {
+	if stat, err := os.Stat(filepath.Join(dir, crash.Log)); err == nil {
+		crash.Time = stat.ModTime()
+	}
+	tag, _ := ioutil.ReadFile(filepath.Join(dir, tagFile(crash.Index)))
+	crash.Tag = string(tag)
+	origin, _ := ioutil.ReadFile(filepath.Join(dir, originFile(crash.Index)))
+	crash.Origin = string(origin)
+	if osutil.IsExist(filepath.Join(dir, reportFile(crash.Index))) {
+		crash.Report = reportFile(crash.Index)
+	}
+	if osutil.IsExist(filepath.Join(dir, recordingFile(crash.Index))) {
+		crash.Recording = recordingFile(crash.Index)
+	}
+	if osutil.IsExist(filepath.Join(dir, diagnosisFile(crash.Index))) {
+		crash.Diagnosis = diagnosisFile(crash.Index)
+	}
+	if osutil.IsExist(filepath.Join(dir, timedFile(crash.Index))) {
+		crash.TimedConsole = timedFile(crash.Index)
+	}
+	if osutil.IsExist(filepath.Join(dir, ftraceFile(crash.Index))) {
+		crash.Ftrace = ftraceFile(crash.Index)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, metaFile(crash.Index))); err == nil {
+		meta := new(Meta)
+		if err := json.Unmarshal(data, meta); err == nil {
+			crash.Meta = meta
+		}
+	}
+}
+
+func writeDescription(crashdir, title string) (string, error) {
+	dir := filepath.Join(crashdir, ID(title))
+	if err := osutil.MkdirAll(dir); err != nil {
+		return "", fmt.Errorf("failed to create crash dir: %v", err)
+	}
+	if err := osutil.WriteFile(filepath.Join(dir, "description"), []byte(title+"\n")); err != nil {
+		return "", fmt.Errorf("failed to write crash description: %v", err)
+	}
+	return dir, nil
+}
+
+// writeOptional writes data to the file, or removes a stale file if data is empty.
+func writeOptional(file string, data []byte) {
+	if len(data) == 0 {
+		os.Remove(file)
+		return
+	}
+	osutil.WriteFile(file, data)
+}
@@ -0,0 +1,217 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package crashdir
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/osutil"
+)
+
+func TestRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-crashdir-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const title = "KASAN: use-after-free Read in foo"
+	meta := &Meta{
+		Title:      title,
+		Time:       time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC),
+		VMIndex:    3,
+		BuildID:    "build",
+		Programs:   []string{"abcd"},
+		GuiltyFile: "mm/foo.c",
+		Frames: []Frame{
+			{Func: "foo_inline", File: "mm/foo.c", Line: 10, Inline: true, ReportLine: 2},
+			{Func: "foo", File: "mm/foo.c", Line: 20, ReportLine: 3},
+		},
+	}
+	index, first, err := SaveCrash(dir, title, &Occurrence{
+		Log:       []byte("log0"),
+		Report:    []byte("report0"),
+		Tag:       "tag0",
+		Meta:      meta,
+		Diagnosis: []byte("diagnosis"),
+		Ftrace:    []byte("ftrace"),
+	})
+	if err != nil || index != 0 || !first {
+		t.Fatalf("first SaveCrash: index=%v first=%v err=%v", index, first, err)
+	}
+	if !NeedRepro(dir, title) {
+		t.Fatalf("crash without repro attempts does not need repro")
+	}
+	for i := 0; i < MaxReproAttempts; i++ {
+		if err := SaveFailedRepro(dir, title, []byte("stats")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if NeedRepro(dir, title) {
+		t.Fatalf("crash with %v failed repro attempts needs repro", MaxReproAttempts)
+	}
+	if err := SaveRepro(dir, title, &Repro{Prog: []byte("prog"), CProg: []byte("cprog")}); err != nil {
+		t.Fatal(err)
+	}
+	recording := filepath.Join(dir, "vm-0.rr")
+	if err := ioutil.WriteFile(recording, []byte("rr"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	index, first, err = SaveCrash(dir, title, &Occurrence{
+		Log:       []byte("log1"),
+		Origin:    "external",
+		Meta:      &Meta{Title: title, VMIndex: -1, ReplayCommand: "qemu -icount rr=replay,rrfile=" + recording},
+		Recording: recording,
+	})
+	if err != nil || index != 1 || first {
+		t.Fatalf("second SaveCrash: index=%v first=%v err=%v", index, first, err)
+	}
+	if !HasTag(dir, title, "tag0") || !HasTag(dir, title, "") || HasTag(dir, title, "tag1") ||
+		HasTag(dir, "another title", "tag0") {
+		t.Fatalf("HasTag does not match saved tags")
+	}
+	// Make the second occurrence the most recent one regardless of timestamp granularity.
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, ID(title), "log1"), future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	types, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 1 {
+		t.Fatalf("got %v crash types, want 1", len(types))
+	}
+	typ, err := Read(dir, ID(title))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ.Title != title || !typ.HasRepro || !typ.HasCRepro ||
+		typ.ReproAttempts != MaxReproAttempts || len(typ.Crashes) != 2 {
+		t.Fatalf("bad crash type: %+v", typ)
+	}
+	latest, oldest := typ.Crashes[0], typ.Crashes[1]
+	if latest.Index != 1 || latest.Origin != "external" || latest.Report != "" || latest.Tag != "" ||
+		latest.Diagnosis != "" || latest.Ftrace != "" {
+		t.Fatalf("bad latest crash: %+v", latest)
+	}
+	if !latest.Meta.HasRepro || !latest.Meta.HasCRepro || latest.Meta.VMIndex != -1 {
+		t.Fatalf("bad latest crash meta: %+v", latest.Meta)
+	}
+	savedRecording := filepath.Join(dir, ID(title), "recording1")
+	if latest.Recording != "recording1" || osutil.IsExist(recording) ||
+		latest.Meta.ReplayCommand != "qemu -icount rr=replay,rrfile="+savedRecording {
+		t.Fatalf("bad latest crash recording: %v, %v", latest.Recording, latest.Meta.ReplayCommand)
+	}
+	if oldest.Index != 0 || oldest.Report != "report0" || oldest.Tag != "tag0" || oldest.Origin != "" ||
+		oldest.Recording != "" || oldest.Diagnosis != "diagnosis0" ||
+		oldest.Ftrace != "ftrace0" {
+		t.Fatalf("bad oldest crash: %+v", oldest)
+	}
+	if !reflect.DeepEqual(oldest.Meta, meta) {
+		t.Fatalf("bad oldest crash meta:\n%+v\nwant:\n%+v", oldest.Meta, meta)
+	}
+	repro := ReadRepro(dir, ID(title))
+	if repro == nil || string(repro.Prog) != "prog" || string(repro.CProg) != "cprog" {
+		t.Fatalf("bad repro: %+v", repro)
+	}
+
+	logFile, err := ResolveLog(filepath.Join(dir, ID(title)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, ID(title), "log1"); logFile != want {
+		t.Fatalf("resolved log %v, want %v", logFile, want)
+	}
+	if logFile, err := ResolveLog("some.log"); err != nil || logFile != "some.log" {
+		t.Fatalf("resolved plain log to %v, %v", logFile, err)
+	}
+}
+
+func TestStaleRepro(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-crashdir-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const title = "WARNING in foo"
+	if _, _, err := SaveCrash(dir, title, &Occurrence{Log: []byte("log"), Meta: &Meta{Title: title}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := MarkRepro(dir, ID(title), "rev2", ""); err == nil {
+		t.Fatalf("marked non-existent repro")
+	}
+	if err := SaveRepro(dir, title, &Repro{Prog: []byte("prog"), Descriptions: "rev1"}); err != nil {
+		t.Fatal(err)
+	}
+	if repro := ReadRepro(dir, ID(title)); repro.Descriptions != "rev1" || repro.Stale != "" {
+		t.Fatalf("bad repro: %+v", repro)
+	}
+	if err := MarkRepro(dir, ID(title), "rev2", "changed meaning"); err != nil {
+		t.Fatal(err)
+	}
+	if repro := ReadRepro(dir, ID(title)); repro.Descriptions != "rev2" || repro.Stale != "changed meaning" {
+		t.Fatalf("bad stale repro: %+v", repro)
+	}
+	if typ, err := Read(dir, ID(title)); err != nil || !typ.ReproStale {
+		t.Fatalf("crash type is not marked stale: %+v, %v", typ, err)
+	}
+	// A new reproducer clears the mark.
+	if err := SaveRepro(dir, title, &Repro{Prog: []byte("prog2"), Descriptions: "rev2"}); err != nil {
+		t.Fatal(err)
+	}
+	if typ, err := Read(dir, ID(title)); err != nil || typ.ReproStale {
+		t.Fatalf("crash type is still marked stale: %+v, %v", typ, err)
+	}
+}
+
+func TestBuilds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-crashdir-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const title = "WARNING in foo"
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, build := range []string{"build1", "build1", "build2", "build1", "build2"} {
+		if _, err := RecordBuild(dir, title, build, start.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(builds []*Build) {
+		t.Helper()
+		if len(builds) != 2 {
+			t.Fatalf("got %v builds, want 2", len(builds))
+		}
+		b1, b2 := builds[0], builds[1]
+		if b1.ID != "build1" || b1.Count != 3 || !b1.FirstSeen.Equal(start) ||
+			!b1.LastSeen.Equal(start.Add(3*time.Hour)) {
+			t.Errorf("bad build1: %+v", b1)
+		}
+		if b2.ID != "build2" || b2.Count != 2 || !b2.FirstSeen.Equal(start.Add(2*time.Hour)) ||
+			!b2.LastSeen.Equal(start.Add(4*time.Hour)) {
+			t.Errorf("bad build2: %+v", b2)
+		}
+	}
+	check(ReadBuilds(dir, ID(title)))
+	// Builds are remembered after all logs are rotated away.
+	for i := 0; i < MaxCrashes; i++ {
+		if _, _, err := SaveCrash(dir, title, &Occurrence{Log: []byte("log")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	typ, err := Read(dir, ID(title))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(typ.Builds)
+}
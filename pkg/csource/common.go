@@ -88,6 +88,8 @@ func defineList(p, mmapProg *prog.Prog, opts Options) (defines []string) {
 		"SYZ_HANDLE_SEGV":                   opts.HandleSegv,
 		"SYZ_REPRO":                         opts.Repro,
 		"SYZ_TRACE":                         opts.Trace,
+		"SYZ_PROG_PROLOGUE":                 opts.Prologue != "",
+		"SYZ_PROG_EPILOGUE":                 opts.Epilogue != "",
 		"SYZ_EXECUTOR_USES_SHMEM":           sysTarget.ExecutorUsesShmem,
 		"SYZ_EXECUTOR_USES_FORK_SERVER":     sysTarget.ExecutorUsesForkServer,
 	}
@@ -67,6 +67,8 @@ func Write(p *prog.Prog, opts Options) ([]byte, error) {
 		"SANDBOX_FUNC":    sandboxFunc,
 		"RESULTS":         varsBuf.String(),
 		"SYSCALLS":        ctx.generateSyscalls(calls, len(vars) != 0),
+		"PROG_PROLOGUE":   cString(opts.Prologue),
+		"PROG_EPILOGUE":   cString(opts.Epilogue),
 	}
 	if !opts.Threaded && !opts.Repeat && opts.Sandbox == "" {
 		// This inlines syscalls right into main for the simplest case.
@@ -83,6 +85,24 @@ func Write(p *prog.Prog, opts Options) ([]byte, error) {
 	return result, nil
 }
 
+// cString returns s as a C string literal.
+func cString(s string) string {
+	buf := new(bytes.Buffer)
+	buf.WriteByte('"')
+	for _, v := range []byte(s) {
+		switch {
+		case v == '"' || v == '\\':
+			fmt.Fprintf(buf, "\\%c", v)
+		case v >= 0x20 && v < 0x7f:
+			buf.WriteByte(v)
+		default:
+			fmt.Fprintf(buf, "\\%03o", v)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
 type context struct {
 	p         *prog.Prog
 	opts      Options
@@ -174,8 +194,7 @@ func (ctx *context) generateCalls(p prog.ExecProg, trace bool) ([]string, []uint
 		}
 
 		if ctx.opts.Fault && ctx.opts.FaultCall == ci {
-			fmt.Fprintf(w, "\twrite_file(\"/sys/kernel/debug/failslab/ignore-gfp-wait\", \"N\");\n")
-			fmt.Fprintf(w, "\twrite_file(\"/sys/kernel/debug/fail_futex/ignore-private\", \"N\");\n")
+			// Fault injection itself is configured in main (see setup_fault).
 			fmt.Fprintf(w, "\tinject_fault(%v);\n", ctx.opts.FaultNth)
 		}
 		// Call itself.
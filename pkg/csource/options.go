@@ -34,6 +34,11 @@ type Options struct {
 	ResetNet      bool `json:"resetnet,omitempty"`
 	HandleSegv    bool `json:"segv,omitempty"`
 
+	// Shell commands executed before/after each execution of the program
+	// (see prog_prologue/prog_epilogue in manager config).
+	Prologue string `json:"prologue,omitempty"`
+	Epilogue string `json:"epilogue,omitempty"`
+
 	// Generate code for use with repro package to prints log messages,
 	// which allows to detect hangs.
 	Repro bool `json:"repro,omitempty"`
@@ -115,9 +120,17 @@ func (opts Options) checkLinuxOnly(OS string) error {
 	if opts.Fault {
 		return fmt.Errorf("Fault is not supported on %v", OS)
 	}
+	if (opts.Prologue != "" || opts.Epilogue != "") && !progHooksSupported(OS) {
+		return fmt.Errorf("Prologue/Epilogue is not supported on %v", OS)
+	}
 	return nil
 }
 
+// progHooksSupported returns if C programs for OS can run shell commands with system.
+func progHooksSupported(OS string) bool {
+	return OS == linux || OS == "openbsd" || OS == "freebsd" || OS == "netbsd"
+}
+
 func DefaultOpts(cfg *mgrconfig.Config) Options {
 	opts := Options{
 		Threaded:      true,
@@ -132,6 +145,8 @@ func DefaultOpts(cfg *mgrconfig.Config) Options {
 		UseTmpDir:     true,
 		HandleSegv:    true,
 		Repro:         true,
+		Prologue:      cfg.ProgPrologue,
+		Epilogue:      cfg.ProgEpilogue,
 	}
 	if cfg.TargetOS != linux {
 		opts.EnableTun = false
@@ -142,6 +157,10 @@ func DefaultOpts(cfg *mgrconfig.Config) Options {
 	if cfg.Sandbox == "" || cfg.Sandbox == "setuid" {
 		opts.ResetNet = false
 	}
+	if !progHooksSupported(cfg.TargetOS) {
+		opts.Prologue = ""
+		opts.Epilogue = ""
+	}
 	if err := opts.Check(cfg.TargetOS); err != nil {
 		panic(fmt.Sprintf("DefaultOpts created bad opts: %v", err))
 	}
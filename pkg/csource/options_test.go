@@ -174,6 +174,11 @@ func enumerateField(OS string, opt Options, field int) []Options {
 			fld.SetInt(times)
 			opts = append(opts, opt)
 		}
+	} else if fldName == "Prologue" || fldName == "Epilogue" {
+		for _, cmd := range []string{"", `echo "it's \"ok\"" >/dev/null`} {
+			fld.SetString(cmd)
+			opts = append(opts, opt)
+		}
 	} else if fldName == "FaultCall" {
 		opts = append(opts, opt)
 	} else if fldName == "FaultNth" {
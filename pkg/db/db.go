@@ -17,6 +17,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"strings"
 
 	"github.com/google/syzkaller/pkg/hash"
 	"github.com/google/syzkaller/pkg/log"
@@ -26,6 +27,7 @@ import (
 type DB struct {
 	Version uint64            // arbitrary user version (0 for new database)
 	Records map[string]Record // in-memory cache, must not be modified directly
+	Meta    map[string]string // arbitrary user metadata, must not be modified directly
 
 	filename    string
 	uncompacted int           // number of records in the file
@@ -47,6 +49,7 @@ func Open(filename string) (*DB, error) {
 	}
 	db.Version, db.Records, db.uncompacted = deserializeDB(bufio.NewReader(f))
 	f.Close()
+	db.Meta = extractMeta(db.Records)
 	if len(db.Records) == 0 || db.uncompacted/10*9 > len(db.Records) {
 		if err := db.compact(); err != nil {
 			return nil, err
@@ -55,6 +58,19 @@ func Open(filename string) (*DB, error) {
 	return db, nil
 }
 
+// ReadRecords reads records of the database file without modifying the file
+// (e.g. to read a database that belongs to another process).
+func ReadRecords(filename string) (map[string]Record, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	_, records, _ := deserializeDB(bufio.NewReader(f))
+	extractMeta(records)
+	return records, nil
+}
+
 func (db *DB) Save(key string, val []byte, seq uint64) {
 	if seq == seqDeleted {
 		panic("reserved seq")
@@ -76,6 +92,16 @@ func (db *DB) Delete(key string) {
 	db.uncompacted++
 }
 
+// SetMeta sets user metadata key to val (persisted with the next Flush).
+func (db *DB) SetMeta(key, val string) {
+	if old, ok := db.Meta[key]; ok && old == val {
+		return
+	}
+	db.Meta[key] = val
+	db.serialize(metaPrefix+key, []byte(val), 0)
+	db.uncompacted++
+}
+
 func (db *DB) Flush() error {
 	if db.uncompacted/10*9 > len(db.Records) {
 		return db.compact()
@@ -109,6 +135,9 @@ func (db *DB) compact() error {
 	for key, rec := range db.Records {
 		serializeRecord(buf, key, rec.Val, rec.Seq)
 	}
+	for key, val := range db.Meta {
+		serializeRecord(buf, metaPrefix+key, []byte(val), 0)
+	}
 	f, err := os.Create(db.filename + ".tmp")
 	if err != nil {
 		return err
@@ -121,7 +150,7 @@ func (db *DB) compact() error {
 	if err := osutil.Rename(f.Name(), db.filename); err != nil {
 		return err
 	}
-	db.uncompacted = len(db.Records)
+	db.uncompacted = len(db.Records) + len(db.Meta)
 	db.pending = nil
 	return nil
 }
@@ -138,8 +167,23 @@ const (
 	recMagic   = uint32(0xfee1bad)
 	curVersion = uint32(2)
 	seqDeleted = ^uint64(0)
+	// Metadata is stored as records with keys with this prefix (record keys are hashes
+	// and never contain ':'), so that older versions can read databases with metadata.
+	metaPrefix = "meta:"
 )
 
+// extractMeta moves metadata records from records into a separate map.
+func extractMeta(records map[string]Record) map[string]string {
+	meta := make(map[string]string)
+	for key, rec := range records {
+		if strings.HasPrefix(key, metaPrefix) {
+			meta[key[len(metaPrefix):]] = string(rec.Val)
+			delete(records, key)
+		}
+	}
+	return meta
+}
+
 func serializeHeader(w *bytes.Buffer, version uint64) {
 	binary.Write(w, binary.LittleEndian, dbMagic)
 	binary.Write(w, binary.LittleEndian, curVersion)
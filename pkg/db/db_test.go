@@ -91,6 +91,45 @@ func TestModify(t *testing.T) {
 	}
 }
 
+func TestMeta(t *testing.T) {
+	fn := tempFile(t)
+	defer os.Remove(fn)
+	db, err := Open(fn)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	db.Save("1", []byte("ab"), 0)
+	db.SetMeta("revision", "abc")
+	db.SetMeta("other", "")
+	db.SetMeta("revision", "def")
+	if err := db.Flush(); err != nil {
+		t.Fatalf("failed to flush db: %v", err)
+	}
+	wantRecords := map[string]Record{
+		"1": {Val: []byte("ab"), Seq: 0},
+	}
+	wantMeta := map[string]string{
+		"revision": "def",
+		"other":    "",
+	}
+	for i := 0; i < 2; i++ {
+		db, err = Open(fn)
+		if err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		if !reflect.DeepEqual(db.Records, wantRecords) {
+			t.Fatalf("bad db records after reopen: %v, want: %v", db.Records, wantRecords)
+		}
+		if !reflect.DeepEqual(db.Meta, wantMeta) {
+			t.Fatalf("bad db meta after reopen: %v, want: %v", db.Meta, wantMeta)
+		}
+		// Metadata must survive compaction.
+		if err := db.BumpVersion(uint64(i + 1)); err != nil {
+			t.Fatalf("failed to bump version: %v", err)
+		}
+	}
+}
+
 func TestLarge(t *testing.T) {
 	fn := tempFile(t)
 	defer os.Remove(fn)
@@ -125,3 +164,49 @@ func tempFile(t *testing.T) string {
 	}
 	return fn
 }
+
+func TestProvenance(t *testing.T) {
+	fn := tempFile(t)
+	defer os.Remove(fn)
+	db, err := Open(fn)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	db.SaveProvenance("a", &Provenance{Origin: "generate", Call: "open", Signal: 10, NewSignal: 10})
+	db.SaveProvenance("b", &Provenance{Origin: "mutate", Parent: "a", Call: "read", Signal: 5, NewSignal: 2})
+	db.SaveProvenance("c", &Provenance{Origin: "smash", Parent: "b"})
+	db.SaveProvenance("d", &Provenance{Origin: "mutate", Parent: "deleted"})
+	db.SaveProvenance("e", &Provenance{Origin: "mutate", Parent: "f"})
+	db.SaveProvenance("f", &Provenance{Origin: "mutate", Parent: "e"})
+	if err := db.Flush(); err != nil {
+		t.Fatalf("failed to flush db: %v", err)
+	}
+	records, err := ReadRecords(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prov := LoadProvenance(records, "b"); prov == nil || prov.Origin != "mutate" || prov.Parent != "a" ||
+		prov.Call != "read" || prov.Signal != 5 || prov.NewSignal != 2 {
+		t.Fatalf("bad provenance: %+v", prov)
+	}
+	if prov := LoadProvenance(records, "x"); prov != nil {
+		t.Fatalf("got provenance for unknown program: %+v", prov)
+	}
+	for key, want := range map[string][]string{
+		"c": {"smash", "mutate", "generate"},
+		"d": {"mutate"},
+		"e": {"mutate", "mutate"},
+		"x": nil,
+	} {
+		var got []string
+		for _, prov := range ProvenanceChain(records, key) {
+			got = append(got, prov.Origin)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("chain of %v: got %v, want %v", key, got, want)
+		}
+	}
+	if name := ProvenanceFile("workdir/corpus.db"); name != "workdir/corpus-provenance.db" {
+		t.Errorf("bad provenance file name %v", name)
+	}
+}
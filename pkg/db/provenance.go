@@ -0,0 +1,73 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Provenance describes how a corpus program was obtained.
+// Provenance of a corpus is stored in a separate database (see ProvenanceFile)
+// with the same keys as the corpus database and JSON-encoded Provenance values.
+type Provenance struct {
+	Origin    string    `json:"origin"`           // e.g. "generate", "mutate", "hub" (see rpctype.Origin*)
+	Parent    string    `json:"parent,omitempty"` // hash of the corpus program this program was derived from
+	Time      time.Time `json:"time"`             // when the program was added to corpus
+	Call      string    `json:"call"`             // the call that gave new signal
+	Signal    int       `json:"signal"`           // signal of the call when the program was added
+	NewSignal int       `json:"new_signal"`       // part of the signal that was new for the corpus
+	// Hash of the program this program replaced (e.g. when the program was re-minimized
+	// after descriptions change), the program inherits provenance of the replaced program.
+	Replaced string `json:"replaced,omitempty"`
+}
+
+// ProvenanceFile returns name of the provenance database for the corpus database corpusFile
+// (corpus.db -> corpus-provenance.db).
+func ProvenanceFile(corpusFile string) string {
+	return strings.TrimSuffix(corpusFile, ".db") + "-provenance.db"
+}
+
+// SaveProvenance saves provenance of the program with hash key.
+func (db *DB) SaveProvenance(key string, prov *Provenance) {
+	data, err := json.Marshal(prov)
+	if err != nil {
+		panic(fmt.Sprintf("failed to marshal provenance: %v", err))
+	}
+	db.Save(key, data, 0)
+}
+
+// LoadProvenance returns provenance of the program with hash key from provenance database records,
+// or nil if the program has no (valid) provenance.
+func LoadProvenance(records map[string]Record, key string) *Provenance {
+	rec, ok := records[key]
+	if !ok {
+		return nil
+	}
+	prov := new(Provenance)
+	if err := json.Unmarshal(rec.Val, prov); err != nil {
+		return nil
+	}
+	return prov
+}
+
+// ProvenanceChain returns provenance of the program with hash key followed by provenance
+// of its ancestors (parent, parent of the parent, etc). The chain ends with the first program
+// that has no parent or no provenance.
+func ProvenanceChain(records map[string]Record, key string) []*Provenance {
+	var chain []*Provenance
+	seen := make(map[string]bool)
+	for key != "" && !seen[key] {
+		seen[key] = true
+		prov := LoadProvenance(records, key)
+		if prov == nil {
+			break
+		}
+		chain = append(chain, prov)
+		key = prov.Parent
+	}
+	return chain
+}
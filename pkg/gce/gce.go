@@ -43,9 +43,12 @@ type Context struct {
 	apiRateGate <-chan time.Time
 }
 
+// DefaultAPIQPS is the default limit on the rate of API calls (see SetAPIRate).
+const DefaultAPIQPS = 1
+
 func NewContext() (*Context, error) {
 	ctx := &Context{
-		apiRateGate: time.NewTicker(time.Second).C,
+		apiRateGate: time.NewTicker(time.Second / DefaultAPIQPS).C,
 	}
 	background := context.Background()
 	tokenSource, err := google.DefaultTokenSource(background, compute.CloudPlatformScope)
@@ -92,6 +95,20 @@ func NewContext() (*Context, error) {
 	return ctx, nil
 }
 
+// SetAPIRate changes the limit on the rate of API calls done through ctx.
+// Must be called before ctx is used.
+func (ctx *Context) SetAPIRate(qps float64) {
+	ctx.apiRateGate = time.NewTicker(time.Duration(float64(time.Second) / qps)).C
+}
+
+// WithZone returns a context that manages instances in another zone of the same project.
+// The contexts share the API rate limit.
+func (ctx *Context) WithZone(zone string) *Context {
+	zctx := *ctx
+	zctx.ZoneID = zone
+	return &zctx
+}
+
 func (ctx *Context) CreateInstance(name, machineType, image, sshkey string) (string, error) {
 	prefix := "https://www.googleapis.com/compute/v1/projects/" + ctx.ProjectID
 	sshkeyAttr := "syzkaller:" + sshkey
@@ -259,6 +276,26 @@ func (ctx *Context) DeleteImage(imageName string) error {
 	return nil
 }
 
+// SerialPortOutput returns output of the first serial port of the instance starting at offset start.
+// Returns the output, its actual start offset (larger than start if the requested part of the output
+// was already dropped from the instance buffer) and the offset to pass in the next call.
+// The call itself (excluding waiting for the rate limit) is aborted after timeout.
+func (ctx *Context) SerialPortOutput(name string, start int64, timeout time.Duration) (
+	string, int64, int64, error) {
+	var output *compute.SerialPortOutput
+	err := ctx.apiCall(func() (err error) {
+		callCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		output, err = ctx.computeService.Instances.GetSerialPortOutput(ctx.ProjectID, ctx.ZoneID, name).
+			Port(1).Start(start).Context(callCtx).Do()
+		return
+	})
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("failed to get serial port output: %v", err)
+	}
+	return output.Contents, output.Start, output.Next, nil
+}
+
 type resourcePoolExhaustedError string
 
 func (err resourcePoolExhaustedError) Error() string {
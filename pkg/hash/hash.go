@@ -9,6 +9,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"math"
 )
 
 type Sig [sha1.Size]byte
@@ -41,6 +42,18 @@ func (sig *Sig) Truncate64() int64 {
 	return v
 }
 
+// Seed derives a PRNG seed from the base seed and name of an entity (e.g. a VM or a process),
+// so that seeds of different entities are unrelated, but stay the same for the same base seed.
+// The result is never 0 (which usually means "random seed").
+func Seed(base int64, name string) int64 {
+	sig := Hash([]byte(fmt.Sprintf("%v/%v", base, name)))
+	seed := sig.Truncate64() & math.MaxInt64
+	if seed == 0 {
+		seed = 1
+	}
+	return seed
+}
+
 func FromString(str string) (Sig, error) {
 	bin, err := hex.DecodeString(str)
 	if err != nil {
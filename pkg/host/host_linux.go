@@ -194,6 +194,8 @@ func isSupportedSyzkall(sandbox string, c *prog.Syscall) (bool, string) {
 		}
 		syscall.Close(fd)
 		return true, ""
+	case "syz_io_uring_setup", "syz_io_uring_submit", "syz_io_uring_complete":
+		return isSupportedIOUring()
 	case "syz_mount_image":
 		if ok, reason := onlySandboxNone(sandbox); !ok {
 			return ok, reason
@@ -211,6 +213,20 @@ func isSupportedSyzkall(sandbox string, c *prog.Syscall) (bool, string) {
 	panic("unknown syzkall: " + c.Name)
 }
 
+// io_uring_setup has the same number on all arches (it was added after syscall numbers were unified).
+const sysIOUringSetup = 425
+
+func isSupportedIOUring() (bool, string) {
+	// Zero entries and nil params are invalid, so this does not create an instance.
+	// io_uring can also be disabled at runtime (kernel.io_uring_disabled), then setup fails with EPERM.
+	_, _, errno := syscall.Syscall(sysIOUringSetup, 0, 0, 0)
+	switch errno {
+	case syscall.ENOSYS, syscall.EPERM:
+		return false, fmt.Sprintf("io_uring_setup failed: %v", errno)
+	}
+	return true, ""
+}
+
 func onlySandboxNone(sandbox string) (bool, string) {
 	if syscall.Getuid() != 0 || sandbox != "none" {
 		return false, "only supported under root with sandbox=none"
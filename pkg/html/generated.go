@@ -154,6 +154,30 @@ textarea {
 	width:100%;
 	font-family: monospace;
 }
+
+.crash_report pre {
+	margin: 0;
+}
+
+.crash_report .oops {
+	color: #c00;
+	font-weight: bold;
+}
+
+.crash_report .guilty {
+	background: #ffe8a0;
+	font-weight: bold;
+}
+
+.crash_report .frame a {
+	color: #375EAB;
+}
+
+.crash_section {
+	border-top: 2px solid #ccc;
+	margin-top: 10px;
+	padding-top: 5px;
+}
 `
 const js = `
 // Copyright 2018 syzkaller project authors. All rights reserved.
@@ -0,0 +1,29 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package instance
+
+import (
+	"fmt"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+)
+
+// CPUSetCmd wraps a command that runs syz-fuzzer/syz-execprog so that it (and the executor)
+// runs only on the guest CPUs from cpuset (guest_cpuset, checked by mgrconfig).
+// The command fails if the guest does not have all of these CPUs. Returns cmd if cpuset is empty.
+func CPUSetCmd(cmd, cpuset string) string {
+	if cpuset == "" {
+		return cmd
+	}
+	cpus, err := mgrconfig.ParseCPUSet(cpuset)
+	if err != nil {
+		panic(fmt.Sprintf("bad guest_cpuset %q: %v", cpuset, err))
+	}
+	script := fmt.Sprintf("n=$(nproc --all 2>/dev/null); "+
+		"if [ -n \"$n\" ] && [ $n -le %[2]v ]; then "+
+		"echo syzkaller: guest_cpuset %[1]v does not fit into $n guest CPUs; exit 1; fi; "+
+		"exec taskset -c %[1]v %[3]v",
+		cpuset, cpus[len(cpus)-1], cmd)
+	return `sh -c "` + shellEscaper.Replace(script) + `"`
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package instance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/osutil"
+)
+
+func TestCPUSetCmd(t *testing.T) {
+	if cmd := CPUSetCmd("./syz-fuzzer -v=1", ""); cmd != "./syz-fuzzer -v=1" {
+		t.Fatalf("command is changed without cpuset: %q", cmd)
+	}
+	tests := []struct {
+		cpuset string
+		nproc  int
+		want   string
+		fail   bool
+	}{
+		{"0,2", 4, "taskset -c 0,2\ndone $x\n", false},
+		{"1-3", 4, "taskset -c 1-3\ndone $x\n", false},
+		{"1-3", 3, "syzkaller: guest_cpuset 1-3 does not fit into 3 guest CPUs\n", true},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%v-%v", test.cpuset, test.nproc), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "syz-cpuset")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			// Fake nproc and taskset, test machines don't necessarily have enough CPUs.
+			scripts := map[string]string{
+				"nproc":   fmt.Sprintf("#!/bin/sh\necho %v\n", test.nproc),
+				"taskset": "#!/bin/sh\n[ \"$1\" = \"-c\" ] && echo taskset -c $2 && shift 2 && exec \"$@\"\nexit 1\n",
+			}
+			for name, script := range scripts {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c := osutil.Command("sh", "-c", CPUSetCmd(`echo "done" '$x'`, test.cpuset))
+			c.Env = append(os.Environ(), "PATH="+dir+":"+os.Getenv("PATH"))
+			out, err := c.CombinedOutput()
+			if (err != nil) != test.fail {
+				t.Fatalf("command failed: %v, want failure: %v\n%s", err, test.fail, out)
+			}
+			if string(out) != test.want {
+				t.Fatalf("got output %q, want %q", out, test.want)
+			}
+		})
+	}
+}
+
+func TestCPUSetCmdAffinity(t *testing.T) {
+	if _, err := osutil.RunCmd(time.Minute, "", "taskset", "-c", "0", "true"); err != nil {
+		t.Skipf("taskset can't confine to CPU 0: %v", err)
+	}
+	cmd := CPUSetCmd("grep Cpus_allowed_list /proc/self/status", "0")
+	out, err := osutil.RunCmd(time.Minute, "", "sh", "-c", cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Cpus_allowed_list:\t0\n"; string(out) != want {
+		t.Fatalf("got %q, want %q", out, want)
+	}
+}
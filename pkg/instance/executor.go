@@ -0,0 +1,128 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package instance
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/vm"
+)
+
+// ExecutorHash returns sha256 that syz-executor in VMs is expected to have:
+// executor_hash from config (for executors pre-installed in images),
+// or hash of the syz-executor binary that is deployed into VMs.
+func ExecutorHash(cfg *mgrconfig.Config) (string, error) {
+	if cfg.ExecutorHash != "" {
+		return cfg.ExecutorHash, nil
+	}
+	f, err := os.Open(cfg.SyzExecutorBin)
+	if err != nil {
+		return "", fmt.Errorf("failed to open executor: %v", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to read executor: %v", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type ExecutorMismatchError struct {
+	Expected string
+	Got      string
+}
+
+func (err *ExecutorMismatchError) Error() string {
+	return fmt.Sprintf("executor mismatch: expected %v got %v, redeploy the image", err.Expected, err.Got)
+}
+
+// CheckExecutor checks that the executor binary in VM has the expected hash,
+// a stale or truncated executor leads to failures that look like kernel bugs.
+// ExecutorMismatchError is returned on mismatch (unless allow_executor_mismatch is set).
+// OSes without a known hashing utility are not checked.
+func CheckExecutor(inst *vm.Instance, cfg *mgrconfig.Config, executor, expected string) error {
+	cmd := executorHashCmd(cfg.TargetOS, executor)
+	if cmd == "" {
+		return nil
+	}
+	output, err := runCommand(inst, time.Minute, cmd)
+	if err != nil {
+		return fmt.Errorf("failed to hash executor in VM: %v\n%s", err, output)
+	}
+	got := parseExecutorHash(output)
+	if got == "" {
+		return fmt.Errorf("failed to hash executor in VM: no hash in output:\n%s", output)
+	}
+	if got == expected {
+		return nil
+	}
+	mismatch := &ExecutorMismatchError{Expected: expected, Got: got}
+	if cfg.AllowExecutorMismatch {
+		log.Logf(1, "%v (allowed by config)", mismatch)
+		return nil
+	}
+	return mismatch
+}
+
+func executorHashCmd(OS, executor string) string {
+	switch OS {
+	case "linux":
+		return "sha256sum " + executor
+	case "freebsd", "netbsd", "openbsd":
+		return "sha256 -q " + executor
+	default:
+		return ""
+	}
+}
+
+var executorHashRe = regexp.MustCompile(`(?m)^([0-9a-f]{64})\b`)
+
+func parseExecutorHash(output []byte) string {
+	match := executorHashRe.FindSubmatch(output)
+	if match == nil {
+		return ""
+	}
+	return string(match[1])
+}
+
+func runCommand(inst *vm.Instance, timeout time.Duration, command string) ([]byte, error) {
+	outc, errc, err := inst.Run(timeout, nil, command)
+	if err != nil {
+		return nil, err
+	}
+	var output []byte
+	for {
+		select {
+		case out, ok := <-outc:
+			if !ok {
+				outc = nil
+				continue
+			}
+			output = append(output, out...)
+		case err := <-errc:
+			// Collect output that is already available.
+		drain:
+			for outc != nil {
+				select {
+				case out, ok := <-outc:
+					if !ok {
+						break drain
+					}
+					output = append(output, out...)
+				default:
+					break drain
+				}
+			}
+			return output, err
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package instance
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+)
+
+func TestExecutorHash(t *testing.T) {
+	f, err := ioutil.TempFile("", "syz-executor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write([]byte("executor")); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	cfg := &mgrconfig.Config{SyzExecutorBin: f.Name()}
+	hash, err := ExecutorHash(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "8fba13dab71d6fdd8a9b9db1f06e81315dfbfd69167b6097f724604db3c91cdf"; hash != want {
+		t.Errorf("got hash %v, want %v", hash, want)
+	}
+	cfg.ExecutorHash = "0000000000000000000000000000000000000000000000000000000000000000"
+	hash, err = ExecutorHash(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != cfg.ExecutorHash {
+		t.Errorf("got hash %v, want configured %v", hash, cfg.ExecutorHash)
+	}
+	cfg = &mgrconfig.Config{SyzExecutorBin: f.Name() + ".nonexistent"}
+	if _, err := ExecutorHash(cfg); err == nil {
+		t.Errorf("hashing a nonexistent executor succeeded")
+	}
+}
+
+func TestParseExecutorHash(t *testing.T) {
+	const hash = "8fba13dab71d6fdd8a9b9db1f06e81315dfbfd69167b6097f724604db3c91cdf"
+	tests := []struct {
+		output string
+		hash   string
+	}{
+		{hash + "  /syz-executor\n", hash},
+		{hash + "\n", hash},
+		{"[   12.345] random: crng init done\n" + hash + "  /syz-executor\n", hash},
+		{"sha256sum: /syz-executor: No such file or directory\n", ""},
+		{hash[:63] + "\n", ""},
+		{"", ""},
+	}
+	for i, test := range tests {
+		if got := parseExecutorHash([]byte(test.output)); got != test.hash {
+			t.Errorf("#%v: got %q, want %q", i, got, test.hash)
+		}
+	}
+}
+
+func TestExecutorHashCmd(t *testing.T) {
+	if cmd := executorHashCmd("linux", "/syz-executor"); cmd != "sha256sum /syz-executor" {
+		t.Errorf("bad linux command: %q", cmd)
+	}
+	if cmd := executorHashCmd("freebsd", "/syz-executor"); cmd != "sha256 -q /syz-executor" {
+		t.Errorf("bad freebsd command: %q", cmd)
+	}
+	if cmd := executorHashCmd("fuchsia", "/syz-executor"); cmd != "" {
+		t.Errorf("fuchsia executor is hashed: %q", cmd)
+	}
+	err := &ExecutorMismatchError{Expected: "aaaa", Got: "bbbb"}
+	if want := "executor mismatch: expected aaaa got bbbb, redeploy the image"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err.Error(), want)
+	}
+}
@@ -153,6 +153,10 @@ func (env *Env) Test(numVMs int, reproSyz, reproOpts, reproC []byte) ([]error, e
 	if err != nil {
 		return nil, err
 	}
+	executorHash, err := ExecutorHash(env.cfg)
+	if err != nil {
+		return nil, err
+	}
 	vmPool, err := vm.Create(env.cfg, false)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create VM pool: %v", err)
@@ -163,13 +167,14 @@ func (env *Env) Test(numVMs int, reproSyz, reproOpts, reproC []byte) ([]error, e
 	res := make(chan error, numVMs)
 	for i := 0; i < numVMs; i++ {
 		inst := &inst{
-			cfg:       env.cfg,
-			reporter:  reporter,
-			vmPool:    vmPool,
-			vmIndex:   i,
-			reproSyz:  reproSyz,
-			reproOpts: reproOpts,
-			reproC:    reproC,
+			cfg:          env.cfg,
+			reporter:     reporter,
+			vmPool:       vmPool,
+			vmIndex:      i,
+			executorHash: executorHash,
+			reproSyz:     reproSyz,
+			reproOpts:    reproOpts,
+			reproC:       reproC,
 		}
 		go func() { res <- inst.test() }()
 	}
@@ -181,14 +186,15 @@ func (env *Env) Test(numVMs int, reproSyz, reproOpts, reproC []byte) ([]error, e
 }
 
 type inst struct {
-	cfg       *mgrconfig.Config
-	reporter  report.Reporter
-	vmPool    *vm.Pool
-	vm        *vm.Instance
-	vmIndex   int
-	reproSyz  []byte
-	reproOpts []byte
-	reproC    []byte
+	cfg          *mgrconfig.Config
+	reporter     report.Reporter
+	vmPool       *vm.Pool
+	vm           *vm.Instance
+	vmIndex      int
+	executorHash string
+	reproSyz     []byte
+	reproOpts    []byte
+	reproC       []byte
 }
 
 func (inst *inst) test() error {
@@ -262,6 +268,9 @@ func (inst *inst) testInstance() error {
 	if err != nil {
 		return &TestError{Title: fmt.Sprintf("failed to copy test binary to VM: %v", err)}
 	}
+	if err := CheckExecutor(inst.vm, inst.cfg, executorBin, inst.executorHash); err != nil {
+		return &TestError{Title: err.Error()}
+	}
 
 	cmd := FuzzerCmd(fuzzerBin, executorBin, "test", inst.cfg.TargetOS, inst.cfg.TargetArch, fwdAddr,
 		inst.cfg.Sandbox, 0, 0, false, false, true, false)
@@ -322,6 +331,7 @@ func (inst *inst) testRepro() error {
 	}
 	cmdSyz := ExecprogCmd(execprogBin, executorBin, cfg.TargetOS, cfg.TargetArch, opts.Sandbox,
 		true, true, true, cfg.Procs, opts.FaultCall, opts.FaultNth, vmProgFile)
+	cmdSyz = MemoryCgroupCmd(cmdSyz, cfg.ExecutorMemoryLimit)
 	if err := inst.testProgram(cmdSyz, 7*time.Minute); err != nil {
 		return err
 	}
@@ -0,0 +1,48 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package instance
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MemoryCgroupCmd wraps a command that runs syz-fuzzer/syz-execprog so that it (and the executor)
+// runs in a memory cgroup limited to limitMB megabytes (executor_memory_limit), so that OOMs
+// are scoped to the executor instead of taking down the whole VM. Returns cmd if limitMB is 0.
+func MemoryCgroupCmd(cmd string, limitMB int) string {
+	if limitMB == 0 {
+		return cmd
+	}
+	return memoryCgroupCmd(cmd, limitMB, "/sys/fs/cgroup")
+}
+
+// memoryCgroupCmd creates cgroup syz-executor in cgroup v2 (mounted at root, if nothing is mounted there)
+// or in the memory controller hierarchy of cgroup v1 (root/memory), and runs cmd in it.
+// With cgroup v2 cmd runs in a new cgroup namespace, the executor mounts the cgroup2 hierarchy
+// for the test processes and it must see our cgroup as the root, otherwise the test processes
+// would be moved out of the limited cgroup.
+// The script is passed to sh -c in double quotes, because some VM types wrap the command into single quotes.
+func memoryCgroupCmd(cmd string, limitMB int, root string) string {
+	limit := uint64(limitMB) << 20
+	script := fmt.Sprintf("r=%v; "+
+		"if [ ! -e $r/cgroup.controllers ] && [ ! -d $r/memory ]; then "+
+		"mkdir -p $r && mount -t cgroup2 none $r; fi; "+
+		"if [ -e $r/cgroup.controllers ]; then "+
+		"cg=$r/syz-executor; mkdir -p $cg && "+
+		"echo +memory > $r/cgroup.subtree_control && "+
+		"echo %[2]v > $cg/memory.max && "+
+		"echo $$ > $cg/cgroup.procs && exec unshare -C %[3]v; "+
+		"else "+
+		"cg=$r/memory/syz-executor; mkdir -p $cg && "+
+		"echo %[2]v > $cg/memory.limit_in_bytes && "+
+		"echo $$ > $cg/cgroup.procs && exec %[3]v; "+
+		"fi; "+
+		"echo syzkaller: failed to set up executor memory cgroup; exit 1",
+		root, limit, cmd)
+	return `sh -c "` + shellEscaper.Replace(script) + `"`
+}
+
+// shellEscaper escapes characters that are special inside of double quotes in shell.
+var shellEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "`", "\\`")
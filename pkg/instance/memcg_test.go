@@ -0,0 +1,95 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package instance
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/osutil"
+)
+
+func TestMemoryCgroupCmdDisabled(t *testing.T) {
+	if cmd := MemoryCgroupCmd("./syz-fuzzer -v=1", 0); cmd != "./syz-fuzzer -v=1" {
+		t.Fatalf("command is changed without limit: %q", cmd)
+	}
+}
+
+func TestMemoryCgroupCmd(t *testing.T) {
+	tests := []struct {
+		name  string
+		v2    bool              // cgroup2 is mounted (otherwise cgroup v1 memory controller)
+		files map[string]string // expected files in cgroup fs
+	}{
+		{
+			name: "v2",
+			v2:   true,
+			files: map[string]string{
+				"cgroup.subtree_control":    "+memory\n",
+				"syz-executor/memory.max":   "268435456\n",
+				"syz-executor/cgroup.procs": "",
+			},
+		},
+		{
+			name: "v1",
+			files: map[string]string{
+				"memory/syz-executor/memory.limit_in_bytes": "268435456\n",
+				"memory/syz-executor/cgroup.procs":          "",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "syz-memcg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			root := filepath.Join(dir, "cgroup")
+			if test.v2 {
+				osutil.MkdirAll(root)
+				osutil.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpu memory\n"))
+			} else {
+				osutil.MkdirAll(filepath.Join(root, "memory"))
+			}
+			// Fake unshare, we can't create cgroup namespaces in tests.
+			bin := filepath.Join(dir, "bin")
+			osutil.MkdirAll(bin)
+			unshare := "#!/bin/sh\n[ \"$1\" = \"-C\" ] && shift && echo unshared\nexec \"$@\"\n"
+			if err := ioutil.WriteFile(filepath.Join(bin, "unshare"), []byte(unshare), 0755); err != nil {
+				t.Fatal(err)
+			}
+			cmd := memoryCgroupCmd(`echo "done" '$x'`, 256, root)
+			c := osutil.Command("sh", "-c", cmd)
+			c.Env = append(os.Environ(), "PATH="+bin+":"+os.Getenv("PATH"))
+			out, err := c.CombinedOutput()
+			if err != nil {
+				t.Fatalf("command failed: %v\n%s", err, out)
+			}
+			want := "done $x\n"
+			if test.v2 {
+				want = "unshared\n" + want
+			}
+			if string(out) != want {
+				t.Fatalf("got output %q, want %q", out, want)
+			}
+			for file, content := range test.files {
+				data, err := ioutil.ReadFile(filepath.Join(root, file))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if content == "" {
+					if strings.TrimSpace(string(data)) == "" {
+						t.Errorf("%v is empty", file)
+					}
+				} else if string(data) != content {
+					t.Errorf("%v contains %q, want %q", file, data, content)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package instance
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/vm"
+)
+
+// DegradedError is returned by CheckPerf if the VM is significantly slower than the baseline.
+type DegradedError struct {
+	Duration time.Duration
+	Limit    time.Duration
+}
+
+func (err *DegradedError) Error() string {
+	return fmt.Sprintf("degraded VM: perf gate benchmark took %v, limit %v", err.Duration, err.Limit)
+}
+
+// CheckPerf runs the post-boot benchmark (perf_gate) in the VM and returns its duration.
+// DegradedError is returned if the benchmark is slower than the baseline plus tolerance.
+func CheckPerf(inst *vm.Instance, gate mgrconfig.PerfGate) (time.Duration, error) {
+	limit := perfLimit(gate)
+	timeout := 10 * limit
+	if timeout < time.Minute {
+		timeout = time.Minute
+	}
+	start := time.Now()
+	output, err := runCommand(inst, timeout, gate.Command)
+	duration := time.Since(start)
+	if err != nil {
+		return 0, fmt.Errorf("failed to run perf gate benchmark: %v\n%s", err, output)
+	}
+	return duration, checkPerfDuration(duration, limit)
+}
+
+func perfLimit(gate mgrconfig.PerfGate) time.Duration {
+	return time.Duration(gate.Baseline) * time.Millisecond * time.Duration(100+gate.Tolerance) / 100
+}
+
+func checkPerfDuration(duration, limit time.Duration) error {
+	if duration <= limit {
+		return nil
+	}
+	return &DegradedError{Duration: duration, Limit: limit}
+}
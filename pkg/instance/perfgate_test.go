@@ -0,0 +1,35 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package instance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+)
+
+func TestCheckPerfDuration(t *testing.T) {
+	limit := perfLimit(mgrconfig.PerfGate{Baseline: 2000, Tolerance: 50})
+	if limit != 3*time.Second {
+		t.Fatalf("got limit %v, want 3s", limit)
+	}
+	if err := checkPerfDuration(2500*time.Millisecond, limit); err != nil {
+		t.Errorf("normal benchmark result is flagged: %v", err)
+	}
+	err := checkPerfDuration(5*time.Second, limit)
+	degraded, ok := err.(*DegradedError)
+	if !ok {
+		t.Fatalf("slow benchmark result is not flagged: %v", err)
+	}
+	if degraded.Duration != 5*time.Second || degraded.Limit != limit {
+		t.Errorf("bad error: %+v", degraded)
+	}
+	if want := "degraded VM: perf gate benchmark took 5s, limit 3s"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err.Error(), want)
+	}
+	if err := checkPerfDuration(2001*time.Millisecond, perfLimit(mgrconfig.PerfGate{Baseline: 2000})); err == nil {
+		t.Errorf("benchmark result over the baseline is not flagged with 0 tolerance")
+	}
+}
@@ -32,6 +32,7 @@ const (
 	FlagEnableTun                                       // initialize and use tun in executor
 	FlagEnableNetDev                                    // setup a bunch of various network devices for testing
 	FlagEnableFault                                     // enable fault injection support
+	FlagEnableProfile                                   // open kernel tracing control for FlagProfile
 	// Executor does not know about these:
 	FlagUseShmem      // use shared memory instead of pipes for communication
 	FlagUseForkServer // use extended protocol with handshake
@@ -47,6 +48,7 @@ const (
 	FlagCollectComps                       // collect KCOV comparisons
 	FlagThreaded                           // use multiple threads to mitigate blocked syscalls
 	FlagCollide                            // collide syscalls to provoke data races
+	FlagProfile                            // enable kernel tracing while the program runs
 )
 
 type ExecOpts struct {
@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"syscall"
 	"testing"
 	"time"
 
@@ -172,3 +173,99 @@ func TestParallel(t *testing.T) {
 		}
 	}
 }
+
+// TestIOUring executes generated io_uring programs on the host kernel and checks errno statistics
+// (rings are set up and sqes are queued), then checks that the kernel completes a submitted operation.
+func TestIOUring(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("io_uring is linux-only")
+	}
+	target, rs, iters, configFlags := initTest(t)
+	// Generated programs don't use io_uring_enter: they often block in it waiting for completions
+	// until the call timeout. Completion is checked with a fixed program instead.
+	enabled := make(map[*prog.Syscall]bool)
+	for _, name := range []string{"syz_io_uring_setup", "syz_io_uring_submit", "syz_io_uring_complete"} {
+		c := target.SyscallMap[name]
+		if c == nil {
+			t.Skipf("no %v syscall on %v", name, target.Arch)
+		}
+		enabled[c] = true
+	}
+	ct := target.BuildChoiceTable(nil, enabled)
+	bin := buildExecutor(t, target)
+	defer os.Remove(bin)
+	cfg := &Config{
+		Executor: bin,
+		Flags:    configFlags,
+		Timeout:  timeout,
+	}
+	env, err := MakeEnv(cfg, 0)
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer env.Close()
+	// Call name -> errno -> number of executions.
+	errnos := make(map[string]map[int]int)
+	for i := 0; i < iters; i++ {
+		p := target.Generate(rs, 10, ct)
+		output, info, failed, _, err := env.Exec(&ExecOpts{}, p)
+		if err != nil {
+			t.Fatalf("failed to run executor: %v", err)
+		}
+		if failed {
+			t.Fatalf("program failed:\n%s", output)
+		}
+		for j, inf := range info.Calls {
+			if inf.Flags&CallFinished == 0 {
+				continue
+			}
+			name := p.Calls[j].Meta.CallName
+			if errnos[name] == nil {
+				errnos[name] = make(map[int]int)
+			}
+			errnos[name][inf.Errno]++
+		}
+	}
+	for name, stats := range errnos {
+		t.Logf("%v: %v", name, stats)
+	}
+	setup := errnos["syz_io_uring_setup"]
+	if setup[0] == 0 && (setup[int(syscall.ENOSYS)] != 0 || setup[int(syscall.EPERM)] != 0) {
+		t.Skip("io_uring is not supported by the kernel")
+	}
+	successRate := func(name string) float64 {
+		total := 0
+		for _, n := range errnos[name] {
+			total += n
+		}
+		if total == 0 {
+			return 0
+		}
+		return float64(errnos[name][0]) / float64(total)
+	}
+	for _, name := range []string{"syz_io_uring_setup", "syz_io_uring_submit"} {
+		if rate := successRate(name); rate < 0.5 {
+			t.Errorf("%v succeeds only in %.0f%% of executions", name, rate*100)
+		}
+	}
+	// The kernel completes a submitted operation.
+	p, err := target.Deserialize([]byte(`r0 = syz_io_uring_setup(0x4, &(0x7f0000000000), &(0x7f0000000100)=<r1=>0x0)
+syz_io_uring_submit(r1, &(0x7f0000000200)=@nop={0x0})
+io_uring_enter(r0, 0x1, 0x1, 0x1, 0x0, 0x0)
+syz_io_uring_complete(r1)
+syz_io_uring_complete(r1)
+`), prog.NonStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, info, failed, _, err := env.Exec(&ExecOpts{}, p)
+	if err != nil || failed {
+		t.Fatalf("failed to run executor: %v\n%s", err, output)
+	}
+	// The nop completes with 0, then the CQ is empty.
+	for i, want := range []int{0, 0, 0, 0, int(syscall.EAGAIN)} {
+		if i >= len(info.Calls) || info.Calls[i].Flags&CallFinished == 0 || info.Calls[i].Errno != want {
+			t.Fatalf("call %v: want errno %v, got %+v", p.Calls[i].Meta.Name, want, info.Calls)
+		}
+	}
+}
@@ -6,6 +6,7 @@
 //  - global verbosity setting that can be used by multiple packages
 //  - ability to disable all output
 //  - ability to cache recent output in memory
+//  - rate limiting of identical messages
 package log
 
 import (
@@ -13,6 +14,7 @@ import (
 	"flag"
 	"fmt"
 	golog "log"
+	"regexp"
 	"sync"
 	"time"
 )
@@ -25,8 +27,16 @@ var (
 	cachePos     int
 	cacheEntries []string
 	prependTime  = true // for testing
+
+	rateLimit  int                   // identical messages per component per minute, 0 means no limit
+	rateStates map[string]*rateState // component + format -> state
+	coalesced  uint64                // messages that were not printed due to rate limiting
+	timeNow    = time.Now            // for testing
 )
 
+// rateLimitWindow is the period rateLimit applies to.
+const rateLimitWindow = time.Minute
+
 // EnableCaching enables in memory caching of log output.
 // Caches up to maxLines, but no more than maxMem bytes.
 // Cached output can later be queried with CachedOutput.
@@ -59,37 +69,167 @@ func CachedLogOutput() string {
 	return buf.String()
 }
 
-func Logf(v int, msg string, args ...interface{}) {
+// EnableRateLimiting limits the number of identical messages (messages with the same format string
+// from the same component, see messageComponent) to perMinute per minute. Messages beyond the limit
+// are not printed, instead a single "message repeated K times" line is printed when the minute ends.
+// Messages logged with Crashf are never limited.
+func EnableRateLimiting(perMinute int) {
+	mu.Lock()
+	defer mu.Unlock()
+	if rateStates != nil {
+		Fatalf("log rate limiting is already enabled")
+	}
+	if perMinute <= 0 {
+		return
+	}
+	rateLimit = perMinute
+	rateStates = make(map[string]*rateState)
+	go func() {
+		for range time.NewTicker(rateLimitWindow / 6).C {
+			flushRateLimits()
+		}
+	}()
+}
+
+// CoalescedMessages returns the number of messages that were not printed due to rate limiting.
+func CoalescedMessages() uint64 {
 	mu.Lock()
+	defer mu.Unlock()
+	return coalesced
+}
+
+func Logf(v int, msg string, args ...interface{}) {
+	logf(v, false, msg, args...)
+}
+
+// Crashf logs a crash-related message at verbosity 0.
+// Such messages are always logged verbatim, they are never rate limited.
+func Crashf(msg string, args ...interface{}) {
+	logf(0, true, msg, args...)
+}
+
+func logf(v int, verbatim bool, msg string, args ...interface{}) {
 	doLog := v <= *flagV
-	if cacheEntries != nil && v <= 1 {
-		cacheMem -= len(cacheEntries[cachePos])
-		if cacheMem < 0 {
-			panic("log cache size underflow")
+	doCache := v <= 1
+	if !doLog && !doCache {
+		return
+	}
+	lines := []string{fmt.Sprintf(msg, args...)}
+	mu.Lock()
+	if rateLimit != 0 && !verbatim {
+		lines = rateLimitLocked(v, msg, lines[0])
+	}
+	if cacheEntries != nil && doCache {
+		for _, line := range lines {
+			cacheLocked(line)
 		}
-		timeStr := ""
-		if prependTime {
-			timeStr = time.Now().Format("2006/01/02 15:04:05 ")
+	}
+	mu.Unlock()
+
+	if doLog {
+		for _, line := range lines {
+			golog.Print(line)
 		}
-		cacheEntries[cachePos] = fmt.Sprintf(timeStr+msg, args...)
-		cacheMem += len(cacheEntries[cachePos])
-		cachePos++
-		if cachePos == len(cacheEntries) {
-			cachePos = 0
+	}
+}
+
+type rateState struct {
+	v        int
+	start    time.Time // start of the current window
+	count    int       // messages in the current window
+	repeated int       // messages that were not printed in the current window
+	last     string    // the last message that was not printed
+}
+
+// Component is the prefix of the message up to the first colon if it's a single word,
+// e.g. "vm-3" for "vm-3: failed to copy binary".
+var componentRe = regexp.MustCompile(`^[a-zA-Z0-9_.\-]+:`)
+
+func messageComponent(text string) string {
+	return componentRe.FindString(text)
+}
+
+// rateLimitLocked returns lines that need to be logged for the message: the message itself
+// if it is within the limit, preceded by the summary of the previous window (if any).
+func rateLimitLocked(v int, msg, text string) []string {
+	key := messageComponent(text) + "\x00" + msg
+	now := timeNow()
+	var lines []string
+	st := rateStates[key]
+	if st == nil || now.Sub(st.start) >= rateLimitWindow {
+		if st != nil && st.repeated != 0 {
+			lines = append(lines, st.summary())
+		}
+		st = &rateState{v: v, start: now}
+		rateStates[key] = st
+	}
+	st.count++
+	if st.count > rateLimit {
+		st.repeated++
+		st.last = text
+		coalesced++
+		return lines
+	}
+	return append(lines, text)
+}
+
+func (st *rateState) summary() string {
+	return fmt.Sprintf("%v [message repeated %v times]", st.last, st.repeated)
+}
+
+// flushRateLimits logs summaries of windows that have ended and forgets their states.
+func flushRateLimits() {
+	type summary struct {
+		v    int
+		line string
+	}
+	var summaries []summary
+	mu.Lock()
+	now := timeNow()
+	for key, st := range rateStates {
+		if now.Sub(st.start) < rateLimitWindow {
+			continue
 		}
-		for i := 0; i < len(cacheEntries)-1 && cacheMem > cacheMaxMem; i++ {
-			pos := (cachePos + i) % len(cacheEntries)
-			cacheMem -= len(cacheEntries[pos])
-			cacheEntries[pos] = ""
+		delete(rateStates, key)
+		if st.repeated == 0 {
+			continue
 		}
-		if cacheMem < 0 {
-			panic("log cache size underflow")
+		line := st.summary()
+		summaries = append(summaries, summary{st.v, line})
+		if cacheEntries != nil && st.v <= 1 {
+			cacheLocked(line)
 		}
 	}
 	mu.Unlock()
+	for _, s := range summaries {
+		if s.v <= *flagV {
+			golog.Print(s.line)
+		}
+	}
+}
 
-	if doLog {
-		golog.Printf(msg, args...)
+func cacheLocked(line string) {
+	cacheMem -= len(cacheEntries[cachePos])
+	if cacheMem < 0 {
+		panic("log cache size underflow")
+	}
+	timeStr := ""
+	if prependTime {
+		timeStr = time.Now().Format("2006/01/02 15:04:05 ")
+	}
+	cacheEntries[cachePos] = timeStr + line
+	cacheMem += len(cacheEntries[cachePos])
+	cachePos++
+	if cachePos == len(cacheEntries) {
+		cachePos = 0
+	}
+	for i := 0; i < len(cacheEntries)-1 && cacheMem > cacheMaxMem; i++ {
+		pos := (cachePos + i) % len(cacheEntries)
+		cacheMem -= len(cacheEntries[pos])
+		cacheEntries[pos] = ""
+	}
+	if cacheMem < 0 {
+		panic("log cache size underflow")
 	}
 }
 
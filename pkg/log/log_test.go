@@ -5,6 +5,7 @@ package log
 
 import (
 	"testing"
+	"time"
 )
 
 func init() {
@@ -33,3 +34,60 @@ func TestCaching(t *testing.T) {
 		}
 	}
 }
+
+func TestRateLimiting(t *testing.T) {
+	defer func(limit int, states map[string]*rateState, now func() time.Time, entries []string, mem, pos int) {
+		rateLimit, rateStates, timeNow = limit, states, now
+		cacheEntries, cacheMem, cachePos = entries, mem, pos
+	}(rateLimit, rateStates, timeNow, cacheEntries, cacheMem, cachePos)
+	rateLimit = 2
+	rateStates = make(map[string]*rateState)
+	cacheEntries, cacheMem, cachePos = make([]string, 100), 0, 0
+	cacheMaxMem = 1 << 20
+	prependTime = false
+	start := time.Now()
+	now := start
+	timeNow = func() time.Time { return now }
+	for i := 0; i < 5; i++ {
+		Logf(0, "vm-%v: failed to ssh: %v", 0, i)
+		Logf(0, "vm-%v: failed to ssh: %v", 1, i)
+		Crashf("vm-%v: crash: %v", 0, i)
+	}
+	Logf(0, "vm-%v: failed to copy: %v", 0, 0)
+	want := `vm-0: failed to ssh: 0
+vm-1: failed to ssh: 0
+vm-0: crash: 0
+vm-0: failed to ssh: 1
+vm-1: failed to ssh: 1
+vm-0: crash: 1
+vm-0: crash: 2
+vm-0: crash: 3
+vm-0: crash: 4
+vm-0: failed to copy: 0
+`
+	if got := CachedLogOutput(); got != want {
+		t.Fatalf("got log:\n%v\nwant:\n%v", got, want)
+	}
+	if got := CoalescedMessages(); got != 6 {
+		t.Fatalf("got %v coalesced messages, want 6", got)
+	}
+	// The next window of vm-0 starts with the summary of the previous one,
+	// the summary of vm-1 is printed when the window ends.
+	now = start.Add(rateLimitWindow)
+	Logf(0, "vm-%v: failed to ssh: %v", 0, 5)
+	flushRateLimits()
+	want += `vm-0: failed to ssh: 4 [message repeated 3 times]
+vm-0: failed to ssh: 5
+vm-1: failed to ssh: 4 [message repeated 3 times]
+`
+	if got := CachedLogOutput(); got != want {
+		t.Fatalf("got log:\n%v\nwant:\n%v", got, want)
+	}
+	if len(rateStates) != 1 {
+		t.Fatalf("states of ended windows are not forgotten: %v", len(rateStates))
+	}
+	flushRateLimits()
+	if got := CachedLogOutput(); got != want {
+		t.Fatalf("summaries are printed twice:\n%v", got)
+	}
+}
@@ -8,6 +8,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/google/syzkaller/pkg/config"
@@ -31,6 +34,10 @@ type Config struct {
 	KernelObj string `json:"kernel_obj"`
 	// Kernel source directory (if not set defaults to KernelObj).
 	KernelSrc string `json:"kernel_src"`
+	// Template of links to kernel sources for stack frames on the crash page of the web UI,
+	// "{file}" and "{line}" are replaced with the source location of the frame
+	// (e.g. "https://elixir.bootlin.com/linux/latest/source/{file}#L{line}", optional).
+	KernelSourceURL string `json:"kernel_source_url"`
 	// Arbitrary optional tag that is saved along with crash reports (e.g. branch/commit).
 	Tag string `json:"tag"`
 	// Linux image for VMs.
@@ -50,11 +57,21 @@ type Config struct {
 	DashboardClient string `json:"dashboard_client"`
 	DashboardAddr   string `json:"dashboard_addr"`
 	DashboardKey    string `json:"dashboard_key"`
+	// After the first crash with a title is uploaded to dashboard, further crashes with the title
+	// are collected for that many seconds and uploaded as a single crash with the number of occurrences
+	// (default: 0, upload every crash).
+	DashboardBatchPeriod int `json:"dashboard_batch_period"`
+	// Upload crashes with the same title at most once per that many seconds,
+	// crashes in between are counted in the next upload (default: 0, no limit).
+	DashboardTitleInterval int `json:"dashboard_title_interval"`
 
 	// Path to syzkaller checkout (syz-manager will look for binaries in bin subdir).
 	Syzkaller string `json:"syzkaller"`
 	// Number of parallel processes inside of every VM.
 	Procs int `json:"procs"`
+	// Let the fuzzer choose the number of processes at startup from the guest's online CPUs
+	// and available memory within bounds (for pools of differently sized VMs, see AdaptiveProcs).
+	AdaptiveProcs AdaptiveProcs `json:"adaptive_procs"`
 
 	// Type of sandbox to use during fuzzing:
 	// "none": don't do anything special (has false positives, e.g. due to killing init), default
@@ -65,6 +82,29 @@ type Config struct {
 	// "android_untrusted_app": (Android) Emulate permissions of an untrusted app
 	Sandbox string `json:"sandbox"`
 
+	// Shell commands executed in the VM before/after each test program
+	// (e.g. to reset state of the fuzzed subsystem: "umount -a -t ntfs3; losetup -D").
+	// Note: with procs > 1 the commands run concurrently with other test programs.
+	// C reproducers contain the commands as well, unless reproduction finds them unnecessary.
+	ProgPrologue string `json:"prog_prologue"`
+	ProgEpilogue string `json:"prog_epilogue"`
+	// Timeout for prog_prologue/prog_epilogue commands in seconds (default: 10).
+	ProgHookTimeout int `json:"prog_hook_timeout"`
+	// What to do if prog_prologue/prog_epilogue command fails or times out:
+	// "continue": log the failure and continue fuzzing, default
+	// "restart": restart the VM
+	ProgHookFailure string `json:"prog_hook_failure"`
+
+	// Run syz-fuzzer/syz-execprog with the executor in a memory cgroup limited to that many MB
+	// (Linux only, default: 0, no limit). OOMs are then scoped to the executor instead of taking down
+	// the whole VM. Supports cgroup v2 (requires unshare in the image) and the cgroup v1 memory controller.
+	ExecutorMemoryLimit int `json:"executor_memory_limit"`
+	// Confine syz-fuzzer/syz-execprog and the executor to these guest CPUs with taskset
+	// (Linux only, default: empty, all CPUs), e.g. "0,2" or "0-1". Combined with a matching number
+	// of VM CPUs this helps to reproduce races that depend on CPU topology.
+	// This is unrelated to pinning of VMs to host CPUs/NUMA nodes.
+	GuestCPUSet string `json:"guest_cpuset"`
+
 	// Use KCOV coverage (default: true).
 	Cover bool `json:"cover"`
 	// Reproduce, localize and minimize crashers (default: true).
@@ -78,6 +118,138 @@ type Config struct {
 	// Completely ignore reports matching these regexps (don't save nor reboot),
 	// must match the first line of crash message.
 	Ignores []string `json:"ignores"`
+	// Don't save crashes matching these rules, but reboot VM after them (see ScopedSuppression).
+	// Unlike suppressions, the rules can be limited to some VM instances and/or kernels,
+	// e.g. to ignore a known hardware-triggered oops on one board of a mixed pool.
+	ScopedSuppressions []ScopedSuppression `json:"scoped_suppressions"`
+	// Severity of crashes by title (see SeverityRule), the first matching rule wins.
+	// The rules are checked before the built-in rules for common classes of crashes,
+	// crashes that don't match any rule get SeverityMedium.
+	Severities []SeverityRule `json:"severities"`
+	// Console output signatures of security-relevant events that are not crashes (see SecurityEvent),
+	// e.g. a process appearing in the host network namespace when fuzzing container code.
+	SecurityEvents []SecurityEvent `json:"security_events"`
+	// Reporter for crashes of the outer layer of a hybrid stack (e.g. "linux" for the host kernel
+	// when fuzzing gVisor or a unikernel on top of KVM). It is consulted when the primary reporter
+	// (selected by target OS/VM type) does not find a crash in the output (default: none).
+	SecondaryReporter string `json:"secondary_reporter"`
+	// Replace exact repeats of blocks of console output lines with a line with the number
+	// of repeats (useful if the kernel floods console with the same message, default: false).
+	// Lines are compared ignoring console timestamps, the first instance is always preserved.
+	DedupOutput bool `json:"dedup_output"`
+	// Record console output of VM runs in a binary framed format with the time each chunk of output
+	// arrived (default: false). Recordings of crashes are saved as console<n>.timed in crash dirs
+	// (and console.timed in crash bundles) and can be replayed through the console monitor at the original
+	// or accelerated speed to re-detect the crash deterministically (see vm.Pool.MonitorTimedConsole).
+	TimedConsoleLog bool `json:"timed_console_log"`
+	// Upload syz-executor once into a location shared by all VMs (e.g. a host directory
+	// exported to qemu VMs over 9p) instead of copying it into every VM (default: false).
+	// Only qemu supports sharing, other VM types (including gce) fall back to copying.
+	SharedExecutor bool `json:"shared_executor"`
+	// Expected sha256 of syz-executor in VMs (default: hash of the syz-executor binary that is deployed).
+	// The executor is checked in every VM before fuzzing/testing starts, stale or truncated
+	// executors (e.g. in hand-built images) lead to failures that look like kernel bugs.
+	ExecutorHash string `json:"executor_hash"`
+	// Only log executor hash mismatches instead of failing (for development with custom executors).
+	AllowExecutorMismatch bool `json:"allow_executor_mismatch"`
+	// After a crash, reboot the VM and read pstore records left by the crashed kernel (default: false).
+	// This allows to recover panics that did not make it to the console (e.g. when a hung kernel
+	// was reset by a hardware/soft watchdog). The records are attached to the crash report.
+	// The kernel needs to be configured to keep pstore records across reboots (e.g. ramoops).
+	// VM types that don't support this ignore it.
+	ReadPstore bool `json:"read_pstore"`
+	// Capture guest memory state (/proc/meminfo, top /proc/slabinfo caches and /proc/vmstat deltas
+	// since the start of the run) when a crash or a hang is detected, and save it in crash metadata.
+	// Collection is best-effort: it's skipped if the connection to the VM is lost
+	// and abandoned after a short timeout. VM types that can't read guest files ignore it.
+	CrashMemState bool `json:"crash_mem_state"`
+	// Read the kernel ftrace ring buffer when a crash or a hang is detected and save it with the crash
+	// (default: false). Tracing must be enabled in the image or on the kernel command line beforehand
+	// (e.g. ftrace=function_graph), the buffer is only read. VM types that can't run commands ignore it.
+	CrashFtrace bool `json:"crash_ftrace"`
+	// Record the kernel command line the VM was booted with in every crash report, including
+	// no-output and lost connection reports (default: false). The command line is taken from the VM
+	// configuration on the host, not from the guest, so a malformed command line that makes the kernel
+	// boot silently can be spotted. VM types that don't control the kernel command line ignore it.
+	CrashCmdline bool `json:"crash_cmdline"`
+	// Strategy that chooses where instances of VM pools that span several hosts or zones are created
+	// (isolated targets, gce zones): "round-robin", "least-loaded" or "zone-balanced"
+	// (default: empty, VM types place instances by their index).
+	Placement string `json:"placement"`
+	// Check that VMs can connect to the forwarded manager port before starting the fuzzer
+	// (a quick TCP connect test with nc or bash in the VM), so that networking problems
+	// (firewall, wrong host interface) fail fast with a clear error instead of lost connections later.
+	VerifyForward bool `json:"verify_forward"`
+	// Console output strings that mean that the fuzzer was preempted (e.g. by the host),
+	// runs that print them are abandoned without reporting crashes.
+	// Useful for non-linux targets and custom executors, "SYZ-FUZZER: PREEMPTED" printed
+	// by syz-fuzzer is always recognized.
+	PreemptionMarkers []string `json:"preemption_markers"`
+	// How long to wait for the first console output after the fuzzer/test is started in a VM, in seconds
+	// (default: 0, the usual no output timeout is used). Useful for slow-booting images:
+	// once some output appears, the usual (shorter) no output timeout detects hangs.
+	FirstOutputTimeout int `json:"first_output_timeout"`
+	// Raise a coverage alert if average signal per program execution is lower than this
+	// (default: 0, disabled). Alerts are shown in the web UI, written to the bench file and emailed.
+	MinExecSignal int `json:"min_exec_signal"`
+	// Raise a coverage alert if average signal per program execution drops by this factor
+	// compared to the historical baseline of the same kernel, or of the previous kernel
+	// right after a kernel/image change (default: 4, 0 to disable).
+	SignalDropFactor int `json:"signal_drop_factor"`
+	// After a VM reports a crash, crashes with the same title from the same VM (index)
+	// are suppressed (only logged) for that many seconds (default: 0, disabled).
+	// Reduces duplicate reports from VMs that re-hit the same bug right after reboot,
+	// crashes with the same title from other VMs are not affected.
+	CrashCooldown int `json:"crash_cooldown"`
+	// Maximum number of crash reports emitted by VMs per minute (default: 0, unlimited).
+	// Protects the manager, the dashboard and their storage during crash storms: excess reports
+	// are only counted and added to repeats of the next report with the same title.
+	// Titles that were not reported in the current minute are prioritized over repeats.
+	MaxReportsPerMinute int `json:"max_reports_per_minute"`
+	// Maximum number of identical messages (same format, same component, e.g. "vm-3") in the manager log
+	// per minute (e.g. 20, default: 0, no limit). Excess messages are coalesced into a single
+	// "message repeated K times" line. Crash messages are never limited.
+	LogRateLimit int `json:"log_rate_limit"`
+	// Flag VMs which exec rate is that many times lower than the median rate of all VMs
+	// (e.g. because of bad host NUMA placement or a degraded disk) on the VMs page
+	// (default: 4, 0 to disable).
+	SlowVMFactor int `json:"slow_vm_factor"`
+	// Run a quick benchmark in each VM after boot and flag (and optionally quarantine) VMs
+	// that are significantly slower than the baseline, e.g. on thermally throttled or
+	// overcommitted hosts (see PerfGate).
+	PerfGate PerfGate `json:"perf_gate"`
+	// HTTP addresses of sibling managers (for the same kernel family), or local paths to their
+	// exported reproducer bundles (saved /api/repros output) or crashes dirs. On start, reproducers
+	// of the siblings are validated and triaged before the corpus, crashes caused by them are marked
+	// as "seeded from <manager>/<title>" to distinguish rediscovery from new findings.
+	SiblingManagers []string `json:"sibling_managers"`
+	// Sample guest state files during fuzzing and report values that grow suspiciously
+	// (a cheap memory leak signal without a sanitizer, see LeakWatch).
+	LeakWatch LeakWatch `json:"leak_watch"`
+	// Save every crash detected on VMs as a self-contained bundle directory
+	// (report, console log, machine info and artifacts, see BundleCrashes).
+	BundleCrashes BundleCrashes `json:"bundle_crashes"`
+	// Append outcomes of all VM runs (crashes and non-crash outcomes) with machine info
+	// to a file as newline-delimited JSON for offline analysis (see ReportLog).
+	ReportLog ReportLog `json:"report_log"`
+	// Don't restart VMs on non-fatal kernel warnings and rate limit their reports (see Warnings).
+	Warnings Warnings `json:"warnings"`
+	// Tell fatal kernel oopses (e.g. BUG_ON) from non-fatal ones (e.g. WARN_ON) by whether
+	// the fuzzer keeps executing programs after the oops (see LivenessCheck).
+	LivenessCheck LivenessCheck `json:"liveness_check"`
+	// Report console output that looks like a kernel crash, but is not recognized by the reporter,
+	// with a generic "unrecognized crash" title instead of losing or mislabeling it (see UnrecognizedCrashes).
+	UnrecognizedCrashes UnrecognizedCrashes `json:"unrecognized_crashes"`
+	// Corpora of managers that fuzz the same kernel on other architectures (see ForeignCorpus).
+	// Their programs are translated to the target of this manager and triaged as candidates.
+	ForeignCorpora []ForeignCorpus `json:"foreign_corpora"`
+	// Trace a sample of program executions in VMs with ftrace or perf and send kernel profiles
+	// of slow programs to the manager (see SlowProfiles). Profiles are shown on the /profiles page.
+	SlowProfiles SlowProfiles `json:"slow_profiles"`
+	// PRNG seed for debugging of syzkaller itself (0 by default, i.e. random).
+	// If set, seeds of fuzzers and their procs are derived from it deterministically,
+	// so generation/mutation decisions are reproducible given the same corpus.
+	Seed int64 `json:"seed"`
 
 	// VM type (qemu, gce, android, isolated, etc).
 	Type string `json:"type"`
@@ -133,6 +305,8 @@ func LoadPartialFile(filename string) (*Config, error) {
 	return loadPartial(cfg)
 }
 
+var executorHashRe = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
 func defaultValues() *Config {
 	return &Config{
 		SSHUser:   "root",
@@ -141,6 +315,35 @@ func defaultValues() *Config {
 		Sandbox:   "none",
 		RPC:       ":0",
 		Procs:     1,
+
+		SignalDropFactor: 4,
+		SlowVMFactor:     4,
+		AdaptiveProcs: AdaptiveProcs{
+			MemPerProc: 256,
+		},
+		PerfGate: PerfGate{
+			Tolerance: 50,
+		},
+		LeakWatch: LeakWatch{
+			Period:    600,
+			Growth:    100,
+			MinGrowth: 1000,
+		},
+		Warnings: Warnings{
+			Window: 60,
+		},
+		ReportLog: ReportLog{
+			MaxSize: 100,
+			Backups: 3,
+		},
+		SlowProfiles: SlowProfiles{
+			Sample:    1000,
+			Threshold: 1000,
+			MaxSize:   1024,
+		},
+
+		ProgHookTimeout: 10,
+		ProgHookFailure: "continue",
 	}
 }
 
@@ -153,6 +356,165 @@ func loadPartial(cfg *Config) (*Config, error) {
 	return cfg, nil
 }
 
+// LeakWatch configures periodic sampling of guest state (e.g. /proc/slabinfo) during fuzzing.
+// Values that grow monotonically between all samples of a run by more than the thresholds
+// are reported as a suspicious memory growth with the before/after diff attached.
+type LeakWatch struct {
+	// Files in the VM to sample, e.g. /proc/slabinfo, /proc/meminfo, /proc/vmallocinfo
+	// (default: none, i.e. disabled).
+	Files []string `json:"files"`
+	// Sampling period in seconds (default: 600).
+	Period int `json:"period"`
+	// Minimal growth in percent of the first sample (default: 100).
+	Growth int `json:"growth"`
+	// Minimal absolute growth, filters out noise in small values (default: 1000).
+	MinGrowth int64 `json:"min_growth"`
+}
+
+// AdaptiveProcs configures the number of fuzzer processes chosen in each VM at startup:
+// a process per online CPU, but no more than available memory allows, within [Min, Max].
+// Reproduction of crashes uses the number of processes of the VM where the crash happened.
+type AdaptiveProcs struct {
+	// Bounds on the number of processes (default: 0, i.e. procs is used in all VMs).
+	Min int `json:"min"`
+	Max int `json:"max"`
+	// Guest memory in MB required by a process (default: 256).
+	MemPerProc int `json:"mem_per_proc"`
+}
+
+// PerfGate configures the post-boot benchmark. The command runs in the VM before the fuzzer,
+// VMs where it takes longer than Baseline plus Tolerance percent are flagged as degraded.
+type PerfGate struct {
+	// Benchmark command, e.g. a fixed CPU loop (default: none, i.e. disabled).
+	Command string `json:"command"`
+	// Expected duration of the command in milliseconds (including the overhead of running
+	// a command in the VM, e.g. ssh connection).
+	Baseline int `json:"baseline"`
+	// Allowed slowdown in percent of the baseline (default: 50).
+	Tolerance int `json:"tolerance"`
+	// Don't use degraded VMs for that many seconds, then recreate them
+	// (default: 0, i.e. degraded VMs are only flagged).
+	Quarantine int `json:"quarantine"`
+}
+
+// BundleCrashes configures crash bundles: on crash detection a crash-<n> directory is created in Dir
+// with report.json, console.log, machine-info.json and artifacts/ (files produced by the VM
+// implementation, e.g. output of instrumentation, and files copied out of the VM).
+type BundleCrashes struct {
+	// Destination directory (default: none, i.e. disabled).
+	Dir string `json:"dir"`
+	// Files in the VM to copy into artifacts/, e.g. /var/log/syslog (optional).
+	GuestFiles []string `json:"guest_files"`
+}
+
+// ReportLog configures the NDJSON log of VM run outcomes: each line is a JSON object with the outcome
+// (crash, suppressed, exit, timeout or recycle), timestamps, the parsed report (for crashes) and machine info.
+// When the file grows over MaxSize it's rotated: file is renamed to file.1, file.1 to file.2 and so on.
+type ReportLog struct {
+	// Destination file (default: none, i.e. disabled).
+	File string `json:"file"`
+	// Size in MB after which the file is rotated (default: 100).
+	MaxSize int `json:"max_size"`
+	// Number of rotated files to keep (default: 3).
+	Backups int `json:"backups"`
+}
+
+// Warnings configures rate limiting of non-fatal kernel warnings (WARNING reports not followed by a panic).
+// After a warning the VM keeps running for Window seconds while repeats of the warning are counted,
+// then the warning is reported with the number of repeats. Each distinct warning is reported at most
+// once per Period seconds, repeats in between are counted and added to the next report of the warning.
+type Warnings struct {
+	// Reporting period in seconds (default: 0, i.e. disabled, warnings are reported as other crashes).
+	Period int `json:"period"`
+	// Counting window in seconds (default: 60).
+	Window int `json:"window"`
+}
+
+// LivenessCheck configures detection of fatality of kernel oopses. Without panic_on_warn/oops=panic
+// fatal and non-fatal oopses look alike, so after an oops with a matching title the console is watched
+// for Timeout seconds: the oops is non-fatal if the fuzzer executes more programs, and fatal otherwise.
+type LivenessCheck struct {
+	// Watch timeout in seconds (default: 0, i.e. disabled).
+	Timeout int `json:"timeout"`
+	// Regexps of titles of oopses to check (default: WARNING and BUG oopses).
+	Titles []string `json:"titles"`
+}
+
+// UnrecognizedCrashes configures the heuristic that detects crashes the reporter does not recognize:
+// output looks like a crash if lines matching at least Threshold different markers (e.g. register dumps,
+// RIP: lines, stack traces) are printed close to each other.
+type UnrecognizedCrashes struct {
+	// Number of different markers that must match (default: 0, i.e. disabled).
+	Threshold int `json:"threshold"`
+	// Regexps of lines typical for crash output (default: x86/arm64 register dumps, RIP:, Call Trace:, Code:).
+	Markers []string `json:"markers"`
+}
+
+// ForeignCorpus is a corpus of a manager for another arch of the same OS. Programs are translated
+// to the target of this manager: calls that don't exist in this target are dropped (see prog.Target.Translate).
+type ForeignCorpus struct {
+	// Target of the corpus in the same format as target, e.g. "linux/arm64".
+	Target string `json:"target"`
+	// Corpus database of the other manager (its workdir/corpus.db) loaded at startup (optional).
+	Corpus string `json:"corpus"`
+	// HTTP address of the other manager to periodically fetch its new corpus inputs from (optional).
+	// Configure both managers with each other to exchange inputs in both directions.
+	Manager string `json:"manager"`
+}
+
+// SlowProfiles configures sampled kernel profiling of program executions (linux only).
+// Sampled executions run with tracing enabled (the executor toggles ftrace around the program,
+// or perf record runs system-wide during the execution), profiles of executions that take longer
+// than Threshold are sent to the manager. Profiling is disabled in VMs where the tracer is unavailable.
+type SlowProfiles struct {
+	// Tracer: "ftrace" (function_graph tracer, requires tracefs) or "perf" (perf binary in the image)
+	// (default: none, i.e. disabled).
+	Tracer string `json:"tracer"`
+	// Trace 1 of that many program executions (default: 1000).
+	Sample int `json:"sample"`
+	// Minimal program execution time in milliseconds to send the profile (default: 1000).
+	Threshold int `json:"threshold"`
+	// Maximal size of a profile in KB, longer profiles are truncated (default: 1024).
+	// For ftrace it's also the size of the per-CPU trace buffer.
+	MaxSize int `json:"max_size"`
+}
+
+// ScopedSuppression suppresses crashes which title matches Title on VM instances
+// with the given indexes and/or on kernels which release matches Kernel.
+type ScopedSuppression struct {
+	// Regexp matched against crash title.
+	Title string `json:"title"`
+	// Comma-separated list of VM index ranges, e.g. "0-3,7" (default: all instances).
+	Instances string `json:"instances"`
+	// Regexp matched against kernel release extracted from the crash report, e.g. "4\\.14\\." (optional).
+	// Crashes without a kernel release in the report don't match rules with this attribute.
+	Kernel string `json:"kernel"`
+}
+
+// SeverityRule assigns Severity to crashes which title matches Title.
+type SeverityRule struct {
+	// Regexp matched against crash title.
+	Title string `json:"title"`
+	// One of SeverityCritical, SeverityHigh, SeverityMedium, SeverityLow.
+	Severity string `json:"severity"`
+}
+
+const (
+	SeverityCritical = "critical"
+	SeverityHigh     = "high"
+	SeverityMedium   = "medium"
+	SeverityLow      = "low"
+)
+
+// SecurityEvent is a signature of a security event: console output lines that match Regexp
+// end the run with a report of report.ClassSecurityEvent class titled "SECURITY: <Name>".
+type SecurityEvent struct {
+	// Short name of the event used in report titles (e.g. "netns escape").
+	Name string `json:"name"`
+	// Regexp matched against console output lines.
+	Regexp string `json:"regexp"`
+}
+
 func Complete(cfg *Config) error {
 	if cfg.TargetOS == "" || cfg.TargetVMArch == "" || cfg.TargetArch == "" {
 		return fmt.Errorf("target parameters are not filled in")
@@ -181,6 +543,17 @@ func Complete(cfg *Config) error {
 	default:
 		return fmt.Errorf("config param sandbox must contain one of none/setuid/namespace/android_untrusted_app")
 	}
+	if cfg.ProgHookTimeout <= 0 {
+		return fmt.Errorf("bad config param prog_hook_timeout: %v", cfg.ProgHookTimeout)
+	}
+	if cfg.FirstOutputTimeout < 0 {
+		return fmt.Errorf("bad config param first_output_timeout: %v", cfg.FirstOutputTimeout)
+	}
+	switch cfg.ProgHookFailure {
+	case "continue", "restart":
+	default:
+		return fmt.Errorf("config param prog_hook_failure must contain one of continue/restart")
+	}
 	if err := checkSSHParams(cfg); err != nil {
 		return err
 	}
@@ -190,6 +563,12 @@ func Complete(cfg *Config) error {
 		cfg.KernelSrc = cfg.KernelObj // assume in-tree build by default
 	}
 	cfg.KernelSrc = osutil.Abs(cfg.KernelSrc)
+	if cfg.KernelSourceURL != "" && !strings.Contains(cfg.KernelSourceURL, "{file}") {
+		return fmt.Errorf("config param kernel_source_url must contain {file}")
+	}
+	if cfg.BundleCrashes.Dir != "" {
+		cfg.BundleCrashes.Dir = osutil.Abs(cfg.BundleCrashes.Dir)
+	}
 	if cfg.HubClient != "" && (cfg.Name == "" || cfg.HubAddr == "" || cfg.HubKey == "") {
 		return fmt.Errorf("hub_client is set, but name/hub_addr/hub_key is empty")
 	}
@@ -198,6 +577,118 @@ func Complete(cfg *Config) error {
 		cfg.DashboardKey == "") {
 		return fmt.Errorf("dashboard_client is set, but name/dashboard_addr/dashboard_key is empty")
 	}
+	if cfg.MinExecSignal < 0 || cfg.SignalDropFactor < 0 || cfg.SignalDropFactor == 1 {
+		return fmt.Errorf("bad min_exec_signal/signal_drop_factor: %v/%v, want >= 0/0 or >= 2",
+			cfg.MinExecSignal, cfg.SignalDropFactor)
+	}
+	if cfg.ExecutorHash != "" && !executorHashRe.MatchString(cfg.ExecutorHash) {
+		return fmt.Errorf("bad executor_hash %q, want sha256 in hex", cfg.ExecutorHash)
+	}
+	for _, marker := range cfg.PreemptionMarkers {
+		if strings.TrimSpace(marker) == "" {
+			return fmt.Errorf("preemption_markers contains an empty marker")
+		}
+	}
+	if cfg.DashboardBatchPeriod < 0 || cfg.DashboardTitleInterval < 0 {
+		return fmt.Errorf("dashboard_batch_period/dashboard_title_interval can't be negative")
+	}
+	if cfg.CrashCooldown < 0 {
+		return fmt.Errorf("crash_cooldown can't be negative")
+	}
+	if cfg.MaxReportsPerMinute < 0 {
+		return fmt.Errorf("max_reports_per_minute can't be negative")
+	}
+	if cfg.LogRateLimit < 0 {
+		return fmt.Errorf("log_rate_limit can't be negative")
+	}
+	if cfg.ExecutorMemoryLimit < 0 {
+		return fmt.Errorf("executor_memory_limit can't be negative")
+	}
+	if cfg.ExecutorMemoryLimit != 0 && cfg.TargetOS != "linux" {
+		return fmt.Errorf("executor_memory_limit is supported only on linux")
+	}
+	if err := checkGuestCPUSet(cfg); err != nil {
+		return err
+	}
+	if cfg.SlowVMFactor < 0 || cfg.SlowVMFactor == 1 {
+		return fmt.Errorf("bad slow_vm_factor: %v, want 0 or >= 2", cfg.SlowVMFactor)
+	}
+	if pg := cfg.PerfGate; pg.Command != "" && (pg.Baseline <= 0 || pg.Tolerance < 0 || pg.Quarantine < 0) {
+		return fmt.Errorf("bad perf_gate baseline/tolerance/quarantine: %v/%v/%v, want > 0/>= 0/>= 0",
+			pg.Baseline, pg.Tolerance, pg.Quarantine)
+	}
+	if len(cfg.LeakWatch.Files) != 0 &&
+		(cfg.LeakWatch.Period <= 0 || cfg.LeakWatch.Growth <= 0 || cfg.LeakWatch.MinGrowth < 0) {
+		return fmt.Errorf("bad leak_watch period/growth/min_growth: %v/%v/%v, want > 0/> 0/>= 0",
+			cfg.LeakWatch.Period, cfg.LeakWatch.Growth, cfg.LeakWatch.MinGrowth)
+	}
+	if ap := cfg.AdaptiveProcs; ap.Max != 0 &&
+		(ap.Min < 1 || ap.Min > ap.Max || ap.Max > 32 || ap.MemPerProc <= 0) {
+		return fmt.Errorf("bad adaptive_procs min/max/mem_per_proc: %v/%v/%v, want 1 <= min <= max <= 32, > 0",
+			ap.Min, ap.Max, ap.MemPerProc)
+	}
+	for i := range cfg.ForeignCorpora {
+		fc := &cfg.ForeignCorpora[i]
+		if err := checkForeignCorpus(cfg, fc); err != nil {
+			return fmt.Errorf("bad config param foreign_corpora: %v", err)
+		}
+	}
+	if cfg.Warnings.Period < 0 || cfg.Warnings.Period > 0 && cfg.Warnings.Window <= 0 {
+		return fmt.Errorf("bad config param warnings: period %v, window %v",
+			cfg.Warnings.Period, cfg.Warnings.Window)
+	}
+	if cfg.LivenessCheck.Timeout < 0 {
+		return fmt.Errorf("bad config param liveness_check: timeout %v", cfg.LivenessCheck.Timeout)
+	}
+	for _, title := range cfg.LivenessCheck.Titles {
+		if _, err := regexp.Compile(title); err != nil {
+			return fmt.Errorf("bad config param liveness_check: bad title regexp %q: %v", title, err)
+		}
+	}
+	if uc := cfg.UnrecognizedCrashes; uc.Threshold < 0 ||
+		len(uc.Markers) != 0 && uc.Threshold > len(uc.Markers) {
+		return fmt.Errorf("bad config param unrecognized_crashes: threshold %v, %v markers",
+			uc.Threshold, len(uc.Markers))
+	}
+	for _, marker := range cfg.UnrecognizedCrashes.Markers {
+		if _, err := regexp.Compile(marker); err != nil {
+			return fmt.Errorf("bad config param unrecognized_crashes: bad marker regexp %q: %v", marker, err)
+		}
+	}
+	if sp := cfg.SlowProfiles; sp.Tracer != "" {
+		if sp.Tracer != "ftrace" && sp.Tracer != "perf" {
+			return fmt.Errorf("bad config param slow_profiles: unknown tracer %q, want ftrace or perf", sp.Tracer)
+		}
+		if cfg.TargetOS != "linux" {
+			return fmt.Errorf("slow_profiles is supported only on linux")
+		}
+		if sp.Sample < 1 || sp.Threshold < 0 || sp.MaxSize <= 0 {
+			return fmt.Errorf("bad config param slow_profiles: sample/threshold/max_size: %v/%v/%v,"+
+				" want >= 1/>= 0/> 0", sp.Sample, sp.Threshold, sp.MaxSize)
+		}
+	}
+	if rl := cfg.ReportLog; rl.File != "" && (rl.MaxSize <= 0 || rl.Backups < 0) {
+		return fmt.Errorf("bad config param report_log: max_size/backups: %v/%v, want > 0/>= 0",
+			rl.MaxSize, rl.Backups)
+	}
+	if len(cfg.BundleCrashes.GuestFiles) != 0 && cfg.BundleCrashes.Dir == "" {
+		return fmt.Errorf("bundle_crashes guest_files require dir")
+	}
+	for i, supp := range cfg.ScopedSuppressions {
+		if err := checkScopedSuppression(supp); err != nil {
+			return fmt.Errorf("bad scoped_suppressions[%v]: %v", i, err)
+		}
+	}
+	for i, rule := range cfg.Severities {
+		if err := checkSeverityRule(rule); err != nil {
+			return fmt.Errorf("bad severities[%v]: %v", i, err)
+		}
+	}
+	for i, event := range cfg.SecurityEvents {
+		if err := checkSecurityEvent(event); err != nil {
+			return fmt.Errorf("bad security_events[%v]: %v", i, err)
+		}
+	}
 
 	return nil
 }
@@ -219,6 +710,45 @@ func checkSSHParams(cfg *Config) error {
 	return nil
 }
 
+// checkGuestCPUSet checks guest_cpuset syntax and that it fits into the number of VM CPUs
+// if the VM config specifies it (qemu, kvm). Otherwise it's checked in the VM before running commands.
+func checkGuestCPUSet(cfg *Config) error {
+	if cfg.GuestCPUSet == "" {
+		return nil
+	}
+	if cfg.TargetOS != "linux" {
+		return fmt.Errorf("guest_cpuset is supported only on linux")
+	}
+	cpus, err := ParseCPUSet(cfg.GuestCPUSet)
+	if err != nil {
+		return fmt.Errorf("bad guest_cpuset %q: %v", cfg.GuestCPUSet, err)
+	}
+	vm := struct {
+		CPU int `json:"cpu"`
+	}{}
+	if len(cfg.VM) != 0 && json.Unmarshal(cfg.VM, &vm) == nil && vm.CPU > 0 && cpus[len(cpus)-1] >= vm.CPU {
+		return fmt.Errorf("guest_cpuset %q does not fit into %v VM CPUs", cfg.GuestCPUSet, vm.CPU)
+	}
+	return nil
+}
+
+// ParseCPUSet parses a list of CPUs in the taskset format (e.g. "0,2-3") and returns sorted CPU numbers.
+func ParseCPUSet(set string) ([]int, error) {
+	if strings.TrimSpace(set) == "" || strings.ContainsAny(set, " \t") {
+		return nil, fmt.Errorf("bad cpu list %q", set)
+	}
+	used, err := ParseInstances(set)
+	if err != nil {
+		return nil, err
+	}
+	var cpus []int
+	for cpu := range used {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
 func completeBinaries(cfg *Config) error {
 	sysTarget := targets.Get(cfg.TargetOS, cfg.TargetArch)
 	if sysTarget == nil {
@@ -244,6 +774,26 @@ func completeBinaries(cfg *Config) error {
 	return nil
 }
 
+func checkForeignCorpus(cfg *Config, fc *ForeignCorpus) error {
+	targetOS, _, targetArch, err := splitTarget(fc.Target)
+	if err != nil {
+		return fmt.Errorf("target %q: %v", fc.Target, err)
+	}
+	if targetOS != cfg.TargetOS || targetArch == cfg.TargetArch {
+		return fmt.Errorf("target %q: want another arch of %v", fc.Target, cfg.TargetOS)
+	}
+	if _, err := prog.GetTarget(targetOS, targetArch); err != nil {
+		return err
+	}
+	if fc.Corpus == "" && fc.Manager == "" {
+		return fmt.Errorf("target %q: neither corpus nor manager is specified", fc.Target)
+	}
+	if fc.Corpus != "" {
+		fc.Corpus = osutil.Abs(fc.Corpus)
+	}
+	return nil
+}
+
 func splitTarget(target string) (string, string, string, error) {
 	if target == "" {
 		return "", "", "", fmt.Errorf("target is empty")
@@ -299,6 +849,79 @@ func ParseEnabledSyscalls(target *prog.Target, enabled, disabled []string) (map[
 	return syscalls, nil
 }
 
+func checkScopedSuppression(supp ScopedSuppression) error {
+	if supp.Title == "" {
+		return fmt.Errorf("title is empty")
+	}
+	if _, err := regexp.Compile(supp.Title); err != nil {
+		return fmt.Errorf("bad title regexp: %v", err)
+	}
+	if _, err := regexp.Compile(supp.Kernel); err != nil {
+		return fmt.Errorf("bad kernel regexp: %v", err)
+	}
+	if _, err := ParseInstances(supp.Instances); err != nil {
+		return err
+	}
+	return nil
+}
+
+func checkSeverityRule(rule SeverityRule) error {
+	if rule.Title == "" {
+		return fmt.Errorf("title is empty")
+	}
+	if _, err := regexp.Compile(rule.Title); err != nil {
+		return fmt.Errorf("bad title regexp: %v", err)
+	}
+	switch rule.Severity {
+	case SeverityCritical, SeverityHigh, SeverityMedium, SeverityLow:
+	default:
+		return fmt.Errorf("unknown severity %q, want %v/%v/%v/%v", rule.Severity,
+			SeverityCritical, SeverityHigh, SeverityMedium, SeverityLow)
+	}
+	return nil
+}
+
+func checkSecurityEvent(event SecurityEvent) error {
+	if event.Name == "" {
+		return fmt.Errorf("name is empty")
+	}
+	if event.Regexp == "" {
+		return fmt.Errorf("regexp is empty")
+	}
+	if _, err := regexp.Compile(event.Regexp); err != nil {
+		return fmt.Errorf("bad regexp: %v", err)
+	}
+	return nil
+}
+
+// maxInstance limits VM indexes in instance ranges.
+const maxInstance = 1 << 16
+
+// ParseInstances parses a comma-separated list of VM index ranges (e.g. "0-3,7").
+// Returns nil for an empty list, which means all instances.
+func ParseInstances(instances string) (map[int]bool, error) {
+	if strings.TrimSpace(instances) == "" {
+		return nil, nil
+	}
+	res := make(map[int]bool)
+	for _, part := range strings.Split(instances, ",") {
+		part = strings.TrimSpace(part)
+		begin, end := part, part
+		if dash := strings.IndexByte(part, '-'); dash != -1 {
+			begin, end = part[:dash], part[dash+1:]
+		}
+		first, err1 := strconv.Atoi(strings.TrimSpace(begin))
+		last, err2 := strconv.Atoi(strings.TrimSpace(end))
+		if err1 != nil || err2 != nil || first < 0 || first > last || last >= maxInstance {
+			return nil, fmt.Errorf("bad instance range %q", part)
+		}
+		for i := first; i <= last; i++ {
+			res[i] = true
+		}
+	}
+	return res, nil
+}
+
 func matchSyscall(name, pattern string) bool {
 	if pattern == name || strings.HasPrefix(name, pattern+"$") {
 		return true
@@ -5,6 +5,8 @@ package mgrconfig
 
 import (
 	"path/filepath"
+	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/google/syzkaller/pkg/config"
@@ -62,3 +64,65 @@ func TestMatchSyscall(t *testing.T) {
 		}
 	}
 }
+
+func TestParseInstances(t *testing.T) {
+	tests := []struct {
+		instances string
+		result    []int
+		err       bool
+	}{
+		{instances: ""},
+		{instances: "3", result: []int{3}},
+		{instances: "0-2, 7", result: []int{0, 1, 2, 7}},
+		{instances: "4-4,1", result: []int{1, 4}},
+		{instances: "3-1", err: true},
+		{instances: "-1", err: true},
+		{instances: "1,", err: true},
+		{instances: "a-b", err: true},
+		{instances: "0-100000000", err: true},
+	}
+	for i, test := range tests {
+		res, err := ParseInstances(test.instances)
+		if (err != nil) != test.err {
+			t.Errorf("#%v: %q: got error %v, want error %v", i, test.instances, err, test.err)
+			continue
+		}
+		var got []int
+		for idx := range res {
+			got = append(got, idx)
+		}
+		sort.Ints(got)
+		if !reflect.DeepEqual(got, test.result) {
+			t.Errorf("#%v: %q: got %v, want %v", i, test.instances, got, test.result)
+		}
+	}
+}
+
+func TestGuestCPUSet(t *testing.T) {
+	tests := []struct {
+		cpuset string
+		vm     string
+		err    bool
+	}{
+		{cpuset: "0,2", vm: `{"cpu": 4}`},
+		{cpuset: "1-3", vm: `{"cpu": 4}`},
+		{cpuset: "1-3", vm: `{"count": 2}`},
+		{cpuset: "1-4", vm: `{"cpu": 4}`, err: true},
+		{cpuset: "0, 1", err: true},
+		{cpuset: "2-1", err: true},
+		{cpuset: "x", err: true},
+	}
+	for i, test := range tests {
+		cfg := &Config{
+			TargetOS:    "linux",
+			GuestCPUSet: test.cpuset,
+			VM:          []byte(test.vm),
+		}
+		if err := checkGuestCPUSet(cfg); (err != nil) != test.err {
+			t.Errorf("#%v: %q %v: got error %v, want error %v", i, test.cpuset, test.vm, err, test.err)
+		}
+	}
+	if cpus, err := ParseCPUSet("3,0-1,1"); err != nil || !reflect.DeepEqual(cpus, []int{0, 1, 3}) {
+		t.Errorf("got cpus %v, err %v", cpus, err)
+	}
+}
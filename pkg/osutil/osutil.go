@@ -19,6 +19,10 @@ const (
 	DefaultExecPerm = 0755
 )
 
+// ExitInterrupted is the exit status of tools that were stopped with SIGINT/SIGTERM (see HandleInterrupts)
+// and saved partial results.
+const ExitInterrupted = 3
+
 // RunCmd runs "bin args..." in dir with timeout and returns its output.
 func RunCmd(timeout time.Duration, dir, bin string, args ...string) ([]byte, error) {
 	cmd := Command(bin, args...)
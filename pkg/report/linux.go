@@ -153,6 +153,16 @@ func (ctx *linux) Parse(output []byte) *Report {
 	rep.Title = title
 	rep.Corrupted = corrupted != ""
 	rep.CorruptedReason = corrupted
+	if format.chain != nil {
+		if match := format.title.FindIndex(report); match != nil {
+			_, rep.LockChain = format.chain(report[match[0]:])
+		}
+		// Lockdep reports used to be titled by the guilty function,
+		// the dashboard matches existing bugs by this title.
+		if alt, _, _ := extractDescription(report, withoutChainFormats(oops), linuxStackParams); alt != "" {
+			rep.AltTitles = []string{alt}
+		}
+	}
 	// Prepend 5 lines preceding start of the report,
 	// they can contain additional info related to the report.
 	for _, prefix := range reportPrefix {
@@ -383,7 +393,7 @@ func symbolizeLine(symbFunc func(bin string, pc uint64) ([]symbolizer.Frame, err
 		if frame.Inline {
 			end := match[7] + len(info)
 			modified = replace(modified, end, end, []byte(" [inline]"))
-			modified = replace(modified, match[2], match[7], []byte(frame.Func))
+			modified = replace(modified, match[2], match[7], []byte(normalizeFuncSuffixes(frame.Func)))
 		}
 		symbolized = append(symbolized, modified...)
 	}
@@ -551,6 +561,124 @@ func linuxStallFrameExtractor(frames []string) (string, string) {
 	return "", "did not find any anchor frame"
 }
 
+// linuxLockChain extracts lock classes from the "existing dependency chain" part
+// of a lockdep circular locking report. Lockdep prints the cycle starting from the lock
+// that the task is trying to acquire (#0), so the same cycle hit from different entry points
+// is printed with different rotations. The cycle is canonicalized (see canonicalLockCycle)
+// and described by linuxLockCycleTitle, e.g. "&xt[i].mutex -> sk_lock-AF_INET -> rtnl_mutex".
+func linuxLockChain(report []byte) (string, []string) {
+	var locks []string
+	s := bufio.NewScanner(bytes.NewReader(report))
+	for s.Scan() {
+		ln := s.Bytes()
+		if bytes.Contains(ln, []byte("other info that might help us debug this")) {
+			break
+		}
+		match := linuxLockChainRe.FindSubmatch(ln)
+		if match == nil {
+			continue
+		}
+		idx, err := strconv.Atoi(string(match[1]))
+		if err != nil || idx > 64 {
+			return "", nil
+		}
+		for len(locks) <= idx {
+			locks = append(locks, "")
+		}
+		locks[idx] = linuxLockSubclassRe.ReplaceAllString(string(match[2]), "")
+	}
+	if len(locks) < 2 {
+		return "", nil
+	}
+	// Lock #i+1 closes the cycle for lock #i, so the cycle is listed in the order of decreasing indices
+	// (this keeps the acquired lock -> held lock order of the titles).
+	cycle := make([]string, len(locks))
+	for i, lock := range locks {
+		if lock == "" {
+			// Some links of the chain are missing, the report is truncated.
+			return "", nil
+		}
+		cycle[len(locks)-1-i] = lock
+	}
+	cycle = canonicalLockCycle(cycle)
+	return linuxLockCycleTitle(cycle), cycle
+}
+
+// linuxLockCycleTitle describes the canonical lock cycle for the title. All locks are listed
+// (so that e.g. a 2-lock cycle does not collide with a 3-lock cycle that contains it), unless
+// the title becomes too long. Then the cycle is cut and its length is added,
+// e.g. "a -> b -> c -> ... (9 locks)".
+func linuxLockCycleTitle(cycle []string) string {
+	const maxLen = 80
+	desc := cycle[0]
+	for i := 1; i < len(cycle); i++ {
+		next := desc + " -> " + cycle[i]
+		if i >= 2 && len(next) > maxLen {
+			return fmt.Sprintf("%v -> ... (%v locks)", desc, len(cycle))
+		}
+		desc = next
+	}
+	return desc
+}
+
+// linuxRecursiveLock extracts the lock class from a lockdep recursive locking report.
+// The task tries to acquire a lock of the class that it already holds, so the cycle
+// consists of the single lock class that is also used as the title, e.g. "rtnl_mutex".
+func linuxRecursiveLock(report []byte) (string, []string) {
+	lock := linuxReportedLock(report, "is trying to acquire lock:")
+	if lock == "" {
+		return "", nil
+	}
+	return lock, []string{lock}
+}
+
+// linuxInconsistentLock extracts the lock class from a lockdep inconsistent lock state report.
+func linuxInconsistentLock(report []byte) (string, []string) {
+	lock := linuxReportedLock(report, "takes:")
+	if lock == "" {
+		return "", nil
+	}
+	return lock, []string{lock}
+}
+
+// linuxReportedLock returns the lock class printed on the line following the first line
+// that ends with marker, e.g. " (&(&q->lock)->rlock){+.?.}, at: ...".
+func linuxReportedLock(report []byte, marker string) string {
+	s := bufio.NewScanner(bytes.NewReader(report))
+	for s.Scan() {
+		if !bytes.HasSuffix(bytes.TrimRight(s.Bytes(), "\r"), []byte(marker)) {
+			continue
+		}
+		if !s.Scan() {
+			return ""
+		}
+		match := linuxLockRe.FindSubmatch(s.Bytes())
+		if match == nil {
+			return ""
+		}
+		return linuxLockSubclassRe.ReplaceAllString(string(match[1]), "")
+	}
+	return ""
+}
+
+// canonicalLockCycle returns the lexicographically smallest rotation of the lock cycle,
+// so that the cycle has the same representation regardless of the lock it was detected at.
+func canonicalLockCycle(cycle []string) []string {
+	best := cycle
+	for i := 1; i < len(cycle); i++ {
+		rotated := append(append([]string{}, cycle[i:]...), cycle[:i]...)
+		for j := range rotated {
+			if rotated[j] != best[j] {
+				if rotated[j] < best[j] {
+					best = rotated
+				}
+				break
+			}
+		}
+	}
+	return best
+}
+
 var linuxStallAnchorFrames = []*regexp.Regexp{
 	// Various generic functions that dispatch work.
 	// We also include some of their callers, so that if some names change
@@ -623,7 +751,14 @@ var (
 	linuxSymbolizeRe = regexp.MustCompile(`(?:\[\<(?:[0-9a-f]+)\>\])?[ \t]+(?:[0-9]+:)?([a-zA-Z0-9_.]+)\+0x([0-9a-f]+)/0x([0-9a-f]+)`)
 	stackFrameRe     = regexp.MustCompile(`^ *(?:\[\<(?:[0-9a-f]+)\>\])?[ \t]+(?:[0-9]+:)?([a-zA-Z0-9_.]+)\+0x([0-9a-f]+)/0x([0-9a-f]+)`)
 	linuxRcuStall    = compile("INFO: rcu_(?:preempt|sched|bh) (?:self-)?detected(?: expedited)? stall")
-	linuxRipFrame    = compile(`IP: (?:(?:[0-9]+:)?(?:{{PC}} +){0,2}{{FUNC}}|[0-9]+:0x[0-9a-f]+|(?:[0-9]+:)?{{PC}} +\[< *\(null\)>\] +\(null\)|[0-9]+: +\(null\))`)
+	// Matches "-> #1 (&xt[i].mutex){+.+.}:" lines, captures chain index and lock class name.
+	linuxLockChainRe = regexp.MustCompile(`-> #([0-9]+) \((.+)\)\{[^}]*\}`)
+	// Matches lock descriptions like " 0000000097f06d5d (rtnl_mutex){+.+.}, at: ...", captures lock class name.
+	linuxLockRe = regexp.MustCompile(`\((.+)\)\{[^}]*\}`)
+	// Matches lockdep subclass suffixes of lock class names ("&sb->s_type->i_mutex_key#10"),
+	// these depend on the order of lock class registration and are not stable.
+	linuxLockSubclassRe = regexp.MustCompile(`(?:#|/)[0-9]+$`)
+	linuxRipFrame       = compile(`IP: (?:(?:[0-9]+:)?(?:{{PC}} +){0,2}{{FUNC}}|[0-9]+:0x[0-9a-f]+|(?:[0-9]+:)?{{PC}} +\[< *\(null\)>\] +\(null\)|[0-9]+: +\(null\))`)
 )
 
 var linuxCorruptedTitles = []*regexp.Regexp{
@@ -990,6 +1125,11 @@ var linuxOopses = []*oops{
 				title: compile("WARNING: .* at {{SRC}} {{FUNC}}"),
 				fmt:   "WARNING in %[2]v",
 			},
+			{
+				title: compile("WARNING: possible circular locking dependency detected"),
+				fmt:   "possible deadlock in %[1]v",
+				chain: linuxLockChain,
+			},
 			{
 				title:  compile("WARNING: possible circular locking dependency detected"),
 				report: compile("WARNING: possible circular locking dependency detected(?:.*\\n)+?.*is trying to acquire lock(?:.*\\n)+?.*at: (?:{{PC}} +)?{{FUNC}}"),
@@ -1005,11 +1145,21 @@ var linuxOopses = []*oops{
 				report: compile("WARNING: SOFTIRQ-safe -> SOFTIRQ-unsafe lock order detected(?:.*\\n)+?.*is trying to acquire(?:.*\\n)+?.*at: (?:{{PC}} +)?{{FUNC}}"),
 				fmt:    "possible deadlock in %[1]v",
 			},
+			{
+				title: compile("WARNING: possible recursive locking detected"),
+				fmt:   "possible deadlock in %[1]v",
+				chain: linuxRecursiveLock,
+			},
 			{
 				title:  compile("WARNING: possible recursive locking detected"),
 				report: compile("WARNING: possible recursive locking detected(?:.*\\n)+?.*is trying to acquire lock(?:.*\\n)+?.*at: (?:{{PC}} +)?{{FUNC}}"),
 				fmt:    "possible deadlock in %[1]v",
 			},
+			{
+				title: compile("WARNING: inconsistent lock state"),
+				fmt:   "inconsistent lock state in %[1]v",
+				chain: linuxInconsistentLock,
+			},
 			{
 				title:  compile("WARNING: inconsistent lock state"),
 				report: compile("WARNING: inconsistent lock state(?:.*\\n)+?.*takes(?:.*\\n)+?.*at: (?:{{PC}} +)?{{FUNC}}"),
@@ -1066,6 +1216,11 @@ var linuxOopses = []*oops{
 	{
 		[]byte("INFO:"),
 		[]oopsFormat{
+			{
+				title: compile("INFO: possible circular locking dependency detected"),
+				fmt:   "possible deadlock in %[1]v",
+				chain: linuxLockChain,
+			},
 			{
 				title:  compile("INFO: possible circular locking dependency detected"),
 				report: compile("INFO: possible circular locking dependency detected \\](?:.*\\n)+?.*is trying to acquire lock(?:.*\\n)+?.*at: {{PC}} +{{FUNC}}"),
@@ -1081,11 +1236,21 @@ var linuxOopses = []*oops{
 				report: compile("INFO: SOFTIRQ-safe -> SOFTIRQ-unsafe lock order detected \\](?:.*\\n)+?.*is trying to acquire(?:.*\\n)+?.*at: {{PC}} +{{FUNC}}"),
 				fmt:    "possible deadlock in %[1]v",
 			},
+			{
+				title: compile("INFO: possible recursive locking detected"),
+				fmt:   "possible deadlock in %[1]v",
+				chain: linuxRecursiveLock,
+			},
 			{
 				title:  compile("INFO: possible recursive locking detected"),
 				report: compile("INFO: possible recursive locking detected \\](?:.*\\n)+?.*is trying to acquire lock(?:.*\\n)+?.*at: {{PC}} +{{FUNC}}"),
 				fmt:    "possible deadlock in %[1]v",
 			},
+			{
+				title: compile("INFO: inconsistent lock state"),
+				fmt:   "inconsistent lock state in %[1]v",
+				chain: linuxInconsistentLock,
+			},
 			{
 				title:  compile("INFO: inconsistent lock state"),
 				report: compile("INFO: inconsistent lock state \\](?:.*\\n)+?.*takes(?:.*\\n)+?.*at: {{PC}} +{{FUNC}}"),
@@ -1334,6 +1499,18 @@ var linuxOopses = []*oops{
 		},
 		[]*regexp.Regexp{},
 	},
+	{
+		// ppc64 has no decompressor, prom_init prints this on boot.
+		[]byte("Booting Linux via __start()"),
+		[]oopsFormat{
+			{
+				title:        compile(`Booting Linux via __start\(\)`),
+				fmt:          UnexpectedKernelReboot,
+				noStackTrace: true,
+			},
+		},
+		[]*regexp.Regexp{},
+	},
 	{
 		[]byte("unregister_netdevice: waiting for"),
 		[]oopsFormat{
@@ -4,7 +4,11 @@
 package report
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/google/syzkaller/pkg/mgrconfig"
@@ -227,3 +231,99 @@ func TestLinuxSymbolizeLine(t *testing.T) {
 		})
 	}
 }
+
+// Reports of the same bugs on kernels built with gcc 9 and clang 17 (with ThinLTO, which renames
+// static functions to <func>.llvm.<hash>). The consoles lost the access size lines, so the titles
+// come from the oops lines with the compiler-generated suffixes.
+var linuxCompilerSuffixTests = []struct {
+	gcc      string // file in testdata/linux/report
+	clang    string
+	title    string
+	gccAlt   string
+	clangAlt string
+}{
+	{
+		gcc:      "323",
+		clang:    "324",
+		title:    "KASAN: slab-out-of-bounds in tcp_write_xmit",
+		gccAlt:   "KASAN: slab-out-of-bounds in tcp_write_xmit.isra.0",
+		clangAlt: "KASAN: slab-out-of-bounds in tcp_write_xmit.llvm.ADDR",
+	},
+	{
+		gcc:      "325",
+		clang:    "326",
+		title:    "KASAN: use-after-free in __sco_sock_close",
+		gccAlt:   "KASAN: use-after-free in __sco_sock_close.part.0",
+		clangAlt: "KASAN: use-after-free in __sco_sock_close.llvm.ADDR",
+	},
+}
+
+func TestLinuxCompilerSuffixes(t *testing.T) {
+	cfg := &mgrconfig.Config{
+		TargetOS:   "linux",
+		TargetArch: "amd64",
+	}
+	reporter, err := NewReporter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range linuxCompilerSuffixTests {
+		for _, file := range []struct {
+			name string
+			alt  string
+		}{{test.gcc, test.gccAlt}, {test.clang, test.clangAlt}} {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", "linux", "report", file.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Skip the test headers.
+			output := data[bytes.Index(data, []byte("\n\n"))+2:]
+			rep := reporter.Parse(output)
+			if rep == nil {
+				t.Fatalf("%v: no crash found", file.name)
+			}
+			if rep.Title != test.title {
+				t.Errorf("%v: got title %q, want %q", file.name, rep.Title, test.title)
+			}
+			// The unnormalized title is kept, so that bugs reported with it are still matched.
+			if want := []string{file.alt}; fmt.Sprint(rep.AltTitles) != fmt.Sprint(want) {
+				t.Errorf("%v: got alt titles %q, want %q", file.name, rep.AltTitles, want)
+			}
+		}
+	}
+}
+
+func TestNormalizeFuncSuffixes(t *testing.T) {
+	tests := map[string]string{
+		"foo.isra.0":                        "foo",
+		"foo.isra.0.constprop.3":            "foo",
+		"foo.part.12 in bar.cold":           "foo in bar",
+		"foo.llvm.NUM+0x10":                 "foo+0x10",
+		"foo.lto_priv.0 at addr ADDR":       "foo at addr ADDR",
+		"kernel BUG at fs/ext4/inode.c:123": "kernel BUG at fs/ext4/inode.c:123",
+		"foo.partial":                       "foo.partial",
+		"linux-4.19.0":                      "linux-4.19.0",
+	}
+	for str, want := range tests {
+		if got := normalizeFuncSuffixes(str); got != want {
+			t.Errorf("normalizeFuncSuffixes(%q) = %q, want %q", str, got, want)
+		}
+	}
+}
+
+func TestCanonicalLockCycle(t *testing.T) {
+	tests := map[string]string{
+		"a":                     "a",
+		"b -> a":                "a -> b",
+		"c -> a -> b":           "a -> b -> c",
+		"b -> c -> a":           "a -> b -> c",
+		"a -> c -> b":           "a -> c -> b",
+		"b -> a -> b -> a -> c": "a -> b -> a -> c -> b",
+	}
+	for cycle, want := range tests {
+		got := strings.Join(canonicalLockCycle(strings.Split(cycle, " -> ")), " -> ")
+		if got != want {
+			t.Errorf("canonicalLockCycle(%q) = %q, want %q", cycle, got, want)
+		}
+	}
+}
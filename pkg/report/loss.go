@@ -0,0 +1,44 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+)
+
+var (
+	// Messages that explicitly say that console output was lost.
+	outputLossRes = []*regexp.Regexp{
+		regexp.MustCompile(`\*\* [0-9]+ printk messages dropped \*\*`),
+		regexp.MustCompile(`[0-9]+ input overrun\(s\)`),
+		regexp.MustCompile(`serial8250: too much work for irq`),
+	}
+	consoleTimestampRe = regexp.MustCompile(`\[ *[0-9]+\.[0-9]{6}\]`)
+)
+
+// DetectOutputLoss heuristically detects that some bytes of console output were lost
+// (e.g. the serial console drops bytes under very high log rates).
+// Returns description of the first detected loss, or "" if output looks complete.
+// This is best-effort: loss is not necessarily detected and false positives are possible.
+func DetectOutputLoss(output []byte) string {
+	for _, re := range outputLossRes {
+		if match := re.Find(output); match != nil {
+			return fmt.Sprintf("console reported lost output: %s", match)
+		}
+	}
+	for _, line := range bytes.Split(output, []byte{'\n'}) {
+		// A kernel message that was cut mid-line and continued by the next kernel message,
+		// i.e. the tail of the first message (including the new line) was lost.
+		loc := consoleTimestampRe.FindIndex(line)
+		if loc == nil || len(bytes.TrimSpace(line[:loc[0]])) != 0 {
+			continue
+		}
+		if consoleTimestampRe.Match(line[loc[1]:]) {
+			return fmt.Sprintf("truncated line: %q", line)
+		}
+	}
+	return ""
+}
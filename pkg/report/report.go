@@ -10,7 +10,9 @@ import (
 	"bytes"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/syzkaller/pkg/mgrconfig"
 	"github.com/google/syzkaller/sys/targets"
@@ -24,17 +26,34 @@ type Reporter interface {
 	// Returns nil if no oops found.
 	Parse(output []byte) *Report
 
-	// Symbolize symbolizes rep.Report and fills in Maintainers.
+	// Symbolize symbolizes rep.Report and fills in Maintainers and Frames.
 	Symbolize(rep *Report) error
 }
 
 type Report struct {
 	// Title contains a representative description of the first oops.
 	Title string
+	// AltTitles contains alternative titles of the same oops: the title with compiler-generated
+	// function suffixes (.isra.0, .constprop.0, .part.0, .cold, etc) left intact, if it differs from Title,
+	// and the function-based title of lockdep reports titled by locks. Used by the dashboard
+	// to match bugs reported before the suffixes were normalized or lockdep titles were changed.
+	AltTitles []string
+	// LockChain contains lock classes of the lock cycle of lockdep deadlock reports (the title is derived
+	// from it). The cycle is rotated to a canonical form, so it does not depend on the lock where
+	// lockdep detected it, and lockdep subclasses are stripped. A single lock for recursive locking
+	// and inconsistent lock state reports.
+	LockChain []string
 	// Report contains whole oops text.
 	Report []byte
 	// Output contains whole raw console output as passed to Reporter.Parse.
 	Output []byte
+	// RawOutput contains console output before the VM type removed its noise from it
+	// (see vmimpl.OutputFilterer), it's saved as the crash log instead of Output if set.
+	RawOutput []byte
+	// Diagnosis contains console output produced in response to the VM Diagnose request
+	// (e.g. sysrq task dumps) after the crash, it's not included in Report and Output
+	// (set by the VM monitor).
+	Diagnosis []byte
 	// StartPos/EndPos denote region of output with oops message(s).
 	StartPos int
 	EndPos   int
@@ -44,8 +63,52 @@ type Report struct {
 	Corrupted bool
 	// CorruptedReason contains reason why the report is marked as corrupted.
 	CorruptedReason string
+	// Incomplete indicates that console output was likely lossy (e.g. serial console dropped bytes),
+	// so the report may miss parts of the crash. Set by the VM monitor (see DetectOutputLoss).
+	Incomplete bool
+	// IncompleteReason describes the detected output loss.
+	IncompleteReason string
+	// Origin is the type of reporter that detected the crash (e.g. "gvisor" or "linux"),
+	// set only if a secondary reporter is configured (e.g. for crashes of the host kernel).
+	Origin string
+	// Time is the host time when the crash was detected (set by the VM monitor).
+	Time time.Time
+	// GuestUptime is the guest uptime at the time of the crash (0 if unknown, see GuestUptime).
+	GuestUptime time.Duration
 	// Maintainers is list of maintainer emails (filled in by Symbolize).
 	Maintainers []string
+	// Severity is the priority of the crash assigned by its title (set by the VM monitor,
+	// see severities config), one of mgrconfig.Severity* constants.
+	Severity string
+	// Repeats is the number of identical reports that were counted instead of being reported
+	// (set by the VM monitor for rate limited warnings and throttled reports).
+	Repeats int
+	// Throttled indicates that the report exceeded max_reports_per_minute and should only be counted,
+	// it's added to Repeats of the next report with the same title (set by the VM monitor).
+	Throttled bool
+	// Info contains additional information about the VM attached by the VM implementation
+	// (e.g. paths of files produced by instrumentation).
+	Info []byte
+	// MemState is the guest memory state at the time of the crash: meminfo, top slab caches
+	// and vmstat deltas (set by the VM monitor if crash_mem_state is configured).
+	MemState []byte
+	// Ftrace is the kernel ftrace ring buffer at the time of the crash
+	// (set by the VM monitor if crash_ftrace is configured).
+	Ftrace []byte
+	// Class is the class of the report: ClassCrash, ClassSecurityEvent or ClassBootWarning
+	// (set by the VM monitor for security_events signatures and oopses printed before fuzzing starts).
+	Class string
+	// Liveness tells whether the kernel kept executing programs after the oops: LivenessFatal
+	// or LivenessNonFatal, empty if it was not checked (set by the VM monitor, see liveness_check config).
+	Liveness string
+	// Cmdline is the kernel command line the VM was booted with as configured on the host
+	// (set by the VM monitor if crash_cmdline is configured, see vmimpl.CmdlineReporter).
+	Cmdline string
+	// TimedConsole is the console output of the run in the timed framed format
+	// (set by the VM monitor if timed_console_log is configured).
+	TimedConsole []byte
+	// Frames are stack frames of Report with source locations (filled in by Symbolize, see ParseFrames).
+	Frames []Frame
 	// guiltyFile is the source file that we think is to blame for the crash  (filled in by Symbolize).
 	guiltyFile string
 	// reportPrefixLen is length of additional prefix lines that we added before actual crash report.
@@ -74,15 +137,65 @@ func NewReporter(cfg *mgrconfig.Config) (Reporter, error) {
 	if err != nil {
 		return nil, err
 	}
-	supps, err := compileRegexps(append(suppressions, cfg.Suppressions...))
+	wrap := &reporterWrapper{
+		Reporter: rep,
+		typ:      typ,
+	}
+	if cfg.SecondaryReporter != "" {
+		secondary, secondarySupps, err := newSecondaryReporter(cfg, typ, ignores)
+		if err != nil {
+			return nil, err
+		}
+		wrap.secondary = secondary
+		wrap.secondaryTyp = cfg.SecondaryReporter
+		suppressions = append(suppressions, secondarySupps...)
+	}
+	wrap.suppressions, err = compileRegexps(append(suppressions, cfg.Suppressions...))
 	if err != nil {
 		return nil, err
 	}
-	return &reporterWrapper{rep, supps, typ}, nil
+	return wrap, nil
+}
+
+// newSecondaryReporter creates reporter for crashes of the outer layer of a hybrid stack
+// (e.g. the host kernel when fuzzing gVisor). The kernel of the outer layer is not known,
+// so its crashes are not symbolized.
+func newSecondaryReporter(cfg *mgrconfig.Config, primary string, ignores []*regexp.Regexp) (
+	Reporter, []string, error) {
+	typ := cfg.SecondaryReporter
+	ctor := ctors[typ]
+	if ctor == nil {
+		return nil, nil, fmt.Errorf("unknown secondary_reporter: %v", typ)
+	}
+	if typ == primary {
+		return nil, nil, fmt.Errorf("secondary_reporter %v is the same as the primary reporter", typ)
+	}
+	target := targets.Get(typ, cfg.TargetArch)
+	if target == nil && typ != "gvisor" {
+		return nil, nil, fmt.Errorf("unknown secondary_reporter target %v/%v", typ, cfg.TargetArch)
+	}
+	return ctor(target, "", "", ignores)
 }
 
 const UnexpectedKernelReboot = "unexpected kernel reboot"
 
+// Report classes (see Report.Class).
+const (
+	ClassCrash = ""
+	// Security-relevant event that is not a crash (e.g. a container escape), see security_events config.
+	ClassSecurityEvent = "security-event"
+	// Kernel oops printed during boot, before the fuzzer has executed any programs.
+	ClassBootWarning = "boot-warning"
+)
+
+// Liveness of the kernel after an oops (see Report.Liveness).
+const (
+	// The kernel did not make progress after the oops (e.g. BUG_ON halted it).
+	LivenessFatal = "fatal"
+	// The fuzzer kept executing programs after the oops (e.g. WARN_ON).
+	LivenessNonFatal = "non-fatal"
+)
+
 var ctors = map[string]fn{
 	"akaros":  ctorAkaros,
 	"linux":   ctorLinux,
@@ -112,22 +225,171 @@ type reporterWrapper struct {
 	Reporter
 	suppressions []*regexp.Regexp
 	typ          string
+	secondary    Reporter // optional reporter for the outer layer of a hybrid stack
+	secondaryTyp string
+}
+
+func (wrap *reporterWrapper) ContainsCrash(output []byte) bool {
+	return wrap.Reporter.ContainsCrash(output) ||
+		wrap.secondary != nil && wrap.secondary.ContainsCrash(output)
 }
 
+// Parse consults the secondary reporter only if the primary reporter does not find a crash,
+// i.e. if both the primary and the secondary stacks crashed, the primary crash is reported.
+// Titles of secondary crashes are prefixed with the secondary reporter type
+// (e.g. "linux: BUG: unable to handle kernel paging request").
 func (wrap *reporterWrapper) Parse(output []byte) *Report {
 	rep := wrap.Reporter.Parse(output)
+	if rep != nil && wrap.secondary != nil {
+		rep.Origin = wrap.typ
+	}
+	if rep == nil && wrap.secondary != nil {
+		rep = wrap.secondary.Parse(output)
+		if rep != nil {
+			rep.Origin = wrap.secondaryTyp
+			rep.Title = wrap.secondaryTyp + ": " + rep.Title
+		}
+	}
 	if rep == nil {
 		return nil
 	}
 	rep.Title = sanitizeTitle(replaceTable(dynamicTitleReplacement, rep.Title))
+	var altTitles []string
+	if title := normalizeFuncSuffixes(rep.Title); title != rep.Title {
+		altTitles = append(altTitles, rep.Title)
+		rep.Title = title
+	}
+	for _, alt := range rep.AltTitles {
+		alt = sanitizeTitle(replaceTable(dynamicTitleReplacement, alt))
+		if norm := normalizeFuncSuffixes(alt); norm != alt {
+			altTitles = append(altTitles, norm)
+		}
+		altTitles = append(altTitles, alt)
+	}
+	rep.AltTitles = altTitles
 	rep.Suppressed = matchesAny(rep.Output, wrap.suppressions)
 	return rep
 }
 
+func (wrap *reporterWrapper) Symbolize(rep *Report) error {
+	if wrap.secondary != nil && rep.Origin == wrap.secondaryTyp {
+		return wrap.secondary.Symbolize(rep)
+	}
+	if err := wrap.Reporter.Symbolize(rep); err != nil {
+		return err
+	}
+	rep.Frames = ParseFrames(rep.Report)
+	return nil
+}
+
+// Frame is a stack frame of a symbolized report.
+type Frame struct {
+	Func   string
+	File   string // source file relative to the kernel source tree
+	Line   int    // line in File
+	Inline bool   // the frame is inlined into the next frame
+	// ReportLine is the index of the line of the report the frame was parsed from.
+	ReportLine int
+}
+
+var frameRe = regexp.MustCompile(`^[ \t]*(?:R?IP: (?:[0-9a-f]+:)?)?(?:\? +)?(?:\[<[0-9a-f]+>\] +)?` +
+	`([a-zA-Z0-9_.]+)(?:\+0x[0-9a-f]+/0x[0-9a-f]+)? +([a-zA-Z0-9_.\-/]+\.[a-zA-Z]+):([0-9]+)( \[inline\])?[ \t]*$`)
+
+// ParseFrames returns stack frames of a symbolized report, i.e. frames with source locations
+// (e.g. "dump_stack+0x1b9/0x294 lib/dump_stack.c:113" or "__dump_stack lib/dump_stack.c:77 [inline]").
+// Frames that are not symbolized are skipped.
+func ParseFrames(report []byte) []Frame {
+	var frames []Frame
+	s := bufio.NewScanner(bytes.NewReader(report))
+	for line := 0; s.Scan(); line++ {
+		match := frameRe.FindSubmatch(s.Bytes())
+		if match == nil {
+			continue
+		}
+		ln, err := strconv.Atoi(string(match[3]))
+		if err != nil {
+			continue
+		}
+		frames = append(frames, Frame{
+			Func:       string(match[1]),
+			File:       string(match[2]),
+			Line:       ln,
+			Inline:     len(match[4]) != 0,
+			ReportLine: line,
+		})
+	}
+	return frames
+}
+
+// GuiltyFile returns the source file that we think is to blame for the crash
+// (available after Symbolize, empty if unknown).
+func (rep *Report) GuiltyFile() string {
+	return rep.guiltyFile
+}
+
 func IsSuppressed(reporter Reporter, output []byte) bool {
 	return matchesAny(output, reporter.(*reporterWrapper).suppressions)
 }
 
+// NoCrashReason explains why ContainsCrash does not find a crash in output:
+// either there are no oops messages at all, or all of them are benign or ignored.
+func NoCrashReason(reporter Reporter, output []byte) string {
+	wrap := reporter.(*reporterWrapper)
+	reason := noCrashReason(wrap.Reporter, output)
+	if reason == "" && wrap.secondary != nil {
+		reason = noCrashReason(wrap.secondary, output)
+	}
+	if reason == "" {
+		reason = "no oops messages found"
+	}
+	return reason
+}
+
+// noCrashReason returns the reason for the first oops message that is not considered a crash,
+// or "" if there are no oops messages.
+func noCrashReason(reporter Reporter, output []byte) string {
+	var oopses []*oops
+	var ignores []*regexp.Regexp
+	switch ctx := reporter.(type) {
+	case *linux:
+		oopses, ignores = linuxOopses, ctx.ignores
+	case *gvisor:
+		oopses, ignores = gvisorOopses, ctx.ignores
+	case *akaros:
+		oopses, ignores = akarosOopses, ctx.ignores
+	case *freebsd:
+		oopses, ignores = freebsdOopses, ctx.ignores
+	case *openbsd:
+		oopses, ignores = openbsdOopses, ctx.ignores
+	case *fuchsia:
+		oopses, ignores = zirconOopses, ctx.ignores
+	}
+	for _, line := range bytes.Split(output, []byte{'\n'}) {
+		for _, oops := range oopses {
+			if !bytes.Contains(line, oops.header) {
+				continue
+			}
+			line = bytes.TrimSpace(line)
+			if re := firstMatch(line, oops.suppressions); re != nil {
+				return fmt.Sprintf("oops message %q is benign (matches %q)", line, re)
+			}
+			if re := firstMatch(line, ignores); re != nil {
+				return fmt.Sprintf("oops message %q is ignored (matches %q from ignores)", line, re)
+			}
+		}
+	}
+	return ""
+}
+
+func firstMatch(line []byte, res []*regexp.Regexp) *regexp.Regexp {
+	for _, re := range res {
+		if re.Match(line) {
+			return re
+		}
+	}
+	return nil
+}
+
 type replacement struct {
 	match       *regexp.Regexp
 	replacement string
@@ -180,6 +442,18 @@ var dynamicTitleReplacement = []replacement{
 	},
 }
 
+// Compilers emit clones/parts of functions with suffixes like foo.isra.0, foo.constprop.3,
+// foo.part.12, foo.cold (gcc), foo.llvm.1234567 (clang) or just foo.42. The suffixes depend on
+// the compiler version and optimization decisions, so the same crash would get different titles.
+// Numbers in the suffixes may have been already replaced with NUM/ADDR by dynamicTitleReplacement.
+var funcSuffixRe = regexp.MustCompile(`([a-zA-Z_][a-zA-Z0-9_]{2,})` +
+	`(?:\.(?:isra|constprop|part|cold|llvm|lto_priv|[0-9]+)(?:\.(?:[0-9]+|NUM|ADDR))?)+([^a-zA-Z0-9_.]|$)`)
+
+// normalizeFuncSuffixes strips compiler-generated suffixes from function names in str.
+func normalizeFuncSuffixes(str string) string {
+	return funcSuffixRe.ReplaceAllString(str, "${1}${2}")
+}
+
 func sanitizeTitle(title string) string {
 	const maxTitleLen = 120 // Corrupted/intermixed lines can be very long.
 	res := make([]byte, 0, len(title))
@@ -217,11 +491,31 @@ type oopsFormat struct {
 	fmt string
 	// If not nil, a function name is extracted from the report and passed to fmt.
 	// If not nil but frame extraction fails, the report is considered corrupted.
-	stack        *stackFmt
+	stack *stackFmt
+	// If not nil, a chain of involved locks is extracted from the report and passed to fmt
+	// as an additional last argument. If chain extraction fails, the format is skipped.
+	chain        chainExtractor
 	noStackTrace bool
 	corrupted    bool
 }
 
+// chainExtractor extracts the lock dependency cycle from the report (lock class names in canonical order)
+// and its description for the title (e.g. "lockA -> lockB"). Returns empty description
+// if the chain is not present or is incomplete.
+type chainExtractor func(report []byte) (desc string, chain []string)
+
+// withoutChainFormats returns a copy of oops without formats that use chainExtractor.
+func withoutChainFormats(oops *oops) *oops {
+	res := *oops
+	res.formats = nil
+	for _, f := range oops.formats {
+		if f.chain == nil {
+			res.formats = append(res.formats, f)
+		}
+	}
+	return &res
+}
+
 type stackFmt struct {
 	// parts describe how guilty stack frame must be extracted from the report.
 	// parts are matched consecutively potentially capturing frames.
@@ -314,6 +608,13 @@ func extractDescription(output []byte, oops *oops, params *stackParams) (
 		for i := 2; i < len(match); i += 2 {
 			args = append(args, string(output[match[i]:match[i+1]]))
 		}
+		if f.chain != nil {
+			chain, _ := f.chain(output[match[0]:])
+			if chain == "" {
+				continue
+			}
+			args = append(args, chain)
+		}
 		corrupted = ""
 		if f.stack != nil {
 			frame := ""
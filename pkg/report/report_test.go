@@ -11,9 +11,11 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/syzkaller/pkg/mgrconfig"
 	"github.com/google/syzkaller/pkg/osutil"
@@ -33,6 +35,8 @@ type ParseTest struct {
 	EndLine    string
 	Corrupted  bool
 	Suppressed bool
+	LockChain  string
+	AltTitles  []string
 	HasReport  bool
 	Report     []byte
 }
@@ -63,6 +67,8 @@ func testParseFile(t *testing.T, reporter Reporter, fn string) {
 				endPrefix        = "END: "
 				corruptedPrefix  = "CORRUPTED: "
 				suppressedPrefix = "SUPPRESSED: "
+				lockChainPrefix  = "LOCKCHAIN: "
+				altTitlePrefix   = "ALT: "
 			)
 			switch ln := s.Text(); {
 			case strings.HasPrefix(ln, "#"):
@@ -90,6 +96,10 @@ func testParseFile(t *testing.T, reporter Reporter, fn string) {
 				default:
 					t.Fatalf("unknown SUPPRESSED value %q", v)
 				}
+			case strings.HasPrefix(ln, lockChainPrefix):
+				test.LockChain = ln[len(lockChainPrefix):]
+			case strings.HasPrefix(ln, altTitlePrefix):
+				test.AltTitles = append(test.AltTitles, ln[len(altTitlePrefix):])
 			case ln == "":
 				phase = phaseLog
 			default:
@@ -135,14 +145,18 @@ func testParseImpl(t *testing.T, reporter Reporter, test *ParseTest) {
 	if rep != nil && rep.Title == "" {
 		t.Fatalf("found crash, but title is empty")
 	}
-	title, corrupted, corruptedReason, suppressed := "", false, "", false
+	title, corrupted, corruptedReason, suppressed, lockChain := "", false, "", false, ""
+	var altTitles []string
 	if rep != nil {
 		title = rep.Title
 		corrupted = rep.Corrupted
 		corruptedReason = rep.CorruptedReason
 		suppressed = rep.Suppressed
+		lockChain = strings.Join(rep.LockChain, " -> ")
+		altTitles = rep.AltTitles
 	}
-	if title != test.Title || corrupted != test.Corrupted || suppressed != test.Suppressed {
+	if title != test.Title || corrupted != test.Corrupted || suppressed != test.Suppressed ||
+		lockChain != test.LockChain || fmt.Sprint(altTitles) != fmt.Sprint(test.AltTitles) {
 		if *flagUpdate && test.StartLine == "" && test.EndLine == "" {
 			buf := new(bytes.Buffer)
 			fmt.Fprintf(buf, "TITLE: %v\n", title)
@@ -152,6 +166,12 @@ func testParseImpl(t *testing.T, reporter Reporter, test *ParseTest) {
 			if suppressed {
 				fmt.Fprintf(buf, "SUPPRESSED: Y\n")
 			}
+			if lockChain != "" {
+				fmt.Fprintf(buf, "LOCKCHAIN: %v\n", lockChain)
+			}
+			for _, alt := range altTitles {
+				fmt.Fprintf(buf, "ALT: %v\n", alt)
+			}
 			fmt.Fprintf(buf, "\n%s", test.Log)
 			if test.HasReport {
 				fmt.Fprintf(buf, "REPORT:\n%s", test.Report)
@@ -160,10 +180,10 @@ func testParseImpl(t *testing.T, reporter Reporter, test *ParseTest) {
 				t.Logf("failed to update test file: %v", err)
 			}
 		}
-		t.Fatalf("want:\nTITLE: %s\nCORRUPTED: %v\nSUPPRESSED: %v\n"+
-			"got:\nTITLE: %s\nCORRUPTED: %v (%v)\nSUPPRESSED: %v\n",
-			test.Title, test.Corrupted, test.Suppressed,
-			title, corrupted, corruptedReason, suppressed)
+		t.Fatalf("want:\nTITLE: %s\nCORRUPTED: %v\nSUPPRESSED: %v\nLOCKCHAIN: %v\nALT: %q\n"+
+			"got:\nTITLE: %s\nCORRUPTED: %v (%v)\nSUPPRESSED: %v\nLOCKCHAIN: %v\nALT: %q\n",
+			test.Title, test.Corrupted, test.Suppressed, test.LockChain, test.AltTitles,
+			title, corrupted, corruptedReason, suppressed, lockChain, altTitles)
 	}
 	if title != "" && len(rep.Report) == 0 {
 		t.Fatalf("found crash message but report is empty")
@@ -323,6 +343,204 @@ func TestReplace(t *testing.T) {
 	}
 }
 
+func TestGuestUptime(t *testing.T) {
+	tests := []struct {
+		output string
+		uptime time.Duration
+	}{
+		{"", 0},
+		{"no timestamps\n", 0},
+		{"[    0.000000] Linux version 4.19\n", 0},
+		{
+			"[    1.500000] first\n[ 3723.000042][ T1234] second\nsyzkaller login:\n",
+			3723*time.Second + 42*time.Microsecond,
+		},
+	}
+	for i, test := range tests {
+		if got := GuestUptime([]byte(test.output)); got != test.uptime {
+			t.Errorf("#%v: got %v, want %v", i, got, test.uptime)
+		}
+	}
+}
+
+func TestDetectOutputLoss(t *testing.T) {
+	tests := []struct {
+		output string
+		lossy  bool
+	}{
+		{
+			"[   10.000001] kernel: first message\n" +
+				"[   10.000002] kernel: second message\n",
+			false,
+		},
+		{
+			// The tail of the first message and the new line were lost.
+			"[   10.000001] kernel: first mes[   10.000002] kernel: second message\n" +
+				"[   10.000003] kernel: third message\n",
+			true,
+		},
+		{
+			"[   10.000001][ T1234] kernel: first mes[   10.000002][ T1234] kernel: second message\n",
+			true,
+		},
+		{
+			// Login prompt is not a kernel message and is not terminated with a new line.
+			"syzkaller login: [   10.000001] kernel: first message\n",
+			false,
+		},
+		{
+			"[   10.000001] kernel: first message\n" +
+				"** 12 printk messages dropped ** [   10.000002] kernel: second message\n",
+			true,
+		},
+		{
+			"[   10.000001] serial8250: too much work for irq4\n",
+			true,
+		},
+		{
+			"[   10.000001] ttyS0: 1 input overrun(s)\n",
+			true,
+		},
+		{
+			"",
+			false,
+		},
+	}
+	for i, test := range tests {
+		reason := DetectOutputLoss([]byte(test.output))
+		if test.lossy != (reason != "") {
+			t.Errorf("#%v: got reason %q, want lossy %v", i, reason, test.lossy)
+		}
+	}
+}
+
+func TestSecondaryReporter(t *testing.T) {
+	hostCrash := "[  100.000001] ==================================================================\n" +
+		"[  100.000002] BUG: KASAN: use-after-free in vhost_work_queue+0x1a/0x30\n" +
+		"[  100.000003] Read of size 8 at addr ffff88006c2c2728 by task vhost-1234/1234\n" +
+		"[  100.000004] \n" +
+		"[  100.000005] CPU: 1 PID: 1234 Comm: vhost-1234 Not tainted 4.19.0+ #1\n"
+	sandboxCrash := "panic: runtime error: invalid memory address or nil pointer dereference\n" +
+		"[signal SIGSEGV: segmentation violation code=0x1 addr=0x40 pc=0x811ac1]\n" +
+		"\n" +
+		"goroutine 9707990 [running]:\n"
+	// Both crash in the same run, output is interleaved.
+	interleaved := "[  100.000001] ==================================================================\n" +
+		"[  100.000002] BUG: KASAN: use-after-free in vhost_work_queue+0x1a/0x30\n" +
+		"panic: runtime error: invalid memory address or nil pointer dereference\n" +
+		"[  100.000003] Read of size 8 at addr ffff88006c2c2728 by task vhost-1234/1234\n" +
+		"[signal SIGSEGV: segmentation violation code=0x1 addr=0x40 pc=0x811ac1]\n" +
+		"[  100.000004] \n" +
+		"\n" +
+		"goroutine 9707990 [running]:\n"
+	cfg := &mgrconfig.Config{
+		TargetOS:          "linux",
+		TargetArch:        "amd64",
+		Type:              "gvisor",
+		SecondaryReporter: "linux",
+	}
+	reporter, err := NewReporter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		output string
+		title  string
+		origin string
+	}{
+		{hostCrash, "linux: KASAN: use-after-free Read in vhost_work_queue", "linux"},
+		{sandboxCrash, "panic: runtime error: invalid memory address or nil pointer dereference", "gvisor"},
+		{interleaved, "panic: runtime error: invalid memory address or nil pointer dereference", "gvisor"},
+		{"[  100.000001] random: crng init done\n", "", ""},
+	}
+	for i, test := range tests {
+		contains := reporter.ContainsCrash([]byte(test.output))
+		rep := reporter.Parse([]byte(test.output))
+		if test.title == "" {
+			if contains || rep != nil {
+				t.Errorf("#%v: found unexpected crash", i)
+			}
+			continue
+		}
+		if !contains || rep == nil {
+			t.Errorf("#%v: crash is not found (contains=%v)", i, contains)
+			continue
+		}
+		if rep.Title != test.title || rep.Origin != test.origin {
+			t.Errorf("#%v: got title %q origin %q, want %q %q", i, rep.Title, rep.Origin, test.title, test.origin)
+		}
+	}
+
+	cfg.SecondaryReporter = "gvisor"
+	if _, err := NewReporter(cfg); err == nil {
+		t.Errorf("secondary reporter same as primary is accepted")
+	}
+	cfg.SecondaryReporter = "foo"
+	if _, err := NewReporter(cfg); err == nil {
+		t.Errorf("unknown secondary reporter is accepted")
+	}
+	cfg.SecondaryReporter = ""
+	reporter, err = NewReporter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep := reporter.Parse([]byte(sandboxCrash)); rep == nil || rep.Origin != "" {
+		t.Errorf("origin is set without secondary reporter: %+v", rep)
+	}
+}
+
+func TestNoCrashReason(t *testing.T) {
+	cfg := &mgrconfig.Config{
+		TargetOS:   "linux",
+		TargetArch: "amd64",
+		Ignores:    []string{"WARNING: .* in foo_bar"},
+	}
+	reporter, err := NewReporter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"[    1.000000] random: crng init done\n": "no oops messages found",
+		"[    1.000000] INFO: lockdep is turned off.\n": `oops message "[    1.000000] INFO: lockdep is turned off." ` +
+			`is benign (matches "INFO: lockdep is turned off")`,
+		"[    1.000000] WARNING: CPU: 0 PID: 1 in foo_bar+0x10/0x20\n": `oops message ` +
+			`"[    1.000000] WARNING: CPU: 0 PID: 1 in foo_bar+0x10/0x20" ` +
+			`is ignored (matches "WARNING: .* in foo_bar" from ignores)`,
+	}
+	for output, want := range tests {
+		if reporter.ContainsCrash([]byte(output)) {
+			t.Errorf("found unexpected crash in %q", output)
+		}
+		if got := NoCrashReason(reporter, []byte(output)); got != want {
+			t.Errorf("got reason:\n%v\nwant:\n%v", got, want)
+		}
+	}
+}
+
+func TestParseFrames(t *testing.T) {
+	report := `WARNING: possible recursive locking detected
+ #0: 00000000d3c36c1b (&pipe->mutex/1){+.+.}, at: pipe_lock+0x56/0x70 fs/pipe.c:70
+Call Trace:
+ __dump_stack lib/dump_stack.c:77 [inline]
+ dump_stack+0x1b9/0x294 lib/dump_stack.c:113
+ ? trace_hardirqs_on+0x10/0x10 kernel/locking/lockdep.c:2920
+ do_splice_from+0x64a/0x1430
+ [<ffffffff81006848>] trace_hardirqs_off_thunk+0x1a/0x1c arch/x86/entry/thunk_64.S:42
+RIP: 0010:native_safe_halt+0x6/0x10 arch/x86/include/asm/irqflags.h:57
+RSP: 0018:ffffffff88e07bc0 EFLAGS: 00000282
+`
+	want := []Frame{
+		{Func: "__dump_stack", File: "lib/dump_stack.c", Line: 77, Inline: true, ReportLine: 3},
+		{Func: "dump_stack", File: "lib/dump_stack.c", Line: 113, ReportLine: 4},
+		{Func: "trace_hardirqs_on", File: "kernel/locking/lockdep.c", Line: 2920, ReportLine: 5},
+		{Func: "trace_hardirqs_off_thunk", File: "arch/x86/entry/thunk_64.S", Line: 42, ReportLine: 7},
+		{Func: "native_safe_halt", File: "arch/x86/include/asm/irqflags.h", Line: 57, ReportLine: 8},
+	}
+	if got := ParseFrames([]byte(report)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got frames:\n%+v\nwant:\n%+v", got, want)
+	}
+}
+
 func TestFuzz(t *testing.T) {
 	for _, data := range []string{
 		"kernel panicType 'help' for a list of commands",
@@ -0,0 +1,29 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package report
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var uptimeRe = regexp.MustCompile(`\[ *([0-9]+)\.([0-9]{6})\]`)
+
+// GuestUptime returns guest uptime at the time of the last console timestamp in output
+// (e.g. "[  123.456789]" printed by kernels with CONFIG_PRINTK_TIME).
+// Returns 0 if output does not contain timestamps.
+func GuestUptime(output []byte) time.Duration {
+	matches := uptimeRe.FindAllSubmatch(output, -1)
+	if len(matches) == 0 {
+		return 0
+	}
+	match := matches[len(matches)-1]
+	secs, err := strconv.ParseUint(string(match[1]), 10, 64)
+	if err != nil {
+		return 0
+	}
+	usecs, _ := strconv.ParseUint(string(match[2]), 10, 64)
+	return time.Duration(secs)*time.Second + time.Duration(usecs)*time.Microsecond
+}
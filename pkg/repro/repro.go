@@ -5,6 +5,7 @@ package repro
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"sort"
@@ -29,8 +30,22 @@ type Result struct {
 	// Information about the final (non-symbolized) crash that we reproduced.
 	// Can be different from what we started reproducing.
 	Report *report.Report
+	// The reproducer triggered the crash on a freshly booted VM during final verification.
+	Verified bool
+	// The final reproducer did not trigger the crash on a freshly booted VM
+	// (e.g. it relied on kernel state left by previous runs), so the result is
+	// the latest earlier candidate that did (or the final reproducer if none did).
+	EnvironmentSensitive bool
+	// Reproduction was interrupted (see ErrInterrupted), the result is the latest intermediate result,
+	// it may be not minimized/simplified and is not verified.
+	Partial bool
 }
 
+// ErrInterrupted is returned by Run if reproduction was interrupted with vm.Shutdown.
+// Tests are stopped at the next safe point and Run returns the latest intermediate result
+// marked as Partial along with the error (nil if nothing was reproduced yet).
+var ErrInterrupted = errors.New("reproduction was interrupted")
+
 type Stats struct {
 	Log              []byte
 	ExtractProgTime  time.Duration
@@ -38,6 +53,7 @@ type Stats struct {
 	SimplifyProgTime time.Duration
 	ExtractCTime     time.Duration
 	SimplifyCTime    time.Duration
+	VerifyTime       time.Duration
 }
 
 type context struct {
@@ -48,6 +64,7 @@ type context struct {
 	bootRequests chan int
 	stats        *Stats
 	report       *report.Report
+	candidates   []*Result // intermediate results, used as fallback if the final result fails verification
 }
 
 type instance struct {
@@ -144,36 +161,74 @@ func Run(crashLog []byte, cfg *mgrconfig.Config, reporter report.Reporter, vmPoo
 	}()
 
 	res, err := ctx.repro(entries, crashStart)
-	if err != nil {
-		return nil, nil, err
-	}
-	if res != nil {
-		ctx.reproLog(3, "repro crashed as (corrupted=%v):\n%s",
-			ctx.report.Corrupted, ctx.report.Report)
-		// Try to rerun the repro if the report is corrupted.
-		for attempts := 0; ctx.report.Corrupted && attempts < 3; attempts++ {
-			ctx.reproLog(3, "report is corrupted, running repro again")
-			if res.CRepro {
-				_, err = ctx.testCProg(res.Prog, res.Duration, res.Opts)
-			} else {
-				_, err = ctx.testProg(res.Prog, res.Duration, res.Opts)
-			}
-			if err != nil {
-				return nil, nil, err
-			}
-		}
-		ctx.reproLog(3, "final repro crashed as (corrupted=%v):\n%s",
-			ctx.report.Corrupted, ctx.report.Report)
-		res.Report = ctx.report
+	if err == nil && res != nil {
+		res, err = ctx.finish(res)
 	}
 
 	close(ctx.bootRequests)
 	for inst := range ctx.instances {
 		inst.Close()
 	}
+	if err == ErrInterrupted {
+		res = ctx.partialResult()
+		ctx.reproLog(0, "interrupted, partial result: %v", res != nil)
+		return res, ctx.stats, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
 	return res, ctx.stats, nil
 }
 
+// finish verifies the final result and gets a non-corrupted report for it.
+func (ctx *context) finish(res *Result) (*Result, error) {
+	// If verification is interrupted, the final result is still the best one.
+	ctx.checkpoint(res)
+	res, err := ctx.verify(res)
+	if err != nil {
+		return nil, err
+	}
+	ctx.reproLog(3, "repro crashed as (corrupted=%v):\n%s",
+		ctx.report.Corrupted, ctx.report.Report)
+	// Try to rerun the repro if the report is corrupted.
+	for attempts := 0; ctx.report.Corrupted && attempts < 3; attempts++ {
+		ctx.reproLog(3, "report is corrupted, running repro again")
+		if res.CRepro {
+			_, err = ctx.testCProg(res.Prog, res.Duration, res.Opts)
+		} else {
+			_, err = ctx.testProg(res.Prog, res.Duration, res.Opts)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	ctx.reproLog(3, "final repro crashed as (corrupted=%v):\n%s",
+		ctx.report.Corrupted, ctx.report.Report)
+	res.Report = ctx.report
+	return res, nil
+}
+
+// partialResult returns the latest intermediate result after interruption (nil if there is none).
+func (ctx *context) partialResult() *Result {
+	if len(ctx.candidates) == 0 {
+		return nil
+	}
+	res := *ctx.candidates[len(ctx.candidates)-1]
+	res.Verified = false
+	res.Partial = true
+	res.Report = ctx.report
+	return &res
+}
+
+func interrupted() bool {
+	select {
+	case <-vm.Shutdown:
+		return true
+	default:
+		return false
+	}
+}
+
 func (ctx *context) repro(entries []*prog.LogEntry, crashStart int) (*Result, error) {
 	// Cut programs that were executed after crash.
 	for i, ent := range entries {
@@ -200,16 +255,19 @@ func (ctx *context) repro(entries []*prog.LogEntry, crashStart int) (*Result, er
 			res.Opts.Repro = false
 		}
 	}()
+	ctx.checkpoint(res)
 	res, err = ctx.minimizeProg(res)
 	if err != nil {
 		return nil, err
 	}
+	ctx.checkpoint(res)
 
 	// Try extracting C repro without simplifying options first.
 	res, err = ctx.extractC(res)
 	if err != nil {
 		return nil, err
 	}
+	ctx.checkpoint(res)
 
 	// Simplify options and try extracting C repro.
 	if !res.CRepro {
@@ -217,6 +275,7 @@ func (ctx *context) repro(entries []*prog.LogEntry, crashStart int) (*Result, er
 		if err != nil {
 			return nil, err
 		}
+		ctx.checkpoint(res)
 	}
 
 	// Simplify C related options.
@@ -372,6 +431,7 @@ func (ctx *context) extractProgBisect(entries []*prog.LogEntry, baseDuration tim
 	calls := 0
 	for _, entry := range entries {
 		if entry.Fault {
+			opts.Fault = true
 			opts.FaultCall = calls + entry.FaultCall
 			opts.FaultNth = entry.FaultNth
 			if entry.FaultCall < 0 || entry.FaultCall >= len(entry.P.Calls) {
@@ -410,19 +470,52 @@ func (ctx *context) minimizeProg(res *Result) (*Result, error) {
 	if res.Opts.Fault {
 		call = res.Opts.FaultCall
 	}
+	test := func(p1 *prog.Prog, opts csource.Options) bool {
+		crashed, err := ctx.testProg(p1, res.Duration, opts)
+		if err == ErrInterrupted {
+			// The program minimized so far is still a valid reproducer.
+			return false
+		}
+		if err != nil {
+			ctx.reproLog(0, "minimization failed with %v", err)
+			return false
+		}
+		return crashed
+	}
 	res.Prog, res.Opts.FaultCall = prog.Minimize(res.Prog, call, true,
 		func(p1 *prog.Prog, callIndex int) bool {
-			crashed, err := ctx.testProg(p1, res.Duration, res.Opts)
-			if err != nil {
-				ctx.reproLog(0, "minimization failed with %v", err)
-				return false
+			opts := res.Opts
+			if !opts.Fault {
+				return test(p1, opts)
+			}
+			// Removal of calls before the faulted one shifts its index.
+			opts.FaultCall = callIndex
+			if test(p1, opts) {
+				return true
+			}
+			// Simplifications can remove operations that preceded the failing one in the faulted call
+			// (e.g. a smaller buffer needs fewer allocations), then the same fault has a smaller nth.
+			for nth := opts.FaultNth - 1; nth >= 0 && nth >= res.Opts.FaultNth-maxFaultNthShift; nth-- {
+				opts.FaultNth = nth
+				if interrupted() {
+					return false
+				}
+				if test(p1, opts) {
+					ctx.reproLog(3, "fault nth shifted from %v to %v", res.Opts.FaultNth, nth)
+					res.Opts.FaultNth = nth
+					return true
+				}
 			}
-			return crashed
+			return false
 		})
 
 	return res, nil
 }
 
+// maxFaultNthShift limits how much smaller fault nth is tried when a minimization step
+// does not reproduce the crash with fault injection.
+const maxFaultNthShift = 3
+
 // Simplify repro options (threaded, collide, sandbox, etc).
 func (ctx *context) simplifyProg(res *Result) (*Result, error) {
 	ctx.reproLog(2, "simplifying guilty program")
@@ -496,6 +589,99 @@ func (ctx *context) simplifyC(res *Result) (*Result, error) {
 	return res, nil
 }
 
+// checkpoint remembers a copy of an intermediate result as a fallback candidate for verify.
+func (ctx *context) checkpoint(res *Result) {
+	res1 := *res
+	res1.Opts.Repro = false
+	if len(ctx.candidates) != 0 && sameResult(ctx.candidates[len(ctx.candidates)-1], &res1) {
+		return
+	}
+	ctx.candidates = append(ctx.candidates, &res1)
+}
+
+func sameResult(res1, res2 *Result) bool {
+	return bytes.Equal(res1.Prog.Serialize(), res2.Prog.Serialize()) && res1.Opts == res2.Opts && res1.CRepro == res2.CRepro && res1.Duration == res2.Duration
+}
+
+// Number of runs of a reproducer during verification,
+// the reproducer is verified if any of them triggers the crash.
+const verifyAttempts = 2
+
+// verify re-runs the final reproducer on a freshly booted VM before declaring success.
+// A reproducer can work only because earlier runs left the kernel in a particular state
+// (a module loaded, a sysctl flipped), such reproducers fail on a clean boot.
+// Each test takes a VM that was booted after the previous test (see returnInstance),
+// so the VM used for minimization is closed and verification starts from scratch.
+// If the final reproducer does not trigger the crash, we fall back to the latest earlier
+// candidate that does, and mark the result as environment-sensitive.
+func (ctx *context) verify(res *Result) (*Result, error) {
+	ctx.reproLog(2, "verifying reproducer on a fresh VM")
+	start := time.Now()
+	defer func() {
+		ctx.stats.VerifyTime = time.Since(start)
+	}()
+
+	ok, err := ctx.verifyResult(res)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		res.Verified = true
+		return res, nil
+	}
+	ctx.reproLog(1, "final reproducer did not trigger the crash on a fresh VM, trying earlier candidates")
+	for i := len(ctx.candidates) - 1; i >= 0; i-- {
+		cand := ctx.candidates[i]
+		if sameResult(cand, res) {
+			continue
+		}
+		ok, err := ctx.verifyResult(cand)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			ctx.reproLog(1, "using earlier candidate (%v/%v), the reproducer is environment-sensitive",
+				i+1, len(ctx.candidates))
+			cand.Verified = true
+			cand.EnvironmentSensitive = true
+			return cand, nil
+		}
+	}
+	ctx.reproLog(1, "no candidate triggered the crash on a fresh VM, the reproducer is environment-sensitive")
+	res.EnvironmentSensitive = true
+	return res, nil
+}
+
+// verifyResult runs the syz reproducer and the C reproducer (if any) on fresh VMs.
+// If only the syz reproducer triggers the crash, the C reproducer is dropped.
+func (ctx *context) verifyResult(res *Result) (bool, error) {
+	for attempt := 0; attempt < verifyAttempts; attempt++ {
+		crashed, err := ctx.testProg(res.Prog, res.Duration, res.Opts)
+		if err != nil {
+			return false, err
+		}
+		if !crashed {
+			continue
+		}
+		if !res.CRepro {
+			return true, nil
+		}
+		for attempt := 0; attempt < verifyAttempts; attempt++ {
+			crashed, err := ctx.testCProg(res.Prog, res.Duration, res.Opts)
+			if err != nil {
+				return false, err
+			}
+			if crashed {
+				return true, nil
+			}
+		}
+		ctx.reproLog(1, "C reproducer did not trigger the crash on a fresh VM, dropping it")
+		res.CRepro = false
+		return true, nil
+	}
+	return false, nil
+}
+
 func (ctx *context) testProg(p *prog.Prog, duration time.Duration, opts csource.Options) (crashed bool, err error) {
 	entry := prog.LogEntry{P: p}
 	if opts.Fault {
@@ -508,9 +694,9 @@ func (ctx *context) testProg(p *prog.Prog, duration time.Duration, opts csource.
 
 func (ctx *context) testProgs(entries []*prog.LogEntry, duration time.Duration, opts csource.Options) (
 	crashed bool, err error) {
-	inst := <-ctx.instances
-	if inst == nil {
-		return false, fmt.Errorf("all VMs failed to boot")
+	inst, err := ctx.takeInstance()
+	if err != nil {
+		return false, err
 	}
 	defer ctx.returnInstance(inst)
 	if len(entries) == 0 {
@@ -546,11 +732,16 @@ func (ctx *context) testProgs(entries []*prog.LogEntry, duration time.Duration,
 	command := instancePkg.ExecprogCmd(inst.execprogBin, inst.executorBin,
 		ctx.cfg.TargetOS, ctx.cfg.TargetArch, opts.Sandbox, opts.Repeat,
 		opts.Threaded, opts.Collide, opts.Procs, -1, -1, vmProgFile)
+	command = instancePkg.CPUSetCmd(command, ctx.cfg.GuestCPUSet)
+	command = instancePkg.MemoryCgroupCmd(command, ctx.cfg.ExecutorMemoryLimit)
 	ctx.reproLog(2, "testing program (duration=%v, %+v): %s", duration, opts, program)
 	return ctx.testImpl(inst.Instance, command, duration)
 }
 
 func (ctx *context) testCProg(p *prog.Prog, duration time.Duration, opts csource.Options) (crashed bool, err error) {
+	if interrupted() {
+		return false, ErrInterrupted
+	}
 	src, err := csource.Write(p, opts)
 	if err != nil {
 		return false, err
@@ -569,9 +760,9 @@ func (ctx *context) testCProg(p *prog.Prog, duration time.Duration, opts csource
 }
 
 func (ctx *context) testBin(bin string, duration time.Duration) (crashed bool, err error) {
-	inst := <-ctx.instances
-	if inst == nil {
-		return false, fmt.Errorf("all VMs failed to boot")
+	inst, err := ctx.takeInstance()
+	if err != nil {
+		return false, err
 	}
 	defer ctx.returnInstance(inst)
 
@@ -588,6 +779,9 @@ func (ctx *context) testImpl(inst *vm.Instance, command string, duration time.Du
 		return false, fmt.Errorf("failed to run command in VM: %v", err)
 	}
 	rep := inst.MonitorExecution(outc, errc, ctx.reporter, true)
+	if rep == nil && interrupted() {
+		return false, ErrInterrupted
+	}
 	if rep == nil {
 		ctx.reproLog(2, "program did not crash")
 		return false, nil
@@ -601,6 +795,25 @@ func (ctx *context) testImpl(inst *vm.Instance, command string, duration time.Du
 	return true, nil
 }
 
+// takeInstance waits for a booted VM, this is the point where interrupted reproduction stops.
+func (ctx *context) takeInstance() (*instance, error) {
+	if interrupted() {
+		return nil, ErrInterrupted
+	}
+	inst := <-ctx.instances
+	if inst == nil {
+		if interrupted() {
+			return nil, ErrInterrupted
+		}
+		return nil, fmt.Errorf("all VMs failed to boot")
+	}
+	if interrupted() {
+		ctx.returnInstance(inst)
+		return nil, ErrInterrupted
+	}
+	return inst, nil
+}
+
 func (ctx *context) returnInstance(inst *instance) {
 	ctx.bootRequests <- inst.index
 	inst.Close()
@@ -849,4 +1062,12 @@ var cSimplifies = append(progSimplifies, []Simplify{
 		opts.HandleSegv = false
 		return true
 	},
+	func(opts *csource.Options) bool {
+		if opts.Prologue == "" && opts.Epilogue == "" {
+			return false
+		}
+		opts.Prologue = ""
+		opts.Epilogue = ""
+		return true
+	},
 }...)
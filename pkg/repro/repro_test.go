@@ -4,12 +4,22 @@
 package repro
 
 import (
+	"bytes"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/syzkaller/pkg/csource"
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/report"
 	"github.com/google/syzkaller/prog"
+	_ "github.com/google/syzkaller/sys"
+	"github.com/google/syzkaller/vm"
+	"github.com/google/syzkaller/vm/vmimpl"
 )
 
 func initTest(t *testing.T) (*rand.Rand, int) {
@@ -97,3 +107,313 @@ func TestSimplifies(t *testing.T) {
 	}
 	check(opts, 0)
 }
+
+func TestCheckpoint(t *testing.T) {
+	target, err := prog.GetTarget("test", "64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, _ := initTest(t)
+	p := target.Generate(rs, 5, nil)
+	ctx := &context{
+		stats: new(Stats),
+	}
+	res := &Result{Prog: p, Duration: time.Minute, Opts: csource.Options{Repro: true}}
+	ctx.checkpoint(res)
+	ctx.checkpoint(res)
+	res.CRepro = true
+	ctx.checkpoint(res)
+	res.Prog = target.Generate(rs, 5, nil)
+	ctx.checkpoint(res)
+	if len(ctx.candidates) != 3 {
+		t.Fatalf("got %v candidates, want 3", len(ctx.candidates))
+	}
+	if ctx.candidates[0].Prog != p || ctx.candidates[0].CRepro || ctx.candidates[0].Opts.Repro {
+		t.Errorf("first candidate is changed: %+v", ctx.candidates[0])
+	}
+	if !ctx.candidates[1].CRepro || ctx.candidates[2].Prog != res.Prog {
+		t.Errorf("bad candidates: %+v %+v", ctx.candidates[1], ctx.candidates[2])
+	}
+}
+
+// testPool creates instances where every program crashes the kernel.
+// The interruptAt-th run closes vm.Shutdown as SIGINT/SIGTERM would do.
+type testPool struct {
+	mu          sync.Mutex
+	runs        int
+	interruptAt int
+	created     int
+	closed      int
+	interrupt   sync.Once
+}
+
+type testInstance struct {
+	pool *testPool
+}
+
+var testVMPool = new(testPool)
+
+func init() {
+	vmimpl.Register("test-repro", func(env *vmimpl.Env) (vmimpl.Pool, error) {
+		return testVMPool, nil
+	}, false)
+}
+
+func (pool *testPool) Count() int {
+	return 2
+}
+
+func (pool *testPool) Create(workdir string, index int) (vmimpl.Instance, error) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.created++
+	return &testInstance{pool}, nil
+}
+
+func (inst *testInstance) Copy(hostSrc string) (string, error) {
+	return "/" + hostSrc, nil
+}
+
+func (inst *testInstance) Forward(port int) (string, error) {
+	return "", vmimpl.ErrUnsupported
+}
+
+func (inst *testInstance) Run(timeout time.Duration, stop <-chan bool, command string) (
+	<-chan []byte, <-chan error, error) {
+	pool := inst.pool
+	pool.mu.Lock()
+	pool.runs++
+	interrupt := pool.runs == pool.interruptAt
+	pool.mu.Unlock()
+	if interrupt {
+		// The program is still running when the signal comes.
+		pool.interrupt.Do(func() { close(vm.Shutdown) })
+		return make(chan []byte), make(chan error), nil
+	}
+	outc := make(chan []byte, 1)
+	outc <- []byte("executing program\nBUG: test crash\n")
+	close(outc)
+	return outc, make(chan error), nil
+}
+
+func (inst *testInstance) Diagnose() bool {
+	return false
+}
+
+func (inst *testInstance) Close() {
+	inst.pool.mu.Lock()
+	defer inst.pool.mu.Unlock()
+	inst.pool.closed++
+}
+
+// testFaultPool simulates a kernel bug that is triggered only by fault injection into getuid.
+// The failing allocation is the 3rd one if the program also calls getgid (which leaves state
+// that getuid allocates for), and the 2nd one otherwise.
+type testFaultPool struct{}
+
+type testFaultInstance struct{}
+
+func init() {
+	vmimpl.Register("test-repro-fault", func(env *vmimpl.Env) (vmimpl.Pool, error) {
+		return new(testFaultPool), nil
+	}, false)
+}
+
+func (pool *testFaultPool) Count() int {
+	return 2
+}
+
+func (pool *testFaultPool) Create(workdir string, index int) (vmimpl.Instance, error) {
+	return new(testFaultInstance), nil
+}
+
+func (inst *testFaultInstance) Copy(hostSrc string) (string, error) {
+	return hostSrc, nil
+}
+
+func (inst *testFaultInstance) Forward(port int) (string, error) {
+	return "", vmimpl.ErrUnsupported
+}
+
+func (inst *testFaultInstance) Run(timeout time.Duration, stop <-chan bool, command string) (
+	<-chan []byte, <-chan error, error) {
+	crashed := false
+	if strings.Contains(command, " -executor=") {
+		// syz-execprog gets the fault from the program log.
+		args := strings.Fields(command)
+		data, err := ioutil.ReadFile(args[len(args)-1])
+		if err != nil {
+			return nil, nil, err
+		}
+		target, err := prog.GetTarget("linux", "amd64")
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, ent := range target.ParseLog(data) {
+			crashed = crashed || testFaultCrashes(ent.P, ent.Fault, ent.FaultCall, ent.FaultNth)
+		}
+	} else {
+		// The compiled C reproducer, its program and options are checked after reproduction.
+		data, err := ioutil.ReadFile(command)
+		if err != nil {
+			return nil, nil, err
+		}
+		crashed = bytes.Contains(data, []byte("/proc/thread-self/fail-nth"))
+	}
+	outc := make(chan []byte, 1)
+	errc := make(chan error, 1)
+	if crashed {
+		outc <- []byte("executing program\nBUG: fault injection crash\n")
+		close(outc)
+		return outc, make(chan error), nil
+	}
+	errc <- nil
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(outc)
+	}()
+	return outc, errc, nil
+}
+
+func (inst *testFaultInstance) Diagnose() bool {
+	return false
+}
+
+func (inst *testFaultInstance) Close() {
+}
+
+func testFaultCrashes(p *prog.Prog, fault bool, call, nth int) bool {
+	if !fault || call < 0 || call >= len(p.Calls) || p.Calls[call].Meta.Name != "getuid" {
+		return false
+	}
+	want := 1
+	for _, c := range p.Calls {
+		if c.Meta.Name == "getgid" {
+			want = 2
+		}
+	}
+	return nth == want
+}
+
+// TestFaultInjection checks that fault injection parameters survive the full reproduction pipeline:
+// extraction, minimization (which shifts both the faulted call and nth), simplification and C reproducer.
+func TestFaultInjection(t *testing.T) {
+	if interrupted() {
+		t.Skip("vm.Shutdown is already closed (-count > 1?)")
+	}
+	dir, err := ioutil.TempDir("", "syz-repro-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &mgrconfig.Config{
+		Workdir:        dir,
+		TargetOS:       "linux",
+		TargetArch:     "amd64",
+		TargetVMArch:   "amd64",
+		Type:           "test-repro-fault",
+		Sandbox:        "none",
+		SyzExecprogBin: "syz-execprog",
+		SyzExecutorBin: "syz-executor",
+	}
+	pool, err := vm.Create(cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reporter, err := report.NewReporter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashLog := []byte("executing program 0:\ngetpid()\n" +
+		"executing program 0 (fault-call:2 fault-nth:2):\ngetpid()\ngetgid()\ngetuid()\n" +
+		"BUG: fault injection crash\n")
+	res, _, err := Run(crashLog, cfg, reporter, pool, []int{0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res == nil {
+		t.Fatalf("failed to reproduce the crash")
+	}
+	if got := string(res.Prog.Serialize()); got != "getuid()\n" {
+		t.Fatalf("got program:\n%s", got)
+	}
+	if !res.Opts.Fault || res.Opts.FaultCall != 0 || res.Opts.FaultNth != 1 {
+		t.Fatalf("got fault options %+v", res.Opts)
+	}
+	if !res.CRepro {
+		t.Fatalf("no C reproducer")
+	}
+	// The options are saved along with the reproducer (see syz-manager).
+	opts, err := csource.DeserializeOptions(res.Opts.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !testFaultCrashes(res.Prog, opts.Fault, opts.FaultCall, opts.FaultNth) {
+		t.Fatalf("deserialized options lost fault injection: %+v", opts)
+	}
+	src, err := csource.Write(res.Prog, res.Opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"setup_fault();", "/sys/kernel/debug/failslab/ignore-gfp-wait", "inject_fault(1);"} {
+		if !bytes.Contains(src, []byte(want)) {
+			t.Errorf("C reproducer does not contain %q:\n%s", want, src)
+		}
+	}
+}
+
+// TestInterrupt checks that interrupted reproduction returns the best result found so far.
+// It closes vm.Shutdown, so it must be the last test that uses VMs.
+func TestInterrupt(t *testing.T) {
+	if interrupted() {
+		t.Skip("vm.Shutdown is already closed (-count > 1?)")
+	}
+	dir, err := ioutil.TempDir("", "syz-repro-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &mgrconfig.Config{
+		Workdir:        dir,
+		TargetOS:       "linux",
+		TargetArch:     "amd64",
+		TargetVMArch:   "amd64",
+		Type:           "test-repro",
+		Sandbox:        "none",
+		SyzExecprogBin: "syz-execprog",
+		SyzExecutorBin: "syz-executor",
+	}
+	pool, err := vm.Create(cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reporter, err := report.NewReporter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The 1st run extracts the program, the 2nd removes a call during minimization,
+	// the 3rd is interrupted.
+	testVMPool.interruptAt = 3
+	crashLog := []byte("executing program 0:\ngetpid()\ngetuid()\ngetgid()\nBUG: test crash\n")
+	res, stats, err := Run(crashLog, cfg, reporter, pool, []int{0, 1})
+	if err != ErrInterrupted {
+		t.Fatalf("got error %v, want %v", err, ErrInterrupted)
+	}
+	if res == nil || !res.Partial || res.Verified || stats == nil {
+		t.Fatalf("got result %+v, want partial result", res)
+	}
+	if len(res.Prog.Calls) != 2 {
+		t.Fatalf("got partially minimized program:\n%s", res.Prog.Serialize())
+	}
+	if res.Report == nil || !strings.Contains(res.Report.Title, "test crash") {
+		t.Fatalf("got report %+v", res.Report)
+	}
+	testVMPool.mu.Lock()
+	defer testVMPool.mu.Unlock()
+	if testVMPool.runs != testVMPool.interruptAt {
+		t.Errorf("got %v runs, want %v", testVMPool.runs, testVMPool.interruptAt)
+	}
+	if testVMPool.created != testVMPool.closed {
+		t.Errorf("created %v VMs, but closed %v", testVMPool.created, testVMPool.closed)
+	}
+}
@@ -6,6 +6,8 @@
 package rpctype
 
 import (
+	"time"
+
 	"github.com/google/syzkaller/pkg/host"
 	"github.com/google/syzkaller/pkg/ipc"
 	"github.com/google/syzkaller/pkg/signal"
@@ -16,14 +18,31 @@ type RPCInput struct {
 	Prog   []byte
 	Signal signal.Serial
 	Cover  []uint32
+	// Provenance of the input: how it was obtained (one of Origin* constants)
+	// and hash of the corpus program it was derived from (if any).
+	Origin string
+	Parent string
 }
 
 type RPCCandidate struct {
 	Prog      []byte
 	Minimized bool
 	Smashed   bool
+	Origin    string
 }
 
+// Origins of corpus programs.
+const (
+	OriginGenerate = "generate"
+	OriginMutate   = "mutate"
+	OriginSmash    = "smash" // mutation of a program that was just added to corpus
+	OriginHint     = "hint"  // mutation based on comparison operands
+	OriginCorpus   = "corpus"
+	OriginHub      = "hub"
+	OriginForeign  = "foreign"
+	OriginSibling  = "sibling"
+)
+
 type ConnectArgs struct {
 	Name string
 }
@@ -35,6 +54,21 @@ type ConnectRes struct {
 	AllSandboxes     bool
 	CheckResult      *CheckArgs
 	MemoryLeakFrames [][]byte
+	ProgPrologue     string
+	ProgEpilogue     string
+	ProgHookTimeout  time.Duration
+	ProgHookRestart  bool
+	Seed             int64 // PRNG seed for the fuzzer (0 for random)
+	// Bounds on the number of fuzzer processes and guest memory in MB per process
+	// if the fuzzer chooses the number of processes itself (MaxProcs is 0 otherwise).
+	MinProcs   int
+	MaxProcs   int
+	MemPerProc int
+	// Sampled profiling of slow programs (ProfileTracer is empty if disabled), see mgrconfig.SlowProfiles.
+	ProfileTracer    string
+	ProfileSample    int
+	ProfileThreshold time.Duration
+	ProfileMaxSize   int // in bytes
 }
 
 type CheckArgs struct {
@@ -55,17 +89,64 @@ type NewInputArgs struct {
 	RPCInput
 }
 
+// NewProfileArgs is a kernel profile of a slow program execution,
+// or the reason profiling is disabled in the VM if Error is set.
+type NewProfileArgs struct {
+	Name     string
+	Prog     []byte
+	Duration time.Duration
+	Tracer   string
+	Profile  []byte
+	Error    string
+}
+
 type PollArgs struct {
 	Name           string
 	NeedCandidates bool
 	MaxSignal      signal.Serial
 	Stats          map[string]uint64
+	ProcExecs      []uint64               // executions of each fuzzer process since the last poll
+	Procs          int                    // number of fuzzer processes
+	CallErrnos     map[string]*CallErrnos // sampled errno histograms of syscalls since the last poll
+}
+
+// CallErrnos is a sampled errno histogram of a syscall.
+type CallErrnos struct {
+	Errnos       map[int]uint64 // errno (0 for success) -> number of sampled executions
+	Example      []byte         // a program where the call failed (nil if it never failed)
+	ExampleErrno int            // errno of the call in Example
 }
 
 type PollRes struct {
 	Candidates []RPCCandidate
 	NewInputs  []RPCInput
 	MaxSignal  signal.Serial
+	Replays    []RPCReplay
+	Focus      *RPCFocus // the active focus (nil if there is none)
+}
+
+// RPCFocus biases fuzzing toward a set of syscalls for a limited time (see focus on the crash page).
+// Fuzzers keep the focus while the manager sends it in poll results.
+type RPCFocus struct {
+	ID     int
+	Calls  []string // names of the focused syscalls
+	Weight int      // multiplier of priorities of the focused syscalls
+	Fault  bool     // inject faults into the focused syscalls
+}
+
+// RPCReplay is a request to execute a corpus program once with coverage collection,
+// the result is sent back with Manager.ReplayDone.
+type RPCReplay struct {
+	ID   int
+	Prog []byte
+}
+
+type ReplayDoneArgs struct {
+	Name   string
+	ID     int
+	Cover  [][]uint32      // coverage of each call of the program
+	Signal []signal.Serial // signal of each call of the program
+	Error  string          // the program could not be executed
 }
 
 type HubConnectArgs struct {
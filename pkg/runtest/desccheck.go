@@ -0,0 +1,292 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package runtest
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/syzkaller/pkg/ast"
+	"github.com/google/syzkaller/pkg/host"
+	"github.com/google/syzkaller/pkg/ipc"
+	"github.com/google/syzkaller/prog"
+)
+
+// DefaultDescCheckSkip matches calls that are not executed by DescCheck by default
+// because they disrupt the machine or the executor (reboot it, kill processes, unmount or sync filesystems, etc).
+const DefaultDescCheckSkip = `^(reboot|kexec_load|kexec_file_load|sync|syncfs|sync_file_range|` +
+	`swapon|swapoff|mount|umount2|pivot_root|chroot|init_module|finit_module|delete_module|acct|` +
+	`setrlimit|prlimit64|vhangup|ptrace|kill|tkill|tgkill|exit|exit_group|syz_execute_func)(\$.*)?$`
+
+// DescCheck checks syscall descriptions against a live kernel.
+// It executes a number of minimal well-formed invocations of each enabled syscall
+// (the call itself preceded by calls that create resources it needs), collects errno distributions
+// and flags calls that always fail with errors that usually mean a wrong description
+// (e.g. EINVAL for a wrong struct size or ioctl number, ENOSYS for a wrong syscall number,
+// EFAULT for a wrong pointer direction or layout).
+type DescCheck struct {
+	Target       *prog.Target
+	Features     *host.Features
+	Sandbox      string
+	EnabledCalls map[*prog.Syscall]bool
+	Files        map[string]string // call name -> description file, see DescriptionFiles
+	Skip         *regexp.Regexp    // calls that are not executed, DefaultDescCheckSkip if nil
+	Runs         int               // invocations per call, 10 if 0
+	Rate         float64           // max invocations per second, 0 means no limit
+	Seed         int64
+	Requests     chan *RunRequest
+	LogFunc      func(text string)
+}
+
+// CallErrnos is the result of checking a single call.
+type CallErrnos struct {
+	Call       string
+	File       string
+	Errnos     map[int]int    // errno (0 for success) -> number of finished invocations
+	Blocked    int            // invocations that did not finish
+	Failed     int            // invocations where the call was not executed
+	Errors     map[string]int // execution errors and machine crashes -> number of invocations
+	Suspicious bool           // all finished invocations failed with suspicious errors
+}
+
+// DescriptionFiles maps names of all calls described in the descriptions dir (sys/OS)
+// to the names of the files they are described in.
+func DescriptionFiles(dir string) (map[string]string, error) {
+	desc := ast.ParseGlob(filepath.Join(dir, "*.txt"), nil)
+	if desc == nil {
+		return nil, fmt.Errorf("failed to parse descriptions in %v", dir)
+	}
+	files := make(map[string]string)
+	for _, node := range desc.Nodes {
+		if call, ok := node.(*ast.Call); ok {
+			files[call.Name.Name] = filepath.Base(call.Pos.File)
+		}
+	}
+	return files, nil
+}
+
+func (dc *DescCheck) log(msg string, args ...interface{}) {
+	dc.LogFunc(fmt.Sprintf(msg, args...))
+}
+
+// Run executes the calls and returns results sorted by description file and call name.
+func (dc *DescCheck) Run() ([]*CallErrnos, error) {
+	skip := dc.Skip
+	if skip == nil {
+		skip = regexp.MustCompile(DefaultDescCheckSkip)
+	}
+	runs := dc.Runs
+	if runs == 0 {
+		runs = 10
+	}
+	features := dc.Features
+	if features == nil {
+		features = new(host.Features)
+	}
+	var calls []*prog.Syscall
+	enabled := make(map[*prog.Syscall]bool)
+	for call := range dc.EnabledCalls {
+		if skip.MatchString(call.Name) {
+			continue
+		}
+		enabled[call] = true
+		calls = append(calls, call)
+	}
+	if len(calls) == 0 {
+		close(dc.Requests)
+		return nil, fmt.Errorf("no calls to check")
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		return calls[i].Name < calls[j].Name
+	})
+	dc.log("checking %v calls (%v skipped), %v runs each",
+		len(calls), len(dc.EnabledCalls)-len(calls), runs)
+	ct := dc.Target.BuildChoiceTable(dc.Target.CalculatePriorities(nil), enabled)
+	ctx := &Context{Features: features}
+	type pending struct {
+		res *CallErrnos
+		req *RunRequest
+	}
+	progs := make(chan pending, 1000+2*cap(dc.Requests))
+	errc := make(chan error, 1)
+	results := make([]*CallErrnos, len(calls))
+	go func() {
+		defer close(progs)
+		defer close(dc.Requests)
+		var tick <-chan time.Time
+		if dc.Rate > 0 {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / dc.Rate))
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		rs := rand.NewSource(dc.Seed)
+		for i, call := range calls {
+			results[i] = &CallErrnos{
+				Call:   call.Name,
+				File:   dc.Files[call.Name],
+				Errnos: make(map[int]int),
+				Errors: make(map[string]int),
+			}
+			for run := 0; run < runs; run++ {
+				p := dc.Target.GenerateCallProg(rs, ct, call)
+				req, err := ctx.createSyzTest(p, dc.Sandbox, false, false)
+				if err != nil {
+					errc <- err
+					return
+				}
+				req.Repeat = 1
+				req.Done = make(chan struct{})
+				if tick != nil {
+					<-tick
+				}
+				dc.Requests <- req
+				progs <- pending{results[i], req}
+			}
+		}
+		errc <- nil
+	}()
+	done := 0
+	for pend := range progs {
+		<-pend.req.Done
+		pend.res.add(pend.req)
+		if done++; done%1000 == 0 {
+			dc.log("executed %v programs", done)
+		}
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	suspicious := suspiciousErrnos(dc.Target.OS)
+	for _, res := range results {
+		res.Suspicious = res.isSuspicious(suspicious)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].File < results[j].File
+	})
+	return results, nil
+}
+
+func (res *CallErrnos) add(req *RunRequest) {
+	if req.Err != nil {
+		res.Errors[strings.SplitN(req.Err.Error(), "\n", 2)[0]]++
+		return
+	}
+	if len(req.Info) == 0 || len(req.Info[0].Calls) != len(req.P.Calls) {
+		res.Errors["no call info"]++
+		return
+	}
+	info := req.Info[0].Calls[len(req.P.Calls)-1]
+	switch {
+	case info.Flags&ipc.CallExecuted == 0:
+		res.Failed++
+	case info.Flags&ipc.CallFinished == 0:
+		res.Blocked++
+	default:
+		res.Errnos[info.Errno]++
+	}
+}
+
+func (res *CallErrnos) isSuspicious(suspicious map[int]bool) bool {
+	if len(res.Errnos) == 0 {
+		return false
+	}
+	for errno := range res.Errnos {
+		if !suspicious[errno] {
+			return false
+		}
+	}
+	return true
+}
+
+// suspiciousErrnos returns errnos that usually mean a wrong description when a call always fails with them:
+// EFAULT, EINVAL and ENOSYS.
+func suspiciousErrnos(OS string) map[int]bool {
+	switch OS {
+	case "linux", "test":
+		return map[int]bool{14: true, 22: true, 38: true}
+	case "freebsd", "netbsd", "openbsd", "darwin":
+		return map[int]bool{14: true, 22: true, 78: true}
+	default:
+		return map[int]bool{}
+	}
+}
+
+// WriteDescCheckReport writes the results grouped by description file.
+// If all is not set, only files with suspicious calls are included.
+func WriteDescCheckReport(w io.Writer, OS string, results []*CallErrnos, all bool) {
+	suspicious, total := 0, 0
+	for i := 0; i < len(results); {
+		file := results[i].File
+		end := i
+		show := all
+		for ; end < len(results) && results[end].File == file; end++ {
+			show = show || results[end].Suspicious
+		}
+		if show {
+			if file == "" {
+				file = "unknown file"
+			}
+			fmt.Fprintf(w, "%v:\n", file)
+		}
+		for ; i < end; i++ {
+			res := results[i]
+			total++
+			mark := ""
+			if res.Suspicious {
+				suspicious++
+				mark = "SUSPICIOUS "
+			}
+			if show && (all || res.Suspicious) {
+				fmt.Fprintf(w, "\t%-40v %v%v\n", res.Call, mark, res.summary(OS))
+			}
+		}
+	}
+	fmt.Fprintf(w, "checked %v calls, %v suspicious\n", total, suspicious)
+}
+
+func (res *CallErrnos) summary(OS string) string {
+	var errnos []int
+	for errno := range res.Errnos {
+		errnos = append(errnos, errno)
+	}
+	sort.Ints(errnos)
+	var parts []string
+	for _, errno := range errnos {
+		parts = append(parts, fmt.Sprintf("%v:%v", errnoName(OS, errno), res.Errnos[errno]))
+	}
+	if res.Blocked != 0 {
+		parts = append(parts, fmt.Sprintf("blocked:%v", res.Blocked))
+	}
+	if res.Failed != 0 {
+		parts = append(parts, fmt.Sprintf("not executed:%v", res.Failed))
+	}
+	var errors []string
+	for err := range res.Errors {
+		errors = append(errors, err)
+	}
+	sort.Strings(errors)
+	for _, err := range errors {
+		parts = append(parts, fmt.Sprintf("%q:%v", err, res.Errors[err]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func errnoName(OS string, errno int) string {
+	if errno == 0 {
+		return "ok"
+	}
+	if OS == runtime.GOOS {
+		// Host errno values are the same as the target ones.
+		return fmt.Sprintf("%v(%v)", errno, syscall.Errno(errno).Error())
+	}
+	return fmt.Sprint(errno)
+}
@@ -0,0 +1,86 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package runtest
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/ipc"
+	"github.com/google/syzkaller/prog"
+)
+
+func TestDescCheck(t *testing.T) {
+	target, err := prog.GetTarget("test", "64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enabled := make(map[*prog.Syscall]bool)
+	files := make(map[string]string)
+	for _, call := range target.Syscalls {
+		enabled[call] = true
+		files[call.Name] = "other.txt"
+		if strings.HasPrefix(call.Name, "test$res") {
+			files[call.Name] = "res.txt"
+		}
+	}
+	// The "kernel" rejects test$res2 with EINVAL and everything else succeeds.
+	requests := make(chan *RunRequest, 10)
+	executed := make(map[string]bool)
+	go func() {
+		for req := range requests {
+			info := &ipc.ProgInfo{}
+			for _, c := range req.P.Calls {
+				ci := ipc.CallInfo{Flags: ipc.CallExecuted | ipc.CallFinished}
+				if c.Meta.Name == "test$res2" {
+					ci.Errno = 22
+				}
+				info.Calls = append(info.Calls, ci)
+			}
+			executed[req.P.Calls[len(req.P.Calls)-1].Meta.Name] = true
+			req.Info = []*ipc.ProgInfo{info}
+			close(req.Done)
+		}
+	}()
+	dc := &DescCheck{
+		Target:       target,
+		Sandbox:      "none",
+		EnabledCalls: enabled,
+		Files:        files,
+		Skip:         regexp.MustCompile(`^test\$res0$`),
+		Runs:         3,
+		Requests:     requests,
+		LogFunc:      func(text string) { t.Log(text) },
+	}
+	results, err := dc.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if executed["test$res0"] {
+		t.Errorf("skipped call was executed")
+	}
+	if len(results) != len(enabled)-1 {
+		t.Fatalf("got %v results, want %v", len(results), len(enabled)-1)
+	}
+	for i, res := range results {
+		if i != 0 && results[i-1].File > res.File {
+			t.Errorf("results are not sorted by file: %v after %v", res.File, results[i-1].File)
+		}
+		if want := res.Call == "test$res2"; res.Suspicious != want {
+			t.Errorf("call %v: suspicious %v, want %v (%+v)", res.Call, res.Suspicious, want, res)
+		}
+		if n := res.Errnos[0] + res.Errnos[22]; n != dc.Runs {
+			t.Errorf("call %v: %v results, want %v", res.Call, n, dc.Runs)
+		}
+	}
+	buf := new(bytes.Buffer)
+	WriteDescCheckReport(buf, target.OS, results, false)
+	report := buf.String()
+	t.Logf("%s", report)
+	if !strings.HasPrefix(report, "res.txt:\n\ttest$res2 ") || strings.Contains(report, "other.txt") {
+		t.Errorf("bad report:\n%s", report)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// Package syzkaller is a small stable API for embedding syzkaller in other tools:
+// load a target, parse/generate/mutate programs, create a VM pool from a manager config,
+// run a program in a VM and get the crash report back.
+// All other packages are internal and change without notice. This package is backed by them,
+// but its surface is versioned: incompatible changes of the exported API bump the major Version,
+// compatible additions bump the minor Version. Types of internal packages are never exposed.
+package syzkaller
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/instance"
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/osutil"
+	"github.com/google/syzkaller/pkg/report"
+	"github.com/google/syzkaller/prog"
+	_ "github.com/google/syzkaller/sys" // register targets
+	"github.com/google/syzkaller/vm"
+)
+
+// Version is the version of the API in the major.minor form.
+const Version = "1.0"
+
+// Target is a kernel OS/arch with its syscall descriptions.
+type Target struct {
+	target  *prog.Target
+	ctOnce  sync.Once
+	ctTable *prog.ChoiceTable
+}
+
+// Program is a sequence of syscalls of a target.
+type Program struct {
+	target *Target
+	prog   *prog.Prog
+}
+
+// Report is a kernel crash detected in a VM.
+type Report struct {
+	// Title is a short description of the crash, the same crash has the same title.
+	Title string
+	// Report is the crash text extracted from the console output.
+	Report []byte
+	// Output is the console output around the crash.
+	Output []byte
+	// Corrupted is set if the crash text is truncated or garbled.
+	Corrupted bool
+}
+
+// LoadTarget returns the target for the OS and arch, e.g. "linux" and "amd64".
+func LoadTarget(OS, arch string) (*Target, error) {
+	target, err := prog.GetTarget(OS, arch)
+	if err != nil {
+		return nil, err
+	}
+	return &Target{target: target}, nil
+}
+
+// OS returns the OS of the target.
+func (t *Target) OS() string {
+	return t.target.OS
+}
+
+// Arch returns the arch of the target.
+func (t *Target) Arch() string {
+	return t.target.Arch
+}
+
+// Parse parses a program in the text format (as written by Program.Serialize).
+// Unknown calls are rejected, missing arguments get default values.
+func (t *Target) Parse(data []byte) (*Program, error) {
+	p, err := t.target.Deserialize(data, prog.NonStrict)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{t, p}, nil
+}
+
+// Generate generates a random program with about ncalls calls of all syscalls of the target.
+// The same seed produces the same program with the same descriptions.
+func (t *Target) Generate(seed int64, ncalls int) *Program {
+	p := t.target.Generate(rand.NewSource(seed), ncalls, t.choiceTable())
+	return &Program{t, p}
+}
+
+func (t *Target) choiceTable() *prog.ChoiceTable {
+	t.ctOnce.Do(func() {
+		t.ctTable = t.target.BuildChoiceTable(nil, nil)
+	})
+	return t.ctTable
+}
+
+// Mutate randomly mutates the program in place, corpus programs can be spliced into it.
+// All programs must belong to the same target.
+func (p *Program) Mutate(seed int64, ncalls int, corpus []*Program) {
+	var progs []*prog.Prog
+	for _, cp := range corpus {
+		progs = append(progs, cp.prog)
+	}
+	p.prog.Mutate(rand.NewSource(seed), ncalls, p.target.choiceTable(), progs)
+}
+
+// Clone returns a deep copy of the program.
+func (p *Program) Clone() *Program {
+	return &Program{p.target, p.prog.Clone()}
+}
+
+// Calls returns the number of calls in the program.
+func (p *Program) Calls() int {
+	return len(p.prog.Calls)
+}
+
+// Serialize returns the program in the text format.
+func (p *Program) Serialize() []byte {
+	return p.prog.Serialize()
+}
+
+// Pool is a pool of VMs created from a syz-manager config.
+type Pool struct {
+	cfg      *mgrconfig.Config
+	target   *Target
+	pool     *vm.Pool
+	reporter report.Reporter
+}
+
+// NewPool creates a VM pool from a syz-manager config (see docs/configuration.md) in JSON.
+// Fields that are used only by syz-manager are ignored. VMs are booted when programs are run.
+func NewPool(config []byte) (*Pool, error) {
+	cfg, err := mgrconfig.LoadData(config)
+	if err != nil {
+		return nil, err
+	}
+	target, err := LoadTarget(cfg.TargetOS, cfg.TargetArch)
+	if err != nil {
+		return nil, err
+	}
+	reporter, err := report.NewReporter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := osutil.MkdirAll(cfg.Workdir); err != nil {
+		return nil, fmt.Errorf("failed to create workdir: %v", err)
+	}
+	pool, err := vm.Create(cfg, false)
+	if err != nil {
+		return nil, err
+	}
+	return &Pool{
+		cfg:      cfg,
+		target:   target,
+		pool:     pool,
+		reporter: reporter,
+	}, nil
+}
+
+// Target returns the target of the VMs.
+func (pool *Pool) Target() *Target {
+	return pool.target
+}
+
+// Count returns the number of VMs in the pool.
+func (pool *Pool) Count() int {
+	return pool.pool.Count()
+}
+
+// Run boots VM index (in [0, Count)), executes the program in it (once, in a single process)
+// and returns the crash report, or nil if the kernel did not crash within timeout.
+// Runs on different VMs can proceed in parallel.
+func (pool *Pool) Run(index int, p *Program, timeout time.Duration) (*Report, error) {
+	if p.target.target != pool.target.target {
+		return nil, fmt.Errorf("program target %v/%v does not match pool target %v/%v",
+			p.target.OS(), p.target.Arch(), pool.target.OS(), pool.target.Arch())
+	}
+	inst, err := pool.pool.Create(index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create VM: %v", err)
+	}
+	defer inst.Close()
+	execprogBin, err := inst.Copy(pool.cfg.SyzExecprogBin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy to VM: %v", err)
+	}
+	executorBin, err := inst.Copy(pool.cfg.SyzExecutorBin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy to VM: %v", err)
+	}
+	progFile, err := osutil.WriteTempFile(p.Serialize())
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(progFile)
+	vmProgFile, err := inst.Copy(progFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy to VM: %v", err)
+	}
+	command := instance.ExecprogCmd(execprogBin, executorBin, pool.cfg.TargetOS, pool.cfg.TargetArch,
+		pool.cfg.Sandbox, false, false, false, 1, -1, 0, vmProgFile)
+	outc, errc, err := inst.Run(timeout, nil, command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run program in VM: %v", err)
+	}
+	return newReport(inst.MonitorExecution(outc, errc, pool.reporter, true)), nil
+}
+
+// ParseReport returns the first kernel crash in console output of the pool VMs, or nil if there is none.
+func (pool *Pool) ParseReport(output []byte) *Report {
+	return newReport(pool.reporter.Parse(output))
+}
+
+func newReport(rep *report.Report) *Report {
+	if rep == nil {
+		return nil
+	}
+	return &Report{
+		Title:     rep.Title,
+		Report:    rep.Report,
+		Output:    rep.Output,
+		Corrupted: rep.Corrupted,
+	}
+}
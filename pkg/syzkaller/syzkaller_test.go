@@ -0,0 +1,181 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package syzkaller
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/osutil"
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+// testPool creates VMs that crash the kernel if the executed program calls getpid.
+type testPool struct{}
+
+func (pool *testPool) Count() int {
+	return 2
+}
+
+func (pool *testPool) Create(workdir string, index int) (vmimpl.Instance, error) {
+	return &testInstance{files: make(map[string][]byte)}, nil
+}
+
+type testInstance struct {
+	files map[string][]byte // VM file -> contents
+}
+
+func (inst *testInstance) Copy(hostSrc string) (string, error) {
+	data, err := ioutil.ReadFile(hostSrc)
+	if err != nil {
+		return "", err
+	}
+	vmDst := "/" + filepath.Base(hostSrc)
+	inst.files[vmDst] = data
+	return vmDst, nil
+}
+
+func (inst *testInstance) Forward(port int) (string, error) {
+	return "", vmimpl.ErrUnsupported
+}
+
+func (inst *testInstance) Run(timeout time.Duration, stop <-chan bool, command string) (
+	<-chan []byte, <-chan error, error) {
+	outc := make(chan []byte, 1)
+	errc := make(chan error, 1)
+	args := strings.Fields(command)
+	prog := inst.files[args[len(args)-1]]
+	if !strings.HasPrefix(args[0], "/syz-execprog") || prog == nil {
+		outc <- []byte("bad command: " + command + "\n")
+		errc <- nil
+		return outc, errc, nil
+	}
+	if bytes.Contains(prog, []byte("getpid()")) {
+		outc <- []byte("executing program\nBUG: kernel NULL pointer dereference, address: 0000000000000000\n")
+		close(outc)
+		return outc, errc, nil
+	}
+	outc <- []byte("executing program\n")
+	errc <- nil
+	return outc, errc, nil
+}
+
+func (inst *testInstance) Diagnose() bool {
+	return false
+}
+
+func (inst *testInstance) Close() {
+}
+
+func init() {
+	vmimpl.Register("test-api", func(env *vmimpl.Env) (vmimpl.Pool, error) {
+		return &testPool{}, nil
+	}, false)
+}
+
+// TestAPI exercises the whole supported flow, changes that break it need a new major Version.
+func TestAPI(t *testing.T) {
+	if Version != "1.0" {
+		t.Fatalf("unexpected API version %v", Version)
+	}
+	target, err := LoadTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.OS() != "linux" || target.Arch() != "amd64" {
+		t.Fatalf("got target %v/%v", target.OS(), target.Arch())
+	}
+	if _, err := target.Parse([]byte("no_such_syscall()\n")); err == nil {
+		t.Fatalf("parsed a bad program")
+	}
+	crasher, err := target.Parse([]byte("getpid()\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	benign, err := target.Parse([]byte("getuid()\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated := target.Generate(0, 10)
+	if generated.Calls() == 0 {
+		t.Fatalf("generated an empty program")
+	}
+	if again := target.Generate(0, 10); !bytes.Equal(again.Serialize(), generated.Serialize()) {
+		t.Fatalf("generation is not deterministic:\n%s\n\n%s", generated.Serialize(), again.Serialize())
+	}
+	mutated := generated.Clone()
+	for i := int64(0); bytes.Equal(mutated.Serialize(), generated.Serialize()); i++ {
+		mutated.Mutate(i, 10, []*Program{crasher, benign})
+	}
+	if _, err := target.Parse(mutated.Serialize()); err != nil {
+		t.Fatalf("failed to parse mutated program: %v\n%s", err, mutated.Serialize())
+	}
+
+	dir, err := ioutil.TempDir("", "syz-api-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pool := createTestPool(t, dir)
+	if pool.Count() != 2 {
+		t.Fatalf("got %v VMs", pool.Count())
+	}
+	rep, err := pool.Run(1, crasher, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep == nil || !strings.Contains(rep.Title, "NULL pointer dereference") {
+		t.Fatalf("got report %+v", rep)
+	}
+	if rep, err := pool.Run(0, benign, time.Minute); err != nil || rep != nil {
+		t.Fatalf("got report %+v, error %v", rep, err)
+	}
+	if rep := pool.ParseReport([]byte("BUG: KASAN: use-after-free in foo\n")); rep == nil ||
+		rep.Title != "KASAN: use-after-free in foo" {
+		t.Fatalf("got parsed report %+v", rep)
+	}
+	if rep := pool.ParseReport([]byte("all good\n")); rep != nil {
+		t.Fatalf("got parsed report %+v", rep)
+	}
+	other, err := LoadTarget("linux", "386")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Run(0, other.Generate(0, 1), time.Minute); err == nil {
+		t.Fatalf("ran a program of another target")
+	}
+}
+
+func createTestPool(t *testing.T, dir string) *Pool {
+	binDir := filepath.Join(dir, "bin", "linux_amd64")
+	if err := osutil.MkdirAll(binDir); err != nil {
+		t.Fatal(err)
+	}
+	for _, bin := range []string{"syz-fuzzer", "syz-execprog", "syz-executor"} {
+		if err := osutil.WriteFile(filepath.Join(binDir, bin), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg, err := json.Marshal(map[string]interface{}{
+		"target":    "linux/amd64",
+		"http":      "127.0.0.1:0",
+		"workdir":   filepath.Join(dir, "workdir"),
+		"syzkaller": dir,
+		"type":      "test-api",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := NewPool(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
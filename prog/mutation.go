@@ -6,6 +6,7 @@ package prog
 import (
 	"fmt"
 	"math/rand"
+	"sort"
 	"unsafe"
 )
 
@@ -50,21 +51,125 @@ type mutator struct {
 	corpus []*Prog
 }
 
+// splice inserts a random segment of a random corpus program into p.
 func (ctx *mutator) splice() bool {
 	p, r := ctx.p, ctx.r
 	if len(ctx.corpus) == 0 || len(p.Calls) == 0 {
 		return false
 	}
 	p0 := ctx.corpus[r.Intn(len(ctx.corpus))]
-	p0c := p0.Clone()
+	if len(p0.Calls) == 0 {
+		return false
+	}
 	idx := r.Intn(len(p.Calls))
-	p.Calls = append(p.Calls[:idx], append(p0c.Calls, p.Calls[idx:]...)...)
+	begin := r.Intn(len(p0.Calls))
+	end := begin + 1 + r.Intn(len(p0.Calls)-begin)
+	calls := ctx.spliceCalls(p0.Clone(), idx, begin, end)
+	if len(calls) == 0 {
+		return false
+	}
+	p.Calls = append(p.Calls[:idx], append(calls, p.Calls[idx:]...)...)
 	for i := len(p.Calls) - 1; i >= ctx.ncalls; i-- {
 		p.removeCall(i)
 	}
 	return true
 }
 
+// spliceCalls returns calls [begin, end) of p0 (a clone of a corpus program) prepared to be inserted
+// into p before call idx. Resources that the segment uses, but that are created in p0 before it,
+// are replaced with compatible resources created in p before idx; if p has none, the calls of p0
+// that create them (with their own dependencies) are inserted before the segment.
+// The segment is shortened so that the inserted calls fit into ncalls, returns nil if nothing fits.
+func (ctx *mutator) spliceCalls(p0 *Prog, idx, begin, end int) []*Call {
+	s := analyze(ctx.ct, ctx.p, ctx.p.Calls[idx])
+	producers := make(map[*ResultArg]int)
+	for i, c := range p0.Calls {
+		ForeachArg(c, func(arg Arg, _ *ArgCtx) {
+			if a, ok := arg.(*ResultArg); ok {
+				producers[a] = i
+			}
+		})
+	}
+	for ; end > begin; end-- {
+		pulled, remap := ctx.reconcileResources(s, p0, producers, begin, end)
+		if len(pulled)+end-begin > ctx.ncalls-idx {
+			continue
+		}
+		for a, res := range remap {
+			delete(a.Res.uses, a)
+			a.Res = res
+			if res.uses == nil {
+				res.uses = make(map[*ResultArg]bool)
+			}
+			res.uses[a] = true
+		}
+		for i := len(p0.Calls) - 1; i >= 0; i-- {
+			if i >= end || i < begin && !pulled[i] {
+				p0.removeCall(i)
+			}
+		}
+		return p0.Calls
+	}
+	return nil
+}
+
+// reconcileResources decides how resources used by calls [begin, end) of p0 that are created before
+// the segment are provided: remap contains replacements with resources created in p (described by s),
+// pulled contains indexes of the calls of p0 that need to be inserted along with the segment.
+func (ctx *mutator) reconcileResources(s *state, p0 *Prog, producers map[*ResultArg]int, begin, end int) (
+	pulled map[int]bool, remap map[*ResultArg]*ResultArg) {
+	pulled = make(map[int]bool)
+	remap = make(map[*ResultArg]*ResultArg)
+	var queue []int
+	for i := begin; i < end; i++ {
+		queue = append(queue, i)
+	}
+	for len(queue) != 0 {
+		c := p0.Calls[queue[0]]
+		queue = queue[1:]
+		ForeachArg(c, func(arg Arg, _ *ArgCtx) {
+			a, ok := arg.(*ResultArg)
+			if !ok || a.Res == nil {
+				return
+			}
+			producer := producers[a.Res]
+			if producer >= begin || pulled[producer] {
+				return
+			}
+			if res := ctx.existingResource(s, a); res != nil {
+				remap[a] = res
+				return
+			}
+			pulled[producer] = true
+			queue = append(queue, producer)
+		})
+	}
+	return
+}
+
+// existingResource returns a random resource created in p (described by s) that is compatible with arg.
+func (ctx *mutator) existingResource(s *state, arg *ResultArg) *ResultArg {
+	typ, ok := arg.Type().(*ResourceType)
+	if !ok {
+		return nil
+	}
+	var names []string
+	for name := range s.resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var all []*ResultArg
+	for _, name := range names {
+		if ctx.p.Target.isCompatibleResource(typ.Desc.Name, name) {
+			all = append(all, s.resources[name]...)
+		}
+	}
+	if len(all) == 0 {
+		return nil
+	}
+	return all[ctx.r.Intn(len(all))]
+}
+
 func (ctx *mutator) squashAny() bool {
 	p, r := ctx.p, ctx.r
 	complexPtrs := p.complexPtrs()
@@ -67,6 +67,69 @@ func TestMutateCorpus(t *testing.T) {
 	}
 }
 
+func TestSpliceResources(t *testing.T) {
+	target, rs, iters := initTest(t)
+	r := newRand(target, rs)
+	uses, dangling, pulled := 0, 0, 0
+	for i := 0; i < iters; i++ {
+		p := target.Generate(rs, 10, nil)
+		p0 := target.Generate(rs, 10, nil)
+		idx := r.Intn(len(p.Calls))
+		begin := r.Intn(len(p0.Calls))
+		end := begin + 1 + r.Intn(len(p0.Calls)-begin)
+		// Uses of resources created before the segment would dangle if the segment is inserted as is.
+		segmentUses, external := spliceResourceUses(p0, begin, end)
+		ctx := &mutator{p: p, r: r, ncalls: 100}
+		calls := ctx.spliceCalls(p0.Clone(), idx, begin, end)
+		if len(calls) < end-begin || len(calls) > end {
+			t.Fatalf("got %v calls for segment [%v, %v)", len(calls), begin, end)
+		}
+		p.Calls = append(p.Calls[:idx], append(calls, p.Calls[idx:]...)...)
+		if err := p.validate(); err != nil {
+			t.Fatalf("spliced program is broken: %v\n%s", err, p.Serialize())
+		}
+		live, _ := spliceResourceUses(&Prog{Target: target, Calls: calls}, len(calls)-(end-begin), len(calls))
+		if live != segmentUses {
+			t.Fatalf("%v of %v resource uses of the segment [%v, %v) are live after splicing:\n%s\n\ndonor:\n%s",
+				live, segmentUses, begin, end, p.Serialize(), p0.Serialize())
+		}
+		uses += segmentUses
+		dangling += external
+		pulled += len(calls) - (end - begin)
+		// Pulled in producers don't make the program longer than allowed.
+		ctx = &mutator{p: p0.Clone(), r: r, ncalls: 2}
+		if calls := ctx.spliceCalls(p0.Clone(), 0, begin, end); len(calls) > 2 {
+			t.Fatalf("got %v calls to insert with limit 2", len(calls))
+		}
+	}
+	t.Logf("%v resource uses, %v would dangle without reconciliation, %.2f calls pulled in per splice",
+		uses, dangling, float64(pulled)/float64(iters))
+}
+
+// spliceResourceUses returns number of resource uses in calls [begin, end) of p
+// and how many of them refer to resources created in p before begin.
+func spliceResourceUses(p *Prog, begin, end int) (uses, external int) {
+	created := make(map[*ResultArg]int)
+	for i, c := range p.Calls {
+		ForeachArg(c, func(arg Arg, _ *ArgCtx) {
+			if a, ok := arg.(*ResultArg); ok {
+				created[a] = i
+			}
+		})
+	}
+	for _, c := range p.Calls[begin:end] {
+		ForeachArg(c, func(arg Arg, _ *ArgCtx) {
+			if a, ok := arg.(*ResultArg); ok && a.Res != nil {
+				uses++
+				if producer, ok := created[a.Res]; ok && producer < begin {
+					external++
+				}
+			}
+		})
+	}
+	return
+}
+
 func TestMutateTable(t *testing.T) {
 	target := initTargetTest(t, "test", "64")
 	tests := [][2]string{
@@ -9,6 +9,7 @@ import (
 	"math"
 	"math/rand"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/google/syzkaller/pkg/ifuzz"
@@ -188,10 +189,7 @@ func (r *randGen) filenameImpl(s *state) string {
 		// Generate a new name.
 		dir := "."
 		if r.oneOf(2) && len(s.files) != 0 {
-			files := make([]string, 0, len(s.files))
-			for f := range s.files {
-				files = append(files, f)
-			}
+			files := sortedKeys(s.files)
 			dir = files[r.Intn(len(files))]
 			if len(dir) > 0 && dir[len(dir)-1] == 0 {
 				dir = dir[:len(dir)-1]
@@ -207,13 +205,21 @@ func (r *randGen) filenameImpl(s *state) string {
 			}
 		}
 	}
-	files := make([]string, 0, len(s.files))
-	for f := range s.files {
-		files = append(files, f)
-	}
+	files := sortedKeys(s.files)
 	return files[r.Intn(len(files))]
 }
 
+// sortedKeys returns keys of m in a stable order,
+// so that random choices among them are reproducible for the same seed.
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (r *randGen) randString(s *state, t *BufferType) []byte {
 	if len(t.Values) != 0 {
 		return []byte(t.Values[r.Intn(len(t.Values))])
@@ -221,10 +227,7 @@ func (r *randGen) randString(s *state, t *BufferType) []byte {
 	if len(s.strings) != 0 && r.bin() {
 		// Return an existing string.
 		// TODO(dvyukov): make s.strings indexed by string SubKind.
-		strings := make([]string, 0, len(s.strings))
-		for s := range s.strings {
-			strings = append(strings, s)
-		}
+		strings := sortedKeys(s.strings)
 		return []byte(strings[r.Intn(len(strings))])
 	}
 	punct := []byte{'!', '@', '#', '$', '%', '^', '&', '*', '(', ')', '-', '+', '\\',
@@ -275,6 +278,7 @@ func (r *randGen) createResource(s *state, res *ResourceType) (arg Arg, calls []
 				all = append(all, kind1)
 			}
 		}
+		sort.Strings(all)
 		kind = all[r.Intn(len(all))]
 	}
 	// Find calls that produce the necessary resources.
@@ -493,6 +497,24 @@ func (target *Target) GenerateAllSyzProg(rs rand.Source) *Prog {
 	return p
 }
 
+// GenerateCallProg generates a program that invokes meta (with random well-formed arguments)
+// as the last call, preceded by calls that create resources it needs (only calls enabled in ct are used).
+func (target *Target) GenerateCallProg(rs rand.Source, ct *ChoiceTable, meta *Syscall) *Prog {
+	p := &Prog{
+		Target: target,
+	}
+	r := newRand(target, rs)
+	s := newState(target, ct)
+	for _, c := range r.generateParticularCall(s, meta) {
+		s.analyze(c)
+		p.Calls = append(p.Calls, c)
+	}
+	if err := p.validate(); err != nil {
+		panic(err)
+	}
+	return p
+}
+
 // GenerateSimpleProg generates the simplest non-empty program for testing
 // (e.g. containing a single mmap).
 func (target *Target) GenerateSimpleProg() *Prog {
@@ -586,7 +608,13 @@ func (a *ResourceType) generate(r *randGen, s *state) (arg Arg, calls []*Call) {
 	case r.nOutOf(1000, 1011):
 		// Get an existing resource.
 		var allres []*ResultArg
-		for name1, res1 := range s.resources {
+		names := make([]string, 0, len(s.resources))
+		for name1 := range s.resources {
+			names = append(names, name1)
+		}
+		sort.Strings(names)
+		for _, name1 := range names {
+			res1 := s.resources[name1]
 			if r.target.isCompatibleResource(a.Desc.Name, name1) ||
 				r.oneOf(20) && r.target.isCompatibleResource(a.Desc.Kind[0], name1) {
 				allres = append(allres, res1...)
@@ -24,3 +24,18 @@ func TestNotEscaping(t *testing.T) {
 		}
 	}
 }
+
+func TestGenerateCallProg(t *testing.T) {
+	target, rs, _ := initTest(t)
+	enabled := make(map[*Syscall]bool)
+	for _, meta := range target.Syscalls {
+		enabled[meta] = true
+	}
+	ct := target.BuildChoiceTable(target.CalculatePriorities(nil), enabled)
+	for _, meta := range target.Syscalls {
+		p := target.GenerateCallProg(rs, ct, meta)
+		if last := p.Calls[len(p.Calls)-1].Meta; last != meta {
+			t.Fatalf("generated program for %v ends with %v", meta.Name, last.Name)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"fmt"
+)
+
+// Translate converts p, a program of another target (e.g. the same OS on another arch),
+// into a program of target. Calls that target does not have are dropped, their names are returned.
+// Constants and lengths are recalculated, because they can differ between architectures.
+func (target *Target) Translate(p *Prog) (*Prog, []string, error) {
+	p = p.Clone()
+	var dropped []string
+	for i := len(p.Calls) - 1; i >= 0; i-- {
+		if name := p.Calls[i].Meta.Name; target.SyscallMap[name] == nil {
+			dropped = append([]string{name}, dropped...)
+			p.removeCall(i)
+		}
+	}
+	if len(p.Calls) == 0 {
+		return nil, dropped, fmt.Errorf("no calls left")
+	}
+	p1, err := target.Deserialize(p.Serialize(), NonStrict)
+	if err != nil {
+		return nil, dropped, err
+	}
+	for _, c := range p1.Calls {
+		ForeachArg(c, func(arg Arg, _ *ArgCtx) {
+			if typ, ok := arg.Type().(*ConstType); ok {
+				arg.(*ConstArg).Val = typ.Val
+			}
+		})
+		target.assignSizesCall(c)
+	}
+	if err := p1.validate(); err != nil {
+		return nil, dropped, err
+	}
+	return p1, dropped, nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	from := initTargetTest(t, "linux", "amd64")
+	to, err := GetTarget("linux", "arm64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// open and arch_prctl don't exist on arm64, the ioctl command has a wrong value.
+	p, err := from.Deserialize([]byte(`r0 = open(&(0x7f0000000000)='./file0\x00', 0x0, 0x0)
+arch_prctl$ARCH_GET_FS(0x1003, &(0x7f0000000100))
+ioctl$TCGETS(r0, 0x1234, &(0x7f0000000200))
+r1 = openat(0xffffffffffffff9c, &(0x7f0000000300)='./file1\x00', 0x0, 0x0)
+close(r1)
+`), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1, dropped, err := to.Translate(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"open", "arch_prctl$ARCH_GET_FS"}; !reflect.DeepEqual(dropped, want) {
+		t.Errorf("dropped %v, want %v", dropped, want)
+	}
+	if p1.Target != to || len(p1.Calls) != 3 {
+		t.Fatalf("bad translated program:\n%s", p1.Serialize())
+	}
+	want := `ioctl$TCGETS(0xffffffffffffffff, 0x5401, &(0x7f0000000200))
+r0 = openat(0xffffffffffffff9c, &(0x7f0000000300)='./file1\x00', 0x0, 0x0)
+close(r0)
+`
+	if got := string(p1.Serialize()); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	// Programs of the original target are not modified.
+	if len(p.Calls) != 5 || !strings.HasPrefix(string(p.Serialize()), "r0 = open(") {
+		t.Errorf("the original program was modified:\n%s", p.Serialize())
+	}
+	p, err = from.Deserialize([]byte("arch_prctl$ARCH_GET_FS(0x1003, &(0x7f0000000100))\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := to.Translate(p); err == nil {
+		t.Errorf("translated a program without calls")
+	}
+}
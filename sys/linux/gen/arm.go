@@ -48,6 +48,7 @@ var resources_arm = []*ResourceDesc{
 	{Name: "fd_fuse", Type: &IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", TypeSize: 4}}}, Kind: []string{"fd", "fd_fuse"}, Values: []uint64{18446744073709551615, 18446744073709551516}},
 	{Name: "fd_i2c", Type: &IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", TypeSize: 4}}}, Kind: []string{"fd", "fd_i2c"}, Values: []uint64{18446744073709551615, 18446744073709551516}},
 	{Name: "fd_inotify", Type: &IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", TypeSize: 4}}}, Kind: []string{"fd", "fd_inotify"}, Values: []uint64{18446744073709551615, 18446744073709551516}},
+	{Name: "fd_io_uring", Type: &IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", TypeSize: 4}}}, Kind: []string{"fd", "fd_io_uring"}, Values: []uint64{18446744073709551615, 18446744073709551516}},
 	{Name: "fd_ion", Type: &IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", TypeSize: 4}}}, Kind: []string{"fd", "fd_ion"}, Values: []uint64{18446744073709551615, 18446744073709551516}},
 	{Name: "fd_kvm", Type: &IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", TypeSize: 4}}}, Kind: []string{"fd", "fd_kvm"}, Values: []uint64{18446744073709551615, 18446744073709551516}},
 	{Name: "fd_kvmcpu", Type: &IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", TypeSize: 4}}}, Kind: []string{"fd", "fd_kvmcpu"}, Values: []uint64{18446744073709551615, 18446744073709551516}},
@@ -103,6 +104,7 @@ var resources_arm = []*ResourceDesc{
 	{Name: "ifindex_vcan", Type: &IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", TypeSize: 4}}}, Kind: []string{"ifindex", "ifindex_vcan"}, Values: []uint64{0}},
 	{Name: "inotifydesc", Type: &IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", TypeSize: 4}}}, Kind: []string{"inotifydesc"}, Values: []uint64{0}},
 	{Name: "io_ctx", Type: &IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "intptr", TypeSize: 4}}}, Kind: []string{"io_ctx"}, Values: []uint64{0}},
+	{Name: "io_uring_ring", Type: &IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "intptr", TypeSize: 4}}}, Kind: []string{"io_uring_ring"}, Values: []uint64{0}},
 	{Name: "ipc", Type: &IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", TypeSize: 4}}}, Kind: []string{"ipc"}, Values: []uint64{0, 18446744073709551615}},
 	{Name: "ipc_msq", Type: &IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", TypeSize: 4}}}, Kind: []string{"ipc", "ipc_msq"}, Values: []uint64{0, 18446744073709551615}},
 	{Name: "ipc_sem", Type: &IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", TypeSize: 4}}}, Kind: []string{"ipc", "ipc_sem"}, Values: []uint64{0, 18446744073709551615}},
@@ -6327,6 +6329,306 @@ var structDescs_arm = []*KeyedStruct{
 		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "res", TypeSize: 8, ArgDir: 1}}},
 		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "res2", TypeSize: 8, ArgDir: 1}}},
 	}}},
+	{Key: StructKey{Name: "io_uring_params[io_uring_ring_setup_flags]"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_params[io_uring_ring_setup_flags]", TypeSize: 120}, Fields: []Type{
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "sq_entries", TypeSize: 4}}},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", FldName: "cq_entries", TypeSize: 4}}, Kind: 2, RangeEnd: 512},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "io_uring_ring_setup_flags", FldName: "flags", TypeSize: 4}}, Vals: []uint64{1, 2, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", FldName: "sq_thread_cpu", TypeSize: 4}}, Kind: 2, RangeEnd: 3},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", FldName: "sq_thread_idle", TypeSize: 4}}, Kind: 2, RangeEnd: 1000},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "features", TypeSize: 4}}},
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd_io_uring", FldName: "wq_fd", TypeSize: 4, IsOptional: true}},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "resv", TypeSize: 12}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 3, RangeEnd: 3},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "sq_off", TypeSize: 40}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 10, RangeEnd: 10},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "cq_off", TypeSize: 40}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 10, RangeEnd: 10},
+	}}},
+	{Key: StructKey{Name: "io_uring_params[io_uring_setup_flags]"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_params[io_uring_setup_flags]", TypeSize: 120}, Fields: []Type{
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "sq_entries", TypeSize: 4}}},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", FldName: "cq_entries", TypeSize: 4}}, Kind: 2, RangeEnd: 512},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "io_uring_setup_flags", FldName: "flags", TypeSize: 4}}, Vals: []uint64{1, 2, 4, 8, 16, 32}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", FldName: "sq_thread_cpu", TypeSize: 4}}, Kind: 2, RangeEnd: 3},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", FldName: "sq_thread_idle", TypeSize: 4}}, Kind: 2, RangeEnd: 1000},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "features", TypeSize: 4}}},
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd_io_uring", FldName: "wq_fd", TypeSize: 4, IsOptional: true}},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "resv", TypeSize: 12}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 3, RangeEnd: 3},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "sq_off", TypeSize: 40}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 10, RangeEnd: 10},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "cq_off", TypeSize: 40}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 10, RangeEnd: 10},
+	}}},
+	{Key: StructKey{Name: "io_uring_sqe"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_sqe", TypeSize: 64}, Fields: []Type{
+		&StructType{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_NOP, const[0, int32], const[0, int64], const[0, int64], const[0, int32], const[0, int32], const[0, int16]]"}, FldName: "nop"},
+		&StructType{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_READV, fd, fileoff[int64], ptr64[in, array[iovec_out]], len[addr, int32], flags[rwf_flags, int32], const[0, int16]]"}, FldName: "readv"},
+		&StructType{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_WRITEV, fd, fileoff[int64], ptr64[in, array[iovec_in]], len[addr, int32], flags[rwf_flags, int32], const[0, int16]]"}, FldName: "writev"},
+		&StructType{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_FSYNC, fd, const[0, int64], const[0, int64], const[0, int32], flags[io_uring_fsync_flags, int32], const[0, int16]]"}, FldName: "fsync"},
+		&StructType{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_READ_FIXED, fd, fileoff[int64], ptr64[out, array[int8]], len[addr, int32], flags[rwf_flags, int32], int16[0:3]]"}, FldName: "read_fixed"},
+		&StructType{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_WRITE_FIXED, fd, fileoff[int64], ptr64[in, array[int8]], len[addr, int32], flags[rwf_flags, int32], int16[0:3]]"}, FldName: "write_fixed"},
+		&StructType{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_POLL_ADD, fd, const[0, int64], const[0, int64], const[0, int32], flags[pollfd_events, int32], const[0, int16]]"}, FldName: "poll_add"},
+		&StructType{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_POLL_REMOVE, const[0, int32], const[0, int64], io_uring_user_data, const[0, int32], const[0, int32], const[0, int16]]"}, FldName: "poll_remove"},
+		&StructType{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_SENDMSG, sock, const[0, int64], ptr64[in, send_msghdr], const[1, int32], flags[send_flags, int32], const[0, int16]]"}, FldName: "sendmsg"},
+		&StructType{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_RECVMSG, sock, const[0, int64], ptr64[inout, recv_msghdr], const[1, int32], flags[recv_flags, int32], const[0, int16]]"}, FldName: "recvmsg"},
+		&StructType{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_TIMEOUT, const[0, int32], int64[0:4], ptr64[in, io_uring_timespec], const[1, int32], flags[io_uring_timeout_flags, int32], const[0, int16]]"}, FldName: "timeout"},
+		&StructType{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_ACCEPT, sock, ptr64[inout, len[addr, int32]], ptr64[out, sockaddr_storage], const[0, int32], flags[accept_flags, int32], const[0, int16]]"}, FldName: "accept"},
+		&StructType{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_ASYNC_CANCEL, const[0, int32], const[0, int64], io_uring_user_data, const[0, int32], const[0, int32], const[0, int16]]"}, FldName: "async_cancel"},
+		&StructType{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_CONNECT, sock, len[addr, int64], ptr64[in, sockaddr_storage], const[0, int32], const[0, int32], const[0, int16]]"}, FldName: "connect"},
+		&StructType{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_OPENAT, fd_dir[opt], const[0, int64], ptr64[in, filename], flags[open_mode, int32], flags[open_flags, int32], const[0, int16]]"}, FldName: "openat"},
+		&StructType{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_CLOSE, fd, const[0, int64], const[0, int64], const[0, int32], const[0, int32], const[0, int16]]"}, FldName: "close"},
+		&StructType{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_READ, fd, fileoff[int64], ptr64[out, array[int8]], len[addr, int32], flags[rwf_flags, int32], const[0, int16]]"}, FldName: "read"},
+		&StructType{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_WRITE, fd, fileoff[int64], ptr64[in, array[int8]], len[addr, int32], flags[rwf_flags, int32], const[0, int16]]"}, FldName: "write"},
+	}}},
+	{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_ACCEPT, sock, ptr64[inout, len[addr, int32]], ptr64[out, sockaddr_storage], const[0, int32], flags[accept_flags, int32], const[0, int16]]"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_sqe_t[IORING_OP_ACCEPT, sock, ptr64[inout, len[addr, int32]], ptr64[out, sockaddr_storage], const[0, int32], flags[accept_flags, int32], const[0, int16]]", TypeSize: 64}, Fields: []Type{
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 1}}, Val: 13},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "iosqe_flags", FldName: "flags", TypeSize: 1}}, Vals: []uint64{1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int16", FldName: "ioprio", TypeSize: 2}}, Kind: 2, RangeEnd: 3},
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "sock", FldName: "fd", TypeSize: 4}},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr64", FldName: "off", TypeSize: 8}, Type: &LenType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "len", TypeSize: 4, ArgDir: 2}}, Buf: "addr"}},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr64", FldName: "addr", TypeSize: 8}, Type: &UnionType{Key: StructKey{Name: "sockaddr_storage", Dir: 1}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "len", TypeSize: 4}}},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "accept_flags", FldName: "op_flags", TypeSize: 4}}, Vals: []uint64{2048, 524288}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "user_data", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "buf_index", TypeSize: 2}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "personality", TypeSize: 2}}},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "pad", TypeSize: 20}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 5, RangeEnd: 5},
+	}}},
+	{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_ASYNC_CANCEL, const[0, int32], const[0, int64], io_uring_user_data, const[0, int32], const[0, int32], const[0, int16]]"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_sqe_t[IORING_OP_ASYNC_CANCEL, const[0, int32], const[0, int64], io_uring_user_data, const[0, int32], const[0, int32], const[0, int16]]", TypeSize: 64}, Fields: []Type{
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 1}}, Val: 14},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "iosqe_flags", FldName: "flags", TypeSize: 1}}, Vals: []uint64{1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int16", FldName: "ioprio", TypeSize: 2}}, Kind: 2, RangeEnd: 3},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "fd", TypeSize: 4}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "off", TypeSize: 8}}},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "addr", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "len", TypeSize: 4}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "op_flags", TypeSize: 4}}},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "user_data", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "buf_index", TypeSize: 2}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "personality", TypeSize: 2}}},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "pad", TypeSize: 20}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 5, RangeEnd: 5},
+	}}},
+	{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_CLOSE, fd, const[0, int64], const[0, int64], const[0, int32], const[0, int32], const[0, int16]]"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_sqe_t[IORING_OP_CLOSE, fd, const[0, int64], const[0, int64], const[0, int32], const[0, int32], const[0, int16]]", TypeSize: 64}, Fields: []Type{
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 1}}, Val: 19},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "iosqe_flags", FldName: "flags", TypeSize: 1}}, Vals: []uint64{1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int16", FldName: "ioprio", TypeSize: 2}}, Kind: 2, RangeEnd: 3},
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd", FldName: "fd", TypeSize: 4}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "off", TypeSize: 8}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "addr", TypeSize: 8}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "len", TypeSize: 4}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "op_flags", TypeSize: 4}}},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "user_data", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "buf_index", TypeSize: 2}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "personality", TypeSize: 2}}},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "pad", TypeSize: 20}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 5, RangeEnd: 5},
+	}}},
+	{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_CONNECT, sock, len[addr, int64], ptr64[in, sockaddr_storage], const[0, int32], const[0, int32], const[0, int16]]"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_sqe_t[IORING_OP_CONNECT, sock, len[addr, int64], ptr64[in, sockaddr_storage], const[0, int32], const[0, int32], const[0, int16]]", TypeSize: 64}, Fields: []Type{
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 1}}, Val: 16},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "iosqe_flags", FldName: "flags", TypeSize: 1}}, Vals: []uint64{1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int16", FldName: "ioprio", TypeSize: 2}}, Kind: 2, RangeEnd: 3},
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "sock", FldName: "fd", TypeSize: 4}},
+		&LenType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "len", FldName: "off", TypeSize: 8}}, Buf: "addr"},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr64", FldName: "addr", TypeSize: 8}, Type: &UnionType{Key: StructKey{Name: "sockaddr_storage"}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "len", TypeSize: 4}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "op_flags", TypeSize: 4}}},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "user_data", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "buf_index", TypeSize: 2}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "personality", TypeSize: 2}}},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "pad", TypeSize: 20}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 5, RangeEnd: 5},
+	}}},
+	{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_FSYNC, fd, const[0, int64], const[0, int64], const[0, int32], flags[io_uring_fsync_flags, int32], const[0, int16]]"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_sqe_t[IORING_OP_FSYNC, fd, const[0, int64], const[0, int64], const[0, int32], flags[io_uring_fsync_flags, int32], const[0, int16]]", TypeSize: 64}, Fields: []Type{
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 1}}, Val: 3},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "iosqe_flags", FldName: "flags", TypeSize: 1}}, Vals: []uint64{1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int16", FldName: "ioprio", TypeSize: 2}}, Kind: 2, RangeEnd: 3},
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd", FldName: "fd", TypeSize: 4}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "off", TypeSize: 8}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "addr", TypeSize: 8}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "len", TypeSize: 4}}},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "io_uring_fsync_flags", FldName: "op_flags", TypeSize: 4}}, Vals: []uint64{0, 1}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "user_data", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "buf_index", TypeSize: 2}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "personality", TypeSize: 2}}},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "pad", TypeSize: 20}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 5, RangeEnd: 5},
+	}}},
+	{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_NOP, const[0, int32], const[0, int64], const[0, int64], const[0, int32], const[0, int32], const[0, int16]]"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_sqe_t[IORING_OP_NOP, const[0, int32], const[0, int64], const[0, int64], const[0, int32], const[0, int32], const[0, int16]]", TypeSize: 64}, Fields: []Type{
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 1}}},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "iosqe_flags", FldName: "flags", TypeSize: 1}}, Vals: []uint64{1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int16", FldName: "ioprio", TypeSize: 2}}, Kind: 2, RangeEnd: 3},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "fd", TypeSize: 4}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "off", TypeSize: 8}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "addr", TypeSize: 8}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "len", TypeSize: 4}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "op_flags", TypeSize: 4}}},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "user_data", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "buf_index", TypeSize: 2}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "personality", TypeSize: 2}}},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "pad", TypeSize: 20}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 5, RangeEnd: 5},
+	}}},
+	{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_OPENAT, fd_dir[opt], const[0, int64], ptr64[in, filename], flags[open_mode, int32], flags[open_flags, int32], const[0, int16]]"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_sqe_t[IORING_OP_OPENAT, fd_dir[opt], const[0, int64], ptr64[in, filename], flags[open_mode, int32], flags[open_flags, int32], const[0, int16]]", TypeSize: 64}, Fields: []Type{
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 1}}, Val: 18},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "iosqe_flags", FldName: "flags", TypeSize: 1}}, Vals: []uint64{1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int16", FldName: "ioprio", TypeSize: 2}}, Kind: 2, RangeEnd: 3},
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd_dir", FldName: "fd", TypeSize: 4, IsOptional: true}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "off", TypeSize: 8}}},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr64", FldName: "addr", TypeSize: 8}, Type: &BufferType{TypeCommon: TypeCommon{TypeName: "filename", IsVarlen: true}, Kind: 3}},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "open_mode", FldName: "len", TypeSize: 4}}, Vals: []uint64{256, 128, 64, 32, 16, 8, 4, 2, 1}, BitMask: true},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "open_flags", FldName: "op_flags", TypeSize: 4}}, Vals: []uint64{0, 1, 2, 1024, 8192, 524288, 64, 65536, 16384, 128, 131072, 262144, 256, 32768, 2048, 2097152, 1052672, 512, 4194304}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "user_data", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "buf_index", TypeSize: 2}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "personality", TypeSize: 2}}},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "pad", TypeSize: 20}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 5, RangeEnd: 5},
+	}}},
+	{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_POLL_ADD, fd, const[0, int64], const[0, int64], const[0, int32], flags[pollfd_events, int32], const[0, int16]]"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_sqe_t[IORING_OP_POLL_ADD, fd, const[0, int64], const[0, int64], const[0, int32], flags[pollfd_events, int32], const[0, int16]]", TypeSize: 64}, Fields: []Type{
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 1}}, Val: 6},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "iosqe_flags", FldName: "flags", TypeSize: 1}}, Vals: []uint64{1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int16", FldName: "ioprio", TypeSize: 2}}, Kind: 2, RangeEnd: 3},
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd", FldName: "fd", TypeSize: 4}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "off", TypeSize: 8}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "addr", TypeSize: 8}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "len", TypeSize: 4}}},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "pollfd_events", FldName: "op_flags", TypeSize: 4}}, Vals: []uint64{1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024, 4096, 8192, 16384, 32768}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "user_data", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "buf_index", TypeSize: 2}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "personality", TypeSize: 2}}},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "pad", TypeSize: 20}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 5, RangeEnd: 5},
+	}}},
+	{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_POLL_REMOVE, const[0, int32], const[0, int64], io_uring_user_data, const[0, int32], const[0, int32], const[0, int16]]"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_sqe_t[IORING_OP_POLL_REMOVE, const[0, int32], const[0, int64], io_uring_user_data, const[0, int32], const[0, int32], const[0, int16]]", TypeSize: 64}, Fields: []Type{
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 1}}, Val: 7},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "iosqe_flags", FldName: "flags", TypeSize: 1}}, Vals: []uint64{1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int16", FldName: "ioprio", TypeSize: 2}}, Kind: 2, RangeEnd: 3},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "fd", TypeSize: 4}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "off", TypeSize: 8}}},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "addr", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "len", TypeSize: 4}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "op_flags", TypeSize: 4}}},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "user_data", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "buf_index", TypeSize: 2}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "personality", TypeSize: 2}}},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "pad", TypeSize: 20}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 5, RangeEnd: 5},
+	}}},
+	{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_READ, fd, fileoff[int64], ptr64[out, array[int8]], len[addr, int32], flags[rwf_flags, int32], const[0, int16]]"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_sqe_t[IORING_OP_READ, fd, fileoff[int64], ptr64[out, array[int8]], len[addr, int32], flags[rwf_flags, int32], const[0, int16]]", TypeSize: 64}, Fields: []Type{
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 1}}, Val: 22},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "iosqe_flags", FldName: "flags", TypeSize: 1}}, Vals: []uint64{1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int16", FldName: "ioprio", TypeSize: 2}}, Kind: 2, RangeEnd: 3},
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd", FldName: "fd", TypeSize: 4}},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "fileoff", FldName: "off", TypeSize: 8}}, Kind: 1},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr64", FldName: "addr", TypeSize: 8}, Type: &BufferType{TypeCommon: TypeCommon{TypeName: "array", ArgDir: 1, IsVarlen: true}}},
+		&LenType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "len", FldName: "len", TypeSize: 4}}, Buf: "addr"},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "rwf_flags", FldName: "op_flags", TypeSize: 4}}, Vals: []uint64{0, 1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "user_data", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "buf_index", TypeSize: 2}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "personality", TypeSize: 2}}},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "pad", TypeSize: 20}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 5, RangeEnd: 5},
+	}}},
+	{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_READV, fd, fileoff[int64], ptr64[in, array[iovec_out]], len[addr, int32], flags[rwf_flags, int32], const[0, int16]]"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_sqe_t[IORING_OP_READV, fd, fileoff[int64], ptr64[in, array[iovec_out]], len[addr, int32], flags[rwf_flags, int32], const[0, int16]]", TypeSize: 64}, Fields: []Type{
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 1}}, Val: 1},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "iosqe_flags", FldName: "flags", TypeSize: 1}}, Vals: []uint64{1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int16", FldName: "ioprio", TypeSize: 2}}, Kind: 2, RangeEnd: 3},
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd", FldName: "fd", TypeSize: 4}},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "fileoff", FldName: "off", TypeSize: 8}}, Kind: 1},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr64", FldName: "addr", TypeSize: 8}, Type: &ArrayType{TypeCommon: TypeCommon{TypeName: "array", IsVarlen: true}, Type: &StructType{Key: StructKey{Name: "iovec[out, array[int8]]"}}}},
+		&LenType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "len", FldName: "len", TypeSize: 4}}, Buf: "addr"},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "rwf_flags", FldName: "op_flags", TypeSize: 4}}, Vals: []uint64{0, 1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "user_data", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "buf_index", TypeSize: 2}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "personality", TypeSize: 2}}},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "pad", TypeSize: 20}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 5, RangeEnd: 5},
+	}}},
+	{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_READ_FIXED, fd, fileoff[int64], ptr64[out, array[int8]], len[addr, int32], flags[rwf_flags, int32], int16[0:3]]"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_sqe_t[IORING_OP_READ_FIXED, fd, fileoff[int64], ptr64[out, array[int8]], len[addr, int32], flags[rwf_flags, int32], int16[0:3]]", TypeSize: 64}, Fields: []Type{
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 1}}, Val: 4},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "iosqe_flags", FldName: "flags", TypeSize: 1}}, Vals: []uint64{1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int16", FldName: "ioprio", TypeSize: 2}}, Kind: 2, RangeEnd: 3},
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd", FldName: "fd", TypeSize: 4}},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "fileoff", FldName: "off", TypeSize: 8}}, Kind: 1},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr64", FldName: "addr", TypeSize: 8}, Type: &BufferType{TypeCommon: TypeCommon{TypeName: "array", ArgDir: 1, IsVarlen: true}}},
+		&LenType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "len", FldName: "len", TypeSize: 4}}, Buf: "addr"},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "rwf_flags", FldName: "op_flags", TypeSize: 4}}, Vals: []uint64{0, 1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "user_data", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int16", FldName: "buf_index", TypeSize: 2}}, Kind: 2, RangeEnd: 3},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "personality", TypeSize: 2}}},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "pad", TypeSize: 20}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 5, RangeEnd: 5},
+	}}},
+	{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_RECVMSG, sock, const[0, int64], ptr64[inout, recv_msghdr], const[1, int32], flags[recv_flags, int32], const[0, int16]]"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_sqe_t[IORING_OP_RECVMSG, sock, const[0, int64], ptr64[inout, recv_msghdr], const[1, int32], flags[recv_flags, int32], const[0, int16]]", TypeSize: 64}, Fields: []Type{
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 1}}, Val: 10},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "iosqe_flags", FldName: "flags", TypeSize: 1}}, Vals: []uint64{1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int16", FldName: "ioprio", TypeSize: 2}}, Kind: 2, RangeEnd: 3},
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "sock", FldName: "fd", TypeSize: 4}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "off", TypeSize: 8}}},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr64", FldName: "addr", TypeSize: 8}, Type: &StructType{Key: StructKey{Name: "recv_msghdr", Dir: 2}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "len", TypeSize: 4}}, Val: 1},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "recv_flags", FldName: "op_flags", TypeSize: 4}}, Vals: []uint64{1073741824, 64, 8192, 1, 2, 32, 256, 65536}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "user_data", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "buf_index", TypeSize: 2}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "personality", TypeSize: 2}}},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "pad", TypeSize: 20}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 5, RangeEnd: 5},
+	}}},
+	{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_SENDMSG, sock, const[0, int64], ptr64[in, send_msghdr], const[1, int32], flags[send_flags, int32], const[0, int16]]"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_sqe_t[IORING_OP_SENDMSG, sock, const[0, int64], ptr64[in, send_msghdr], const[1, int32], flags[send_flags, int32], const[0, int16]]", TypeSize: 64}, Fields: []Type{
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 1}}, Val: 9},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "iosqe_flags", FldName: "flags", TypeSize: 1}}, Vals: []uint64{1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int16", FldName: "ioprio", TypeSize: 2}}, Kind: 2, RangeEnd: 3},
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "sock", FldName: "fd", TypeSize: 4}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "off", TypeSize: 8}}},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr64", FldName: "addr", TypeSize: 8}, Type: &StructType{Key: StructKey{Name: "send_msghdr"}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "len", TypeSize: 4}}, Val: 1},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "send_flags", FldName: "op_flags", TypeSize: 4}}, Vals: []uint64{2048, 4, 64, 128, 32768, 16384, 1, 16, 262144, 536870912, 67108864}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "user_data", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "buf_index", TypeSize: 2}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "personality", TypeSize: 2}}},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "pad", TypeSize: 20}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 5, RangeEnd: 5},
+	}}},
+	{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_TIMEOUT, const[0, int32], int64[0:4], ptr64[in, io_uring_timespec], const[1, int32], flags[io_uring_timeout_flags, int32], const[0, int16]]"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_sqe_t[IORING_OP_TIMEOUT, const[0, int32], int64[0:4], ptr64[in, io_uring_timespec], const[1, int32], flags[io_uring_timeout_flags, int32], const[0, int16]]", TypeSize: 64}, Fields: []Type{
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 1}}, Val: 11},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "iosqe_flags", FldName: "flags", TypeSize: 1}}, Vals: []uint64{1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int16", FldName: "ioprio", TypeSize: 2}}, Kind: 2, RangeEnd: 3},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "fd", TypeSize: 4}}},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "off", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr64", FldName: "addr", TypeSize: 8}, Type: &StructType{Key: StructKey{Name: "io_uring_timespec"}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "len", TypeSize: 4}}, Val: 1},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "io_uring_timeout_flags", FldName: "op_flags", TypeSize: 4}}, Vals: []uint64{0, 1}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "user_data", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "buf_index", TypeSize: 2}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "personality", TypeSize: 2}}},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "pad", TypeSize: 20}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 5, RangeEnd: 5},
+	}}},
+	{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_WRITE, fd, fileoff[int64], ptr64[in, array[int8]], len[addr, int32], flags[rwf_flags, int32], const[0, int16]]"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_sqe_t[IORING_OP_WRITE, fd, fileoff[int64], ptr64[in, array[int8]], len[addr, int32], flags[rwf_flags, int32], const[0, int16]]", TypeSize: 64}, Fields: []Type{
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 1}}, Val: 23},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "iosqe_flags", FldName: "flags", TypeSize: 1}}, Vals: []uint64{1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int16", FldName: "ioprio", TypeSize: 2}}, Kind: 2, RangeEnd: 3},
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd", FldName: "fd", TypeSize: 4}},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "fileoff", FldName: "off", TypeSize: 8}}, Kind: 1},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr64", FldName: "addr", TypeSize: 8}, Type: &BufferType{TypeCommon: TypeCommon{TypeName: "array", IsVarlen: true}}},
+		&LenType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "len", FldName: "len", TypeSize: 4}}, Buf: "addr"},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "rwf_flags", FldName: "op_flags", TypeSize: 4}}, Vals: []uint64{0, 1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "user_data", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "buf_index", TypeSize: 2}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "personality", TypeSize: 2}}},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "pad", TypeSize: 20}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 5, RangeEnd: 5},
+	}}},
+	{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_WRITEV, fd, fileoff[int64], ptr64[in, array[iovec_in]], len[addr, int32], flags[rwf_flags, int32], const[0, int16]]"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_sqe_t[IORING_OP_WRITEV, fd, fileoff[int64], ptr64[in, array[iovec_in]], len[addr, int32], flags[rwf_flags, int32], const[0, int16]]", TypeSize: 64}, Fields: []Type{
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 1}}, Val: 2},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "iosqe_flags", FldName: "flags", TypeSize: 1}}, Vals: []uint64{1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int16", FldName: "ioprio", TypeSize: 2}}, Kind: 2, RangeEnd: 3},
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd", FldName: "fd", TypeSize: 4}},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "fileoff", FldName: "off", TypeSize: 8}}, Kind: 1},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr64", FldName: "addr", TypeSize: 8}, Type: &ArrayType{TypeCommon: TypeCommon{TypeName: "array", IsVarlen: true}, Type: &StructType{Key: StructKey{Name: "iovec[in, array[int8]]"}}}},
+		&LenType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "len", FldName: "len", TypeSize: 4}}, Buf: "addr"},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "rwf_flags", FldName: "op_flags", TypeSize: 4}}, Vals: []uint64{0, 1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "user_data", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "buf_index", TypeSize: 2}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "personality", TypeSize: 2}}},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "pad", TypeSize: 20}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 5, RangeEnd: 5},
+	}}},
+	{Key: StructKey{Name: "io_uring_sqe_t[IORING_OP_WRITE_FIXED, fd, fileoff[int64], ptr64[in, array[int8]], len[addr, int32], flags[rwf_flags, int32], int16[0:3]]"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_sqe_t[IORING_OP_WRITE_FIXED, fd, fileoff[int64], ptr64[in, array[int8]], len[addr, int32], flags[rwf_flags, int32], int16[0:3]]", TypeSize: 64}, Fields: []Type{
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 1}}, Val: 5},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "iosqe_flags", FldName: "flags", TypeSize: 1}}, Vals: []uint64{1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int16", FldName: "ioprio", TypeSize: 2}}, Kind: 2, RangeEnd: 3},
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd", FldName: "fd", TypeSize: 4}},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "fileoff", FldName: "off", TypeSize: 8}}, Kind: 1},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr64", FldName: "addr", TypeSize: 8}, Type: &BufferType{TypeCommon: TypeCommon{TypeName: "array", IsVarlen: true}}},
+		&LenType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "len", FldName: "len", TypeSize: 4}}, Buf: "addr"},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "rwf_flags", FldName: "op_flags", TypeSize: 4}}, Vals: []uint64{0, 1, 2, 4, 8, 16}, BitMask: true},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "user_data", TypeSize: 8}}, Kind: 2, RangeEnd: 4},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int16", FldName: "buf_index", TypeSize: 2}}, Kind: 2, RangeEnd: 3},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "personality", TypeSize: 2}}},
+		&ArrayType{TypeCommon: TypeCommon{TypeName: "array", FldName: "pad", TypeSize: 20}, Type: &ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", TypeSize: 4}}}, Kind: 1, RangeBegin: 5, RangeEnd: 5},
+	}}},
+	{Key: StructKey{Name: "io_uring_timespec"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "io_uring_timespec", TypeSize: 16}, Fields: []Type{
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "sec", TypeSize: 8}}, Kind: 2, RangeEnd: 1},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int64", FldName: "nsec", TypeSize: 8}}, Kind: 2, RangeEnd: 10000000},
+	}}},
 	{Key: StructKey{Name: "iocb"}, Desc: &StructDesc{TypeCommon: TypeCommon{TypeName: "iocb", TypeSize: 64}, Fields: []Type{
 		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "aio_data", TypeSize: 8}}},
 		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "aio_key", TypeSize: 4}}},
@@ -26947,6 +27249,54 @@ var syscalls_arm = []*Syscall{
 		&LenType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "len", FldName: "nr", TypeSize: 4}}, Buf: "iocbpp"},
 		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr", FldName: "iocbpp", TypeSize: 4}, Type: &ArrayType{TypeCommon: TypeCommon{TypeName: "array", IsVarlen: true}, Type: &PtrType{TypeCommon: TypeCommon{TypeName: "ptr", TypeSize: 4}, Type: &StructType{Key: StructKey{Name: "iocb"}}}}},
 	}},
+	{NR: 426, Name: "io_uring_enter", CallName: "io_uring_enter", Args: []Type{
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd_io_uring", FldName: "fd", TypeSize: 4}},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", FldName: "to_submit", TypeSize: 4}}, Kind: 2, RangeEnd: 16},
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", FldName: "min_complete", TypeSize: 4}}, Kind: 2, RangeEnd: 16},
+		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "io_uring_enter_flags", FldName: "flags", TypeSize: 4}}, Vals: []uint64{1, 2}, BitMask: true},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr", FldName: "sigmask", TypeSize: 4, IsOptional: true}, Type: &StructType{Key: StructKey{Name: "sigset"}}},
+		&LenType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "len", FldName: "size", TypeSize: 4}}, Buf: "sigmask"},
+	}},
+	{NR: 427, Name: "io_uring_register$IORING_REGISTER_BUFFERS", CallName: "io_uring_register", Args: []Type{
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd_io_uring", FldName: "fd", TypeSize: 4}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 4}}},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr", FldName: "arg", TypeSize: 4}, Type: &ArrayType{TypeCommon: TypeCommon{TypeName: "array", IsVarlen: true}, Type: &StructType{Key: StructKey{Name: "iovec[out, array[int8]]"}}, Kind: 1, RangeBegin: 1, RangeEnd: 4}},
+		&LenType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "len", FldName: "nr_args", TypeSize: 4}}, Buf: "arg"},
+	}},
+	{NR: 427, Name: "io_uring_register$IORING_REGISTER_EVENTFD", CallName: "io_uring_register", Args: []Type{
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd_io_uring", FldName: "fd", TypeSize: 4}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 4}}, Val: 4},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr", FldName: "arg", TypeSize: 4}, Type: &ResourceType{TypeCommon: TypeCommon{TypeName: "fd_event", TypeSize: 4}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "nr_args", TypeSize: 4}}, Val: 1},
+	}},
+	{NR: 427, Name: "io_uring_register$IORING_REGISTER_FILES", CallName: "io_uring_register", Args: []Type{
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd_io_uring", FldName: "fd", TypeSize: 4}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 4}}, Val: 2},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr", FldName: "arg", TypeSize: 4}, Type: &ArrayType{TypeCommon: TypeCommon{TypeName: "array", IsVarlen: true}, Type: &ResourceType{TypeCommon: TypeCommon{TypeName: "fd", TypeSize: 4}}, Kind: 1, RangeBegin: 1, RangeEnd: 4}},
+		&LenType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "len", FldName: "nr_args", TypeSize: 4}}, Buf: "arg"},
+	}},
+	{NR: 427, Name: "io_uring_register$IORING_UNREGISTER_BUFFERS", CallName: "io_uring_register", Args: []Type{
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd_io_uring", FldName: "fd", TypeSize: 4}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 4}}, Val: 1},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "arg", TypeSize: 4}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "nr_args", TypeSize: 4}}},
+	}},
+	{NR: 427, Name: "io_uring_register$IORING_UNREGISTER_EVENTFD", CallName: "io_uring_register", Args: []Type{
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd_io_uring", FldName: "fd", TypeSize: 4}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 4}}, Val: 5},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "arg", TypeSize: 4}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "nr_args", TypeSize: 4}}},
+	}},
+	{NR: 427, Name: "io_uring_register$IORING_UNREGISTER_FILES", CallName: "io_uring_register", Args: []Type{
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd_io_uring", FldName: "fd", TypeSize: 4}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "opcode", TypeSize: 4}}, Val: 3},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "arg", TypeSize: 4}}},
+		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "nr_args", TypeSize: 4}}},
+	}},
+	{NR: 425, Name: "io_uring_setup", CallName: "io_uring_setup", Args: []Type{
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", FldName: "entries", TypeSize: 4}}, Kind: 2, RangeBegin: 1, RangeEnd: 256},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr", FldName: "params", TypeSize: 4}, Type: &StructType{Key: StructKey{Name: "io_uring_params[io_uring_setup_flags]"}}},
+	}, Ret: &ResourceType{TypeCommon: TypeCommon{TypeName: "fd_io_uring", FldName: "ret", TypeSize: 4, ArgDir: 1}}},
 	{NR: 54, Name: "ioctl", CallName: "ioctl", Args: []Type{
 		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd", FldName: "fd", TypeSize: 4}},
 		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "intptr", FldName: "cmd", TypeSize: 4}}},
@@ -36163,6 +36513,18 @@ var syscalls_arm = []*Syscall{
 		&FlagsType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "nfc_raw_type", FldName: "type", TypeSize: 4}}, Vals: []uint64{1, 3}},
 		&ConstType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "const", FldName: "proto", TypeSize: 4}}},
 	}, Ret: &ResourceType{TypeCommon: TypeCommon{TypeName: "sock_nfc_raw", FldName: "ret", TypeSize: 4, ArgDir: 1}}},
+	{Name: "syz_io_uring_complete", CallName: "syz_io_uring_complete", Args: []Type{
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "io_uring_ring", FldName: "ring", TypeSize: 4}},
+	}},
+	{Name: "syz_io_uring_setup", CallName: "syz_io_uring_setup", Args: []Type{
+		&IntType{IntTypeCommon: IntTypeCommon{TypeCommon: TypeCommon{TypeName: "int32", FldName: "entries", TypeSize: 4}}, Kind: 2, RangeBegin: 1, RangeEnd: 256},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr", FldName: "params", TypeSize: 4}, Type: &StructType{Key: StructKey{Name: "io_uring_params[io_uring_ring_setup_flags]"}}},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr", FldName: "ring_ptr", TypeSize: 4}, Type: &ResourceType{TypeCommon: TypeCommon{TypeName: "io_uring_ring", TypeSize: 4, ArgDir: 1}}},
+	}, Ret: &ResourceType{TypeCommon: TypeCommon{TypeName: "fd_io_uring", FldName: "ret", TypeSize: 4, ArgDir: 1}}},
+	{Name: "syz_io_uring_submit", CallName: "syz_io_uring_submit", Args: []Type{
+		&ResourceType{TypeCommon: TypeCommon{TypeName: "io_uring_ring", FldName: "ring", TypeSize: 4}},
+		&PtrType{TypeCommon: TypeCommon{TypeName: "ptr", FldName: "sqe", TypeSize: 4}, Type: &UnionType{Key: StructKey{Name: "io_uring_sqe"}}},
+	}},
 	{Name: "syz_kvm_setup_cpu$arm64", CallName: "syz_kvm_setup_cpu", Args: []Type{
 		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd_kvmvm", FldName: "fd", TypeSize: 4}},
 		&ResourceType{TypeCommon: TypeCommon{TypeName: "fd_kvmcpu", FldName: "cpufd", TypeSize: 4}},
@@ -39107,6 +39469,45 @@ var consts_arm = []ConstValue{
 	{Name: "IOPRIO_WHO_PGRP", Value: 2},
 	{Name: "IOPRIO_WHO_PROCESS", Value: 1},
 	{Name: "IOPRIO_WHO_USER", Value: 3},
+	{Name: "IORING_ENTER_GETEVENTS", Value: 1},
+	{Name: "IORING_ENTER_SQ_WAKEUP", Value: 2},
+	{Name: "IORING_FSYNC_DATASYNC", Value: 1},
+	{Name: "IORING_OP_ACCEPT", Value: 13},
+	{Name: "IORING_OP_ASYNC_CANCEL", Value: 14},
+	{Name: "IORING_OP_CLOSE", Value: 19},
+	{Name: "IORING_OP_CONNECT", Value: 16},
+	{Name: "IORING_OP_FSYNC", Value: 3},
+	{Name: "IORING_OP_NOP"},
+	{Name: "IORING_OP_OPENAT", Value: 18},
+	{Name: "IORING_OP_POLL_ADD", Value: 6},
+	{Name: "IORING_OP_POLL_REMOVE", Value: 7},
+	{Name: "IORING_OP_READ", Value: 22},
+	{Name: "IORING_OP_READV", Value: 1},
+	{Name: "IORING_OP_READ_FIXED", Value: 4},
+	{Name: "IORING_OP_RECVMSG", Value: 10},
+	{Name: "IORING_OP_SENDMSG", Value: 9},
+	{Name: "IORING_OP_TIMEOUT", Value: 11},
+	{Name: "IORING_OP_WRITE", Value: 23},
+	{Name: "IORING_OP_WRITEV", Value: 2},
+	{Name: "IORING_OP_WRITE_FIXED", Value: 5},
+	{Name: "IORING_REGISTER_BUFFERS"},
+	{Name: "IORING_REGISTER_EVENTFD", Value: 4},
+	{Name: "IORING_REGISTER_FILES", Value: 2},
+	{Name: "IORING_SETUP_ATTACH_WQ", Value: 32},
+	{Name: "IORING_SETUP_CLAMP", Value: 16},
+	{Name: "IORING_SETUP_CQSIZE", Value: 8},
+	{Name: "IORING_SETUP_IOPOLL", Value: 1},
+	{Name: "IORING_SETUP_SQPOLL", Value: 2},
+	{Name: "IORING_SETUP_SQ_AFF", Value: 4},
+	{Name: "IORING_TIMEOUT_ABS", Value: 1},
+	{Name: "IORING_UNREGISTER_BUFFERS", Value: 1},
+	{Name: "IORING_UNREGISTER_EVENTFD", Value: 5},
+	{Name: "IORING_UNREGISTER_FILES", Value: 3},
+	{Name: "IOSQE_ASYNC", Value: 16},
+	{Name: "IOSQE_FIXED_FILE", Value: 1},
+	{Name: "IOSQE_IO_DRAIN", Value: 2},
+	{Name: "IOSQE_IO_HARDLINK", Value: 8},
+	{Name: "IOSQE_IO_LINK", Value: 4},
 	{Name: "IP6T_AH_INV_LEN", Value: 2},
 	{Name: "IP6T_AH_INV_SPI", Value: 1},
 	{Name: "IP6T_FRAG_FST", Value: 8},
@@ -41036,6 +41437,11 @@ var consts_arm = []ConstValue{
 	{Name: "RUSAGE_CHILDREN", Value: 18446744073709551615},
 	{Name: "RUSAGE_SELF"},
 	{Name: "RUSAGE_THREAD", Value: 1},
+	{Name: "RWF_APPEND", Value: 16},
+	{Name: "RWF_DSYNC", Value: 2},
+	{Name: "RWF_HIPRI", Value: 1},
+	{Name: "RWF_NOWAIT", Value: 8},
+	{Name: "RWF_SYNC", Value: 4},
 	{Name: "RWF_WRITE_LIFE_NOT_SET"},
 	{Name: "RWH_WRITE_LIFE_EXTREME", Value: 5},
 	{Name: "RWH_WRITE_LIFE_LONG", Value: 4},
@@ -43925,6 +44331,9 @@ var consts_arm = []ConstValue{
 	{Name: "__NR_io_pgetevents", Value: 399},
 	{Name: "__NR_io_setup", Value: 243},
 	{Name: "__NR_io_submit", Value: 246},
+	{Name: "__NR_io_uring_enter", Value: 426},
+	{Name: "__NR_io_uring_register", Value: 427},
+	{Name: "__NR_io_uring_setup", Value: 425},
 	{Name: "__NR_ioctl", Value: 54},
 	{Name: "__NR_ioprio_get", Value: 315},
 	{Name: "__NR_ioprio_set", Value: 314},
@@ -44124,4 +44533,4 @@ var consts_arm = []ConstValue{
 	{Name: "bpf_insn_load_imm_dw", Value: 24},
 }
 
-const revision_arm = "34b155a4d1654ac354700f5d731b25f9b7fdcc76"
+const revision_arm = "c5ffafea16905c836fb86d55e9383fefc25e573e"
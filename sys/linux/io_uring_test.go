@@ -0,0 +1,76 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package linux_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/prog"
+	_ "github.com/google/syzkaller/sys/linux/gen"
+)
+
+// TestIOUringGeneration checks that generated io_uring programs submit to rings created by
+// syz_io_uring_setup rather than to garbage handles, and use all described operations.
+func TestIOUringGeneration(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enabled := make(map[*prog.Syscall]bool)
+	for _, name := range []string{"syz_io_uring_setup", "syz_io_uring_submit",
+		"syz_io_uring_complete", "io_uring_enter"} {
+		c := target.SyscallMap[name]
+		if c == nil {
+			t.Fatalf("no %v syscall", name)
+		}
+		enabled[c] = true
+	}
+	ct := target.BuildChoiceTable(nil, enabled)
+	iters := 1000
+	if testing.Short() {
+		iters = 100
+	}
+	seed := time.Now().UnixNano()
+	t.Logf("seed=%v", seed)
+	rs := rand.NewSource(seed)
+	ops := make(map[string]bool)
+	total, valid := 0, 0
+	for i := 0; i < iters; i++ {
+		p := target.Generate(rs, 10, ct)
+		for _, c := range p.Calls {
+			if c.Meta.CallName != "syz_io_uring_submit" && c.Meta.CallName != "syz_io_uring_complete" {
+				continue
+			}
+			total++
+			if ring := c.Args[0].(*prog.ResultArg); ring.Res != nil {
+				valid++
+			}
+			if c.Meta.CallName != "syz_io_uring_submit" {
+				continue
+			}
+			sqe := c.Args[1].(*prog.PointerArg)
+			if sqe.Res == nil {
+				continue
+			}
+			ops[sqe.Res.(*prog.UnionArg).Option.Type().FieldName()] = true
+		}
+	}
+	if total == 0 {
+		t.Fatalf("no syz_io_uring_submit/complete calls generated")
+	}
+	// Mutation can still replace rings with special values, but generation should not.
+	if valid*10 < total*9 {
+		t.Errorf("only %v/%v submit/complete calls refer to a ring", valid, total)
+	}
+	union := target.SyscallMap["syz_io_uring_submit"].Args[1].(*prog.PtrType).Type.(*prog.UnionType)
+	if !testing.Short() {
+		for _, f := range union.Fields {
+			if !ops[f.FieldName()] {
+				t.Errorf("operation %v is never generated", f.FieldName())
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+
+	"github.com/google/syzkaller/pkg/ipc"
+	"github.com/google/syzkaller/pkg/rpctype"
+	"github.com/google/syzkaller/prog"
+)
+
+// errnoStats collects per-syscall errno histograms of executed programs for the manager,
+// which uses them to detect syscalls that start failing after description changes.
+// Only every errnoSampleRate-th execution of a proc is accounted to bound the overhead.
+type errnoStats struct {
+	mu    sync.Mutex
+	calls map[string]*rpctype.CallErrnos
+}
+
+const errnoSampleRate = 16
+
+// sample accounts errnos of calls of an executed program.
+// Executions with fault injection or collisions are not representative and are skipped.
+func (es *errnoStats) sample(opts *ipc.ExecOpts, p *prog.Prog, info *ipc.ProgInfo) {
+	if opts.Flags&(ipc.FlagInjectFault|ipc.FlagCollide) != 0 || len(info.Calls) != len(p.Calls) {
+		return
+	}
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.calls == nil {
+		es.calls = make(map[string]*rpctype.CallErrnos)
+	}
+	var data []byte
+	for i, inf := range info.Calls {
+		if inf.Flags&ipc.CallFinished == 0 {
+			continue
+		}
+		name := p.Calls[i].Meta.Name
+		call := es.calls[name]
+		if call == nil {
+			call = &rpctype.CallErrnos{Errnos: make(map[int]uint64)}
+			es.calls[name] = call
+		}
+		call.Errnos[inf.Errno]++
+		if inf.Errno != 0 && call.Example == nil {
+			if data == nil {
+				data = p.Serialize()
+			}
+			call.Example = data
+			call.ExampleErrno = inf.Errno
+		}
+	}
+}
+
+// grab returns histograms collected since the last grab.
+func (es *errnoStats) grab() map[string]*rpctype.CallErrnos {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	calls := es.calls
+	es.calls = nil
+	return calls
+}
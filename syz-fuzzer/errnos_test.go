@@ -0,0 +1,46 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/syzkaller/pkg/ipc"
+	"github.com/google/syzkaller/prog"
+)
+
+func TestErrnoStats(t *testing.T) {
+	target, err := prog.GetTarget("test", "64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("test()\ntest()\ntest$int(0x0, 0x0, 0x0, 0x0, 0x0)\n"), prog.Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := ipc.CallExecuted | ipc.CallFinished
+	info := &ipc.ProgInfo{Calls: []ipc.CallInfo{
+		{Flags: done},
+		{Flags: done, Errno: 22},
+		{Flags: ipc.CallExecuted, Errno: 999},
+	}}
+	var es errnoStats
+	es.sample(&ipc.ExecOpts{}, p, info)
+	es.sample(&ipc.ExecOpts{Flags: ipc.FlagInjectFault}, p, info)
+	es.sample(&ipc.ExecOpts{Flags: ipc.FlagCollide}, p, info)
+	calls := es.grab()
+	if len(calls) != 1 || calls["test"] == nil {
+		t.Fatalf("got calls %+v", calls)
+	}
+	call := calls["test"]
+	if call.Errnos[0] != 1 || call.Errnos[22] != 1 || len(call.Errnos) != 2 {
+		t.Fatalf("got errnos %v", call.Errnos)
+	}
+	if call.ExampleErrno != 22 || string(call.Example) != string(p.Serialize()) {
+		t.Fatalf("got example %q with errno %v", call.Example, call.ExampleErrno)
+	}
+	if calls := es.grab(); calls != nil {
+		t.Fatalf("got calls %+v after grab", calls)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math/rand"
+
+	"github.com/google/syzkaller/pkg/ipc"
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/rpctype"
+	"github.com/google/syzkaller/prog"
+)
+
+// focus biases fuzzing toward a set of syscalls while the manager sends it (see rpctype.RPCFocus):
+// priorities of the focused calls are multiplied by the focus weight in the choice table,
+// and faults are injected into the focused calls of fuzzed programs.
+type focus struct {
+	id          int
+	calls       map[int]bool // IDs of the focused syscalls
+	fault       bool
+	choiceTable *prog.ChoiceTable
+}
+
+// Focused programs are executed with a fault injected into the nth fault site
+// of a random focused call, nth is chosen from [0, focusFaultNth).
+const focusFaultNth = 20
+
+// applyFocus sets the focus received from the manager (nil resets it).
+func (fuzzer *Fuzzer) applyFocus(rf *rpctype.RPCFocus) {
+	cur := fuzzer.currentFocus()
+	if rf == nil {
+		if cur != nil {
+			log.Logf(0, "focus %v finished", cur.id)
+			fuzzer.setFocus(nil)
+		}
+		return
+	}
+	if cur != nil && cur.id == rf.ID || fuzzer.prios == nil {
+		// The base choice table is not yet built during the initial polls,
+		// the focus is applied on one of the next polls.
+		return
+	}
+	fuzzer.setFocus(newFocus(fuzzer.target, fuzzer.prios, fuzzer.calls, rf))
+}
+
+func newFocus(target *prog.Target, prios [][]float32, enabled map[*prog.Syscall]bool,
+	rf *rpctype.RPCFocus) *focus {
+	f := &focus{
+		id:    rf.ID,
+		calls: make(map[int]bool),
+		fault: rf.Fault,
+	}
+	for _, name := range rf.Calls {
+		if c := target.SyscallMap[name]; c != nil && enabled[c] {
+			f.calls[c.ID] = true
+		}
+	}
+	focused := make([][]float32, len(prios))
+	for i, row := range prios {
+		focused[i] = append([]float32{}, row...)
+		for id := range f.calls {
+			focused[i][id] *= float32(rf.Weight)
+		}
+	}
+	f.choiceTable = target.BuildChoiceTable(focused, enabled)
+	log.Logf(0, "focus %v: %v of %v calls enabled, weight %v, fault injection %v",
+		f.id, len(f.calls), len(rf.Calls), rf.Weight, rf.Fault)
+	return f
+}
+
+func (fuzzer *Fuzzer) currentFocus() *focus {
+	fuzzer.focusMu.RLock()
+	defer fuzzer.focusMu.RUnlock()
+	return fuzzer.focus
+}
+
+func (fuzzer *Fuzzer) setFocus(f *focus) {
+	fuzzer.focusMu.Lock()
+	defer fuzzer.focusMu.Unlock()
+	fuzzer.focus = f
+}
+
+// getChoiceTable returns the choice table of the active focus, or the base one.
+func (fuzzer *Fuzzer) getChoiceTable() *prog.ChoiceTable {
+	if f := fuzzer.currentFocus(); f != nil {
+		return f.choiceTable
+	}
+	return fuzzer.choiceTable
+}
+
+// focusFaultCall returns index of a random focused call of p to inject a fault into,
+// or -1 if faults should not be injected into p.
+func (f *focus) focusFaultCall(rnd *rand.Rand, p *prog.Prog) int {
+	if f == nil || !f.fault {
+		return -1
+	}
+	var calls []int
+	for i, c := range p.Calls {
+		if f.calls[c.Meta.ID] {
+			calls = append(calls, i)
+		}
+	}
+	if len(calls) == 0 {
+		return -1
+	}
+	return calls[rnd.Intn(len(calls))]
+}
+
+// executeFocusFault executes p once more with a fault injected into a focused call (if any).
+func (proc *Proc) executeFocusFault(p *prog.Prog) {
+	if !proc.fuzzer.faultInjectionEnabled {
+		return
+	}
+	call := proc.fuzzer.currentFocus().focusFaultCall(proc.rnd, p)
+	if call == -1 {
+		return
+	}
+	nth := proc.rnd.Intn(focusFaultNth)
+	log.Logf(1, "#%v: injecting focus fault into call %v/%v", proc.pid, call, nth)
+	opts := *proc.execOpts
+	opts.Flags |= ipc.FlagInjectFault
+	opts.FaultCall = call
+	opts.FaultNth = nth
+	proc.executeRaw(&opts, p, StatFocus)
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/rpctype"
+	"github.com/google/syzkaller/prog"
+)
+
+func TestFocus(t *testing.T) {
+	target, err := prog.GetTarget("test", "64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prios := target.CalculatePriorities(nil)
+	enabled := make(map[*prog.Syscall]bool)
+	for _, c := range target.Syscalls {
+		enabled[c] = true
+	}
+	focused := target.Syscalls[len(target.Syscalls)/2]
+	f := newFocus(target, prios, enabled, &rpctype.RPCFocus{
+		ID:     1,
+		Calls:  []string{focused.Name, "no-such-call"},
+		Weight: 100,
+		Fault:  true,
+	})
+	if len(f.calls) != 1 || !f.calls[focused.ID] {
+		t.Fatalf("bad focused calls: %v", f.calls)
+	}
+	// The focused call is chosen much more frequently with the focused choice table.
+	base := target.BuildChoiceTable(prios, enabled)
+	count := func(ct *prog.ChoiceTable) int {
+		rnd := rand.New(rand.NewSource(0))
+		n := 0
+		for i := 0; i < 10000; i++ {
+			if ct.Choose(rnd, target.Syscalls[0].ID) == focused.ID {
+				n++
+			}
+		}
+		return n
+	}
+	if baseCount, focusCount := count(base), count(f.choiceTable); focusCount <= 10*baseCount {
+		t.Fatalf("focused call is chosen %v times, without focus %v times", focusCount, baseCount)
+	}
+	rnd := rand.New(rand.NewSource(0))
+	p := target.Generate(rnd, 5, target.BuildChoiceTable(nil, map[*prog.Syscall]bool{focused: true}))
+	if call := f.focusFaultCall(rnd, p); call == -1 || p.Calls[call].Meta != focused {
+		t.Fatalf("bad fault call %v in:\n%s", call, p.Serialize())
+	}
+	if simple := target.GenerateSimpleProg(); simple.Calls[0].Meta != focused {
+		if call := f.focusFaultCall(rnd, simple); call != -1 {
+			t.Fatalf("got fault call %v in a program without focused calls", call)
+		}
+	}
+	var nilFocus *focus
+	if call := nilFocus.focusFaultCall(rnd, p); call != -1 {
+		t.Fatalf("got fault call %v without focus", call)
+	}
+}
@@ -5,6 +5,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
@@ -32,17 +33,28 @@ type Fuzzer struct {
 	config      *ipc.Config
 	execOpts    *ipc.ExecOpts
 	procs       []*Proc
+	procCount   int // number of procs, reported to the manager
 	gate        *ipc.Gate
 	workQueue   *WorkQueue
 	needPoll    chan struct{}
 	choiceTable *prog.ChoiceTable
+	prios       [][]float32 // base call-to-call priorities (used to build focused choice tables)
+	calls       map[*prog.Syscall]bool
 	stats       [StatCount]uint64
+	execSignal  uint64 // total signal of all executions (used by manager to detect broken coverage)
+	errnos      errnoStats
 	manager     *rpctype.RPCClient
 	target      *prog.Target
+	progHooks   *progHooks
+	profiler    *profiler
+	seed        int64 // PRNG seed (0 for random)
 
 	faultInjectionEnabled    bool
 	comparisonTracingEnabled bool
 
+	focusMu sync.RWMutex
+	focus   *focus // the active focus (nil if there is none)
+
 	corpusMu     sync.RWMutex
 	corpus       []*prog.Prog
 	corpusHashes map[hash.Sig]struct{}
@@ -66,6 +78,8 @@ const (
 	StatSmash
 	StatHint
 	StatSeed
+	StatReplay
+	StatFocus
 	StatCount
 )
 
@@ -78,6 +92,8 @@ var statNames = [StatCount]string{
 	StatSmash:     "exec smash",
 	StatHint:      "exec hints",
 	StatSeed:      "exec seeds",
+	StatReplay:    "exec replay",
+	StatFocus:     "exec focus",
 }
 
 type OutputType int
@@ -102,6 +118,7 @@ func main() {
 		flagPprof   = flag.String("pprof", "", "address to serve pprof profiles")
 		flagTest    = flag.Bool("test", false, "enable image testing mode")      // used by syz-ci
 		flagRunTest = flag.Bool("runtest", false, "enable program testing mode") // used by pkg/runtest
+		flagSeed    = flag.Int64("seed", 0, "PRNG seed (0 for random, overrides seed from manager)")
 	)
 	flag.Parse()
 	outputType := parseOutputType(*flagOutput)
@@ -201,7 +218,16 @@ func main() {
 		runTest(target, manager, *flagName, config.Executor)
 		return
 	}
+	profiler := newProfiler(r, func(a *rpctype.NewProfileArgs) {
+		a.Name = *flagName
+		if err := manager.Call("Manager.NewProfile", a, nil); err != nil {
+			log.Fatalf("Manager.NewProfile call failed: %v", err)
+		}
+	})
+	config.Flags |= profiler.envFlags()
 
+	// Executor buffers are allocated per proc, so they are scaled along with procs.
+	procs := adaptProcs(*flagProcs, r)
 	needPoll := make(chan struct{}, 1)
 	needPoll <- struct{}{}
 	fuzzer := &Fuzzer{
@@ -209,16 +235,33 @@ func main() {
 		outputType:               outputType,
 		config:                   config,
 		execOpts:                 execOpts,
-		gate:                     ipc.NewGate(2**flagProcs, gateCallback),
-		workQueue:                newWorkQueue(*flagProcs, needPoll),
+		procCount:                procs,
+		gate:                     ipc.NewGate(2*procs, gateCallback),
+		workQueue:                newWorkQueue(procs, needPoll),
 		needPoll:                 needPoll,
 		manager:                  manager,
 		target:                   target,
 		faultInjectionEnabled:    r.CheckResult.Features[host.FeatureFaultInjection].Enabled,
 		comparisonTracingEnabled: r.CheckResult.Features[host.FeatureComparisons].Enabled,
 		corpusHashes:             make(map[hash.Sig]struct{}),
+		profiler:                 profiler,
+		seed:                     r.Seed,
 	}
-	for i := 0; fuzzer.poll(i == 0, nil); i++ {
+	if *flagSeed != 0 {
+		fuzzer.seed = *flagSeed
+	}
+	if fuzzer.seed != 0 {
+		log.Logf(0, "seed: %v", fuzzer.seed)
+	}
+	if r.ProgPrologue != "" || r.ProgEpilogue != "" {
+		fuzzer.progHooks = &progHooks{
+			prologue: r.ProgPrologue,
+			epilogue: r.ProgEpilogue,
+			timeout:  r.ProgHookTimeout,
+			restart:  r.ProgHookRestart,
+		}
+	}
+	for i := 0; fuzzer.poll(i == 0, nil, nil); i++ {
 	}
 	calls := make(map[*prog.Syscall]bool)
 	for _, id := range r.CheckResult.EnabledCalls[sandbox] {
@@ -226,8 +269,10 @@ func main() {
 	}
 	prios := target.CalculatePriorities(fuzzer.corpus)
 	fuzzer.choiceTable = target.BuildChoiceTable(prios, calls)
+	fuzzer.calls = calls
+	fuzzer.prios = prios
 
-	for pid := 0; pid < *flagProcs; pid++ {
+	for pid := 0; pid < procs; pid++ {
 		proc, err := newProc(fuzzer, pid)
 		if err != nil {
 			log.Fatalf("failed to create proc: %v", err)
@@ -262,28 +307,34 @@ func (fuzzer *Fuzzer) pollLoop() {
 				continue
 			}
 			stats := make(map[string]uint64)
-			for _, proc := range fuzzer.procs {
-				stats["exec total"] += atomic.SwapUint64(&proc.env.StatExecs, 0)
+			procExecs := make([]uint64, len(fuzzer.procs))
+			for i, proc := range fuzzer.procs {
+				procExecs[i] = atomic.SwapUint64(&proc.env.StatExecs, 0)
+				stats["exec total"] += procExecs[i]
 				stats["executor restarts"] += atomic.SwapUint64(&proc.env.StatRestarts, 0)
 			}
+			stats["exec signal"] = atomic.SwapUint64(&fuzzer.execSignal, 0)
 			for stat := Stat(0); stat < StatCount; stat++ {
 				v := atomic.SwapUint64(&fuzzer.stats[stat], 0)
 				stats[statNames[stat]] = v
 				execTotal += v
 			}
-			if !fuzzer.poll(needCandidates, stats) {
+			if !fuzzer.poll(needCandidates, stats, procExecs) {
 				lastPoll = time.Now()
 			}
 		}
 	}
 }
 
-func (fuzzer *Fuzzer) poll(needCandidates bool, stats map[string]uint64) bool {
+func (fuzzer *Fuzzer) poll(needCandidates bool, stats map[string]uint64, procExecs []uint64) bool {
 	a := &rpctype.PollArgs{
 		Name:           fuzzer.name,
 		NeedCandidates: needCandidates,
 		MaxSignal:      fuzzer.grabNewSignal().Serialize(),
 		Stats:          stats,
+		ProcExecs:      procExecs,
+		Procs:          fuzzer.procCount,
+		CallErrnos:     fuzzer.errnos.grab(),
 	}
 	r := &rpctype.PollRes{}
 	if err := fuzzer.manager.Call("Manager.Poll", a, r); err != nil {
@@ -309,11 +360,31 @@ func (fuzzer *Fuzzer) poll(needCandidates bool, stats map[string]uint64) bool {
 			flags |= ProgSmashed
 		}
 		fuzzer.workQueue.enqueue(&WorkCandidate{
-			p:     p,
-			flags: flags,
+			p:      p,
+			flags:  flags,
+			origin: candidate.Origin,
 		})
 	}
-	return len(r.NewInputs) != 0 || len(r.Candidates) != 0 || maxSignal.Len() != 0
+	for _, replay := range r.Replays {
+		p, err := fuzzer.target.Deserialize(replay.Prog, prog.NonStrict)
+		if err != nil {
+			fuzzer.sendReplayToManager(&rpctype.ReplayDoneArgs{
+				ID:    replay.ID,
+				Error: fmt.Sprintf("failed to parse program: %v", err),
+			})
+			continue
+		}
+		fuzzer.workQueue.enqueue(&WorkReplay{p: p, id: replay.ID})
+	}
+	fuzzer.applyFocus(r.Focus)
+	return len(r.NewInputs) != 0 || len(r.Candidates) != 0 || len(r.Replays) != 0 || maxSignal.Len() != 0
+}
+
+func (fuzzer *Fuzzer) sendReplayToManager(a *rpctype.ReplayDoneArgs) {
+	a.Name = fuzzer.name
+	if err := fuzzer.manager.Call("Manager.ReplayDone", a, nil); err != nil {
+		log.Fatalf("Manager.ReplayDone call failed: %v", err)
+	}
 }
 
 func (fuzzer *Fuzzer) sendInputToManager(inp rpctype.RPCInput) {
@@ -0,0 +1,35 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/osutil"
+)
+
+// progHooks are shell commands executed before/after each test program
+// (prog_prologue/prog_epilogue in manager config).
+type progHooks struct {
+	prologue string
+	epilogue string
+	timeout  time.Duration
+	restart  bool // restart VM if a command fails
+}
+
+func (hooks *progHooks) run(pid int, what, command string) {
+	if command == "" {
+		return
+	}
+	if _, err := osutil.RunCmd(hooks.timeout, "", "/bin/sh", "-c", command); err != nil {
+		log.Logf(0, "proc %v: program %v failed: %v", pid, what, err)
+		if hooks.restart {
+			// This is recognized by manager as a request to restart the VM (not a crash).
+			log.Logf(0, "SYZ-FUZZER: RESTART REQUESTED")
+			os.Exit(1)
+		}
+	}
+}
@@ -36,6 +36,7 @@ type Proc struct {
 	execOptsCover     *ipc.ExecOpts
 	execOptsComps     *ipc.ExecOpts
 	execOptsNoCollide *ipc.ExecOpts
+	execs             int // executions of the proc (for errno sampling)
 }
 
 func newProc(fuzzer *Fuzzer, pid int) (*Proc, error) {
@@ -43,7 +44,11 @@ func newProc(fuzzer *Fuzzer, pid int) (*Proc, error) {
 	if err != nil {
 		return nil, err
 	}
-	rnd := rand.New(rand.NewSource(time.Now().UnixNano() + int64(pid)*1e12))
+	seed := procSeed(fuzzer.seed, pid)
+	if fuzzer.seed != 0 {
+		log.Logf(0, "proc %v: seed %v", pid, seed)
+	}
+	rnd := rand.New(rand.NewSource(seed))
 	execOptsNoCollide := *fuzzer.execOpts
 	execOptsNoCollide.Flags &= ^ipc.FlagCollide
 	execOptsCover := execOptsNoCollide
@@ -63,6 +68,14 @@ func newProc(fuzzer *Fuzzer, pid int) (*Proc, error) {
 	return proc, nil
 }
 
+// procSeed returns PRNG seed for proc pid derived from the fuzzer seed (if any).
+func procSeed(fuzzerSeed int64, pid int) int64 {
+	if fuzzerSeed == 0 {
+		return time.Now().UnixNano() + int64(pid)*1e12
+	}
+	return hash.Seed(fuzzerSeed, fmt.Sprintf("proc-%v", pid))
+}
+
 func (proc *Proc) loop() {
 	generatePeriod := 100
 	if proc.fuzzer.config.Flags&ipc.FlagSignal == 0 {
@@ -77,32 +90,53 @@ func (proc *Proc) loop() {
 			case *WorkTriage:
 				proc.triageInput(item)
 			case *WorkCandidate:
-				proc.execute(proc.execOpts, item.p, item.flags, StatCandidate)
+				src := progSource{origin: item.origin}
+				if item.origin == rpctype.OriginCorpus {
+					// If the program changes during triage (e.g. it's re-minimized),
+					// the new program inherits provenance of the old one.
+					src.parent = item.p
+				}
+				proc.execute(proc.execOpts, item.p, item.flags, StatCandidate, src)
 			case *WorkSmash:
 				proc.smashInput(item)
+			case *WorkReplay:
+				proc.replayInput(item)
 			default:
 				log.Fatalf("unknown work type: %#v", item)
 			}
 			continue
 		}
 
-		ct := proc.fuzzer.choiceTable
+		ct := proc.fuzzer.getChoiceTable()
 		corpus := proc.fuzzer.corpusSnapshot()
-		if len(corpus) == 0 || i%generatePeriod == 0 {
-			// Generate a new prog.
-			p := proc.fuzzer.target.Generate(proc.rnd, programLength, ct)
+		p, parent, stat := fuzzProg(proc.fuzzer.target, proc.rnd, ct, corpus, i%generatePeriod == 0)
+		src := progSource{origin: rpctype.OriginMutate, parent: parent}
+		if stat == StatGenerate {
 			log.Logf(1, "#%v: generated", proc.pid)
-			proc.execute(proc.execOpts, p, ProgNormal, StatGenerate)
+			src.origin = rpctype.OriginGenerate
 		} else {
-			// Mutate an existing prog.
-			p := corpus[proc.rnd.Intn(len(corpus))].Clone()
-			p.Mutate(proc.rnd, programLength, ct, corpus)
 			log.Logf(1, "#%v: mutated", proc.pid)
-			proc.execute(proc.execOpts, p, ProgNormal, StatFuzz)
 		}
+		proc.execute(proc.execOpts, p, ProgNormal, stat, src)
+		proc.executeFocusFault(p)
 	}
 }
 
+// fuzzProg generates a new program or mutates an existing one from corpus
+// (returned as parent, nil for generated programs).
+// All random decisions come from rnd, so the result is reproducible
+// given the same rnd seed and corpus.
+func fuzzProg(target *prog.Target, rnd *rand.Rand, ct *prog.ChoiceTable, corpus []*prog.Prog,
+	generate bool) (p, parent *prog.Prog, stat Stat) {
+	if len(corpus) == 0 || generate {
+		return target.Generate(rnd, programLength, ct), nil, StatGenerate
+	}
+	parent = corpus[rnd.Intn(len(corpus))]
+	p = parent.Clone()
+	p.Mutate(rnd, programLength, ct, corpus)
+	return p, parent, StatFuzz
+}
+
 func (proc *Proc) triageInput(item *WorkTriage) {
 	log.Logf(1, "#%v: triaging type=%x", proc.pid, item.flags)
 
@@ -145,7 +179,7 @@ func (proc *Proc) triageInput(item *WorkTriage) {
 		item.p, item.call = prog.Minimize(item.p, item.call, false,
 			func(p1 *prog.Prog, call1 int) bool {
 				for i := 0; i < minimizeAttempts; i++ {
-					info := proc.execute(proc.execOptsNoCollide, p1, ProgNormal, StatMinimize)
+					info := proc.execute(proc.execOptsNoCollide, p1, ProgNormal, StatMinimize, item.src)
 					if info == nil || len(info.Calls) == 0 || len(info.Calls[call1].Signal) == 0 {
 						continue // The call was not executed.
 					}
@@ -168,12 +202,19 @@ func (proc *Proc) triageInput(item *WorkTriage) {
 	data := item.p.Serialize()
 	sig := hash.Hash(data)
 
+	parent := ""
+	if item.src.parent != nil {
+		parent = hash.String(item.src.parent.Serialize())
+	}
+
 	log.Logf(2, "added new input for %v to corpus:\n%s", call.Meta.CallName, data)
 	proc.fuzzer.sendInputToManager(rpctype.RPCInput{
 		Call:   call.Meta.CallName,
 		Prog:   data,
 		Signal: inputSignal.Serialize(),
 		Cover:  inputCover.Serialize(),
+		Origin: item.src.origin,
+		Parent: parent,
 	})
 
 	proc.fuzzer.addInputToCorpus(item.p, inputSignal, sig)
@@ -193,10 +234,28 @@ func (proc *Proc) smashInput(item *WorkSmash) {
 	corpus := proc.fuzzer.corpusSnapshot()
 	for i := 0; i < 100; i++ {
 		p := item.p.Clone()
-		p.Mutate(proc.rnd, programLength, proc.fuzzer.choiceTable, corpus)
+		p.Mutate(proc.rnd, programLength, proc.fuzzer.getChoiceTable(), corpus)
 		log.Logf(1, "#%v: smash mutated", proc.pid)
-		proc.execute(proc.execOpts, p, ProgNormal, StatSmash)
+		proc.execute(proc.execOpts, p, ProgNormal, StatSmash, progSource{rpctype.OriginSmash, item.p})
+	}
+}
+
+func (proc *Proc) replayInput(item *WorkReplay) {
+	log.Logf(1, "#%v: replaying program %v", proc.pid, item.id)
+	res := &rpctype.ReplayDoneArgs{ID: item.id}
+	info := proc.executeRaw(proc.execOptsCover, item.p, StatReplay)
+	if info == nil || len(info.Calls) == 0 {
+		res.Error = "the program was not executed"
+	} else {
+		for i, inf := range info.Calls {
+			var cov cover.Cover
+			cov.Merge(inf.Cover)
+			res.Cover = append(res.Cover, cov.Serialize())
+			sign := signal.FromRaw(inf.Signal, signalPrio(item.p.Target, item.p.Calls[i], &inf))
+			res.Signal = append(res.Signal, sign.Serialize())
+		}
 	}
+	proc.fuzzer.sendReplayToManager(res)
 }
 
 func (proc *Proc) failCall(p *prog.Prog, call int) {
@@ -216,7 +275,7 @@ func (proc *Proc) failCall(p *prog.Prog, call int) {
 func (proc *Proc) executeHintSeed(p *prog.Prog, call int) {
 	log.Logf(1, "#%v: collecting comparisons", proc.pid)
 	// First execute the original program to dump comparisons from KCOV.
-	info := proc.execute(proc.execOptsComps, p, ProgNormal, StatSeed)
+	info := proc.execute(proc.execOptsComps, p, ProgNormal, StatSeed, progSource{rpctype.OriginSmash, p})
 	if info == nil {
 		return
 	}
@@ -224,13 +283,14 @@ func (proc *Proc) executeHintSeed(p *prog.Prog, call int) {
 	// Then mutate the initial program for every match between
 	// a syscall argument and a comparison operand.
 	// Execute each of such mutants to check if it gives new coverage.
-	p.MutateWithHints(call, info.Calls[call].Comps, func(p *prog.Prog) {
+	p.MutateWithHints(call, info.Calls[call].Comps, func(p1 *prog.Prog) {
 		log.Logf(1, "#%v: executing comparison hint", proc.pid)
-		proc.execute(proc.execOpts, p, ProgNormal, StatHint)
+		proc.execute(proc.execOpts, p1, ProgNormal, StatHint, progSource{rpctype.OriginHint, p})
 	})
 }
 
-func (proc *Proc) execute(execOpts *ipc.ExecOpts, p *prog.Prog, flags ProgTypes, stat Stat) *ipc.ProgInfo {
+func (proc *Proc) execute(execOpts *ipc.ExecOpts, p *prog.Prog, flags ProgTypes, stat Stat,
+	src progSource) *ipc.ProgInfo {
 	info := proc.executeRaw(execOpts, p, stat)
 	for _, callIndex := range proc.fuzzer.checkNewSignal(p, info) {
 		info := info.Calls[callIndex]
@@ -244,6 +304,7 @@ func (proc *Proc) execute(execOpts *ipc.ExecOpts, p *prog.Prog, flags ProgTypes,
 			call:  callIndex,
 			info:  info,
 			flags: flags,
+			src:   src,
 		})
 	}
 	return info
@@ -259,9 +320,15 @@ func (proc *Proc) executeRaw(opts *ipc.ExecOpts, p *prog.Prog, stat Stat) *ipc.P
 	defer proc.fuzzer.gate.Leave(ticket)
 
 	proc.logProgram(opts, p)
+	if hooks := proc.fuzzer.progHooks; hooks != nil {
+		hooks.run(proc.pid, "prologue", hooks.prologue)
+		defer hooks.run(proc.pid, "epilogue", hooks.epilogue)
+	}
 	for try := 0; ; try++ {
 		atomic.AddUint64(&proc.fuzzer.stats[stat], 1)
-		output, info, failed, hanged, err := proc.env.Exec(opts, p)
+		execOpts, trace := proc.fuzzer.profiler.start(opts)
+		output, info, failed, hanged, err := proc.env.Exec(execOpts, p)
+		trace.finish(p)
 		if failed {
 			// BUG in output should be recognized by manager.
 			log.Logf(0, "BUG: executor-detected bug:\n%s", output)
@@ -278,6 +345,14 @@ func (proc *Proc) executeRaw(opts *ipc.ExecOpts, p *prog.Prog, stat Stat) *ipc.P
 			continue
 		}
 		log.Logf(2, "result failed=%v hanged=%v: %s\n", failed, hanged, output)
+		signal := 0
+		for _, call := range info.Calls {
+			signal += len(call.Signal)
+		}
+		atomic.AddUint64(&proc.fuzzer.execSignal, uint64(signal))
+		if proc.execs++; proc.execs%errnoSampleRate == 0 {
+			proc.fuzzer.errnos.sample(execOpts, p, info)
+		}
 		return info
 	}
 }
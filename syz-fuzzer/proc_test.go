@@ -0,0 +1,44 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/hash"
+	"github.com/google/syzkaller/prog"
+)
+
+func TestFuzzProgDeterminism(t *testing.T) {
+	target, err := prog.GetTarget("test", "64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct := target.BuildChoiceTable(target.CalculatePriorities(nil), nil)
+	fuzz := func(fuzzerSeed int64, pid int) []byte {
+		rnd := rand.New(rand.NewSource(procSeed(fuzzerSeed, pid)))
+		var corpus []*prog.Prog
+		out := new(bytes.Buffer)
+		for i := 0; i < 100; i++ {
+			p, _, _ := fuzzProg(target, rnd, ct, corpus, i%10 == 0)
+			if i%3 == 0 {
+				corpus = append(corpus, p)
+			}
+			out.Write(p.Serialize())
+		}
+		return out.Bytes()
+	}
+	seed := hash.Seed(42, "vm-0/0")
+	if seed == hash.Seed(42, "vm-0/1") || seed == hash.Seed(43, "vm-0/0") {
+		t.Fatalf("derived seeds are not unique")
+	}
+	if !bytes.Equal(fuzz(seed, 1), fuzz(seed, 1)) {
+		t.Fatalf("fuzzing with the same seed produced different programs")
+	}
+	if bytes.Equal(fuzz(seed, 1), fuzz(seed, 2)) {
+		t.Fatalf("procs with different pids produced the same programs")
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/rpctype"
+)
+
+// adaptProcs chooses the number of procs for this machine if the manager asks to (adaptive_procs config),
+// otherwise returns procs as is.
+func adaptProcs(procs int, r *rpctype.ConnectRes) int {
+	if r.MaxProcs == 0 {
+		return procs
+	}
+	cpus := runtime.NumCPU()
+	mem := memAvailable()
+	procs = chooseProcs(r.MinProcs, r.MaxProcs, r.MemPerProc, cpus, mem)
+	log.Logf(0, "procs: %v (cpus: %v, available memory: %v MB)", procs, cpus, mem)
+	return procs
+}
+
+// chooseProcs returns the number of procs for a machine with the given number of CPUs
+// and available memory in MB (0 if unknown): a proc per CPU, but not more than memory allows,
+// within [min, max].
+func chooseProcs(min, max, memPerProc, cpus, mem int) int {
+	procs := cpus
+	if mem != 0 && memPerProc != 0 && procs > mem/memPerProc {
+		procs = mem / memPerProc
+	}
+	if procs > max {
+		procs = max
+	}
+	if procs < min {
+		procs = min
+	}
+	return procs
+}
+
+// memAvailable returns available memory in MB, or 0 if it's unknown.
+func memAvailable() int {
+	data, err := ioutil.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	return parseMemAvailable(data)
+}
+
+// parseMemAvailable extracts the "MemAvailable: 123456 kB" value from /proc/meminfo in MB.
+func parseMemAvailable(data []byte) int {
+	for s := bufio.NewScanner(bytes.NewReader(data)); s.Scan(); {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 3 || fields[0] != "MemAvailable:" || fields[2] != "kB" {
+			continue
+		}
+		kb, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return 0
+		}
+		return kb >> 10
+	}
+	return 0
+}
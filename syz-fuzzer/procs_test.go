@@ -0,0 +1,43 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestChooseProcs(t *testing.T) {
+	tests := []struct {
+		min, max, memPerProc, cpus, mem int
+		procs                           int
+	}{
+		{1, 8, 256, 2, 0, 2},
+		{1, 8, 256, 16, 0, 8},
+		{1, 8, 256, 16, 1024, 4},
+		{2, 8, 256, 16, 100, 2},
+		{4, 8, 256, 2, 8192, 4},
+		{1, 32, 256, 16, 65536, 16},
+	}
+	for _, test := range tests {
+		procs := chooseProcs(test.min, test.max, test.memPerProc, test.cpus, test.mem)
+		if procs != test.procs {
+			t.Errorf("chooseProcs(min=%v max=%v mem_per_proc=%v cpus=%v mem=%v) = %v, want %v",
+				test.min, test.max, test.memPerProc, test.cpus, test.mem, procs, test.procs)
+		}
+	}
+}
+
+func TestParseMemAvailable(t *testing.T) {
+	meminfo := `MemTotal:        2040788 kB
+MemFree:          112344 kB
+MemAvailable:    1048576 kB
+Buffers:           83440 kB
+`
+	if mem := parseMemAvailable([]byte(meminfo)); mem != 1024 {
+		t.Fatalf("got %v MB, want 1024", mem)
+	}
+	if mem := parseMemAvailable([]byte("MemTotal: 2040788 kB\n")); mem != 0 {
+		t.Fatalf("got %v MB for missing MemAvailable", mem)
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/syzkaller/pkg/ipc"
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/osutil"
+	"github.com/google/syzkaller/pkg/rpctype"
+	"github.com/google/syzkaller/prog"
+)
+
+// profiler traces a sample of program executions and sends kernel profiles of slow programs
+// to the manager (slow_profiles in manager config). With ftrace the executor enables tracing
+// around the program (FlagProfile), with perf a system-wide perf record runs during the execution.
+// At most one execution is traced at a time. Profiling is disabled if the tracer is unavailable or fails.
+type profiler struct {
+	tracer    string
+	sample    uint64
+	threshold time.Duration
+	maxSize   int
+	tracefs   string // tracefs dir for ftrace
+	send      func(a *rpctype.NewProfileArgs)
+
+	execs    uint64 // executions since start (atomic)
+	disabled uint32 // atomic
+	busy     chan struct{}
+	once     sync.Once
+}
+
+// profTrace is a traced program execution.
+type profTrace struct {
+	prof     *profiler
+	start    time.Time
+	file     string // perf record output
+	perf     *exec.Cmd
+	perfDone chan error
+}
+
+var tracefsDirs = []string{"/sys/kernel/debug/tracing", "/sys/kernel/tracing"}
+
+const (
+	perfStartTimeout  = 10 * time.Second
+	perfReportTimeout = time.Minute
+)
+
+// newProfiler returns nil if profiling is not configured.
+func newProfiler(r *rpctype.ConnectRes, send func(a *rpctype.NewProfileArgs)) *profiler {
+	if r.ProfileTracer == "" {
+		return nil
+	}
+	prof := &profiler{
+		tracer:    r.ProfileTracer,
+		sample:    uint64(r.ProfileSample),
+		threshold: r.ProfileThreshold,
+		maxSize:   r.ProfileMaxSize,
+		send:      send,
+		busy:      make(chan struct{}, 1),
+	}
+	if err := prof.setup(); err != nil {
+		prof.disable(err)
+	}
+	return prof
+}
+
+func (prof *profiler) setup() error {
+	switch prof.tracer {
+	case "ftrace":
+		for _, dir := range tracefsDirs {
+			if osutil.IsExist(filepath.Join(dir, "tracing_on")) {
+				prof.tracefs = dir
+				break
+			}
+		}
+		if prof.tracefs == "" {
+			return fmt.Errorf("tracefs is not mounted (tried %v)", strings.Join(tracefsDirs, ", "))
+		}
+		tracers, err := ioutil.ReadFile(filepath.Join(prof.tracefs, "available_tracers"))
+		if err != nil {
+			return err
+		}
+		if !bytes.Contains(append(tracers, ' '), []byte("function_graph ")) {
+			return fmt.Errorf("function_graph tracer is not available (%s)", bytes.TrimSpace(tracers))
+		}
+		for _, setting := range []struct{ file, val string }{
+			{"tracing_on", "0"},
+			{"current_tracer", "function_graph"},
+			{"buffer_size_kb", fmt.Sprint(prof.maxSize >> 10)},
+			{"options/funcgraph-proc", "1"},
+			{"trace", ""},
+		} {
+			if err := osutil.WriteFile(filepath.Join(prof.tracefs, setting.file), []byte(setting.val)); err != nil {
+				return fmt.Errorf("failed to setup ftrace: %v", err)
+			}
+		}
+	case "perf":
+		if _, err := exec.LookPath("perf"); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown tracer %q", prof.tracer)
+	}
+	log.Logf(0, "profiling 1 of %v executions with %v", prof.sample, prof.tracer)
+	return nil
+}
+
+// envFlags returns env flags required for profiling.
+func (prof *profiler) envFlags() ipc.EnvFlags {
+	if prof == nil || prof.tracer != "ftrace" || atomic.LoadUint32(&prof.disabled) != 0 {
+		return 0
+	}
+	return ipc.FlagEnableProfile
+}
+
+// disable disables profiling and reports the reason to the manager (only the first time).
+func (prof *profiler) disable(err error) {
+	atomic.StoreUint32(&prof.disabled, 1)
+	prof.once.Do(func() {
+		log.Logf(0, "%v profiling disabled: %v", prof.tracer, err)
+		prof.send(&rpctype.NewProfileArgs{
+			Tracer: prof.tracer,
+			Error:  err.Error(),
+		})
+	})
+}
+
+// start starts tracing if the execution is sampled. It returns exec opts for the execution
+// and the trace which must be finished after the execution (nil if the execution is not traced).
+func (prof *profiler) start(opts *ipc.ExecOpts) (*ipc.ExecOpts, *profTrace) {
+	if prof == nil || atomic.LoadUint32(&prof.disabled) != 0 ||
+		atomic.AddUint64(&prof.execs, 1)%prof.sample != 0 {
+		return opts, nil
+	}
+	select {
+	case prof.busy <- struct{}{}:
+	default:
+		return opts, nil
+	}
+	tr := &profTrace{prof: prof}
+	switch prof.tracer {
+	case "ftrace":
+		if err := osutil.WriteFile(filepath.Join(prof.tracefs, "trace"), nil); err != nil {
+			prof.disable(fmt.Errorf("failed to clear trace: %v", err))
+			<-prof.busy
+			return opts, nil
+		}
+		traceOpts := *opts
+		traceOpts.Flags |= ipc.FlagProfile
+		opts = &traceOpts
+	case "perf":
+		if err := tr.startPerf(); err != nil {
+			prof.disable(err)
+			<-prof.busy
+			return opts, nil
+		}
+	}
+	tr.start = time.Now()
+	return opts, tr
+}
+
+func (tr *profTrace) startPerf() error {
+	f, err := ioutil.TempFile("", "syz-perf")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	os.Remove(f.Name())
+	tr.file = f.Name()
+	tr.perf = osutil.Command("perf", "record", "-a", "-g", "-q", "-o", tr.file)
+	output := new(bytes.Buffer)
+	tr.perf.Stdout = output
+	tr.perf.Stderr = output
+	if err := tr.perf.Start(); err != nil {
+		return fmt.Errorf("failed to start perf: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- tr.perf.Wait() }()
+	for start := time.Now(); !osutil.IsExist(tr.file); time.Sleep(10 * time.Millisecond) {
+		select {
+		case err := <-done:
+			os.Remove(tr.file)
+			return fmt.Errorf("perf record exited: %v\n%s", err, output.Bytes())
+		default:
+		}
+		if time.Since(start) > perfStartTimeout {
+			tr.perf.Process.Kill()
+			<-done
+			os.Remove(tr.file)
+			return fmt.Errorf("perf record did not start in %v", perfStartTimeout)
+		}
+	}
+	tr.perfDone = done
+	return nil
+}
+
+// finish stops tracing and sends the profile to the manager if the program was slow.
+// tr can be nil, then finish does nothing.
+func (tr *profTrace) finish(p *prog.Prog) {
+	if tr == nil {
+		return
+	}
+	prof := tr.prof
+	defer func() { <-prof.busy }()
+	duration := time.Since(tr.start)
+	var profile []byte
+	var err error
+	switch prof.tracer {
+	case "ftrace":
+		if duration > prof.threshold {
+			profile, err = readCapped(filepath.Join(prof.tracefs, "trace"), prof.maxSize)
+		}
+	case "perf":
+		tr.perf.Process.Signal(os.Interrupt)
+		err = <-tr.perfDone
+		if err == nil && duration > prof.threshold {
+			var output []byte
+			output, err = osutil.RunCmd(perfReportTimeout, "", "perf", "report", "--stdio", "-i", tr.file)
+			profile = capProfile(output, prof.maxSize)
+		}
+		os.Remove(tr.file)
+		if err != nil {
+			err = fmt.Errorf("perf failed: %v", err)
+		}
+	}
+	if err != nil {
+		prof.disable(err)
+		return
+	}
+	if profile == nil {
+		return
+	}
+	log.Logf(1, "sending %v profile of a program that took %v", prof.tracer, duration)
+	prof.send(&rpctype.NewProfileArgs{
+		Prog:     p.Serialize(),
+		Duration: duration,
+		Tracer:   prof.tracer,
+		Profile:  profile,
+	})
+}
+
+func readCapped(file string, maxSize int) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(f, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	return capProfile(data, maxSize), nil
+}
+
+func capProfile(data []byte, maxSize int) []byte {
+	if len(data) <= maxSize {
+		return data
+	}
+	return append(data[:maxSize:maxSize], "\n<<truncated>>\n"...)
+}
@@ -0,0 +1,127 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/ipc"
+	"github.com/google/syzkaller/pkg/rpctype"
+	"github.com/google/syzkaller/prog"
+)
+
+func TestProfilerFtrace(t *testing.T) {
+	target, err := prog.GetTarget("test", "64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "syz-fuzzer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dirs []string) { tracefsDirs = dirs }(tracefsDirs)
+	tracefsDirs = []string{filepath.Join(dir, "none"), dir}
+	if err := os.Mkdir(filepath.Join(dir, "options"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile := func(file, data string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("tracing_on", "1")
+	writeFile("available_tracers", "function_graph function nop\n")
+	var sent []*rpctype.NewProfileArgs
+	prof := newProfiler(&rpctype.ConnectRes{
+		ProfileTracer:    "ftrace",
+		ProfileSample:    3,
+		ProfileThreshold: time.Millisecond,
+		ProfileMaxSize:   8 << 10,
+	}, func(a *rpctype.NewProfileArgs) {
+		sent = append(sent, a)
+	})
+	if len(sent) != 0 {
+		t.Fatalf("profiler failed to setup: %+v", sent[0])
+	}
+	if prof.envFlags() != ipc.FlagEnableProfile {
+		t.Fatalf("profiling is not enabled in executor")
+	}
+	for file, want := range map[string]string{
+		"tracing_on":             "0",
+		"current_tracer":         "function_graph",
+		"buffer_size_kb":         "8",
+		"options/funcgraph-proc": "1",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%v = %q, want %q", file, data, want)
+		}
+	}
+
+	p, err := target.Deserialize([]byte("test()\n"), prog.Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &ipc.ExecOpts{Flags: ipc.FlagDedupCover}
+	var traced []*profTrace
+	for i := 0; i < 9; i++ {
+		execOpts, tr := prof.start(opts)
+		if tr == nil {
+			if execOpts.Flags&ipc.FlagProfile != 0 {
+				t.Fatalf("execution %v is not traced, but has FlagProfile", i)
+			}
+			continue
+		}
+		if execOpts.Flags&ipc.FlagProfile == 0 || opts.Flags&ipc.FlagProfile != 0 {
+			t.Fatalf("bad exec flags of traced execution: %v/%v", execOpts.Flags, opts.Flags)
+		}
+		if i == 2 {
+			for j := 0; j < 3; j++ {
+				if _, tr1 := prof.start(opts); tr1 != nil {
+					t.Fatalf("execution is traced concurrently")
+				}
+			}
+		}
+		// This is what the executor does when the program runs.
+		writeFile("trace", "# tracer: function_graph\n"+strings.Repeat(" 0)  | do_sys_open() {\n", 1000))
+		time.Sleep(2 * time.Millisecond)
+		tr.finish(p)
+		traced = append(traced, tr)
+	}
+	if len(traced) != 3 || len(sent) != 3 {
+		t.Fatalf("traced %v executions, sent %v profiles, want 3", len(traced), len(sent))
+	}
+	for _, a := range sent {
+		if a.Error != "" || a.Tracer != "ftrace" || string(a.Prog) != string(p.Serialize()) ||
+			a.Duration < 2*time.Millisecond {
+			t.Errorf("bad profile: %+v", a)
+		}
+		if len(a.Profile) > 8<<10+100 || !strings.HasSuffix(string(a.Profile), "<<truncated>>\n") {
+			t.Errorf("profile is not truncated: %v bytes", len(a.Profile))
+		}
+	}
+
+	// Profiling is disabled once tracing fails, the manager is notified once.
+	os.RemoveAll(dir)
+	for i := 0; i < 10; i++ {
+		if _, tr := prof.start(opts); tr != nil {
+			t.Fatalf("execution is traced after a failure")
+		}
+	}
+	if len(sent) != 4 || sent[3].Error == "" {
+		t.Fatalf("profiling failure is not reported: %+v", sent[3:])
+	}
+	if prof.envFlags() != 0 {
+		t.Fatalf("profiling is enabled in executor after a failure")
+	}
+}
@@ -16,6 +16,7 @@ import (
 // in order to not permanently lose interesting programs in case of VM crash.
 type WorkQueue struct {
 	mu              sync.RWMutex
+	replay          []*WorkReplay
 	triageCandidate []*WorkTriage
 	candidate       []*WorkCandidate
 	triage          []*WorkTriage
@@ -43,14 +44,23 @@ type WorkTriage struct {
 	call  int
 	info  ipc.CallInfo
 	flags ProgTypes
+	src   progSource
+}
+
+// progSource describes where an executed program comes from,
+// it becomes provenance of the program if the program is added to corpus.
+type progSource struct {
+	origin string     // one of rpctype.Origin* constants
+	parent *prog.Prog // corpus program the program was derived from (nil if none)
 }
 
 // WorkCandidate are programs from hub.
 // We don't know yet if they are useful for this fuzzer or not.
 // A proc handles them the same way as locally generated/mutated programs.
 type WorkCandidate struct {
-	p     *prog.Prog
-	flags ProgTypes
+	p      *prog.Prog
+	flags  ProgTypes
+	origin string
 }
 
 // WorkSmash are programs just added to corpus.
@@ -61,6 +71,13 @@ type WorkSmash struct {
 	call int
 }
 
+// WorkReplay are corpus programs that the manager asked to execute once
+// and report their coverage (e.g. to check if a program still gives the same coverage).
+type WorkReplay struct {
+	p  *prog.Prog
+	id int
+}
+
 func newWorkQueue(procs int, needCandidates chan struct{}) *WorkQueue {
 	return &WorkQueue{
 		procs:          procs,
@@ -82,6 +99,8 @@ func (wq *WorkQueue) enqueue(item interface{}) {
 		wq.candidate = append(wq.candidate, item)
 	case *WorkSmash:
 		wq.smash = append(wq.smash, item)
+	case *WorkReplay:
+		wq.replay = append(wq.replay, item)
 	default:
 		panic("unknown work type")
 	}
@@ -89,14 +108,17 @@ func (wq *WorkQueue) enqueue(item interface{}) {
 
 func (wq *WorkQueue) dequeue() (item interface{}) {
 	wq.mu.RLock()
-	if len(wq.triageCandidate)+len(wq.candidate)+len(wq.triage)+len(wq.smash) == 0 {
+	if len(wq.replay)+len(wq.triageCandidate)+len(wq.candidate)+len(wq.triage)+len(wq.smash) == 0 {
 		wq.mu.RUnlock()
 		return nil
 	}
 	wq.mu.RUnlock()
 	wq.mu.Lock()
 	wantCandidates := false
-	if len(wq.triageCandidate) != 0 {
+	if len(wq.replay) != 0 {
+		item = wq.replay[0]
+		wq.replay = wq.replay[1:]
+	} else if len(wq.triageCandidate) != 0 {
 		last := len(wq.triageCandidate) - 1
 		item = wq.triageCandidate[last]
 		wq.triageCandidate = wq.triageCandidate[:last]
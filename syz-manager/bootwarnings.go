@@ -0,0 +1,46 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/google/syzkaller/pkg/crashdir"
+	"github.com/google/syzkaller/pkg/log"
+)
+
+// saveBootWarning saves a kernel oops that was printed during boot, before the fuzzer executed
+// any programs. Such oopses are not caused by fuzzing and happen on every boot of the same kernel,
+// so they are not reproduced, not reported to the dashboard and are saved (and emailed)
+// only once per kernel build. They are shown on the VMs page as kernel health issues.
+func (mgr *Manager) saveBootWarning(crash *Crash, source string) {
+	mgr.stats.bootWarnings.inc()
+	tag := mgr.cfg.Tag
+	if crash.kernelTag != "" {
+		tag += "/" + crash.kernelTag
+	}
+	if crashdir.HasTag(mgr.bootWarnDir, crash.Title, tag) {
+		log.Logf(1, "%v: boot warning: %v (already saved for this build)", source, crash.Title)
+		return
+	}
+	log.Crashf("%v: boot warning: %v", source, crash.Title)
+	if err := mgr.reporter.Symbolize(crash.Report); err != nil {
+		log.Crashf("failed to symbolize report: %v", err)
+	}
+	occ := &crashdir.Occurrence{
+		Log:          crash.Output,
+		Report:       crash.Report.Report,
+		Tag:          tag,
+		Meta:         mgr.crashMeta(crash),
+		Recording:    crash.recording,
+		Diagnosis:    crash.Diagnosis,
+		TimedConsole: crash.TimedConsole,
+		Ftrace:       crash.Ftrace,
+	}
+	index, _, err := crashdir.SaveCrash(mgr.bootWarnDir, crash.Title, occ)
+	if err != nil {
+		log.Logf(0, "failed to save boot warning: %v", err)
+		return
+	}
+	log.Logf(1, "%v: saved boot warning as %v/log%v", source, crashdir.ID(crash.Title), index)
+	go mgr.emailCrash(crash)
+}
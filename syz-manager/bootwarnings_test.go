@@ -0,0 +1,82 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/crashdir"
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/report"
+	"github.com/google/syzkaller/prog"
+	_ "github.com/google/syzkaller/sys"
+)
+
+func TestBootWarnings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-manager-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &mgrconfig.Config{TargetOS: "linux", TargetArch: "amd64", Tag: "build1"}
+	reporter, err := report.NewReporter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := &Manager{
+		cfg:         cfg,
+		target:      target,
+		reporter:    reporter,
+		crashdir:    filepath.Join(dir, "crashes"),
+		bootWarnDir: filepath.Join(dir, "boot-warnings"),
+		stats:       new(Stats),
+		crashTypes:  make(map[string]bool),
+		vmStats:     newVMStats(0),
+		focus:       newFocusState(),
+	}
+	if mgr.suppressions, err = newScopedSuppressions(nil); err != nil {
+		t.Fatal(err)
+	}
+	const title = "WARNING in foo"
+	newCrash := func() *Crash {
+		return &Crash{
+			vmIndex: 0,
+			Report: &report.Report{
+				Title:  title,
+				Class:  report.ClassBootWarning,
+				Output: []byte("WARNING: CPU: 0 PID: 1 at foo.c:1 foo\n"),
+				Report: []byte("WARNING: CPU: 0 PID: 1 at foo.c:1 foo\n"),
+			},
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if mgr.saveCrash(newCrash()) {
+			t.Fatalf("boot warning needs repro")
+		}
+	}
+	cfg.Tag = "build2"
+	if mgr.saveCrash(newCrash()) {
+		t.Fatalf("boot warning needs repro")
+	}
+	if got := mgr.stats.bootWarnings.get(); got != 4 {
+		t.Fatalf("got %v boot warnings, want 4", got)
+	}
+	if got := mgr.stats.crashes.get(); got != 0 {
+		t.Fatalf("boot warnings are counted as %v crashes", got)
+	}
+	if types, _ := crashdir.List(mgr.crashdir); len(types) != 0 {
+		t.Fatalf("boot warnings are saved as crashes")
+	}
+	warnings := mgr.bootWarnings()
+	if len(warnings) != 1 || warnings[0].Title != title || warnings[0].Builds != 2 {
+		t.Fatalf("bad boot warnings: %+v", warnings)
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/osutil"
+	"github.com/google/syzkaller/pkg/rpctype"
+)
+
+// callHealth detects description regressions: after descriptions change, a syscall that used to
+// succeed most of the time can silently start failing all the time (e.g. with EINVAL because of
+// a wrong struct layout). Fuzzers report sampled per-syscall errno histograms, callHealth computes
+// success rates of syscalls and keeps their baselines per kernel build. When a success rate collapses
+// relative to the baseline that was collected with a different syzkaller/descriptions revision,
+// the syscall is reported as regressed. Baselines are not changed while a syscall is regressed.
+type callHealth struct {
+	file     string // baselines are persisted here across manager restarts
+	kernel   string // identity of the current kernel/image
+	revision string // syzkaller and descriptions revision
+
+	mu        sync.Mutex
+	state     callHealthState
+	calls     map[string]*callWindow
+	regressed map[string]time.Time // regressed syscall -> when the regression was detected
+}
+
+type callHealthState struct {
+	// Kernel identity -> syscall name -> baseline.
+	Baselines map[string]map[string]*callBaseline `json:"baselines"`
+}
+
+type callBaseline struct {
+	SuccessRate float64   `json:"success_rate"`
+	Samples     int       `json:"samples"`
+	Revision    string    `json:"revision"`
+	Updated     time.Time `json:"updated"`
+}
+
+type callWindow struct {
+	errnos   map[int]uint64 // errnos in the current window
+	total    uint64         // sampled executions in the current window
+	rate     float64        // success rate over the last complete window (-1 if none)
+	errno    int            // the most frequent errno over the last complete window
+	examples []callExample  // the most recent failing programs
+}
+
+type callExample struct {
+	Errno int
+	Prog  []byte
+}
+
+// callHealthStatus is a snapshot of a syscall health.
+type callHealthStatus struct {
+	Call      string
+	Rate      float64 // -1 if unknown yet
+	Errno     int     // the most frequent errno
+	Baseline  *callBaseline
+	Regressed bool
+	Since     time.Time // when the regression was detected
+	Examples  []callExample
+}
+
+const (
+	// Success rate of a syscall is computed over windows of at least that many sampled executions.
+	callHealthWindow = 200
+	// Baseline is trusted after that many windows.
+	callHealthSamples = 5
+	// After that many samples the baseline becomes a moving average with weight 1/callHealthHistory.
+	callHealthHistory = 50
+	// Success rate has collapsed if it's that many times lower than the baseline...
+	callHealthDropFactor = 4
+	// ...and the baseline is at least that high (rarely succeeding syscalls are too noisy).
+	callHealthMinRate = 0.1
+	// Number of example failing programs kept per syscall.
+	callHealthExamples = 3
+)
+
+func newCallHealth(file, kernel, revision string) *callHealth {
+	ch := &callHealth{
+		file:      file,
+		kernel:    kernel,
+		revision:  revision,
+		calls:     make(map[string]*callWindow),
+		regressed: make(map[string]time.Time),
+	}
+	if data, err := ioutil.ReadFile(file); err == nil {
+		if err := json.Unmarshal(data, &ch.state); err != nil {
+			log.Logf(0, "failed to parse %v: %v", file, err)
+		}
+	}
+	if ch.state.Baselines == nil {
+		ch.state.Baselines = make(map[string]map[string]*callBaseline)
+	}
+	return ch
+}
+
+// add accounts errno histograms reported by a fuzzer.
+func (ch *callHealth) add(calls map[string]*rpctype.CallErrnos) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	for name, call := range calls {
+		win := ch.calls[name]
+		if win == nil {
+			win = &callWindow{errnos: make(map[int]uint64), rate: -1}
+			ch.calls[name] = win
+		}
+		for errno, n := range call.Errnos {
+			win.errnos[errno] += n
+			win.total += n
+		}
+		if call.Example != nil {
+			win.examples = append(win.examples, callExample{call.ExampleErrno, call.Example})
+			if len(win.examples) > callHealthExamples {
+				win.examples = win.examples[1:]
+			}
+		}
+	}
+}
+
+// check is called periodically, returns new alerts about regressed syscalls.
+func (ch *callHealth) check(now time.Time) []string {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	baselines := ch.state.Baselines[ch.kernel]
+	if baselines == nil {
+		baselines = make(map[string]*callBaseline)
+		ch.state.Baselines[ch.kernel] = baselines
+	}
+	var alerts []string
+	updated := false
+	for name, win := range ch.calls {
+		if win.total < callHealthWindow {
+			continue
+		}
+		win.rate = float64(win.errnos[0]) / float64(win.total)
+		win.errno = 0
+		for errno, n := range win.errnos {
+			if errno != 0 && (win.errno == 0 || n > win.errnos[win.errno] ||
+				n == win.errnos[win.errno] && errno < win.errno) {
+				win.errno = errno
+			}
+		}
+		win.errnos = make(map[int]uint64)
+		win.total = 0
+		base := baselines[name]
+		if ch.collapsed(base, win.rate) {
+			if _, ok := ch.regressed[name]; !ok {
+				ch.regressed[name] = now
+				alerts = append(alerts, fmt.Sprintf("%v: success rate dropped from %.0f%% to %.0f%%"+
+					" (mostly errno %v) after syzkaller/descriptions update", name,
+					base.SuccessRate*100, win.rate*100, win.errno))
+			}
+			// Don't let broken descriptions pollute the baseline.
+			continue
+		}
+		delete(ch.regressed, name)
+		if base == nil || base.Revision != ch.revision {
+			// The syscall is healthy with the current revision, so further comparisons are with it.
+			base = &callBaseline{SuccessRate: win.rate}
+			baselines[name] = base
+		}
+		if base.Samples < callHealthHistory {
+			base.Samples++
+		}
+		base.SuccessRate += (win.rate - base.SuccessRate) / float64(base.Samples)
+		base.Revision = ch.revision
+		base.Updated = now
+		updated = true
+	}
+	if updated {
+		ch.save()
+	}
+	sort.Strings(alerts)
+	return alerts
+}
+
+// collapsed says if the success rate has collapsed relative to the baseline collected with another revision.
+func (ch *callHealth) collapsed(base *callBaseline, rate float64) bool {
+	return base != nil && base.Revision != ch.revision && base.Samples >= callHealthSamples &&
+		base.SuccessRate >= callHealthMinRate && rate*callHealthDropFactor < base.SuccessRate
+}
+
+// status returns health of all syscalls, the worst regressions first.
+func (ch *callHealth) status() []*callHealthStatus {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	var res []*callHealthStatus
+	for name, win := range ch.calls {
+		st := &callHealthStatus{
+			Call:     name,
+			Rate:     win.rate,
+			Errno:    win.errno,
+			Examples: append([]callExample{}, win.examples...),
+		}
+		if base := ch.state.Baselines[ch.kernel][name]; base != nil {
+			b := *base
+			st.Baseline = &b
+		}
+		st.Since, st.Regressed = ch.regressed[name]
+		res = append(res, st)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Regressed != res[j].Regressed {
+			return res[i].Regressed
+		}
+		if di, dj := res[i].drop(), res[j].drop(); di != dj {
+			return di > dj
+		}
+		return res[i].Call < res[j].Call
+	})
+	return res
+}
+
+// drop returns the drop of the success rate relative to the baseline.
+func (st *callHealthStatus) drop() float64 {
+	if st.Baseline == nil || st.Rate < 0 {
+		return 0
+	}
+	return st.Baseline.SuccessRate - st.Rate
+}
+
+// regressions returns the number of currently regressed syscalls.
+func (ch *callHealth) regressions() int {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return len(ch.regressed)
+}
+
+func (ch *callHealth) save() {
+	data, err := json.MarshalIndent(&ch.state, "", "\t")
+	if err != nil {
+		log.Fatalf("failed to marshal syscall health baselines: %v", err)
+	}
+	if err := osutil.WriteFile(ch.file, data); err != nil {
+		log.Logf(0, "failed to write %v: %v", ch.file, err)
+	}
+}
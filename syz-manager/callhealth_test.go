@@ -0,0 +1,93 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/rpctype"
+)
+
+func TestCallHealth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-manager-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "callhealth.json")
+	now := time.Now()
+	// window reports a window of executions of syscalls with the given success rates (in percents).
+	window := func(ch *callHealth, rates map[string]uint64) []string {
+		calls := make(map[string]*rpctype.CallErrnos)
+		for name, rate := range rates {
+			calls[name] = &rpctype.CallErrnos{
+				Errnos:       map[int]uint64{0: rate * 2, 22: (100 - rate) * 2},
+				Example:      []byte(name + "()\n"),
+				ExampleErrno: 22,
+			}
+		}
+		ch.add(calls)
+		now = now.Add(time.Minute)
+		return ch.check(now)
+	}
+	healthy := map[string]uint64{"open": 60, "ioctl": 30, "rare": 5}
+	ch := newCallHealth(file, "kernel", "rev1")
+	for i := 0; i < callHealthSamples; i++ {
+		if alerts := window(ch, healthy); len(alerts) != 0 {
+			t.Fatalf("got alerts on the first revision: %v", alerts)
+		}
+	}
+	// Success rate drops without syzkaller/descriptions update are not regressions.
+	if alerts := window(ch, map[string]uint64{"open": 0}); len(alerts) != 0 {
+		t.Fatalf("got alerts without revision change: %v", alerts)
+	}
+	for i := 0; i < callHealthSamples; i++ {
+		window(ch, healthy)
+	}
+
+	// Baselines are persisted, the new revision breaks open and rare (but rare is too noisy to check).
+	ch = newCallHealth(file, "kernel", "rev2")
+	broken := map[string]uint64{"open": 0, "ioctl": 25, "rare": 0}
+	alerts := window(ch, broken)
+	if len(alerts) != 1 || !strings.HasPrefix(alerts[0], "open: success rate dropped from") ||
+		!strings.Contains(alerts[0], "errno 22") {
+		t.Fatalf("got alerts %q", alerts)
+	}
+	// The alert is raised once.
+	if alerts := window(ch, broken); len(alerts) != 0 {
+		t.Fatalf("got repeated alerts %q", alerts)
+	}
+	if ch.regressions() != 1 {
+		t.Fatalf("got %v regressions, want 1", ch.regressions())
+	}
+	status := ch.status()
+	if len(status) != 3 || status[0].Call != "open" || !status[0].Regressed || status[0].Rate != 0 ||
+		status[0].Baseline.Revision != "rev1" || len(status[0].Examples) != 2 {
+		t.Fatalf("bad status of the regressed syscall: %+v", status[0])
+	}
+	if status[1].Regressed || status[1].Baseline.Revision != "rev2" {
+		t.Fatalf("bad status of a healthy syscall: %+v", status[1])
+	}
+
+	// Baselines of other kernels are not compared.
+	ch = newCallHealth(file, "kernel2", "rev2")
+	if alerts := window(ch, broken); len(alerts) != 0 {
+		t.Fatalf("got alerts for another kernel: %v", alerts)
+	}
+
+	// The regressed baseline was not updated, so the regression persists across restarts until recovery.
+	ch = newCallHealth(file, "kernel", "rev2")
+	if alerts := window(ch, broken); len(alerts) != 1 {
+		t.Fatalf("got alerts %q after restart", alerts)
+	}
+	window(ch, healthy)
+	if ch.regressions() != 0 {
+		t.Fatalf("the regression is not cleared after recovery")
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// crashCooldown suppresses repeated crashes with the same title from the same VM:
+// a VM that crashes, reboots and immediately re-hits the same bug produces a stream
+// of duplicate reports that carry no new information.
+type crashCooldown struct {
+	period time.Duration
+
+	mu   sync.Mutex
+	last map[int]map[string]time.Time // VM index -> title -> time of the last reported crash
+}
+
+func newCrashCooldown(period time.Duration) *crashCooldown {
+	return &crashCooldown{
+		period: period,
+		last:   make(map[int]map[string]time.Time),
+	}
+}
+
+// suppress returns true if a crash with the title from the VM should not be reported
+// because the VM has already reported it within the cooldown period.
+// Otherwise the crash is recorded as reported.
+func (cc *crashCooldown) suppress(vmIndex int, title string, now time.Time) bool {
+	if cc.period == 0 {
+		return false
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	titles := cc.last[vmIndex]
+	if titles == nil {
+		titles = make(map[string]time.Time)
+		cc.last[vmIndex] = titles
+	}
+	if last, ok := titles[title]; ok && now.Sub(last) < cc.period {
+		return true
+	}
+	titles[title] = now
+	// Forget old entries, so that the map does not grow indefinitely.
+	for title1, last := range titles {
+		if now.Sub(last) >= cc.period {
+			delete(titles, title1)
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCrashCooldown(t *testing.T) {
+	cc := newCrashCooldown(time.Minute)
+	now := time.Now()
+	type crash struct {
+		vm       int
+		title    string
+		after    time.Duration
+		suppress bool
+	}
+	crashes := []crash{
+		{0, "KASAN: use-after-free Read in foo", 0, false},
+		{0, "KASAN: use-after-free Read in foo", 10 * time.Second, true},
+		{1, "KASAN: use-after-free Read in foo", 15 * time.Second, false},
+		{0, "WARNING in bar", 20 * time.Second, false},
+		{0, "KASAN: use-after-free Read in foo", 59 * time.Second, true},
+		{0, "KASAN: use-after-free Read in foo", 61 * time.Second, false},
+		{0, "KASAN: use-after-free Read in foo", 100 * time.Second, true},
+	}
+	for i, c := range crashes {
+		if got := cc.suppress(c.vm, c.title, now.Add(c.after)); got != c.suppress {
+			t.Errorf("crash #%v (vm-%v %q at %v): suppress=%v, want %v",
+				i, c.vm, c.title, c.after, got, c.suppress)
+		}
+	}
+	disabled := newCrashCooldown(0)
+	for i := 0; i < 2; i++ {
+		if disabled.suppress(0, "WARNING in bar", now) {
+			t.Errorf("disabled cooldown suppressed a crash")
+		}
+	}
+}
@@ -54,6 +54,29 @@ func generateCoverHTML(w io.Writer, kernelObj, kernelObjName, kernelSrc, arch st
 	return reportGenerator.Do(w, pcs)
 }
 
+// symbolizeCover returns source locations of coverage pcs, PCs without debug info are not present in the result.
+func symbolizeCover(kernelObj, kernelObjName, kernelSrc, arch string, pcs []uint32) (map[uint32]cover.Location, error) {
+	initCoverOnce.Do(func() { initCoverError = initCover(kernelObj, kernelObjName, kernelSrc, arch) })
+	if initCoverError != nil {
+		return nil, initCoverError
+	}
+	restored := make([]uint64, len(pcs))
+	for i, pc := range pcs {
+		restored[i] = cover.RestorePC(pc, initCoverVMOffset)
+	}
+	locs, err := reportGenerator.Locations(restored)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[uint32]cover.Location)
+	for i, pc := range pcs {
+		if loc, ok := locs[restored[i]]; ok {
+			res[pc] = loc
+		}
+	}
+	return res, nil
+}
+
 func getVMOffset(vmlinux string) (uint32, error) {
 	out, err := osutil.RunCmd(time.Hour, "", "readelf", "-SW", vmlinux)
 	if err != nil {
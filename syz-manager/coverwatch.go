@@ -0,0 +1,169 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/osutil"
+)
+
+// coverWatchdog detects silent breakage of coverage collection
+// (e.g. a kernel config change that disables KCOV for a subsystem).
+// Corpus signal naturally plateaus as the corpus converges, so instead the watchdog looks at
+// the average signal per execution, which does not depend on novelty of programs,
+// and compares it with the historical baseline of the same kernel
+// (and with the baseline of the previous kernel right after a kernel change).
+type coverWatchdog struct {
+	file       string  // baselines are persisted here across manager restarts
+	kernel     string  // identity of the current kernel/image
+	prev       string  // identity of the kernel that was used before (if changed)
+	minSignal  float64 // alert if signal per execution is lower (0 to disable)
+	dropFactor float64 // alert if signal per execution drops by this factor (0 to disable)
+
+	mu         sync.Mutex
+	state      coverWatchState
+	execs      uint64 // executions in the current window
+	signal     uint64 // signal of executions in the current window
+	rate       float64
+	growth     float64 // corpus signal growth per hour
+	lastCorpus int
+	lastCheck  time.Time
+	alert      string
+}
+
+type coverWatchState struct {
+	Kernel    string                    `json:"kernel"`
+	Baselines map[string]*coverBaseline `json:"baselines"`
+}
+
+type coverBaseline struct {
+	SignalPerExec float64   `json:"signal_per_exec"`
+	Samples       int       `json:"samples"`
+	Updated       time.Time `json:"updated"`
+}
+
+const (
+	// Signal per execution is computed over windows of at least that many executions.
+	coverWatchWindow = 10000
+	// Baseline is trusted after that many windows.
+	coverWatchSamples = 10
+	// After that many samples the baseline becomes a moving average with weight 1/coverWatchHistory.
+	coverWatchHistory = 100
+)
+
+func newCoverWatchdog(file, kernel string, minSignal, dropFactor float64) *coverWatchdog {
+	cw := &coverWatchdog{
+		file:       file,
+		kernel:     kernel,
+		minSignal:  minSignal,
+		dropFactor: dropFactor,
+	}
+	if data, err := ioutil.ReadFile(file); err == nil {
+		if err := json.Unmarshal(data, &cw.state); err != nil {
+			log.Logf(0, "failed to parse %v: %v", file, err)
+		}
+	}
+	if cw.state.Baselines == nil {
+		cw.state.Baselines = make(map[string]*coverBaseline)
+	}
+	if cw.state.Kernel != kernel {
+		cw.prev = cw.state.Kernel
+		cw.state.Kernel = kernel
+		cw.save()
+	}
+	return cw
+}
+
+// add accounts executions reported by a fuzzer.
+func (cw *coverWatchdog) add(execs, signal uint64) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.execs += execs
+	cw.signal += signal
+}
+
+// check is called periodically, returns a new alert if coverage looks broken.
+func (cw *coverWatchdog) check(corpusSignal int, now time.Time) string {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if !cw.lastCheck.IsZero() {
+		cw.growth = float64(corpusSignal-cw.lastCorpus) / now.Sub(cw.lastCheck).Hours()
+	}
+	cw.lastCorpus = corpusSignal
+	cw.lastCheck = now
+	if cw.execs < coverWatchWindow {
+		return ""
+	}
+	cw.rate = float64(cw.signal) / float64(cw.execs)
+	cw.execs, cw.signal = 0, 0
+	alert := cw.diagnose(cw.rate)
+	raised := alert != "" && cw.alert == ""
+	cw.alert = alert
+	if alert != "" {
+		// Don't let a broken kernel pollute the baseline.
+		if raised {
+			return alert
+		}
+		return ""
+	}
+	base := cw.state.Baselines[cw.kernel]
+	if base == nil {
+		base = new(coverBaseline)
+		cw.state.Baselines[cw.kernel] = base
+	}
+	if base.Samples < coverWatchHistory {
+		base.Samples++
+	}
+	base.SignalPerExec += (cw.rate - base.SignalPerExec) / float64(base.Samples)
+	base.Updated = now
+	cw.save()
+	return ""
+}
+
+func (cw *coverWatchdog) diagnose(rate float64) string {
+	if cw.minSignal != 0 && rate < cw.minSignal {
+		return fmt.Sprintf("signal per execution %.1f is below the configured minimum %.1f",
+			rate, cw.minSignal)
+	}
+	if cw.dropFactor == 0 {
+		return ""
+	}
+	if base := cw.state.Baselines[cw.kernel]; base != nil && base.Samples >= coverWatchSamples {
+		if rate*cw.dropFactor < base.SignalPerExec {
+			return fmt.Sprintf("signal per execution dropped from %.1f to %.1f on the same kernel",
+				base.SignalPerExec, rate)
+		}
+		return ""
+	}
+	if base := cw.state.Baselines[cw.prev]; cw.prev != "" && base != nil && base.Samples >= coverWatchSamples {
+		if rate*cw.dropFactor < base.SignalPerExec {
+			return fmt.Sprintf("signal per execution dropped from %.1f to %.1f after kernel/image change",
+				base.SignalPerExec, rate)
+		}
+	}
+	return ""
+}
+
+// status returns the current alert (if any), signal per execution and corpus signal growth per hour.
+func (cw *coverWatchdog) status() (string, float64, float64) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.alert, cw.rate, cw.growth
+}
+
+func (cw *coverWatchdog) save() {
+	data, err := json.MarshalIndent(&cw.state, "", "\t")
+	if err != nil {
+		log.Fatalf("failed to marshal coverage baselines: %v", err)
+	}
+	if err := osutil.WriteFile(cw.file, data); err != nil {
+		log.Logf(0, "failed to write %v: %v", cw.file, err)
+	}
+}
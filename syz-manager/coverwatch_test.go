@@ -0,0 +1,109 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCoverWatchdog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-manager-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "coverwatch.json")
+	now := time.Now()
+	corpus := 1000
+	// window reports a window of executions with the given signal per execution.
+	window := func(cw *coverWatchdog, rate uint64) string {
+		cw.add(coverWatchWindow, coverWatchWindow*rate)
+		now = now.Add(time.Minute)
+		return cw.check(corpus, now)
+	}
+	cw := newCoverWatchdog(file, "kernel1", 0, 2)
+	for i := 0; i < 2*coverWatchSamples; i++ {
+		if i < coverWatchSamples {
+			corpus += 100
+		}
+		if alert := window(cw, 20); alert != "" {
+			t.Fatalf("window %v: got alert %q", i, alert)
+		}
+	}
+	// The corpus has converged, but signal per execution is stable, so this is not an alert.
+	if alert, rate, growth := cw.status(); alert != "" || rate != 20 || growth != 0 {
+		t.Fatalf("bad status of the converged corpus: %q, %v, %v", alert, rate, growth)
+	}
+	base := *cw.state.Baselines["kernel1"]
+	if base.SignalPerExec != 20 || base.Samples != 2*coverWatchSamples {
+		t.Fatalf("bad baseline: %+v", base)
+	}
+
+	// A drop on the same kernel is alerted once and does not affect the baseline.
+	alert := window(cw, 5)
+	if !strings.Contains(alert, "dropped from 20.0 to 5.0 on the same kernel") {
+		t.Fatalf("got alert %q", alert)
+	}
+	for i := 0; i < coverWatchSamples; i++ {
+		if alert := window(cw, 5); alert != "" {
+			t.Fatalf("got repeated alert %q", alert)
+		}
+	}
+	if alert, _, _ := cw.status(); alert == "" {
+		t.Fatalf("the alert is not raised")
+	}
+	if got := *cw.state.Baselines["kernel1"]; got.SignalPerExec != base.SignalPerExec ||
+		got.Samples != base.Samples {
+		t.Fatalf("baseline is updated while the alert is raised: %+v", got)
+	}
+	if alert := window(cw, 19); alert != "" {
+		t.Fatalf("got alert after recovery %q", alert)
+	}
+	if alert, _, _ := cw.status(); alert != "" {
+		t.Fatalf("the alert is not cleared after recovery: %q", alert)
+	}
+
+	// The baseline survives manager restart.
+	cw = newCoverWatchdog(file, "kernel1", 0, 2)
+	if got := cw.state.Baselines["kernel1"]; got == nil || got.Samples != base.Samples+1 {
+		t.Fatalf("baseline is not persisted: %+v", got)
+	}
+	if alert := window(cw, 5); !strings.Contains(alert, "on the same kernel") {
+		t.Fatalf("got alert %q after restart", alert)
+	}
+
+	// The new kernel has no baseline yet, so it's compared with the previous kernel.
+	cw = newCoverWatchdog(file, "kernel2", 0, 2)
+	alert = window(cw, 5)
+	if !strings.Contains(alert, "to 5.0 after kernel/image change") {
+		t.Fatalf("got alert %q after kernel change", alert)
+	}
+	if cw.state.Baselines["kernel2"] != nil {
+		t.Fatalf("broken kernel got a baseline")
+	}
+	// Windows with less than coverWatchWindow executions are not checked.
+	cw = newCoverWatchdog(file, "kernel2", 0, 2)
+	cw.add(coverWatchWindow-1, 0)
+	if alert := cw.check(corpus, now.Add(time.Minute)); alert != "" {
+		t.Fatalf("got alert on a partial window %q", alert)
+	}
+}
+
+func TestCoverWatchdogMinSignal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-manager-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cw := newCoverWatchdog(filepath.Join(dir, "coverwatch.json"), "kernel", 10, 0)
+	cw.add(coverWatchWindow, coverWatchWindow*5)
+	if alert := cw.check(0, time.Now()); !strings.Contains(alert, "below the configured minimum 10.0") {
+		t.Fatalf("got alert %q", alert)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/google/syzkaller/pkg/instance"
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/osutil"
+	"github.com/google/syzkaller/pkg/report"
+	"github.com/google/syzkaller/prog"
+	"github.com/google/syzkaller/vm"
+)
+
+// Single VM debug mode (-single-vm-debug) is meant for development of descriptions:
+// the manager boots one VM, deploys syz-execprog/syz-executor, tails the console with crash
+// detection and runs the program from -debug-prog every time the file changes.
+// Fuzzing, corpus, RPC and HTTP servers are not started. On a crash the report is printed
+// and the VM is kept alive for inspection (see the ssh command in the VM info) until interrupted.
+
+// The console is monitored in periods of that length between programs,
+// so that a silent idle VM is not detected as hanged.
+var debugIdlePeriod = time.Minute
+
+type debugVM struct {
+	cfg         *mgrconfig.Config
+	target      *prog.Target
+	reporter    report.Reporter
+	inst        *vm.Instance
+	progFile    string
+	execprogBin string
+	executorBin string
+}
+
+func runSingleVMDebug(cfg *mgrconfig.Config, target *prog.Target, progFile string) {
+	if cfg.Type == "none" {
+		log.Fatalf("-single-vm-debug is not supported for VM type none")
+	}
+	reporter, err := report.NewReporter(cfg)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	vmPool, err := vm.Create(cfg, true)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	osutil.HandleInterrupts(vm.Shutdown)
+	log.Logf(0, "booting VM...")
+	inst, err := vmPool.Create(0)
+	if err != nil {
+		log.Fatalf("failed to create instance: %v", err)
+	}
+	defer inst.Close()
+	if info, err := inst.Info(); err != nil {
+		log.Logf(0, "failed to get VM info: %v", err)
+	} else if len(info) != 0 {
+		log.Logf(0, "VM info:\n%s", info)
+	}
+	dbg := &debugVM{
+		cfg:      cfg,
+		target:   target,
+		reporter: reporter,
+		inst:     inst,
+		progFile: progFile,
+	}
+	if dbg.execprogBin, err = inst.Copy(cfg.SyzExecprogBin); err != nil {
+		log.Fatalf("%v", copyError(err))
+	}
+	if dbg.executorBin, err = inst.Copy(cfg.SyzExecutorBin); err != nil {
+		log.Fatalf("%v", copyError(err))
+	}
+	if progFile == "" {
+		log.Logf(0, "VM is ready, -debug-prog is not specified, only the console is monitored")
+	} else {
+		log.Logf(0, "VM is ready, programs from %v are executed every time the file changes", progFile)
+	}
+	rep := dbg.loop()
+	if rep == nil {
+		return
+	}
+	if err := reporter.Symbolize(rep); err != nil {
+		log.Crashf("failed to symbolize report: %v", err)
+	}
+	fmt.Printf("\nCRASH: %v\n\n%s\n", rep.Title, rep.Report)
+	log.Logf(0, "the VM is kept alive for inspection, press Ctrl-C to exit")
+	<-vm.Shutdown
+}
+
+// loop monitors the console and runs the program once the program file changes.
+// Returns the first detected crash, or nil on shutdown.
+func (dbg *debugVM) loop() *report.Report {
+	var lastMod time.Time
+	for {
+		done := make(chan bool)
+		changed := watchFile(dbg.progFile, lastMod, done)
+		outc, errc, err := dbg.inst.Run(debugIdlePeriod, changed, "sleep 1000000")
+		if err != nil {
+			log.Fatalf("failed to run command in VM: %v", err)
+		}
+		rep := dbg.inst.MonitorExecution(outc, errc, dbg.reporter, true)
+		close(done)
+		if rep != nil {
+			return rep
+		}
+		select {
+		case <-vm.Shutdown:
+			return nil
+		default:
+		}
+		if mod := fileModTime(dbg.progFile); !mod.Equal(lastMod) {
+			lastMod = mod
+			if rep := dbg.runProg(); rep != nil {
+				return rep
+			}
+		}
+	}
+}
+
+func (dbg *debugVM) runProg() *report.Report {
+	data, err := ioutil.ReadFile(dbg.progFile)
+	if err != nil {
+		log.Logf(0, "failed to read program: %v", err)
+		return nil
+	}
+	if _, err := dbg.target.Deserialize(data, prog.NonStrict); err != nil {
+		log.Logf(0, "failed to parse program: %v", err)
+		return nil
+	}
+	vmProgFile, err := dbg.inst.Copy(dbg.progFile)
+	if err != nil {
+		log.Logf(0, "%v", copyError(err))
+		return nil
+	}
+	cfg := dbg.cfg
+	cmd := instance.ExecprogCmd(dbg.execprogBin, dbg.executorBin, cfg.TargetOS, cfg.TargetArch,
+		cfg.Sandbox, false, true, true, 1, -1, 0, vmProgFile)
+	log.Logf(0, "executing %v", dbg.progFile)
+	outc, errc, err := dbg.inst.Run(5*time.Minute, nil, cmd)
+	if err != nil {
+		log.Logf(0, "failed to run program: %v", err)
+		return nil
+	}
+	rep := dbg.inst.MonitorExecution(outc, errc, dbg.reporter, true)
+	if rep == nil {
+		log.Logf(0, "program finished")
+	}
+	return rep
+}
+
+// watchFile returns a channel that becomes ready when modification time of the file is not mod,
+// the file is checked every second until done is closed.
+func watchFile(file string, mod time.Time, done <-chan bool) <-chan bool {
+	changed := make(chan bool)
+	if file == "" {
+		return changed
+	}
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if !fileModTime(file).Equal(mod) {
+				close(changed)
+				return
+			}
+		}
+	}()
+	return changed
+}
+
+// fileModTime returns modification time of the file, or zero time if the file does not exist.
+func fileModTime(file string) time.Time {
+	if file == "" {
+		return time.Time{}
+	}
+	st, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return st.ModTime()
+}
@@ -0,0 +1,43 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWatchFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "syz-manager-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	mod := fileModTime(f.Name())
+	if mod.IsZero() {
+		t.Fatalf("no modification time for an existing file")
+	}
+	done := make(chan bool)
+	defer close(done)
+	changed := watchFile(f.Name(), mod, done)
+	select {
+	case <-changed:
+		t.Fatalf("unchanged file is reported as changed")
+	case <-time.After(1500 * time.Millisecond):
+	}
+	if err := os.Chtimes(f.Name(), mod, mod.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("changed file is not reported")
+	}
+	if !fileModTime("").IsZero() || !fileModTime(f.Name()+".non-existent").IsZero() {
+		t.Fatalf("non-zero modification time for a missing file")
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/google/syzkaller/pkg/crashdir"
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/prog"
+)
+
+// Programs in corpus and reproducers are stored in text form and are re-interpreted
+// with the current syscall descriptions. When descriptions change (prog.Target.Revision),
+// some programs can't be parsed anymore and some silently change meaning
+// (e.g. a removed argument or a changed struct layout). We record the revision
+// programs were saved with and re-validate them when it changes.
+
+// descriptionsMeta is the corpus.db metadata key that holds revision of syscall descriptions.
+const descriptionsMeta = "descriptions"
+
+// progChanged checks if the serialized program data is still valid for target.
+// Returns an empty string if the program is unaffected, or a description of the problem.
+func progChanged(target *prog.Target, data []byte) string {
+	p, err := target.Deserialize(data, prog.NonStrict)
+	if err != nil {
+		return fmt.Sprintf("failed to parse: %v", err)
+	}
+	if !bytes.Equal(stripComments(p.Serialize()), stripComments(data)) {
+		return "changed meaning with new descriptions"
+	}
+	return ""
+}
+
+// stripComments removes comment lines (e.g. the options header of reproducers)
+// and empty lines that are not preserved by prog serialization.
+func stripComments(data []byte) []byte {
+	buf := new(bytes.Buffer)
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// revalidateRepros checks reproducers in crashdir that were saved/checked with different
+// descriptions and marks the affected ones as needing re-verification.
+func (mgr *Manager) revalidateRepros() {
+	types, err := crashdir.List(mgr.crashdir)
+	if err != nil {
+		log.Logf(0, "failed to list crashes: %v", err)
+		return
+	}
+	stale := 0
+	for _, typ := range types {
+		if !typ.HasRepro {
+			continue
+		}
+		id := typ.ID
+		repro := crashdir.ReadRepro(mgr.crashdir, id)
+		if repro == nil || repro.Descriptions == mgr.target.Revision {
+			continue
+		}
+		reason := progChanged(mgr.target, repro.Prog)
+		if reason != "" {
+			log.Logf(1, "reproducer %v: %v", id, reason)
+			stale++
+		}
+		if err := crashdir.MarkRepro(mgr.crashdir, id, mgr.target.Revision, reason); err != nil {
+			log.Logf(0, "failed to mark reproducer %v: %v", id, err)
+		}
+	}
+	if stale != 0 {
+		log.Logf(0, "%v reproducers need re-verification with new descriptions", stale)
+	}
+}
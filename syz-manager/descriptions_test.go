@@ -0,0 +1,41 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/syzkaller/prog"
+)
+
+func TestProgChanged(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		prog    string
+		changed bool
+	}{
+		{
+			prog: "# {Threaded:false Collide:false Repeat:false}\n" +
+				"r0 = openat(0xffffffffffffff9c, &(0x7f0000000000)='./file0\\x00', 0x0, 0x0)\n" +
+				"close(r0)\n",
+		},
+		{
+			// Missing arguments are filled in with defaults.
+			prog:    "close()\n",
+			changed: true,
+		},
+		{
+			prog:    "foo$bar(0x1)\n",
+			changed: true,
+		},
+	}
+	for i, test := range tests {
+		if reason := progChanged(target, []byte(test.prog)); (reason != "") != test.changed {
+			t.Errorf("#%v: changed=%q, want %v", i, reason, test.changed)
+		}
+	}
+}
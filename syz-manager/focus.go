@@ -0,0 +1,244 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/crashdir"
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/rpctype"
+	"github.com/google/syzkaller/prog"
+)
+
+// A focus (started from the crash page) temporarily biases fuzzing toward an unreproduced crash:
+// syscalls of the programs that were executing when the crash happened get their priorities
+// multiplied by the focus weight and faults are injected into them, for a limited time.
+// The focus is sent to fuzzers in poll results (see rpctype.RPCFocus). Only one focus can be
+// active at a time, crashes with the focus title during the focus window are counted as hits.
+
+const (
+	defaultFocusWeight   = 10
+	maxFocusWeight       = 1000
+	defaultFocusDuration = time.Hour
+	maxFocusDuration     = 24 * time.Hour
+	maxFocusHistory      = 10 // number of finished focuses shown in UI
+)
+
+type focusState struct {
+	mu      sync.Mutex
+	seq     int           // id of the next focus
+	active  *crashFocus   // nil if there is no active focus
+	history []*crashFocus // finished focuses, the most recent last
+}
+
+type crashFocus struct {
+	id       int
+	title    string
+	calls    []string
+	weight   int
+	fault    bool
+	duration time.Duration
+	start    time.Time
+	end      time.Time
+	hits     int // crashes with the focus title during the focus window
+	crashes  int // all crashes during the focus window
+}
+
+func newFocusState() *focusState {
+	return &focusState{}
+}
+
+// start activates the focus, unless another focus is still active.
+func (fs *focusState) start(f *crashFocus, now time.Time) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.expire(now)
+	if fs.active != nil {
+		return fmt.Errorf("focus on %q is active until %v", fs.active.title, fs.active.end.Format(time.RFC3339))
+	}
+	f.id = fs.seq
+	fs.seq++
+	f.start = now
+	f.end = now.Add(f.duration)
+	fs.active = f
+	log.Logf(0, "focus %v on %q: %v calls, weight %v, until %v",
+		f.id, f.title, len(f.calls), f.weight, f.end.Format(time.RFC3339))
+	return nil
+}
+
+// stop finishes the active focus before its deadline.
+func (fs *focusState) stop(now time.Time) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.active != nil && now.Before(fs.active.end) {
+		fs.active.end = now
+	}
+	fs.expire(now)
+}
+
+// expire moves the active focus to history once the focus window is over.
+func (fs *focusState) expire(now time.Time) {
+	f := fs.active
+	if f == nil || now.Before(f.end) {
+		return
+	}
+	log.Logf(0, "focus %v on %q finished: %v hits of %v crashes", f.id, f.title, f.hits, f.crashes)
+	fs.active = nil
+	fs.history = append(fs.history, f)
+	if len(fs.history) > maxFocusHistory {
+		fs.history = fs.history[len(fs.history)-maxFocusHistory:]
+	}
+}
+
+// rpc returns the active focus for fuzzers.
+func (fs *focusState) rpc(now time.Time) *rpctype.RPCFocus {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.expire(now)
+	f := fs.active
+	if f == nil {
+		return nil
+	}
+	return &rpctype.RPCFocus{
+		ID:     f.id,
+		Calls:  f.calls,
+		Weight: f.weight,
+		Fault:  f.fault,
+	}
+}
+
+// crash accounts a crash in the focus window, returns true if it's a hit.
+func (fs *focusState) crash(title string, now time.Time) bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.expire(now)
+	f := fs.active
+	if f == nil {
+		return false
+	}
+	f.crashes++
+	if title != f.title {
+		return false
+	}
+	f.hits++
+	log.Logf(0, "focus %v on %q: the crash happened again (%v hits)", f.id, f.title, f.hits)
+	return true
+}
+
+func (fs *focusState) status(now time.Time) (active *UIFocus, history []*UIFocus) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.expire(now)
+	if fs.active != nil {
+		active = fs.active.ui()
+	}
+	for i := len(fs.history) - 1; i >= 0; i-- {
+		history = append(history, fs.history[i].ui())
+	}
+	return
+}
+
+func (f *crashFocus) ui() *UIFocus {
+	return &UIFocus{
+		ID:      crashdir.ID(f.title),
+		Title:   f.title,
+		Calls:   f.calls,
+		Weight:  f.weight,
+		Fault:   f.fault,
+		Start:   f.start,
+		End:     f.end,
+		Hits:    f.hits,
+		Crashes: f.crashes,
+	}
+}
+
+// focusCalls returns names of syscalls of the programs that were executing
+// when the crashes of the type happened (the last program of each proc in crash logs).
+func focusCalls(target *prog.Target, dir string, typ *crashdir.Type) ([]string, error) {
+	calls := make(map[string]bool)
+	for _, crash := range typ.Crashes {
+		data, err := ioutil.ReadFile(filepath.Join(dir, typ.ID, crash.Log))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read crash log: %v", err)
+		}
+		last := make(map[int]*prog.LogEntry)
+		for _, ent := range target.ParseLog(data) {
+			last[ent.Proc] = ent
+		}
+		for _, ent := range last {
+			for _, c := range ent.P.Calls {
+				calls[c.Meta.Name] = true
+			}
+		}
+	}
+	if len(calls) == 0 {
+		return nil, fmt.Errorf("crash logs don't contain programs")
+	}
+	var res []string
+	for call := range calls {
+		res = append(res, call)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// httpFocus starts a focus on a crash (POST id=crash id, weight, duration e.g. "30m",
+// fault=1 to inject faults) or stops the active focus (POST stop=1).
+func (mgr *Manager) httpFocus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "focus requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.FormValue("stop") != "" {
+		mgr.focus.stop(time.Now())
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	f, err := mgr.parseFocus(r)
+	if err == nil {
+		err = mgr.focus.start(f, time.Now())
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, "/crash?id="+url.QueryEscape(r.FormValue("id")), http.StatusSeeOther)
+}
+
+func (mgr *Manager) parseFocus(r *http.Request) (*crashFocus, error) {
+	typ, err := crashdir.Read(mgr.crashdir, r.FormValue("id"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read crash info: %v", err)
+	}
+	f := &crashFocus{
+		title:    typ.Title,
+		weight:   defaultFocusWeight,
+		fault:    r.FormValue("fault") != "",
+		duration: defaultFocusDuration,
+	}
+	if str := r.FormValue("weight"); str != "" {
+		if f.weight, err = strconv.Atoi(str); err != nil || f.weight < 1 || f.weight > maxFocusWeight {
+			return nil, fmt.Errorf("bad weight %q (must be in [1, %v])", str, maxFocusWeight)
+		}
+	}
+	if str := r.FormValue("duration"); str != "" {
+		f.duration, err = time.ParseDuration(str)
+		if err != nil || f.duration <= 0 || f.duration > maxFocusDuration {
+			return nil, fmt.Errorf("bad duration %q (must be in (0, %v])", str, maxFocusDuration)
+		}
+	}
+	if f.calls, err = focusCalls(mgr.target, mgr.crashdir, typ); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
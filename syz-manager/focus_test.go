@@ -0,0 +1,119 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/crashdir"
+	"github.com/google/syzkaller/prog"
+)
+
+func TestFocus(t *testing.T) {
+	fs := newFocusState()
+	now := time.Now()
+	if fs.rpc(now) != nil {
+		t.Fatalf("got focus without starting one")
+	}
+	err := fs.start(&crashFocus{
+		title:    "WARNING in foo",
+		calls:    []string{"close", "openat"},
+		weight:   10,
+		fault:    true,
+		duration: time.Hour,
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Concurrent focuses are rejected.
+	if err := fs.start(&crashFocus{title: "WARNING in bar", duration: time.Hour}, now); err == nil {
+		t.Fatalf("started the second focus")
+	}
+	rf := fs.rpc(now.Add(time.Minute))
+	if rf == nil || rf.ID != 0 || rf.Weight != 10 || !rf.Fault ||
+		!reflect.DeepEqual(rf.Calls, []string{"close", "openat"}) {
+		t.Fatalf("bad focus: %+v", rf)
+	}
+	if fs.crash("WARNING in bar", now.Add(time.Minute)) {
+		t.Fatalf("another crash is a hit")
+	}
+	if !fs.crash("WARNING in foo", now.Add(2*time.Minute)) {
+		t.Fatalf("the focus crash is not a hit")
+	}
+	active, history := fs.status(now.Add(3 * time.Minute))
+	if active == nil || active.Hits != 1 || active.Crashes != 2 || len(history) != 0 {
+		t.Fatalf("bad status: %+v, %+v", active, history)
+	}
+	// The focus is finished after its window, crashes are not counted anymore.
+	if fs.crash("WARNING in foo", now.Add(time.Hour)) {
+		t.Fatalf("a crash after the focus window is a hit")
+	}
+	if fs.rpc(now.Add(time.Hour)) != nil {
+		t.Fatalf("the focus is not finished")
+	}
+	active, history = fs.status(now.Add(time.Hour))
+	if active != nil || len(history) != 1 || history[0].Hits != 1 || history[0].Crashes != 2 {
+		t.Fatalf("bad status: %+v, %+v", active, history)
+	}
+	if err := summaryTemplate.Execute(ioutil.Discard, &UISummaryData{FocusHistory: history}); err != nil {
+		t.Fatal(err)
+	}
+	// A new focus can be started after the previous one has finished, and stopped early.
+	if err := fs.start(&crashFocus{title: "WARNING in bar", duration: time.Hour}, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if rf := fs.rpc(now.Add(time.Hour)); rf == nil || rf.ID != 1 {
+		t.Fatalf("bad focus: %+v", rf)
+	}
+	fs.stop(now.Add(time.Hour + time.Minute))
+	if fs.rpc(now.Add(time.Hour+time.Minute)) != nil {
+		t.Fatalf("the focus is not stopped")
+	}
+}
+
+func TestFocusCalls(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "syz-manager-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	const title = "WARNING in foo"
+	// Only the last program of each proc counts.
+	log := []byte(`
+executing program 0:
+getpid()
+executing program 1:
+getuid()
+executing program 0:
+r0 = openat(0xffffffffffffff9c, &(0x7f0000000000)='./file0\x00', 0x0, 0x0)
+close(r0)
+WARNING: CPU: 0 PID: 1 at foo+0x10/0x20
+`)
+	if _, _, err := crashdir.SaveCrash(dir, title, &crashdir.Occurrence{Log: log}); err != nil {
+		t.Fatal(err)
+	}
+	typ, err := crashdir.Read(dir, crashdir.ID(title))
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls, err := focusCalls(target, dir, typ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"close", "getuid", "openat"}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("want calls %v, got %v", want, calls)
+	}
+	crash := makeUICrashType(typ, nil, time.Now())
+	if err := crashTemplate.Execute(ioutil.Discard, crash); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/db"
+	"github.com/google/syzkaller/pkg/hash"
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/rpctype"
+	"github.com/google/syzkaller/prog"
+)
+
+// Managers that fuzz the same kernel on different architectures can share their corpora
+// (foreign_corpora config). Programs of the other target are translated to our target
+// (calls that we don't have are dropped) and triaged as candidates. A foreign corpus is loaded
+// from the corpus database of the other manager at startup, and/or new corpus inputs are periodically
+// fetched from the other manager (/api/inputs). Translation statistics are shown on /foreign.
+
+// foreignInputs is the format of /api/inputs.
+type foreignInputs struct {
+	Target string   `json:"target"` // "os/arch" of the programs
+	Start  int64    `json:"start"`  // start time of the manager, the sequence restarts with the manager
+	Seq    int      `json:"seq"`    // pass as since to fetch newer inputs
+	More   bool     `json:"more"`
+	Progs  []string `json:"progs"`
+}
+
+type foreignCorpus struct {
+	cfg    mgrconfig.ForeignCorpus
+	target *prog.Target
+
+	mu         sync.Mutex
+	progs      int            // programs received
+	translated int            // programs added as candidates
+	failed     int            // programs that failed to translate
+	disabled   int            // translated programs with syscalls that are disabled here
+	dropped    map[string]int // call name -> number of dropped calls
+	lastSync   time.Time
+	lastErr    string // last fetch error or translation failure
+
+	start int64 // of the other manager, see foreignInputs
+	seq   int
+}
+
+const (
+	foreignInputsBatch  = 1000
+	foreignFetchTimeout = time.Minute
+)
+
+func newForeignCorpora(cfg *mgrconfig.Config) ([]*foreignCorpus, error) {
+	var corpora []*foreignCorpus
+	for _, fcfg := range cfg.ForeignCorpora {
+		parts := strings.Split(fcfg.Target, "/")
+		target, err := prog.GetTarget(parts[0], parts[len(parts)-1])
+		if err != nil {
+			return nil, err
+		}
+		corpora = append(corpora, &foreignCorpus{
+			cfg:     fcfg,
+			target:  target,
+			dropped: make(map[string]int),
+		})
+	}
+	return corpora, nil
+}
+
+// httpInputs exports corpus inputs added since the given sequence number for managers of other targets.
+func (mgr *Manager) httpInputs(w http.ResponseWriter, r *http.Request) {
+	since, _ := strconv.Atoi(r.FormValue("since"))
+	start, _ := strconv.ParseInt(r.FormValue("start"), 10, 64)
+	res := &foreignInputs{
+		Target: mgr.target.OS + "/" + mgr.target.Arch,
+		Start:  mgr.startTime.UnixNano(),
+	}
+	mgr.mu.Lock()
+	if start != res.Start || since < 0 || since > len(mgr.inputLog) {
+		since = 0
+	}
+	res.Seq = since + foreignInputsBatch
+	if res.Seq > len(mgr.inputLog) {
+		res.Seq = len(mgr.inputLog)
+	}
+	res.More = res.Seq < len(mgr.inputLog)
+	for _, sig := range mgr.inputLog[since:res.Seq] {
+		if inp, ok := mgr.corpus[sig]; ok {
+			res.Progs = append(res.Progs, string(inp.Prog))
+		}
+	}
+	mgr.mu.Unlock()
+	data, err := json.Marshal(res)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to marshal inputs: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// translate translates programs of the foreign corpus and returns the ones that can be used as candidates.
+func (mgr *Manager) translate(fc *foreignCorpus, progs [][]byte, syscalls map[int]bool) [][]byte {
+	var res [][]byte
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.progs += len(progs)
+	for _, data := range progs {
+		p, err := fc.target.Deserialize(data, prog.NonStrict)
+		var dropped []string
+		if err == nil {
+			p, dropped, err = mgr.target.Translate(p)
+		}
+		for _, call := range dropped {
+			fc.dropped[call]++
+		}
+		mgr.stats.foreignDroppedCalls.add(len(dropped))
+		if err != nil {
+			fc.failed++
+			fc.lastErr = fmt.Sprintf("failed to translate program: %v", err)
+			mgr.stats.foreignRecvProgFail.inc()
+			continue
+		}
+		enabled := true
+		for _, c := range p.Calls {
+			if !syscalls[c.Meta.ID] {
+				enabled = false
+				break
+			}
+		}
+		if !enabled {
+			fc.disabled++
+			continue
+		}
+		fc.translated++
+		mgr.stats.foreignRecvProg.inc()
+		res = append(res, p.Serialize())
+	}
+	return res
+}
+
+// loadForeignCorpora translates corpus databases of managers of other targets into candidates.
+func (mgr *Manager) loadForeignCorpora(syscalls map[int]bool) {
+	for _, fc := range mgr.foreign {
+		if fc.cfg.Corpus == "" {
+			continue
+		}
+		records, err := db.ReadRecords(fc.cfg.Corpus)
+		if err != nil {
+			log.Logf(0, "failed to read %v corpus: %v", fc.cfg.Target, err)
+			fc.mu.Lock()
+			fc.lastErr = err.Error()
+			fc.mu.Unlock()
+			continue
+		}
+		keys := make([]string, 0, len(records))
+		for key := range records {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		progs := make([][]byte, 0, len(keys))
+		for _, key := range keys {
+			progs = append(progs, records[key].Val)
+		}
+		added := 0
+		for _, data := range mgr.translate(fc, progs, syscalls) {
+			if _, ok := mgr.corpusDB.Records[hash.String(data)]; ok {
+				continue
+			}
+			mgr.candidates = append(mgr.candidates, rpctype.RPCCandidate{
+				Prog:   data,
+				Origin: rpctype.OriginForeign,
+			})
+			added++
+		}
+		log.Logf(0, "%-24v: %v (%v programs, %v)", fc.cfg.Target+" corpus", added, len(progs), fc.summary())
+	}
+}
+
+func (mgr *Manager) foreignSyncLoop(fc *foreignCorpus) {
+	syscalls := make(map[int]bool)
+	for _, id := range mgr.checkResult.EnabledCalls[mgr.cfg.Sandbox] {
+		syscalls[id] = true
+	}
+	for {
+		time.Sleep(time.Minute)
+		if err := mgr.foreignSync(fc, syscalls); err != nil {
+			log.Logf(0, "%v inputs sync with %v failed: %v", fc.cfg.Target, fc.cfg.Manager, err)
+			fc.mu.Lock()
+			fc.lastErr = err.Error()
+			fc.mu.Unlock()
+		}
+	}
+}
+
+// foreignSync fetches new inputs from the manager of the other target and adds them as candidates.
+func (mgr *Manager) foreignSync(fc *foreignCorpus, syscalls map[int]bool) error {
+	for {
+		res, err := fetchForeignInputs(fc.cfg.Manager, fc.start, fc.seq)
+		if err != nil {
+			return err
+		}
+		if want := fc.target.OS + "/" + fc.target.Arch; res.Target != want {
+			return fmt.Errorf("the manager fuzzes %v, not %v", res.Target, want)
+		}
+		progs := make([][]byte, len(res.Progs))
+		for i, data := range res.Progs {
+			progs[i] = []byte(data)
+		}
+		candidates := mgr.translate(fc, progs, syscalls)
+		mgr.addNewCandidates(candidates, rpctype.OriginForeign)
+		fc.mu.Lock()
+		fc.start, fc.seq = res.Start, res.Seq
+		fc.lastSync = time.Now()
+		summary := fc.summary()
+		fc.mu.Unlock()
+		log.Logf(0, "%v inputs sync: recv %v, candidates %v (%v)",
+			fc.cfg.Target, len(progs), len(candidates), summary)
+		if !res.More {
+			return nil
+		}
+	}
+}
+
+func fetchForeignInputs(manager string, start int64, since int) (*foreignInputs, error) {
+	addr := manager
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	args := url.Values{}
+	args.Add("start", fmt.Sprint(start))
+	args.Add("since", fmt.Sprint(since))
+	client := &http.Client{Timeout: foreignFetchTimeout}
+	resp, err := client.Get(strings.TrimSuffix(addr, "/") + "/api/inputs?" + args.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(data)))
+	}
+	res := new(foreignInputs)
+	if err := json.Unmarshal(data, res); err != nil {
+		return nil, fmt.Errorf("failed to parse inputs: %v", err)
+	}
+	return res, nil
+}
+
+// summary returns cumulative statistics of the corpus, fc.mu must be held.
+func (fc *foreignCorpus) summary() string {
+	dropped := 0
+	for _, n := range fc.dropped {
+		dropped += n
+	}
+	return fmt.Sprintf("translated %v, failed %v, disabled %v, dropped calls %v",
+		fc.translated, fc.failed, fc.disabled, dropped)
+}
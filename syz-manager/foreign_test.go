@@ -0,0 +1,149 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/db"
+	"github.com/google/syzkaller/pkg/hash"
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/rpctype"
+	"github.com/google/syzkaller/prog"
+)
+
+func TestForeignCorpora(t *testing.T) {
+	amd64, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	arm64, err := prog.GetTarget("linux", "arm64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "syz-manager-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	progs := []string{
+		// open does not exist on arm64.
+		"r0 = open(&(0x7f0000000000)='./file0\\x00', 0x0, 0x0)\nclose(r0)\n",
+		"r0 = openat(0xffffffffffffff9c, &(0x7f0000000000)='./file1\\x00', 0x0, 0x0)\nclose(r0)\n",
+		// Nothing is left after translation.
+		"arch_prctl$ARCH_GET_FS(0x1003, &(0x7f0000000100))\n",
+		// getpid is disabled below.
+		"getpid()\n",
+		"foo$bar()\n",
+	}
+
+	// The amd64 manager exports its corpus inputs.
+	other := &Manager{
+		target:    amd64,
+		startTime: time.Now(),
+		corpus:    make(map[string]rpctype.RPCInput),
+	}
+	var records []db.Record
+	for _, data := range progs {
+		sig := hash.String([]byte(data))
+		other.corpus[sig] = rpctype.RPCInput{Prog: []byte(data)}
+		other.inputLog = append(other.inputLog, sig)
+		records = append(records, db.Record{Val: []byte(data)})
+	}
+	server := httptest.NewServer(http.HandlerFunc(other.httpInputs))
+	defer server.Close()
+	w := httptest.NewRecorder()
+	other.httpInputs(w, httptest.NewRequest("GET", fmt.Sprintf("/api/inputs?since=3&start=%v",
+		other.startTime.UnixNano()), nil))
+	inputs := new(foreignInputs)
+	if err := json.Unmarshal(w.Body.Bytes(), inputs); err != nil {
+		t.Fatal(err)
+	}
+	if inputs.Target != "linux/amd64" || inputs.Seq != len(progs) || inputs.More || len(inputs.Progs) != 2 {
+		t.Fatalf("bad inputs: %+v", inputs)
+	}
+	corpusFile := filepath.Join(dir, "corpus.db")
+	if err := db.Create(corpusFile, 0, records); err != nil {
+		t.Fatal(err)
+	}
+
+	newManager := func(fcfg mgrconfig.ForeignCorpus) *Manager {
+		foreign, err := newForeignCorpora(&mgrconfig.Config{ForeignCorpora: []mgrconfig.ForeignCorpus{fcfg}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		corpusDB, err := db.Open(filepath.Join(dir, fmt.Sprintf("corpus%v.db", time.Now().UnixNano())))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Manager{
+			target:   arm64,
+			stats:    new(Stats),
+			corpusDB: corpusDB,
+			foreign:  foreign,
+		}
+	}
+	syscalls := make(map[int]bool)
+	for _, c := range arm64.Syscalls {
+		syscalls[c.ID] = c.Name != "getpid"
+	}
+	checkCandidates := func(mgr *Manager) {
+		want := []string{
+			"close(0xffffffffffffffff)\n",
+			"r0 = openat(0xffffffffffffff9c, &(0x7f0000000000)='./file1\\x00', 0x0, 0x0)\nclose(r0)\n",
+		}
+		if len(mgr.candidates) != len(want) {
+			t.Fatalf("got %v candidates, want %v", len(mgr.candidates), len(want))
+		}
+		got := make(map[string]bool)
+		for _, cand := range mgr.candidates {
+			got[string(cand.Prog)] = true
+		}
+		for _, data := range want {
+			if !got[data] {
+				t.Errorf("missing candidate:\n%s", data)
+			}
+		}
+		fc := mgr.foreign[0]
+		if fc.progs != len(progs) || fc.translated != 2 || fc.failed != 2 || fc.disabled != 1 ||
+			fc.dropped["open"] != 1 || fc.dropped["arch_prctl$ARCH_GET_FS"] != 1 {
+			t.Errorf("bad stats: %+v", fc)
+		}
+		if mgr.stats.foreignRecvProg.get() != 2 || mgr.stats.foreignRecvProgFail.get() != 2 ||
+			mgr.stats.foreignDroppedCalls.get() != 2 {
+			t.Errorf("bad manager stats: %v", mgr.stats.all())
+		}
+	}
+
+	mgr := newManager(mgrconfig.ForeignCorpus{Target: "linux/amd64", Corpus: corpusFile})
+	mgr.loadForeignCorpora(syscalls)
+	checkCandidates(mgr)
+
+	mgr = newManager(mgrconfig.ForeignCorpus{Target: "linux/amd64", Manager: server.URL})
+	if err := mgr.foreignSync(mgr.foreign[0], syscalls); err != nil {
+		t.Fatal(err)
+	}
+	checkCandidates(mgr)
+	// Only new inputs are fetched on the next sync.
+	mgr.candidates = nil
+	if err := mgr.foreignSync(mgr.foreign[0], syscalls); err != nil {
+		t.Fatal(err)
+	}
+	if len(mgr.candidates) != 0 || mgr.foreign[0].seq != len(progs) {
+		t.Errorf("got %v candidates on resync, seq %v", len(mgr.candidates), mgr.foreign[0].seq)
+	}
+
+	mgr = newManager(mgrconfig.ForeignCorpus{Target: "linux/386", Manager: server.URL})
+	if err := mgr.foreignSync(mgr.foreign[0], syscalls); err == nil {
+		t.Errorf("synced inputs of a wrong target")
+	}
+}
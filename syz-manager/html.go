@@ -5,6 +5,7 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -20,15 +21,17 @@ import (
 	"time"
 
 	"github.com/google/syzkaller/pkg/cover"
+	"github.com/google/syzkaller/pkg/crashdir"
+	"github.com/google/syzkaller/pkg/db"
 	"github.com/google/syzkaller/pkg/html"
 	"github.com/google/syzkaller/pkg/log"
-	"github.com/google/syzkaller/pkg/osutil"
 	"github.com/google/syzkaller/prog"
 )
 
 func (mgr *Manager) initHTTP() {
 	http.HandleFunc("/", mgr.httpSummary)
 	http.HandleFunc("/syscalls", mgr.httpSyscalls)
+	http.HandleFunc("/syscall-health", mgr.httpSyscallHealth)
 	http.HandleFunc("/corpus", mgr.httpCorpus)
 	http.HandleFunc("/crash", mgr.httpCrash)
 	http.HandleFunc("/cover", mgr.httpCover)
@@ -37,6 +40,18 @@ func (mgr *Manager) initHTTP() {
 	http.HandleFunc("/report", mgr.httpReport)
 	http.HandleFunc("/rawcover", mgr.httpRawCover)
 	http.HandleFunc("/input", mgr.httpInput)
+	http.HandleFunc("/corpus-program", mgr.httpCorpusProgram)
+	http.HandleFunc("/replay", mgr.httpReplay)
+	http.HandleFunc("/focus", mgr.httpFocus)
+	http.HandleFunc("/vms", mgr.httpVMs)
+	http.HandleFunc("/metrics", mgr.httpMetrics)
+	http.HandleFunc("/api/import", mgr.httpImport)
+	http.HandleFunc("/api/repros", mgr.httpRepros)
+	http.HandleFunc("/api/inputs", mgr.httpInputs)
+	http.HandleFunc("/api/crash", mgr.httpCrashAPI)
+	http.HandleFunc("/foreign", mgr.httpForeign)
+	http.HandleFunc("/profiles", mgr.httpProfiles)
+	http.HandleFunc("/profile", mgr.httpProfile)
 	// Browsers like to request this, without special handler this goes to / handler.
 	http.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {})
 
@@ -53,10 +68,18 @@ func (mgr *Manager) initHTTP() {
 
 func (mgr *Manager) httpSummary(w http.ResponseWriter, r *http.Request) {
 	data := &UISummaryData{
-		Name:  mgr.cfg.Name,
-		Log:   log.CachedLogOutput(),
-		Stats: mgr.collectStats(),
+		Name:         mgr.cfg.Name,
+		Log:          log.CachedLogOutput(),
+		LogCoalesced: log.CoalescedMessages(),
+		Stats:        mgr.collectStats(),
 	}
+	data.Alert, _, _ = mgr.coverWatch.status()
+	data.RegressedCalls = mgr.callHealth.regressions()
+	data.Suppressions = mgr.suppressions.status()
+	data.Focus, data.FocusHistory = mgr.focus.status(time.Now())
+	mgr.mu.Lock()
+	data.DescriptionsChange = mgr.descriptionsChange
+	mgr.mu.Unlock()
 
 	var err error
 	if data.Crashes, err = mgr.collectCrashes(mgr.cfg.Workdir); err != nil {
@@ -92,6 +115,150 @@ func (mgr *Manager) httpSyscalls(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (mgr *Manager) httpSyscallHealth(w http.ResponseWriter, r *http.Request) {
+	data := &UISyscallHealthData{
+		Name: mgr.cfg.Name,
+	}
+	for _, st := range mgr.callHealth.status() {
+		ui := &UISyscallHealth{
+			Call:      st.Call,
+			Errno:     st.Errno,
+			Regressed: st.Regressed,
+			Since:     st.Since,
+		}
+		if st.Rate >= 0 {
+			ui.Rate = fmt.Sprintf("%.0f%%", st.Rate*100)
+		}
+		if st.Baseline != nil {
+			ui.Baseline = fmt.Sprintf("%.0f%%", st.Baseline.SuccessRate*100)
+			ui.Revision = st.Baseline.Revision
+		}
+		for _, ex := range st.Examples {
+			ui.Examples = append(ui.Examples, UISyscallExample{Errno: ex.Errno, Prog: string(ex.Prog)})
+		}
+		data.Calls = append(data.Calls, ui)
+	}
+	if err := syscallHealthTemplate.Execute(w, data); err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+func (mgr *Manager) httpVMs(w http.ResponseWriter, r *http.Request) {
+	vms, median := mgr.vmStats.status(time.Now())
+	data := &UIVMsData{
+		Name:       mgr.cfg.Name,
+		MedianRate: fmt.Sprintf("%.1f", median),
+		SlowFactor: mgr.cfg.SlowVMFactor,
+	}
+	for _, vm := range vms {
+		ui := &UIVM{
+			Name:          vm.Name,
+			Active:        vm.Active,
+			Slow:          vm.Slow,
+			Rate:          fmt.Sprintf("%.1f", vm.Rate),
+			Procs:         vm.Procs,
+			Execs:         vm.Execs,
+			Inputs:        vm.Inputs,
+			Restarts:      vm.Restarts,
+			LastCrash:     vm.LastCrash,
+			LastCrashTime: vm.LastCrashTime,
+			Degraded:      vm.Degraded,
+		}
+		if vm.Benchmark != 0 {
+			ui.Benchmark = vm.Benchmark.String()
+		}
+		var index int
+		if _, err := fmt.Sscanf(vm.Name, "vm-%d", &index); err == nil && mgr.vmPool != nil {
+			ui.Manual = mgr.vmPool.TakenOver(index)
+		}
+		for _, rate := range vm.ProcRates {
+			ui.ProcRates = append(ui.ProcRates, fmt.Sprintf("%.1f", rate))
+		}
+		data.VMs = append(data.VMs, ui)
+	}
+	data.BootWarnings = mgr.bootWarnings()
+	if err := vmsTemplate.Execute(w, data); err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// bootWarnings returns kernel oopses printed during boot, the most recent first.
+func (mgr *Manager) bootWarnings() []*UIBootWarning {
+	types, err := crashdir.List(mgr.bootWarnDir)
+	if err != nil {
+		return nil
+	}
+	var warnings []*UIBootWarning
+	for _, typ := range types {
+		typ, err := crashdir.Read(mgr.bootWarnDir, typ.ID)
+		if err != nil || len(typ.Crashes) == 0 {
+			continue
+		}
+		last := typ.Crashes[0]
+		ui := &UIBootWarning{
+			Title:  typ.Title,
+			Builds: len(typ.Crashes),
+			Tag:    last.Tag,
+			Time:   last.Time,
+			Log:    filepath.Join("boot-warnings", typ.ID, last.Log),
+		}
+		if last.Report != "" {
+			ui.Report = filepath.Join("boot-warnings", typ.ID, last.Report)
+		}
+		warnings = append(warnings, ui)
+	}
+	sort.Slice(warnings, func(i, j int) bool {
+		return warnings[i].Time.After(warnings[j].Time)
+	})
+	return warnings
+}
+
+func (mgr *Manager) httpForeign(w http.ResponseWriter, r *http.Request) {
+	data := &UIForeignData{
+		Name: mgr.cfg.Name,
+	}
+	for _, fc := range mgr.foreign {
+		fc.mu.Lock()
+		ui := &UIForeignCorpus{
+			Target:     fc.cfg.Target,
+			Corpus:     fc.cfg.Corpus,
+			Manager:    fc.cfg.Manager,
+			Progs:      fc.progs,
+			Translated: fc.translated,
+			Failed:     fc.failed,
+			Disabled:   fc.disabled,
+			LastSync:   fc.lastSync,
+			LastError:  fc.lastErr,
+		}
+		for call, count := range fc.dropped {
+			ui.Dropped = append(ui.Dropped, UIDroppedCall{Name: call, Count: count})
+		}
+		fc.mu.Unlock()
+		sort.Slice(ui.Dropped, func(i, j int) bool {
+			if ui.Dropped[i].Count != ui.Dropped[j].Count {
+				return ui.Dropped[i].Count > ui.Dropped[j].Count
+			}
+			return ui.Dropped[i].Name < ui.Dropped[j].Name
+		})
+		data.Corpora = append(data.Corpora, ui)
+	}
+	if err := foreignTemplate.Execute(w, data); err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+func (mgr *Manager) httpMetrics(w http.ResponseWriter, r *http.Request) {
+	vms, _ := mgr.vmStats.status(time.Now())
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w, vms)
+}
+
 type CallCov struct {
 	count int
 	cov   cover.Cover
@@ -109,6 +276,49 @@ func (mgr *Manager) collectStats() []UIStat {
 		{Name: "cover", Value: fmt.Sprint(len(mgr.corpusCover)), Link: "/cover"},
 		{Name: "signal", Value: fmt.Sprint(mgr.corpusSignal.Len())},
 	}
+	if _, signalPerExec, growth := mgr.coverWatch.status(); signalPerExec != 0 {
+		stats = append(stats,
+			UIStat{Name: "signal/exec", Value: fmt.Sprintf("%.1f", signalPerExec)},
+			UIStat{Name: "signal growth", Value: fmt.Sprintf("%.0f/hour", growth)},
+		)
+	}
+	if vms, _ := mgr.vmStats.status(time.Now()); len(vms) != 0 {
+		active, slow := 0, 0
+		for _, vm := range vms {
+			if vm.Active {
+				active++
+			}
+			if vm.Slow {
+				slow++
+			}
+		}
+		value := fmt.Sprintf("%v active", active)
+		if slow != 0 {
+			value += fmt.Sprintf(", %v slow", slow)
+		}
+		stats = append(stats, UIStat{Name: "VMs", Value: value, Link: "/vms"})
+	}
+	if len(mgr.foreign) != 0 {
+		stats = append(stats, UIStat{
+			Name:  "foreign corpora",
+			Value: fmt.Sprint(len(mgr.foreign)),
+			Link:  "/foreign",
+		})
+	}
+	if mgr.cfg.SlowProfiles.Tracer != "" {
+		stats = append(stats, UIStat{
+			Name:  "slow profiles",
+			Value: fmt.Sprint(mgr.stats.slowProfiles.get()),
+			Link:  "/profiles",
+		})
+	}
+	if regressed := mgr.callHealth.regressions(); regressed != 0 {
+		stats = append(stats, UIStat{
+			Name:  "regressed syscalls",
+			Value: fmt.Sprint(regressed),
+			Link:  "/syscall-health",
+		})
+	}
 	if mgr.checkResult != nil {
 		stats = append(stats, UIStat{
 			Name:  "syscalls",
@@ -124,6 +334,7 @@ func (mgr *Manager) collectStats() []UIStat {
 
 	intStats := convertStats(mgr.stats.all(), secs)
 	intStats = append(intStats, convertStats(mgr.fuzzerStats, secs)...)
+	intStats = append(intStats, convertStats(mgr.poolStats(), secs)...)
 	sort.Slice(intStats, func(i, j int) bool {
 		return intStats[i].Name < intStats[j].Name
 	})
@@ -166,17 +377,60 @@ func (mgr *Manager) collectSyscallInfo() map[string]*CallCov {
 
 func (mgr *Manager) httpCrash(w http.ResponseWriter, r *http.Request) {
 	crashID := r.FormValue("id")
-	crash := readCrash(mgr.cfg.Workdir, crashID, nil, mgr.startTime, true)
-	if crash == nil {
-		http.Error(w, fmt.Sprintf("failed to read crash info"), http.StatusInternalServerError)
+	typ, err := crashdir.Read(mgr.crashdir, crashID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read crash info: %v", err), http.StatusInternalServerError)
 		return
 	}
+	crash := makeUICrashType(typ, nil, mgr.startTime)
+	crash.Focus, _ = mgr.focus.status(time.Now())
+	index := -1
+	if idx := r.FormValue("index"); idx != "" {
+		if index, err = strconv.Atoi(idx); err != nil {
+			http.Error(w, fmt.Sprintf("bad crash index %q", idx), http.StatusBadRequest)
+			return
+		}
+	}
+	for i, c := range typ.Crashes {
+		// Render the requested occurrence, or the most recent one with a report.
+		if crash.Report == nil && (index == -1 || index == c.Index) {
+			crash.Report = readUIReport(filepath.Join(mgr.crashdir, typ.ID), c, mgr.cfg.KernelSourceURL)
+		}
+		if c.Diagnosis == "" {
+			continue
+		}
+		if data, err := ioutil.ReadFile(filepath.Join(mgr.crashdir, typ.ID, c.Diagnosis)); err == nil {
+			crash.Crashes[i].Diagnosis = string(data)
+		}
+	}
 	if err := crashTemplate.Execute(w, crash); err != nil {
 		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
 		return
 	}
 }
 
+// apiCrash is the crash info returned by /api/crash.
+type apiCrash struct {
+	ID     string            `json:"id"`
+	Title  string            `json:"title"`
+	Builds []*crashdir.Build `json:"builds"`
+}
+
+func (mgr *Manager) httpCrashAPI(w http.ResponseWriter, r *http.Request) {
+	typ, err := crashdir.Read(mgr.crashdir, r.FormValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read crash info: %v", err), http.StatusNotFound)
+		return
+	}
+	data, err := json.MarshalIndent(&apiCrash{ID: typ.ID, Title: typ.Title, Builds: typ.Builds}, "", "\t")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to marshal crash info: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
 func (mgr *Manager) httpCorpus(w http.ResponseWriter, r *http.Request) {
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
@@ -328,7 +582,8 @@ func (mgr *Manager) httpPrio(w http.ResponseWriter, r *http.Request) {
 
 func (mgr *Manager) httpFile(w http.ResponseWriter, r *http.Request) {
 	file := filepath.Clean(r.FormValue("name"))
-	if !strings.HasPrefix(file, "crashes/") && !strings.HasPrefix(file, "corpus/") {
+	if !strings.HasPrefix(file, "crashes/") && !strings.HasPrefix(file, "corpus/") &&
+		!strings.HasPrefix(file, "boot-warnings/") {
 		http.Error(w, "oh, oh, oh!", http.StatusInternalServerError)
 		return
 	}
@@ -355,32 +610,54 @@ func (mgr *Manager) httpInput(w http.ResponseWriter, r *http.Request) {
 	w.Write(inp.Prog)
 }
 
+func (mgr *Manager) httpCorpusProgram(w http.ResponseWriter, r *http.Request) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	data := &UICorpusProgram{Name: mgr.cfg.Name}
+	if sig := strings.TrimSpace(r.FormValue("sig")); sig != "" {
+		var err error
+		if data, err = mgr.collectCorpusProgram(sig); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+	if err := corpusProgramTemplate.Execute(w, data); err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
 func (mgr *Manager) httpReport(w http.ResponseWriter, r *http.Request) {
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
 
 	crashID := r.FormValue("id")
-	desc, err := ioutil.ReadFile(filepath.Join(mgr.crashdir, crashID, "description"))
+	typ, err := crashdir.Read(mgr.crashdir, crashID)
 	if err != nil {
-		http.Error(w, "failed to read description file", http.StatusInternalServerError)
+		http.Error(w, "failed to read crash", http.StatusInternalServerError)
 		return
 	}
-	tag, _ := ioutil.ReadFile(filepath.Join(mgr.crashdir, crashID, "repro.tag"))
-	prog, _ := ioutil.ReadFile(filepath.Join(mgr.crashdir, crashID, "repro.prog"))
-	cprog, _ := ioutil.ReadFile(filepath.Join(mgr.crashdir, crashID, "repro.cprog"))
-	rep, _ := ioutil.ReadFile(filepath.Join(mgr.crashdir, crashID, "repro.report"))
+	var tag, prog, cprog, rep []byte
+	stale := ""
+	if repro := crashdir.ReadRepro(mgr.crashdir, crashID); repro != nil {
+		tag, prog, cprog, rep = []byte(repro.Tag), repro.Prog, repro.CProg, repro.Report
+		stale = repro.Stale
+	}
 
 	commitDesc := ""
 	if len(tag) != 0 {
 		commitDesc = fmt.Sprintf(" on commit %s.", trimNewLines(tag))
 	}
-	fmt.Fprintf(w, "Syzkaller hit '%s' bug%s.\n\n", trimNewLines(desc), commitDesc)
+	fmt.Fprintf(w, "Syzkaller hit '%s' bug%s.\n\n", typ.Title, commitDesc)
 	if len(rep) != 0 {
 		fmt.Fprintf(w, "%s\n\n", rep)
 	}
 	if len(prog) == 0 && len(cprog) == 0 {
 		fmt.Fprintf(w, "The bug is not reproducible.\n")
 	} else {
+		if stale != "" {
+			fmt.Fprintf(w, "The reproducer needs re-verification: %v.\n\n", stale)
+		}
 		fmt.Fprintf(w, "Syzkaller reproducer:\n%s\n\n", prog)
 		if len(cprog) != 0 {
 			fmt.Fprintf(w, "C reproducer:\n%s\n\n", cprog)
@@ -429,17 +706,13 @@ func (mgr *Manager) collectCrashes(workdir string) ([]*UICrashType, error) {
 	mgr.reproRequest <- reproReply
 	repros := <-reproReply
 
-	crashdir := filepath.Join(workdir, "crashes")
-	dirs, err := osutil.ListDir(crashdir)
+	types, err := crashdir.List(filepath.Join(workdir, "crashes"))
 	if err != nil {
 		return nil, err
 	}
 	var crashTypes []*UICrashType
-	for _, dir := range dirs {
-		crash := readCrash(workdir, dir, repros, mgr.startTime, false)
-		if crash != nil {
-			crashTypes = append(crashTypes, crash)
-		}
+	for _, typ := range types {
+		crashTypes = append(crashTypes, makeUICrashType(typ, repros, mgr.startTime))
 	}
 	sort.Slice(crashTypes, func(i, j int) bool {
 		return strings.ToLower(crashTypes[i].Description) < strings.ToLower(crashTypes[j].Description)
@@ -447,85 +720,46 @@ func (mgr *Manager) collectCrashes(workdir string) ([]*UICrashType, error) {
 	return crashTypes, nil
 }
 
-func readCrash(workdir, dir string, repros map[string]bool, start time.Time, full bool) *UICrashType {
-	if len(dir) != 40 {
-		return nil
-	}
-	crashdir := filepath.Join(workdir, "crashes")
-	descFile, err := os.Open(filepath.Join(crashdir, dir, "description"))
-	if err != nil {
-		return nil
-	}
-	defer descFile.Close()
-	descBytes, err := ioutil.ReadAll(descFile)
-	if err != nil || len(descBytes) == 0 {
-		return nil
-	}
-	desc := string(trimNewLines(descBytes))
-	stat, err := descFile.Stat()
-	if err != nil {
-		return nil
-	}
-	modTime := stat.ModTime()
-	descFile.Close()
-
-	files, err := osutil.ListDir(filepath.Join(crashdir, dir))
-	if err != nil {
-		return nil
-	}
+func makeUICrashType(typ *crashdir.Type, repros map[string]bool, start time.Time) *UICrashType {
 	var crashes []*UICrash
-	reproAttempts := 0
-	hasRepro, hasCRepro := false, false
-	reports := make(map[string]bool)
-	for _, f := range files {
-		if strings.HasPrefix(f, "log") {
-			index, err := strconv.ParseUint(f[3:], 10, 64)
-			if err == nil {
-				crashes = append(crashes, &UICrash{
-					Index: int(index),
-				})
-			}
-		} else if strings.HasPrefix(f, "report") {
-			reports[f] = true
-		} else if f == "repro.prog" {
-			hasRepro = true
-		} else if f == "repro.cprog" {
-			hasCRepro = true
-		} else if f == "repro.report" {
-		} else if f == "repro0" || f == "repro1" || f == "repro2" {
-			reproAttempts++
-		}
-	}
-
-	if full {
-		for _, crash := range crashes {
-			index := strconv.Itoa(crash.Index)
-			crash.Log = filepath.Join("crashes", dir, "log"+index)
-			if stat, err := os.Stat(filepath.Join(workdir, crash.Log)); err == nil {
-				crash.Time = stat.ModTime()
-				crash.Active = crash.Time.After(start)
-			}
-			tag, _ := ioutil.ReadFile(filepath.Join(crashdir, dir, "tag"+index))
-			crash.Tag = string(tag)
-			reportFile := filepath.Join("crashes", dir, "report"+index)
-			if osutil.IsExist(filepath.Join(workdir, reportFile)) {
-				crash.Report = reportFile
+	for _, crash := range typ.Crashes {
+		ui := &UICrash{
+			Index:  crash.Index,
+			Time:   crash.Time,
+			Active: crash.Time.After(start),
+			Log:    filepath.Join("crashes", typ.ID, crash.Log),
+			Tag:    crash.Tag,
+			Origin: crash.Origin,
+		}
+		if crash.Report != "" {
+			ui.Report = filepath.Join("crashes", typ.ID, crash.Report)
+		}
+		if crash.Ftrace != "" {
+			ui.Ftrace = filepath.Join("crashes", typ.ID, crash.Ftrace)
+		}
+		if crash.Meta != nil {
+			ui.Kernel = crash.Meta.KernelTag
+			ui.MemState = crash.Meta.MemState
+			if crash.Meta.SeededFrom != "" {
+				ui.Origin = strings.TrimSpace(ui.Origin + " seeded from " + crash.Meta.SeededFrom)
 			}
 		}
-		sort.Slice(crashes, func(i, j int) bool {
-			return crashes[i].Time.After(crashes[j].Time)
-		})
+		crashes = append(crashes, ui)
+	}
+	triaged := reproStatus(typ.HasRepro, typ.HasCRepro, repros[typ.Title],
+		typ.ReproAttempts >= crashdir.MaxReproAttempts)
+	if typ.ReproStale {
+		triaged += " (needs re-verification)"
 	}
-
-	triaged := reproStatus(hasRepro, hasCRepro, repros[desc], reproAttempts >= maxReproAttempts)
 	return &UICrashType{
-		Description: desc,
-		LastTime:    modTime,
-		Active:      modTime.After(start),
-		ID:          dir,
+		Description: typ.Title,
+		LastTime:    typ.LastTime,
+		Active:      typ.LastTime.After(start),
+		ID:          typ.ID,
 		Count:       len(crashes),
 		Triaged:     triaged,
 		Crashes:     crashes,
+		Builds:      typ.Builds,
 	}
 }
 
@@ -552,10 +786,36 @@ func trimNewLines(data []byte) []byte {
 }
 
 type UISummaryData struct {
-	Name    string
-	Stats   []UIStat
-	Crashes []*UICrashType
-	Log     string
+	Name               string
+	Alert              string
+	RegressedCalls     int // syscalls with collapsed success rate
+	DescriptionsChange string
+	Stats              []UIStat
+	Crashes            []*UICrashType
+	Suppressions       []UIScopedSuppression
+	Focus              *UIFocus   // the active focus (if any)
+	FocusHistory       []*UIFocus // finished focuses, the most recent first
+	Log                string
+	LogCoalesced       uint64 // identical log messages that were coalesced by rate limiting
+}
+
+type UIFocus struct {
+	ID      string // crash id
+	Title   string
+	Calls   []string
+	Weight  int
+	Fault   bool
+	Start   time.Time
+	End     time.Time
+	Hits    int // crashes with the focus title during the focus window
+	Crashes int // all crashes during the focus window
+}
+
+type UIScopedSuppression struct {
+	Title     string
+	Instances string
+	Kernel    string
+	Hits      uint64
 }
 
 type UISyscallsData struct {
@@ -563,6 +823,27 @@ type UISyscallsData struct {
 	Calls []UICallType
 }
 
+type UISyscallHealthData struct {
+	Name  string
+	Calls []*UISyscallHealth
+}
+
+type UISyscallHealth struct {
+	Call      string
+	Rate      string // success rate over the last window
+	Errno     int    // the most frequent errno over the last window
+	Baseline  string // baseline success rate
+	Revision  string // syzkaller/descriptions revision of the baseline
+	Regressed bool
+	Since     time.Time
+	Examples  []UISyscallExample
+}
+
+type UISyscallExample struct {
+	Errno int
+	Prog  string
+}
+
 type UICrashType struct {
 	Description string
 	LastTime    time.Time
@@ -571,6 +852,9 @@ type UICrashType struct {
 	Count       int
 	Triaged     string
 	Crashes     []*UICrash
+	Builds      []*crashdir.Build // kernel builds the crash was seen on (only on the crash page)
+	Report      *UIReport         // the rendered report of one of the occurrences (only on the crash page)
+	Focus       *UIFocus          // the active focus (on any crash)
 }
 
 type UICrash struct {
@@ -580,6 +864,14 @@ type UICrash struct {
 	Log    string
 	Report string
 	Tag    string
+	Origin string
+	Kernel string // tag of the kernel if the VM pool runs several kernels
+	// Guest memory state at the time of the crash (see crash_mem_state).
+	MemState string
+	// Output of Diagnose (e.g. sysrq dumps) printed after the crash.
+	Diagnosis string
+	// Kernel ftrace buffer at the time of the crash (see crash_ftrace).
+	Ftrace string
 }
 
 type UIStat struct {
@@ -588,6 +880,85 @@ type UIStat struct {
 	Link  string
 }
 
+type UIVMsData struct {
+	Name         string
+	MedianRate   string
+	SlowFactor   int
+	VMs          []*UIVM
+	BootWarnings []*UIBootWarning
+}
+
+// UIBootWarning is a kernel oops printed during boot (a kernel health issue, not a fuzzing crash).
+type UIBootWarning struct {
+	Title  string
+	Builds int    // number of kernel builds the warning was seen on
+	Tag    string // the last build the warning was seen on
+	Time   time.Time
+	Log    string
+	Report string
+}
+
+type UIVM struct {
+	Name          string
+	Active        bool
+	Slow          bool
+	Rate          string
+	ProcRates     []string
+	Procs         int
+	Execs         uint64
+	Inputs        uint64
+	Restarts      int
+	LastCrash     string
+	LastCrashTime time.Time
+	Benchmark     string
+	Degraded      bool
+	Manual        bool // the machine is taken over by a human, fuzzing is paused
+}
+
+type UIForeignData struct {
+	Name    string
+	Corpora []*UIForeignCorpus
+}
+
+type UIForeignCorpus struct {
+	Target     string
+	Corpus     string
+	Manager    string
+	Progs      int
+	Translated int
+	Failed     int
+	Disabled   int
+	LastSync   time.Time
+	LastError  string
+	Dropped    []UIDroppedCall
+}
+
+type UIDroppedCall struct {
+	Name  string
+	Count int
+}
+
+type UIProfilesData struct {
+	Name     string
+	Profiles []UIProfile
+	Disabled []UIProfileDisabled
+}
+
+type UIProfile struct {
+	ID       int
+	Time     time.Time
+	VM       string
+	Short    string
+	Duration time.Duration
+	Tracer   string
+	Size     int
+}
+
+type UIProfileDisabled struct {
+	VM     string
+	Reason string
+}
+
 type UICallType struct {
 	Name   string
 	Inputs int
@@ -615,6 +986,18 @@ var summaryTemplate = html.CreatePage(`
 <body>
 <b>{{.Name }} syzkaller</b>
 <br>
+{{if .Alert}}
+<div class="bad">Coverage alert: {{.Alert}}</div>
+<br>
+{{end}}
+{{if .DescriptionsChange}}
+<div class="bad">{{.DescriptionsChange}}</div>
+<br>
+{{end}}
+{{if .RegressedCalls}}
+<div class="bad">Syscall health alert: success rate of {{.RegressedCalls}} syscalls collapsed after syzkaller/descriptions update, see <a href="/syscall-health">syscall health</a></div>
+<br>
+{{end}}
 
 <table class="list_table">
 	<caption>Stats:</caption>
@@ -654,7 +1037,71 @@ var summaryTemplate = html.CreatePage(`
 	{{end}}
 </table>
 
+{{if or .Focus .FocusHistory}}
+<table class="list_table">
+	<caption>Focus:</caption>
+	<tr>
+		<th>Crash</th>
+		<th>Calls</th>
+		<th>Weight</th>
+		<th>Fault injection</th>
+		<th>Start</th>
+		<th>End</th>
+		<th>Hits</th>
+		<th>Crashes</th>
+	</tr>
+	{{if .Focus}}
+	{{template "focus" .Focus}}
+	{{end}}
+	{{range $f := $.FocusHistory}}
+	{{template "focus" $f}}
+	{{end}}
+</table>
+{{if .Focus}}
+<form method="post" action="/focus">
+	<input type="hidden" name="stop" value="1">
+	<input type="submit" value="Stop focus">
+</form>
+{{end}}
+{{end}}
+
+{{define "focus"}}
+	<tr>
+		<td class="title"><a href="/crash?id={{.ID}}">{{.Title}}</a></td>
+		<td>{{len .Calls}}</td>
+		<td>{{.Weight}}</td>
+		<td>{{.Fault}}</td>
+		<td class="time">{{formatTime .Start}}</td>
+		<td class="time">{{formatTime .End}}</td>
+		<td class="stat {{if not .Hits}}inactive{{end}}">{{.Hits}}</td>
+		<td class="stat">{{.Crashes}}</td>
+	</tr>
+{{end}}
+
+{{if .Suppressions}}
+<table class="list_table">
+	<caption>Scoped suppressions:</caption>
+	<tr>
+		<th>Title</th>
+		<th>Instances</th>
+		<th>Kernel</th>
+		<th>Hits</th>
+	</tr>
+	{{range $s := $.Suppressions}}
+	<tr>
+		<td class="title">{{$s.Title}}</td>
+		<td>{{if $s.Instances}}{{$s.Instances}}{{else}}all{{end}}</td>
+		<td>{{if $s.Kernel}}{{$s.Kernel}}{{else}}any{{end}}</td>
+		<td class="stat {{if not $s.Hits}}inactive{{end}}">{{$s.Hits}}</td>
+	</tr>
+	{{end}}
+</table>
+{{end}}
+
 <b>Log:</b>
+{{if .LogCoalesced}}
+({{.LogCoalesced}} repeated messages were coalesced, see log_rate_limit)
+{{end}}
 <br>
 <textarea id="log_textarea" readonly rows="20" wrap=off>
 {{.Log}}
@@ -695,6 +1142,201 @@ var syscallsTemplate = html.CreatePage(`
 </body></html>
 `)
 
+var syscallHealthTemplate = html.CreatePage(`
+<!doctype html>
+<html>
+<head>
+	<title>{{.Name }} syzkaller syscall health</title>
+	{{HEAD}}
+</head>
+<body>
+
+<table class="list_table">
+	<caption>Syscall health (the worst regressions first):</caption>
+	<tr>
+		<th><a onclick="return sortTable(this, 'Syscall', textSort)" href="#">Syscall</a></th>
+		<th><a onclick="return sortTable(this, 'Success', numSort)" href="#">Success</a></th>
+		<th><a onclick="return sortTable(this, 'Baseline', numSort)" href="#">Baseline</a></th>
+		<th>Top errno</th>
+		<th>Baseline revision</th>
+		<th><a onclick="return sortTable(this, 'Regressed since', textSort, true)" href="#">Regressed since</a></th>
+	</tr>
+	{{range $c := $.Calls}}
+	<tr>
+		<td class="{{if $c.Regressed}}bad{{end}}">{{$c.Call}}</td>
+		<td class="stat">{{$c.Rate}}</td>
+		<td class="stat">{{$c.Baseline}}</td>
+		<td class="stat">{{if $c.Errno}}{{$c.Errno}}{{end}}</td>
+		<td class="tag">{{$c.Revision}}</td>
+		<td class="time">{{if $c.Regressed}}{{formatTime $c.Since}}{{end}}</td>
+	</tr>
+	{{end}}
+</table>
+
+{{range $c := $.Calls}}
+{{if $c.Regressed}}
+<b>{{$c.Call}} example failing programs:</b>
+{{range $ex := $c.Examples}}
+<pre>errno {{$ex.Errno}}:
+{{$ex.Prog}}</pre>
+{{end}}
+{{end}}
+{{end}}
+</body></html>
+`)
+
+var vmsTemplate = html.CreatePage(`
+<!doctype html>
+<html>
+<head>
+	<title>{{.Name }} syzkaller VMs</title>
+	{{HEAD}}
+</head>
+<body>
+
+<table class="list_table">
+	<caption>VMs (median exec rate {{.MedianRate}}/sec{{if .SlowFactor}}, {{.SlowFactor}}x lower is slow{{end}}):</caption>
+	<tr>
+		<th><a onclick="return sortTable(this, 'Name', textSort)" href="#">Name</a></th>
+		<th><a onclick="return sortTable(this, 'Exec/sec', numSort)" href="#">Exec/sec</a></th>
+		<th><a onclick="return sortTable(this, 'Procs', numSort)" href="#">Procs</a></th>
+		<th>Per-proc exec/sec</th>
+		<th><a onclick="return sortTable(this, 'Execs', numSort)" href="#">Execs</a></th>
+		<th><a onclick="return sortTable(this, 'Inputs', numSort)" href="#">Inputs</a></th>
+		<th><a onclick="return sortTable(this, 'Restarts', numSort)" href="#">Restarts</a></th>
+		<th>Last crash</th>
+		<th><a onclick="return sortTable(this, 'Last crash time', textSort, true)" href="#">Last crash time</a></th>
+		<th>Benchmark</th>
+	</tr>
+	{{range $vm := $.VMs}}
+	<tr>
+		<td class="{{if or $vm.Slow $vm.Degraded}}bad{{else if not $vm.Active}}inactive{{end}}">{{$vm.Name}}{{if $vm.Slow}} (slow){{end}}{{if $vm.Degraded}} (degraded){{end}}{{if $vm.Manual}} (manual){{end}}</td>
+		<td class="stat {{if not $vm.Active}}inactive{{end}}">{{$vm.Rate}}</td>
+		<td class="stat">{{if $vm.Procs}}{{$vm.Procs}}{{end}}</td>
+		<td class="{{if not $vm.Active}}inactive{{end}}">{{range $r := $vm.ProcRates}}{{$r}} {{end}}</td>
+		<td class="stat">{{$vm.Execs}}</td>
+		<td class="stat">{{$vm.Inputs}}</td>
+		<td class="stat">{{$vm.Restarts}}</td>
+		<td class="title">{{$vm.LastCrash}}</td>
+		<td class="time">{{formatTime $vm.LastCrashTime}}</td>
+		<td class="{{if $vm.Degraded}}bad{{end}}">{{$vm.Benchmark}}</td>
+	</tr>
+	{{end}}
+</table>
+
+{{if $.BootWarnings}}
+<table class="list_table">
+	<caption>Kernel health issues (oopses during boot, not reproduced):</caption>
+	<tr>
+		<th>Title</th>
+		<th>Builds</th>
+		<th>Last build</th>
+		<th>Last time</th>
+		<th>Log</th>
+		<th>Report</th>
+	</tr>
+	{{range $w := $.BootWarnings}}
+	<tr>
+		<td class="title">{{$w.Title}}</td>
+		<td class="stat">{{$w.Builds}}</td>
+		<td class="tag">{{$w.Tag}}</td>
+		<td class="time">{{formatTime $w.Time}}</td>
+		<td><a href="/file?name={{$w.Log}}">log</a></td>
+		<td>{{if $w.Report}}<a href="/file?name={{$w.Report}}">report</a>{{end}}</td>
+	</tr>
+	{{end}}
+</table>
+{{end}}
+</body></html>
+`)
+
+var foreignTemplate = html.CreatePage(`
+<!doctype html>
+<html>
+<head>
+	<title>{{.Name }} syzkaller foreign corpora</title>
+	{{HEAD}}
+</head>
+<body>
+
+{{range $fc := $.Corpora}}
+<table class="list_table">
+	<caption>{{$fc.Target}} corpus{{if $fc.Corpus}} {{$fc.Corpus}}{{end}}{{if $fc.Manager}} from {{$fc.Manager}}{{end}}:</caption>
+	<tr><td>received programs</td><td class="stat">{{$fc.Progs}}</td></tr>
+	<tr><td>translated</td><td class="stat">{{$fc.Translated}}</td></tr>
+	<tr><td>failed to translate</td><td class="stat">{{$fc.Failed}}</td></tr>
+	<tr><td>with disabled syscalls</td><td class="stat">{{$fc.Disabled}}</td></tr>
+	<tr><td>last sync</td><td class="time">{{formatTime $fc.LastSync}}</td></tr>
+	<tr><td>last error</td><td>{{$fc.LastError}}</td></tr>
+</table>
+<br>
+<table class="list_table">
+	<caption>Dropped calls:</caption>
+	<tr>
+		<th>Call</th>
+		<th>Count</th>
+	</tr>
+	{{range $c := $fc.Dropped}}
+	<tr>
+		<td>{{$c.Name}}</td>
+		<td class="stat">{{$c.Count}}</td>
+	</tr>
+	{{end}}
+</table>
+<br>
+{{end}}
+</body></html>
+`)
+
+var profilesTemplate = html.CreatePage(`
+<!doctype html>
+<html>
+<head>
+	<title>{{.Name }} syzkaller slow program profiles</title>
+	{{HEAD}}
+</head>
+<body>
+
+<table class="list_table">
+	<caption>Slow program profiles (last {{len $.Profiles}}):</caption>
+	<tr>
+		<th>Time</th>
+		<th>VM</th>
+		<th>Duration</th>
+		<th>Tracer</th>
+		<th>Size</th>
+		<th>Program</th>
+	</tr>
+	{{range $p := $.Profiles}}
+	<tr>
+		<td class="time">{{formatTime $p.Time}}</td>
+		<td>{{$p.VM}}</td>
+		<td class="stat">{{$p.Duration}}</td>
+		<td>{{$p.Tracer}}</td>
+		<td class="stat">{{$p.Size}}</td>
+		<td><a href="/profile?id={{$p.ID}}">{{if $p.Short}}{{$p.Short}}{{else}}program{{end}}</a></td>
+	</tr>
+	{{end}}
+</table>
+{{if $.Disabled}}
+<br>
+<table class="list_table">
+	<caption>Profiling disabled:</caption>
+	<tr>
+		<th>VM</th>
+		<th>Reason</th>
+	</tr>
+	{{range $d := $.Disabled}}
+	<tr>
+		<td>{{$d.VM}}</td>
+		<td>{{$d.Reason}}</td>
+	</tr>
+	{{end}}
+</table>
+{{end}}
+</body></html>
+`)
+
 var crashTemplate = html.CreatePage(`
 <!doctype html>
 <html>
@@ -709,6 +1351,43 @@ var crashTemplate = html.CreatePage(`
 Report: <a href="/report?id={{.ID}}">{{.Triaged}}</a>
 {{end}}
 
+{{if .Focus}}
+<p>
+Focus on <a href="/crash?id={{.Focus.ID}}">{{.Focus.Title}}</a> is active until {{formatTime .Focus.End}}:
+{{.Focus.Hits}} hits of {{.Focus.Crashes}} crashes, calls: {{range $c := .Focus.Calls}}{{$c}} {{end}}
+</p>
+{{else}}
+<form method="post" action="/focus">
+	Focus fuzzing on this crash:
+	<input type="hidden" name="id" value="{{.ID}}">
+	weight <input type="text" name="weight" value="10" size="4">
+	duration <input type="text" name="duration" value="1h" size="4">
+	<label><input type="checkbox" name="fault" value="1" checked> inject faults</label>
+	<input type="submit" value="Focus">
+</form>
+{{end}}
+
+{{if .Builds}}
+<table class="list_table">
+	<caption>Builds (<a href="/api/crash?id={{.ID}}">json</a>):</caption>
+	<tr>
+		<th>Build</th>
+		<th>First seen</th>
+		<th>Last seen</th>
+		<th>Count</th>
+	</tr>
+	{{range $b := .Builds}}
+	<tr>
+		<td class="tag" title="{{$b.ID}}">{{$b.ID}}</td>
+		<td class="time">{{formatTime $b.FirstSeen}}</td>
+		<td class="time">{{formatTime $b.LastSeen}}</td>
+		<td>{{$b.Count}}</td>
+	</tr>
+	{{end}}
+</table>
+<br>
+{{end}}
+
 <table class="list_table">
 	<tr>
 		<th>#</th>
@@ -716,21 +1395,67 @@ Report: <a href="/report?id={{.ID}}">{{.Triaged}}</a>
 		<th>Report</th>
 		<th>Time</th>
 		<th>Tag</th>
+		<th>Origin</th>
+		<th>Kernel</th>
+		<th>Memory</th>
+		<th>Diagnosis</th>
 	</tr>
 	{{range $c := $.Crashes}}
 	<tr>
 		<td>{{$c.Index}}</td>
-		<td><a href="/file?name={{$c.Log}}">log</a></td>
+		<td>
+			<a href="/file?name={{$c.Log}}">log</a>
+			{{if $c.Ftrace}}
+				<a href="/file?name={{$c.Ftrace}}">ftrace</a>
+			{{end}}
+		</td>
 		<td>
 			{{if $c.Report}}
-				<a href="/file?name={{$c.Report}}">report</a></td>
+				<a href="/crash?id={{$.ID}}&index={{$c.Index}}#report">report</a>
+				<a href="/file?name={{$c.Report}}">raw</a>
 			{{end}}
 		</td>
 		<td class="time {{if not $c.Active}}inactive{{end}}">{{formatTime $c.Time}}</td>
 		<td class="tag {{if not $c.Active}}inactive{{end}}" title="{{$c.Tag}}">{{formatShortHash $c.Tag}}</td>
+		<td>{{$c.Origin}}</td>
+		<td>{{$c.Kernel}}</td>
+		<td>
+			{{if $c.MemState}}
+				<details><summary>mem state</summary><pre>{{$c.MemState}}</pre></details>
+			{{end}}
+		</td>
+		<td>
+			{{if $c.Diagnosis}}
+				<details><summary>diagnosis</summary><pre>{{$c.Diagnosis}}</pre></details>
+			{{end}}
+		</td>
 	</tr>
 	{{end}}
 </table>
+
+{{with $r := .Report}}
+<div id="report" class="crash_report">
+<b>Report of occurrence #{{$r.Index}} ({{formatTime $r.Time}}, <a href="/file?name={{$r.File}}">raw</a>):</b>
+{{range $b := $r.Blocks}}
+{{if $b.Repeated}}<details><summary>same stack as above ({{len $b.Lines}} frames)</summary>{{end}}
+<pre>{{range $l := $b.Lines}}{{if $l.Frame}}<span class="frame{{if $l.Guilty}} guilty{{end}}">{{$l.Text}}{{if $l.Link}}<a href="{{$l.Link}}">{{$l.Location}}</a>{{else}}{{$l.Location}}{{end}}{{$l.Suffix}}</span>{{else if $l.Oops}}<span class="oops">{{$l.Text}}</span>{{else}}{{$l.Text}}{{end}}
+{{end}}</pre>
+{{if $b.Repeated}}</details>{{end}}
+{{end}}
+</div>
+{{if $r.Diagnosis}}
+<div class="crash_section">
+<b>Diagnosis (printed after the crash, not a part of the report):</b>
+<pre>{{$r.Diagnosis}}</pre>
+</div>
+{{end}}
+{{if $r.Info}}
+<div class="crash_section">
+<b>Machine info:</b>
+<pre>{{$r.Info}}</pre>
+</div>
+{{end}}
+{{end}}
 </body></html>
 `)
 
@@ -748,17 +1473,198 @@ var corpusTemplate = html.CreatePage(`
 	<tr>
 		<th>Coverage</th>
 		<th>Program</th>
+		<th>Provenance</th>
 	</tr>
 	{{range $inp := $.Inputs}}
 	<tr>
 		<td><a href='/cover?input={{$inp.Sig}}'>{{$inp.Cover}}</a></td>
 		<td><a href="/input?sig={{$inp.Sig}}">{{$inp.Short}}</a></td>
+		<td><a href="/corpus-program?sig={{$inp.Sig}}">provenance</a></td>
 	</tr>
 	{{end}}
 </table>
 </body></html>
 `)
 
+type UICorpusProgram struct {
+	Name        string
+	Sig         string
+	InCorpus    bool
+	Prog        string
+	Calls       []string
+	Call        string // the call that gives new signal
+	Signal      int
+	Cover       int
+	UniqueCover int // coverage that no other corpus program gives
+	Provenance  []*UIProvenance
+}
+
+type UIProvenance struct {
+	Sig      string
+	InCorpus bool
+	*db.Provenance
+}
+
+var corpusProgramTemplate = html.CreatePage(`
+<!doctype html>
+<html>
+<head>
+	<title>{{.Name }} syzkaller corpus program</title>
+	{{HEAD}}
+</head>
+<body>
+
+<form action="/corpus-program">
+	Program hash: <input name="sig" size="45" value="{{$.Sig}}"> <input type="submit" value="Find">
+</form>
+<br>
+
+{{if $.Sig}}
+<table class="list_table">
+	<caption>Program {{$.Sig}}:</caption>
+	<tr><td>in corpus</td><td>{{$.InCorpus}}</td></tr>
+	{{if $.InCorpus}}
+	<tr><td>new signal in call</td><td>{{$.Call}}</td></tr>
+	<tr><td>signal</td><td class="stat">{{$.Signal}}</td></tr>
+	<tr><td>coverage</td><td class="stat"><a href='/cover?input={{$.Sig}}'>{{$.Cover}}</a></td></tr>
+	<tr><td>unique coverage</td><td class="stat">{{$.UniqueCover}}</td></tr>
+	{{end}}
+	<tr><td>syscalls</td><td>{{range $c := $.Calls}}<a href='/corpus?call={{$c}}'>{{$c}}</a> {{end}}</td></tr>
+</table>
+{{if $.InCorpus}}
+<form action="/replay" method="post">
+	<input type="hidden" name="sig" value="{{$.Sig}}">
+	<input type="submit" value="Replay and diff coverage"> <a href='/replay?sig={{$.Sig}}'>last replay</a>
+</form>
+{{end}}
+<br>
+<table class="list_table">
+	<caption>Provenance:</caption>
+	<tr>
+		<th>Program</th>
+		<th>Origin</th>
+		<th>Added</th>
+		<th>Call</th>
+		<th>Signal</th>
+		<th>New signal</th>
+		<th>Replaced</th>
+	</tr>
+	{{range $p := $.Provenance}}
+	<tr>
+		<td><a href='/corpus-program?sig={{$p.Sig}}'>{{$p.Sig}}</a>{{if not $p.InCorpus}} (deleted){{end}}</td>
+		<td>{{$p.Origin}}</td>
+		<td class="time">{{formatTime $p.Time}}</td>
+		<td>{{$p.Call}}</td>
+		<td class="stat">{{$p.Signal}}</td>
+		<td class="stat">{{$p.NewSignal}}</td>
+		<td>{{$p.Replaced}}</td>
+	</tr>
+	{{end}}
+</table>
+{{if $.Prog}}
+<pre>{{$.Prog}}</pre>
+{{end}}
+{{end}}
+</body></html>
+`)
+
+type UIReplay struct {
+	Name      string
+	Sig       string
+	Build     string // kernel build (tag) the program was replayed on
+	ID        int
+	Status    string
+	Error     string
+	VM        string
+	Requested time.Time
+	Finished  time.Time
+	Call      string        // the call that gave new signal when the program was added to corpus
+	Diff      *UIReplayDiff // set when the replay is finished
+}
+
+type UIReplayDiff struct {
+	Signal         int // recorded signal of the call
+	ReplaySignal   int
+	LostSignal     int // recorded signal that the replay did not give
+	Cover          int // recorded coverage of the call
+	ReplayCover    int
+	New            []*UIReplayFile // PCs covered by the replay, but not recorded
+	Lost           []*UIReplayFile // recorded PCs not covered by the replay
+	SymbolizeError string
+}
+
+type UIReplayFile struct {
+	File  string // empty for PCs that can't be symbolized
+	PCs   int
+	Lines []string // func:line, or PCs that can't be symbolized
+}
+
+var replayTemplate = html.CreatePage(`
+<!doctype html>
+<html>
+<head>
+	<title>{{.Name }} syzkaller replay</title>
+	{{HEAD}}
+	{{if or (eq $.Status "pending") (eq $.Status "running")}}<meta http-equiv="refresh" content="10">{{end}}
+</head>
+<body>
+
+<table class="list_table">
+	<caption>Replay of <a href='/corpus-program?sig={{$.Sig}}'>{{$.Sig}}</a>:</caption>
+	<tr><td>kernel build</td><td>{{$.Build}}</td></tr>
+	<tr><td>status</td><td>{{$.Status}}{{if $.Error}}: {{$.Error}}{{end}}</td></tr>
+	{{if $.VM}}<tr><td>VM</td><td>{{$.VM}}</td></tr>{{end}}
+	{{if not $.Requested.IsZero}}<tr><td>requested</td><td class="time">{{formatTime $.Requested}}</td></tr>{{end}}
+	{{if not $.Finished.IsZero}}<tr><td>finished</td><td class="time">{{formatTime $.Finished}}</td></tr>{{end}}
+	{{if $.Call}}<tr><td>new signal in call</td><td>{{$.Call}}</td></tr>{{end}}
+	{{with $.Diff}}
+	<tr><td>signal (recorded/replay/lost)</td><td class="stat">{{.Signal}} / {{.ReplaySignal}} / {{.LostSignal}}</td></tr>
+	<tr><td>coverage (recorded/replay)</td><td class="stat">{{.Cover}} / {{.ReplayCover}}</td></tr>
+	{{if .SymbolizeError}}<tr><td>symbolization</td><td>{{.SymbolizeError}}</td></tr>{{end}}
+	{{end}}
+</table>
+<form action="/replay" method="post">
+	<input type="hidden" name="sig" value="{{$.Sig}}">
+	<input type="hidden" name="force" value="1">
+	<input type="submit" value="Replay again">
+</form>
+{{with $.Diff}}
+<br>
+<table class="list_table">
+	<caption>New coverage (not recorded):</caption>
+	<tr>
+		<th>File</th>
+		<th>PCs</th>
+		<th>Lines</th>
+	</tr>
+	{{range $f := .New}}
+	<tr>
+		<td>{{if $f.File}}{{$f.File}}{{else}}(not symbolized){{end}}</td>
+		<td class="stat">{{$f.PCs}}</td>
+		<td>{{range $l := $f.Lines}}{{$l}} {{end}}</td>
+	</tr>
+	{{end}}
+</table>
+<br>
+<table class="list_table">
+	<caption>Lost coverage (recorded, but not covered by the replay):</caption>
+	<tr>
+		<th>File</th>
+		<th>PCs</th>
+		<th>Lines</th>
+	</tr>
+	{{range $f := .Lost}}
+	<tr>
+		<td>{{if $f.File}}{{$f.File}}{{else}}(not symbolized){{end}}</td>
+		<td class="stat">{{$f.PCs}}</td>
+		<td>{{range $l := $f.Lines}}{{$l}} {{end}}</td>
+	</tr>
+	{{end}}
+</table>
+{{end}}
+</body></html>
+`)
+
 type UIPrioData struct {
 	Call  string
 	Prios []UIPrio
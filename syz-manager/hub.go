@@ -46,7 +46,7 @@ type HubConnector struct {
 // HubManagerView restricts interface between HubConnector and Manager.
 type HubManagerView interface {
 	getMinimizedCorpus() (corpus, repros [][]byte)
-	addNewCandidates(progs [][]byte)
+	addNewCandidates(progs [][]byte, origin string)
 }
 
 func (hc *HubConnector) loop() {
@@ -168,7 +168,7 @@ func (hc *HubConnector) processProgs(progs [][]byte) int {
 		}
 		candidates = append(candidates, inp)
 	}
-	hc.mgr.addNewCandidates(candidates)
+	hc.mgr.addNewCandidates(candidates, rpctype.OriginHub)
 	return dropped
 }
 
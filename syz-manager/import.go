@@ -0,0 +1,79 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/google/syzkaller/pkg/crashdir"
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/report"
+)
+
+// maxImportLogSize limits size of console logs accepted by /api/import.
+const maxImportLogSize = 64 << 20
+
+// httpImport accepts a raw kernel console log (e.g. from a CI boot test or a user report)
+// in the request body and files it as a crash in the same way as crashes found by fuzzing.
+func (mgr *Manager) httpImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxImportLogSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read log: %v", err), http.StatusBadRequest)
+		return
+	}
+	crash, reproQueued, err := mgr.importLog(data)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("log rejected: %v", err), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "title: %v\n", crash.Title)
+	fmt.Fprintf(w, "id: %v\n", crashdir.ID(crash.Title))
+	if crash.Corrupted {
+		fmt.Fprintf(w, "corrupted: %v\n", crash.CorruptedReason)
+	}
+	fmt.Fprintf(w, "repro queued: %v\n", reproQueued)
+}
+
+// importLog parses an external console log, saves the crash and, if the log contains
+// programs that we can execute, queues reproduction of the crash.
+// Logs that don't contain a crash are rejected.
+func (mgr *Manager) importLog(data []byte) (*Crash, bool, error) {
+	if !mgr.reporter.ContainsCrash(data) {
+		return nil, false, fmt.Errorf("no kernel crash found in the log: %v", report.NoCrashReason(mgr.reporter, data))
+	}
+	rep := mgr.reporter.Parse(data)
+	if rep == nil {
+		return nil, false, fmt.Errorf("failed to extract crash report from the log")
+	}
+	if rep.Suppressed {
+		return nil, false, fmt.Errorf("crash %q is suppressed", rep.Title)
+	}
+	crash := &Crash{
+		vmIndex:  -1,
+		external: true,
+		Report:   rep,
+	}
+	mgr.stats.crashImported.inc()
+	if !mgr.saveCrash(crash) {
+		return crash, false, nil
+	}
+	if len(mgr.target.ParseLog(data)) == 0 {
+		log.Crashf("external crash %v: no programs in the log, not reproducing", crash.Title)
+		return crash, false, nil
+	}
+	select {
+	case mgr.importReproQueue <- crash:
+		return crash, true, nil
+	default:
+		log.Crashf("external crash %v: repro queue is full", crash.Title)
+		return crash, false, nil
+	}
+}
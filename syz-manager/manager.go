@@ -13,6 +13,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -20,6 +21,7 @@ import (
 
 	"github.com/google/syzkaller/dashboard/dashapi"
 	"github.com/google/syzkaller/pkg/cover"
+	"github.com/google/syzkaller/pkg/crashdir"
 	"github.com/google/syzkaller/pkg/csource"
 	"github.com/google/syzkaller/pkg/db"
 	"github.com/google/syzkaller/pkg/gce"
@@ -36,12 +38,17 @@ import (
 	"github.com/google/syzkaller/sys"
 	"github.com/google/syzkaller/sys/targets"
 	"github.com/google/syzkaller/vm"
+	"github.com/google/syzkaller/vm/vmimpl"
 )
 
 var (
 	flagConfig = flag.String("config", "", "configuration file")
 	flagDebug  = flag.Bool("debug", false, "dump all VM output to console")
 	flagBench  = flag.String("bench", "", "write execution statistics into this file periodically")
+
+	flagSingleVMDebug = flag.Bool("single-vm-debug", false, "boot one VM and run -debug-prog in it "+
+		"without fuzzing (for development of descriptions)")
+	flagDebugProg = flag.String("debug-prog", "", "program to run with -single-vm-debug every time the file changes")
 )
 
 type Manager struct {
@@ -51,8 +58,10 @@ type Manager struct {
 	sysTarget      *targets.Target
 	reporter       report.Reporter
 	crashdir       string
+	bootWarnDir    string // kernel oopses printed during boot (see saveBootWarning)
 	port           int
 	corpusDB       *db.DB
+	provenanceDB   *db.DB // provenance of corpus programs (see db.Provenance)
 	startTime      time.Time
 	firstConnect   time.Time
 	fuzzingTime    time.Duration
@@ -65,7 +74,16 @@ type Manager struct {
 	numFuzzing     uint32
 	numReproducing uint32
 
-	dash *dashapi.Dashboard
+	dash          *dashapi.Dashboard
+	uploader      *crashUploader
+	coverWatch    *coverWatchdog
+	callHealth    *callHealth
+	crashCooldown *crashCooldown
+	suppressions  *scopedSuppressions
+	vmStats       *vmStats
+
+	executorHash string // expected hash of syz-executor in VMs
+	rnd          *rand.Rand
 
 	mu              sync.Mutex
 	phase           int
@@ -81,10 +99,27 @@ type Manager struct {
 	lastMinCorpus    int
 	memoryLeakFrames map[string]bool
 
-	fuzzers        map[string]*Fuzzer
-	needMoreRepros chan chan bool
-	hubReproQueue  chan *Crash
-	reproRequest   chan chan map[string]bool
+	// Summary of the corpus re-validation after syscall descriptions have changed (for UI).
+	descriptionsChange string
+
+	siblingSeeds []*siblingSeed    // reproducers of sibling managers to inject as candidates
+	seedOrigins  map[string]string // hash of injected sibling reproducer -> its origin
+
+	foreign  []*foreignCorpus // corpora of managers for other targets
+	inputLog []string         // hashes of new corpus inputs in the order of addition (for /api/inputs)
+
+	slowProfiles *slowProfiles
+	replays      *corpusReplays
+	focus        *focusState
+
+	fuzzers          map[string]*Fuzzer
+	fuzzerRuns       map[string]int   // number of connects of each fuzzer (if seed is set)
+	fuzzerSeeds      map[string]int64 // seed of the current run of each fuzzer (if seed is set)
+	fuzzerProcs      map[string]int   // number of procs of the current run of each fuzzer
+	needMoreRepros   chan chan bool
+	hubReproQueue    chan *Crash
+	importReproQueue chan *Crash
+	reproRequest     chan chan map[string]bool
 
 	// For checking that files that we are using are not changing under us.
 	// Maps file name to modification time.
@@ -115,8 +150,17 @@ type Fuzzer struct {
 }
 
 type Crash struct {
-	vmIndex int
-	hub     bool // this crash was created based on a repro from hub
+	vmIndex  int
+	hub      bool // this crash was created based on a repro from hub
+	external bool // this crash was imported from an external console log
+	// Recorded VM execution (see vmimpl.Recorder) and the command that replays it.
+	recording     string
+	replayCommand string
+	seed          int64  // PRNG seed of the fuzzer in the VM (if seed is set)
+	kernelTag     string // tag of the kernel the VM runs (if the VM pool runs several kernels)
+	seededFrom    string // origin of the sibling manager reproducer that caused the crash (if any)
+	procs         int    // number of fuzzer procs in the VM (0 if unknown)
+	occurrences   int    // number of crashes the saved crash stands for (if it's a batch that failed to upload)
 	*report.Report
 }
 
@@ -130,6 +174,7 @@ func main() {
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
+	log.EnableRateLimiting(cfg.LogRateLimit)
 	target, err := prog.GetTarget(cfg.TargetOS, cfg.TargetArch)
 	if err != nil {
 		log.Fatalf("%v", err)
@@ -138,6 +183,10 @@ func main() {
 	if sysTarget == nil {
 		log.Fatalf("unsupported OS/arch: %v/%v", cfg.TargetOS, cfg.TargetArch)
 	}
+	if *flagSingleVMDebug {
+		runSingleVMDebug(cfg, target, *flagDebugProg)
+		return
+	}
 	syscalls, err := mgrconfig.ParseEnabledSyscalls(target, cfg.EnabledSyscalls, cfg.DisabledSyscalls)
 	if err != nil {
 		log.Fatalf("%v", err)
@@ -160,6 +209,10 @@ func RunManager(cfg *mgrconfig.Config, target *prog.Target, sysTarget *targets.T
 
 	crashdir := filepath.Join(cfg.Workdir, "crashes")
 	osutil.MkdirAll(crashdir)
+	bootWarnDir := filepath.Join(cfg.Workdir, "boot-warnings")
+	osutil.MkdirAll(bootWarnDir)
+	// Recordings that were not saved with crashes before the previous manager exit.
+	os.RemoveAll(filepath.Join(cfg.Workdir, "recordings"))
 
 	var enabledSyscalls []int
 	for c := range syscalls {
@@ -178,6 +231,7 @@ func RunManager(cfg *mgrconfig.Config, target *prog.Target, sysTarget *targets.T
 		sysTarget:        sysTarget,
 		reporter:         reporter,
 		crashdir:         crashdir,
+		bootWarnDir:      bootWarnDir,
 		startTime:        time.Now(),
 		stats:            new(Stats),
 		fuzzerStats:      make(map[string]uint64),
@@ -187,23 +241,74 @@ func RunManager(cfg *mgrconfig.Config, target *prog.Target, sysTarget *targets.T
 		disabledHashes:   make(map[string]struct{}),
 		memoryLeakFrames: make(map[string]bool),
 		fuzzers:          make(map[string]*Fuzzer),
+		fuzzerRuns:       make(map[string]int),
+		fuzzerSeeds:      make(map[string]int64),
+		fuzzerProcs:      make(map[string]int),
 		fresh:            true,
 		vmStop:           make(chan bool),
 		hubReproQueue:    make(chan *Crash, 10),
+		importReproQueue: make(chan *Crash, 10),
 		needMoreRepros:   make(chan chan bool),
 		reproRequest:     make(chan chan map[string]bool),
 		usedFiles:        make(map[string]time.Time),
+		seedOrigins:      make(map[string]string),
+	}
+
+	seed := cfg.Seed
+	if seed != 0 {
+		log.Logf(0, "seed: %v", seed)
+	} else {
+		seed = time.Now().UnixNano()
 	}
+	mgr.rnd = rand.New(rand.NewSource(seed))
 
 	log.Logf(0, "loading corpus...")
 	mgr.corpusDB, err = db.Open(filepath.Join(cfg.Workdir, "corpus.db"))
 	if err != nil {
 		log.Fatalf("failed to open corpus database: %v", err)
 	}
+	mgr.provenanceDB, err = db.Open(db.ProvenanceFile(filepath.Join(cfg.Workdir, "corpus.db")))
+	if err != nil {
+		log.Fatalf("failed to open corpus provenance database: %v", err)
+	}
+	log.Logf(0, "syscall descriptions revision: %v (corpus: %v)",
+		target.Revision, mgr.corpusDB.Meta[descriptionsMeta])
+	mgr.revalidateRepros()
+	mgr.loadSiblingSeeds()
+	mgr.foreign, err = newForeignCorpora(cfg)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	mgr.slowProfiles = &slowProfiles{disabled: make(map[string]string)}
+	mgr.replays = newCorpusReplays()
+	mgr.focus = newFocusState()
+
+	mgr.executorHash, err = instance.ExecutorHash(cfg)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	mgr.collectUsedFiles()
+	mgr.coverWatch = newCoverWatchdog(filepath.Join(cfg.Workdir, "coverwatch.json"), mgr.kernelID(),
+		float64(cfg.MinExecSignal), float64(cfg.SignalDropFactor))
+	mgr.callHealth = newCallHealth(filepath.Join(cfg.Workdir, "callhealth.json"), mgr.kernelID(),
+		sys.GitRevision+"/"+target.Revision)
+	mgr.crashCooldown = newCrashCooldown(time.Duration(cfg.CrashCooldown) * time.Second)
+	mgr.suppressions, err = newScopedSuppressions(cfg.ScopedSuppressions)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	mgr.vmStats = newVMStats(float64(cfg.SlowVMFactor))
+
+	if cfg.DashboardAddr != "" {
+		mgr.dash = dashapi.New(cfg.DashboardClient, cfg.DashboardAddr, cfg.DashboardKey)
+		mgr.uploader = newCrashUploader(mgr.dash, time.Duration(cfg.DashboardBatchPeriod)*time.Second,
+			time.Duration(cfg.DashboardTitleInterval)*time.Second)
+	}
 
 	// Create HTTP server.
+	// Handlers use the manager state above, so it must be created before serving.
 	mgr.initHTTP()
-	mgr.collectUsedFiles()
 
 	// Create RPC server for fuzzers.
 	s, err := rpctype.NewRPCServer(cfg.RPC, mgr)
@@ -214,10 +319,6 @@ func RunManager(cfg *mgrconfig.Config, target *prog.Target, sysTarget *targets.T
 	mgr.port = s.Addr().(*net.TCPAddr).Port
 	go s.Serve()
 
-	if cfg.DashboardAddr != "" {
-		mgr.dash = dashapi.New(cfg.DashboardClient, cfg.DashboardAddr, cfg.DashboardKey)
-	}
-
 	go func() {
 		for lastTime := time.Now(); ; {
 			time.Sleep(10 * time.Second)
@@ -262,6 +363,12 @@ func RunManager(cfg *mgrconfig.Config, target *prog.Target, sysTarget *targets.T
 				vals["fuzzing"] = uint64(mgr.fuzzingTime) / 1e9
 				vals["signal"] = uint64(mgr.corpusSignal.Len())
 				vals["coverage"] = uint64(len(mgr.corpusCover))
+				alert, signalPerExec, _ := mgr.coverWatch.status()
+				vals["signal per exec"] = uint64(signalPerExec)
+				vals["coverage alert"] = 0
+				if alert != "" {
+					vals["coverage alert"] = 1
+				}
 				for k, v := range mgr.fuzzerStats {
 					vals[k] = v
 				}
@@ -269,6 +376,9 @@ func RunManager(cfg *mgrconfig.Config, target *prog.Target, sysTarget *targets.T
 				for k, v := range mgr.stats.all() {
 					vals[k] = v
 				}
+				for k, v := range mgr.poolStats() {
+					vals[k] = v
+				}
 
 				data, err := json.MarshalIndent(vals, "", "  ")
 				if err != nil {
@@ -284,6 +394,8 @@ func RunManager(cfg *mgrconfig.Config, target *prog.Target, sysTarget *targets.T
 	if mgr.dash != nil {
 		go mgr.dashboardReporter()
 	}
+	go mgr.coverWatchLoop()
+	go mgr.callHealthLoop()
 
 	osutil.HandleInterrupts(vm.Shutdown)
 	if mgr.vmPool == nil {
@@ -291,9 +403,12 @@ func RunManager(cfg *mgrconfig.Config, target *prog.Target, sysTarget *targets.T
 		log.Logf(0, "you are supposed to start syz-fuzzer manually as:")
 		log.Logf(0, "syz-fuzzer -manager=manager.ip:%v [other flags as necessary]", mgr.port)
 		<-vm.Shutdown
-		return
+	} else {
+		mgr.vmLoop()
+	}
+	if mgr.uploader != nil {
+		mgr.uploader.close()
 	}
-	mgr.vmLoop()
 }
 
 type RunResult struct {
@@ -372,7 +487,8 @@ func (mgr *Manager) vmLoop() {
 				atomic.AddUint32(&mgr.numReproducing, 1)
 				log.Logf(1, "loop: starting repro of '%v' on instances %+v", crash.Title, vmIndexes)
 				go func() {
-					res, stats, err := repro.Run(crash.Output, mgr.cfg, mgr.reporter, mgr.vmPool, vmIndexes)
+					res, stats, err := repro.Run(crash.Output, mgr.reproConfig(crash), mgr.reporter,
+						mgr.vmPool, vmIndexes)
 					reproDone <- &ReproResult{vmIndexes, crash.Title, res, stats, err, crash.hub}
 				}()
 			}
@@ -383,6 +499,9 @@ func (mgr *Manager) vmLoop() {
 				log.Logf(1, "loop: starting instance %v", idx)
 				go func() {
 					crash, err := mgr.runInstance(idx)
+					if _, ok := err.(*instance.DegradedError); ok {
+						mgr.quarantine(idx)
+					}
 					runDone <- &RunResult{idx, crash, err}
 				}()
 			}
@@ -416,25 +535,29 @@ func (mgr *Manager) vmLoop() {
 			}
 		case res := <-reproDone:
 			atomic.AddUint32(&mgr.numReproducing, ^uint32(0))
-			crepro := false
+			crepro, envSensitive := false, false
 			title := ""
 			if res.res != nil {
 				crepro = res.res.CRepro
+				envSensitive = res.res.EnvironmentSensitive
 				title = res.res.Report.Title
 			}
-			log.Logf(1, "loop: repro on %+v finished '%v', repro=%v crepro=%v desc='%v'",
-				res.instances, res.title0, res.res != nil, crepro, title)
-			if res.err != nil {
-				log.Logf(0, "repro failed: %v", res.err)
+			log.Logf(1, "loop: repro on %+v finished '%v', repro=%v crepro=%v env-sensitive=%v desc='%v'",
+				res.instances, res.title0, res.res != nil, crepro, envSensitive, title)
+			if res.err != nil && res.err != repro.ErrInterrupted {
+				log.Crashf("repro failed: %v", res.err)
 			}
 			delete(reproducing, res.title0)
 			instances = append(instances, res.instances...)
 			reproInstances -= instancesPerRepro
-			if res.res == nil {
+			switch {
+			case res.err == repro.ErrInterrupted:
+				// The manager is shutting down, don't save incomplete results.
+			case res.res == nil:
 				if !res.hub {
 					mgr.saveFailedRepro(res.title0, res.stats)
 				}
-			} else {
+			default:
 				mgr.saveRepro(res.res, res.stats, res.hub)
 			}
 		case <-shutdown:
@@ -443,6 +566,9 @@ func (mgr *Manager) vmLoop() {
 		case crash := <-mgr.hubReproQueue:
 			log.Logf(1, "loop: get repro from hub")
 			pendingRepro[crash] = true
+		case crash := <-mgr.importReproQueue:
+			log.Logf(1, "loop: get repro for external crash '%v'", crash.Title)
+			pendingRepro[crash] = true
 		case reply := <-mgr.needMoreRepros:
 			reply <- phase >= phaseTriagedHub &&
 				len(reproQueue)+len(pendingRepro)+len(reproducing) == 0
@@ -482,8 +608,20 @@ func (mgr *Manager) loadCorpus() {
 	for _, id := range mgr.checkResult.EnabledCalls[mgr.cfg.Sandbox] {
 		syscalls[id] = true
 	}
-	deleted := 0
-	for key, rec := range mgr.corpusDB.Records {
+	keys := make([]string, 0, len(mgr.corpusDB.Records))
+	for key := range mgr.corpusDB.Records {
+		keys = append(keys, key)
+	}
+	if mgr.cfg.Seed != 0 {
+		// Map iteration order is random, candidates need a stable order to be reproducible.
+		sort.Strings(keys)
+	}
+	// If descriptions have changed since the corpus was saved, count programs that changed meaning.
+	oldDescriptions := mgr.corpusDB.Meta[descriptionsMeta]
+	revalidate := oldDescriptions != "" && oldDescriptions != mgr.target.Revision
+	deleted, changed := 0, 0
+	for _, key := range keys {
+		rec := mgr.corpusDB.Records[key]
 		p, err := mgr.target.Deserialize(rec.Val, prog.NonStrict)
 		if err != nil {
 			if deleted < 10 {
@@ -493,6 +631,9 @@ func (mgr *Manager) loadCorpus() {
 			deleted++
 			continue
 		}
+		if revalidate && progChanged(mgr.target, rec.Val) != "" {
+			changed++
+		}
 		disabled := false
 		for _, c := range p.Calls {
 			if !syscalls[c.Meta.ID] {
@@ -511,10 +652,23 @@ func (mgr *Manager) loadCorpus() {
 			Prog:      rec.Val,
 			Minimized: minimized,
 			Smashed:   smashed,
+			Origin:    rpctype.OriginCorpus,
 		})
 	}
 	mgr.fresh = len(mgr.corpusDB.Records) == 0
 	log.Logf(0, "%-24v: %v (%v deleted)", "corpus", len(mgr.candidates), deleted)
+	if revalidate {
+		mgr.descriptionsChange = fmt.Sprintf("syscall descriptions have changed since the corpus was saved"+
+			" (%v -> %v): %v programs dropped, %v programs changed meaning",
+			oldDescriptions, mgr.target.Revision, deleted, changed)
+		log.Logf(0, "%v", mgr.descriptionsChange)
+	}
+	if oldDescriptions != mgr.target.Revision {
+		mgr.corpusDB.SetMeta(descriptionsMeta, mgr.target.Revision)
+		if err := mgr.corpusDB.Flush(); err != nil {
+			log.Logf(0, "failed to save corpus database: %v", err)
+		}
+	}
 
 	// Now this is ugly.
 	// We duplicate all inputs in the corpus and shuffle the second part.
@@ -522,18 +676,74 @@ func (mgr *Manager) loadCorpus() {
 	// in such case it will also lost all cached candidates. Or, the input can be somewhat flaky
 	// and doesn't give the coverage on first try. So we give each input the second chance.
 	// Shuffling should alleviate deterministically losing the same inputs on fuzzer crashing.
+	mgr.loadForeignCorpora(syscalls)
 	mgr.candidates = append(mgr.candidates, mgr.candidates...)
 	shuffle := mgr.candidates[len(mgr.candidates)/2:]
 	for i := range shuffle {
-		j := i + rand.Intn(len(shuffle)-i)
+		j := i + mgr.rnd.Intn(len(shuffle)-i)
 		shuffle[i], shuffle[j] = shuffle[j], shuffle[i]
 	}
+	// Candidates are handed out from the end, so reproducers of sibling managers go first.
+	mgr.injectSiblingSeeds(syscalls)
 	if mgr.phase != phaseInit {
 		panic(fmt.Sprintf("loadCorpus: bad phase %v", mgr.phase))
 	}
 	mgr.phase = phaseLoadedCorpus
 }
 
+// copyError describes a failure to copy a binary into a VM. Transient failures are already retried
+// in place by the vm package, so both kinds lead to recreation of the VM, but permanent ones
+// (e.g. the guest disk is full) most likely need attention.
+func copyError(err error) error {
+	if vmimpl.IsTransient(err) {
+		return fmt.Errorf("failed to copy binary (transient failure persisted): %v", err)
+	}
+	return fmt.Errorf("failed to copy binary (permanent failure): %v", err)
+}
+
+// poolStats returns statistics of the VM pool.
+func (mgr *Manager) poolStats() map[string]uint64 {
+	if mgr.vmPool == nil {
+		return nil
+	}
+	return map[string]uint64{
+		fmt.Sprintf("%v copy retries", mgr.vmPool.Type()):            mgr.vmPool.CopyRetries(),
+		fmt.Sprintf("%v console stall recovered", mgr.vmPool.Type()): mgr.vmPool.ConsoleStallsRecovered(),
+		fmt.Sprintf("%v infra errors", mgr.vmPool.Type()):            mgr.vmPool.InfraErrors(),
+	}
+}
+
+// checkPerf runs the post-boot benchmark in the VM (see perf_gate). Degraded VMs are flagged,
+// instance.DegradedError is returned for them only if they need to be quarantined.
+func (mgr *Manager) checkPerf(inst *vm.Instance, index int) error {
+	duration, err := instance.CheckPerf(inst, mgr.cfg.PerfGate)
+	degraded, ok := err.(*instance.DegradedError)
+	if err != nil && !ok {
+		return err
+	}
+	mgr.vmStats.benchmark(fmt.Sprintf("vm-%v", index), duration, degraded != nil)
+	if degraded == nil {
+		return nil
+	}
+	mgr.stats.perfGateFailures.inc()
+	if mgr.cfg.PerfGate.Quarantine == 0 {
+		log.Logf(0, "vm-%v: %v", index, degraded)
+		return nil
+	}
+	return degraded
+}
+
+// quarantine keeps the VM index out of use for the perf_gate quarantine period,
+// the VM is recreated after that (possibly on a different host).
+func (mgr *Manager) quarantine(index int) {
+	period := time.Duration(mgr.cfg.PerfGate.Quarantine) * time.Second
+	log.Logf(0, "vm-%v: quarantined for %v", index, period)
+	select {
+	case <-time.After(period):
+	case <-vm.Shutdown:
+	}
+}
+
 func (mgr *Manager) runInstance(index int) (*Crash, error) {
 	mgr.checkUsedFiles()
 	inst, err := mgr.vmPool.Create(index)
@@ -548,11 +758,23 @@ func (mgr *Manager) runInstance(index int) (*Crash, error) {
 	}
 	fuzzerBin, err := inst.Copy(mgr.cfg.SyzFuzzerBin)
 	if err != nil {
-		return nil, fmt.Errorf("failed to copy binary: %v", err)
+		return nil, copyError(err)
 	}
 	executorBin, err := inst.Copy(mgr.cfg.SyzExecutorBin)
 	if err != nil {
-		return nil, fmt.Errorf("failed to copy binary: %v", err)
+		return nil, copyError(err)
+	}
+	if err := instance.CheckExecutor(inst, mgr.cfg, executorBin, mgr.executorHash); err != nil {
+		if _, ok := err.(*instance.ExecutorMismatchError); ok {
+			// This won't fix itself, and fuzzing with a wrong executor produces bogus crashes.
+			log.Fatalf("vm-%v: %v", index, err)
+		}
+		return nil, err
+	}
+	if mgr.cfg.PerfGate.Command != "" {
+		if err := mgr.checkPerf(inst, index); err != nil {
+			return nil, err
+		}
 	}
 
 	fuzzerV := 0
@@ -563,12 +785,19 @@ func (mgr *Manager) runInstance(index int) (*Crash, error) {
 	}
 
 	// Run the fuzzer binary.
+	mgr.mu.Lock()
+	// The seed is set when the fuzzer connects, don't attribute crashes to the previous run.
+	delete(mgr.fuzzerSeeds, fmt.Sprintf("vm-%v", index))
+	delete(mgr.fuzzerProcs, fmt.Sprintf("vm-%v", index))
+	mgr.mu.Unlock()
 	start := time.Now()
 	atomic.AddUint32(&mgr.numFuzzing, 1)
 	defer atomic.AddUint32(&mgr.numFuzzing, ^uint32(0))
 	cmd := instance.FuzzerCmd(fuzzerBin, executorBin, fmt.Sprintf("vm-%v", index),
 		mgr.cfg.TargetOS, mgr.cfg.TargetArch, fwdAddr, mgr.cfg.Sandbox, procs, fuzzerV,
 		mgr.cfg.Cover, *flagDebug, false, false)
+	cmd = instance.CPUSetCmd(cmd, mgr.cfg.GuestCPUSet)
+	cmd = instance.MemoryCgroupCmd(cmd, mgr.cfg.ExecutorMemoryLimit)
 	outc, errc, err := inst.Run(time.Hour, mgr.vmStop, cmd)
 	if err != nil {
 		return nil, fmt.Errorf("failed to run fuzzer: %v", err)
@@ -581,29 +810,76 @@ func (mgr *Manager) runInstance(index int) (*Crash, error) {
 		return nil, nil
 	}
 	crash := &Crash{
-		vmIndex: index,
-		hub:     false,
-		Report:  rep,
+		vmIndex:   index,
+		hub:       false,
+		kernelTag: inst.KernelTag(),
+		Report:    rep,
+	}
+	if !rep.Suppressed && !rep.Throttled {
+		crash.recording, crash.replayCommand = mgr.saveRecording(inst, index)
 	}
+	mgr.mu.Lock()
+	crash.seed = mgr.fuzzerSeeds[fmt.Sprintf("vm-%v", index)]
+	crash.procs = mgr.fuzzerProcs[fmt.Sprintf("vm-%v", index)]
+	mgr.mu.Unlock()
 	return crash, nil
 }
 
+// reproConfig returns the config for reproduction of the crash: the crash is reproduced
+// with the number of procs of the VM where it happened (see adaptive_procs).
+func (mgr *Manager) reproConfig(crash *Crash) *mgrconfig.Config {
+	if crash.procs == 0 || crash.procs == mgr.cfg.Procs {
+		return mgr.cfg
+	}
+	cfg := *mgr.cfg
+	cfg.Procs = crash.procs
+	return &cfg
+}
+
+// saveRecording preserves recorded execution of the crashed VM until the crash is saved.
+// Returns the recording file and the replay command, or empty strings if the VM does not record.
+func (mgr *Manager) saveRecording(inst *vm.Instance, index int) (string, string) {
+	dir := filepath.Join(mgr.cfg.Workdir, "recordings")
+	if err := osutil.MkdirAll(dir); err != nil {
+		log.Logf(0, "failed to create recordings dir: %v", err)
+		return "", ""
+	}
+	file := filepath.Join(dir, fmt.Sprintf("vm-%v-%v", index, time.Now().UnixNano()))
+	replay, err := inst.SaveRecording(file)
+	if err != nil {
+		log.Logf(0, "vm-%v: %v", index, err)
+		return "", ""
+	}
+	if replay == "" {
+		return "", ""
+	}
+	return file, replay
+}
+
 func (mgr *Manager) emailCrash(crash *Crash) {
+	mgr.sendEmail(crash.Title, crash.Report.Report)
+}
+
+func (mgr *Manager) sendEmail(subject string, body []byte) {
 	if len(mgr.cfg.EmailAddrs) == 0 {
 		return
 	}
-	args := []string{"-s", "syzkaller: " + crash.Title}
+	args := []string{"-s", "syzkaller: " + subject}
 	args = append(args, mgr.cfg.EmailAddrs...)
 	log.Logf(0, "sending email to %v", mgr.cfg.EmailAddrs)
 
 	cmd := exec.Command("mailx", args...)
-	cmd.Stdin = bytes.NewReader(crash.Report.Report)
+	cmd.Stdin = bytes.NewReader(body)
 	if _, err := osutil.Run(10*time.Minute, cmd); err != nil {
 		log.Logf(0, "failed to send email: %v", err)
 	}
 }
 
 func (mgr *Manager) saveCrash(crash *Crash) bool {
+	if crash.recording != "" {
+		// The recording is moved into the crash dir if the crash is saved locally.
+		defer os.Remove(crash.recording)
+	}
 	isMemoryLeak := strings.HasPrefix(crash.Title, report.MemoryLeakPrefix)
 	if isMemoryLeak {
 		frame := crash.Title[len(report.MemoryLeakPrefix):]
@@ -611,111 +887,253 @@ func (mgr *Manager) saveCrash(crash *Crash) bool {
 		mgr.memoryLeakFrames[frame] = true
 		mgr.mu.Unlock()
 	}
+	source := fmt.Sprintf("vm-%v", crash.vmIndex)
+	if crash.external {
+		source = "external"
+	} else {
+		mgr.vmStats.crash(source, crash.Title, time.Now())
+		if crash.Class != report.ClassBootWarning && mgr.focus.crash(crash.Title, time.Now()) {
+			mgr.stats.focusHits.inc()
+		}
+	}
 	if crash.Suppressed {
-		log.Logf(0, "vm-%v: suppressed crash %v", crash.vmIndex, crash.Title)
+		log.Crashf("%v: suppressed crash %v", source, crash.Title)
 		mgr.stats.crashSuppressed.inc()
 		return false
 	}
+	if rule := mgr.suppressions.match(crash.vmIndex, crash.Report); rule != "" {
+		log.Crashf("%v: suppressed crash %v by scoped suppression %v", source, crash.Title, rule)
+		mgr.stats.crashSuppressed.inc()
+		return false
+	}
+	if crash.Throttled {
+		log.Crashf("%v: throttled crash %v", source, crash.Title)
+		mgr.stats.crashThrottled.inc()
+		return false
+	}
+	if crash.Class == report.ClassBootWarning {
+		mgr.saveBootWarning(crash, source)
+		return false
+	}
+	if !crash.external && mgr.crashCooldown.suppress(crash.vmIndex, crash.Title, time.Now()) {
+		log.Crashf("%v: crash in cooldown: %v", source, crash.Title)
+		mgr.stats.crashCooldown.inc()
+		return false
+	}
 	corrupted := ""
 	if crash.Corrupted {
 		corrupted = " [corrupted]"
 	}
-	log.Logf(0, "vm-%v: crash: %v%v", crash.vmIndex, crash.Title, corrupted)
-	if err := mgr.reporter.Symbolize(crash.Report); err != nil {
-		log.Logf(0, "failed to symbolize report: %v", err)
+	if crash.Incomplete {
+		corrupted += " [incomplete]"
+		log.Logf(1, "%v: console output is likely lossy: %v", source, crash.IncompleteReason)
+	}
+	if crash.kernelTag != "" {
+		corrupted += fmt.Sprintf(" [kernel %v]", crash.kernelTag)
+	}
+	if crash.Repeats != 0 {
+		corrupted += fmt.Sprintf(" [%v repeats]", crash.Repeats)
+	}
+	if !crash.external && !crash.hub {
+		crash.seededFrom = mgr.seededFrom(crash.Output)
+	}
+	if crash.seededFrom != "" {
+		corrupted += fmt.Sprintf(" [seeded from %v]", crash.seededFrom)
+	}
+	// Security events are not kernel crashes: they are not symbolized, not counted as crashes
+	// and not reproduced (repro detects only crashes), they are saved locally for manual triage.
+	isSecurityEvent := crash.Class == report.ClassSecurityEvent
+	if isSecurityEvent {
+		log.Crashf("%v: security event: %v%v", source, crash.Title, corrupted)
+		mgr.stats.securityEvents.inc()
+	} else {
+		log.Crashf("%v: crash: %v%v", source, crash.Title, corrupted)
+		if err := mgr.reporter.Symbolize(crash.Report); err != nil {
+			log.Crashf("failed to symbolize report: %v", err)
+		}
+		if !crash.external {
+			// External crashes are not found by this manager, don't skew fuzzing stats.
+			mgr.stats.crashes.inc()
+		}
 	}
-
-	mgr.stats.crashes.inc()
 	mgr.mu.Lock()
 	if !mgr.crashTypes[crash.Title] {
 		mgr.crashTypes[crash.Title] = true
 		mgr.stats.crashTypes.inc()
 	}
 	mgr.mu.Unlock()
+	// Builds are recorded separately from occurrences: they are needed even for crashes uploaded
+	// to dashboard (not saved locally) and must survive rotation of old crash logs.
+	var builds []*crashdir.Build
+	if build := mgr.crashBuild(crash); build != "" {
+		var err error
+		builds, err = crashdir.RecordBuild(mgr.crashdir, crash.Title, build, crashTime(crash))
+		if err != nil {
+			log.Crashf("failed to record crash build: %v", err)
+		}
+	}
 
-	if mgr.dash != nil {
+	// External crashes don't belong to the kernel build that we fuzz,
+	// so they are stored only locally. So are leak_watch reports, which are just a heuristic signal.
+	if mgr.dash != nil && !crash.external && !isSecurityEvent &&
+		!strings.HasPrefix(crash.Title, vm.MemoryGrowthPrefix) {
 		if isMemoryLeak {
 			return true
 		}
 		dc := &dashapi.Crash{
 			BuildID:     mgr.cfg.Tag,
 			Title:       crash.Title,
+			AltTitles:   crash.AltTitles,
 			Corrupted:   crash.Corrupted,
 			Maintainers: crash.Maintainers,
+			Severity:    crash.Severity,
 			Log:         crash.Output,
 			Report:      crash.Report.Report,
+			Diagnosis:   crash.Diagnosis,
 		}
-		resp, err := mgr.dash.ReportCrash(dc)
+		for _, b := range builds {
+			dc.Builds = append(dc.Builds, dashapi.CrashBuild{
+				BuildID:   b.ID,
+				FirstSeen: b.FirstSeen,
+				LastSeen:  b.LastSeen,
+				Count:     b.Count,
+			})
+		}
+		// The crash may be batched and fail to upload after its recording is removed.
+		local := *crash
+		local.recording = ""
+		needRepro, err := mgr.uploader.reportCrash(dc, func(occurrences int) {
+			local.occurrences = occurrences
+			mgr.storeCrash(&local, source)
+		})
 		if err != nil {
-			log.Logf(0, "failed to report crash to dashboard: %v", err)
+			log.Crashf("failed to report crash to dashboard: %v", err)
 		} else {
 			// Don't store the crash locally, if we've successfully
 			// uploaded it to the dashboard. These will just eat disk space.
-			return resp.NeedRepro
+			return needRepro
 		}
 	}
 
-	sig := hash.Hash([]byte(crash.Title))
-	id := sig.String()
-	dir := filepath.Join(mgr.crashdir, id)
-	osutil.MkdirAll(dir)
-	if err := osutil.WriteFile(filepath.Join(dir, "description"), []byte(crash.Title+"\n")); err != nil {
-		log.Logf(0, "failed to write crash: %v", err)
-	}
-	// Save up to 100 reports. If we already have 100, overwrite the oldest one.
-	// Newer reports are generally more useful. Overwriting is also needed
-	// to be able to understand if a particular bug still happens or already fixed.
-	oldestI := 0
-	var oldestTime time.Time
-	for i := 0; i < 100; i++ {
-		info, err := os.Stat(filepath.Join(dir, fmt.Sprintf("log%v", i)))
-		if err != nil {
-			oldestI = i
-			if i == 0 {
-				go mgr.emailCrash(crash)
-			}
-			break
-		}
-		if oldestTime.IsZero() || info.ModTime().Before(oldestTime) {
-			oldestI = i
-			oldestTime = info.ModTime()
+	mgr.storeCrash(crash, source)
+	if isSecurityEvent {
+		return false
+	}
+	return mgr.needLocalRepro(crash)
+}
+
+// storeCrash saves the crash in the local crashes dir.
+func (mgr *Manager) storeCrash(crash *Crash, source string) {
+	origin := ""
+	if crash.external {
+		origin = "external"
+	}
+	crashLog := crash.Output
+	if crash.RawOutput != nil {
+		// The log is for humans and reproduction, it must be what the machine has actually printed.
+		crashLog = crash.RawOutput
+	}
+	occ := &crashdir.Occurrence{
+		Log:          crashLog,
+		Report:       crash.Report.Report,
+		Tag:          mgr.cfg.Tag,
+		Origin:       origin,
+		Meta:         mgr.crashMeta(crash),
+		Recording:    crash.recording,
+		Diagnosis:    crash.Diagnosis,
+		TimedConsole: crash.TimedConsole,
+		Ftrace:       crash.Ftrace,
+	}
+	index, first, err := crashdir.SaveCrash(mgr.crashdir, crash.Title, occ)
+	if err != nil {
+		log.Crashf("failed to save crash: %v", err)
+	} else {
+		log.Logf(1, "%v: saved crash as %v/log%v", source, crashdir.ID(crash.Title), index)
+		if first {
+			go mgr.emailCrash(crash)
 		}
 	}
-	osutil.WriteFile(filepath.Join(dir, fmt.Sprintf("log%v", oldestI)), crash.Output)
-	if len(mgr.cfg.Tag) > 0 {
-		osutil.WriteFile(filepath.Join(dir, fmt.Sprintf("tag%v", oldestI)), []byte(mgr.cfg.Tag))
+}
+
+func (mgr *Manager) crashMeta(crash *Crash) *crashdir.Meta {
+	meta := &crashdir.Meta{
+		Title:            crash.Title,
+		Time:             time.Now(),
+		VMIndex:          crash.vmIndex,
+		BuildID:          mgr.cfg.Tag,
+		Revision:         sys.GitRevision,
+		Descriptions:     mgr.target.Revision,
+		Corrupted:        crash.Corrupted,
+		CorruptedReason:  crash.CorruptedReason,
+		IncompleteReason: crash.IncompleteReason,
+		ReportOrigin:     crash.Origin,
+		GuiltyFile:       crash.GuiltyFile(),
+		Maintainers:      crash.Maintainers,
+		Info:             string(crash.Info),
+		ReplayCommand:    crash.replayCommand,
+		GuestUptime:      crash.GuestUptime.Seconds(),
+		Seed:             crash.seed,
+		KernelTag:        crash.kernelTag,
+		SeededFrom:       crash.seededFrom,
+		Procs:            crash.procs,
+		Repeats:          crash.Repeats,
+		Occurrences:      crash.occurrences,
+		MemState:         string(crash.MemState),
+		Severity:         crash.Severity,
+		Class:            crash.Class,
+		Liveness:         crash.Liveness,
+		Cmdline:          crash.Cmdline,
+		Frames:           saveFrames(crash.Frames),
+	}
+	if !crash.Time.IsZero() {
+		meta.Time = crash.Time
+	}
+	if crash.external {
+		meta.VMIndex = -1
+	}
+	// The last program of each proc is what was executing when the kernel crashed.
+	last := make(map[int]*prog.LogEntry)
+	for _, ent := range mgr.target.ParseLog(crash.Output) {
+		last[ent.Proc] = ent
+	}
+	for _, ent := range last {
+		meta.Programs = append(meta.Programs, hash.String(ent.P.Serialize()))
+	}
+	sort.Strings(meta.Programs)
+	return meta
+}
+
+// crashBuild returns identifier of the kernel build the crash happened on: the build tag, with the kernel tag
+// if the VM pool runs several kernels. Returns an empty string if the build is unknown or is not ours.
+func (mgr *Manager) crashBuild(crash *Crash) string {
+	if crash.external {
+		return ""
 	}
-	if len(crash.Report.Report) > 0 {
-		osutil.WriteFile(filepath.Join(dir, fmt.Sprintf("report%v", oldestI)), crash.Report.Report)
+	if crash.kernelTag == "" || mgr.cfg.Tag == "" {
+		return mgr.cfg.Tag + crash.kernelTag
 	}
-
-	return mgr.needLocalRepro(crash)
+	return mgr.cfg.Tag + "/" + crash.kernelTag
 }
 
-const maxReproAttempts = 3
+func crashTime(crash *Crash) time.Time {
+	if !crash.Time.IsZero() {
+		return crash.Time
+	}
+	return time.Now()
+}
 
 func (mgr *Manager) needLocalRepro(crash *Crash) bool {
-	if !mgr.cfg.Reproduce || crash.Corrupted {
+	if !mgr.cfg.Reproduce || crash.Corrupted || strings.HasPrefix(crash.Title, vm.MemoryGrowthPrefix) {
 		return false
 	}
-	sig := hash.Hash([]byte(crash.Title))
-	dir := filepath.Join(mgr.crashdir, sig.String())
-	if osutil.IsExist(filepath.Join(dir, "repro.prog")) {
-		return false
-	}
-	for i := 0; i < maxReproAttempts; i++ {
-		if !osutil.IsExist(filepath.Join(dir, fmt.Sprintf("repro%v", i))) {
-			return true
-		}
-	}
-	return false
+	return crashdir.NeedRepro(mgr.crashdir, crash.Title)
 }
 
 func (mgr *Manager) needRepro(crash *Crash) bool {
 	if crash.hub {
 		return true
 	}
-	if mgr.dash == nil {
+	if mgr.dash == nil || crash.external || strings.HasPrefix(crash.Title, vm.MemoryGrowthPrefix) {
 		return mgr.needLocalRepro(crash)
 	}
 	if strings.HasPrefix(crash.Title, report.MemoryLeakPrefix) {
@@ -726,9 +1144,9 @@ func (mgr *Manager) needRepro(crash *Crash) bool {
 		Title:     crash.Title,
 		Corrupted: crash.Corrupted,
 	}
-	needRepro, err := mgr.dash.NeedRepro(cid)
+	needRepro, err := mgr.uploader.needRepro(cid)
 	if err != nil {
-		log.Logf(0, "dashboard.NeedRepro failed: %v", err)
+		log.Crashf("dashboard.NeedRepro failed: %v", err)
 	}
 	return needRepro
 }
@@ -745,26 +1163,20 @@ func (mgr *Manager) saveFailedRepro(title string, stats *repro.Stats) {
 			Title:   title,
 		}
 		if err := mgr.dash.ReportFailedRepro(cid); err != nil {
-			log.Logf(0, "failed to report failed repro to dashboard: %v", err)
+			log.Crashf("failed to report failed repro to dashboard: %v", err)
 		} else {
 			return
 		}
 	}
-	dir := filepath.Join(mgr.crashdir, hash.String([]byte(title)))
-	osutil.MkdirAll(dir)
-	for i := 0; i < maxReproAttempts; i++ {
-		name := filepath.Join(dir, fmt.Sprintf("repro%v", i))
-		if !osutil.IsExist(name) {
-			saveReproStats(name, stats)
-			break
-		}
+	if err := crashdir.SaveFailedRepro(mgr.crashdir, title, reproStats(stats)); err != nil {
+		log.Crashf("failed to save repro stats: %v", err)
 	}
 }
 
 func (mgr *Manager) saveRepro(res *repro.Result, stats *repro.Stats, hub bool) {
 	rep := res.Report
 	if err := mgr.reporter.Symbolize(rep); err != nil {
-		log.Logf(0, "failed to symbolize repro: %v", err)
+		log.Crashf("failed to symbolize repro: %v", err)
 	}
 	opts := fmt.Sprintf("# %+v\n", res.Opts)
 	prog := res.Prog.Serialize()
@@ -788,7 +1200,7 @@ func (mgr *Manager) saveRepro(res *repro.Result, stats *repro.Stats, hub bool) {
 			}
 			cprogText = cprog
 		} else {
-			log.Logf(0, "failed to write C source: %v", err)
+			log.Crashf("failed to write C source: %v", err)
 		}
 	}
 
@@ -801,7 +1213,9 @@ func (mgr *Manager) saveRepro(res *repro.Result, stats *repro.Stats, hub bool) {
 		dc := &dashapi.Crash{
 			BuildID:     mgr.cfg.Tag,
 			Title:       res.Report.Title,
+			AltTitles:   res.Report.AltTitles,
 			Maintainers: res.Report.Maintainers,
+			Severity:    res.Report.Severity,
 			Log:         res.Report.Output,
 			Report:      res.Report.Report,
 			ReproOpts:   res.Opts.Serialize(),
@@ -809,7 +1223,7 @@ func (mgr *Manager) saveRepro(res *repro.Result, stats *repro.Stats, hub bool) {
 			ReproC:      cprogText,
 		}
 		if _, err := mgr.dash.ReportCrash(dc); err != nil {
-			log.Logf(0, "failed to report repro to dashboard: %v", err)
+			log.Crashf("failed to report repro to dashboard: %v", err)
 		} else {
 			// Don't store the crash locally, if we've successfully
 			// uploaded it to the dashboard. These will just eat disk space.
@@ -817,37 +1231,30 @@ func (mgr *Manager) saveRepro(res *repro.Result, stats *repro.Stats, hub bool) {
 		}
 	}
 
-	dir := filepath.Join(mgr.crashdir, hash.String([]byte(rep.Title)))
-	osutil.MkdirAll(dir)
+	repro := &crashdir.Repro{
+		Prog:   append([]byte(opts), prog...),
+		CProg:  cprogText,
+		Log:    rep.Output,
+		Report: rep.Report,
+		Tag:    mgr.cfg.Tag,
+		Stats:  reproStats(stats),
 
-	if err := osutil.WriteFile(filepath.Join(dir, "description"), []byte(rep.Title+"\n")); err != nil {
-		log.Logf(0, "failed to write crash: %v", err)
-	}
-	osutil.WriteFile(filepath.Join(dir, "repro.prog"), append([]byte(opts), prog...))
-	if len(mgr.cfg.Tag) > 0 {
-		osutil.WriteFile(filepath.Join(dir, "repro.tag"), []byte(mgr.cfg.Tag))
-	}
-	if len(rep.Output) > 0 {
-		osutil.WriteFile(filepath.Join(dir, "repro.log"), rep.Output)
+		Descriptions: mgr.target.Revision,
 	}
-	if len(rep.Report) > 0 {
-		osutil.WriteFile(filepath.Join(dir, "repro.report"), rep.Report)
+	if err := crashdir.SaveRepro(mgr.crashdir, rep.Title, repro); err != nil {
+		log.Crashf("failed to save repro: %v", err)
 	}
-	if len(cprogText) > 0 {
-		osutil.WriteFile(filepath.Join(dir, "repro.cprog"), cprogText)
-	}
-	saveReproStats(filepath.Join(dir, "repro.stats"), stats)
 }
 
-func saveReproStats(filename string, stats *repro.Stats) {
+func reproStats(stats *repro.Stats) []byte {
 	text := ""
 	if stats != nil {
 		text = fmt.Sprintf("Extracting prog: %v\nMinimizing prog: %v\n"+
-			"Simplifying prog options: %v\nExtracting C: %v\nSimplifying C: %v\n\n\n%s",
+			"Simplifying prog options: %v\nExtracting C: %v\nSimplifying C: %v\nVerifying: %v\n\n\n%s",
 			stats.ExtractProgTime, stats.MinimizeProgTime,
-			stats.SimplifyProgTime, stats.ExtractCTime, stats.SimplifyCTime, stats.Log)
+			stats.SimplifyProgTime, stats.ExtractCTime, stats.SimplifyCTime, stats.VerifyTime, stats.Log)
 	}
-	osutil.WriteFile(filename, []byte(text))
+	return []byte(text)
 }
 
 func (mgr *Manager) getMinimizedCorpus() (corpus, repros [][]byte) {
@@ -863,13 +1270,14 @@ func (mgr *Manager) getMinimizedCorpus() (corpus, repros [][]byte) {
 	return
 }
 
-func (mgr *Manager) addNewCandidates(progs [][]byte) {
+func (mgr *Manager) addNewCandidates(progs [][]byte, origin string) {
 	candidates := make([]rpctype.RPCCandidate, len(progs))
 	for i, inp := range progs {
 		candidates[i] = rpctype.RPCCandidate{
 			Prog:      inp,
 			Minimized: false, // don't trust programs from hub
 			Smashed:   false,
+			Origin:    origin,
 		}
 	}
 	mgr.mu.Lock()
@@ -912,11 +1320,13 @@ func (mgr *Manager) minimizeCorpus() {
 		}
 	}
 	mgr.corpusDB.BumpVersion(currentDBVersion)
+	mgr.pruneProvenance()
 }
 
 func (mgr *Manager) Connect(a *rpctype.ConnectArgs, r *rpctype.ConnectRes) error {
 	log.Logf(1, "fuzzer %v connected", a.Name)
 	mgr.stats.vmRestarts.inc()
+	mgr.vmStats.connect(a.Name, time.Now())
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
 
@@ -927,8 +1337,28 @@ func (mgr *Manager) Connect(a *rpctype.ConnectArgs, r *rpctype.ConnectRes) error
 	mgr.minimizeCorpus()
 	f.newMaxSignal = mgr.maxSignal.Copy()
 	f.inputs = make([]rpctype.RPCInput, 0, len(mgr.corpus))
-	for _, inp := range mgr.corpus {
-		f.inputs = append(f.inputs, inp)
+	if ap := mgr.cfg.AdaptiveProcs; ap.Max != 0 && !*flagDebug {
+		r.MinProcs, r.MaxProcs, r.MemPerProc = ap.Min, ap.Max, ap.MemPerProc
+	}
+	if mgr.cfg.Seed != 0 {
+		run := mgr.fuzzerRuns[a.Name]
+		mgr.fuzzerRuns[a.Name]++
+		r.Seed = hash.Seed(mgr.cfg.Seed, fmt.Sprintf("%v/%v", a.Name, run))
+		mgr.fuzzerSeeds[a.Name] = r.Seed
+		log.Logf(0, "fuzzer %v: seed %v", a.Name, r.Seed)
+		// The fuzzer corpus order affects mutations.
+		sigs := make([]string, 0, len(mgr.corpus))
+		for sig := range mgr.corpus {
+			sigs = append(sigs, sig)
+		}
+		sort.Strings(sigs)
+		for _, sig := range sigs {
+			f.inputs = append(f.inputs, mgr.corpus[sig])
+		}
+	} else {
+		for _, inp := range mgr.corpus {
+			f.inputs = append(f.inputs, inp)
+		}
 	}
 	r.MemoryLeakFrames = make([][]byte, 0, len(mgr.memoryLeakFrames))
 	for frame := range mgr.memoryLeakFrames {
@@ -938,6 +1368,16 @@ func (mgr *Manager) Connect(a *rpctype.ConnectArgs, r *rpctype.ConnectRes) error
 	r.CheckResult = mgr.checkResult
 	r.GitRevision = sys.GitRevision
 	r.TargetRevision = mgr.target.Revision
+	r.ProgPrologue = mgr.cfg.ProgPrologue
+	r.ProgEpilogue = mgr.cfg.ProgEpilogue
+	r.ProgHookTimeout = time.Duration(mgr.cfg.ProgHookTimeout) * time.Second
+	r.ProgHookRestart = mgr.cfg.ProgHookFailure == "restart"
+	if sp := mgr.cfg.SlowProfiles; sp.Tracer != "" {
+		r.ProfileTracer = sp.Tracer
+		r.ProfileSample = sp.Sample
+		r.ProfileThreshold = time.Duration(sp.Threshold) * time.Millisecond
+		r.ProfileMaxSize = sp.MaxSize << 10
+	}
 	return nil
 }
 
@@ -993,10 +1433,12 @@ func (mgr *Manager) NewInput(a *rpctype.NewInputArgs, r *int) error {
 		log.Logf(0, "failed to deserialize program from fuzzer: %v\n%s", err, a.RPCInput.Prog)
 		return nil
 	}
-	if mgr.corpusSignal.Diff(inputSignal).Empty() {
+	newSignal := mgr.corpusSignal.Diff(inputSignal)
+	if newSignal.Empty() {
 		return nil
 	}
 	mgr.stats.newInputs.inc()
+	mgr.vmStats.newInput(a.Name)
 	mgr.corpusSignal.Merge(inputSignal)
 	mgr.corpusCover.Merge(a.Cover)
 	sig := hash.String(a.RPCInput.Prog)
@@ -1011,10 +1453,12 @@ func (mgr *Manager) NewInput(a *rpctype.NewInputArgs, r *int) error {
 		mgr.corpus[sig] = inp
 	} else {
 		mgr.corpus[sig] = a.RPCInput
+		mgr.inputLog = append(mgr.inputLog, sig)
 		mgr.corpusDB.Save(sig, a.RPCInput.Prog, 0)
 		if err := mgr.corpusDB.Flush(); err != nil {
 			log.Logf(0, "failed to save corpus database: %v", err)
 		}
+		mgr.recordProvenance(sig, &a.RPCInput, inputSignal.Len(), newSignal.Len())
 		for _, f1 := range mgr.fuzzers {
 			if f1 == f {
 				continue
@@ -1035,10 +1479,18 @@ func (mgr *Manager) Poll(a *rpctype.PollArgs, r *rpctype.PollRes) error {
 		switch k {
 		case "exec total":
 			mgr.stats.execTotal.add(int(v))
+		case "exec signal":
+			// Only used by coverWatch.
 		default:
 			mgr.fuzzerStats[k] += v
 		}
 	}
+	mgr.coverWatch.add(a.Stats["exec total"], a.Stats["exec signal"])
+	mgr.callHealth.add(a.CallErrnos)
+	mgr.vmStats.poll(a.Name, a.Stats["exec total"], a.ProcExecs, a.Procs, time.Now())
+	if a.Procs != 0 {
+		mgr.fuzzerProcs[a.Name] = a.Procs
+	}
 
 	f := mgr.fuzzers[a.Name]
 	if f == nil {
@@ -1067,6 +1519,8 @@ func (mgr *Manager) Poll(a *rpctype.PollArgs, r *rpctype.PollRes) error {
 			mgr.candidates = mgr.candidates[:last]
 		}
 	}
+	r.Replays = mgr.replays.next(a.Name)
+	r.Focus = mgr.focus.rpc(time.Now())
 	if len(r.Candidates) == 0 {
 		for i := 0; i < maxInputs && len(f.inputs) > 0; i++ {
 			last := len(f.inputs) - 1
@@ -1081,6 +1535,11 @@ func (mgr *Manager) Poll(a *rpctype.PollArgs, r *rpctype.PollRes) error {
 	if len(mgr.candidates) == 0 {
 		mgr.candidates = nil
 		if mgr.phase == phaseLoadedCorpus {
+			for _, fc := range mgr.foreign {
+				if fc.cfg.Manager != "" {
+					go mgr.foreignSyncLoop(fc)
+				}
+			}
 			if mgr.cfg.HubClient != "" {
 				mgr.phase = phaseTriagedCorpus
 				go mgr.hubSyncLoop()
@@ -1123,6 +1582,43 @@ func (mgr *Manager) collectUsedFiles() {
 	}
 }
 
+// kernelID identifies the kernel and image under test (for coverWatch and callHealth baselines).
+func (mgr *Manager) kernelID() string {
+	id := fmt.Sprintf("%v\n%s\n", mgr.cfg.Tag, mgr.cfg.VM)
+	vmlinux := filepath.Join(mgr.cfg.KernelObj, mgr.sysTarget.KernelObject)
+	for _, file := range []string{vmlinux, mgr.cfg.Image} {
+		if mod, ok := mgr.usedFiles[file]; ok {
+			id += fmt.Sprintf("%v %v\n", file, mod)
+		}
+	}
+	return hash.String([]byte(id))
+}
+
+func (mgr *Manager) coverWatchLoop() {
+	for range time.NewTicker(time.Minute).C {
+		mgr.mu.Lock()
+		signal := mgr.corpusSignal.Len()
+		mgr.mu.Unlock()
+		if alert := mgr.coverWatch.check(signal, time.Now()); alert != "" {
+			log.Logf(0, "COVERAGE ALERT: %v", alert)
+			mgr.sendEmail("coverage alert", []byte(alert+"\n"))
+		}
+	}
+}
+
+func (mgr *Manager) callHealthLoop() {
+	for range time.NewTicker(time.Minute).C {
+		alerts := mgr.callHealth.check(time.Now())
+		if len(alerts) == 0 {
+			continue
+		}
+		for _, alert := range alerts {
+			log.Logf(0, "SYSCALL HEALTH ALERT: %v", alert)
+		}
+		mgr.sendEmail("syscall health alert", []byte(strings.Join(alerts, "\n")+"\n"))
+	}
+}
+
 func (mgr *Manager) checkUsedFiles() {
 	for f, mod := range mgr.usedFiles {
 		stat, err := os.Stat(f)
@@ -0,0 +1,129 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/rpctype"
+	"github.com/google/syzkaller/prog"
+)
+
+// Fuzzers trace a sample of program executions and send kernel profiles of slow programs
+// (slow_profiles config). The last profiles are kept in memory and shown on /profiles,
+// along with VMs where profiling was disabled because the tracer is unavailable.
+
+const maxSlowProfiles = 100
+
+type slowProfiles struct {
+	mu       sync.Mutex
+	profiles []*slowProfile    // the last maxSlowProfiles profiles
+	seq      int               // id of the next profile
+	disabled map[string]string // VM name -> reason profiling is disabled
+}
+
+type slowProfile struct {
+	id       int
+	time     time.Time
+	vm       string
+	prog     []byte
+	short    string
+	duration time.Duration
+	tracer   string
+	profile  []byte
+}
+
+func (mgr *Manager) NewProfile(a *rpctype.NewProfileArgs, r *int) error {
+	sp := mgr.slowProfiles
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if a.Error != "" {
+		log.Logf(0, "%v: %v profiling disabled: %v", a.Name, a.Tracer, a.Error)
+		sp.disabled[a.Name] = a.Error
+		return nil
+	}
+	short := ""
+	if p, err := mgr.target.Deserialize(a.Prog, prog.NonStrict); err == nil {
+		short = p.String()
+	}
+	log.Logf(1, "%v: %v profile of a program that took %v", a.Name, a.Tracer, a.Duration)
+	mgr.stats.slowProfiles.inc()
+	sp.profiles = append(sp.profiles, &slowProfile{
+		id:       sp.seq,
+		time:     time.Now(),
+		vm:       a.Name,
+		prog:     a.Prog,
+		short:    short,
+		duration: a.Duration,
+		tracer:   a.Tracer,
+		profile:  a.Profile,
+	})
+	sp.seq++
+	if len(sp.profiles) > maxSlowProfiles {
+		sp.profiles = sp.profiles[len(sp.profiles)-maxSlowProfiles:]
+	}
+	return nil
+}
+
+func (mgr *Manager) httpProfiles(w http.ResponseWriter, r *http.Request) {
+	sp := mgr.slowProfiles
+	data := &UIProfilesData{
+		Name: mgr.cfg.Name,
+	}
+	sp.mu.Lock()
+	for i := len(sp.profiles) - 1; i >= 0; i-- {
+		prof := sp.profiles[i]
+		data.Profiles = append(data.Profiles, UIProfile{
+			ID:       prof.id,
+			Time:     prof.time,
+			VM:       prof.vm,
+			Short:    prof.short,
+			Duration: prof.duration / time.Millisecond * time.Millisecond,
+			Tracer:   prof.tracer,
+			Size:     len(prof.profile),
+		})
+	}
+	for vm, reason := range sp.disabled {
+		data.Disabled = append(data.Disabled, UIProfileDisabled{VM: vm, Reason: reason})
+	}
+	sp.mu.Unlock()
+	sort.Slice(data.Disabled, func(i, j int) bool {
+		return data.Disabled[i].VM < data.Disabled[j].VM
+	})
+	if err := profilesTemplate.Execute(w, data); err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+func (mgr *Manager) httpProfile(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "bad profile id", http.StatusBadRequest)
+		return
+	}
+	sp := mgr.slowProfiles
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	for _, prof := range sp.profiles {
+		if prof.id != id {
+			continue
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "# %v profile from %v at %v, the program took %v:\n\n",
+			prof.tracer, prof.vm, prof.time.Format(time.RFC3339), prof.duration)
+		w.Write(prof.prog)
+		fmt.Fprintf(w, "\n\n")
+		w.Write(prof.profile)
+		return
+	}
+	http.Error(w, "can't find the profile (only the last profiles are kept)", http.StatusNotFound)
+}
@@ -0,0 +1,161 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/syzkaller/pkg/db"
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/rpctype"
+	"github.com/google/syzkaller/prog"
+)
+
+// recordProvenance records provenance of the new corpus program sig.
+// Programs that are already known keep their provenance (e.g. programs from the persistent
+// corpus that are triaged again after restart), programs from the persistent corpus that
+// changed during triage (e.g. were re-minimized) inherit provenance of the old program.
+func (mgr *Manager) recordProvenance(sig string, inp *rpctype.RPCInput, signal, newSignal int) {
+	records := mgr.provenanceDB.Records
+	if _, ok := records[sig]; ok {
+		return
+	}
+	prov := &db.Provenance{
+		Origin:    inp.Origin,
+		Parent:    inp.Parent,
+		Time:      time.Now(),
+		Call:      inp.Call,
+		Signal:    signal,
+		NewSignal: newSignal,
+	}
+	if inp.Parent == sig {
+		prov.Parent = ""
+	}
+	if inp.Origin == rpctype.OriginCorpus && prov.Parent != "" {
+		if old := db.LoadProvenance(records, inp.Parent); old != nil {
+			prov.Origin, prov.Parent, prov.Time = old.Origin, old.Parent, old.Time
+		}
+		prov.Replaced = inp.Parent
+	}
+	mgr.provenanceDB.SaveProvenance(sig, prov)
+	if err := mgr.provenanceDB.Flush(); err != nil {
+		log.Logf(0, "failed to save corpus provenance database: %v", err)
+	}
+}
+
+// pruneProvenance deletes provenance of programs that were deleted from the persistent corpus,
+// unless they are ancestors of programs that are still in the corpus.
+func (mgr *Manager) pruneProvenance() {
+	records := mgr.provenanceDB.Records
+	keep := make(map[string]bool)
+	for key := range mgr.corpusDB.Records {
+		for ; key != "" && !keep[key]; key = provenanceParent(records, key) {
+			keep[key] = true
+		}
+	}
+	for key := range records {
+		if !keep[key] {
+			mgr.provenanceDB.Delete(key)
+		}
+	}
+	if err := mgr.provenanceDB.Flush(); err != nil {
+		log.Logf(0, "failed to save corpus provenance database: %v", err)
+	}
+}
+
+func provenanceParent(records map[string]db.Record, key string) string {
+	if prov := db.LoadProvenance(records, key); prov != nil {
+		return prov.Parent
+	}
+	return ""
+}
+
+// collectCorpusProgram collects provenance and contribution of the corpus program
+// with hash (or unique hash prefix) sig for the corpus browser.
+func (mgr *Manager) collectCorpusProgram(sig string) (*UICorpusProgram, error) {
+	key, err := mgr.findCorpusProgram(sig)
+	if err != nil {
+		return nil, err
+	}
+	data := &UICorpusProgram{
+		Name: mgr.cfg.Name,
+		Sig:  key,
+	}
+	if inp, ok := mgr.corpus[key]; ok {
+		data.InCorpus = true
+		data.Prog = string(inp.Prog)
+		data.Call = inp.Call
+		data.Signal = inp.Signal.Deserialize().Len()
+		data.Cover = len(inp.Cover)
+		// Coverage that no other corpus program gives.
+		unique := make(map[uint32]bool, len(inp.Cover))
+		for _, pc := range inp.Cover {
+			unique[pc] = true
+		}
+		for sig1, inp1 := range mgr.corpus {
+			if sig1 == key {
+				continue
+			}
+			for _, pc := range inp1.Cover {
+				delete(unique, pc)
+			}
+		}
+		data.UniqueCover = len(unique)
+	} else if rec, ok := mgr.corpusDB.Records[key]; ok {
+		data.Prog = string(rec.Val)
+	}
+	if data.Prog != "" {
+		p, err := mgr.target.Deserialize([]byte(data.Prog), prog.NonStrict)
+		if err != nil {
+			return nil, fmt.Errorf("failed to deserialize program: %v", err)
+		}
+		for _, c := range p.Calls {
+			data.Calls = append(data.Calls, c.Meta.Name)
+		}
+	}
+	for i, prov := range db.ProvenanceChain(mgr.provenanceDB.Records, key) {
+		if i != 0 {
+			key = data.Provenance[i-1].Parent
+		}
+		_, inCorpus := mgr.corpus[key]
+		data.Provenance = append(data.Provenance, &UIProvenance{
+			Sig:        key,
+			InCorpus:   inCorpus,
+			Provenance: prov,
+		})
+	}
+	return data, nil
+}
+
+// findCorpusProgram returns hash of the corpus program or of the program with provenance
+// (e.g. an ancestor that was deleted from corpus) with the given hash or unique hash prefix.
+func (mgr *Manager) findCorpusProgram(sig string) (string, error) {
+	if len(sig) < 4 {
+		return "", fmt.Errorf("program hash %q is too short", sig)
+	}
+	found := make(map[string]bool)
+	for _, records := range []map[string]db.Record{mgr.corpusDB.Records, mgr.provenanceDB.Records} {
+		for key := range records {
+			if strings.HasPrefix(key, sig) {
+				found[key] = true
+			}
+		}
+	}
+	for key := range mgr.corpus {
+		if strings.HasPrefix(key, sig) {
+			found[key] = true
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("can't find program %v", sig)
+	case 1:
+		for key := range found {
+			return key, nil
+		}
+	}
+	return "", fmt.Errorf("program hash prefix %v is ambiguous (%v programs)", sig, len(found))
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/db"
+	"github.com/google/syzkaller/pkg/hash"
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/rpctype"
+	"github.com/google/syzkaller/prog"
+)
+
+func TestProvenance(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "syz-manager-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	corpusDB, err := db.Open(filepath.Join(dir, "corpus.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	provenanceDB, err := db.Open(db.ProvenanceFile(filepath.Join(dir, "corpus.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := &Manager{
+		cfg:          &mgrconfig.Config{Name: "test"},
+		target:       target,
+		corpusDB:     corpusDB,
+		provenanceDB: provenanceDB,
+		corpus:       make(map[string]rpctype.RPCInput),
+	}
+	add := func(data string, inp rpctype.RPCInput) string {
+		sig := hash.String([]byte(data))
+		inp.Prog = []byte(data)
+		inp.Cover = []uint32{uint32(len(mgr.corpus)), 1000}
+		mgr.corpus[sig] = inp
+		mgr.corpusDB.Save(sig, inp.Prog, 0)
+		mgr.recordProvenance(sig, &inp, 10, 5)
+		return sig
+	}
+	gen := add("getpid()\n", rpctype.RPCInput{Call: "getpid", Origin: rpctype.OriginGenerate})
+	mut := add("getpid()\ngetuid()\n", rpctype.RPCInput{Call: "getuid", Origin: rpctype.OriginMutate, Parent: gen})
+	smash := add("getpid()\ngetuid()\ngetgid()\n",
+		rpctype.RPCInput{Call: "getgid", Origin: rpctype.OriginSmash, Parent: mut})
+	// Re-triaged corpus program keeps the original provenance.
+	mgr.recordProvenance(mut, &rpctype.RPCInput{Call: "getuid", Origin: rpctype.OriginCorpus, Parent: mut}, 1, 1)
+	// Re-minimized corpus program inherits provenance of the old program.
+	remin := add("getgid()\n", rpctype.RPCInput{Call: "getgid", Origin: rpctype.OriginCorpus, Parent: smash})
+
+	data, err := mgr.collectCorpusProgram(smash[:8])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Sig != smash || !data.InCorpus || data.Call != "getgid" || data.Cover != 2 || data.UniqueCover != 1 ||
+		len(data.Calls) != 3 || data.Calls[2] != "getgid" {
+		t.Fatalf("bad program data: %+v", data)
+	}
+	checkChain := func(sig string, want ...string) {
+		data, err := mgr.collectCorpusProgram(sig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Provenance) != len(want)/2 {
+			t.Fatalf("%v: got chain of %v, want %v", sig, len(data.Provenance), len(want)/2)
+		}
+		for i, prov := range data.Provenance {
+			if prov.Sig != want[2*i] || prov.Origin != want[2*i+1] {
+				t.Fatalf("%v: chain element %v: got %v/%v, want %v/%v",
+					sig, i, prov.Sig, prov.Origin, want[2*i], want[2*i+1])
+			}
+		}
+	}
+	checkChain(smash, smash, rpctype.OriginSmash, mut, rpctype.OriginMutate, gen, rpctype.OriginGenerate)
+	checkChain(remin, remin, rpctype.OriginSmash, mut, rpctype.OriginMutate, gen, rpctype.OriginGenerate)
+	if prov := db.LoadProvenance(provenanceDB.Records, remin); prov.Replaced != smash || prov.Signal != 10 {
+		t.Fatalf("bad provenance of re-minimized program: %+v", prov)
+	}
+	if prov := db.LoadProvenance(provenanceDB.Records, mut); prov.Origin != rpctype.OriginMutate || prov.Signal != 10 {
+		t.Fatalf("bad provenance of re-triaged program: %+v", prov)
+	}
+
+	// Ancestors of corpus programs are kept after they are deleted from corpus.
+	delete(mgr.corpus, smash)
+	mgr.corpusDB.Delete(smash)
+	delete(mgr.corpus, mut)
+	mgr.corpusDB.Delete(mut)
+	mgr.pruneProvenance()
+	for sig, keep := range map[string]bool{gen: true, mut: true, smash: false, remin: true} {
+		if _, ok := provenanceDB.Records[sig]; ok != keep {
+			t.Errorf("provenance of %v: present %v, want %v", sig, ok, keep)
+		}
+	}
+	checkChain(mut, mut, rpctype.OriginMutate, gen, rpctype.OriginGenerate)
+	if _, err := mgr.collectCorpusProgram(smash); err == nil {
+		t.Fatalf("found deleted program without provenance")
+	}
+}
@@ -0,0 +1,363 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/cover"
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/rpctype"
+	"github.com/google/syzkaller/pkg/signal"
+	"github.com/google/syzkaller/prog"
+)
+
+// Corpus programs can be replayed from the corpus program page (/replay): the program is executed
+// once in one of the fuzzing VMs with coverage collection, and its coverage is compared with
+// the coverage recorded when the program was added to corpus. This shows if the program still
+// exercises the code it was saved for. Results are cached per program and kernel build (tag config).
+
+const (
+	maxReplays    = 100              // number of cached replay results
+	replayTimeout = 10 * time.Minute // replays without result after that are considered lost
+)
+
+type corpusReplays struct {
+	mu      sync.Mutex
+	replays map[string]*corpusReplay // replayKey -> replay
+	pending []*corpusReplay          // replays that are not yet sent to fuzzers
+	seq     int                      // id of the next replay
+}
+
+type corpusReplay struct {
+	id        int
+	sig       string
+	build     string
+	prog      []byte
+	calls     []string      // names of the program calls
+	call      string        // the call that gave new signal when the program was added to corpus
+	cover     []uint32      // recorded coverage of the call
+	signal    signal.Serial // recorded signal of the call
+	requested time.Time
+	sent      time.Time // zero if not yet sent to a fuzzer
+	vm        string
+	finished  time.Time // zero if not yet finished
+	err       string
+	res       *rpctype.ReplayDoneArgs
+	diff      *UIReplayDiff // symbolized diff (computed on the first request)
+}
+
+func newCorpusReplays() *corpusReplays {
+	return &corpusReplays{
+		replays: make(map[string]*corpusReplay),
+	}
+}
+
+func replayKey(sig, build string) string {
+	return sig + "/" + build
+}
+
+// requestReplay schedules replay of the corpus program sig, unless there is already a replay
+// of the program on the current kernel build (a failed replay or force cause a new replay).
+func (mgr *Manager) requestReplay(sig string, force bool) (*corpusReplay, error) {
+	inp, ok := mgr.corpus[sig]
+	if !ok {
+		return nil, fmt.Errorf("program %v is not in corpus", sig)
+	}
+	p, err := mgr.target.Deserialize(inp.Prog, prog.NonStrict)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize program: %v", err)
+	}
+	rp := mgr.replays
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	key := replayKey(sig, mgr.cfg.Tag)
+	if replay := rp.replays[key]; replay != nil && !force && replay.status(time.Now()) != replayFailed {
+		return replay, nil
+	}
+	replay := &corpusReplay{
+		id:        rp.seq,
+		sig:       sig,
+		build:     mgr.cfg.Tag,
+		prog:      inp.Prog,
+		call:      inp.Call,
+		cover:     inp.Cover,
+		signal:    inp.Signal,
+		requested: time.Now(),
+	}
+	for _, c := range p.Calls {
+		replay.calls = append(replay.calls, c.Meta.Name)
+	}
+	rp.seq++
+	rp.replays[key] = replay
+	rp.pending = append(rp.pending, replay)
+	rp.evict()
+	log.Logf(1, "scheduled replay %v of corpus program %v", replay.id, sig)
+	return replay, nil
+}
+
+// evict deletes the oldest finished replays if there are more than maxReplays.
+func (rp *corpusReplays) evict() {
+	for len(rp.replays) > maxReplays {
+		var oldest string
+		for key, replay := range rp.replays {
+			if replay.finished.IsZero() {
+				continue
+			}
+			if oldest == "" || replay.id < rp.replays[oldest].id {
+				oldest = key
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		delete(rp.replays, oldest)
+	}
+}
+
+// next returns a pending replay for the fuzzer (at most one per poll).
+func (rp *corpusReplays) next(name string) []rpctype.RPCReplay {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if len(rp.pending) == 0 {
+		return nil
+	}
+	replay := rp.pending[0]
+	rp.pending = rp.pending[1:]
+	replay.sent = time.Now()
+	replay.vm = name
+	return []rpctype.RPCReplay{{ID: replay.id, Prog: replay.prog}}
+}
+
+func (mgr *Manager) ReplayDone(a *rpctype.ReplayDoneArgs, r *int) error {
+	rp := mgr.replays
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	for _, replay := range rp.replays {
+		if replay.id != a.ID {
+			continue
+		}
+		log.Logf(1, "%v: replay %v finished: %v", a.Name, a.ID, a.Error)
+		replay.finished = time.Now()
+		replay.vm = a.Name
+		replay.err = a.Error
+		if a.Error == "" {
+			replay.res = a
+		}
+		return nil
+	}
+	// The replay was evicted or superseded by a forced replay.
+	return nil
+}
+
+const (
+	replayPending  = "pending"
+	replayRunning  = "running"
+	replayFailed   = "failed"
+	replayFinished = "finished"
+)
+
+func (replay *corpusReplay) status(now time.Time) string {
+	switch {
+	case replay.err != "":
+		return replayFailed
+	case !replay.finished.IsZero():
+		return replayFinished
+	case replay.sent.IsZero():
+		return replayPending
+	case now.Sub(replay.sent) > replayTimeout:
+		// The VM has probably crashed while executing the program.
+		return replayFailed
+	default:
+		return replayRunning
+	}
+}
+
+// diffReplay compares coverage of the replayed program with the recorded coverage.
+// Coverage of all calls with the same name as the recorded call is taken into account,
+// since the recorded coverage does not identify the call index.
+// New and lost PCs are grouped by source file using symbolize (it may be nil).
+func diffReplay(replay *corpusReplay, symbolize func(pcs []uint32) (map[uint32]cover.Location, error)) *UIReplayDiff {
+	recorded := make(map[uint32]bool)
+	for _, pc := range replay.cover {
+		recorded[pc] = true
+	}
+	replayed := make(map[uint32]bool)
+	var replaySignal signal.Signal
+	for i, name := range replay.calls {
+		if name != replay.call || i >= len(replay.res.Cover) || i >= len(replay.res.Signal) {
+			continue
+		}
+		for _, pc := range replay.res.Cover[i] {
+			replayed[pc] = true
+		}
+		replaySignal.Merge(replay.res.Signal[i].Deserialize())
+	}
+	recordedSignal := replay.signal.Deserialize()
+	diff := &UIReplayDiff{
+		Signal:       recordedSignal.Len(),
+		ReplaySignal: replaySignal.Len(),
+		LostSignal:   replaySignal.Diff(recordedSignal).Len(),
+		Cover:        len(recorded),
+		ReplayCover:  len(replayed),
+	}
+	var newPCs, lostPCs []uint32
+	for pc := range replayed {
+		if !recorded[pc] {
+			newPCs = append(newPCs, pc)
+		}
+	}
+	for pc := range recorded {
+		if !replayed[pc] {
+			lostPCs = append(lostPCs, pc)
+		}
+	}
+	var locs map[uint32]cover.Location
+	if symbolize != nil && len(newPCs)+len(lostPCs) != 0 {
+		var err error
+		if locs, err = symbolize(append(append([]uint32{}, newPCs...), lostPCs...)); err != nil {
+			diff.SymbolizeError = err.Error()
+		}
+	}
+	diff.New = groupReplayPCs(newPCs, locs)
+	diff.Lost = groupReplayPCs(lostPCs, locs)
+	return diff
+}
+
+// groupReplayPCs groups pcs by source file, PCs without location are grouped under an empty file name.
+func groupReplayPCs(pcs []uint32, locs map[uint32]cover.Location) []*UIReplayFile {
+	sort.Slice(pcs, func(i, j int) bool { return pcs[i] < pcs[j] })
+	type line struct {
+		fn   string
+		line int
+	}
+	files := make(map[string]*UIReplayFile)
+	lines := make(map[string]map[line]bool)
+	for _, pc := range pcs {
+		loc, ok := locs[pc]
+		if !ok {
+			loc = cover.Location{Func: fmt.Sprintf("0x%x", pc)}
+		}
+		f := files[loc.File]
+		if f == nil {
+			f = &UIReplayFile{File: loc.File}
+			files[loc.File] = f
+			lines[loc.File] = make(map[line]bool)
+		}
+		f.PCs++
+		if ln := (line{loc.Func, loc.Line}); !lines[loc.File][ln] {
+			lines[loc.File][ln] = true
+			if ok {
+				f.Lines = append(f.Lines, fmt.Sprintf("%v:%v", loc.Func, loc.Line))
+			} else {
+				f.Lines = append(f.Lines, loc.Func)
+			}
+		}
+	}
+	var res []*UIReplayFile
+	for _, f := range files {
+		res = append(res, f)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].File < res[j].File })
+	return res
+}
+
+// collectReplay returns the replay of the corpus program sig on the current kernel build
+// (nil if the program was not replayed), the coverage diff is computed once the replay is finished.
+func (mgr *Manager) collectReplay(sig string) *UIReplay {
+	rp := mgr.replays
+	rp.mu.Lock()
+	replay := rp.replays[replayKey(sig, mgr.cfg.Tag)]
+	if replay == nil {
+		rp.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	data := &UIReplay{
+		Name:      mgr.cfg.Name,
+		Sig:       replay.sig,
+		Build:     replay.build,
+		ID:        replay.id,
+		Status:    replay.status(now),
+		Error:     replay.err,
+		VM:        replay.vm,
+		Requested: replay.requested,
+		Finished:  replay.finished,
+		Call:      replay.call,
+		Diff:      replay.diff,
+	}
+	if data.Status == replayFailed && data.Error == "" {
+		data.Error = fmt.Sprintf("no result from %v in %v", replay.vm, replayTimeout)
+	}
+	rp.mu.Unlock()
+	if data.Status != replayFinished || data.Diff != nil {
+		return data
+	}
+	// Symbolization may be slow, so it's done without holding the lock.
+	var symbolize func(pcs []uint32) (map[uint32]cover.Location, error)
+	if mgr.cfg.KernelObj != "" {
+		symbolize = func(pcs []uint32) (map[uint32]cover.Location, error) {
+			return symbolizeCover(mgr.cfg.KernelObj, mgr.sysTarget.KernelObject,
+				mgr.cfg.KernelSrc, mgr.cfg.TargetVMArch, pcs)
+		}
+	}
+	data.Diff = diffReplay(replay, symbolize)
+	if symbolize == nil {
+		data.Diff.SymbolizeError = "no kernel_obj in config file"
+	}
+	rp.mu.Lock()
+	replay.diff = data.Diff
+	rp.mu.Unlock()
+	return data
+}
+
+// httpReplay schedules replay of a corpus program (POST sig=hash, force=1 to replay again)
+// and shows the result (GET sig=hash, json=1 returns the result as JSON).
+func (mgr *Manager) httpReplay(w http.ResponseWriter, r *http.Request) {
+	sig := strings.TrimSpace(r.FormValue("sig"))
+	mgr.mu.Lock()
+	key, err := mgr.findCorpusProgram(sig)
+	if err == nil && r.Method == http.MethodPost {
+		_, err = mgr.requestReplay(key, r.FormValue("force") != "")
+	}
+	mgr.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodPost {
+		http.Redirect(w, r, "/replay?sig="+url.QueryEscape(key), http.StatusSeeOther)
+		return
+	}
+	data := mgr.collectReplay(key)
+	if data == nil {
+		data = &UIReplay{
+			Name:   mgr.cfg.Name,
+			Sig:    key,
+			Build:  mgr.cfg.Tag,
+			Status: "not replayed",
+		}
+	}
+	if r.FormValue("json") != "" {
+		res, err := json.MarshalIndent(data, "", "\t")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to marshal replay: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(res)
+		return
+	}
+	if err := replayTemplate.Execute(w, data); err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
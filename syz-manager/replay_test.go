@@ -0,0 +1,138 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/cover"
+	"github.com/google/syzkaller/pkg/hash"
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/rpctype"
+	"github.com/google/syzkaller/pkg/signal"
+	"github.com/google/syzkaller/prog"
+)
+
+func TestReplay(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := &Manager{
+		cfg:     &mgrconfig.Config{Name: "test", Tag: "build1"},
+		target:  target,
+		corpus:  make(map[string]rpctype.RPCInput),
+		replays: newCorpusReplays(),
+	}
+	data := []byte("getpid()\ngetuid()\ngetpid()\n")
+	sig := hash.String(data)
+	mgr.corpus[sig] = rpctype.RPCInput{
+		Call:   "getpid",
+		Prog:   data,
+		Signal: signal.FromRaw([]uint32{1, 2, 3}, 0).Serialize(),
+		Cover:  []uint32{10, 20, 30},
+	}
+	if _, err := mgr.requestReplay("foo", false); err == nil {
+		t.Fatalf("replayed a program that is not in corpus")
+	}
+	replay, err := mgr.requestReplay(sig, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := mgr.collectReplay(sig); data == nil || data.Status != replayPending {
+		t.Fatalf("bad replay status: %+v", data)
+	}
+	// The pending replay is reused.
+	if replay1, err := mgr.requestReplay(sig, false); err != nil || replay1 != replay {
+		t.Fatalf("replay is not reused: %v", err)
+	}
+	replays := mgr.replays.next("vm-0")
+	if len(replays) != 1 || replays[0].ID != replay.id || string(replays[0].Prog) != string(data) {
+		t.Fatalf("bad replays: %+v", replays)
+	}
+	if replays := mgr.replays.next("vm-1"); len(replays) != 0 {
+		t.Fatalf("replay is sent twice")
+	}
+	if data := mgr.collectReplay(sig); data.Status != replayRunning || data.VM != "vm-0" {
+		t.Fatalf("bad replay status: %+v", data)
+	}
+	err = mgr.ReplayDone(&rpctype.ReplayDoneArgs{
+		Name:  "vm-0",
+		ID:    replay.id,
+		Cover: [][]uint32{{10, 40}, {50}, {20}},
+		Signal: []signal.Serial{
+			signal.FromRaw([]uint32{1, 4}, 0).Serialize(),
+			signal.FromRaw([]uint32{5}, 0).Serialize(),
+			signal.FromRaw([]uint32{2}, 0).Serialize(),
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := mgr.collectReplay(sig)
+	if res.Status != replayFinished || res.Diff == nil {
+		t.Fatalf("bad replay status: %+v", res)
+	}
+	diff := res.Diff
+	if diff.Signal != 3 || diff.ReplaySignal != 3 || diff.LostSignal != 1 ||
+		diff.Cover != 3 || diff.ReplayCover != 3 {
+		t.Fatalf("bad diff: %+v", diff)
+	}
+	if len(diff.New) != 1 || !reflect.DeepEqual(diff.New[0].Lines, []string{"0x28"}) ||
+		len(diff.Lost) != 1 || !reflect.DeepEqual(diff.Lost[0].Lines, []string{"0x1e"}) {
+		t.Fatalf("bad diff: new %+v, lost %+v", diff.New, diff.Lost)
+	}
+	if err := replayTemplate.Execute(ioutil.Discard, res); err != nil {
+		t.Fatal(err)
+	}
+	// The result is cached for the build, but can be forced.
+	if replay1, err := mgr.requestReplay(sig, false); err != nil || replay1 != replay {
+		t.Fatalf("replay is not cached: %v", err)
+	}
+	if replay1, err := mgr.requestReplay(sig, true); err != nil || replay1 == replay {
+		t.Fatalf("replay is not forced: %v", err)
+	}
+	// A new kernel build needs a new replay.
+	mgr.cfg.Tag = "build2"
+	if data := mgr.collectReplay(sig); data != nil {
+		t.Fatalf("got replay for another build: %+v", data)
+	}
+}
+
+func TestDiffReplay(t *testing.T) {
+	replay := &corpusReplay{
+		calls: []string{"open", "read"},
+		call:  "read",
+		cover: []uint32{1, 2, 3, 4},
+		res: &rpctype.ReplayDoneArgs{
+			Cover:  [][]uint32{{100}, {1, 2, 5, 6, 7}},
+			Signal: []signal.Serial{{}, {}},
+		},
+	}
+	symbolize := func(pcs []uint32) (map[uint32]cover.Location, error) {
+		return map[uint32]cover.Location{
+			3: {Func: "foo", File: "fs/read.c", Line: 10},
+			4: {Func: "foo", File: "fs/read.c", Line: 10},
+			5: {Func: "bar", File: "mm/slab.c", Line: 20},
+			6: {Func: "baz", File: "fs/read.c", Line: 30},
+		}, nil
+	}
+	diff := diffReplay(replay, symbolize)
+	wantNew := []*UIReplayFile{
+		{File: "", PCs: 1, Lines: []string{"0x7"}},
+		{File: "fs/read.c", PCs: 1, Lines: []string{"baz:30"}},
+		{File: "mm/slab.c", PCs: 1, Lines: []string{"bar:20"}},
+	}
+	wantLost := []*UIReplayFile{
+		{File: "fs/read.c", PCs: 2, Lines: []string{"foo:10"}},
+	}
+	if !reflect.DeepEqual(diff.New, wantNew) {
+		t.Errorf("bad new coverage: %+v", diff.New)
+	}
+	if !reflect.DeepEqual(diff.Lost, wantLost) {
+		t.Errorf("bad lost coverage: %+v", diff.Lost)
+	}
+}
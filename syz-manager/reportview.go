@@ -0,0 +1,176 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/syzkaller/pkg/crashdir"
+	"github.com/google/syzkaller/pkg/report"
+)
+
+// UIReport is a crash report prepared for rendering on the crash page: oops lines are highlighted,
+// stack frames link to kernel sources and stacks that repeat an earlier stack are collapsed.
+type UIReport struct {
+	Index  int // index of the crash occurrence
+	Time   time.Time
+	File   string // the raw report file
+	Blocks []*UIReportBlock
+	// Output of Diagnose (e.g. sysrq dumps) printed after the crash, it's not a part of the report.
+	Diagnosis string
+	// Additional information about the VM (see vmimpl.Infoer).
+	Info string
+}
+
+// UIReportBlock is either a stack (consecutive frame lines) or a run of other lines.
+type UIReportBlock struct {
+	Lines []*UIReportLine
+	// The stack is the same as an earlier stack of the report.
+	Repeated bool
+}
+
+type UIReportLine struct {
+	Text   string // whole line, or the part before Location for frames
+	Oops   bool   // the line starts an oops (e.g. "BUG: KASAN: use-after-free in ...")
+	Frame  bool
+	Guilty bool   // the frame is the first frame in the guilty file
+	Link   string // link to the source of the frame (see kernel_source_url)
+	// Source location of the frame ("file:line") and the rest of the line after it.
+	Location string
+	Suffix   string
+}
+
+// Matches lines that start oops messages in reports.
+var oopsLineRe = regexp.MustCompile(`^(?:BUG:|WARNING:|INFO:|KASAN:|KMSAN:|UBSAN:|KCSAN:|` +
+	`general protection fault|kernel BUG|Kernel panic|unreferenced object|divide error|` +
+	`[Uu]nable to handle kernel|Internal error:|panic:|fatal error:)`)
+
+// saveFrames converts report frames into the crashdir format.
+func saveFrames(frames []report.Frame) []crashdir.Frame {
+	var res []crashdir.Frame
+	for _, frame := range frames {
+		res = append(res, crashdir.Frame{
+			Func:       frame.Func,
+			File:       frame.File,
+			Line:       frame.Line,
+			Inline:     frame.Inline,
+			ReportLine: frame.ReportLine,
+		})
+	}
+	return res
+}
+
+// readUIReport prepares the report of the crash occurrence for rendering.
+// Returns nil if the occurrence has no report.
+func readUIReport(dir string, crash *crashdir.Crash, sourceURL string) *UIReport {
+	if crash.Report == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, crash.Report))
+	if err != nil {
+		return nil
+	}
+	guilty := ""
+	var frames []crashdir.Frame
+	if crash.Meta != nil {
+		guilty = crash.Meta.GuiltyFile
+		frames = crash.Meta.Frames
+	}
+	if frames == nil {
+		// Occurrences saved before frames were persisted, the report is symbolized anyway.
+		frames = saveFrames(report.ParseFrames(data))
+	}
+	rep := &UIReport{
+		Index:  crash.Index,
+		Time:   crash.Time,
+		File:   filepath.Join("crashes", filepath.Base(dir), crash.Report),
+		Blocks: renderReport(data, frames, guilty, sourceURL),
+	}
+	if crash.Diagnosis != "" {
+		if data, err := ioutil.ReadFile(filepath.Join(dir, crash.Diagnosis)); err == nil {
+			rep.Diagnosis = string(data)
+		}
+	}
+	if crash.Meta != nil {
+		rep.Info = crash.Meta.Info
+	}
+	return rep
+}
+
+func renderReport(data []byte, frames []crashdir.Frame, guilty, sourceURL string) []*UIReportBlock {
+	lineFrames := make(map[int]*crashdir.Frame)
+	for i := range frames {
+		lineFrames[frames[i].ReportLine] = &frames[i]
+	}
+	var blocks []*UIReportBlock
+	var cur *UIReportBlock
+	curStack := false
+	stacks := make(map[string]bool)
+	flush := func() {
+		if cur == nil {
+			return
+		}
+		if curStack {
+			sig := stackSignature(cur)
+			cur.Repeated = stacks[sig]
+			stacks[sig] = true
+		}
+		blocks = append(blocks, cur)
+		cur = nil
+	}
+	guiltySeen := false
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for i := 0; s.Scan(); i++ {
+		line := &UIReportLine{Text: s.Text()}
+		if frame := lineFrames[i]; frame != nil {
+			line.Frame = true
+			line.Location = fmt.Sprintf("%v:%v", frame.File, frame.Line)
+			if pos := strings.LastIndex(line.Text, line.Location); pos != -1 {
+				line.Text, line.Suffix = line.Text[:pos], line.Text[pos+len(line.Location):]
+				line.Link = frameLink(sourceURL, frame)
+			} else {
+				line.Location = ""
+			}
+			if !guiltySeen && guilty != "" && frame.File == guilty {
+				line.Guilty = true
+				guiltySeen = true
+			}
+		} else {
+			line.Oops = oopsLineRe.MatchString(strings.TrimSpace(line.Text))
+		}
+		if cur == nil || curStack != line.Frame {
+			flush()
+			cur = new(UIReportBlock)
+			curStack = line.Frame
+		}
+		cur.Lines = append(cur.Lines, line)
+	}
+	flush()
+	return blocks
+}
+
+// stackSignature identifies a stack by its frames regardless of offsets and '?' markers.
+func stackSignature(block *UIReportBlock) string {
+	var sig []string
+	for _, line := range block.Lines {
+		sig = append(sig, line.Location)
+	}
+	return strings.Join(sig, " ")
+}
+
+func frameLink(sourceURL string, frame *crashdir.Frame) string {
+	if sourceURL == "" {
+		return ""
+	}
+	link := strings.Replace(sourceURL, "{file}", frame.File, -1)
+	return strings.Replace(link, "{line}", strconv.Itoa(frame.Line), -1)
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/crashdir"
+	"github.com/google/syzkaller/pkg/report"
+)
+
+const testSymbolizedReport = `BUG: KASAN: use-after-free in pipe_lock+0x56/0x70
+Read of size 8 at addr ffff88006bd4d8e8 by task syz-executor5/12201
+
+Call Trace:
+ __dump_stack lib/dump_stack.c:77 [inline]
+ dump_stack+0x1b9/0x294 lib/dump_stack.c:113
+ pipe_lock+0x56/0x70 fs/pipe.c:70
+ do_splice+0x64a/0x1430 fs/splice.c:1147
+
+Allocated by task 12201:
+ __dump_stack lib/dump_stack.c:77 [inline]
+ dump_stack+0x1b9/0x294 lib/dump_stack.c:113
+ pipe_lock+0x56/0x70 fs/pipe.c:70
+ do_splice+0x64a/0x1430 fs/splice.c:1147
+`
+
+func TestRenderReport(t *testing.T) {
+	frames := saveFrames(report.ParseFrames([]byte(testSymbolizedReport)))
+	if len(frames) != 8 {
+		t.Fatalf("got %v frames, want 8", len(frames))
+	}
+	blocks := renderReport([]byte(testSymbolizedReport), frames, "fs/pipe.c",
+		"https://source/{file}#L{line}")
+	if len(blocks) != 4 {
+		t.Fatalf("got %v blocks, want 4", len(blocks))
+	}
+	oops := blocks[0].Lines[0]
+	if !oops.Oops || oops.Frame || blocks[0].Lines[1].Oops {
+		t.Errorf("bad oops line: %+v", oops)
+	}
+	stack, repeated := blocks[1], blocks[3]
+	if stack.Repeated || !repeated.Repeated || len(stack.Lines) != 4 || len(repeated.Lines) != 4 {
+		t.Errorf("repeated stack is not collapsed: %+v, %+v", stack, repeated)
+	}
+	frame := stack.Lines[1]
+	if !frame.Frame || frame.Text != " dump_stack+0x1b9/0x294 " || frame.Location != "lib/dump_stack.c:113" ||
+		frame.Suffix != "" || frame.Link != "https://source/lib/dump_stack.c#L113" || frame.Guilty {
+		t.Errorf("bad frame line: %+v", frame)
+	}
+	if inline := stack.Lines[0]; inline.Suffix != " [inline]" {
+		t.Errorf("bad inline frame line: %+v", inline)
+	}
+	if !stack.Lines[2].Guilty || repeated.Lines[2].Guilty {
+		t.Errorf("the guilty frame is not highlighted once")
+	}
+	blocks = renderReport([]byte(testSymbolizedReport), frames, "", "")
+	if blocks[1].Lines[1].Link != "" || blocks[1].Lines[1].Location != "lib/dump_stack.c:113" {
+		t.Errorf("frame without source URL: %+v", blocks[1].Lines[1])
+	}
+}
+
+func TestCrashPageReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-manager-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	const title = "KASAN: use-after-free Read in pipe_lock"
+	// The old occurrence has no persisted frames, they are parsed from the report.
+	for _, meta := range []*crashdir.Meta{
+		nil,
+		{Title: title, GuiltyFile: "fs/pipe.c", Info: "vm info",
+			Frames: saveFrames(report.ParseFrames([]byte(testSymbolizedReport)))},
+	} {
+		if _, _, err := crashdir.SaveCrash(dir, title, &crashdir.Occurrence{
+			Log:       []byte("log"),
+			Report:    []byte(testSymbolizedReport),
+			Meta:      meta,
+			Diagnosis: []byte("sysrq output"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, crashdir.ID(title), "log1"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	typ, err := crashdir.Read(dir, crashdir.ID(title))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, crash := range typ.Crashes {
+		rep := readUIReport(filepath.Join(dir, typ.ID), crash, "https://source/{file}#L{line}")
+		if rep == nil || rep.Index != crash.Index || len(rep.Blocks) != 4 || rep.Diagnosis != "sysrq output" {
+			t.Fatalf("bad report of occurrence %v: %+v", crash.Index, rep)
+		}
+		if guilty := rep.Blocks[1].Lines[2].Guilty; guilty != (crash.Index == 1) {
+			t.Errorf("occurrence %v: guilty frame %v", crash.Index, guilty)
+		}
+		ui := makeUICrashType(typ, nil, time.Now())
+		ui.Report = rep
+		buf := new(bytes.Buffer)
+		if err := crashTemplate.Execute(buf, ui); err != nil {
+			t.Fatal(err)
+		}
+		page := buf.String()
+		for _, want := range []string{
+			`<span class="oops">BUG: KASAN: use-after-free in pipe_lock`,
+			`<a href="https://source/fs/pipe.c#L70">fs/pipe.c:70</a>`,
+			`same stack as above (4 frames)`,
+			`sysrq output`,
+		} {
+			if !strings.Contains(page, want) {
+				t.Errorf("occurrence %v: crash page does not contain %q", crash.Index, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/syzkaller/pkg/crashdir"
+	"github.com/google/syzkaller/pkg/hash"
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/osutil"
+	"github.com/google/syzkaller/pkg/rpctype"
+	"github.com/google/syzkaller/prog"
+)
+
+// A new manager for the same kernel family can be seeded with reproducers of sibling managers
+// (sibling_managers config), so that it does not spend days rediscovering bugs they already have
+// reproducers for. Reproducers are injected as triage candidates before the corpus, and crashes
+// caused by them are marked as "seeded from <manager>/<title>" to distinguish rediscovery
+// from genuinely new findings.
+
+// reproBundle is the format of /api/repros and of exported reproducer bundles.
+type reproBundle struct {
+	Name   string        `json:"name"`
+	Repros []bundleRepro `json:"repros"`
+}
+
+type bundleRepro struct {
+	Title string `json:"title"`
+	Prog  string `json:"prog"`
+}
+
+// siblingSeed is a reproducer of a sibling manager injected as a triage candidate.
+type siblingSeed struct {
+	p      *prog.Prog
+	data   []byte
+	origin string // "<manager>/<title>"
+}
+
+const siblingFetchTimeout = time.Minute
+
+// httpRepros exports all reproducers of this manager for sibling managers.
+func (mgr *Manager) httpRepros(w http.ResponseWriter, r *http.Request) {
+	repros, err := readBundleRepros(mgr.crashdir)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read reproducers: %v", err), http.StatusInternalServerError)
+		return
+	}
+	data, err := json.MarshalIndent(&reproBundle{Name: mgr.cfg.Name, Repros: repros}, "", "\t")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to marshal reproducers: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// readBundleRepros returns all syzkaller reproducers saved in crashdir.
+func readBundleRepros(dir string) ([]bundleRepro, error) {
+	types, err := crashdir.List(dir)
+	if err != nil {
+		return nil, err
+	}
+	var repros []bundleRepro
+	for _, typ := range types {
+		if !typ.HasRepro {
+			continue
+		}
+		if repro := crashdir.ReadRepro(dir, typ.ID); repro != nil {
+			repros = append(repros, bundleRepro{Title: typ.Title, Prog: string(repro.Prog)})
+		}
+	}
+	return repros, nil
+}
+
+// fetchSiblingRepros returns reproducers of a sibling manager. The sibling is either a local
+// path to an exported bundle (saved output of /api/repros) or to the crashes dir of the sibling
+// workdir, or the HTTP address of the sibling manager.
+func fetchSiblingRepros(sibling string) (*reproBundle, error) {
+	bundle := new(reproBundle)
+	if osutil.IsExist(sibling) {
+		if repros, err := readBundleRepros(sibling); err == nil {
+			bundle.Name = filepath.Base(filepath.Dir(osutil.Abs(sibling)))
+			bundle.Repros = repros
+			return bundle, nil
+		}
+		data, err := ioutil.ReadFile(sibling)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, bundle); err != nil {
+			return nil, fmt.Errorf("failed to parse %v: %v", sibling, err)
+		}
+	} else {
+		addr := sibling
+		if !strings.Contains(addr, "://") {
+			addr = "http://" + addr
+		}
+		client := &http.Client{Timeout: siblingFetchTimeout}
+		resp, err := client.Get(strings.TrimSuffix(addr, "/") + "/api/repros")
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(data)))
+		}
+		if err := json.Unmarshal(data, bundle); err != nil {
+			return nil, fmt.Errorf("failed to parse reproducers: %v", err)
+		}
+	}
+	if bundle.Name == "" {
+		bundle.Name = sibling
+	}
+	return bundle, nil
+}
+
+// loadSiblingSeeds fetches reproducers of sibling managers and validates them against the target.
+func (mgr *Manager) loadSiblingSeeds() {
+	seen := make(map[string]bool)
+	for _, sibling := range mgr.cfg.SiblingManagers {
+		bundle, err := fetchSiblingRepros(sibling)
+		if err != nil {
+			log.Logf(0, "failed to fetch reproducers of sibling manager %v: %v", sibling, err)
+			continue
+		}
+		dropped := 0
+		for _, repro := range bundle.Repros {
+			p, err := mgr.target.Deserialize([]byte(repro.Prog), prog.NonStrict)
+			if err != nil || len(p.Calls) == 0 {
+				dropped++
+				continue
+			}
+			data := p.Serialize()
+			sig := hash.String(data)
+			if seen[sig] {
+				continue
+			}
+			seen[sig] = true
+			origin := bundle.Name + "/" + repro.Title
+			mgr.siblingSeeds = append(mgr.siblingSeeds, &siblingSeed{p: p, data: data, origin: origin})
+			mgr.seedOrigins[sig] = origin
+		}
+		log.Logf(0, "sibling manager %v: %v reproducers (%v dropped)",
+			bundle.Name, len(bundle.Repros)-dropped, dropped)
+	}
+}
+
+// injectSiblingSeeds adds reproducers of sibling managers to candidates,
+// so that they are triaged before anything else.
+func (mgr *Manager) injectSiblingSeeds(syscalls map[int]bool) {
+	injected := 0
+	for _, seed := range mgr.siblingSeeds {
+		disabled := false
+		for _, c := range seed.p.Calls {
+			if !syscalls[c.Meta.ID] {
+				disabled = true
+				break
+			}
+		}
+		if disabled {
+			continue
+		}
+		mgr.candidates = append(mgr.candidates, rpctype.RPCCandidate{
+			Prog:      seed.data,
+			Minimized: false,
+			Smashed:   false,
+			Origin:    rpctype.OriginSibling,
+		})
+		injected++
+	}
+	mgr.siblingSeeds = nil
+	if injected != 0 {
+		log.Logf(0, "%-24v: %v", "sibling reproducers", injected)
+	}
+}
+
+// seededFrom returns the origin of the sibling reproducer that caused the crash with the given
+// console output (the last executed program that is a sibling reproducer), or "".
+func (mgr *Manager) seededFrom(output []byte) string {
+	if len(mgr.seedOrigins) == 0 {
+		return ""
+	}
+	entries := mgr.target.ParseLog(output)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if origin := mgr.seedOrigins[hash.String(entries[i].P.Serialize())]; origin != "" {
+			return origin
+		}
+	}
+	return ""
+}
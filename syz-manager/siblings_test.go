@@ -0,0 +1,92 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/crashdir"
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/prog"
+)
+
+func TestSiblingSeeds(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "syz-manager-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	siblingCrashes := filepath.Join(dir, "sibling", "crashes")
+	const (
+		title = "WARNING in foo"
+		repro = "r0 = openat(0xffffffffffffff9c, &(0x7f0000000000)='./file0\\x00', 0x0, 0x0)\nclose(r0)\n"
+	)
+	if _, _, err := crashdir.SaveCrash(siblingCrashes, title, &crashdir.Occurrence{Log: []byte("log")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := crashdir.SaveRepro(siblingCrashes, title, &crashdir.Repro{
+		Prog: []byte("# {Threaded:false Collide:false}\n" + repro),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := crashdir.SaveRepro(siblingCrashes, "broken", &crashdir.Repro{Prog: []byte("foo$bar()\n")}); err != nil {
+		t.Fatal(err)
+	}
+	sibling := &Manager{
+		cfg:      &mgrconfig.Config{Name: "sibling"},
+		crashdir: siblingCrashes,
+	}
+	server := httptest.NewServer(http.HandlerFunc(sibling.httpRepros))
+	defer server.Close()
+	w := httptest.NewRecorder()
+	sibling.httpRepros(w, httptest.NewRequest("GET", "/api/repros", nil))
+	bundleFile := filepath.Join(dir, "bundle.json")
+	if err := ioutil.WriteFile(bundleFile, w.Body.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		sibling string
+		origin  string
+	}{
+		{siblingCrashes, "sibling/" + title},
+		{bundleFile, "sibling/" + title},
+		{strings.TrimPrefix(server.URL, "http://"), "sibling/" + title},
+	} {
+		mgr := &Manager{
+			cfg:         &mgrconfig.Config{SiblingManagers: []string{test.sibling, filepath.Join(dir, "none")}},
+			target:      target,
+			seedOrigins: make(map[string]string),
+		}
+		mgr.loadSiblingSeeds()
+		if len(mgr.siblingSeeds) != 1 || mgr.siblingSeeds[0].origin != test.origin {
+			t.Fatalf("%v: bad seeds: %+v", test.sibling, mgr.siblingSeeds)
+		}
+		syscalls := make(map[int]bool)
+		for _, c := range mgr.siblingSeeds[0].p.Calls {
+			syscalls[c.Meta.ID] = true
+		}
+		mgr.injectSiblingSeeds(syscalls)
+		if len(mgr.candidates) != 1 || string(mgr.candidates[0].Prog) != repro {
+			t.Fatalf("%v: bad candidates: %+v", test.sibling, mgr.candidates)
+		}
+		output := "executing program 0:\n" + repro + "\nWARNING: CPU: 0 PID: 1 at foo.c:1 foo\n"
+		if origin := mgr.seededFrom([]byte(output)); origin != test.origin {
+			t.Fatalf("%v: crash seeded from %q, want %q", test.sibling, origin, test.origin)
+		}
+		if origin := mgr.seededFrom([]byte("executing program 0:\nclose(0x0)\n")); origin != "" {
+			t.Fatalf("%v: unrelated crash seeded from %q", test.sibling, origin)
+		}
+	}
+}
@@ -13,6 +13,12 @@ type Stats struct {
 	crashes          Stat
 	crashTypes       Stat
 	crashSuppressed  Stat
+	crashCooldown    Stat
+	crashThrottled   Stat
+	crashImported    Stat
+	securityEvents   Stat
+	bootWarnings     Stat
+	focusHits        Stat
 	vmRestarts       Stat
 	newInputs        Stat
 	execTotal        Stat
@@ -23,6 +29,13 @@ type Stats struct {
 	hubRecvProgDrop  Stat
 	hubRecvRepro     Stat
 	hubRecvReproDrop Stat
+
+	foreignRecvProg     Stat
+	foreignRecvProgFail Stat
+	foreignDroppedCalls Stat
+
+	slowProfiles     Stat
+	perfGateFailures Stat
 }
 
 func (stats *Stats) all() map[string]uint64 {
@@ -30,6 +43,12 @@ func (stats *Stats) all() map[string]uint64 {
 		"crashes":              stats.crashes.get(),
 		"crash types":          stats.crashTypes.get(),
 		"suppressed":           stats.crashSuppressed.get(),
+		"cooldown crashes":     stats.crashCooldown.get(),
+		"throttled crashes":    stats.crashThrottled.get(),
+		"imported crashes":     stats.crashImported.get(),
+		"security events":      stats.securityEvents.get(),
+		"boot warnings":        stats.bootWarnings.get(),
+		"focus hits":           stats.focusHits.get(),
 		"vm restarts":          stats.vmRestarts.get(),
 		"manager new inputs":   stats.newInputs.get(),
 		"exec total":           stats.execTotal.get(),
@@ -40,6 +59,11 @@ func (stats *Stats) all() map[string]uint64 {
 		"hub: recv prog drop":  stats.hubRecvProgDrop.get(),
 		"hub: recv repro":      stats.hubRecvRepro.get(),
 		"hub: recv repro drop": stats.hubRecvReproDrop.get(),
+		"foreign: recv prog":   stats.foreignRecvProg.get(),
+		"foreign: recv fail":   stats.foreignRecvProgFail.get(),
+		"foreign: drop calls":  stats.foreignDroppedCalls.get(),
+		"slow profiles":        stats.slowProfiles.get(),
+		"perf gate failures":   stats.perfGateFailures.get(),
 	}
 }
 
@@ -0,0 +1,123 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/report"
+)
+
+// scopedSuppressions implements scoped_suppressions config: suppression rules that are
+// limited to some VM instances and/or kernels (e.g. a known hardware-triggered oops
+// that is expected on one board of a mixed pool, but must be reported on all others).
+type scopedSuppressions struct {
+	rules []*scopedSuppression
+
+	mu   sync.Mutex
+	hits []uint64 // number of crashes suppressed by each rule
+}
+
+type scopedSuppression struct {
+	cfg       mgrconfig.ScopedSuppression
+	title     *regexp.Regexp
+	kernel    *regexp.Regexp // nil means any kernel
+	instances map[int]bool   // nil means all instances
+}
+
+func newScopedSuppressions(cfgs []mgrconfig.ScopedSuppression) (*scopedSuppressions, error) {
+	ss := &scopedSuppressions{
+		hits: make([]uint64, len(cfgs)),
+	}
+	for _, cfg := range cfgs {
+		rule := &scopedSuppression{cfg: cfg}
+		var err error
+		if rule.title, err = regexp.Compile(cfg.Title); err != nil {
+			return nil, err
+		}
+		if cfg.Kernel != "" {
+			if rule.kernel, err = regexp.Compile(cfg.Kernel); err != nil {
+				return nil, err
+			}
+		}
+		if rule.instances, err = mgrconfig.ParseInstances(cfg.Instances); err != nil {
+			return nil, err
+		}
+		ss.rules = append(ss.rules, rule)
+	}
+	return ss, nil
+}
+
+// match returns description of the first rule that suppresses the crash from the VM
+// (vmIndex is -1 for external crashes) and counts the hit. Returns "" if no rule matches.
+func (ss *scopedSuppressions) match(vmIndex int, rep *report.Report) string {
+	release, releaseDone := "", false
+	for i, rule := range ss.rules {
+		if !rule.title.MatchString(rep.Title) {
+			continue
+		}
+		if rule.instances != nil && !rule.instances[vmIndex] {
+			continue
+		}
+		if rule.kernel != nil {
+			if !releaseDone {
+				release = kernelRelease(rep)
+				releaseDone = true
+			}
+			if release == "" || !rule.kernel.MatchString(release) {
+				continue
+			}
+		}
+		ss.mu.Lock()
+		ss.hits[i]++
+		ss.mu.Unlock()
+		return fmt.Sprintf("#%v %v", i, rule)
+	}
+	return ""
+}
+
+func (rule *scopedSuppression) String() string {
+	str := fmt.Sprintf("title=%q", rule.cfg.Title)
+	if rule.cfg.Instances != "" {
+		str += fmt.Sprintf(" instances=%q", rule.cfg.Instances)
+	}
+	if rule.cfg.Kernel != "" {
+		str += fmt.Sprintf(" kernel=%q", rule.cfg.Kernel)
+	}
+	return str
+}
+
+// status returns the rules with the number of hits for the UI.
+func (ss *scopedSuppressions) status() []UIScopedSuppression {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	var res []UIScopedSuppression
+	for i, rule := range ss.rules {
+		res = append(res, UIScopedSuppression{
+			Title:     rule.cfg.Title,
+			Instances: rule.cfg.Instances,
+			Kernel:    rule.cfg.Kernel,
+			Hits:      ss.hits[i],
+		})
+	}
+	return res
+}
+
+// kernelReleaseRe matches the kernel release in the "CPU: 0 PID: 1 Comm: foo Not tainted 4.19.0-rc5+ #1"
+// line of linux oopses (or "Tainted: G        W         4.19.0-rc5+ #1").
+var kernelReleaseRe = regexp.MustCompile(`(?:Not tainted|Tainted: [A-Z ]+?) +([0-9][^ \n]*) #`)
+
+// kernelRelease extracts the kernel release from the crash report (or the whole output).
+// Returns "" if the release is not found.
+func kernelRelease(rep *report.Report) string {
+	for _, data := range [][]byte{rep.Report, rep.Output} {
+		if match := kernelReleaseRe.FindSubmatch(data); match != nil {
+			return string(match[1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/report"
+)
+
+func TestScopedSuppressions(t *testing.T) {
+	ss, err := newScopedSuppressions([]mgrconfig.ScopedSuppression{
+		{Title: "^WARNING in board_reset", Instances: "2-3"},
+		{Title: "^INFO: task hung", Kernel: `^4\.14\.`},
+		{Title: "^KASAN: .* in foo$"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		release414 = "CPU: 1 PID: 1 Comm: syz-executor0 Not tainted 4.14.71+ #5\n"
+		release419 = "CPU: 0 PID: 2 Comm: swapper/0 Tainted: G        W         4.19.0-rc5+ #37\n"
+	)
+	tests := []struct {
+		vmIndex int
+		title   string
+		report  string
+		output  string
+		rule    string
+	}{
+		{2, "WARNING in board_reset", "", "", `#0 title="^WARNING in board_reset" instances="2-3"`},
+		{3, "WARNING in board_reset", "", "", `#0 title="^WARNING in board_reset" instances="2-3"`},
+		{1, "WARNING in board_reset", "", "", ""},
+		{-1, "WARNING in board_reset", "", "", ""},
+		{0, "INFO: task hung in bar", release414, "", `#1 title="^INFO: task hung" kernel="^4\\.14\\."`},
+		{0, "INFO: task hung in bar", "", "foo\n" + release414, `#1 title="^INFO: task hung" kernel="^4\\.14\\."`},
+		{0, "INFO: task hung in bar", release419, "", ""},
+		{0, "INFO: task hung in bar", "", "", ""},
+		{-1, "KASAN: use-after-free Read in foo", "", "", `#2 title="^KASAN: .* in foo$"`},
+		{0, "KASAN: use-after-free Read in foobar", "", "", ""},
+	}
+	for i, test := range tests {
+		rep := &report.Report{
+			Title:  test.title,
+			Report: []byte(test.report),
+			Output: []byte(test.output),
+		}
+		if rule := ss.match(test.vmIndex, rep); rule != test.rule {
+			t.Errorf("#%v: got rule %q, want %q", i, rule, test.rule)
+		}
+	}
+	hits := []uint64{2, 2, 1}
+	for i, rule := range ss.status() {
+		if rule.Hits != hits[i] {
+			t.Errorf("rule #%v: got %v hits, want %v", i, rule.Hits, hits[i])
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/dashboard/dashapi"
+	"github.com/google/syzkaller/pkg/log"
+)
+
+// crashUploader throttles crash uploads to dashboard.
+// When a new kernel regresses, the same crash can happen on all VMs within seconds,
+// and uploading every occurrence hammers dashboard for no value.
+// The first crash with a title is uploaded right away (dashboard needs to know about it
+// to decide on repro), subsequent crashes are collected for the batch period and uploaded
+// as a single crash with the number of occurrences, but not more often than once per interval.
+// The uploader also caches titles that dashboard does not need repros for,
+// so that we don't ask about them on every crash.
+// Batched crashes that fail to upload are saved locally with the number of occurrences
+// (their callers were told that the crash is taken care of), pending batches are uploaded on close.
+// Titles are forgotten once there is nothing to upload and nothing to remember about them.
+// With zero batch period and interval, crashes are passed to dashboard as is.
+type crashUploader struct {
+	dash     dashboardAPI
+	batch    time.Duration
+	interval time.Duration
+	stop     chan bool
+	done     chan bool
+
+	mu     sync.Mutex
+	titles map[crashKey]*titleUploads
+}
+
+type dashboardAPI interface {
+	ReportCrash(crash *dashapi.Crash) (*dashapi.ReportCrashResp, error)
+	NeedRepro(crash *dashapi.CrashID) (bool, error)
+}
+
+type crashKey struct {
+	title     string
+	corrupted bool
+}
+
+type titleUploads struct {
+	uploaded  time.Time      // time of the last upload (zero if none)
+	flush     time.Time      // when pending crashes should be uploaded
+	pending   *dashapi.Crash // the last crash that is not uploaded yet
+	save      func(int)      // saves the pending crash locally if the upload fails
+	count     int            // number of crashes that are not uploaded yet
+	needRepro bool           // the last dashboard response
+	policy    time.Time      // when needRepro was received
+}
+
+// Dashboard responses about repro needs are trusted for that long.
+const reproPolicyTTL = time.Hour
+
+func newCrashUploader(dash dashboardAPI, batch, interval time.Duration) *crashUploader {
+	up := &crashUploader{
+		dash:     dash,
+		batch:    batch,
+		interval: interval,
+		stop:     make(chan bool),
+		done:     make(chan bool),
+		titles:   make(map[crashKey]*titleUploads),
+	}
+	if up.throttled() {
+		go up.loop()
+	}
+	return up
+}
+
+// close uploads all pending crashes, the uploader must not be used afterwards.
+func (up *crashUploader) close() {
+	if !up.throttled() {
+		return
+	}
+	close(up.stop)
+	<-up.done
+	up.flush(time.Now(), true)
+}
+
+func (up *crashUploader) throttled() bool {
+	return up.batch != 0 || up.interval != 0
+}
+
+// reportCrash uploads the crash or queues it for a later upload.
+// Returns whether dashboard needs a repro for the crash. If a queued crash fails to upload later,
+// save is called to store it locally with the number of crashes it stands for.
+func (up *crashUploader) reportCrash(crash *dashapi.Crash, save func(occurrences int)) (bool, error) {
+	if !up.throttled() {
+		resp, err := up.dash.ReportCrash(crash)
+		if err != nil {
+			return false, err
+		}
+		return resp.NeedRepro, nil
+	}
+	key := crashKey{crash.Title, crash.Corrupted}
+	now := time.Now()
+	up.mu.Lock()
+	t := up.titles[key]
+	if t == nil {
+		t = new(titleUploads)
+		up.titles[key] = t
+	}
+	if t.uploaded.IsZero() {
+		t.uploaded = now
+		up.mu.Unlock()
+		return up.upload(key, crash)
+	}
+	if t.pending == nil {
+		t.flush = now.Add(up.batch)
+		if next := t.uploaded.Add(up.interval); t.flush.Before(next) {
+			t.flush = next
+		}
+	}
+	t.pending = crash
+	t.save = save
+	t.count++
+	needRepro := t.needRepro
+	up.mu.Unlock()
+	return needRepro, nil
+}
+
+// needRepro checks if dashboard needs a repro for the crash,
+// titles that dashboard recently said it does not need repros for are answered locally.
+func (up *crashUploader) needRepro(cid *dashapi.CrashID) (bool, error) {
+	key := crashKey{cid.Title, cid.Corrupted}
+	if up.throttled() {
+		up.mu.Lock()
+		t := up.titles[key]
+		cached := t != nil && !t.needRepro && time.Since(t.policy) < reproPolicyTTL
+		up.mu.Unlock()
+		if cached {
+			return false, nil
+		}
+	}
+	needRepro, err := up.dash.NeedRepro(cid)
+	if err != nil {
+		return false, err
+	}
+	up.recordPolicy(key, needRepro)
+	return needRepro, nil
+}
+
+func (up *crashUploader) upload(key crashKey, crash *dashapi.Crash) (bool, error) {
+	resp, err := up.dash.ReportCrash(crash)
+	if err != nil {
+		return false, err
+	}
+	up.recordPolicy(key, resp.NeedRepro)
+	return resp.NeedRepro, nil
+}
+
+func (up *crashUploader) recordPolicy(key crashKey, needRepro bool) {
+	if !up.throttled() {
+		return
+	}
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	t := up.titles[key]
+	if t == nil {
+		t = new(titleUploads)
+		up.titles[key] = t
+	}
+	t.needRepro = needRepro
+	t.policy = time.Now()
+}
+
+func (up *crashUploader) loop() {
+	defer close(up.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			up.flush(now, false)
+		case <-up.stop:
+			return
+		}
+	}
+}
+
+// flush uploads pending crashes which batch period has passed (all pending crashes if force is set).
+func (up *crashUploader) flush(now time.Time, force bool) {
+	type batch struct {
+		key   crashKey
+		crash *dashapi.Crash
+		save  func(int)
+	}
+	var batches []batch
+	up.mu.Lock()
+	for key, t := range up.titles {
+		if up.expired(t, now) {
+			delete(up.titles, key)
+			continue
+		}
+		if t.pending == nil || !force && now.Before(t.flush) {
+			continue
+		}
+		crash := t.pending
+		crash.Occurrences = t.count
+		batches = append(batches, batch{key, crash, t.save})
+		t.pending = nil
+		t.save = nil
+		t.count = 0
+		t.uploaded = now
+	}
+	up.mu.Unlock()
+	for _, b := range batches {
+		if _, err := up.upload(b.key, b.crash); err != nil {
+			log.Crashf("failed to report %v crashes to dashboard: %v", b.crash.Occurrences, err)
+			if b.save != nil {
+				b.save(b.crash.Occurrences)
+			}
+		}
+	}
+}
+
+// expired returns whether the title can be forgotten: it has no pending crashes,
+// the next crash can be uploaded right away and dashboard needs to be asked about repros again.
+func (up *crashUploader) expired(t *titleUploads, now time.Time) bool {
+	if t.pending != nil || now.Sub(t.uploaded) < up.batch || now.Sub(t.uploaded) < up.interval {
+		return false
+	}
+	return t.needRepro || now.Sub(t.policy) >= reproPolicyTTL
+}
@@ -0,0 +1,219 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/dashboard/dashapi"
+)
+
+type testDashboard struct {
+	mu         sync.Mutex
+	crashes    []*dashapi.Crash
+	needRepros int
+	needRepro  bool
+	broken     bool
+}
+
+func (dash *testDashboard) ReportCrash(crash *dashapi.Crash) (*dashapi.ReportCrashResp, error) {
+	dash.mu.Lock()
+	defer dash.mu.Unlock()
+	if dash.broken {
+		return nil, errors.New("dashboard is down")
+	}
+	dash.crashes = append(dash.crashes, crash)
+	return &dashapi.ReportCrashResp{NeedRepro: dash.needRepro}, nil
+}
+
+func (dash *testDashboard) NeedRepro(crash *dashapi.CrashID) (bool, error) {
+	dash.mu.Lock()
+	defer dash.mu.Unlock()
+	dash.needRepros++
+	return dash.needRepro, nil
+}
+
+func (dash *testDashboard) uploads() int {
+	dash.mu.Lock()
+	defer dash.mu.Unlock()
+	return len(dash.crashes)
+}
+
+func TestCrashUploaderUnthrottled(t *testing.T) {
+	dash := &testDashboard{needRepro: true}
+	up := newCrashUploader(dash, 0, 0)
+	for i := 0; i < 3; i++ {
+		needRepro, err := up.reportCrash(&dashapi.Crash{Title: "WARNING in foo"}, nil)
+		if err != nil || !needRepro {
+			t.Fatalf("crash #%v: needRepro=%v err=%v", i, needRepro, err)
+		}
+	}
+	if got := dash.uploads(); got != 3 {
+		t.Fatalf("uploaded %v crashes, want 3", got)
+	}
+	up.close()
+}
+
+func TestCrashUploaderBatching(t *testing.T) {
+	dash := &testDashboard{needRepro: true}
+	// The batch period is long enough for the background loop to not interfere.
+	up := newCrashUploader(dash, time.Hour, time.Hour)
+	defer up.close()
+	// The first crash is uploaded right away, the rest are batched with the cached repro policy.
+	for i := 0; i < 4; i++ {
+		needRepro, err := up.reportCrash(&dashapi.Crash{Title: "WARNING in foo"}, func(int) {
+			t.Errorf("crash is saved locally")
+		})
+		if err != nil || !needRepro {
+			t.Fatalf("crash #%v: needRepro=%v err=%v", i, needRepro, err)
+		}
+	}
+	if got := dash.uploads(); got != 1 {
+		t.Fatalf("uploaded %v crashes, want 1", got)
+	}
+	// Different titles are not batched together.
+	if _, err := up.reportCrash(&dashapi.Crash{Title: "WARNING in bar"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := dash.uploads(); got != 2 {
+		t.Fatalf("uploaded %v crashes, want 2", got)
+	}
+	up.flush(time.Now(), false)
+	if got := dash.uploads(); got != 2 {
+		t.Fatalf("batch is uploaded before the batch period")
+	}
+	up.flush(time.Now().Add(2*time.Hour), false)
+	if got := dash.uploads(); got != 3 {
+		t.Fatalf("uploaded %v crashes, want 3", got)
+	}
+	if crash := dash.crashes[2]; crash.Title != "WARNING in foo" || crash.Occurrences != 3 {
+		t.Fatalf("bad batched crash: %q, %v occurrences", crash.Title, crash.Occurrences)
+	}
+	up.flush(time.Now().Add(4*time.Hour), false)
+	if got := dash.uploads(); got != 3 {
+		t.Fatalf("empty batch is uploaded")
+	}
+}
+
+func TestCrashUploaderFailedFlush(t *testing.T) {
+	dash := &testDashboard{}
+	up := newCrashUploader(dash, time.Hour, time.Hour)
+	defer up.close()
+	if _, err := up.reportCrash(&dashapi.Crash{Title: "WARNING in foo"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	saved, occurrences := 0, 0
+	for i := 0; i < 2; i++ {
+		if _, err := up.reportCrash(&dashapi.Crash{Title: "WARNING in foo"}, func(n int) {
+			saved++
+			occurrences = n
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dash.broken = true
+	up.flush(time.Now().Add(2*time.Hour), false)
+	// Only the last crash of a batch is kept, so it's saved once with the number of crashes in the batch.
+	if saved != 1 || occurrences != 2 {
+		t.Fatalf("batch that failed to upload is saved %v times with %v occurrences, want 1 with 2",
+			saved, occurrences)
+	}
+	// The direct upload error is returned to the caller, which saves the crash itself.
+	if _, err := up.reportCrash(&dashapi.Crash{Title: "WARNING in bar"}, nil); err == nil {
+		t.Fatalf("no error for a failed upload")
+	}
+}
+
+func TestCrashUploaderClose(t *testing.T) {
+	dash := &testDashboard{}
+	up := newCrashUploader(dash, time.Hour, time.Hour)
+	for i := 0; i < 3; i++ {
+		if _, err := up.reportCrash(&dashapi.Crash{Title: "WARNING in foo"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	up.close()
+	if got := dash.uploads(); got != 2 {
+		t.Fatalf("uploaded %v crashes, want 2", got)
+	}
+	if crash := dash.crashes[1]; crash.Occurrences != 2 {
+		t.Fatalf("flushed crash has %v occurrences, want 2", crash.Occurrences)
+	}
+}
+
+func TestCrashUploaderNeedRepro(t *testing.T) {
+	dash := &testDashboard{}
+	up := newCrashUploader(dash, time.Hour, time.Hour)
+	defer up.close()
+	cid := &dashapi.CrashID{Title: "WARNING in foo"}
+	// Titles dashboard does not need repros for are answered locally.
+	if _, err := up.reportCrash(&dashapi.Crash{Title: cid.Title}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if needRepro, err := up.needRepro(cid); err != nil || needRepro {
+			t.Fatalf("needRepro=%v err=%v", needRepro, err)
+		}
+	}
+	if dash.needRepros != 0 {
+		t.Fatalf("dashboard is asked %v times, want 0", dash.needRepros)
+	}
+	// Titles dashboard needs repros for are always checked with dashboard.
+	dash.needRepro = true
+	other := &dashapi.CrashID{Title: "WARNING in bar"}
+	for i := 0; i < 2; i++ {
+		if needRepro, err := up.needRepro(other); err != nil || !needRepro {
+			t.Fatalf("needRepro=%v err=%v", needRepro, err)
+		}
+	}
+	if dash.needRepros != 2 {
+		t.Fatalf("dashboard is asked %v times, want 2", dash.needRepros)
+	}
+	// Corrupted crashes are tracked separately.
+	if needRepro, err := up.needRepro(&dashapi.CrashID{Title: cid.Title, Corrupted: true}); err != nil ||
+		!needRepro {
+		t.Fatalf("needRepro=%v err=%v", needRepro, err)
+	}
+}
+
+func TestCrashUploaderForget(t *testing.T) {
+	dash := &testDashboard{}
+	up := newCrashUploader(dash, time.Minute, time.Hour)
+	defer up.close()
+	titles := func() int {
+		up.mu.Lock()
+		defer up.mu.Unlock()
+		return len(up.titles)
+	}
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if _, err := up.reportCrash(&dashapi.Crash{Title: "WARNING in foo"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Titles that dashboard needs repros for are remembered only until the next crash can be uploaded.
+	dash.needRepro = true
+	if _, err := up.needRepro(&dashapi.CrashID{Title: "WARNING in bar"}); err != nil {
+		t.Fatal(err)
+	}
+	// The pending crash is uploaded, but the title is remembered for the interval and the repro policy.
+	up.flush(start.Add(2*time.Hour), false)
+	if got := titles(); got != 1 {
+		t.Fatalf("uploader has %v titles, want 1", got)
+	}
+	up.flush(start.Add(2*time.Hour+30*time.Minute), false)
+	if got := titles(); got != 1 {
+		t.Fatalf("title is forgotten before the interval has passed")
+	}
+	up.flush(start.Add(4*time.Hour), false)
+	if got := titles(); got != 0 {
+		t.Fatalf("uploader has %v titles, want 0", got)
+	}
+	if got := dash.uploads(); got != 2 {
+		t.Fatalf("uploaded %v crashes, want 2", got)
+	}
+}
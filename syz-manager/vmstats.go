@@ -0,0 +1,263 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vmStats tracks per-VM fuzzing stats to identify sick VMs: the aggregate exec rate
+// hides that a few VMs execute programs much slower than the rest
+// (e.g. because of bad host NUMA placement or a degraded disk).
+type vmStats struct {
+	slowFactor float64 // VMs which rate is that many times lower than the median are slow (0 to disable)
+
+	mu  sync.Mutex
+	vms map[string]*vmStat // VM name (fuzzer name) -> stats
+}
+
+type vmStat struct {
+	connects      int
+	execs         uint64
+	inputs        uint64   // new corpus inputs contributed by the VM
+	procExecs     []uint64 // executions of each fuzzer process since the last connect
+	procs         int      // number of fuzzer processes reported by the fuzzer
+	samples       []vmSample
+	lastCrash     string
+	lastCrashTime time.Time
+	benchmark     time.Duration // duration of the last perf gate benchmark (0 if not run)
+	degraded      bool          // the last perf gate benchmark was too slow
+}
+
+type vmSample struct {
+	time      time.Time
+	execs     uint64
+	procExecs []uint64
+}
+
+// vmStatus is a snapshot of VM stats.
+type vmStatus struct {
+	Name          string
+	Active        bool      // the VM has reported stats recently
+	Rate          float64   // executions per second
+	ProcRates     []float64 // executions per second of each fuzzer process
+	Procs         int       // number of fuzzer processes (0 if unknown)
+	Execs         uint64
+	Inputs        uint64
+	Restarts      int
+	LastCrash     string
+	LastCrashTime time.Time
+	Slow          bool
+	Benchmark     time.Duration // duration of the last perf gate benchmark
+	Degraded      bool          // the last perf gate benchmark was too slow
+}
+
+const (
+	// Exec rates are computed over that period.
+	vmStatsWindow = time.Minute
+	// Slow VMs are detected only if there are at least that many active VMs,
+	// otherwise the median is not meaningful.
+	vmStatsMinVMs = 3
+)
+
+func newVMStats(slowFactor float64) *vmStats {
+	return &vmStats{
+		slowFactor: slowFactor,
+		vms:        make(map[string]*vmStat),
+	}
+}
+
+func (vs *vmStats) get(name string) *vmStat {
+	st := vs.vms[name]
+	if st == nil {
+		st = new(vmStat)
+		vs.vms[name] = st
+	}
+	return st
+}
+
+// connect is called when a fuzzer connects, i.e. the VM was (re)started.
+func (vs *vmStats) connect(name string, now time.Time) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	st := vs.get(name)
+	st.connects++
+	st.procExecs = nil
+	st.procs = 0
+	st.samples = []vmSample{{time: now, execs: st.execs}}
+}
+
+// poll accounts executions reported by a fuzzer.
+func (vs *vmStats) poll(name string, execs uint64, procExecs []uint64, procs int, now time.Time) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	st := vs.get(name)
+	st.execs += execs
+	if procs != 0 {
+		st.procs = procs
+	}
+	for len(st.procExecs) < len(procExecs) {
+		st.procExecs = append(st.procExecs, 0)
+	}
+	for i, v := range procExecs {
+		st.procExecs[i] += v
+	}
+	st.samples = append(st.samples, vmSample{
+		time:      now,
+		execs:     st.execs,
+		procExecs: append([]uint64{}, st.procExecs...),
+	})
+	// Keep one sample at or before the window start as the base for rate computation.
+	for len(st.samples) > 2 && now.Sub(st.samples[1].time) >= vmStatsWindow {
+		st.samples = st.samples[1:]
+	}
+}
+
+// newInput accounts a new corpus input contributed by a fuzzer.
+func (vs *vmStats) newInput(name string) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	vs.get(name).inputs++
+}
+
+func (vs *vmStats) crash(name, title string, now time.Time) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	st := vs.get(name)
+	st.lastCrash = title
+	st.lastCrashTime = now
+}
+
+// benchmark accounts the result of the post-boot perf gate benchmark (see perf_gate).
+func (vs *vmStats) benchmark(name string, duration time.Duration, degraded bool) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	st := vs.get(name)
+	st.benchmark = duration
+	st.degraded = degraded
+}
+
+// status returns stats of all VMs sorted by name and the median exec rate of active VMs.
+func (vs *vmStats) status(now time.Time) ([]*vmStatus, float64) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	var res []*vmStatus
+	var rates []float64
+	for name, st := range vs.vms {
+		status := &vmStatus{
+			Name:          name,
+			Execs:         st.execs,
+			Inputs:        st.inputs,
+			Procs:         st.procs,
+			LastCrash:     st.lastCrash,
+			LastCrashTime: st.lastCrashTime,
+			Benchmark:     st.benchmark,
+			Degraded:      st.degraded,
+		}
+		if st.connects > 1 {
+			status.Restarts = st.connects - 1
+		}
+		res = append(res, status)
+		if len(st.samples) < 2 {
+			continue
+		}
+		first, last := st.samples[0], st.samples[len(st.samples)-1]
+		secs := last.time.Sub(first.time).Seconds()
+		if now.Sub(last.time) >= vmStatsWindow || secs <= 0 {
+			continue
+		}
+		status.Active = true
+		status.Rate = float64(last.execs-first.execs) / secs
+		for i, v := range last.procExecs {
+			var base uint64
+			if i < len(first.procExecs) {
+				base = first.procExecs[i]
+			}
+			status.ProcRates = append(status.ProcRates, float64(v-base)/secs)
+		}
+		rates = append(rates, status.Rate)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return vmLess(res[i].Name, res[j].Name)
+	})
+	if len(rates) == 0 {
+		return res, 0
+	}
+	sort.Float64s(rates)
+	median := rates[len(rates)/2]
+	if len(rates)%2 == 0 {
+		median = (rates[len(rates)/2-1] + rates[len(rates)/2]) / 2
+	}
+	if vs.slowFactor != 0 && len(rates) >= vmStatsMinVMs {
+		for _, status := range res {
+			status.Slow = status.Active && status.Rate*vs.slowFactor < median
+		}
+	}
+	return res, median
+}
+
+// vmLess orders VM names like vm-2 before vm-10.
+func vmLess(a, b string) bool {
+	ia, erra := strconv.Atoi(strings.TrimPrefix(a, "vm-"))
+	ib, errb := strconv.Atoi(strings.TrimPrefix(b, "vm-"))
+	if erra == nil && errb == nil && ia != ib {
+		return ia < ib
+	}
+	return a < b
+}
+
+// writeMetrics writes VM stats in Prometheus text exposition format.
+func writeMetrics(w io.Writer, vms []*vmStatus) {
+	metrics := []struct {
+		name  string
+		typ   string
+		help  string
+		value func(*vmStatus) string
+	}{
+		{"syz_vm_exec_total", "counter", "Number of program executions in the VM.",
+			func(st *vmStatus) string { return fmt.Sprint(st.Execs) }},
+		{"syz_vm_exec_rate", "gauge", "Program executions per second in the VM over the last minute.",
+			func(st *vmStatus) string { return fmt.Sprintf("%.2f", st.Rate) }},
+		{"syz_vm_corpus_inputs_total", "counter", "Number of new corpus inputs contributed by the VM.",
+			func(st *vmStatus) string { return fmt.Sprint(st.Inputs) }},
+		{"syz_vm_procs", "gauge", "Number of fuzzer processes in the VM.",
+			func(st *vmStatus) string { return fmt.Sprint(st.Procs) }},
+		{"syz_vm_restarts_total", "counter", "Number of VM restarts.",
+			func(st *vmStatus) string { return fmt.Sprint(st.Restarts) }},
+		{"syz_vm_active", "gauge", "Whether the VM has reported stats recently.",
+			func(st *vmStatus) string { return boolMetric(st.Active) }},
+		{"syz_vm_slow", "gauge", "Whether the VM exec rate is much lower than the median.",
+			func(st *vmStatus) string { return boolMetric(st.Slow) }},
+		{"syz_vm_degraded", "gauge", "Whether the post-boot benchmark in the VM was too slow.",
+			func(st *vmStatus) string { return boolMetric(st.Degraded) }},
+		{"syz_vm_benchmark_seconds", "gauge", "Duration of the post-boot benchmark in the VM.",
+			func(st *vmStatus) string { return fmt.Sprintf("%.3f", st.Benchmark.Seconds()) }},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", m.name, m.help, m.name, m.typ)
+		for _, st := range vms {
+			fmt.Fprintf(w, "%v{instance=%q} %v\n", m.name, st.Name, m.value(st))
+		}
+	}
+	fmt.Fprintf(w, "# HELP syz_vm_proc_exec_rate Program executions per second of a fuzzer process.\n"+
+		"# TYPE syz_vm_proc_exec_rate gauge\n")
+	for _, st := range vms {
+		for i, rate := range st.ProcRates {
+			fmt.Fprintf(w, "syz_vm_proc_exec_rate{instance=%q,proc=\"%v\"} %.2f\n", st.Name, i, rate)
+		}
+	}
+}
+
+func boolMetric(v bool) string {
+	if v {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVMStats(t *testing.T) {
+	vs := newVMStats(4)
+	start := time.Now()
+	rates := []uint64{100, 110, 90, 30, 5}
+	for i := range rates {
+		vs.connect(fmt.Sprintf("vm-%v", i), start)
+	}
+	vs.connect("vm-4", start) // restart
+	// Poll every 10 seconds for 2 minutes, only the last minute counts.
+	for sec := 10; sec <= 120; sec += 10 {
+		for i, rate := range rates {
+			if sec <= 60 {
+				rate = 1000
+			}
+			vs.poll(fmt.Sprintf("vm-%v", i), rate*10, []uint64{rate * 5, rate * 5}, 2,
+				start.Add(time.Duration(sec)*time.Second))
+		}
+	}
+	vs.newInput("vm-1")
+	vs.crash("vm-2", "WARNING in foo", start)
+	vs.benchmark("vm-0", 2*time.Second, false)
+	vs.benchmark("vm-3", 5*time.Second, true)
+	vms, median := vs.status(start.Add(2 * time.Minute))
+	if median != 90 {
+		t.Errorf("got median rate %v, want 90", median)
+	}
+	if len(vms) != len(rates) {
+		t.Fatalf("got %v VMs, want %v", len(vms), len(rates))
+	}
+	for i, vm := range vms {
+		if want := fmt.Sprintf("vm-%v", i); vm.Name != want {
+			t.Errorf("VM #%v: got name %v, want %v", i, vm.Name, want)
+		}
+		if !vm.Active || vm.Rate != float64(rates[i]) {
+			t.Errorf("%v: got active=%v rate=%v, want rate %v", vm.Name, vm.Active, vm.Rate, rates[i])
+		}
+		if len(vm.ProcRates) != 2 || vm.ProcRates[0] != float64(rates[i])/2 || vm.Procs != 2 {
+			t.Errorf("%v: got procs %v, proc rates %v", vm.Name, vm.Procs, vm.ProcRates)
+		}
+		if slow := i == 4; vm.Slow != slow {
+			t.Errorf("%v: got slow=%v, want %v", vm.Name, vm.Slow, slow)
+		}
+	}
+	if vms[1].Inputs != 1 || vms[4].Restarts != 1 || vms[0].Restarts != 0 {
+		t.Errorf("got inputs %v, restarts %v/%v", vms[1].Inputs, vms[4].Restarts, vms[0].Restarts)
+	}
+	if vms[2].LastCrash != "WARNING in foo" || !vms[2].LastCrashTime.Equal(start) {
+		t.Errorf("got last crash %q at %v", vms[2].LastCrash, vms[2].LastCrashTime)
+	}
+	if vms[0].Degraded || vms[0].Benchmark != 2*time.Second || !vms[3].Degraded || vms[1].Benchmark != 0 {
+		t.Errorf("got benchmarks %v/%v/%v, degraded %v/%v",
+			vms[0].Benchmark, vms[1].Benchmark, vms[3].Benchmark, vms[0].Degraded, vms[3].Degraded)
+	}
+
+	// VMs that stopped reporting are not active and are not slow.
+	vms, median = vs.status(start.Add(5 * time.Minute))
+	for _, vm := range vms {
+		if vm.Active || vm.Slow {
+			t.Errorf("%v: active=%v slow=%v after it stopped reporting", vm.Name, vm.Active, vm.Slow)
+		}
+	}
+	if median != 0 {
+		t.Errorf("got median %v without active VMs", median)
+	}
+
+	buf := new(bytes.Buffer)
+	vms, _ = vs.status(start.Add(2 * time.Minute))
+	writeMetrics(buf, vms)
+	for _, want := range []string{
+		"# TYPE syz_vm_exec_total counter\n",
+		`syz_vm_exec_rate{instance="vm-0"} 100.00` + "\n",
+		`syz_vm_slow{instance="vm-4"} 1` + "\n",
+		`syz_vm_degraded{instance="vm-3"} 1` + "\n",
+		`syz_vm_degraded{instance="vm-0"} 0` + "\n",
+		`syz_vm_benchmark_seconds{instance="vm-3"} 5.000` + "\n",
+		`syz_vm_restarts_total{instance="vm-4"} 1` + "\n",
+		`syz_vm_procs{instance="vm-0"} 2` + "\n",
+		`syz_vm_proc_exec_rate{instance="vm-3",proc="1"} 15.00` + "\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("metrics don't contain %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestVMLess(t *testing.T) {
+	if !vmLess("vm-2", "vm-10") || vmLess("vm-10", "vm-2") || !vmLess("vm-1", "vm-1x") {
+		t.Errorf("bad VM name order")
+	}
+}
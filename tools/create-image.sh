@@ -38,6 +38,14 @@ ssh-keygen -f $RELEASE.id_rsa -t rsa -N ''
 sudo mkdir -p $DIR/root/.ssh/
 cat $RELEASE.id_rsa.pub | sudo tee $DIR/root/.ssh/authorized_keys
 
+# Optionally install syz-agent (qemu "agent" config option), e.g.:
+# SYZ_AGENT=$GOPATH/src/github.com/google/syzkaller/bin/linux_amd64/syz-agent ./create-image.sh
+if [ -n "${SYZ_AGENT:-}" ]; then
+	sudo cp $SYZ_AGENT $DIR/usr/bin/syz-agent
+	printf '[Unit]\nDescription=syzkaller agent\n\n[Service]\nExecStart=/usr/bin/syz-agent\nRestart=always\n\n[Install]\nWantedBy=multi-user.target\n' | sudo tee $DIR/etc/systemd/system/syz-agent.service
+	sudo ln -sf /etc/systemd/system/syz-agent.service $DIR/etc/systemd/system/multi-user.target.wants/syz-agent.service
+fi
+
 # Build a disk image
 dd if=/dev/zero of=$RELEASE.img bs=1M seek=2047 count=1
 sudo mkfs.ext4 -F $RELEASE.img
@@ -0,0 +1,69 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// syz-agent runs inside of a test machine and serves agent protocol requests
+// (see pkg/agent) from the host over a virtio-serial port. It allows VM backends
+// to copy files and run commands in the machine without sshd.
+// By default the virtio-serial port named syz-agent is used, -port flag allows to specify
+// port device explicitly.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/syzkaller/pkg/agent"
+	"github.com/google/syzkaller/pkg/log"
+)
+
+var flagPort = flag.String("port", "", "virtio-serial port device to serve")
+
+func main() {
+	flag.Parse()
+	for {
+		// The port returns EOF when the host disconnects, just reopen it.
+		if err := serve(*flagPort); err != nil {
+			log.Logf(0, "%v", err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func serve(port string) error {
+	if port == "" {
+		var err error
+		if port, err = findPort("syz-agent"); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(port, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return agent.Serve(f)
+}
+
+// findPort returns device for the named virtio-serial port.
+// We don't rely on /dev/virtio-ports symlinks because there may be no udev in the machine.
+func findPort(name string) (string, error) {
+	ports, err := filepath.Glob("/sys/class/virtio-ports/*")
+	if err != nil {
+		return "", err
+	}
+	for _, port := range ports {
+		data, err := ioutil.ReadFile(filepath.Join(port, "name"))
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(data)) == name {
+			return filepath.Join("/dev", filepath.Base(port)), nil
+		}
+	}
+	return "", fmt.Errorf("no virtio-serial port named %v", name)
+}
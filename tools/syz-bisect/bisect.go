@@ -7,20 +7,24 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/syzkaller/pkg/bisect"
 	"github.com/google/syzkaller/pkg/config"
 	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/osutil"
 )
 
 var (
-	flagConfig = flag.String("config", "", "bisect config file")
-	flagCrash  = flag.String("crash", "", "dir with crash info")
-	flagFix    = flag.Bool("fix", false, "search for crash fix")
+	flagConfig         = flag.String("config", "", "bisect config file")
+	flagCrash          = flag.String("crash", "", "dir with crash info")
+	flagFix            = flag.Bool("fix", false, "search for crash fix")
+	flagMinimizeConfig = flag.Bool("minimize_config", false, "minimize kernel config (see config_groups)")
 )
 
 type Config struct {
@@ -33,12 +37,21 @@ type Config struct {
 	Cmdline       string          `json:"cmdline"`
 	SyzkallerRepo string          `json:"syzkaller_repo"`
 	Manager       json.RawMessage `json:"manager"`
+	// Files with kernel config fragments to try to disable with -minimize_config.
+	ConfigGroups []string `json:"config_groups"`
+	// Max number of kernel builds during config minimization (20 by default).
+	MaxRebuilds int `json:"max_rebuilds"`
+	// Config minimization does not start new builds after that many minutes (600 by default).
+	TimeBudget int `json:"time_budget"`
 }
 
 func main() {
 	flag.Parse()
 	os.Setenv("SYZ_DISABLE_SANDBOXING", "yes")
-	mycfg := new(Config)
+	mycfg := &Config{
+		MaxRebuilds: 20,
+		TimeBudget:  600,
+	}
 	if err := config.LoadFile(*flagConfig, mycfg); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -78,12 +91,52 @@ func main() {
 	loadFile("kernel.config", &cfg.Kernel.Config)
 	loadFile("repro.syz", &cfg.Repro.Syz)
 	loadFile("repro.opts", &cfg.Repro.Opts)
+	if *flagMinimizeConfig {
+		minimizeConfig(cfg, mycfg)
+		return
+	}
 	if _, err := bisect.Run(cfg); err != nil {
 		fmt.Fprintf(os.Stderr, "bisection failed: %v\n", err)
 		os.Exit(1)
 	}
 }
 
+// minimizeConfig saves the minimized config and the log of steps into the crash dir
+// (as minimized.config and minimize.log).
+func minimizeConfig(cfg *bisect.Config, mycfg *Config) {
+	for _, file := range mycfg.ConfigGroups {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		group, err := bisect.ParseConfigGroup(filepath.Base(file), data)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		cfg.Minimize.Groups = append(cfg.Minimize.Groups, group)
+	}
+	cfg.Minimize.MaxRebuilds = mycfg.MaxRebuilds
+	cfg.Minimize.TimeBudget = time.Duration(mycfg.TimeBudget) * time.Minute
+	logFile, err := os.Create(filepath.Join(*flagCrash, "minimize.log"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer logFile.Close()
+	cfg.Trace = io.MultiWriter(os.Stdout, logFile)
+	res, err := bisect.MinimizeConfig(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config minimization failed: %v\n", err)
+		os.Exit(1)
+	}
+	if err := osutil.WriteFile(filepath.Join(*flagCrash, "minimized.config"), res.Config); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
 func loadString(file string, dst *string) {
 	data, err := ioutil.ReadFile(filepath.Join(*flagCrash, file))
 	if err != nil {
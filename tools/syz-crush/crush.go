@@ -3,6 +3,8 @@
 
 // syz-crush replays crash log on multiple VMs. Usage:
 //   syz-crush -config=config.file execution.log
+// or, to replay the most recent log of a saved crash:
+//   syz-crush -config=config.file workdir/crashes/ID
 // Intended for reproduction of particularly elusive crashes.
 package main
 
@@ -13,6 +15,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/google/syzkaller/pkg/crashdir"
 	"github.com/google/syzkaller/pkg/instance"
 	"github.com/google/syzkaller/pkg/log"
 	"github.com/google/syzkaller/pkg/mgrconfig"
@@ -33,7 +36,11 @@ func main() {
 		log.Fatalf("%v", err)
 	}
 	if len(flag.Args()) != 1 {
-		log.Fatalf("usage: syz-crush -config=config.file execution.log")
+		log.Fatalf("usage: syz-crush -config=config.file execution.log|workdir/crashes/ID")
+	}
+	logFile, err := crashdir.ResolveLog(flag.Args()[0])
+	if err != nil {
+		log.Fatalf("%v", err)
 	}
 	if _, err := prog.GetTarget(cfg.TargetOS, cfg.TargetArch); err != nil {
 		log.Fatalf("%v", err)
@@ -56,7 +63,7 @@ func main() {
 		go func() {
 			defer wg.Done()
 			for {
-				runInstance(cfg, reporter, vmPool, i)
+				runInstance(cfg, reporter, vmPool, i, logFile)
 				if atomic.LoadUint32(&shutdown) != 0 {
 					break
 				}
@@ -74,7 +81,7 @@ func main() {
 	wg.Wait()
 }
 
-func runInstance(cfg *mgrconfig.Config, reporter report.Reporter, vmPool *vm.Pool, index int) {
+func runInstance(cfg *mgrconfig.Config, reporter report.Reporter, vmPool *vm.Pool, index int, logFile string) {
 	inst, err := vmPool.Create(index)
 	if err != nil {
 		log.Logf(0, "failed to create instance: %v", err)
@@ -92,14 +99,14 @@ func runInstance(cfg *mgrconfig.Config, reporter report.Reporter, vmPool *vm.Poo
 		log.Logf(0, "failed to copy executor: %v", err)
 		return
 	}
-	logFile, err := inst.Copy(flag.Args()[0])
+	vmLogFile, err := inst.Copy(logFile)
 	if err != nil {
 		log.Logf(0, "failed to copy log: %v", err)
 		return
 	}
 
 	cmd := instance.ExecprogCmd(execprogBin, executorBin, cfg.TargetOS, cfg.TargetArch, cfg.Sandbox,
-		true, true, true, cfg.Procs, -1, -1, logFile)
+		true, true, true, cfg.Procs, -1, -1, vmLogFile)
 	outc, errc, err := inst.Run(time.Hour, nil, cmd)
 	if err != nil {
 		log.Logf(0, "failed to run execprog: %v", err)
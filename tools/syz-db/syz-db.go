@@ -9,8 +9,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/syzkaller/pkg/db"
 	"github.com/google/syzkaller/pkg/hash"
@@ -27,6 +29,10 @@ func main() {
 	)
 	flag.Parse()
 	args := flag.Args()
+	if len(args) == 2 && args[0] == "stats" {
+		stats(args[1])
+		return
+	}
 	if len(args) != 3 {
 		usage()
 	}
@@ -52,6 +58,7 @@ func usage() {
 	fmt.Fprintf(os.Stderr, "usage:\n")
 	fmt.Fprintf(os.Stderr, "  syz-db pack dir corpus.db\n")
 	fmt.Fprintf(os.Stderr, "  syz-db unpack corpus.db dir\n")
+	fmt.Fprintf(os.Stderr, "  syz-db stats corpus.db\n")
 	os.Exit(1)
 }
 
@@ -101,6 +108,7 @@ func unpack(file, dir string) {
 	if err != nil {
 		failf("failed to open database: %v", err)
 	}
+	provenance := readProvenance(file)
 	osutil.MkdirAll(dir)
 	for key, rec := range db.Records {
 		fname := filepath.Join(dir, key)
@@ -110,7 +118,77 @@ func unpack(file, dir string) {
 		if err := osutil.WriteFile(fname, rec.Val); err != nil {
 			failf("failed to output file: %v", err)
 		}
+		if provenance != nil {
+			fmt.Printf("%v: %v\n", key, formatProvenance(provenance, key))
+		}
+	}
+}
+
+func stats(file string) {
+	corpus, err := db.ReadRecords(file)
+	if err != nil {
+		failf("failed to open database: %v", err)
+	}
+	size := 0
+	for _, rec := range corpus {
+		size += len(rec.Val)
+	}
+	fmt.Printf("programs: %v (%v bytes)\n", len(corpus), size)
+	provenance := readProvenance(file)
+	if provenance == nil {
+		return
+	}
+	origins := make(map[string]int)
+	for key := range corpus {
+		origin := "unknown"
+		if prov := db.LoadProvenance(provenance, key); prov != nil && prov.Origin != "" {
+			origin = prov.Origin
+		}
+		origins[origin]++
+	}
+	var sorted []string
+	for origin := range origins {
+		sorted = append(sorted, origin)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if origins[sorted[i]] != origins[sorted[j]] {
+			return origins[sorted[i]] > origins[sorted[j]]
+		}
+		return sorted[i] < sorted[j]
+	})
+	fmt.Printf("origins:\n")
+	for _, origin := range sorted {
+		fmt.Printf("  %-12v %v\n", origin, origins[origin])
+	}
+}
+
+// readProvenance returns records of the provenance database of the corpus database file,
+// or nil if the corpus has no provenance.
+func readProvenance(file string) map[string]db.Record {
+	records, err := db.ReadRecords(db.ProvenanceFile(file))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "failed to read provenance: %v\n", err)
+		}
+		return nil
+	}
+	return records
+}
+
+func formatProvenance(records map[string]db.Record, key string) string {
+	prov := db.LoadProvenance(records, key)
+	if prov == nil {
+		return "no provenance"
+	}
+	res := fmt.Sprintf("origin=%v time=%v call=%v signal=%v new_signal=%v",
+		prov.Origin, prov.Time.Format(time.RFC3339), prov.Call, prov.Signal, prov.NewSignal)
+	if prov.Parent != "" {
+		res += fmt.Sprintf(" parent=%v", prov.Parent)
+	}
+	if prov.Replaced != "" {
+		res += fmt.Sprintf(" replaced=%v", prov.Replaced)
 	}
+	return res
 }
 
 func failf(msg string, args ...interface{}) {
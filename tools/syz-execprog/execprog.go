@@ -20,7 +20,9 @@ import (
 	"github.com/google/syzkaller/pkg/ipc"
 	"github.com/google/syzkaller/pkg/ipc/ipcconfig"
 	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/mgrconfig"
 	"github.com/google/syzkaller/pkg/osutil"
+	"github.com/google/syzkaller/pkg/report"
 	"github.com/google/syzkaller/prog"
 	_ "github.com/google/syzkaller/sys"
 )
@@ -35,6 +37,13 @@ var (
 	flagFaultCall = flag.Int("fault_call", -1, "inject fault into this call (0-based)")
 	flagFaultNth  = flag.Int("fault_nth", 0, "inject fault on n-th operation (0-based)")
 	flagHints     = flag.Bool("hints", false, "do a hints-generation run")
+
+	flagResultFormat = flag.String("result-format", "", "write per program file results in the format (junit/tap/json),"+
+		" exit status is non-zero if any file fails")
+	flagResultFile = flag.String("result-file", "", "write results to the file instead of stdout")
+	flagKernelObj  = flag.String("kernel_obj", "", "kernel build dir, enables detection of kernel crashes"+
+		" on the console (with -result-format)")
+	flagConsole = flag.String("console", "/dev/kmsg", "kernel console to check for crashes (with -kernel_obj)")
 )
 
 func main() {
@@ -50,8 +59,11 @@ func main() {
 		log.Fatalf("%v", err)
 	}
 
-	entries := loadPrograms(target, flag.Args())
-	if len(entries) == 0 {
+	if *flagResultFormat != "" && !validResultFormat(*flagResultFormat) {
+		log.Fatalf("unknown -result-format %q, want junit/tap/json", *flagResultFormat)
+	}
+	entries, entryFiles := loadPrograms(target, flag.Args())
+	if len(entries) == 0 && *flagResultFormat == "" {
 		return
 	}
 
@@ -70,37 +82,108 @@ func main() {
 	config, execOpts := createConfig(target, entries, features)
 
 	ctx := &Context{
-		entries:  entries,
-		config:   config,
-		execOpts: execOpts,
-		gate:     ipc.NewGate(2**flagProcs, nil),
-		shutdown: make(chan struct{}),
-		repeat:   *flagRepeat,
+		entries:    entries,
+		entryFiles: entryFiles,
+		config:     config,
+		execOpts:   execOpts,
+		gate:       ipc.NewGate(2**flagProcs, nil),
+		shutdown:   make(chan struct{}),
+		repeat:     *flagRepeat,
+	}
+	if *flagResultFormat != "" {
+		ctx.results = createResults(target, flag.Args())
+	}
+	// Stop at the next program on SIGINT/SIGTERM and write what was collected so far.
+	osutil.HandleInterrupts(ctx.shutdown)
+	procs := *flagProcs
+	if len(entries) == 0 {
+		procs = 0
 	}
 	var wg sync.WaitGroup
-	wg.Add(*flagProcs)
-	for p := 0; p < *flagProcs; p++ {
+	wg.Add(procs)
+	for p := 0; p < procs; p++ {
 		pid := p
 		go func() {
 			defer wg.Done()
 			ctx.run(pid)
 		}()
 	}
-	osutil.HandleInterrupts(ctx.shutdown)
 	wg.Wait()
+	if ctx.results != nil {
+		writeResults(ctx.results)
+	}
+	if ctx.interrupted() {
+		// Coverage files are written after each program and results are written above,
+		// programs that were not executed are reported as skipped.
+		log.Logf(0, "interrupted, exiting with partial results")
+		os.Exit(osutil.ExitInterrupted)
+	}
+	if ctx.results != nil && ctx.results.failed() {
+		os.Exit(1)
+	}
+}
+
+func createResults(target *prog.Target, files []string) *results {
+	var reporter report.Reporter
+	var cons *console
+	if *flagKernelObj != "" {
+		cfg := &mgrconfig.Config{
+			TargetOS:   target.OS,
+			TargetArch: target.Arch,
+			KernelObj:  *flagKernelObj,
+		}
+		var err error
+		if reporter, err = report.NewReporter(cfg); err != nil {
+			log.Fatalf("failed to create reporter: %v", err)
+		}
+		if cons, err = openConsole(*flagConsole); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	return newResults(files, reporter, cons)
+}
+
+func writeResults(res *results) {
+	// Give the kernel some time to print crashes caused by the last programs.
+	res.finish(time.Second)
+	w := os.Stdout
+	if *flagResultFile != "" {
+		f, err := os.Create(*flagResultFile)
+		if err != nil {
+			log.Fatalf("failed to create result file: %v", err)
+		}
+		w = f
+	}
+	if err := res.write(w, *flagResultFormat); err != nil {
+		log.Fatalf("failed to write results: %v", err)
+	}
+	if err := w.Sync(); err != nil && *flagResultFile != "" {
+		log.Fatalf("failed to write results: %v", err)
+	}
 }
 
 type Context struct {
-	entries   []*prog.LogEntry
-	config    *ipc.Config
-	execOpts  *ipc.ExecOpts
-	gate      *ipc.Gate
-	shutdown  chan struct{}
-	logMu     sync.Mutex
-	posMu     sync.Mutex
-	repeat    int
-	pos       int
-	lastPrint time.Time
+	entries    []*prog.LogEntry
+	entryFiles []int // index of the program file for each entry
+	results    *results
+	config     *ipc.Config
+	execOpts   *ipc.ExecOpts
+	gate       *ipc.Gate
+	shutdown   chan struct{}
+	logMu      sync.Mutex
+	posMu      sync.Mutex
+	repeat     int
+	pos        int
+	lastPrint  time.Time
+}
+
+func (ctx *Context) interrupted() bool {
+	select {
+	case <-ctx.shutdown:
+		return true
+	default:
+		return false
+	}
 }
 
 func (ctx *Context) run(pid int) {
@@ -109,22 +192,17 @@ func (ctx *Context) run(pid int) {
 		log.Fatalf("failed to create ipc env: %v", err)
 	}
 	defer env.Close()
-	for {
-		select {
-		case <-ctx.shutdown:
-			return
-		default:
-		}
+	for !ctx.interrupted() {
 		idx := ctx.getProgramIndex()
 		if ctx.repeat > 0 && idx >= len(ctx.entries)*ctx.repeat {
 			return
 		}
-		entry := ctx.entries[idx%len(ctx.entries)]
-		ctx.execute(pid, env, entry)
+		ctx.execute(pid, env, idx%len(ctx.entries))
 	}
 }
 
-func (ctx *Context) execute(pid int, env *ipc.Env, entry *prog.LogEntry) {
+func (ctx *Context) execute(pid int, env *ipc.Env, idx int) {
+	entry := ctx.entries[idx]
 	// Limit concurrency window.
 	ticket := ctx.gate.Enter()
 	defer ctx.gate.Leave(ticket)
@@ -140,7 +218,19 @@ func (ctx *Context) execute(pid int, env *ipc.Env, entry *prog.LogEntry) {
 	if *flagOutput {
 		ctx.logProgram(pid, entry.P, callOpts)
 	}
+	start := time.Now()
 	output, info, failed, hanged, err := env.Exec(callOpts, entry.P)
+	if ctx.results != nil {
+		execErr := ""
+		if err != nil {
+			execErr = fmt.Sprintf("executor failed: %v", err)
+		} else if failed {
+			execErr = "executor-detected bug"
+		} else if hanged {
+			execErr = "program hanged"
+		}
+		ctx.results.record(ctx.entryFiles[idx], time.Since(start), execErr)
+	}
 	if failed {
 		log.Logf(0, "BUG: executor-detected bug:\n%s", output)
 	}
@@ -249,17 +339,21 @@ func (ctx *Context) getProgramIndex() int {
 	return idx
 }
 
-func loadPrograms(target *prog.Target, files []string) []*prog.LogEntry {
+func loadPrograms(target *prog.Target, files []string) ([]*prog.LogEntry, []int) {
 	var entries []*prog.LogEntry
-	for _, fn := range files {
+	var entryFiles []int
+	for i, fn := range files {
 		data, err := ioutil.ReadFile(fn)
 		if err != nil {
 			log.Fatalf("failed to read log file: %v", err)
 		}
-		entries = append(entries, target.ParseLog(data)...)
+		for _, entry := range target.ParseLog(data) {
+			entries = append(entries, entry)
+			entryFiles = append(entryFiles, i)
+		}
 	}
 	log.Logf(0, "parsed %v programs", len(entries))
-	return entries
+	return entries, entryFiles
 }
 
 func createConfig(target *prog.Target, entries []*prog.LogEntry, features *host.Features) (
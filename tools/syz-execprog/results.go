@@ -0,0 +1,308 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/report"
+)
+
+// Result output (-result-format) turns sets of program files (e.g. reproducers of known bugs)
+// into CI test suites: each program file is a test case that fails if executor fails, a program hangs or
+// a kernel crash is detected on the console (-kernel_obj) while programs from the file are executed.
+
+// fileResult is the result of execution of all programs from a single program file.
+type fileResult struct {
+	File     string        `json:"file"`
+	Programs int           `json:"programs"` // number of executed programs, 0 means not executed
+	Duration time.Duration `json:"duration"`
+	Failed   bool          `json:"failed"`
+	Crash    string        `json:"crash,omitempty"` // title of the detected crash
+	Error    string        `json:"error,omitempty"` // executor failure or hang
+	Report   string        `json:"report,omitempty"`
+}
+
+type results struct {
+	mu       sync.Mutex
+	files    []*fileResult
+	reporter report.Reporter // nil if crashes are not detected
+	console  *console
+	output   []byte // console output that wasn't yet parsed
+	last     int    // index of the last executed file
+}
+
+func newResults(files []string, reporter report.Reporter, console *console) *results {
+	res := &results{
+		reporter: reporter,
+		console:  console,
+	}
+	for _, file := range files {
+		res.files = append(res.files, &fileResult{File: file})
+	}
+	return res
+}
+
+// record records execution of a program from file idx and checks the console for crashes.
+func (res *results) record(idx int, duration time.Duration, execErr string) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	r := res.files[idx]
+	r.Programs++
+	r.Duration += duration
+	if execErr != "" && r.Error == "" {
+		r.Failed = true
+		r.Error = execErr
+	}
+	res.last = idx
+	res.checkConsoleLocked()
+}
+
+// finish waits for crash messages that may still be printed by the kernel.
+func (res *results) finish(wait time.Duration) {
+	if res.console == nil {
+		return
+	}
+	time.Sleep(wait)
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	res.checkConsoleLocked()
+}
+
+// checkConsoleLocked attributes crashes found in new console output to the last executed file.
+// With -procs > 1 the crash may be actually caused by a concurrently executed program.
+func (res *results) checkConsoleLocked() {
+	if res.console == nil {
+		return
+	}
+	res.output = append(res.output, res.console.read()...)
+	for res.reporter.ContainsCrash(res.output) {
+		rep := res.reporter.Parse(res.output)
+		if rep == nil {
+			break
+		}
+		res.output = res.output[rep.EndPos:]
+		if err := res.reporter.Symbolize(rep); err != nil {
+			log.Logf(0, "failed to symbolize report: %v", err)
+		}
+		log.Logf(0, "detected crash: %v", rep.Title)
+		r := res.files[res.last]
+		if r.Crash == "" {
+			r.Failed = true
+			r.Crash = rep.Title
+			r.Report = string(rep.Report)
+		}
+	}
+	// Keep only the tail that may contain beginning of a crash that is not yet fully printed.
+	const maxTail = 128 << 10
+	if len(res.output) > maxTail {
+		res.output = res.output[len(res.output)-maxTail:]
+	}
+}
+
+func (res *results) failed() bool {
+	for _, r := range res.files {
+		if r.Failed {
+			return true
+		}
+	}
+	return false
+}
+
+func (res *results) write(w io.Writer, format string) error {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	switch format {
+	case "junit":
+		return writeJUnit(w, res.files)
+	case "tap":
+		return writeTAP(w, res.files)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(res.files)
+	default:
+		return fmt.Errorf("unknown result format %q", format)
+	}
+}
+
+func validResultFormat(format string) bool {
+	return format == "junit" || format == "tap" || format == "json"
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func writeJUnit(w io.Writer, files []*fileResult) error {
+	suite := junitSuite{
+		Name:  "syz-execprog",
+		Tests: len(files),
+	}
+	var total time.Duration
+	for _, r := range files {
+		total += r.Duration
+		tc := junitCase{
+			Name:      r.File,
+			ClassName: "syz-execprog",
+			Time:      junitTime(r.Duration),
+		}
+		switch {
+		case r.Failed:
+			suite.Failures++
+			tc.Failure = &junitFailure{
+				Message: r.failure(),
+				Type:    "crash",
+				Text:    r.Report,
+			}
+			if r.Crash == "" {
+				tc.Failure.Type = "executor"
+			}
+		case r.Programs == 0:
+			suite.Skipped++
+			tc.Skipped = &struct{}{}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Time = junitTime(total)
+	data, err := xml.MarshalIndent(junitSuites{Suites: []junitSuite{suite}}, "", "\t")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func junitTime(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+func writeTAP(w io.Writer, files []*fileResult) error {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "TAP version 13\n1..%v\n", len(files))
+	for i, r := range files {
+		switch {
+		case r.Failed:
+			fmt.Fprintf(buf, "not ok %v - %v\n", i+1, r.File)
+			fmt.Fprintf(buf, "  ---\n  message: %v\n  duration_ms: %v\n  ...\n",
+				strconv.Quote(r.failure()), r.Duration.Nanoseconds()/1e6)
+		case r.Programs == 0:
+			fmt.Fprintf(buf, "ok %v - %v # SKIP not executed\n", i+1, r.File)
+		default:
+			fmt.Fprintf(buf, "ok %v - %v\n", i+1, r.File)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (r *fileResult) failure() string {
+	if r.Crash != "" {
+		return r.Crash
+	}
+	return r.Error
+}
+
+// console reads new kernel console output without blocking.
+type console struct {
+	file *os.File
+	kmsg bool
+}
+
+var kmsgRecordRe = regexp.MustCompile(`^[0-9]+,[0-9]+,([0-9]+),[^;]*;(.*)$`)
+
+func openConsole(name string) (*console, error) {
+	file, err := os.OpenFile(name, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open console: %v", err)
+	}
+	// We are interested only in messages printed while programs are executed.
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to seek console: %v", err)
+	}
+	return &console{
+		file: file,
+		kmsg: name == "/dev/kmsg",
+	}, nil
+}
+
+func (c *console) read() []byte {
+	var output []byte
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := c.file.Read(buf)
+		if n > 0 {
+			if c.kmsg {
+				output = append(output, formatKmsgRecord(buf[:n])...)
+			} else {
+				output = append(output, buf[:n]...)
+			}
+		}
+		if err != nil {
+			if pe, ok := err.(*os.PathError); ok && pe.Err == syscall.EPIPE {
+				// Some kmsg records were overwritten before we read them, continue with the next one.
+				continue
+			}
+			// EAGAIN/EOF: no more output at the moment.
+			return output
+		}
+		if n == 0 {
+			return output
+		}
+	}
+}
+
+// formatKmsgRecord formats a /dev/kmsg record ("6,1234,5678901,-;message\n KEY=value\n")
+// the same way as the kernel prints messages on console ("[    5.678901] message").
+func formatKmsgRecord(record []byte) []byte {
+	lines := bytes.Split(record, []byte{'\n'})
+	match := kmsgRecordRe.FindSubmatch(lines[0])
+	if match == nil {
+		return record
+	}
+	usec, err := strconv.ParseUint(string(match[1]), 10, 64)
+	if err != nil {
+		return record
+	}
+	return []byte(fmt.Sprintf("[%5d.%06d] %s\n", usec/1e6, usec%1e6, match[2]))
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/report"
+)
+
+func TestResultsCrash(t *testing.T) {
+	f, err := ioutil.TempFile("", "syz-execprog-console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	f.WriteString("[    1.000000] boot messages\n")
+	cons, err := openConsole(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reporter, err := report.NewReporter(&mgrconfig.Config{TargetOS: "linux", TargetArch: "amd64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := newResults([]string{"repro1", "repro2", "repro3"}, reporter, cons)
+	res.record(0, time.Second, "")
+	f.WriteString("[   10.000000] BUG: KASAN: use-after-free in foo+0x10/0x20\n" +
+		"[   10.000000] Read of size 8 at addr ffff8880a5b0a0f8 by task syz-executor/1\n")
+	res.record(1, 2*time.Second, "")
+	res.record(1, 2*time.Second, "")
+	if res.files[0].Failed || !res.files[1].Failed || res.files[2].Failed || !res.failed() {
+		t.Fatalf("bad failed status: %+v %+v %+v", *res.files[0], *res.files[1], *res.files[2])
+	}
+	if want := "KASAN: use-after-free Read in foo"; res.files[1].Crash != want {
+		t.Fatalf("got crash %q, want %q", res.files[1].Crash, want)
+	}
+	if res.files[1].Programs != 2 || res.files[1].Duration != 4*time.Second {
+		t.Fatalf("got %v programs in %v, want 2 in 4s", res.files[1].Programs, res.files[1].Duration)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := res.write(buf, "tap"); err != nil {
+		t.Fatal(err)
+	}
+	wantTAP := `TAP version 13
+1..3
+ok 1 - repro1
+not ok 2 - repro2
+  ---
+  message: "KASAN: use-after-free Read in foo"
+  duration_ms: 4000
+  ...
+ok 3 - repro3 # SKIP not executed
+`
+	if buf.String() != wantTAP {
+		t.Fatalf("got TAP:\n%s\nwant:\n%s", buf.String(), wantTAP)
+	}
+	buf.Reset()
+	if err := res.write(buf, "junit"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<testsuite name="syz-execprog" tests="3" failures="1" skipped="1" time="5.000">`,
+		`<testcase name="repro1" classname="syz-execprog" time="1.000"></testcase>`,
+		`<failure message="KASAN: use-after-free Read in foo" type="crash">`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("JUnit output does not contain %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestResultsExecutorFailure(t *testing.T) {
+	res := newResults([]string{"prog"}, nil, nil)
+	res.record(0, time.Second, "executor-detected bug")
+	res.record(0, time.Second, "")
+	if !res.files[0].Failed || res.files[0].Error != "executor-detected bug" {
+		t.Fatalf("bad result: %+v", *res.files[0])
+	}
+	res = newResults([]string{"prog"}, nil, nil)
+	res.record(0, time.Second, "program hanged")
+	if !res.files[0].Failed || !res.failed() {
+		t.Fatalf("hanged program is not a failure: %+v", *res.files[0])
+	}
+	if err := res.write(new(bytes.Buffer), "xml"); err == nil {
+		t.Fatalf("no error for unknown format")
+	}
+}
+
+func TestFormatKmsgRecord(t *testing.T) {
+	tests := map[string]string{
+		"6,1234,5678901,-;BUG: KASAN: use-after-free\n SUBSYSTEM=foo\n": "[    5.678901] BUG: KASAN: use-after-free\n",
+		"4,2,1000,c;WARNING: CPU: 0 PID: 1\n":                           "[    0.001000] WARNING: CPU: 0 PID: 1\n",
+		"plain line\n":                                                  "plain line\n",
+	}
+	for record, want := range tests {
+		if got := string(formatKmsgRecord([]byte(record))); got != want {
+			t.Errorf("formatKmsgRecord(%q) = %q, want %q", record, got, want)
+		}
+	}
+}
@@ -22,7 +22,7 @@ import (
 var (
 	flagOS     = flag.String("os", runtime.GOOS, "target os")
 	flagArch   = flag.String("arch", runtime.GOARCH, "target arch")
-	flagSeed   = flag.Int("seed", -1, "prng seed")
+	flagSeed   = flag.Int64("seed", -1, "prng seed (e.g. a proc seed from fuzzer log)")
 	flagLen    = flag.Int("len", 30, "number of calls in programs")
 	flagEnable = flag.String("enable", "", "comma-separated list of enabled syscalls")
 )
@@ -53,7 +53,7 @@ func main() {
 	}
 	seed := time.Now().UnixNano()
 	if *flagSeed != -1 {
-		seed = int64(*flagSeed)
+		seed = *flagSeed
 	}
 	rs := rand.NewSource(seed)
 	prios := target.CalculatePriorities(nil)
@@ -8,7 +8,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 
+	"github.com/google/syzkaller/pkg/crashdir"
 	"github.com/google/syzkaller/pkg/csource"
 	"github.com/google/syzkaller/pkg/log"
 	"github.com/google/syzkaller/pkg/mgrconfig"
@@ -29,18 +31,22 @@ func main() {
 	os.Args = append(append([]string{}, os.Args[0], "-v=10"), os.Args[1:]...)
 	flag.Parse()
 	if len(flag.Args()) != 1 || *flagConfig == "" {
-		log.Fatalf("usage: syz-repro -config=manager.cfg execution.log")
+		log.Fatalf("usage: syz-repro -config=manager.cfg execution.log|workdir/crashes/ID")
 	}
 	cfg, err := mgrconfig.LoadFile(*flagConfig)
 	if err != nil {
 		log.Fatalf("%v: %v", *flagConfig, err)
 	}
-	logFile := flag.Args()[0]
+	logFile, err := crashdir.ResolveLog(flag.Args()[0])
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
 	data, err := ioutil.ReadFile(logFile)
 	if err != nil {
 		log.Fatalf("failed to open log file %v: %v", logFile, err)
 	}
-	if _, err := prog.GetTarget(cfg.TargetOS, cfg.TargetArch); err != nil {
+	target, err := prog.GetTarget(cfg.TargetOS, cfg.TargetArch)
+	if err != nil {
 		log.Fatalf("%v", err)
 	}
 	vmPool, err := vm.Create(cfg, *flagDebug)
@@ -62,10 +68,13 @@ func main() {
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
+	// On SIGINT/SIGTERM reproduction stops at the next safe point, VMs are closed
+	// and the best reproducer found so far is saved with a partial marker.
 	osutil.HandleInterrupts(vm.Shutdown)
 
 	res, stats, err := repro.Run(data, cfg, reporter, vmPool, vmIndexes)
-	if err != nil {
+	interrupted := err == repro.ErrInterrupted
+	if err != nil && !interrupted {
 		log.Logf(0, "reproduction failed: %v", err)
 	}
 	if stats != nil {
@@ -74,21 +83,62 @@ func main() {
 		fmt.Printf("Simplifying prog options: %v\n", stats.SimplifyProgTime)
 		fmt.Printf("Extracting C: %v\n", stats.ExtractCTime)
 		fmt.Printf("Simplifying C: %v\n", stats.SimplifyCTime)
+		fmt.Printf("Verifying: %v\n", stats.VerifyTime)
 	}
 	if res == nil {
+		if interrupted {
+			log.Logf(0, "interrupted before anything was reproduced")
+			os.Exit(osutil.ExitInterrupted)
+		}
 		return
 	}
 
-	fmt.Printf("opts: %+v crepro: %v\n\n", res.Opts, res.CRepro)
-	fmt.Printf("%s\n", res.Prog.Serialize())
+	fmt.Printf("opts: %+v crepro: %v verified: %v environment-sensitive: %v partial: %v\n\n",
+		res.Opts, res.CRepro, res.Verified, res.EnvironmentSensitive, res.Partial)
+	partial := ""
+	if res.Partial {
+		partial = "# " + partialMarker + "\n"
+	}
+	fmt.Printf("%s%s\n", partial, res.Prog.Serialize())
+	var src []byte
 	if res.CRepro {
-		src, err := csource.Write(res.Prog, res.Opts)
+		src, err = csource.Write(res.Prog, res.Opts)
 		if err != nil {
 			log.Fatalf("failed to generate C repro: %v", err)
 		}
 		if formatted, err := csource.Format(src); err == nil {
 			src = formatted
 		}
+		if res.Partial {
+			src = append([]byte("// "+partialMarker+"\n"), src...)
+		}
 		fmt.Printf("%s\n", src)
 	}
+	if logFile != flag.Args()[0] {
+		// Reproducing a saved crash, store the result next to it as syz-manager does.
+		dir := filepath.Dir(filepath.Clean(flag.Args()[0]))
+		repro := &crashdir.Repro{
+			Prog:         append([]byte(fmt.Sprintf("# %+v\n%v", res.Opts, partial)), res.Prog.Serialize()...),
+			CProg:        src,
+			Log:          res.Report.Output,
+			Report:       res.Report.Report,
+			Tag:          cfg.Tag,
+			Descriptions: target.Revision,
+		}
+		if err := crashdir.SaveRepro(dir, res.Report.Title, repro); err != nil {
+			log.Fatalf("failed to save repro: %v", err)
+		}
+		if res.Partial {
+			// Partial reproducers are shown as needing re-verification.
+			if err := crashdir.MarkRepro(dir, crashdir.ID(res.Report.Title), target.Revision,
+				partialMarker); err != nil {
+				log.Fatalf("failed to mark repro: %v", err)
+			}
+		}
+	}
+	if interrupted {
+		os.Exit(osutil.ExitInterrupted)
+	}
 }
+
+const partialMarker = "partial: reproduction was interrupted, the reproducer may be not minimized and is not verified"
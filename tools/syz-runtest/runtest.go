@@ -3,6 +3,10 @@
 
 // Runtest runs syzkaller test programs in sys/*/test/*. Start as:
 // $ syz-runtest -config manager.config
+// With -check_descriptions it instead checks syscall descriptions against the kernel:
+// it executes simple invocations of every enabled syscall and reports calls that always fail
+// with EINVAL/ENOSYS/EFAULT grouped by description file (see runtest.DescCheck):
+// $ syz-runtest -config manager.config -check_descriptions -calls '^ioctl\$DRM'
 // Also see pkg/runtest docs.
 package main
 
@@ -15,6 +19,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -33,6 +38,13 @@ import (
 var (
 	flagConfig = flag.String("config", "", "manager config")
 	flagDebug  = flag.Bool("debug", false, "debug mode")
+
+	flagCheckDesc = flag.Bool("check_descriptions", false, "check syscall descriptions instead of running tests")
+	flagCalls     = flag.String("calls", "", "regexp of calls to check (all enabled calls by default)")
+	flagSkip      = flag.String("skip", runtest.DefaultDescCheckSkip, "regexp of calls to not execute")
+	flagRuns      = flag.Int("runs", 10, "invocations of each call")
+	flagRate      = flag.Float64("rate", 100, "max invocations per second (0 for no limit)")
+	flagAll       = flag.Bool("all", false, "report all checked calls, not only suspicious")
 )
 
 func main() {
@@ -46,7 +58,13 @@ func main() {
 		log.Fatal(err)
 	}
 	testDir := filepath.Join(cfg.Syzkaller, "sys", target.OS, "test")
-	if err := testParsing(target, testDir); err != nil {
+	var descCheck *runtest.DescCheck
+	var descCalls *regexp.Regexp
+	if *flagCheckDesc {
+		if descCheck, descCalls, err = createDescCheck(cfg, target); err != nil {
+			log.Fatal(err)
+		}
+	} else if err := testParsing(target, testDir); err != nil {
 		log.Fatal(err)
 	}
 	vmPool, err := vm.Create(cfg, *flagDebug)
@@ -115,15 +133,19 @@ func main() {
 	for sandbox, calls := range enabledCalls {
 		fmt.Printf("%-24v: %v calls enabled\n", sandbox+" sandbox", len(calls))
 	}
-	ctx := &runtest.Context{
-		Dir:          testDir,
-		Target:       target,
-		Features:     mgr.checkResult.Features,
-		EnabledCalls: enabledCalls,
-		Requests:     mgr.requests,
-		LogFunc:      func(text string) { fmt.Println(text) },
+	if descCheck != nil {
+		err = runDescCheck(descCheck, mgr, enabledCalls[cfg.Sandbox], descCalls)
+	} else {
+		ctx := &runtest.Context{
+			Dir:          testDir,
+			Target:       target,
+			Features:     mgr.checkResult.Features,
+			EnabledCalls: enabledCalls,
+			Requests:     mgr.requests,
+			LogFunc:      func(text string) { fmt.Println(text) },
+		}
+		err = ctx.Run()
 	}
-	err = ctx.Run()
 	close(vm.Shutdown)
 	wg.Wait()
 	if err != nil {
@@ -132,6 +154,49 @@ func main() {
 	}
 }
 
+func createDescCheck(cfg *mgrconfig.Config, target *prog.Target) (*runtest.DescCheck, *regexp.Regexp, error) {
+	calls, err := regexp.Compile(*flagCalls)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad -calls: %v", err)
+	}
+	skip, err := regexp.Compile(*flagSkip)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad -skip: %v", err)
+	}
+	files, err := runtest.DescriptionFiles(filepath.Join(cfg.Syzkaller, "sys", target.OS))
+	if err != nil {
+		return nil, nil, err
+	}
+	dc := &runtest.DescCheck{
+		Target:  target,
+		Sandbox: cfg.Sandbox,
+		Files:   files,
+		Skip:    skip,
+		Runs:    *flagRuns,
+		Rate:    *flagRate,
+		Seed:    time.Now().UnixNano(),
+		LogFunc: func(text string) { fmt.Println(text) },
+	}
+	return dc, calls, nil
+}
+
+func runDescCheck(dc *runtest.DescCheck, mgr *Manager, enabled map[*prog.Syscall]bool, calls *regexp.Regexp) error {
+	dc.Features = mgr.checkResult.Features
+	dc.Requests = mgr.requests
+	dc.EnabledCalls = make(map[*prog.Syscall]bool)
+	for call := range enabled {
+		if calls.MatchString(call.Name) {
+			dc.EnabledCalls[call] = true
+		}
+	}
+	results, err := dc.Run()
+	if err != nil {
+		return err
+	}
+	runtest.WriteDescCheckReport(os.Stdout, mgr.target.OS, results, *flagAll)
+	return nil
+}
+
 type Manager struct {
 	cfg              *mgrconfig.Config
 	target           *prog.Target
@@ -0,0 +1,64 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// syz-soak repeatedly creates and destroys VMs described by a manager config
+// and reports failures, leaked host resources and latencies. Usage:
+//   syz-soak -config=config.file [-cycles=100] [-duration=1h] [-vms=0,1]
+// Intended for validation of VM backends before they are used in production.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/osutil"
+	"github.com/google/syzkaller/vm"
+)
+
+var (
+	flagConfig   = flag.String("config", "", "configuration file")
+	flagCycles   = flag.Int("cycles", 100, "number of create/destroy cycles of each VM (0 for unlimited)")
+	flagDuration = flag.Duration("duration", 0, "time limit of the test (0 for unlimited)")
+	flagVMs      = flag.String("vms", "", "comma-separated indexes of VMs to cycle (default: all)")
+	flagDebug    = flag.Bool("debug", false, "print debug output of VMs")
+)
+
+func main() {
+	flag.Parse()
+	cfg, err := mgrconfig.LoadFile(*flagConfig)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	opts := &vm.SoakOptions{
+		Cycles:   *flagCycles,
+		Duration: *flagDuration,
+	}
+	if *flagVMs != "" {
+		for _, idx := range strings.Split(*flagVMs, ",") {
+			index, err := strconv.Atoi(strings.TrimSpace(idx))
+			if err != nil {
+				log.Fatalf("bad VM index %q: %v", idx, err)
+			}
+			opts.Indexes = append(opts.Indexes, index)
+		}
+	}
+	vmPool, err := vm.Create(cfg, *flagDebug)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	osutil.HandleInterrupts(vm.Shutdown)
+	log.Logf(0, "cycling %v VMs...", cfg.Type)
+	res, err := vmPool.Soak(opts)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	fmt.Print(res)
+	if res.Failures != 0 || len(res.Leaks()) != 0 {
+		os.Exit(1)
+	}
+}
@@ -37,6 +37,11 @@ type Config struct {
 	// This option is enabled by default. Turn it off if your devices
 	// don't have battery service, or it causes problems otherwise.
 	BatteryCheck bool `json:"battery_check"`
+
+	// Source of kernel log: "" (default, serial console associated with the device if found,
+	// otherwise "dmesg -w" over adb shell) or "kmsg" (/dev/kmsg over adb shell, survives reboots
+	// and does not repeat records after reconnection).
+	Console string `json:"console"`
 }
 
 type Pool struct {
@@ -66,6 +71,9 @@ func ctor(env *vmimpl.Env) (vmimpl.Pool, error) {
 	if len(cfg.Devices) == 0 {
 		return nil, fmt.Errorf("no adb devices specified")
 	}
+	if cfg.Console != "" && cfg.Console != "kmsg" {
+		return nil, fmt.Errorf("unknown console %q, want kmsg", cfg.Console)
+	}
 	devRe := regexp.MustCompile("[0-9A-F]+")
 	for _, dev := range cfg.Devices {
 		if !devRe.MatchString(dev) {
@@ -102,7 +110,11 @@ func (pool *Pool) Create(workdir string, index int) (vmimpl.Instance, error) {
 	if err := inst.repair(); err != nil {
 		return nil, err
 	}
-	inst.console = findConsole(inst.adbBin, inst.device)
+	if pool.cfg.Console == "kmsg" {
+		inst.console = "kmsg"
+	} else {
+		inst.console = findConsole(inst.adbBin, inst.device)
+	}
 	if pool.cfg.BatteryCheck {
 		if err := inst.checkBatteryLevel(); err != nil {
 			return nil, err
@@ -113,6 +125,10 @@ func (pool *Pool) Create(workdir string, index int) (vmimpl.Instance, error) {
 		return nil, err
 	}
 	inst.adb("shell", "echo 0 > /proc/sys/kernel/kptr_restrict")
+	if pool.env.ReadPstore {
+		// The device persists across instances, so records of previous crashes are still there.
+		inst.adb("shell", vmimpl.PstoreClearCommand)
+	}
 	closeInst = nil
 	return inst, nil
 }
@@ -237,6 +253,10 @@ func (inst *instance) Forward(port int) (string, error) {
 		if err == nil {
 			return fmt.Sprintf("127.0.0.1:%v", devicePort), nil
 		}
+		// Old adb versions don't have the reverse command, there is no point in trying other ports.
+		if verr, ok := err.(*osutil.VerboseError); ok && bytes.Contains(verr.Output, []byte("unknown command")) {
+			return "", vmimpl.ErrUnsupported
+		}
 	}
 	return "", err
 }
@@ -357,7 +377,7 @@ func (inst *instance) Close() {
 func (inst *instance) Copy(hostSrc string) (string, error) {
 	vmDst := filepath.Join("/data", filepath.Base(hostSrc))
 	if _, err := inst.adb("push", hostSrc, vmDst); err != nil {
-		return "", err
+		return "", vmimpl.ClassifyCopyError(err)
 	}
 	return vmDst, nil
 }
@@ -366,9 +386,14 @@ func (inst *instance) Run(timeout time.Duration, stop <-chan bool, command strin
 	<-chan []byte, <-chan error, error) {
 	var tty io.ReadCloser
 	var err error
-	if inst.console == "adb" {
+	switch inst.console {
+	case "adb":
 		tty, err = vmimpl.OpenAdbConsole(inst.adbBin, inst.device)
-	} else {
+	case "kmsg":
+		tty, err = vmimpl.OpenKmsgConsole(func() (io.ReadCloser, error) {
+			return vmimpl.OpenRemoteCommand(inst.adbBin, "-s", inst.device, "shell", vmimpl.KmsgCommand)
+		})
+	default:
 		tty, err = vmimpl.OpenConsole(inst.console)
 	}
 	if err != nil {
@@ -405,6 +430,20 @@ func (inst *instance) Run(timeout time.Duration, stop <-chan bool, command strin
 	return vmimpl.Multiplex(adb, merger, tty, timeout, stop, inst.closed, inst.debug)
 }
 
+// ReadPstore waits for the device to come back after the crash (e.g. after panic reboot)
+// and returns pstore records left by the crashed kernel.
+func (inst *instance) ReadPstore() ([]byte, error) {
+	if err := inst.waitForSSH(); err != nil {
+		return nil, fmt.Errorf("device did not come back after crash: %v", err)
+	}
+	// The device may come back as non-root after reboot.
+	inst.adb("root")
+	if err := inst.waitForSSH(); err != nil {
+		return nil, err
+	}
+	return inst.adb("shell", vmimpl.PstoreCommand)
+}
+
 func (inst *instance) Diagnose() bool {
 	return false
 }
@@ -0,0 +1,58 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+// blockStats returns I/O statistics of the VM block devices, or nil if the VM does not provide them.
+func (inst *Instance) blockStats() []vmimpl.BlockStats {
+	statser, ok := inst.impl.(vmimpl.BlockStatser)
+	if !ok {
+		return nil
+	}
+	stats, err := statser.BlockStats()
+	if err != nil {
+		log.Logf(0, "vm-%v: failed to query block device stats: %v", inst.index, err)
+		return nil
+	}
+	return stats
+}
+
+// blockStatsDelta returns I/O statistics of the run given statistics at its start and end.
+// Devices that are missing at the start (or were reset since then) are reported as is.
+func blockStatsDelta(start, end []vmimpl.BlockStats) []vmimpl.BlockStats {
+	var res []vmimpl.BlockStats
+	for _, st := range end {
+		for _, st0 := range start {
+			if st0.Device != st.Device || st0.ReadOps > st.ReadOps || st0.WriteOps > st.WriteOps ||
+				st0.ReadBytes > st.ReadBytes || st0.WriteBytes > st.WriteBytes || st0.Errors > st.Errors {
+				continue
+			}
+			st.ReadOps -= st0.ReadOps
+			st.ReadBytes -= st0.ReadBytes
+			st.WriteOps -= st0.WriteOps
+			st.WriteBytes -= st0.WriteBytes
+			st.Errors -= st0.Errors
+			break
+		}
+		res = append(res, st)
+	}
+	return res
+}
+
+func formatBlockStats(stats []vmimpl.BlockStats) []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "\nblock device I/O during the run:\n")
+	for _, st := range stats {
+		fmt.Fprintf(buf, "%v: %v reads (%v bytes), %v writes (%v bytes), %v errors\n",
+			st.Device, st.ReadOps, st.ReadBytes, st.WriteOps, st.WriteBytes, st.Errors)
+	}
+	return buf.Bytes()
+}
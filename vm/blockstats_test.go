@@ -0,0 +1,36 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+func TestBlockStatsDelta(t *testing.T) {
+	start := []vmimpl.BlockStats{
+		{Device: "hd0", ReadOps: 100, ReadBytes: 4096, WriteOps: 10, WriteBytes: 512, Errors: 1},
+		{Device: "vda", ReadOps: 50, ReadBytes: 1000},
+	}
+	end := []vmimpl.BlockStats{
+		{Device: "hd0", ReadOps: 150, ReadBytes: 8192, WriteOps: 10, WriteBytes: 1024, Errors: 1},
+		{Device: "vda", ReadOps: 5, ReadBytes: 100},
+		{Device: "vdb", WriteOps: 1, WriteBytes: 512},
+	}
+	want := []vmimpl.BlockStats{
+		{Device: "hd0", ReadOps: 50, ReadBytes: 4096, WriteOps: 0, WriteBytes: 512},
+		{Device: "vda", ReadOps: 5, ReadBytes: 100},
+		{Device: "vdb", WriteOps: 1, WriteBytes: 512},
+	}
+	if got := blockStatsDelta(start, end); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got stats:\n%+v\nwant:\n%+v", got, want)
+	}
+	wantText := "\nblock device I/O during the run:\n" +
+		"hd0: 50 reads (4096 bytes), 0 writes (512 bytes), 0 errors\n"
+	if got := string(formatBlockStats(want[:1])); got != wantText {
+		t.Fatalf("got:\n%q\nwant:\n%q", got, wantText)
+	}
+}
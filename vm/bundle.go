@@ -0,0 +1,188 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/osutil"
+	"github.com/google/syzkaller/pkg/report"
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+// Crash bundles (bundle_crashes config) keep everything about a crash in one directory:
+//	crash-<n>/report.json       - the parsed report (bundleReport)
+//	crash-<n>/console.log       - full console output
+//	crash-<n>/console.timed     - console output with timing (if timed_console_log is configured)
+//	crash-<n>/machine-info.json - the VM and the kernel it runs (bundleMachine)
+//	crash-<n>/artifacts/        - files produced by the VM implementation and copied out of the VM
+
+type bundleReport struct {
+	Title            string        `json:"title"`
+	Report           string        `json:"report"`
+	Corrupted        bool          `json:"corrupted,omitempty"`
+	CorruptedReason  string        `json:"corrupted_reason,omitempty"`
+	Incomplete       bool          `json:"incomplete,omitempty"`
+	IncompleteReason string        `json:"incomplete_reason,omitempty"`
+	Origin           string        `json:"origin,omitempty"`
+	Time             time.Time     `json:"time"`
+	GuestUptime      time.Duration `json:"guest_uptime,omitempty"`
+	Repeats          int           `json:"repeats,omitempty"`
+	Liveness         string        `json:"liveness,omitempty"`
+	Cmdline          string        `json:"cmdline,omitempty"`
+}
+
+type bundleMachine struct {
+	Type      string `json:"type"`
+	Name      string `json:"name"`
+	Index     int    `json:"index"`
+	KernelTag string `json:"kernel_tag,omitempty"`
+	Image     string `json:"image,omitempty"`
+	ImageHash string `json:"image_hash,omitempty"`
+	Info      string `json:"info,omitempty"` // information provided by the VM implementation
+}
+
+// saveBundle saves the crash bundle for rep. Failures to collect individual artifacts
+// are logged and don't prevent creation of the bundle.
+func (inst *Instance) saveBundle(rep *report.Report) (string, error) {
+	dir, err := inst.pool.newBundleDir()
+	if err != nil {
+		return "", err
+	}
+	machine := inst.machineInfo()
+	machine.Info = string(rep.Info)
+	if err := writeBundleJSON(filepath.Join(dir, "report.json"), newBundleReport(rep)); err != nil {
+		return "", err
+	}
+	if err := writeBundleJSON(filepath.Join(dir, "machine-info.json"), machine); err != nil {
+		return "", err
+	}
+	if err := osutil.WriteFile(filepath.Join(dir, "console.log"), rep.Output); err != nil {
+		return "", err
+	}
+	if len(rep.Ftrace) != 0 {
+		if err := osutil.WriteFile(filepath.Join(dir, "ftrace.txt"), rep.Ftrace); err != nil {
+			return "", err
+		}
+	}
+	if len(rep.TimedConsole) != 0 {
+		if err := osutil.WriteFile(filepath.Join(dir, "console.timed"), rep.TimedConsole); err != nil {
+			return "", err
+		}
+	}
+	artifacts := filepath.Join(dir, "artifacts")
+	if err := osutil.MkdirAll(artifacts); err != nil {
+		return "", err
+	}
+	if artifacter, ok := inst.impl.(vmimpl.Artifacter); ok {
+		for _, file := range artifacter.Artifacts() {
+			if err := osutil.CopyFile(file, filepath.Join(artifacts, filepath.Base(file))); err != nil {
+				log.Logf(0, "vm-%v: failed to save artifact %v: %v", inst.index, file, err)
+			}
+		}
+	}
+	for _, file := range inst.pool.bundle.GuestFiles {
+		dst := filepath.Join(artifacts, "guest"+strings.Replace(file, "/", "_", -1))
+		if err := inst.CopyOut(file, dst); err != nil {
+			if err == ErrUnsupported {
+				log.Logf(0, "vm-%v: %v VMs don't support copying files out, not saving guest files",
+					inst.index, inst.pool.typ)
+				break
+			}
+			log.Logf(0, "vm-%v: failed to copy out %v: %v", inst.index, file, err)
+		}
+	}
+	return dir, nil
+}
+
+func newBundleReport(rep *report.Report) *bundleReport {
+	return &bundleReport{
+		Title:            rep.Title,
+		Report:           string(rep.Report),
+		Corrupted:        rep.Corrupted,
+		CorruptedReason:  rep.CorruptedReason,
+		Incomplete:       rep.Incomplete,
+		IncompleteReason: rep.IncompleteReason,
+		Origin:           rep.Origin,
+		Time:             rep.Time,
+		GuestUptime:      rep.GuestUptime,
+		Repeats:          rep.Repeats,
+		Liveness:         rep.Liveness,
+		Cmdline:          rep.Cmdline,
+	}
+}
+
+// machineInfo returns information about the VM and the kernel it runs (without VM implementation info).
+func (inst *Instance) machineInfo() *bundleMachine {
+	return &bundleMachine{
+		Type:      inst.pool.typ,
+		Name:      inst.pool.name,
+		Index:     inst.index,
+		KernelTag: inst.KernelTag(),
+		Image:     inst.pool.image,
+		ImageHash: inst.pool.imageHash(),
+	}
+}
+
+// imageHash returns hash of the VM image, it's computed once (images are big).
+func (pool *Pool) imageHash() string {
+	pool.imageHashOnce.Do(func() {
+		if pool.image == "" {
+			return
+		}
+		var err error
+		if pool.imageHashVal, err = vmimpl.FileHash(pool.image); err != nil {
+			log.Logf(0, "failed to hash image: %v", err)
+		}
+	})
+	return pool.imageHashVal
+}
+
+func writeBundleJSON(file string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	return osutil.WriteFile(file, data)
+}
+
+// newBundleDir creates a new crash-<n> dir in the bundles dir.
+func (pool *Pool) newBundleDir() (string, error) {
+	if err := osutil.MkdirAll(pool.bundle.Dir); err != nil {
+		return "", err
+	}
+	pool.bundleMu.Lock()
+	defer pool.bundleMu.Unlock()
+	for ; ; pool.bundleSeq++ {
+		dir := filepath.Join(pool.bundle.Dir, fmt.Sprintf("crash-%v", pool.bundleSeq))
+		err := os.Mkdir(dir, osutil.DefaultDirPerm)
+		if err == nil {
+			pool.bundleSeq++
+			return dir, nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+	}
+}
+
+// CopyOut copies the file vmSrc from the VM to hostDst.
+// Returns ErrUnsupported if the VM can't read files (see vmimpl.FileReader).
+func (inst *Instance) CopyOut(vmSrc, hostDst string) error {
+	reader, ok := inst.impl.(vmimpl.FileReader)
+	if !ok {
+		return ErrUnsupported
+	}
+	data, err := reader.ReadFile(vmSrc)
+	if err != nil {
+		return err
+	}
+	return osutil.WriteFile(hostDst, data)
+}
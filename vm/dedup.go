@@ -0,0 +1,107 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+)
+
+// outputDedup suppresses exact repeats of blocks of console output lines.
+// Some kernel code paths print the same WARNING (or any other message) thousands of times
+// per second once triggered, and monitor spends lots of time accumulating identical output.
+// The first instance of a block is always passed through as is, subsequent exact repeats
+// are replaced with a single line with the number of suppressed repeats.
+// Lines are compared with console timestamps stripped, but otherwise exactly,
+// so blocks that differ only in addresses are not merged.
+type outputDedup struct {
+	hashes  []uint64 // hashes of recent lines
+	period  int      // length of the block that is being repeated (0 if none)
+	matched int      // number of lines of the current repeat matched so far
+	repeats int      // number of suppressed repeats of the block
+	held    []byte   // lines of the current repeat (incomplete)
+}
+
+// process consumes a chunk of output and returns output that should be passed through.
+func (dd *outputDedup) process(data []byte) []byte {
+	var res []byte
+	for len(data) != 0 {
+		end := bytes.IndexByte(data, '\n') + 1
+		if end == 0 {
+			end = len(data)
+		}
+		res = dd.processLine(res, data[:end])
+		data = data[end:]
+	}
+	return res
+}
+
+func (dd *outputDedup) processLine(res, line []byte) []byte {
+	h := dedupHash(line)
+	if dd.period != 0 && dd.hashes[len(dd.hashes)-dd.period] != h {
+		res = dd.flush(res)
+	}
+	if dd.period == 0 {
+		dd.period = dd.findPeriod(h)
+	}
+	dd.push(h)
+	if dd.period == 0 {
+		return append(res, line...)
+	}
+	dd.held = append(dd.held, line...)
+	if dd.matched++; dd.matched == dd.period {
+		dd.repeats++
+		dd.matched = 0
+		dd.held = dd.held[:0]
+	}
+	return res
+}
+
+// flush appends the number of suppressed repeats and the held incomplete repeat to res.
+func (dd *outputDedup) flush(res []byte) []byte {
+	if dd.repeats != 0 {
+		res = append(res, fmt.Sprintf("syzkaller: previous %v lines repeated %v more times\n",
+			dd.period, dd.repeats)...)
+	}
+	res = append(res, dd.held...)
+	dd.period = 0
+	dd.matched = 0
+	dd.repeats = 0
+	dd.held = dd.held[:0]
+	return res
+}
+
+func (dd *outputDedup) findPeriod(h uint64) int {
+	for i := len(dd.hashes) - 1; i >= 0; i-- {
+		if dd.hashes[i] == h {
+			return len(dd.hashes) - i
+		}
+	}
+	return 0
+}
+
+func (dd *outputDedup) push(h uint64) {
+	if len(dd.hashes) >= 2*dedupMaxBlock {
+		n := copy(dd.hashes, dd.hashes[len(dd.hashes)-dedupMaxBlock:])
+		dd.hashes = dd.hashes[:n]
+	}
+	dd.hashes = append(dd.hashes, h)
+}
+
+func dedupHash(line []byte) uint64 {
+	if match := dedupTimestampRe.FindIndex(line); match != nil {
+		line = line[match[1]:]
+	}
+	hash := fnv.New64a()
+	hash.Write(line)
+	return hash.Sum64()
+}
+
+var (
+	// Max length of blocks (in lines) that are detected as repeated.
+	dedupMaxBlock    = 256
+	dedupTimestampRe = regexp.MustCompile(`^(?:\<[0-9]+\>)?\[ *[0-9]+\.[0-9]+\] `)
+)
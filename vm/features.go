@@ -0,0 +1,225 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/report"
+)
+
+// BootFeature is a kernel feature that can be enabled per VM, with a kernel command line argument
+// (the VM type must support vmimpl.CmdlineCreator), with a setup command run in the VM after boot
+// (the VM type must support vmimpl.Execer), or both.
+type BootFeature struct {
+	Name    string
+	Cmdline string // e.g. "kasan.fault=panic"
+	Setup   string // e.g. "sysctl -w net.core.bpf_jit_enable=1" or "modprobe vhost_net"
+}
+
+// FeatureBisectOptions describe how BisectFeatures runs the reproducer.
+type FeatureBisectOptions struct {
+	// Features that can be toggled, the reproducer must crash the kernel with all of them enabled.
+	Features []BootFeature
+	// Indexes of VMs to use (all VMs of the pool if empty).
+	Indexes []int
+	// Host files that are copied into every VM before running the reproducer (e.g. syz-execprog, the program).
+	Files []string
+	// Command returns command that runs the reproducer in VM,
+	// files are VM paths of Files (in the same order).
+	Command func(files []string) string
+	// Number of runs of the reproducer with each feature set (1 if 0),
+	// the feature set reproduces the crash if any of the runs does.
+	Attempts int
+	// Timeout for a single run of the reproducer.
+	Timeout  time.Duration
+	Reporter report.Reporter
+}
+
+// FeatureBisectResult is the result of BisectFeatures.
+type FeatureBisectResult struct {
+	Features []string       // names of the features required to reproduce the crash
+	Report   *report.Report // the crash reproduced with Features
+	Sets     int            // number of tested feature sets
+}
+
+// Timeout for setup commands of features.
+const featureSetupTimeout = time.Minute
+
+// BisectFeatures finds a minimal set of features that is required to reproduce a crash.
+// First the reproducer is run with all features enabled, then each round tries to disable each of
+// the enabled features (feature sets of a round are tested in parallel on the VMs), and the first
+// feature (in the order of Features) without which the crash still reproduces is disabled.
+// The result is 1-minimal: disabling any of the remaining features makes the crash go away.
+// Only the crash with the same title as the crash with all features enabled counts as reproduction.
+// Every run uses a freshly booted VM, so that features enabled by previous runs don't leak.
+func (pool *Pool) BisectFeatures(opts *FeatureBisectOptions) (*FeatureBisectResult, error) {
+	names := make(map[string]bool)
+	for _, feature := range opts.Features {
+		if feature.Name == "" || names[feature.Name] {
+			return nil, fmt.Errorf("empty or duplicate feature name %q", feature.Name)
+		}
+		names[feature.Name] = true
+	}
+	indexes := opts.Indexes
+	if len(indexes) == 0 {
+		for i := 0; i < pool.Count(); i++ {
+			indexes = append(indexes, i)
+		}
+	}
+	enabled := opts.Features
+	reps, err := pool.testFeatureSets(indexes, [][]BootFeature{enabled}, "", opts)
+	if err != nil {
+		return nil, err
+	}
+	if reps[0] == nil {
+		return nil, fmt.Errorf("the crash does not reproduce with all features enabled")
+	}
+	res := &FeatureBisectResult{
+		Report: reps[0],
+		Sets:   1,
+	}
+	title := res.Report.Title
+	log.Logf(0, "bisecting features %v of crash %v", featureNames(enabled), title)
+	for len(enabled) != 0 {
+		var sets [][]BootFeature
+		for i := range enabled {
+			set := append(append([]BootFeature{}, enabled[:i]...), enabled[i+1:]...)
+			sets = append(sets, set)
+		}
+		reps, err := pool.testFeatureSets(indexes, sets, title, opts)
+		if err != nil {
+			return nil, err
+		}
+		res.Sets += len(sets)
+		disabled := -1
+		for i, rep := range reps {
+			if rep != nil {
+				disabled = i
+				break
+			}
+		}
+		if disabled == -1 {
+			break
+		}
+		log.Logf(0, "the crash reproduces without %v", enabled[disabled].Name)
+		enabled = sets[disabled]
+		res.Report = reps[disabled]
+	}
+	res.Features = featureNames(enabled)
+	log.Logf(0, "the crash requires features %v (tested %v feature sets)", res.Features, res.Sets)
+	return res, nil
+}
+
+// testFeatureSets tests the feature sets in parallel on the VMs and returns the crash
+// reproduced with each set (nil if it did not reproduce). If title is not empty,
+// only crashes with that title count.
+func (pool *Pool) testFeatureSets(indexes []int, sets [][]BootFeature, title string,
+	opts *FeatureBisectOptions) ([]*report.Report, error) {
+	reps := make([]*report.Report, len(sets))
+	errs := make([]error, len(sets))
+	work := make(chan int, len(sets))
+	for i := range sets {
+		work <- i
+	}
+	close(work)
+	var wg sync.WaitGroup
+	for _, index := range indexes {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			for i := range work {
+				reps[i], errs[i] = pool.testFeatureSet(index, sets[i], title, opts)
+			}
+		}(index)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("features %v: %v", featureNames(sets[i]), err)
+		}
+	}
+	return reps, nil
+}
+
+func (pool *Pool) testFeatureSet(index int, features []BootFeature, title string,
+	opts *FeatureBisectOptions) (*report.Report, error) {
+	attempts := opts.Attempts
+	if attempts == 0 {
+		attempts = 1
+	}
+	for attempt := 0; attempt < attempts; attempt++ {
+		rep, err := pool.runWithFeatures(index, features, opts)
+		if err != nil {
+			return nil, err
+		}
+		if rep == nil || rep.Suppressed {
+			continue
+		}
+		if title != "" && rep.Title != title {
+			log.Logf(1, "vm-%v: features %v: got another crash: %v", index, featureNames(features), rep.Title)
+			continue
+		}
+		return rep, nil
+	}
+	return nil, nil
+}
+
+// runWithFeatures boots a VM with the features enabled and runs the reproducer once.
+func (pool *Pool) runWithFeatures(index int, features []BootFeature, opts *FeatureBisectOptions) (
+	*report.Report, error) {
+	cmdline := ""
+	for _, feature := range features {
+		if feature.Cmdline != "" {
+			cmdline += " " + feature.Cmdline
+		}
+	}
+	var inst *Instance
+	var err error
+	if cmdline != "" {
+		inst, err = pool.CreateWithCmdline(index, cmdline[1:])
+	} else {
+		inst, err = pool.Create(index)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create VM: %v", err)
+	}
+	defer inst.Close()
+	for _, feature := range features {
+		if feature.Setup == "" {
+			continue
+		}
+		_, stderr, exitCode, err := inst.Exec(feature.Setup, featureSetupTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up %v: %v", feature.Name, err)
+		}
+		if exitCode != 0 {
+			return nil, fmt.Errorf("failed to set up %v: exit code %v: %s", feature.Name, exitCode, stderr)
+		}
+	}
+	var files []string
+	for _, file := range opts.Files {
+		vmFile, err := inst.Copy(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to copy %v: %v", file, err)
+		}
+		files = append(files, vmFile)
+	}
+	outc, errc, err := inst.Run(opts.Timeout, nil, opts.Command(files))
+	if err != nil {
+		return nil, fmt.Errorf("failed to run reproducer: %v", err)
+	}
+	return inst.MonitorExecution(outc, errc, opts.Reporter, true), nil
+}
+
+func featureNames(features []BootFeature) []string {
+	names := []string{}
+	for _, feature := range features {
+		names = append(names, feature.Name)
+	}
+	return names
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+// testFeaturePool creates instances that crash when running the reproducer only if they were booted
+// with "kasan=on" and "sysctl -w vm.foo=1" was run after boot. Without "kasan=on" the sysctl alone
+// causes a different crash.
+type testFeaturePool struct {
+	mu      sync.Mutex
+	creates int
+}
+
+func (pool *testFeaturePool) Count() int {
+	return 2
+}
+
+func (pool *testFeaturePool) Create(workdir string, index int) (vmimpl.Instance, error) {
+	return pool.CreateWithCmdline(workdir, index, "")
+}
+
+func (pool *testFeaturePool) CreateWithCmdline(workdir string, index int, cmdline string) (vmimpl.Instance, error) {
+	pool.mu.Lock()
+	pool.creates++
+	pool.mu.Unlock()
+	return &testFeatureInstance{
+		testInstance: testInstance{outc: make(chan []byte, 10)},
+		cmdline:      cmdline,
+	}, nil
+}
+
+type testFeatureInstance struct {
+	testInstance
+	cmdline string
+	setup   []string
+}
+
+func (inst *testFeatureInstance) Exec(command string, timeout time.Duration) ([]byte, []byte, int, error) {
+	inst.setup = append(inst.setup, command)
+	return nil, nil, 0, nil
+}
+
+func (inst *testFeatureInstance) Run(timeout time.Duration, stop <-chan bool, command string) (
+	<-chan []byte, <-chan error, error) {
+	errc := make(chan error, 1)
+	kasan := strings.Contains(inst.cmdline, "kasan=on")
+	sysctl := false
+	for _, cmd := range inst.setup {
+		sysctl = sysctl || cmd == "sysctl -w vm.foo=1"
+	}
+	switch {
+	case kasan && sysctl:
+		inst.outc <- []byte("executing program\nBUG: feature crash\n")
+	case sysctl:
+		inst.outc <- []byte("executing program\nBUG: other crash\n")
+	default:
+		inst.outc <- []byte("executing program\n")
+		errc <- nil
+	}
+	return inst.outc, errc, nil
+}
+
+func init() {
+	ctor := func(env *vmimpl.Env) (vmimpl.Pool, error) {
+		return &testFeaturePool{}, nil
+	}
+	vmimpl.Register("test-features", ctor, false)
+}
+
+func TestBisectFeatures(t *testing.T) {
+	t.Parallel()
+	cfg := &mgrconfig.Config{Type: "test-features"}
+	pool, reporter := createTestPool(t, cfg)
+	defer os.RemoveAll(cfg.Workdir)
+	opts := &FeatureBisectOptions{
+		Features: []BootFeature{
+			{Name: "quiet", Cmdline: "quiet"},
+			{Name: "kasan", Cmdline: "kasan=on"},
+			{Name: "module", Setup: "modprobe foo"},
+			{Name: "sysctl", Setup: "sysctl -w vm.foo=1"},
+		},
+		Command: func(files []string) string {
+			return "repro"
+		},
+		Timeout:  time.Minute,
+		Reporter: reporter,
+	}
+	res, err := pool.BisectFeatures(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"kasan", "sysctl"}; !reflect.DeepEqual(res.Features, want) {
+		t.Fatalf("want features %v, got %v", want, res.Features)
+	}
+	if res.Report == nil || res.Report.Title != "BUG: feature crash" {
+		t.Fatalf("bad report: %+v", res.Report)
+	}
+	// All features, then without each of 4, 3 and 2 features (the last round finds nothing).
+	if res.Sets != 10 {
+		t.Fatalf("want 10 tested sets, got %v", res.Sets)
+	}
+	if creates := pool.impl.(*testFeaturePool).creates; creates != res.Sets {
+		t.Fatalf("want a VM per set, got %v VMs for %v sets", creates, res.Sets)
+	}
+
+	opts.Features = opts.Features[:3]
+	if _, err := pool.BisectFeatures(opts); err == nil {
+		t.Fatalf("bisected features of a crash that does not reproduce")
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"bytes"
+)
+
+// outputFilter applies the output filter of the VM type (see vmimpl.OutputFilterer) to whole lines
+// of console output before the output is checked for crashes. The raw output is kept
+// (bounded the same way as the monitor output) so that it can be saved as the crash log.
+type outputFilter struct {
+	filter  func(line []byte) []byte
+	partial []byte // the current line that is not finished yet
+	raw     []byte // tail of the unfiltered output
+}
+
+// process consumes a chunk of output and returns the filtered complete lines.
+func (of *outputFilter) process(data []byte) []byte {
+	of.raw = append(of.raw, data...)
+	if len(of.raw) > 2*beforeContext {
+		of.raw = append(of.raw[:0], of.raw[len(of.raw)-beforeContext:]...)
+	}
+	var res []byte
+	for len(data) != 0 {
+		end := bytes.IndexByte(data, '\n') + 1
+		if end == 0 {
+			of.partial = append(of.partial, data...)
+			break
+		}
+		line := data[:end]
+		if len(of.partial) != 0 {
+			line = append(of.partial, line...)
+			of.partial = nil
+		}
+		res = append(res, of.filter(line)...)
+		data = data[end:]
+	}
+	return res
+}
+
+// flush filters and returns the unfinished line, the output is about to be analyzed.
+func (of *outputFilter) flush() []byte {
+	if len(of.partial) == 0 {
+		return nil
+	}
+	res := of.filter(of.partial)
+	of.partial = nil
+	return res
+}
+
+// rawOutput returns a copy of the tail of the unfiltered output.
+func (of *outputFilter) rawOutput() []byte {
+	return append([]byte{}, of.raw...)
+}
@@ -0,0 +1,43 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+)
+
+// forwardCheckTimeout is the timeout for the connect test of verify_forward.
+var forwardCheckTimeout = 30 * time.Second
+
+// verifyForward checks that the VM can connect to the forwarded address addr (verify_forward config).
+func (inst *Instance) verifyForward(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("bad forwarded address %q: %v", addr, err)
+	}
+	start := time.Now()
+	output, err := inst.runCommand(forwardCheckTimeout, forwardCheckCommand(host, port))
+	if err != nil {
+		const maxOutput = 4 << 10
+		if len(output) > maxOutput {
+			output = output[len(output)-maxOutput:]
+		}
+		return fmt.Errorf("VM can't connect to the forwarded address %v (firewall or wrong host interface?): %v\n%s",
+			addr, err, output)
+	}
+	log.Logf(1, "vm-%v: verified forwarded address %v in %v", inst.index, addr, time.Since(start))
+	return nil
+}
+
+// forwardCheckCommand returns a command that exits with 0 if it can connect to host:port.
+// nc is tried first, the /dev/tcp bash fallback covers images without nc (or with nc without -z).
+func forwardCheckCommand(host, port string) string {
+	const connectTimeout = 10
+	return fmt.Sprintf("nc -z -w %v %v %v || bash -c 'exec 3<>/dev/tcp/%v/%v'",
+		connectTimeout, host, port, host, port)
+}
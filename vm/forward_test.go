@@ -0,0 +1,116 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+// testForwardPool creates instances that can connect only to reachable hosts,
+// connects to other hosts fail, "blackhole" hosts don't respond at all.
+type testForwardPool struct {
+	testPool
+}
+
+func (pool *testForwardPool) Create(workdir string, index int) (vmimpl.Instance, error) {
+	return &testForwardInstance{testInstance: testInstance{outc: make(chan []byte, 10)}}, nil
+}
+
+type testForwardInstance struct {
+	testInstance
+	host     string
+	commands []string
+}
+
+func (inst *testForwardInstance) Forward(port int) (string, error) {
+	return fmt.Sprintf("%v:%v", inst.host, port), nil
+}
+
+func (inst *testForwardInstance) Run(timeout time.Duration, stop <-chan bool, command string) (
+	<-chan []byte, <-chan error, error) {
+	inst.commands = append(inst.commands, command)
+	errc := make(chan error, 1)
+	switch {
+	case strings.Contains(command, "reachable"):
+		errc <- nil
+	case strings.Contains(command, "blackhole"):
+		go func() {
+			time.Sleep(timeout)
+			errc <- vmimpl.ErrTimeout
+		}()
+	default:
+		inst.outc <- []byte("nc: connect to unroutable port 1234 (tcp) failed: No route to host\n")
+		errc <- errors.New("exit status 1")
+	}
+	return inst.outc, errc, nil
+}
+
+func init() {
+	forwardCtor := func(env *vmimpl.Env) (vmimpl.Pool, error) {
+		return &testForwardPool{}, nil
+	}
+	vmimpl.Register("test-forward", forwardCtor, false)
+}
+
+func TestVerifyForward(t *testing.T) {
+	defer func(timeout time.Duration) { forwardCheckTimeout = timeout }(forwardCheckTimeout)
+	forwardCheckTimeout = time.Second
+	for _, verify := range []bool{false, true} {
+		cfg := &mgrconfig.Config{
+			Type:          "test-forward",
+			VerifyForward: verify,
+		}
+		pool, _ := createTestPool(t, cfg)
+		defer os.RemoveAll(cfg.Workdir)
+		for _, test := range []struct {
+			host string
+			fail string
+		}{
+			{"reachable", ""},
+			{"unroutable", "No route to host"},
+			{"blackhole", "timeout"},
+		} {
+			inst, err := pool.Create(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			testInst := inst.impl.(*testForwardInstance)
+			testInst.host = test.host
+			start := time.Now()
+			addr, err := inst.Forward(1234)
+			inst.Close()
+			if !verify {
+				if err != nil || addr != test.host+":1234" || len(testInst.commands) != 0 {
+					t.Fatalf("%v: forward is verified when it's not configured: %q, %v, %q",
+						test.host, addr, err, testInst.commands)
+				}
+				continue
+			}
+			if len(testInst.commands) != 1 || !strings.Contains(testInst.commands[0], test.host+" 1234") {
+				t.Fatalf("%v: bad commands: %q", test.host, testInst.commands)
+			}
+			if test.fail == "" {
+				if err != nil || addr != test.host+":1234" {
+					t.Fatalf("%v: forward failed: %q, %v", test.host, addr, err)
+				}
+				continue
+			}
+			if err == nil || !strings.Contains(err.Error(), "can't connect to the forwarded address") ||
+				!strings.Contains(err.Error(), test.fail) {
+				t.Fatalf("%v: got bad error: %v", test.host, err)
+			}
+			if time.Since(start) > 5*time.Second {
+				t.Fatalf("%v: forward failed too slowly: %v", test.host, time.Since(start))
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+)
+
+// The kernel ftrace ring buffer is read when a crash is detected (crash_ftrace config),
+// it contains a function-level timeline of events that led to the crash.
+// Tracing is armed by the image or the kernel command line, here the buffer is only read.
+// tracefs is mounted at /sys/kernel/tracing on newer kernels and is available
+// under debugfs at /sys/kernel/debug/tracing on older ones.
+const ftraceCommand = "cat /sys/kernel/tracing/trace 2>/dev/null || cat /sys/kernel/debug/tracing/trace"
+
+// Only the most recent events are kept if the buffer is larger.
+const maxFtraceSize = 8 << 20
+
+var ftraceTimeout = time.Minute
+
+// readFtrace returns the kernel ftrace buffer of the VM, or nil if the VM can't run commands,
+// tracefs is not available or the buffer has no events (tracing is not enabled).
+func (inst *Instance) readFtrace() []byte {
+	stdout, stderr, exitCode, err := inst.Exec(ftraceCommand, ftraceTimeout)
+	if err != nil {
+		log.Logf(1, "vm-%v: crash_ftrace: failed to read ftrace buffer: %v", inst.index, err)
+		return nil
+	}
+	if exitCode != 0 {
+		log.Logf(1, "vm-%v: crash_ftrace: tracefs is not available: %s", inst.index, stderr)
+		return nil
+	}
+	trace := trimFtrace(stdout, maxFtraceSize)
+	if trace == nil {
+		log.Logf(1, "vm-%v: crash_ftrace: ftrace buffer has no events", inst.index)
+	}
+	return trace
+}
+
+// trimFtrace returns nil if the trace has no events, otherwise the trace header (comment lines)
+// and the most recent events that fit into maxSize.
+func trimFtrace(trace []byte, maxSize int) []byte {
+	header := 0
+	for header < len(trace) && trace[header] == '#' {
+		eol := bytes.IndexByte(trace[header:], '\n')
+		if eol == -1 {
+			header = len(trace)
+			break
+		}
+		header += eol + 1
+	}
+	events := trace[header:]
+	if len(bytes.TrimSpace(events)) == 0 {
+		return nil
+	}
+	if len(events) <= maxSize {
+		return trace
+	}
+	// Drop whole lines.
+	drop := len(events) - maxSize
+	if eol := bytes.IndexByte(events[drop-1:], '\n'); eol != -1 {
+		drop += eol
+	}
+	res := append([]byte{}, trace[:header]...)
+	res = append(res, fmt.Sprintf("# syzkaller: %v bytes of older events are dropped\n", drop)...)
+	return append(res, events[drop:]...)
+}
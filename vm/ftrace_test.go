@@ -0,0 +1,90 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/report"
+)
+
+const testFtrace = `# tracer: function
+#
+#           TASK-PID     CPU#   TIMESTAMP  FUNCTION
+#              | |         |       |         |
+    syz-executor-1234  [000]   100.000001: do_sys_open <-do_syscall_64
+    syz-executor-1234  [000]   100.000002: kfree <-do_sys_open
+`
+
+func TestFtrace(t *testing.T) {
+	cfg := &mgrconfig.Config{CrashFtrace: true}
+	pool, reporter := createTestPool(t, cfg)
+	defer os.RemoveAll(cfg.Workdir)
+	run := func(cmd *testCommand, connLost bool) *report.Report {
+		return runTestInstance(t, pool, reporter, false, func(inst *testInstance) {
+			inst.commands = make(map[string]testCommand)
+			if cmd != nil {
+				inst.commands[ftraceCommand] = *cmd
+			}
+			if connLost {
+				inst.errc <- errors.New("lost connection")
+			} else {
+				inst.outc <- []byte("executing program\nBUG: bad\n")
+			}
+		})
+	}
+	rep := run(&testCommand{stdout: testFtrace}, false)
+	if rep == nil || rep.Title != "BUG: bad" {
+		t.Fatalf("got bad report: %+v", rep)
+	}
+	if string(rep.Ftrace) != testFtrace {
+		t.Fatalf("got ftrace buffer:\n%s\nwant:\n%s", rep.Ftrace, testFtrace)
+	}
+	if strings.Contains(string(rep.Report), "do_sys_open") {
+		t.Fatalf("ftrace buffer is mixed into the report")
+	}
+	// tracefs is not mounted.
+	unavailable := &testCommand{stderr: "cat: /sys/kernel/debug/tracing/trace: No such file or directory\n", exitCode: 1}
+	if rep := run(unavailable, false); rep == nil || rep.Ftrace != nil {
+		t.Fatalf("ftrace buffer is attached without tracefs: %+v", rep)
+	}
+	// Tracing is not enabled.
+	if rep := run(&testCommand{stdout: "# tracer: nop\n#\n"}, false); rep == nil || rep.Ftrace != nil {
+		t.Fatalf("empty ftrace buffer is attached: %+v", rep)
+	}
+	// The command can't be run.
+	if rep := run(nil, false); rep == nil || rep.Ftrace != nil {
+		t.Fatalf("ftrace buffer is attached without the command: %+v", rep)
+	}
+	if rep := run(&testCommand{stdout: testFtrace}, true); rep == nil || rep.Title != lostConnectionCrash ||
+		rep.Ftrace != nil {
+		t.Fatalf("ftrace buffer is read after lost connection: %+v", rep)
+	}
+}
+
+func TestTrimFtrace(t *testing.T) {
+	header := "# tracer: function\n#\n"
+	tests := []struct {
+		trace   string
+		maxSize int
+		want    string
+	}{
+		{header, 100, ""},
+		{header + "\n", 100, ""},
+		{"", 100, ""},
+		{header + "a\nb\n", 100, header + "a\nb\n"},
+		{"a\nb\n", 100, "a\nb\n"},
+		{header + "aaaa\nbbbb\ncccc\n", 10, header + "# syzkaller: 5 bytes of older events are dropped\nbbbb\ncccc\n"},
+		{header + "aaaa\nbbbb\ncccc\n", 8, header + "# syzkaller: 10 bytes of older events are dropped\ncccc\n"},
+	}
+	for i, test := range tests {
+		if got := string(trimFtrace([]byte(test.trace), test.maxSize)); got != test.want {
+			t.Errorf("#%v: got:\n%s\nwant:\n%s", i, got, test.want)
+		}
+	}
+}
@@ -2,7 +2,8 @@
 // Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
 
 // Package gce allows to use Google Compute Engine (GCE) virtual machines as VMs.
-// It is assumed that syz-manager also runs on GCE as VMs are created in the current project/zone.
+// It is assumed that syz-manager also runs on GCE as VMs are created in the current project/zone
+// (or in the configured zones of the current region).
 //
 // See https://cloud.google.com/compute/docs for details.
 // In particular, how to build GCE-compatible images:
@@ -40,12 +41,41 @@ type Config struct {
 	MachineType string `json:"machine_type"` // GCE machine type (e.g. "n1-highcpu-2")
 	GCSPath     string `json:"gcs_path"`     // GCS path to upload image
 	GCEImage    string `json:"gce_image"`    // Pre-created GCE image to use
+	// Limit on the rate of GCE API calls done by the pool (calls per second, 1 by default).
+	APIQPS float64 `json:"api_qps"`
+	// How console output is read: "event" reads the interactive serial console over ssh,
+	// "poll" polls the serial port API every serial_poll_interval. By default "poll" is used
+	// if serial_poll_interval is set and "event" otherwise.
+	ConsoleMode string `json:"console_mode"`
+	// If set, console output is obtained by polling the serial port API every that many milliseconds
+	// instead of the interactive serial console over ssh.
+	SerialPollInterval int `json:"serial_poll_interval"`
+	// Max number of instances that poll serial port output concurrently (4 by default).
+	SerialPollBatch int `json:"serial_poll_batch"`
+	// Timeout for a single serial port API call in seconds (30 by default).
+	SerialPollTimeout int `json:"serial_poll_timeout"`
+	// Suspend hang detection while the VM is paused for host maintenance (live migration),
+	// so that it's not reported as "no output from test machine". Requires curl in the image.
+	RespectMaintenanceEvents bool `json:"respect_maintenance_events"`
+	// Scripted login on the interactive serial console for images that gate it behind a getty login
+	// (e.g. {"user": "root", "password": "..."}), not supported with serial_poll_interval.
+	ConsoleLogin vmimpl.ConsoleLogin `json:"console_login"`
+	// Zones to create VMs in (by default VMs are created in the zone of the manager).
+	// The zones must be in the region of the manager network. See placement config.
+	Zones []string `json:"zones"`
+	// Number of pre-created booted instances that are kept ready to be handed out immediately
+	// (in the first zone), this hides the boot latency. Used instances are replaced in background.
+	WarmPoolSize int `json:"warm_pool_size"`
+	// Ready instances that were idle for that many minutes are deleted (30 by default).
+	WarmPoolTTL int `json:"warm_pool_ttl"`
 }
 
 type Pool struct {
-	env *vmimpl.Env
-	cfg *Config
-	GCE *gce.Context
+	env     *vmimpl.Env
+	cfg     *Config
+	GCE     *gce.Context
+	pollSem chan bool        // limits the number of concurrent serial port API calls
+	warm    *vmimpl.WarmPool // nil if warm_pool_size is not set
 }
 
 type instance struct {
@@ -59,7 +89,11 @@ type instance struct {
 	sshKey   string // ssh key
 	sshUser  string
 	closed   chan bool
+	warmDir  string // workdir of an instance created for the warm pool, removed on Close
 	consolew io.WriteCloser
+	pollSem  chan bool
+	// Receives maintenance event state changes (nil if respect_maintenance_events is not set).
+	maintenance chan bool
 }
 
 func ctor(env *vmimpl.Env) (vmimpl.Pool, error) {
@@ -67,7 +101,11 @@ func ctor(env *vmimpl.Env) (vmimpl.Pool, error) {
 		return nil, fmt.Errorf("config param name is empty (required for GCE)")
 	}
 	cfg := &Config{
-		Count: 1,
+		Count:             1,
+		APIQPS:            gce.DefaultAPIQPS,
+		SerialPollBatch:   4,
+		SerialPollTimeout: 30,
+		WarmPoolTTL:       30,
 	}
 	if err := config.LoadData(env.Config, cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse gce vm config: %v", err)
@@ -91,11 +129,31 @@ func ctor(env *vmimpl.Env) (vmimpl.Pool, error) {
 	if cfg.GCEImage != "" && env.Image != "" {
 		return nil, fmt.Errorf("both image and gce_image are specified")
 	}
+	if err := checkConsoleMode(cfg); err != nil {
+		return nil, err
+	}
+	if err := checkSerialPoll(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.ConsoleLogin.Check(); err != nil {
+		return nil, err
+	}
+	if cfg.ConsoleLogin.User != "" && cfg.ConsoleMode == vmimpl.ConsoleModePoll {
+		return nil, fmt.Errorf("console_login can't be used with console polling")
+	}
+	if cfg.WarmPoolSize < 0 || cfg.WarmPoolSize > cfg.Count {
+		return nil, fmt.Errorf("invalid config param warm_pool_size: %v, want [0, %v]",
+			cfg.WarmPoolSize, cfg.Count)
+	}
+	if cfg.WarmPoolSize != 0 && cfg.WarmPoolTTL < 1 {
+		return nil, fmt.Errorf("invalid config param warm_pool_ttl: %v, want >= 1", cfg.WarmPoolTTL)
+	}
 
 	GCE, err := gce.NewContext()
 	if err != nil {
 		return nil, fmt.Errorf("failed to init gce: %v", err)
 	}
+	GCE.SetAPIRate(cfg.APIQPS)
 	log.Logf(0, "GCE initialized: running on %v, internal IP %v, project %v, zone %v, net %v/%v",
 		GCE.Instance, GCE.InternalIP, GCE.ProjectID, GCE.ZoneID, GCE.Network, GCE.Subnetwork)
 
@@ -115,19 +173,124 @@ func ctor(env *vmimpl.Env) (vmimpl.Pool, error) {
 		}
 	}
 	pool := &Pool{
-		cfg: cfg,
-		env: env,
-		GCE: GCE,
+		cfg:     cfg,
+		env:     env,
+		GCE:     GCE,
+		pollSem: make(chan bool, cfg.SerialPollBatch),
+	}
+	if cfg.WarmPoolSize != 0 {
+		loc := pool.Locations()[0]
+		log.Logf(0, "keeping %v warm instances in %v", cfg.WarmPoolSize, loc.Name)
+		pool.warm, err = vmimpl.NewWarmPool(cfg.WarmPoolSize, time.Duration(cfg.WarmPoolTTL)*time.Minute,
+			filepath.Join(env.Workdir, "gce-warm"), func(workdir string, seq int) (vmimpl.Instance, error) {
+				name := fmt.Sprintf("%v-warm-%v", env.Name, seq)
+				inst, err := pool.create(workdir, name, loc)
+				if err != nil {
+					return nil, err
+				}
+				inst.warmDir = workdir
+				return inst, nil
+			})
+		if err != nil {
+			return nil, err
+		}
 	}
 	return pool, nil
 }
 
+// checkConsoleMode checks console_mode against serial_poll_interval and sets the default mode.
+func checkConsoleMode(cfg *Config) error {
+	if err := vmimpl.CheckConsoleMode(cfg.ConsoleMode); err != nil {
+		return err
+	}
+	switch cfg.ConsoleMode {
+	case "":
+		cfg.ConsoleMode = vmimpl.ConsoleModeEvent
+		if cfg.SerialPollInterval != 0 {
+			cfg.ConsoleMode = vmimpl.ConsoleModePoll
+		}
+	case vmimpl.ConsoleModePoll:
+		if cfg.SerialPollInterval == 0 {
+			return fmt.Errorf("console_mode %q requires serial_poll_interval", cfg.ConsoleMode)
+		}
+	case vmimpl.ConsoleModeEvent:
+		if cfg.SerialPollInterval != 0 {
+			return fmt.Errorf("console_mode %q can't be used with serial_poll_interval", cfg.ConsoleMode)
+		}
+	}
+	return nil
+}
+
+func checkSerialPoll(cfg *Config) error {
+	if cfg.APIQPS <= 0 {
+		return fmt.Errorf("invalid config param api_qps: %v, want > 0", cfg.APIQPS)
+	}
+	if cfg.SerialPollInterval == 0 {
+		return nil
+	}
+	if cfg.SerialPollInterval < 0 {
+		return fmt.Errorf("invalid config param serial_poll_interval: %v", cfg.SerialPollInterval)
+	}
+	if cfg.SerialPollBatch < 1 {
+		return fmt.Errorf("invalid config param serial_poll_batch: %v, want >= 1", cfg.SerialPollBatch)
+	}
+	if cfg.SerialPollTimeout < 1 {
+		return fmt.Errorf("invalid config param serial_poll_timeout: %v, want >= 1", cfg.SerialPollTimeout)
+	}
+	// All calls go through the same rate limiter, so polling must leave at least half
+	// of the rate to other calls (instance creation/deletion), otherwise polls of all
+	// instances lag behind and other calls starve.
+	interval := time.Duration(cfg.SerialPollInterval) * time.Millisecond
+	pollQPS := float64(cfg.Count) / interval.Seconds()
+	if pollQPS > cfg.APIQPS/2 {
+		minInterval := time.Duration(float64(cfg.Count) * 2 / cfg.APIQPS * float64(time.Second))
+		return fmt.Errorf("serial_poll_interval %v is too small for %v VMs with api_qps %v: "+
+			"polling would need %.2f QPS, want interval >= %v",
+			interval, cfg.Count, cfg.APIQPS, pollQPS, minInterval)
+	}
+	return nil
+}
+
 func (pool *Pool) Count() int {
 	return pool.cfg.Count
 }
 
+func (pool *Pool) Locations() []vmimpl.Location {
+	zones := pool.cfg.Zones
+	if len(zones) == 0 {
+		zones = []string{pool.GCE.ZoneID}
+	}
+	var locations []vmimpl.Location
+	for _, zone := range zones {
+		locations = append(locations, vmimpl.Location{Name: zone, Zone: zone})
+	}
+	return locations
+}
+
 func (pool *Pool) Create(workdir string, index int) (vmimpl.Instance, error) {
-	name := fmt.Sprintf("%v-%v", pool.env.Name, index)
+	locations := pool.Locations()
+	return pool.CreateAt(workdir, index, locations[index%len(locations)])
+}
+
+func (pool *Pool) CreateAt(workdir string, index int, loc vmimpl.Location) (vmimpl.Instance, error) {
+	if pool.warm != nil && loc.Name == pool.Locations()[0].Name {
+		if inst := pool.warm.Get(); inst != nil {
+			log.Logf(0, "using warm instance %v for VM %v", inst.(*instance).name, index)
+			return inst, nil
+		}
+	}
+	inst, err := pool.create(workdir, fmt.Sprintf("%v-%v", pool.env.Name, index), loc)
+	if err != nil {
+		return nil, err
+	}
+	return inst, nil
+}
+
+func (pool *Pool) create(workdir, name string, loc vmimpl.Location) (*instance, error) {
+	GCE := pool.GCE
+	if loc.Name != GCE.ZoneID {
+		GCE = GCE.WithZone(loc.Name)
+	}
 	// Create SSH key for the instance.
 	gceKey := filepath.Join(workdir, "key")
 	keygen := osutil.Command("ssh-keygen", "-t", "rsa", "-b", "2048", "-N", "", "-C", "syzkaller", "-f", gceKey)
@@ -140,11 +303,11 @@ func (pool *Pool) Create(workdir string, index int) (vmimpl.Instance, error) {
 	}
 
 	log.Logf(0, "deleting instance: %v", name)
-	if err := pool.GCE.DeleteInstance(name, true); err != nil {
+	if err := GCE.DeleteInstance(name, true); err != nil {
 		return nil, err
 	}
 	log.Logf(0, "creating instance: %v", name)
-	ip, err := pool.GCE.CreateInstance(name, pool.cfg.MachineType, pool.cfg.GCEImage, string(gceKeyPub))
+	ip, err := GCE.CreateInstance(name, pool.cfg.MachineType, pool.cfg.GCEImage, string(gceKeyPub))
 	if err != nil {
 		return nil, err
 	}
@@ -152,7 +315,7 @@ func (pool *Pool) Create(workdir string, index int) (vmimpl.Instance, error) {
 	ok := false
 	defer func() {
 		if !ok {
-			pool.GCE.DeleteInstance(name, true)
+			GCE.DeleteInstance(name, true)
 		}
 	}()
 	sshKey := pool.env.SSHKey
@@ -165,7 +328,7 @@ func (pool *Pool) Create(workdir string, index int) (vmimpl.Instance, error) {
 	log.Logf(0, "wait instance to boot: %v (%v)", name, ip)
 	if err := vmimpl.WaitForSSH(pool.env.Debug, 5*time.Minute, ip,
 		sshKey, sshUser, pool.env.OS, 22); err != nil {
-		output, outputErr := pool.getSerialPortOutput(name, gceKey)
+		output, outputErr := pool.getSerialPortOutput(GCE, name, gceKey)
 		if outputErr != nil {
 			output = []byte(fmt.Sprintf("failed to get boot output: %v", outputErr))
 		}
@@ -176,13 +339,17 @@ func (pool *Pool) Create(workdir string, index int) (vmimpl.Instance, error) {
 		env:     pool.env,
 		cfg:     pool.cfg,
 		debug:   pool.env.Debug,
-		GCE:     pool.GCE,
+		GCE:     GCE,
 		name:    name,
 		ip:      ip,
 		gceKey:  gceKey,
 		sshKey:  sshKey,
 		sshUser: sshUser,
 		closed:  make(chan bool),
+		pollSem: pool.pollSem,
+	}
+	if pool.cfg.RespectMaintenanceEvents {
+		inst.maintenance = make(chan bool)
 	}
 	return inst, nil
 }
@@ -193,6 +360,9 @@ func (inst *instance) Close() {
 	if inst.consolew != nil {
 		inst.consolew.Close()
 	}
+	if inst.warmDir != "" {
+		os.RemoveAll(inst.warmDir)
+	}
 }
 
 func (inst *instance) Forward(port int) (string, error) {
@@ -203,63 +373,60 @@ func (inst *instance) Copy(hostSrc string) (string, error) {
 	vmDst := "./" + filepath.Base(hostSrc)
 	args := append(vmimpl.SCPArgs(inst.debug, inst.sshKey, 22), hostSrc, inst.sshUser+"@"+inst.ip+":"+vmDst)
 	if err := runCmd(inst.debug, "scp", args...); err != nil {
-		return "", err
+		return "", vmimpl.ClassifyCopyError(err)
 	}
 	return vmDst, nil
 }
 
 func (inst *instance) Run(timeout time.Duration, stop <-chan bool, command string) (
 	<-chan []byte, <-chan error, error) {
-	conRpipe, conWpipe, err := osutil.LongPipe()
-	if err != nil {
-		return nil, nil, err
-	}
-
-	conAddr := fmt.Sprintf("%v.%v.%v.syzkaller.port=1@ssh-serialport.googleapis.com",
-		inst.GCE.ProjectID, inst.GCE.ZoneID, inst.name)
-	conArgs := append(vmimpl.SSHArgs(inst.debug, inst.gceKey, 9600), conAddr)
-	con := osutil.Command("ssh", conArgs...)
-	con.Env = []string{}
-	con.Stdout = conWpipe
-	con.Stderr = conWpipe
-	conw, err := con.StdinPipe()
-	if err != nil {
-		conRpipe.Close()
-		conWpipe.Close()
-		return nil, nil, err
-	}
-	if inst.consolew != nil {
-		inst.consolew.Close()
-	}
-	inst.consolew = conw
-	if err := con.Start(); err != nil {
-		conRpipe.Close()
-		conWpipe.Close()
-		return nil, nil, fmt.Errorf("failed to connect to console server: %v", err)
-
-	}
-	conWpipe.Close()
-
 	var tee io.Writer
 	if inst.debug {
 		tee = os.Stdout
 	}
 	merger := vmimpl.NewOutputMerger(tee)
-	var decoder func(data []byte) (int, int, []byte)
-	if inst.env.OS == "windows" {
-		decoder = kd.Decode
+	var conRpipe io.ReadCloser
+	var stopConsole func()
+	var err error
+	if inst.cfg.ConsoleMode == vmimpl.ConsoleModePoll {
+		conRpipe, stopConsole, err = inst.pollConsole()
+		if err != nil {
+			return nil, nil, err
+		}
+		merger.Add("console", conRpipe)
+	} else {
+		conRpipe, stopConsole, err = inst.connectConsole()
+		if err != nil {
+			return nil, nil, err
+		}
+		var decoder func(data []byte) (int, int, []byte)
+		if inst.env.OS == "windows" {
+			decoder = kd.Decode
+		}
+		merger.AddDecoder("console", conRpipe, decoder)
+		if err := waitForConsoleConnect(merger); err != nil {
+			stopConsole()
+			merger.Wait()
+			return nil, nil, err
+		}
 	}
-	merger.AddDecoder("console", conRpipe, decoder)
-	if err := waitForConsoleConnect(merger); err != nil {
-		con.Process.Kill()
-		merger.Wait()
-		return nil, nil, err
+	if inst.maintenance != nil {
+		stopMaintenance, err := inst.watchMaintenance()
+		if err != nil {
+			// Not fatal, we only lose protection from false hangs.
+			log.Logf(0, "%v: failed to watch maintenance events: %v", inst.name, err)
+		} else {
+			stop := stopConsole
+			stopConsole = func() {
+				stopMaintenance()
+				stop()
+			}
+		}
 	}
 	sshRpipe, sshWpipe, err := osutil.LongPipe()
 	if err != nil {
-		con.Process.Kill()
+		stopConsole()
 		merger.Wait()
-		sshRpipe.Close()
 		return nil, nil, err
 	}
 	if inst.env.OS == "linux" {
@@ -272,7 +439,7 @@ func (inst *instance) Run(timeout time.Duration, stop <-chan bool, command strin
 	ssh.Stdout = sshWpipe
 	ssh.Stderr = sshWpipe
 	if err := ssh.Start(); err != nil {
-		con.Process.Kill()
+		stopConsole()
 		merger.Wait()
 		sshRpipe.Close()
 		sshWpipe.Close()
@@ -298,10 +465,9 @@ func (inst *instance) Run(timeout time.Duration, stop <-chan bool, command strin
 		case <-inst.closed:
 			signal(fmt.Errorf("instance closed"))
 		case err := <-merger.Err:
-			con.Process.Kill()
+			stopConsole()
 			ssh.Process.Kill()
 			merger.Wait()
-			con.Wait()
 			if cmdErr := ssh.Wait(); cmdErr == nil {
 				// If the command exited successfully, we got EOF error from merger.
 				// But in this case no error has happened and the EOF is expected.
@@ -322,15 +488,71 @@ func (inst *instance) Run(timeout time.Duration, stop <-chan bool, command strin
 			signal(err)
 			return
 		}
-		con.Process.Kill()
+		stopConsole()
 		ssh.Process.Kill()
 		merger.Wait()
-		con.Wait()
 		ssh.Wait()
 	}()
 	return merger.Output, errc, nil
 }
 
+// connectConsole connects to the interactive serial console over ssh.
+func (inst *instance) connectConsole() (io.ReadCloser, func(), error) {
+	conRpipe, conWpipe, err := osutil.LongPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	conAddr := fmt.Sprintf("%v.%v.%v.syzkaller.port=1@ssh-serialport.googleapis.com",
+		inst.GCE.ProjectID, inst.GCE.ZoneID, inst.name)
+	conArgs := append(vmimpl.SSHArgs(inst.debug, inst.gceKey, 9600), conAddr)
+	con := osutil.Command("ssh", conArgs...)
+	con.Env = []string{}
+	con.Stdout = conWpipe
+	con.Stderr = conWpipe
+	conw, err := con.StdinPipe()
+	if err != nil {
+		conRpipe.Close()
+		conWpipe.Close()
+		return nil, nil, err
+	}
+	if inst.consolew != nil {
+		inst.consolew.Close()
+	}
+	inst.consolew = conw
+	if err := con.Start(); err != nil {
+		conRpipe.Close()
+		conWpipe.Close()
+		return nil, nil, fmt.Errorf("failed to connect to console server: %v", err)
+	}
+	conWpipe.Close()
+	stop := func() {
+		con.Process.Kill()
+		con.Wait()
+	}
+	rc, err := vmimpl.LoginConsole(conRpipe, conw, &inst.cfg.ConsoleLogin)
+	if err != nil {
+		stop()
+		conRpipe.Close()
+		return nil, nil, fmt.Errorf("failed to login on console: %v", err)
+	}
+	return rc, stop, nil
+}
+
+// pollConsole starts polling console output with the serial port API.
+func (inst *instance) pollConsole() (io.ReadCloser, func(), error) {
+	poller := &serialPoller{
+		api:     inst.GCE,
+		name:    inst.name,
+		timeout: time.Duration(inst.cfg.SerialPollTimeout) * time.Second,
+		sem:     inst.pollSem,
+	}
+	if err := poller.skip(); err != nil {
+		return nil, nil, fmt.Errorf("broken console: %v", err)
+	}
+	interval := time.Duration(inst.cfg.SerialPollInterval) * time.Millisecond
+	return vmimpl.PollConsole(inst.name, poller, interval, maxPollErrors)
+}
+
 func waitForConsoleConnect(merger *vmimpl.OutputMerger) error {
 	// We've started the console reading ssh command, but it has not necessary connected yet.
 	// If we proceed to running the target command right away, we can miss part
@@ -366,14 +588,35 @@ func waitForConsoleConnect(merger *vmimpl.OutputMerger) error {
 	}
 }
 
+func (inst *instance) Maintenance() <-chan bool {
+	return inst.maintenance
+}
+
+func (inst *instance) ReadFile(file string) ([]byte, error) {
+	command := "cat " + file
+	if inst.env.OS == "linux" && inst.sshUser != "root" {
+		command = "sudo " + command
+	}
+	args := append(vmimpl.SSHArgs(inst.debug, inst.sshKey, 22), inst.sshUser+"@"+inst.ip, command)
+	return osutil.RunCmd(time.Minute, "", "ssh", args...)
+}
+
+func (inst *instance) Exec(command string, timeout time.Duration) ([]byte, []byte, int, error) {
+	return vmimpl.SSHExec(inst.debug, timeout, inst.ip, inst.sshKey, inst.sshUser, 22, command)
+}
+
+func (inst *instance) Probe() bool {
+	return inst.env.OS == "linux" && vmimpl.ProbeSysrq(inst)
+}
+
 func (inst *instance) Diagnose() bool {
-	if inst.env.OS == "openbsd" {
+	if inst.env.OS == "openbsd" && inst.consolew != nil {
 		return vmimpl.DiagnoseOpenBSD(inst.consolew)
 	}
 	return false
 }
 
-func (pool *Pool) getSerialPortOutput(name, gceKey string) ([]byte, error) {
+func (pool *Pool) getSerialPortOutput(GCE *gce.Context, name, gceKey string) ([]byte, error) {
 	conRpipe, conWpipe, err := osutil.LongPipe()
 	if err != nil {
 		return nil, err
@@ -381,7 +624,7 @@ func (pool *Pool) getSerialPortOutput(name, gceKey string) ([]byte, error) {
 	defer conRpipe.Close()
 	defer conWpipe.Close()
 	conAddr := fmt.Sprintf("%v.%v.%v.syzkaller.port=1.replay-lines=10000@ssh-serialport.googleapis.com",
-		pool.GCE.ProjectID, pool.GCE.ZoneID, name)
+		GCE.ProjectID, GCE.ZoneID, name)
 	conArgs := append(vmimpl.SSHArgs(pool.env.Debug, gceKey, 9600), conAddr)
 	con := osutil.Command("ssh", conArgs...)
 	con.Env = []string{}
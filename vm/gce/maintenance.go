@@ -0,0 +1,84 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package gce
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+// GCE announces host maintenance (live migration) via the maintenance-event metadata value
+// that is accessible only from within the VM, so the watcher runs inside of the VM (requires curl).
+// See https://cloud.google.com/compute/docs/storing-retrieving-metadata#maintenanceevents
+
+// maintenanceCommand prints the current maintenance-event value and then every new value.
+const maintenanceCommand = `url=http://metadata.google.internal/computeMetadata/v1/instance/maintenance-event; ` +
+	`curl -sf -H Metadata-Flavor:Google $url && echo; ` +
+	`while curl -sf -H Metadata-Flavor:Google "$url?wait_for_change=true"; do echo; done`
+
+// parseMaintenanceEvent parses a maintenance-event value.
+// Returns whether a maintenance event is in progress and whether the value is recognized.
+func parseMaintenanceEvent(value string) (bool, bool) {
+	switch strings.TrimSpace(value) {
+	case "NONE":
+		return false, true
+	case "MIGRATE_ON_HOST_MAINTENANCE", "TERMINATE_ON_HOST_MAINTENANCE":
+		return true, true
+	}
+	return false, false
+}
+
+// watchMaintenance starts watching maintenance events in the VM, events are sent to inst.maintenance.
+// Returns the function that stops watching.
+func (inst *instance) watchMaintenance() (func(), error) {
+	args := append(vmimpl.SSHArgs(inst.debug, inst.sshKey, 22), inst.sshUser+"@"+inst.ip, maintenanceCommand)
+	r, err := vmimpl.OpenRemoteCommand("ssh", args...)
+	if err != nil {
+		return nil, err
+	}
+	stop := make(chan bool)
+	go func() {
+		forwardMaintenance(r, inst.maintenance, stop)
+		log.Logf(1, "%v: stopped watching maintenance events", inst.name)
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			r.Close()
+		})
+	}, nil
+}
+
+// forwardMaintenance reads maintenance-event values from r and sends state changes to maint.
+func forwardMaintenance(r io.Reader, maint chan<- bool, stop <-chan bool) {
+	active := false
+	send := func(v bool) bool {
+		select {
+		case maint <- v:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+	for s := bufio.NewScanner(r); s.Scan(); {
+		v, ok := parseMaintenanceEvent(s.Text())
+		if !ok || v == active {
+			continue
+		}
+		active = v
+		if !send(active) {
+			return
+		}
+	}
+	if active {
+		// Don't leave hang detection suspended if the watcher dies in the middle of maintenance.
+		send(false)
+	}
+}
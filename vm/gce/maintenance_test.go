@@ -0,0 +1,65 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package gce
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestForwardMaintenance(t *testing.T) {
+	tests := []struct {
+		output string
+		events []bool
+	}{
+		{
+			output: "NONE\nMIGRATE_ON_HOST_MAINTENANCE\nNONE\n",
+			events: []bool{true, false},
+		},
+		{
+			// Repeated values and garbage (e.g. ssh warnings) are ignored.
+			output: "Warning: Permanently added 'x' to the list of known hosts.\n" +
+				"NONE\nMIGRATE_ON_HOST_MAINTENANCE\nMIGRATE_ON_HOST_MAINTENANCE\nfoo\nNONE\nNONE\n" +
+				"TERMINATE_ON_HOST_MAINTENANCE\n NONE \n",
+			events: []bool{true, false, true, false},
+		},
+		{
+			// The watcher dies in the middle of maintenance.
+			output: "MIGRATE_ON_HOST_MAINTENANCE\n",
+			events: []bool{true, false},
+		},
+		{
+			output: "",
+		},
+	}
+	for i, test := range tests {
+		maint := make(chan bool)
+		done := make(chan bool)
+		go func() {
+			forwardMaintenance(strings.NewReader(test.output), maint, make(chan bool))
+			close(done)
+		}()
+		var events []bool
+	loop:
+		for {
+			select {
+			case v := <-maint:
+				events = append(events, v)
+			case <-done:
+				break loop
+			}
+		}
+		if !reflect.DeepEqual(events, test.events) {
+			t.Errorf("#%v: got events %v, want %v", i, events, test.events)
+		}
+	}
+}
+
+func TestForwardMaintenanceStop(t *testing.T) {
+	stop := make(chan bool)
+	close(stop)
+	// Nobody reads maint, forwardMaintenance must not block.
+	forwardMaintenance(strings.NewReader("MIGRATE_ON_HOST_MAINTENANCE\n"), make(chan bool), stop)
+}
@@ -0,0 +1,67 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package gce
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// serialAPI is the part of gce.Context used to poll serial port output.
+type serialAPI interface {
+	SerialPortOutput(name string, start int64, timeout time.Duration) (string, int64, int64, error)
+}
+
+// serialPoller polls serial port output of an instance with the serial port API
+// (as opposed to the interactive serial console over ssh).
+// The API keeps a limited buffer of output per instance and returns offsets into the whole output,
+// so we continue each call from where the previous one ended. If the output between polls
+// was larger than the buffer, the returned start offset is past what we asked for,
+// and we mark the gap in the output instead of silently losing it.
+type serialPoller struct {
+	api     serialAPI
+	name    string
+	timeout time.Duration
+	sem     chan bool // limits the number of concurrent API calls in the pool
+	next    int64     // offset of the output to request next
+}
+
+// maxPollErrors is the number of consecutive failed polls after which we consider the console lost.
+const maxPollErrors = 10
+
+// skip sets the poller to start from the current end of the output,
+// so that we don't replay what was printed before the command started.
+func (p *serialPoller) skip() error {
+	_, _, next, err := p.call(0)
+	if err != nil {
+		return err
+	}
+	p.next = next
+	return nil
+}
+
+// Poll fetches new output and writes it to w (implements vmimpl.ConsoleSource).
+func (p *serialPoller) Poll(w io.Writer) error {
+	contents, start, next, err := p.call(p.next)
+	if err != nil {
+		return err
+	}
+	if start > p.next {
+		fmt.Fprintf(w, "\nsyzkaller: %v bytes of console output were lost\n", start-p.next)
+	}
+	if _, err := io.WriteString(w, contents); err != nil {
+		return err
+	}
+	if next > p.next {
+		p.next = next
+	}
+	return nil
+}
+
+func (p *serialPoller) call(start int64) (string, int64, int64, error) {
+	p.sem <- true
+	defer func() { <-p.sem }()
+	return p.api.SerialPortOutput(p.name, start, p.timeout)
+}
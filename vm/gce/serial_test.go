@@ -0,0 +1,168 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package gce
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testSerialAPI mimics the serial port API: it keeps only the last bufSize bytes
+// of the output and returns at most maxChunk bytes per call.
+type testSerialAPI struct {
+	output   []byte
+	bufSize  int
+	maxChunk int
+	starts   []int64 // requested start offsets
+}
+
+func (api *testSerialAPI) SerialPortOutput(name string, start int64, timeout time.Duration) (
+	string, int64, int64, error) {
+	api.starts = append(api.starts, start)
+	if min := int64(len(api.output) - api.bufSize); start < min {
+		start = min
+	}
+	if start > int64(len(api.output)) {
+		start = int64(len(api.output))
+	}
+	end := start + int64(api.maxChunk)
+	if end > int64(len(api.output)) {
+		end = int64(len(api.output))
+	}
+	return string(api.output[start:end]), start, end, nil
+}
+
+func (api *testSerialAPI) print(lines ...string) {
+	for _, line := range lines {
+		api.output = append(api.output, line...)
+	}
+}
+
+func newTestPoller(api *testSerialAPI) *serialPoller {
+	return &serialPoller{
+		api:  api,
+		name: "test",
+		sem:  make(chan bool, 1),
+	}
+}
+
+func TestSerialPollerContinuation(t *testing.T) {
+	api := &testSerialAPI{bufSize: 1 << 20, maxChunk: 100}
+	api.print("boot output that must be skipped\n")
+	p := newTestPoller(api)
+	if err := p.skip(); err != nil {
+		t.Fatal(err)
+	}
+	skipped := len(api.output)
+	output := new(bytes.Buffer)
+	for i := 0; i < 50; i++ {
+		// Sometimes print more than maxChunk, and sometimes nothing, between polls.
+		for j := 0; j < i%4; j++ {
+			api.print(fmt.Sprintf("line %v-%v: %v\n", i, j, "some console output that is long enough"))
+		}
+		prev := p.next
+		if err := p.Poll(output); err != nil {
+			t.Fatal(err)
+		}
+		if start := api.starts[len(api.starts)-1]; start != prev {
+			t.Fatalf("poll #%v requested offset %v, want %v", i, start, prev)
+		}
+	}
+	for p.next != int64(len(api.output)) {
+		if err := p.Poll(output); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := api.output[skipped:]; !bytes.Equal(output.Bytes(), want) {
+		t.Fatalf("got output:\n%s\nwant:\n%s", output.Bytes(), want)
+	}
+}
+
+func TestSerialPollerLostOutput(t *testing.T) {
+	api := &testSerialAPI{bufSize: 20, maxChunk: 100}
+	p := newTestPoller(api)
+	output := new(bytes.Buffer)
+	api.print("0123456789\n")
+	if err := p.Poll(output); err != nil {
+		t.Fatal(err)
+	}
+	// 30 bytes are printed, but the buffer keeps only the last 20.
+	api.print("aaaaaaaaa\n", "bbbbbbbbb\n", "ccccccccc\n")
+	if err := p.Poll(output); err != nil {
+		t.Fatal(err)
+	}
+	api.print("ddddddddd\n")
+	if err := p.Poll(output); err != nil {
+		t.Fatal(err)
+	}
+	want := "0123456789\n" +
+		"\nsyzkaller: 10 bytes of console output were lost\n" +
+		"bbbbbbbbb\nccccccccc\nddddddddd\n"
+	if got := output.String(); got != want {
+		t.Fatalf("got output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCheckSerialPoll(t *testing.T) {
+	tests := []struct {
+		count    int
+		interval int
+		qps      float64
+		ok       bool
+	}{
+		{10, 0, 1, true},
+		{10, 20000, 1, true},
+		{10, 10000, 1, false},
+		{10, 1000, 20, true},
+		{10, 1000, 10, false},
+		{1, 1000, 0, false},
+	}
+	for i, test := range tests {
+		cfg := &Config{
+			Count:              test.count,
+			APIQPS:             test.qps,
+			SerialPollInterval: test.interval,
+			SerialPollBatch:    4,
+			SerialPollTimeout:  30,
+		}
+		err := checkSerialPoll(cfg)
+		if test.ok != (err == nil) {
+			t.Errorf("test #%v: got error %v, want ok %v", i, err, test.ok)
+		}
+	}
+}
+
+func TestCheckConsoleMode(t *testing.T) {
+	tests := []struct {
+		mode     string
+		interval int
+		result   string
+	}{
+		{"", 0, "event"},
+		{"", 20000, "poll"},
+		{"event", 0, "event"},
+		{"poll", 20000, "poll"},
+		{"poll", 0, ""},
+		{"event", 20000, ""},
+		{"stream", 0, ""},
+	}
+	for i, test := range tests {
+		cfg := &Config{
+			ConsoleMode:        test.mode,
+			SerialPollInterval: test.interval,
+		}
+		err := checkConsoleMode(cfg)
+		if test.result == "" {
+			if err == nil {
+				t.Errorf("test #%v: no error", i)
+			}
+			continue
+		}
+		if err != nil || cfg.ConsoleMode != test.result {
+			t.Errorf("test #%v: got mode %q, error %v, want %q", i, cfg.ConsoleMode, err, test.result)
+		}
+	}
+}
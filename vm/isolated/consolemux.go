@@ -0,0 +1,232 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package isolated
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/osutil"
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+// The console multiplexer (console_mux config) allows to share lab machines between syzkaller and humans.
+// The backend owns the console and mirrors it to read-only observers connected to a per-target unix socket,
+// while the monitor keeps receiving the full stream. With takeover enabled, a human connected
+// to the takeover socket gets an interactive shell on the machine and fuzzing on it is paused
+// until the human disconnects.
+type ConsoleMux struct {
+	// Directory for the sockets: <target>.console for observers and <target>.takeover for takeover.
+	Dir string `json:"dir"`
+	// Permissions of the sockets in octal ("0660" by default), they control who can attach.
+	Perm string `json:"perm"`
+	// Allow interactive takeover of machines.
+	Takeover bool `json:"takeover"`
+}
+
+// Console output chunks that are buffered for an observer, output is dropped for observers that don't keep up.
+const observerBuffer = 256
+
+type consoleMux struct {
+	target  string
+	session func(conn net.Conn) // runs interactive session of the human on the machine
+
+	mu        sync.Mutex
+	observers map[net.Conn]chan []byte
+	human     net.Conn      // the human that took over the machine (nil if none)
+	takenOver chan struct{} // closed when a human takes over the machine
+	released  chan struct{} // closed when the human disconnects
+}
+
+func checkConsoleMux(cfg *ConsoleMux) error {
+	if cfg.Dir == "" {
+		return fmt.Errorf("console_mux: dir is empty")
+	}
+	if _, err := socketPerm(cfg.Perm); err != nil {
+		return fmt.Errorf("console_mux: bad perm %q: %v", cfg.Perm, err)
+	}
+	return osutil.MkdirAll(cfg.Dir)
+}
+
+func socketPerm(perm string) (os.FileMode, error) {
+	if perm == "" {
+		return 0660, nil
+	}
+	val, err := strconv.ParseUint(perm, 8, 32)
+	if err != nil || val&^0777 != 0 {
+		return 0, fmt.Errorf("want octal permissions")
+	}
+	return os.FileMode(val), nil
+}
+
+func newConsoleMux(cfg *ConsoleMux, target string, session func(conn net.Conn)) (*consoleMux, error) {
+	released := make(chan struct{})
+	close(released)
+	mux := &consoleMux{
+		target:    target,
+		session:   session,
+		observers: make(map[net.Conn]chan []byte),
+		takenOver: make(chan struct{}),
+		released:  released,
+	}
+	perm, err := socketPerm(cfg.Perm)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := listenUnix(filepath.Join(cfg.Dir, target+".console"), perm)
+	if err != nil {
+		return nil, err
+	}
+	go mux.serve(ln, mux.observe)
+	if cfg.Takeover {
+		ln, err := listenUnix(filepath.Join(cfg.Dir, target+".takeover"), perm)
+		if err != nil {
+			return nil, err
+		}
+		go mux.serve(ln, mux.takeover)
+	}
+	return mux, nil
+}
+
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	// The socket may be left from a previous run.
+	os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("console_mux: failed to listen: %v", err)
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("console_mux: failed to chmod socket: %v", err)
+	}
+	return ln, nil
+}
+
+func (mux *consoleMux) serve(ln net.Listener, handle func(conn net.Conn)) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Logf(0, "isolated: console_mux for %v: accept failed: %v", mux.target, err)
+			return
+		}
+		go handle(conn)
+	}
+}
+
+func (mux *consoleMux) observe(conn net.Conn) {
+	output := make(chan []byte, observerBuffer)
+	mux.mu.Lock()
+	mux.observers[conn] = output
+	mux.mu.Unlock()
+	go func() {
+		// Observers are read-only, so their input is discarded, EOF means that the observer is gone.
+		io.Copy(ioutil.Discard, conn)
+		mux.mu.Lock()
+		delete(mux.observers, conn)
+		close(output)
+		mux.mu.Unlock()
+	}()
+	for data := range output {
+		if _, err := conn.Write(data); err != nil {
+			conn.Close()
+		}
+	}
+	conn.Close()
+}
+
+func (mux *consoleMux) takeover(conn net.Conn) {
+	defer conn.Close()
+	mux.mu.Lock()
+	if mux.human != nil {
+		mux.mu.Unlock()
+		fmt.Fprintf(conn, "syzkaller: %v is already taken over\n", mux.target)
+		return
+	}
+	mux.human = conn
+	close(mux.takenOver)
+	released := make(chan struct{})
+	mux.released = released
+	mux.mu.Unlock()
+	log.Logf(0, "isolated: %v is taken over, fuzzing is paused", mux.target)
+	fmt.Fprintf(conn, "syzkaller: fuzzing on %v is paused until you disconnect\n", mux.target)
+	mux.session(conn)
+	mux.mu.Lock()
+	mux.human = nil
+	mux.takenOver = make(chan struct{})
+	close(released)
+	mux.mu.Unlock()
+	log.Logf(0, "isolated: %v is released, fuzzing is resumed", mux.target)
+}
+
+// Write mirrors console output to all observers.
+func (mux *consoleMux) Write(data []byte) (int, error) {
+	data = append([]byte{}, data...)
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	for _, output := range mux.observers {
+		select {
+		case output <- data:
+		default:
+		}
+	}
+	return len(data), nil
+}
+
+func (mux *consoleMux) takenOverByHuman() bool {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	return mux.human != nil
+}
+
+// waitReleased waits until the machine is not taken over, returns false if shutdown is in progress.
+func (mux *consoleMux) waitReleased() bool {
+	mux.mu.Lock()
+	released := mux.released
+	mux.mu.Unlock()
+	select {
+	case <-released:
+		return true
+	case <-vmimpl.Shutdown:
+		return false
+	}
+}
+
+// stopOnTakeover returns stop channel for Run that also fires when a human takes over the machine.
+func (mux *consoleMux) stopOnTakeover(stop <-chan bool, closed <-chan bool) <-chan bool {
+	mux.mu.Lock()
+	takenOver := mux.takenOver
+	mux.mu.Unlock()
+	res := make(chan bool)
+	go func() {
+		select {
+		case <-stop:
+		case <-takenOver:
+		case <-closed:
+			return
+		}
+		close(res)
+	}()
+	return res
+}
+
+// muxConsole mirrors the console it reads to the multiplexer.
+type muxConsole struct {
+	io.ReadCloser
+	mux *consoleMux
+}
+
+func (con *muxConsole) Read(buf []byte) (int, error) {
+	n, err := con.ReadCloser.Read(buf)
+	if n > 0 {
+		con.mux.Write(buf[:n])
+	}
+	return n, err
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package isolated
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConsoleMux(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-isolated-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &ConsoleMux{Dir: dir, Perm: "0600", Takeover: true}
+	if err := checkConsoleMux(cfg); err != nil {
+		t.Fatal(err)
+	}
+	sessions := make(chan net.Conn)
+	mux, err := newConsoleMux(cfg, "host:22", func(conn net.Conn) {
+		sessions <- conn
+		io.Copy(ioutil.Discard, conn)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consoleSocket := filepath.Join(dir, "host:22.console")
+	if st, err := os.Stat(consoleSocket); err != nil || st.Mode().Perm() != 0600 {
+		t.Fatalf("bad console socket: %v %v", st, err)
+	}
+
+	// Observers get the console output that is read by the monitor.
+	observer, err := net.Dial("unix", consoleSocket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer observer.Close()
+	for !hasObservers(mux) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	console := &muxConsole{ioutil.NopCloser(strings.NewReader("BUG: bad\n")), mux}
+	data, err := ioutil.ReadAll(console)
+	if err != nil || string(data) != "BUG: bad\n" {
+		t.Fatalf("monitor got %q, %v", data, err)
+	}
+	if line, err := bufio.NewReader(observer).ReadString('\n'); err != nil || line != "BUG: bad\n" {
+		t.Fatalf("observer got %q, %v", line, err)
+	}
+
+	// Takeover stops fuzzing until the human disconnects.
+	closed := make(chan bool)
+	defer close(closed)
+	stop := mux.stopOnTakeover(nil, closed)
+	human, err := net.Dial("unix", filepath.Join(dir, "host:22.takeover"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-sessions
+	select {
+	case <-stop:
+	case <-time.After(time.Minute):
+		t.Fatal("fuzzing is not stopped on takeover")
+	}
+	if !mux.takenOverByHuman() {
+		t.Fatal("the machine is not taken over")
+	}
+	second, err := net.Dial("unix", filepath.Join(dir, "host:22.takeover"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply, _ := ioutil.ReadAll(second); !strings.Contains(string(reply), "already taken over") {
+		t.Fatalf("second human got %q", reply)
+	}
+	human.Close()
+	if !mux.waitReleased() || mux.takenOverByHuman() {
+		t.Fatal("the machine is not released")
+	}
+	select {
+	case <-mux.stopOnTakeover(nil, closed):
+		t.Fatal("fuzzing is stopped after release")
+	default:
+	}
+}
+
+func hasObservers(mux *consoleMux) bool {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	return len(mux.observers) != 0
+}
+
+func TestCheckConsoleMux(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-isolated-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		cfg *ConsoleMux
+		ok  bool
+	}{
+		{&ConsoleMux{Dir: dir}, true},
+		{&ConsoleMux{Dir: dir, Perm: "0666"}, true},
+		{&ConsoleMux{}, false},
+		{&ConsoleMux{Dir: dir, Perm: "rw"}, false},
+		{&ConsoleMux{Dir: dir, Perm: "4777"}, false},
+	}
+	for i, test := range tests {
+		err := checkConsoleMux(test.cfg)
+		if test.ok != (err == nil) {
+			t.Errorf("#%v: got error %v, want ok %v", i, err, test.ok)
+		}
+	}
+}
@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/syzkaller/pkg/config"
@@ -27,11 +29,25 @@ type Config struct {
 	Targets      []string `json:"targets"`       // target machines: (hostname|ip)(:port)?
 	TargetDir    string   `json:"target_dir"`    // directory to copy/run on target
 	TargetReboot bool     `json:"target_reboot"` // reboot target on repair
+	// Source of kernel log: "dmesg" (default, "dmesg -w" over ssh) or "kmsg" (/dev/kmsg over ssh,
+	// survives reboots and does not repeat records after reconnection).
+	Console string `json:"console"`
+	// Zones of the targets (target -> zone, e.g. rack or lab), used by the zone-balanced placement.
+	Zones map[string]string `json:"zones"`
+	// Share consoles of the targets with humans (see ConsoleMux).
+	ConsoleMux *ConsoleMux `json:"console_mux"`
+	// Mirror the kernel log of each instance to a host pty (linux only), so that external tools
+	// (e.g. screen or minicom) can attach to it. The pty path is printed in the VM info.
+	ConsolePty bool `json:"console_pty"`
 }
 
 type Pool struct {
-	env *vmimpl.Env
-	cfg *Config
+	env   *vmimpl.Env
+	cfg   *Config
+	muxes map[string]*consoleMux // target -> console multiplexer (if console_mux is configured)
+
+	mu      sync.Mutex
+	targets map[int]string // index -> target of the last instance
 }
 
 type instance struct {
@@ -44,6 +60,8 @@ type instance struct {
 	sshUser     string
 	sshKey      string
 	forwardPort int
+	mux         *consoleMux        // nil if console_mux is not configured
+	consolePty  *vmimpl.ConsolePty // nil if console_pty is not enabled
 }
 
 func ctor(env *vmimpl.Env) (vmimpl.Pool, error) {
@@ -57,18 +75,46 @@ func ctor(env *vmimpl.Env) (vmimpl.Pool, error) {
 	if cfg.TargetDir == "" {
 		return nil, fmt.Errorf("config param target_dir is empty")
 	}
+	switch cfg.Console {
+	case "", "dmesg", "kmsg":
+	default:
+		return nil, fmt.Errorf("unknown console %q, want dmesg or kmsg", cfg.Console)
+	}
 	for _, target := range cfg.Targets {
 		if _, _, err := splitTargetPort(target); err != nil {
 			return nil, fmt.Errorf("bad target %q: %v", target, err)
 		}
 	}
+	for target := range cfg.Zones {
+		found := false
+		for _, t := range cfg.Targets {
+			found = found || t == target
+		}
+		if !found {
+			return nil, fmt.Errorf("zones: unknown target %q", target)
+		}
+	}
 	if env.Debug && len(cfg.Targets) > 1 {
 		log.Logf(0, "limiting number of targets from %v to 1 in debug mode", len(cfg.Targets))
 		cfg.Targets = cfg.Targets[:1]
 	}
 	pool := &Pool{
-		cfg: cfg,
-		env: env,
+		cfg:     cfg,
+		env:     env,
+		targets: make(map[int]string),
+	}
+	if cfg.ConsoleMux != nil {
+		if err := checkConsoleMux(cfg.ConsoleMux); err != nil {
+			return nil, err
+		}
+		pool.muxes = make(map[string]*consoleMux)
+		for _, target := range cfg.Targets {
+			mux, err := newConsoleMux(cfg.ConsoleMux, target, pool.session(target))
+			if err != nil {
+				return nil, err
+			}
+			pool.muxes[target] = mux
+		}
 	}
 	return pool, nil
 }
@@ -77,8 +123,60 @@ func (pool *Pool) Count() int {
 	return len(pool.cfg.Targets)
 }
 
+func (pool *Pool) Locations() []vmimpl.Location {
+	var locations []vmimpl.Location
+	for _, target := range pool.cfg.Targets {
+		locations = append(locations, vmimpl.Location{Name: target, Zone: pool.cfg.Zones[target]})
+	}
+	return locations
+}
+
 func (pool *Pool) Create(workdir string, index int) (vmimpl.Instance, error) {
-	targetAddr, targetPort, _ := splitTargetPort(pool.cfg.Targets[index])
+	return pool.CreateAt(workdir, index, pool.Locations()[index])
+}
+
+// OutputFilter drops bootloader output: targets are often boards that are rebooted on repair,
+// and U-Boot chatter must not be taken for a kernel crash.
+func (pool *Pool) OutputFilter() func(line []byte) []byte {
+	return vmimpl.StripBootloader
+}
+
+// TakenOver returns true if the target of instance index is taken over by a human (see ConsoleMux).
+func (pool *Pool) TakenOver(index int) bool {
+	pool.mu.Lock()
+	mux := pool.muxes[pool.targets[index]]
+	pool.mu.Unlock()
+	return mux != nil && mux.takenOverByHuman()
+}
+
+// session runs an interactive ssh session on target for a human that took it over.
+func (pool *Pool) session(target string) func(conn net.Conn) {
+	targetAddr, targetPort, _ := splitTargetPort(target)
+	return func(conn net.Conn) {
+		args := append(vmimpl.SSHArgs(pool.env.Debug, pool.env.SSHKey, targetPort),
+			"-tt", pool.env.SSHUser+"@"+targetAddr)
+		cmd := osutil.Command("ssh", args...)
+		cmd.Stdin = conn
+		cmd.Stdout = conn
+		cmd.Stderr = conn
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(conn, "syzkaller: ssh failed: %v\n", err)
+		}
+	}
+}
+
+func (pool *Pool) CreateAt(workdir string, index int, loc vmimpl.Location) (vmimpl.Instance, error) {
+	pool.mu.Lock()
+	pool.targets[index] = loc.Name
+	pool.mu.Unlock()
+	mux := pool.muxes[loc.Name]
+	if mux != nil && mux.takenOverByHuman() {
+		log.Logf(0, "isolated: %v is taken over, waiting for the human to disconnect", loc.Name)
+		if !mux.waitReleased() {
+			return nil, fmt.Errorf("shutdown in progress")
+		}
+	}
+	targetAddr, targetPort, _ := splitTargetPort(loc.Name)
 	inst := &instance{
 		cfg:        pool.cfg,
 		os:         pool.env.OS,
@@ -88,6 +186,7 @@ func (pool *Pool) Create(workdir string, index int) (vmimpl.Instance, error) {
 		debug:      pool.env.Debug,
 		sshUser:    pool.env.SSHUser,
 		sshKey:     pool.env.SSHKey,
+		mux:        mux,
 	}
 	closeInst := inst
 	defer func() {
@@ -95,6 +194,12 @@ func (pool *Pool) Create(workdir string, index int) (vmimpl.Instance, error) {
 			closeInst.Close()
 		}
 	}()
+	if pool.cfg.ConsolePty {
+		var err error
+		if inst.consolePty, err = vmimpl.OpenConsolePty(); err != nil {
+			return nil, err
+		}
+	}
 	if err := inst.repair(); err != nil {
 		return nil, err
 	}
@@ -105,6 +210,11 @@ func (pool *Pool) Create(workdir string, index int) (vmimpl.Instance, error) {
 	// Remove temp files from previous runs.
 	inst.ssh("rm -rf '" + filepath.Join(inst.cfg.TargetDir, "*") + "'")
 
+	if pool.env.ReadPstore {
+		// The machine persists across instances, so records of previous crashes are still there.
+		inst.ssh(vmimpl.PstoreClearCommand)
+	}
+
 	closeInst = nil
 	return inst, nil
 }
@@ -171,6 +281,22 @@ func (inst *instance) ssh(command string) error {
 	return nil
 }
 
+// sshOutput runs a short auxiliary command on the target and returns its output.
+func (inst *instance) sshOutput(command string) ([]byte, error) {
+	args := append(vmimpl.SSHArgs(inst.debug, inst.sshKey, inst.targetPort),
+		inst.sshUser+"@"+inst.targetAddr, command)
+	return osutil.RunCmd(time.Minute, "", "ssh", args...)
+}
+
+func (inst *instance) Exec(command string, timeout time.Duration) ([]byte, []byte, int, error) {
+	return vmimpl.SSHExec(inst.debug, timeout, inst.targetAddr, inst.sshKey, inst.sshUser,
+		inst.targetPort, command)
+}
+
+func (inst *instance) Probe() bool {
+	return inst.os == "linux" && vmimpl.ProbeSysrq(inst)
+}
+
 func (inst *instance) repair() error {
 	log.Logf(2, "isolated: trying to ssh")
 	if err := inst.waitForSSH(30 * time.Minute); err == nil {
@@ -223,6 +349,17 @@ func (inst *instance) waitForReboot(timeout int) error {
 
 func (inst *instance) Close() {
 	close(inst.closed)
+	if inst.consolePty != nil {
+		inst.consolePty.Close()
+	}
+}
+
+// Info implements vmimpl.Infoer.
+func (inst *instance) Info() ([]byte, error) {
+	if inst.consolePty == nil {
+		return nil, nil
+	}
+	return []byte(fmt.Sprintf("console pty: %v\n", inst.consolePty.Path)), nil
 }
 
 func (inst *instance) Copy(hostSrc string) (string, error) {
@@ -237,32 +374,25 @@ func (inst *instance) Copy(hostSrc string) (string, error) {
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stdout
 	}
-	if err := cmd.Start(); err != nil {
-		return "", err
-	}
-	done := make(chan bool)
-	go func() {
-		select {
-		case <-time.After(3 * time.Minute):
-			cmd.Process.Kill()
-		case <-done:
-		}
-	}()
-	err := cmd.Wait()
-	close(done)
-	if err != nil {
-		return "", err
+	if _, err := osutil.Run(3*time.Minute, cmd); err != nil {
+		return "", vmimpl.ClassifyCopyError(err)
 	}
 	return vmDst, nil
 }
 
 func (inst *instance) Run(timeout time.Duration, stop <-chan bool, command string) (
 	<-chan []byte, <-chan error, error) {
-	args := append(vmimpl.SSHArgs(inst.debug, inst.sshKey, inst.targetPort), inst.sshUser+"@"+inst.targetAddr)
-	dmesg, err := vmimpl.OpenRemoteConsole("ssh", args...)
+	dmesg, err := inst.openConsole()
 	if err != nil {
 		return nil, nil, err
 	}
+	if inst.consolePty != nil {
+		dmesg = inst.consolePty.Tee(dmesg)
+	}
+	if inst.mux != nil {
+		dmesg = &muxConsole{dmesg, inst.mux}
+		stop = inst.mux.stopOnTakeover(stop, inst.closed)
+	}
 
 	rpipe, wpipe, err := osutil.LongPipe()
 	if err != nil {
@@ -270,7 +400,7 @@ func (inst *instance) Run(timeout time.Duration, stop <-chan bool, command strin
 		return nil, nil, err
 	}
 
-	args = vmimpl.SSHArgs(inst.debug, inst.sshKey, inst.targetPort)
+	args := vmimpl.SSHArgs(inst.debug, inst.sshKey, inst.targetPort)
 	// Forward target port as part of the ssh connection (reverse proxy)
 	if inst.forwardPort != 0 {
 		proxy := fmt.Sprintf("%v:127.0.0.1:%v", inst.forwardPort, inst.forwardPort)
@@ -303,6 +433,32 @@ func (inst *instance) Run(timeout time.Duration, stop <-chan bool, command strin
 	return vmimpl.Multiplex(cmd, merger, dmesg, timeout, stop, inst.closed, inst.debug)
 }
 
+func (inst *instance) openConsole() (io.ReadCloser, error) {
+	sshArgs := func(command string) []string {
+		return append(vmimpl.SSHArgs(inst.debug, inst.sshKey, inst.targetPort),
+			inst.sshUser+"@"+inst.targetAddr, command)
+	}
+	if inst.cfg.Console != "kmsg" {
+		return vmimpl.OpenRemoteCommand("ssh", sshArgs("dmesg -w")...)
+	}
+	return vmimpl.OpenKmsgConsole(func() (io.ReadCloser, error) {
+		return vmimpl.OpenRemoteCommand("ssh", sshArgs(vmimpl.KmsgCommand)...)
+	})
+}
+
+// ReadPstore waits for the machine to come back after the crash (e.g. after panic reboot)
+// and returns pstore records left by the crashed kernel.
+func (inst *instance) ReadPstore() ([]byte, error) {
+	if err := inst.waitForSSH(10 * time.Minute); err != nil {
+		return nil, fmt.Errorf("machine did not come back after crash: %v", err)
+	}
+	return inst.sshOutput(vmimpl.PstoreCommand)
+}
+
+func (inst *instance) ReadFile(file string) ([]byte, error) {
+	return inst.sshOutput("cat " + file)
+}
+
 func (inst *instance) Diagnose() bool {
 	return false
 }
@@ -0,0 +1,35 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package isolated
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/osutil"
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+func TestConsolePtyInfo(t *testing.T) {
+	inst := &instance{closed: make(chan bool)}
+	if info, err := inst.Info(); err != nil || len(info) != 0 {
+		t.Fatalf("info without console pty: %q, %v", info, err)
+	}
+	pty, err := vmimpl.OpenConsolePty()
+	if err != nil {
+		t.Skipf("can't create pty: %v", err)
+	}
+	inst = &instance{closed: make(chan bool), consolePty: pty}
+	info, err := inst.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(info), "console pty: "+pty.Path+"\n") {
+		t.Fatalf("console pty is not published: %q", info)
+	}
+	if !osutil.IsExist(pty.Path) {
+		t.Fatalf("pty %v does not exist", pty.Path)
+	}
+	inst.Close()
+}
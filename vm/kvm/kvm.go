@@ -134,7 +134,7 @@ func (pool *Pool) Create(workdir string, index int) (vmimpl.Instance, error) {
 		inst.cfg.Lkvm, "sandbox",
 		"--disk", inst.sandbox,
 		"--kernel", inst.cfg.Kernel,
-		"--params", "slub_debug=UZ "+inst.cfg.Cmdline,
+		"--params", inst.KernelCmdline(),
 		"--mem", strconv.Itoa(inst.cfg.Mem),
 		"--cpus", strconv.Itoa(inst.cfg.CPU),
 		"--network", "mode=user",
@@ -287,6 +287,11 @@ func (inst *instance) Run(timeout time.Duration, stop <-chan bool, command strin
 	return outputC, errorC, nil
 }
 
+// KernelCmdline implements vmimpl.CmdlineReporter.
+func (inst *instance) KernelCmdline() string {
+	return "slub_debug=UZ " + inst.cfg.Cmdline
+}
+
 func (inst *instance) Diagnose() bool {
 	return false
 }
@@ -0,0 +1,239 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/report"
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+// MemoryGrowthPrefix is the title prefix of reports about suspicious growth of guest state values
+// detected by leak_watch. Such reports are a heuristic signal, they are not reproducible as crashes.
+const MemoryGrowthPrefix = "suspicious memory growth: "
+
+// leakWatch samples guest state files (e.g. /proc/slabinfo) at the start of a run and periodically,
+// and at the end of the run reports values that grew monotonically by more than the thresholds.
+type leakWatch struct {
+	cfg   mgrconfig.LeakWatch
+	read  func(file string) ([]byte, error)
+	index int
+	stop  chan bool
+	done  chan bool
+
+	mu      sync.Mutex
+	samples []leakSample
+}
+
+// leakSample maps "file: key" to value (e.g. "slabinfo: kmalloc-64" to the number of active objects).
+type leakSample map[string]int64
+
+// startLeakWatch starts sampling guest state if leak_watch is configured and the VM supports it.
+// Returns nil otherwise.
+func (inst *Instance) startLeakWatch() *leakWatch {
+	cfg := inst.pool.leakWatch
+	if len(cfg.Files) == 0 {
+		return nil
+	}
+	reader, ok := inst.impl.(vmimpl.FileReader)
+	if !ok {
+		log.Logf(1, "vm-%v: VM does not support leak_watch", inst.index)
+		return nil
+	}
+	lw := newLeakWatch(cfg, reader.ReadFile, inst.index)
+	go lw.loop(time.Duration(cfg.Period) * time.Second)
+	return lw
+}
+
+func newLeakWatch(cfg mgrconfig.LeakWatch, read func(file string) ([]byte, error), index int) *leakWatch {
+	return &leakWatch{
+		cfg:   cfg,
+		read:  read,
+		index: index,
+		stop:  make(chan bool),
+		done:  make(chan bool),
+	}
+}
+
+func (lw *leakWatch) loop(period time.Duration) {
+	defer close(lw.done)
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		lw.sample()
+		select {
+		case <-ticker.C:
+		case <-lw.stop:
+			return
+		}
+	}
+}
+
+// sample reads all files and records a new sample.
+func (lw *leakWatch) sample() {
+	sample := make(leakSample)
+	for _, file := range lw.cfg.Files {
+		data, err := lw.read(file)
+		if err != nil {
+			log.Logf(1, "vm-%v: leak_watch: failed to read %v: %v", lw.index, file, err)
+			return
+		}
+		parseLeakSample(sample, file, data)
+	}
+	lw.mu.Lock()
+	lw.samples = append(lw.samples, sample)
+	lw.mu.Unlock()
+}
+
+// finish stops sampling, takes the final sample and returns a report about suspicious growth
+// with the before/after diff attached, or nil if nothing suspicious is detected.
+// lw can be nil, then finish returns nil.
+func (lw *leakWatch) finish(output []byte) *report.Report {
+	if lw == nil {
+		return nil
+	}
+	lw.close()
+	lw.sample()
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	growth := findLeakGrowth(lw.samples, lw.cfg.Growth, lw.cfg.MinGrowth)
+	if len(growth) == 0 {
+		return nil
+	}
+	diff := formatLeakDiff(lw.samples, growth)
+	return &report.Report{
+		Title:  MemoryGrowthPrefix + growth[0],
+		Report: diff,
+		Output: append(append(append([]byte{}, output...), leakWatchHeader...), diff...),
+	}
+}
+
+// close stops sampling, lw can be nil.
+func (lw *leakWatch) close() {
+	if lw == nil {
+		return
+	}
+	select {
+	case <-lw.stop:
+	default:
+		close(lw.stop)
+	}
+	<-lw.done
+}
+
+const leakWatchHeader = "\nsyzkaller: leak_watch detected suspicious growth of guest state:\n"
+
+// parseLeakSample parses a guest state file and adds its values to sample.
+// Generally lines have the "key value ..." format (e.g. "Slab: 123 kB" in /proc/meminfo,
+// or "kmalloc-64 <active_objs> <num_objs> ..." in /proc/slabinfo), the first numeric field
+// after the key is used as the value. /proc/vmallocinfo lines are summed up per allocation caller.
+func parseLeakSample(sample leakSample, file string, data []byte) {
+	name := filepath.Base(file)
+	vmalloc := name == "vmallocinfo"
+	for s := bufio.NewScanner(bytes.NewReader(data)); s.Scan(); {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		key := strings.TrimSuffix(fields[0], ":")
+		if vmalloc {
+			// 0xffffc90000000000-0xffffc90000005000   20480 foo+0x1a/0x40 pages=4 vmalloc
+			if len(fields) < 3 {
+				continue
+			}
+			key = fields[2]
+			if plus := strings.IndexByte(key, '+'); plus != -1 {
+				key = key[:plus]
+			}
+		}
+		for _, field := range fields[1:] {
+			if v, err := strconv.ParseInt(field, 10, 64); err == nil {
+				key = name + ": " + key
+				if vmalloc {
+					sample[key] += v
+				} else {
+					sample[key] = v
+				}
+				break
+			}
+		}
+	}
+}
+
+// findLeakGrowth returns keys which values grew monotonically between all samples
+// by at least growth percent of the first value and at least minGrowth in absolute terms.
+// The keys are sorted by relative growth, the most suspicious first.
+func findLeakGrowth(samples []leakSample, growth int, minGrowth int64) []string {
+	if len(samples) < 2 {
+		return nil
+	}
+	var res []string
+	ratio := make(map[string]float64)
+	for key, first := range samples[0] {
+		prev, monotonic := first, true
+		for _, sample := range samples[1:] {
+			v, ok := sample[key]
+			if !ok || v < prev {
+				monotonic = false
+				break
+			}
+			prev = v
+		}
+		if !monotonic || prev <= first || prev-first < minGrowth || (prev-first)*100 < first*int64(growth) {
+			continue
+		}
+		res = append(res, key)
+		ratio[key] = float64(prev-first) / float64(first+1)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if ratio[res[i]] != ratio[res[j]] {
+			return ratio[res[i]] > ratio[res[j]]
+		}
+		return res[i] < res[j]
+	})
+	return res
+}
+
+// formatLeakDiff formats the suspicious values with all their samples,
+// followed by the before/after diff of all changed values.
+func formatLeakDiff(samples []leakSample, growth []string) []byte {
+	buf := new(bytes.Buffer)
+	first, last := samples[0], samples[len(samples)-1]
+	fmt.Fprintf(buf, "suspicious growth in %v samples:\n", len(samples))
+	for _, key := range growth {
+		fmt.Fprintf(buf, "%v:", key)
+		for _, sample := range samples {
+			fmt.Fprintf(buf, " %v", sample[key])
+		}
+		fmt.Fprintf(buf, "\n")
+	}
+	var changed []string
+	for key, v := range last {
+		if before, ok := first[key]; !ok || before != v {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	fmt.Fprintf(buf, "\nbefore/after diff:\n")
+	for _, key := range changed {
+		before, ok := first[key]
+		if !ok {
+			fmt.Fprintf(buf, "%v: - -> %v\n", key, last[key])
+			continue
+		}
+		fmt.Fprintf(buf, "%v: %v -> %v (%+d)\n", key, before, last[key], last[key]-before)
+	}
+	return buf.Bytes()
+}
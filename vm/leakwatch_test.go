@@ -0,0 +1,117 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+)
+
+const slabinfoHeader = "slabinfo - version: 2.1\n" +
+	"# name            <active_objs> <num_objs> <objsize> <objperslab> <pagesperslab> : tunables ...\n"
+
+func TestLeakWatch(t *testing.T) {
+	samples := []map[string]string{
+		{
+			"/proc/slabinfo": slabinfoHeader +
+				"kmalloc-64          10000  10240     64   64    1 : tunables    0    0    0\n" +
+				"kmalloc-128          5000   5120    128   32    1 : tunables    0    0    0\n" +
+				"dentry              20000  20100    192   21    1 : tunables    0    0    0\n",
+			"/proc/meminfo": "MemTotal:        2048000 kB\nSlab:              50000 kB\n",
+		},
+		{
+			"/proc/slabinfo": slabinfoHeader +
+				"kmalloc-64          20000  20480     64   64    1 : tunables    0    0    0\n" +
+				"kmalloc-128         12000  12160    128   32    1 : tunables    0    0    0\n" +
+				"dentry              90000  90100    192   21    1 : tunables    0    0    0\n",
+			"/proc/meminfo": "MemTotal:        2048000 kB\nSlab:              80000 kB\n",
+		},
+		{
+			"/proc/slabinfo": slabinfoHeader +
+				"kmalloc-64          35000  35200     64   64    1 : tunables    0    0    0\n" +
+				"kmalloc-128         13000  13120    128   32    1 : tunables    0    0    0\n" +
+				"dentry              30000  30100    192   21    1 : tunables    0    0    0\n",
+			"/proc/meminfo": "MemTotal:        2048000 kB\nSlab:              90000 kB\n",
+		},
+	}
+	current := 0
+	read := func(file string) ([]byte, error) {
+		data, ok := samples[current][file]
+		if !ok {
+			return nil, fmt.Errorf("no file %v", file)
+		}
+		return []byte(data), nil
+	}
+	cfg := mgrconfig.LeakWatch{
+		Files:     []string{"/proc/slabinfo", "/proc/meminfo"},
+		Growth:    100,
+		MinGrowth: 1000,
+	}
+	lw := newLeakWatch(cfg, read, 0)
+	close(lw.done) // the sampling loop is not started
+	lw.sample()
+	current++
+	lw.sample()
+	current++
+	rep := lw.finish([]byte("console output\n"))
+	if rep == nil {
+		t.Fatalf("growth is not detected")
+	}
+	// kmalloc-64 grew by 250%, kmalloc-128 by 160%, dentry is not monotonic,
+	// meminfo Slab grew only by 80%.
+	if want := MemoryGrowthPrefix + "slabinfo: kmalloc-64"; rep.Title != want {
+		t.Fatalf("got title %q, want %q", rep.Title, want)
+	}
+	want := "suspicious growth in 3 samples:\n" +
+		"slabinfo: kmalloc-64: 10000 20000 35000\n" +
+		"slabinfo: kmalloc-128: 5000 12000 13000\n" +
+		"\nbefore/after diff:\n" +
+		"meminfo: Slab: 50000 -> 90000 (+40000)\n" +
+		"slabinfo: dentry: 20000 -> 30000 (+10000)\n" +
+		"slabinfo: kmalloc-128: 5000 -> 13000 (+8000)\n" +
+		"slabinfo: kmalloc-64: 10000 -> 35000 (+25000)\n"
+	if string(rep.Report) != want {
+		t.Fatalf("got report:\n%s\nwant:\n%s", rep.Report, want)
+	}
+	if !bytes.HasPrefix(rep.Output, []byte("console output\n"+leakWatchHeader)) ||
+		!bytes.HasSuffix(rep.Output, rep.Report) {
+		t.Fatalf("diff is not attached to output:\n%s", rep.Output)
+	}
+}
+
+func TestLeakWatchNoGrowth(t *testing.T) {
+	data := slabinfoHeader + "kmalloc-64          10000  10240     64   64    1 : tunables    0    0    0\n"
+	read := func(file string) ([]byte, error) {
+		return []byte(data), nil
+	}
+	lw := newLeakWatch(mgrconfig.LeakWatch{Files: []string{"/proc/slabinfo"}, Growth: 100}, read, 0)
+	close(lw.done)
+	lw.sample()
+	if rep := lw.finish(nil); rep != nil {
+		t.Fatalf("unexpected report: %v\n%s", rep.Title, rep.Report)
+	}
+	var nilWatch *leakWatch
+	if rep := nilWatch.finish(nil); rep != nil {
+		t.Fatalf("nil leak watch returned a report")
+	}
+}
+
+func TestParseLeakSample(t *testing.T) {
+	sample := make(leakSample)
+	parseLeakSample(sample, "/proc/vmallocinfo", []byte(
+		"0xffffc90000000000-0xffffc90000005000   20480 foo+0x1a/0x40 pages=4 vmalloc\n"+
+			"0xffffc90000005000-0xffffc90000007000    8192 bar+0x10/0x20 pages=1 vmalloc\n"+
+			"0xffffc90000008000-0xffffc9000000a000    8192 foo+0x1b/0x40 pages=1 vmalloc\n"))
+	want := leakSample{
+		"vmallocinfo: foo": 28672,
+		"vmallocinfo: bar": 8192,
+	}
+	if !reflect.DeepEqual(sample, want) {
+		t.Fatalf("got %v, want %v", sample, want)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/report"
+)
+
+// Liveness check of kernel oopses (liveness_check config).
+// BUG_ON and WARN_ON print similar-looking reports, but the kernel halts after a fatal BUG,
+// while it continues after a WARNING. Unless the kernel panics on oopses, the report text
+// does not tell which one happened, so after an oops the console is watched for a while:
+// the oops is non-fatal if the fuzzer executes more programs, and fatal otherwise.
+
+type livenessCheck struct {
+	timeout time.Duration
+	titles  []*regexp.Regexp
+}
+
+var defaultLivenessTitles = []string{`^(WARNING|BUG|kernel BUG)`}
+
+// compileLivenessCheck returns nil if the liveness check is not configured.
+func compileLivenessCheck(cfg mgrconfig.LivenessCheck) (*livenessCheck, error) {
+	if cfg.Timeout == 0 {
+		return nil, nil
+	}
+	check := &livenessCheck{
+		timeout: time.Duration(cfg.Timeout) * time.Second,
+	}
+	titles := cfg.Titles
+	if len(titles) == 0 {
+		titles = defaultLivenessTitles
+	}
+	for _, title := range titles {
+		re, err := regexp.Compile(title)
+		if err != nil {
+			return nil, fmt.Errorf("bad liveness_check title %q: %v", title, err)
+		}
+		check.titles = append(check.titles, re)
+	}
+	return check, nil
+}
+
+func (check *livenessCheck) matches(title string) bool {
+	for _, re := range check.titles {
+		if re.MatchString(title) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkLiveness is called when a crash that needs to be reported is detected in mon.output[mon.matchPos:].
+// It watches the output after the crash and returns report.LivenessNonFatal if the fuzzer executes
+// more programs, report.LivenessFatal if it does not, or "" if the check is not configured,
+// does not apply to the crash or its result is unknown.
+func (mon *monitor) checkLiveness() string {
+	check := mon.inst.pool.liveness
+	if check == nil || mon.canExit {
+		return ""
+	}
+	rep := mon.reporter.Parse(mon.output[mon.matchPos:])
+	if rep == nil || !check.matches(rep.Title) {
+		return ""
+	}
+	end := mon.matchPos + rep.EndPos
+	timer := time.NewTimer(check.timeout)
+	defer timer.Stop()
+	for {
+		after := mon.output[end:]
+		if bytes.Contains(after, warningPanic) {
+			return report.LivenessFatal
+		}
+		if bytes.Contains(after, executingProgram1) || bytes.Contains(after, executingProgram2) {
+			return report.LivenessNonFatal
+		}
+		if mon.outc == nil {
+			return report.LivenessFatal
+		}
+		select {
+		case out, ok := <-mon.outc:
+			if !ok {
+				mon.outc = nil
+				mon.connLost = true
+				continue
+			}
+			mon.appendOutput(out)
+		case err := <-mon.errc:
+			mon.console.exit(err)
+			switch err {
+			case nil, ErrTimeout:
+				// The run has ended regardless of the kernel state.
+				return ""
+			default:
+				mon.connLost = true
+				return report.LivenessFatal
+			}
+		case <-timer.C:
+			return report.LivenessFatal
+		case <-Shutdown:
+			return ""
+		}
+	}
+}
+
+// livenessSeverity refines severity of a crash by the liveness of the kernel after it:
+// non-fatal crashes are less severe (except for critical ones that are likely exploitable anyway),
+// while fatal ones are at least of medium severity.
+func livenessSeverity(severity, liveness string) string {
+	switch {
+	case liveness == report.LivenessNonFatal && severity == mgrconfig.SeverityHigh:
+		return mgrconfig.SeverityMedium
+	case liveness == report.LivenessNonFatal && severity == mgrconfig.SeverityMedium:
+		return mgrconfig.SeverityLow
+	case liveness == report.LivenessFatal && severity == mgrconfig.SeverityLow:
+		return mgrconfig.SeverityMedium
+	}
+	return severity
+}
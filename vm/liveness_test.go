@@ -0,0 +1,63 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"testing"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/report"
+)
+
+func TestLivenessCheck(t *testing.T) {
+	if check, err := compileLivenessCheck(mgrconfig.LivenessCheck{}); check != nil || err != nil {
+		t.Fatalf("disabled check is compiled: %v, %v", check, err)
+	}
+	if _, err := compileLivenessCheck(mgrconfig.LivenessCheck{Timeout: 1, Titles: []string{"("}}); err == nil {
+		t.Fatalf("bad regexp is accepted")
+	}
+	check, err := compileLivenessCheck(mgrconfig.LivenessCheck{Timeout: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for title, want := range map[string]bool{
+		"WARNING in foo":                  true,
+		"BUG: sleeping function called":   true,
+		"kernel BUG at mm/slub.c:LINE!":   true,
+		"KASAN: use-after-free Read":      false,
+		"general protection fault in foo": false,
+	} {
+		if got := check.matches(title); got != want {
+			t.Errorf("%q: got %v, want %v", title, got, want)
+		}
+	}
+	check, err = compileLivenessCheck(mgrconfig.LivenessCheck{Timeout: 1, Titles: []string{"^KASAN"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !check.matches("KASAN: use-after-free Read") || check.matches("WARNING in foo") {
+		t.Errorf("configured titles are not used")
+	}
+}
+
+func TestLivenessSeverity(t *testing.T) {
+	tests := []struct {
+		severity string
+		liveness string
+		want     string
+	}{
+		{mgrconfig.SeverityHigh, "", mgrconfig.SeverityHigh},
+		{mgrconfig.SeverityHigh, report.LivenessNonFatal, mgrconfig.SeverityMedium},
+		{mgrconfig.SeverityMedium, report.LivenessNonFatal, mgrconfig.SeverityLow},
+		{mgrconfig.SeverityLow, report.LivenessNonFatal, mgrconfig.SeverityLow},
+		{mgrconfig.SeverityCritical, report.LivenessNonFatal, mgrconfig.SeverityCritical},
+		{mgrconfig.SeverityLow, report.LivenessFatal, mgrconfig.SeverityMedium},
+		{mgrconfig.SeverityHigh, report.LivenessFatal, mgrconfig.SeverityHigh},
+	}
+	for i, test := range tests {
+		if got := livenessSeverity(test.severity, test.liveness); got != test.want {
+			t.Errorf("#%v: got %v, want %v", i, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// lineLimiter caps length of individual console output lines.
+// Some drivers print multi-megabyte lines (e.g. hex dumps without newlines), such lines
+// blow past the report context limits and bury crashes that are printed in the middle of them.
+// The beginning of an over-long line is passed through as is, the rest is dropped and replaced
+// with a truncation marker at the end of the line. The dropped part is still checked for oops
+// markers (with the same reporter that checks the whole output): if the kernel starts printing
+// a crash in the middle of the line, the line is terminated right before the crash and the crash
+// is passed through starting from a new line. The truncation point is chosen so that it never
+// splits an oops marker: the last window bytes before the limit are held until it's known that
+// they don't contain beginning of a crash.
+type lineLimiter struct {
+	limit      int               // max line length that is passed through
+	window     int               // length of the held tail of the line (must fit oops markers)
+	contains   func([]byte) bool // returns true if the data contains a crash
+	lineLen    int               // passed through bytes of the current line
+	held       []byte            // bytes of the current line that are not yet passed through or dropped
+	truncating bool              // the current line has exceeded the limit
+	dropped    int               // dropped bytes of the current line
+}
+
+func newLineLimiter(contains func([]byte) bool) *lineLimiter {
+	return &lineLimiter{
+		limit:    maxLineLength,
+		window:   longLineWindow,
+		contains: contains,
+	}
+}
+
+// process consumes a chunk of output and returns output that should be passed through.
+func (ll *lineLimiter) process(data []byte) []byte {
+	var res []byte
+	for len(data) != 0 {
+		end := bytes.IndexByte(data, '\n') + 1
+		eol := end != 0
+		if !eol {
+			end = len(data)
+		}
+		var rest []byte
+		res, rest = ll.processPart(res, data[:end], eol)
+		data = data[end:]
+		if rest != nil {
+			// The line was terminated before a crash, the crash starts a new line.
+			data = append(rest, data...)
+		}
+	}
+	return res
+}
+
+// processPart processes part of the current line (eol says if the part finishes the line).
+// If the line is terminated before a crash, processPart returns the rest of the part
+// that needs to be processed as a new line.
+func (ll *lineLimiter) processPart(res, part []byte, eol bool) ([]byte, []byte) {
+	if !ll.truncating && ll.lineLen+len(ll.held)+len(part) <= ll.limit {
+		if eol {
+			res = append(append(res, ll.held...), part...)
+			ll.reset()
+			return res, nil
+		}
+		// Pass through everything before the window, the window is held
+		// until we know if the line exceeds the limit or not.
+		ll.held = append(ll.held, part...)
+		n := max0(ll.limit - ll.window - ll.lineLen)
+		if n > len(ll.held) {
+			n = len(ll.held)
+		}
+		res = append(res, ll.held[:n]...)
+		ll.lineLen += n
+		ll.held = append(ll.held[:0], ll.held[n:]...)
+		return res, nil
+	}
+	buf := append(ll.held, part...)
+	ll.held = nil
+	if eol {
+		// The newline is not a part of the line, it's added back when the line is finished.
+		buf = buf[:len(buf)-1]
+	}
+	// A crash at the beginning of the line does not need to be moved to a new line.
+	skip := 0
+	if !ll.truncating && ll.lineLen == 0 {
+		skip = 1
+	}
+	pos := ll.crashPos(buf[skip:])
+	if pos != -1 {
+		pos += skip
+	}
+	if !ll.truncating {
+		// Pass through the line up to the limit (or up to the crash).
+		n := max0(ll.limit - ll.lineLen)
+		if pos != -1 && pos < n {
+			n = pos
+		}
+		if n > len(buf) {
+			n = len(buf)
+		}
+		res = append(res, buf[:n]...)
+		ll.lineLen += n
+		buf = buf[n:]
+		if pos != -1 {
+			pos -= n
+		}
+		ll.truncating = true
+	}
+	if pos != -1 {
+		ll.dropped += pos
+		res = ll.finishLine(res)
+		rest := append([]byte{}, buf[pos:]...)
+		if eol {
+			rest = append(rest, '\n')
+		}
+		return res, rest
+	}
+	if eol {
+		ll.dropped += len(buf)
+		return ll.finishLine(res), nil
+	}
+	// Keep the tail that can contain beginning of an oops marker.
+	n := max0(len(buf) - ll.window)
+	ll.dropped += n
+	ll.held = append([]byte{}, buf[n:]...)
+	return res, nil
+}
+
+// crashPos returns position of the first crash in buf, or -1 if buf does not contain a crash.
+func (ll *lineLimiter) crashPos(buf []byte) int {
+	if !ll.contains(buf) {
+		return -1
+	}
+	// Find the shortest prefix that contains the crash (it ends inside of the first oops marker),
+	// and then the shortest suffix of that prefix that still contains the crash
+	// (it starts at the beginning of the marker).
+	lo, hi := 1, len(buf)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if ll.contains(buf[:mid]) {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	end := hi
+	lo, hi = 0, end-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if ll.contains(buf[mid:end]) {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo
+}
+
+// finishLine terminates the current line with the truncation marker.
+func (ll *lineLimiter) finishLine(res []byte) []byte {
+	if ll.dropped != 0 {
+		res = append(res, fmt.Sprintf(" ... syzkaller: truncated %v bytes of a long line", ll.dropped)...)
+	}
+	res = append(res, '\n')
+	ll.reset()
+	return res
+}
+
+// flush appends the held part of the current line to res.
+func (ll *lineLimiter) flush(res []byte) []byte {
+	if ll.truncating {
+		ll.dropped += len(ll.held)
+		return ll.finishLine(res)
+	}
+	res = append(res, ll.held...)
+	ll.lineLen += len(ll.held)
+	ll.held = nil
+	return res
+}
+
+func (ll *lineLimiter) reset() {
+	ll.lineLen = 0
+	ll.held = nil
+	ll.truncating = false
+	ll.dropped = 0
+}
+
+var (
+	// Max length of console output lines, the rest of longer lines is truncated.
+	maxLineLength = 64 << 10
+	// Longer than any oops marker and most crash titles.
+	longLineWindow = 1 << 10
+)
@@ -0,0 +1,183 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/report"
+)
+
+func TestLineLimiter(t *testing.T) {
+	tests := []struct {
+		input  []string
+		output string
+	}{
+		{
+			input:  []string{"short\n", "lines\n"},
+			output: "short\nlines\n",
+		},
+		{
+			input:  []string{"0123456789abcdef\n"},
+			output: "0123456789abcdef\n",
+		},
+		{
+			input:  []string{"0123", "4567", "89ab", "cdef", "\nnext\n"},
+			output: "0123456789abcdef\nnext\n",
+		},
+		{
+			input:  []string{"0123456789abcdef0123456789\nnext\n"},
+			output: "0123456789abcdef ... syzkaller: truncated 10 bytes of a long line\nnext\n",
+		},
+		{
+			input:  []string{"0123456789", "abcdef0123456789", "0123456789", "\n"},
+			output: "0123456789abcdef ... syzkaller: truncated 20 bytes of a long line\n",
+		},
+		{
+			// Crash in the middle of the dropped part starts a new line.
+			input: []string{"0123456789abcdef0123456789BUG: bad\n"},
+			output: "0123456789abcdef ... syzkaller: truncated 10 bytes of a long line\n" +
+				"BUG: bad\n",
+		},
+		{
+			// Crash marker split between chunks.
+			input: []string{"0123456789abcdef0123456789B", "U", "G: bad", "\nnext\n"},
+			output: "0123456789abcdef ... syzkaller: truncated 10 bytes of a long line\n" +
+				"BUG: bad\nnext\n",
+		},
+		{
+			// Crash crossing the limit is not split.
+			input: []string{"0123456789abcBUG: bad\n"},
+			output: "0123456789abc\n" +
+				"BUG: bad\n",
+		},
+		{
+			// Crash at the beginning of a long line is kept.
+			input:  []string{"BUG: bad 0123456789abcdef\n"},
+			output: "BUG: bad 0123456 ... syzkaller: truncated 9 bytes of a long line\n",
+		},
+		{
+			// Unfinished long line is terminated on flush.
+			input:  []string{"0123456789abcdef0123456789"},
+			output: "0123456789abcdef ... syzkaller: truncated 10 bytes of a long line\n",
+		},
+		{
+			// Unfinished short line is passed through as is on flush.
+			input:  []string{"0123456789"},
+			output: "0123456789",
+		},
+	}
+	for i, test := range tests {
+		ll := &lineLimiter{
+			limit:  16,
+			window: 4,
+			contains: func(data []byte) bool {
+				return bytes.Contains(data, []byte("BUG:"))
+			},
+		}
+		var output []byte
+		for _, input := range test.input {
+			output = append(output, ll.process([]byte(input))...)
+		}
+		output = ll.flush(output)
+		if string(output) != test.output {
+			t.Errorf("test #%v: got output:\n%q\nwant:\n%q", i, output, test.output)
+		}
+	}
+}
+
+func TestLineLimiterHugeLine(t *testing.T) {
+	ll := newLineLimiter(func(data []byte) bool {
+		return bytes.Contains(data, []byte("BUG:"))
+	})
+	hex := strings.Repeat("0123456789abcdef", 1<<16)
+	var output []byte
+	for i := 0; i < len(hex); i += 4 << 10 {
+		output = append(output, ll.process([]byte(hex[i:i+4<<10]))...)
+	}
+	output = append(output, ll.process([]byte("BUG: bad\n"))...)
+	output = ll.flush(output)
+	lines := strings.Split(string(output), "\n")
+	if len(lines) != 3 || len(lines[0]) > maxLineLength+100 || lines[1] != "BUG: bad" || lines[2] != "" {
+		t.Fatalf("bad output: %v lines, first line %v bytes, second line %q",
+			len(lines), len(lines[0]), lines[1])
+	}
+}
+
+func TestLongLineCrash(t *testing.T) {
+	hexDump := strings.Repeat("de ad be ef 00 11 22 33 ", (1<<20)/24)
+	tests := []struct {
+		name   string
+		output []string
+	}{
+		{
+			name:   "crash-between-long-lines",
+			output: []string{hexDump, "\n", longLineKasanReport, hexDump, "\n"},
+		},
+		{
+			name:   "crash-in-the-middle-of-long-line",
+			output: []string{hexDump, longLineKasanReport, hexDump, "\n"},
+		},
+		{
+			name:   "crash-after-unfinished-long-line",
+			output: []string{hexDump, hexDump[:1000] + longLineKasanReport[:20], longLineKasanReport[20:]},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			rep := monitorOutput(t, test.output)
+			if rep == nil {
+				t.Fatalf("got no report")
+			}
+			if want := "KASAN: use-after-free Read in foo"; rep.Title != want {
+				t.Fatalf("want title %q, got %q", want, rep.Title)
+			}
+			if !bytes.Contains(rep.Output, []byte("syzkaller: truncated")) {
+				t.Fatalf("output does not contain the truncation marker")
+			}
+			if len(rep.Report) > 2*maxLineLength {
+				t.Fatalf("report is too long: %v bytes", len(rep.Report))
+			}
+			if !bytes.Contains(rep.Report, []byte("\nBUG: KASAN: use-after-free in foo+0x10/0x20\n")) {
+				t.Fatalf("report does not contain the crash on a separate line:\n%s", rep.Report)
+			}
+		})
+	}
+}
+
+// monitorOutput runs MonitorExecution on a test VM that prints the given output chunks.
+func monitorOutput(t *testing.T, output []string) *report.Report {
+	cfg := new(mgrconfig.Config)
+	pool, reporter := createTestPool(t, cfg)
+	defer os.RemoveAll(cfg.Workdir)
+	return runTestInstance(t, pool, reporter, false, func(inst *testInstance) {
+		go func() {
+			for _, out := range output {
+				// Console output arrives in small chunks.
+				for len(out) != 0 {
+					n := 4 << 10
+					if n > len(out) {
+						n = len(out)
+					}
+					inst.outc <- []byte(out[:n])
+					out = out[n:]
+				}
+			}
+		}()
+	})
+}
+
+const longLineKasanReport = `BUG: KASAN: use-after-free in foo+0x10/0x20
+Read of size 8 at addr ffff8880a5b0a0f8 by task syz-executor/1
+CPU: 0 PID: 1 Comm: syz-executor Not tainted 4.19.0+ #1
+Call Trace:
+ dump_stack+0x244/0x39d
+ foo+0x10/0x20
+`
@@ -0,0 +1,187 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+// memState captures guest memory state when a crash is detected (crash_mem_state config):
+// /proc/meminfo, the largest /proc/slabinfo caches and /proc/vmstat deltas since the start of the run.
+// The kernel may be wedged after the crash, so collection is abandoned after memStateTimeout
+// and whatever was read by then is returned.
+type memState struct {
+	read   func(file string) ([]byte, error)
+	index  int
+	vmstat chan leakSample // /proc/vmstat at the start of the run (nil if it can't be read)
+}
+
+const memStateTopSlabs = 20
+
+var memStateTimeout = 10 * time.Second
+
+// startMemState reads the baseline /proc/vmstat in background if crash_mem_state is configured
+// and the VM supports reading files. Returns nil otherwise.
+func (inst *Instance) startMemState() *memState {
+	if !inst.pool.crashMemState {
+		return nil
+	}
+	reader, ok := inst.impl.(vmimpl.FileReader)
+	if !ok {
+		log.Logf(1, "vm-%v: VM does not support crash_mem_state", inst.index)
+		return nil
+	}
+	ms := &memState{
+		read:   reader.ReadFile,
+		index:  inst.index,
+		vmstat: make(chan leakSample, 1),
+	}
+	go func() {
+		data, err := ms.read("/proc/vmstat")
+		if err != nil {
+			log.Logf(1, "vm-%v: crash_mem_state: failed to read /proc/vmstat: %v", ms.index, err)
+			ms.vmstat <- nil
+			return
+		}
+		sample := make(leakSample)
+		parseLeakSample(sample, "/proc/vmstat", data)
+		ms.vmstat <- sample
+	}()
+	return ms
+}
+
+// collect returns the formatted guest memory state, or nil if nothing could be read.
+// ms can be nil, then collect returns nil.
+func (ms *memState) collect() []byte {
+	if ms == nil {
+		return nil
+	}
+	var baseline leakSample
+	select {
+	case baseline = <-ms.vmstat:
+	default:
+	}
+	sections := make(chan string, 3)
+	go func() {
+		defer close(sections)
+		for _, file := range []string{"/proc/meminfo", "/proc/slabinfo", "/proc/vmstat"} {
+			data, err := ms.read(file)
+			if err != nil {
+				log.Logf(1, "vm-%v: crash_mem_state: failed to read %v: %v", ms.index, file, err)
+				continue
+			}
+			switch file {
+			case "/proc/meminfo":
+				sections <- fmt.Sprintf("%v:\n%s", file, data)
+			case "/proc/slabinfo":
+				sections <- formatTopSlabs(data, memStateTopSlabs)
+			case "/proc/vmstat":
+				sections <- formatVmstatDelta(baseline, data)
+			}
+		}
+	}()
+	buf := new(bytes.Buffer)
+	timeout := time.After(memStateTimeout)
+	for {
+		select {
+		case section, ok := <-sections:
+			if !ok {
+				return buf.Bytes()
+			}
+			if buf.Len() != 0 {
+				buf.WriteString("\n")
+			}
+			buf.WriteString(section)
+		case <-timeout:
+			log.Logf(0, "vm-%v: crash_mem_state: timed out reading guest memory state", ms.index)
+			if buf.Len() == 0 {
+				return nil
+			}
+			fmt.Fprintf(buf, "\n(timed out after %v)\n", memStateTimeout)
+			return buf.Bytes()
+		}
+	}
+}
+
+type slabCache struct {
+	name    string
+	active  int64
+	objects int64
+	objSize int64
+}
+
+// formatTopSlabs formats the top slab caches from /proc/slabinfo by total size of their objects.
+func formatTopSlabs(data []byte, top int) string {
+	// name <active_objs> <num_objs> <objsize> <objperslab> <pagesperslab> : tunables ... : slabdata ...
+	var caches []slabCache
+	for s := bufio.NewScanner(bytes.NewReader(data)); s.Scan(); {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 4 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var vals [3]int64
+		var err error
+		for i := range vals {
+			if vals[i], err = strconv.ParseInt(fields[i+1], 10, 64); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			continue
+		}
+		caches = append(caches, slabCache{fields[0], vals[0], vals[1], vals[2]})
+	}
+	sort.SliceStable(caches, func(i, j int) bool {
+		return caches[i].objects*caches[i].objSize > caches[j].objects*caches[j].objSize
+	})
+	if len(caches) > top {
+		caches = caches[:top]
+	}
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "/proc/slabinfo (top %v by size):\n", top)
+	fmt.Fprintf(buf, "%-24v %12v %12v %8v %12v\n", "name", "active_objs", "num_objs", "objsize", "size (kB)")
+	for _, c := range caches {
+		fmt.Fprintf(buf, "%-24v %12v %12v %8v %12v\n", c.name, c.active, c.objects, c.objSize,
+			c.objects*c.objSize/1024)
+	}
+	return buf.String()
+}
+
+// formatVmstatDelta formats changed /proc/vmstat values since the baseline,
+// or all values if there is no baseline.
+func formatVmstatDelta(baseline leakSample, data []byte) string {
+	sample := make(leakSample)
+	parseLeakSample(sample, "/proc/vmstat", data)
+	keys := make([]string, 0, len(sample))
+	for key := range sample {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	buf := new(bytes.Buffer)
+	if baseline == nil {
+		fmt.Fprintf(buf, "/proc/vmstat:\n")
+	} else {
+		fmt.Fprintf(buf, "/proc/vmstat (delta since the start of the run):\n")
+	}
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, "vmstat: ")
+		if baseline == nil {
+			fmt.Fprintf(buf, "%v %v\n", name, sample[key])
+			continue
+		}
+		if delta := sample[key] - baseline[key]; delta != 0 {
+			fmt.Fprintf(buf, "%v %+d\n", name, delta)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,106 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/report"
+)
+
+const testSlabinfo = `slabinfo - version: 2.1
+# name            <active_objs> <num_objs> <objsize> <objperslab> <pagesperslab> : tunables <limit> <batchcount> <sharedfactor> : slabdata <active_slabs> <num_slabs> <sharedavail>
+kmalloc-64         1000   1024     64   64    1 : tunables    0    0    0 : slabdata     16     16      0
+kmalloc-4k          100    128   4096    8    8 : tunables    0    0    0 : slabdata     16     16      0
+dentry             2000   2048    192   21    1 : tunables    0    0    0 : slabdata     98     98      0
+`
+
+func TestMemState(t *testing.T) {
+	cfg := &mgrconfig.Config{CrashMemState: true}
+	pool, reporter := createTestPool(t, cfg)
+	defer os.RemoveAll(cfg.Workdir)
+	run := func(connLost bool) *report.Report {
+		return runTestInstance(t, pool, reporter, false, func(inst *testInstance) {
+			inst.files = map[string][]byte{
+				"/proc/meminfo":  []byte("MemTotal: 2048 kB\nMemFree: 1024 kB\n"),
+				"/proc/slabinfo": []byte(testSlabinfo),
+				"/proc/vmstat":   []byte("nr_free_pages 100\npgfault 200\n"),
+			}
+			if connLost {
+				inst.errc <- errors.New("lost connection")
+			} else {
+				inst.outc <- []byte("BUG: bad\n")
+			}
+		})
+	}
+	rep := run(false)
+	if rep == nil || rep.Title != "BUG: bad" {
+		t.Fatalf("got bad report: %+v", rep)
+	}
+	state := string(rep.MemState)
+	for _, want := range []string{"/proc/meminfo:\nMemTotal: 2048 kB\n", "/proc/slabinfo (top 20 by size):",
+		"/proc/vmstat"} {
+		if !strings.Contains(state, want) {
+			t.Errorf("mem state does not contain %q:\n%s", want, state)
+		}
+	}
+	if strings.Contains(string(rep.Report), "MemTotal") {
+		t.Errorf("mem state is mixed into the report")
+	}
+	if rep := run(true); rep == nil || rep.Title != lostConnectionCrash || rep.MemState != nil {
+		t.Fatalf("mem state is collected after lost connection: %+v", rep)
+	}
+}
+
+func TestMemStateCollect(t *testing.T) {
+	files := map[string]string{
+		"/proc/meminfo":  "MemTotal: 2048 kB\n",
+		"/proc/slabinfo": testSlabinfo,
+		"/proc/vmstat":   "nr_free_pages 50\npgfault 300\nnr_dirty 10\n",
+	}
+	read := func(file string) ([]byte, error) {
+		return []byte(files[file]), nil
+	}
+	ms := &memState{read: read, vmstat: make(chan leakSample, 1)}
+	ms.vmstat <- leakSample{"vmstat: nr_free_pages": 100, "vmstat: pgfault": 200, "vmstat: nr_dirty": 10}
+	want := `/proc/meminfo:
+MemTotal: 2048 kB
+
+/proc/slabinfo (top 20 by size):
+name                      active_objs     num_objs  objsize    size (kB)
+kmalloc-4k                        100          128     4096          512
+dentry                           2000         2048      192          384
+kmalloc-64                       1000         1024       64           64
+
+/proc/vmstat (delta since the start of the run):
+nr_free_pages -50
+pgfault +100
+`
+	if got := string(ms.collect()); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+	// The kernel is wedged: what was read before the timeout is returned.
+	defer func(timeout time.Duration) { memStateTimeout = timeout }(memStateTimeout)
+	memStateTimeout = time.Second
+	block := make(chan bool)
+	defer close(block)
+	ms = &memState{
+		read: func(file string) ([]byte, error) {
+			if file != "/proc/meminfo" {
+				<-block
+			}
+			return read(file)
+		},
+		vmstat: make(chan leakSample, 1),
+	}
+	want = "/proc/meminfo:\nMemTotal: 2048 kB\n\n(timed out after 1s)\n"
+	if got := string(ms.collect()); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
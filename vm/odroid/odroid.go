@@ -91,6 +91,11 @@ func (pool *Pool) Count() int {
 	return 1 // no support for multiple Odroid devices yet
 }
 
+// OutputFilter drops U-Boot output from the serial console, the board is power-cycled between runs.
+func (pool *Pool) OutputFilter() func(line []byte) []byte {
+	return vmimpl.StripBootloader
+}
+
 func (pool *Pool) Create(workdir string, index int) (vmimpl.Instance, error) {
 	inst := &instance{
 		cfg:    pool.cfg,
@@ -289,21 +294,8 @@ func (inst *instance) Copy(hostSrc string) (string, error) {
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stdout
 	}
-	if err := cmd.Start(); err != nil {
-		return "", err
-	}
-	done := make(chan bool)
-	go func() {
-		select {
-		case <-time.After(3 * time.Minute):
-			cmd.Process.Kill()
-		case <-done:
-		}
-	}()
-	err := cmd.Wait()
-	close(done)
-	if err != nil {
-		return "", err
+	if _, err := osutil.Run(3*time.Minute, cmd); err != nil {
+		return "", vmimpl.ClassifyCopyError(err)
 	}
 	return vmDst, nil
 }
@@ -0,0 +1,148 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+// PlacementStrategy chooses where instances of pools that span several hosts or zones
+// (see vmimpl.Placer) are created (placement config).
+type PlacementStrategy interface {
+	// Place returns the location for the instance with the given index,
+	// it must be one of state.Locations.
+	Place(index int, state *PlacementState) vmimpl.Location
+}
+
+// PlacementState is the current placement of instances of a pool.
+type PlacementState struct {
+	Locations []vmimpl.Location       // all locations of the pool
+	Placed    map[int]vmimpl.Location // index -> location of live instances (except the one being placed)
+}
+
+// Load returns the number of live instances in the location.
+func (state *PlacementState) Load(loc vmimpl.Location) int {
+	n := 0
+	for _, placed := range state.Placed {
+		if placed == loc {
+			n++
+		}
+	}
+	return n
+}
+
+// ZoneLoad returns the number of live instances in the zone.
+func (state *PlacementState) ZoneLoad(zone string) int {
+	n := 0
+	for _, placed := range state.Placed {
+		if placed.Zone == zone {
+			n++
+		}
+	}
+	return n
+}
+
+var placementStrategies = map[string]func() PlacementStrategy{
+	"round-robin":   func() PlacementStrategy { return roundRobin{} },
+	"least-loaded":  func() PlacementStrategy { return leastLoaded{} },
+	"zone-balanced": func() PlacementStrategy { return zoneBalanced{} },
+}
+
+// RegisterPlacement registers a placement strategy that can be selected with placement config.
+func RegisterPlacement(name string, ctor func() PlacementStrategy) {
+	placementStrategies[name] = ctor
+}
+
+func newPlacement(name string) (PlacementStrategy, error) {
+	if name == "" {
+		return nil, nil
+	}
+	ctor := placementStrategies[name]
+	if ctor == nil {
+		var names []string
+		for name := range placementStrategies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown placement %q, want one of %v", name, names)
+	}
+	return ctor(), nil
+}
+
+// roundRobin places instances by their index regardless of load.
+type roundRobin struct{}
+
+func (roundRobin) Place(index int, state *PlacementState) vmimpl.Location {
+	return state.Locations[index%len(state.Locations)]
+}
+
+// leastLoaded places instances in the location with the fewest live instances.
+type leastLoaded struct{}
+
+func (leastLoaded) Place(index int, state *PlacementState) vmimpl.Location {
+	return leastLoadedOf(state.Locations, state)
+}
+
+// zoneBalanced places instances in the zone with the fewest live instances
+// (and in the least loaded location of the zone), so that a zone failure takes down
+// as few instances as possible regardless of the number of locations in each zone.
+type zoneBalanced struct{}
+
+func (zoneBalanced) Place(index int, state *PlacementState) vmimpl.Location {
+	var zones []string
+	locations := make(map[string][]vmimpl.Location)
+	for _, loc := range state.Locations {
+		if locations[loc.Zone] == nil {
+			zones = append(zones, loc.Zone)
+		}
+		locations[loc.Zone] = append(locations[loc.Zone], loc)
+	}
+	best, bestLoad := "", 0
+	for i, zone := range zones {
+		if load := state.ZoneLoad(zone); i == 0 || load < bestLoad {
+			best, bestLoad = zone, load
+		}
+	}
+	return leastLoadedOf(locations[best], state)
+}
+
+func leastLoadedOf(locations []vmimpl.Location, state *PlacementState) vmimpl.Location {
+	best, bestLoad := locations[0], state.Load(locations[0])
+	for _, loc := range locations[1:] {
+		if load := state.Load(loc); load < bestLoad {
+			best, bestLoad = loc, load
+		}
+	}
+	return best
+}
+
+// place chooses the location for the instance with the given index and records it as placed.
+func (pool *Pool) place(index int) vmimpl.Location {
+	pool.placeMu.Lock()
+	defer pool.placeMu.Unlock()
+	state := &PlacementState{
+		Locations: pool.impl.(vmimpl.Placer).Locations(),
+		Placed:    make(map[int]vmimpl.Location),
+	}
+	for idx, loc := range pool.placed {
+		if idx != index {
+			state.Placed[idx] = loc
+		}
+	}
+	loc := pool.placement.Place(index, state)
+	pool.placed[index] = loc
+	return loc
+}
+
+// unplace removes the instance with the given index from the placement state.
+func (pool *Pool) unplace(index int, loc vmimpl.Location) {
+	pool.placeMu.Lock()
+	defer pool.placeMu.Unlock()
+	if placed, ok := pool.placed[index]; ok && placed == loc {
+		delete(pool.placed, index)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+// testPlacerPool spans hosts in 3 zones with different numbers of hosts.
+type testPlacerPool struct {
+	testPool
+}
+
+func (pool *testPlacerPool) Count() int {
+	return 9
+}
+
+func (pool *testPlacerPool) Locations() []vmimpl.Location {
+	return []vmimpl.Location{
+		{Name: "host-a0", Zone: "zone-a"},
+		{Name: "host-a1", Zone: "zone-a"},
+		{Name: "host-a2", Zone: "zone-a"},
+		{Name: "host-b0", Zone: "zone-b"},
+		{Name: "host-c0", Zone: "zone-c"},
+		{Name: "host-c1", Zone: "zone-c"},
+	}
+}
+
+func (pool *testPlacerPool) CreateAt(workdir string, index int, loc vmimpl.Location) (vmimpl.Instance, error) {
+	return pool.Create(workdir, index)
+}
+
+func init() {
+	placerCtor := func(env *vmimpl.Env) (vmimpl.Pool, error) {
+		return &testPlacerPool{}, nil
+	}
+	vmimpl.Register("test-placer", placerCtor, false)
+}
+
+func TestZoneBalancedPlacement(t *testing.T) {
+	cfg := &mgrconfig.Config{
+		Type:      "test-placer",
+		Placement: "zone-balanced",
+	}
+	pool, _ := createTestPool(t, cfg)
+	defer os.RemoveAll(cfg.Workdir)
+	var err error
+	insts := make([]*Instance, pool.Count())
+	for i := range insts {
+		if insts[i], err = pool.Create(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkZones := func(want map[string]int) {
+		zones := make(map[string]int)
+		for _, inst := range insts {
+			zones[inst.location.Zone]++
+		}
+		for zone, n := range want {
+			if zones[zone] != n {
+				t.Fatalf("%v instances in %v, want %v (placement: %v)", zones[zone], zone, n, zones)
+			}
+		}
+	}
+	checkZones(map[string]int{"zone-a": 3, "zone-b": 3, "zone-c": 3})
+	hosts := make(map[string]int)
+	for _, inst := range insts {
+		hosts[inst.location.Name]++
+	}
+	for host, want := range map[string]int{"host-a0": 1, "host-a1": 1, "host-a2": 1, "host-b0": 3} {
+		if hosts[host] != want {
+			t.Fatalf("%v instances on %v, want %v", hosts[host], host, want)
+		}
+	}
+	// Instances that are re-created after zone-b went down go to zone-b again once it's the least loaded.
+	var recreate []int
+	for i, inst := range insts {
+		if inst.location.Zone == "zone-b" {
+			inst.Close()
+			recreate = append(recreate, i)
+		}
+	}
+	checkZones(map[string]int{"zone-b": 3})
+	if len(pool.placed) != pool.Count()-3 {
+		t.Fatalf("closed instances are still placed: %v", pool.placed)
+	}
+	for _, i := range recreate {
+		if insts[i], err = pool.Create(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkZones(map[string]int{"zone-a": 3, "zone-b": 3, "zone-c": 3})
+	for _, inst := range insts {
+		inst.Close()
+	}
+	if len(pool.placed) != 0 {
+		t.Fatalf("closed instances are still placed: %v", pool.placed)
+	}
+}
+
+func TestPlacementStrategies(t *testing.T) {
+	locations := (&testPlacerPool{}).Locations()
+	state := &PlacementState{
+		Locations: locations,
+		Placed: map[int]vmimpl.Location{
+			0: locations[0],
+			1: locations[1],
+			2: locations[3],
+		},
+	}
+	for _, test := range []struct {
+		placement string
+		index     int
+		want      string
+	}{
+		{"round-robin", 3, "host-b0"},
+		{"round-robin", 7, "host-a1"},
+		{"least-loaded", 3, "host-a2"},
+		{"zone-balanced", 3, "host-c0"},
+	} {
+		placement, err := newPlacement(test.placement)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := placement.Place(test.index, state); got.Name != test.want {
+			t.Errorf("%v: placed %v in %v, want %v", test.placement, test.index, got.Name, test.want)
+		}
+	}
+	if _, err := newPlacement("foo"); err == nil {
+		t.Errorf("created unknown placement")
+	}
+	// Placement is ignored for VM types that don't span several locations.
+	cfg := &mgrconfig.Config{Placement: "least-loaded"}
+	pool, _ := createTestPool(t, cfg)
+	defer os.RemoveAll(cfg.Workdir)
+	if pool.placement != nil {
+		t.Errorf("placement is used for a pool that does not support it")
+	}
+}
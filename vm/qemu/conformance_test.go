@@ -0,0 +1,41 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/google/syzkaller/vm/vmimpl"
+	"github.com/google/syzkaller/vm/vmimpl/conformance"
+)
+
+// TestConformance boots real VMs, so it runs only if SYZ_QEMU_TEST_IMAGE and SYZ_QEMU_TEST_SSHKEY
+// are set (e.g. to the image and key produced by tools/create-image.sh).
+// SYZ_QEMU_TEST_CONFIG can hold json qemu config (e.g. {"kernel": "bzImage"}).
+func TestConformance(t *testing.T) {
+	image := os.Getenv("SYZ_QEMU_TEST_IMAGE")
+	if image == "" {
+		t.Skip("SYZ_QEMU_TEST_IMAGE is not set")
+	}
+	config := os.Getenv("SYZ_QEMU_TEST_CONFIG")
+	if config == "" {
+		config = "{}"
+	}
+	conformance.Run(t, &conformance.Config{
+		Ctor: ctor,
+		Env: &vmimpl.Env{
+			Name:    "conformance",
+			OS:      runtime.GOOS,
+			Arch:    runtime.GOARCH,
+			Image:   image,
+			SSHKey:  os.Getenv("SYZ_QEMU_TEST_SSHKEY"),
+			SSHUser: "root",
+			Config:  []byte(config),
+		},
+		RealBoot:      true,
+		ReuseInstance: true,
+	})
+}
@@ -0,0 +1,192 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/syzkaller/pkg/osutil"
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+// Default max ratio of the total memory/CPUs of all VMs to the host memory/CPUs (see host_overcommit).
+const defaultHostOvercommit = 2
+
+// checkHostResources returns an error if VMs need more host memory or CPUs than cfg.HostOvercommit allows.
+// qemuArgs are expanded qemu_args of all VMs, they may override mem/cpu for particular VMs.
+func checkHostResources(cfg *Config, host *hostInfo, qemuArgs []string) error {
+	factor := cfg.HostOvercommit
+	if factor < 0 {
+		return nil
+	}
+	if factor == 0 {
+		factor = defaultHostOvercommit
+	}
+	mem, cpus := 0, 0
+	for _, args := range qemuArgs {
+		vmMem, vmCPUs := vmResources(cfg, args)
+		mem += vmMem
+		cpus += vmCPUs
+	}
+	if host.Mem > 0 && float64(mem) > float64(host.Mem)*factor {
+		return fmt.Errorf("%v VMs need %vMB of memory, but the host has only %vMB available"+
+			" (host_overcommit=%v), reduce count or mem", len(qemuArgs), mem, host.Mem, factor)
+	}
+	if host.CPUs > 0 && float64(cpus) > float64(host.CPUs)*factor {
+		return fmt.Errorf("%v VMs need %v CPUs, but the host has only %v CPUs"+
+			" (host_overcommit=%v), reduce count or cpu", len(qemuArgs), cpus, host.CPUs, factor)
+	}
+	return nil
+}
+
+// vmResources returns memory (in MBs) and the number of CPUs of a VM with the given expanded qemu_args.
+// qemu_args go after -m/-smp generated from mem/cpu and qemu uses the last values.
+func vmResources(cfg *Config, qemuArgs string) (int, int) {
+	mem, cpus := cfg.Mem, cfg.CPU
+	args := strings.Fields(qemuArgs)
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "-m":
+			if val, ok := parseMemArg(args[i+1]); ok {
+				mem = val
+			}
+		case "-smp":
+			if val, ok := parseSMPArg(args[i+1]); ok {
+				cpus = val
+			}
+		}
+	}
+	return mem, cpus
+}
+
+// Size suffixes of qemu -m argument -> shift to get KBs.
+var memSuffixes = map[byte]uint{
+	'k': 0, 'K': 0,
+	'm': 10, 'M': 10,
+	'g': 20, 'G': 20,
+	't': 30, 'T': 30,
+}
+
+// parseMemArg parses value of qemu -m argument ("2048", "2G", "size=2G,slots=2,maxmem=8G") in MBs.
+func parseMemArg(arg string) (int, bool) {
+	val, ok := optionValue(arg, "size")
+	if !ok || val == "" {
+		return 0, false
+	}
+	shift := uint(10) // MBs by default
+	if suffix, ok := memSuffixes[val[len(val)-1]]; ok {
+		shift = suffix
+		val = val[:len(val)-1]
+	}
+	n, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return int(n << shift >> 10), true
+}
+
+// parseSMPArg parses the number of CPUs from value of qemu -smp argument ("4", "cpus=4,sockets=1").
+func parseSMPArg(arg string) (int, bool) {
+	val, ok := optionValue(arg, "cpus")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// optionValue returns the value of qemu option name from a comma-separated list of options,
+// name can also be omitted if it goes first.
+func optionValue(arg, name string) (string, bool) {
+	for i, opt := range strings.Split(arg, ",") {
+		if i == 0 && !strings.Contains(opt, "=") {
+			return opt, true
+		}
+		if strings.HasPrefix(opt, name+"=") {
+			return opt[len(name)+1:], true
+		}
+	}
+	return "", false
+}
+
+// Qemu killed with SIGKILL on the host is classified as an infrastructure error (rather than
+// a lost connection crash) if there is evidence of host memory pressure: the host OOM killer
+// message about the qemu process in the host kernel log, or memory stall in PSI.
+const (
+	// How long to wait for qemu to exit after the VM output is closed.
+	qemuExitTimeout = 3 * time.Second
+	// Min percentage of time all tasks stalled on memory in the last 10 seconds
+	// ("full avg10" in /proc/pressure/memory) that indicates host memory pressure.
+	hostMemPressure = 10
+)
+
+var (
+	// Overridden in tests.
+	readHostLog = func() []byte {
+		// dmesg may be restricted for non-root users, then PSI is the only evidence.
+		out, _ := osutil.RunCmd(10*time.Second, "", "dmesg")
+		return out
+	}
+	readHostMemPressure = func() []byte {
+		data, _ := ioutil.ReadFile("/proc/pressure/memory")
+		return data
+	}
+)
+
+// hostKilled returns InfraError if the qemu process was killed because of host memory pressure, or nil otherwise.
+func (inst *instance) hostKilled() error {
+	if inst.qemu == nil || inst.qemuDone == nil {
+		return nil
+	}
+	select {
+	case <-inst.qemuDone:
+	case <-time.After(qemuExitTimeout):
+		return nil
+	}
+	status, ok := inst.qemu.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() || status.Signal() != syscall.SIGKILL {
+		return nil
+	}
+	pid := inst.qemu.ProcessState.Pid()
+	evidence := hostOOMEvidence(pid, readHostLog(), readHostMemPressure())
+	if evidence == "" {
+		return nil
+	}
+	return &vmimpl.InfraError{
+		Err: fmt.Errorf("qemu (pid %v) was killed with SIGKILL on the host: %v", pid, evidence),
+	}
+}
+
+// hostOOMEvidence returns a description of host memory pressure related to process pid
+// given the host kernel log and /proc/pressure/memory contents, or "" if there is none.
+func hostOOMEvidence(pid int, hostLog, pressure []byte) string {
+	oomRe := regexp.MustCompile(fmt.Sprintf(`(?:Out of memory|Memory cgroup out of memory): `+
+		`Killed process %v\b.*`, pid))
+	if match := oomRe.FindAll(hostLog, -1); len(match) != 0 {
+		return fmt.Sprintf("host OOM killer: %s", bytes.TrimSpace(match[len(match)-1]))
+	}
+	s := bufio.NewScanner(bytes.NewReader(pressure))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || fields[0] != "full" || !strings.HasPrefix(fields[1], "avg10=") {
+			continue
+		}
+		avg, err := strconv.ParseFloat(strings.TrimPrefix(fields[1], "avg10="), 64)
+		if err == nil && avg >= hostMemPressure {
+			return fmt.Sprintf("host memory pressure: %v", s.Text())
+		}
+	}
+	return ""
+}
@@ -0,0 +1,155 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+func TestVMResources(t *testing.T) {
+	cfg := &Config{CPU: 2, Mem: 2048}
+	tests := []struct {
+		args string
+		mem  int
+		cpus int
+	}{
+		{"", 2048, 2},
+		{"-enable-kvm -smp sockets=2,cores=1", 2048, 2},
+		{"-m 4096 -smp 4", 4096, 4},
+		{"-m 3G -smp cpus=8,sockets=2", 3072, 8},
+		{"-m size=1G,slots=2,maxmem=8G", 1024, 2},
+		{"-m 1048576k", 1024, 2},
+		{"-m slots=2,maxmem=8G", 2048, 2},
+		{"-m 2x -smp many", 2048, 2},
+		{"-m 1G -m 6G", 6144, 2},
+		{"-m", 2048, 2},
+	}
+	for i, test := range tests {
+		mem, cpus := vmResources(cfg, test.args)
+		if mem != test.mem || cpus != test.cpus {
+			t.Errorf("#%v: %q: got mem=%v cpus=%v, want mem=%v cpus=%v",
+				i, test.args, mem, cpus, test.mem, test.cpus)
+		}
+	}
+}
+
+func TestCheckHostResources(t *testing.T) {
+	host := &hostInfo{CPUs: 8, Mem: 16 * 1024}
+	args := func(vms ...string) []string { return vms }
+	tests := []struct {
+		cfg  *Config
+		args []string
+		ok   bool
+	}{
+		{&Config{CPU: 2, Mem: 4096}, args("", "", "", ""), true},
+		{&Config{CPU: 2, Mem: 4096}, args("", "", "", "", "", "", "", ""), true},
+		{&Config{CPU: 2, Mem: 4096}, args("", "", "", "", "", "", "", "", ""), false},
+		{&Config{CPU: 4, Mem: 1024}, args("", "", "", "", ""), false},
+		// Per-VM overrides in qemu_args.
+		{&Config{CPU: 1, Mem: 1024}, args("", "-m 32G"), false},
+		{&Config{CPU: 1, Mem: 1024}, args("", "-smp 16"), false},
+		{&Config{CPU: 1, Mem: 1024, HostOvercommit: 4}, args("", "-m 30G -smp 16"), true},
+		{&Config{CPU: 1, Mem: 1024, HostOvercommit: 1}, args("-m 16G"), true},
+		{&Config{CPU: 1, Mem: 1024, HostOvercommit: 1}, args("", "-m 16G"), false},
+		{&Config{CPU: 64, Mem: 1 << 20, HostOvercommit: -1}, args("", ""), true},
+	}
+	for i, test := range tests {
+		err := checkHostResources(test.cfg, host, test.args)
+		if test.ok != (err == nil) {
+			t.Errorf("#%v: got error %v, want ok %v", i, err, test.ok)
+		}
+	}
+	// Unknown host memory is not checked.
+	if err := checkHostResources(&Config{CPU: 1, Mem: 1 << 20}, &hostInfo{CPUs: 1}, args("")); err != nil {
+		t.Errorf("got error with unknown host memory: %v", err)
+	}
+}
+
+func TestHostOOMEvidence(t *testing.T) {
+	hostLog := `[1000.000001] qemu-system-x86 invoked oom-killer: gfp_mask=0x100cca(GFP_HIGHUSER_MOVABLE), order=0
+[1000.000002] Out of memory: Killed process 12345 (qemu-system-x86) total-vm:9000000kB, anon-rss:8000000kB
+[1000.000003] oom_reaper: reaped process 12345 (qemu-system-x86), now anon-rss:0kB
+`
+	noPressure := "some avg10=0.00 avg60=0.00 avg300=0.00 total=100\n" +
+		"full avg10=0.00 avg60=0.00 avg300=0.00 total=50\n"
+	pressure := "some avg10=45.10 avg60=12.00 avg300=3.00 total=100000\n" +
+		"full avg10=30.50 avg60=8.00 avg300=2.00 total=80000\n"
+	tests := []struct {
+		pid      int
+		log      string
+		pressure string
+		want     string
+	}{
+		{12345, hostLog, noPressure, "host OOM killer: Out of memory: Killed process 12345 (qemu-system-x86)" +
+			" total-vm:9000000kB, anon-rss:8000000kB"},
+		{1234, hostLog, noPressure, ""},
+		{54321, "", pressure, "host memory pressure: full avg10=30.50 avg60=8.00 avg300=2.00 total=80000"},
+		{54321, "", noPressure, ""},
+		{54321, "", "", ""},
+		{777, "Memory cgroup out of memory: Killed process 777 (qemu) total-vm:1kB\n", "",
+			"host OOM killer: Memory cgroup out of memory: Killed process 777 (qemu) total-vm:1kB"},
+	}
+	for i, test := range tests {
+		got := hostOOMEvidence(test.pid, []byte(test.log), []byte(test.pressure))
+		if got != test.want {
+			t.Errorf("#%v: got %q, want %q", i, got, test.want)
+		}
+	}
+}
+
+func TestHostKilled(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("requires sleep")
+	}
+	defer func(readLog, readPressure func() []byte) {
+		readHostLog, readHostMemPressure = readLog, readPressure
+	}(readHostLog, readHostMemPressure)
+	readHostMemPressure = func() []byte { return nil }
+	start := func() *instance {
+		inst := &instance{
+			qemu:     exec.Command("sleep", "1000"),
+			qemuDone: make(chan struct{}),
+		}
+		if err := inst.qemu.Start(); err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			inst.qemu.Wait()
+			close(inst.qemuDone)
+		}()
+		return inst
+	}
+	inst := start()
+	readHostLog = func() []byte {
+		return []byte(fmt.Sprintf("Out of memory: Killed process %v (qemu-system-x86)\n", inst.qemu.Process.Pid))
+	}
+	inst.qemu.Process.Kill()
+	err := inst.hostKilled()
+	if !vmimpl.IsInfra(err) || !strings.Contains(err.Error(), "host OOM killer") {
+		t.Fatalf("got error %v, want infra error", err)
+	}
+	// Killed, but there is no evidence of memory pressure.
+	inst = start()
+	readHostLog = func() []byte { return nil }
+	inst.qemu.Process.Kill()
+	if err := inst.hostKilled(); err != nil {
+		t.Fatalf("got error %v without memory pressure", err)
+	}
+	// Terminated by something other than SIGKILL.
+	inst = start()
+	readHostLog = func() []byte {
+		return []byte(fmt.Sprintf("Out of memory: Killed process %v (qemu-system-x86)\n", inst.qemu.Process.Pid))
+	}
+	inst.qemu.Process.Signal(syscall.SIGTERM)
+	if err := inst.hostKilled(); err != nil {
+		t.Fatalf("got error %v for SIGTERM", err)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"fmt"
+
+	"github.com/google/syzkaller/pkg/osutil"
+)
+
+// Kernel is one of several kernels that VMs of the pool run instead of a single kernel
+// (e.g. to fuzz two kernel builds side-by-side and find crashes that happen only on one of them).
+// VMs are assigned to kernels round-robin according to their shares.
+type Kernel struct {
+	Path string `json:"path"` // kernel for injected boot (e.g. arch/x86/boot/bzImage)
+	// Tag of VMs that run this kernel, it is attached to crashes found on them
+	// (default: kernel0, kernel1, etc by position in kernels).
+	Tag string `json:"tag"`
+	// Relative share of VMs that run this kernel (default: 1), e.g. shares 3 and 1
+	// mean that VMs 0, 1, 2 run the first kernel, VM 3 runs the second one, and so on.
+	Share int `json:"share"`
+}
+
+// checkKernels validates kernels config and fills in defaults.
+func checkKernels(cfg *Config) error {
+	if len(cfg.Kernels) == 0 {
+		return nil
+	}
+	if cfg.Kernel != "" {
+		return fmt.Errorf("kernel and kernels can't be used together")
+	}
+	if cfg.RecordReplay != 0 {
+		return fmt.Errorf("kernels can't be used with record_replay")
+	}
+	tags := make(map[string]bool)
+	for i := range cfg.Kernels {
+		kernel := &cfg.Kernels[i]
+		if kernel.Path == "" {
+			return fmt.Errorf("kernels[%v]: path is empty", i)
+		}
+		if !osutil.IsExist(kernel.Path) {
+			return fmt.Errorf("kernel '%v' does not exist", kernel.Path)
+		}
+		kernel.Path = osutil.Abs(kernel.Path)
+		if kernel.Tag == "" {
+			kernel.Tag = fmt.Sprintf("kernel%v", i)
+		}
+		if tags[kernel.Tag] {
+			return fmt.Errorf("duplicate kernel tag %q", kernel.Tag)
+		}
+		tags[kernel.Tag] = true
+		if kernel.Share == 0 {
+			kernel.Share = 1
+		}
+		if kernel.Share < 0 {
+			return fmt.Errorf("kernels[%v]: negative share %v", i, kernel.Share)
+		}
+	}
+	return nil
+}
+
+// hasKernel returns true if VMs boot a kernel passed to qemu (as opposed to the image bootloader).
+func hasKernel(cfg *Config) bool {
+	return cfg.Kernel != "" || len(cfg.Kernels) != 0
+}
+
+// selectKernel returns the kernel and its tag that the VM with the given index runs.
+// The tag is empty if the pool runs a single kernel.
+func selectKernel(cfg *Config, index int) (string, string) {
+	if len(cfg.Kernels) == 0 {
+		return cfg.Kernel, ""
+	}
+	total := 0
+	for _, kernel := range cfg.Kernels {
+		total += kernel.Share
+	}
+	pos := index % total
+	for _, kernel := range cfg.Kernels {
+		if pos < kernel.Share {
+			return kernel.Path, kernel.Tag
+		}
+		pos -= kernel.Share
+	}
+	panic("unreachable")
+}
+
+// KernelIndexes implements vmimpl.KernelIndexer.
+func (pool *Pool) KernelIndexes(tag string) []int {
+	var indexes []int
+	for index := 0; index < pool.cfg.Count; index++ {
+		if _, kernelTag := selectKernel(pool.cfg, index); kernelTag == tag {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+func (inst *instance) KernelTag() string {
+	return inst.kernelTag
+}
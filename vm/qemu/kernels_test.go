@@ -0,0 +1,109 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+func TestKernels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-qemu-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldKernel := filepath.Join(dir, "bzImage.old")
+	newKernel := filepath.Join(dir, "bzImage.new")
+	for _, file := range []string{oldKernel, newKernel} {
+		if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &Config{
+		Kernels: []Kernel{
+			{Path: oldKernel, Share: 2},
+			{Path: newKernel, Tag: "new"},
+		},
+		Cmdline: "syz.kernel={{.Kernel}}",
+	}
+	if err := checkKernels(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !hasKernel(cfg) {
+		t.Fatalf("hasKernel returned false")
+	}
+	tests := []struct {
+		kernel string
+		tag    string
+	}{
+		{oldKernel, "kernel0"},
+		{oldKernel, "kernel0"},
+		{newKernel, "new"},
+		{oldKernel, "kernel0"},
+		{oldKernel, "kernel0"},
+		{newKernel, "new"},
+	}
+	for index, test := range tests {
+		kernel, tag := selectKernel(cfg, index)
+		if kernel != test.kernel || tag != test.tag {
+			t.Errorf("VM %v: got kernel %v/%v, want %v/%v", index, kernel, tag, test.kernel, test.tag)
+		}
+		_, cmdline, err := expandConfig(cfg, "linux", "amd64", index, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "syz.kernel=" + test.tag; cmdline != want {
+			t.Errorf("VM %v: got cmdline %q, want %q", index, cmdline, want)
+		}
+	}
+	var inst vmimpl.Instance = &instance{kernelTag: "new"}
+	if tagger, ok := inst.(vmimpl.KernelTagger); !ok || tagger.KernelTag() != "new" {
+		t.Fatalf("instance does not report its kernel tag")
+	}
+	cfg.Count = 6
+	var pool vmimpl.Pool = &Pool{cfg: cfg}
+	indexer, ok := pool.(vmimpl.KernelIndexer)
+	if !ok {
+		t.Fatalf("pool does not select kernels")
+	}
+	if indexes := fmt.Sprint(indexer.KernelIndexes("new")); indexes != "[2 5]" {
+		t.Errorf("got VMs %v for kernel new, want [2 5]", indexes)
+	}
+	if indexes := indexer.KernelIndexes("kasan"); len(indexes) != 0 {
+		t.Errorf("got VMs %v for unknown kernel", indexes)
+	}
+	if kernel, tag := selectKernel(&Config{Kernel: oldKernel}, 5); kernel != oldKernel || tag != "" {
+		t.Fatalf("single kernel: got %v/%v", kernel, tag)
+	}
+}
+
+func TestCheckKernels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-qemu-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kernel := filepath.Join(dir, "bzImage")
+	if err := ioutil.WriteFile(kernel, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i, cfg := range []*Config{
+		{Kernel: kernel, Kernels: []Kernel{{Path: kernel}}},
+		{Kernels: []Kernel{{Path: kernel}}, RecordReplay: 1},
+		{Kernels: []Kernel{{}}},
+		{Kernels: []Kernel{{Path: filepath.Join(dir, "foo")}}},
+		{Kernels: []Kernel{{Path: kernel, Tag: "a"}, {Path: kernel, Tag: "a"}}},
+		{Kernels: []Kernel{{Path: kernel, Share: -1}}},
+	} {
+		if err := checkKernels(cfg); err == nil {
+			t.Errorf("#%v: bad config is accepted", i)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+// How long VM creation waits for other VMs to release memory when host_mem_budget is exhausted,
+// after that creation fails (the caller will retry later). Overridden in tests.
+var memBudgetWait = 10 * time.Minute
+
+// memBudget is admission control of VMs by the total memory of running VMs (host_mem_budget):
+// unlike host_overcommit that is checked once for the configured VMs, it also bounds VMs
+// created on top of them (e.g. for reproduction or bisection) and VMs that are being recreated.
+type memBudget struct {
+	mu      sync.Mutex
+	total   int // MBs
+	used    int // MBs
+	changed chan bool
+}
+
+func newMemBudget(total int) *memBudget {
+	return &memBudget{
+		total:   total,
+		changed: make(chan bool),
+	}
+}
+
+// acquire reserves mem MBs for the VM, if the budget is exhausted it waits until other VMs release memory.
+func (budget *memBudget) acquire(name string, mem int) error {
+	if mem > budget.total {
+		return fmt.Errorf("%v needs %vMB of memory, more than host_mem_budget %vMB", name, mem, budget.total)
+	}
+	var timeout <-chan time.Time
+	for {
+		budget.mu.Lock()
+		if budget.used+mem <= budget.total {
+			budget.used += mem
+			budget.mu.Unlock()
+			return nil
+		}
+		used, changed := budget.used, budget.changed
+		budget.mu.Unlock()
+		if timeout == nil {
+			log.Logf(0, "%v: waiting for %vMB of memory: %vMB of host_mem_budget %vMB are used by other VMs",
+				name, mem, used, budget.total)
+			timeout = time.After(memBudgetWait)
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			return fmt.Errorf("%v needs %vMB of memory, but %vMB of host_mem_budget %vMB are used"+
+				" by other VMs for %v", name, mem, used, budget.total, memBudgetWait)
+		case <-vmimpl.Shutdown:
+			return fmt.Errorf("shutdown")
+		}
+	}
+}
+
+// release returns memory reserved by acquire.
+func (budget *memBudget) release(mem int) {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	budget.used -= mem
+	close(budget.changed)
+	budget.changed = make(chan bool)
+}
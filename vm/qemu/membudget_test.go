@@ -0,0 +1,56 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemBudget(t *testing.T) {
+	defer func(old time.Duration) {
+		memBudgetWait = old
+	}(memBudgetWait)
+	memBudgetWait = time.Minute
+	budget := newMemBudget(4096)
+	for i := 0; i < 2; i++ {
+		if err := budget.acquire("vm", 2048); err != nil {
+			t.Fatalf("within-budget VM %v is refused: %v", i, err)
+		}
+	}
+	if err := budget.acquire("huge", 8192); err == nil || !strings.Contains(err.Error(), "host_mem_budget") {
+		t.Fatalf("VM larger than the whole budget is not refused: %v", err)
+	}
+	// The budget is exhausted, the next VM is queued until one of the running VMs is closed.
+	done := make(chan error)
+	go func() {
+		done <- budget.acquire("queued", 1024)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("over-budget VM is not queued: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	budget.release(2048)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("queued VM is refused after release: %v", err)
+		}
+	case <-time.After(time.Minute):
+		t.Fatalf("queued VM is not admitted after release")
+	}
+	if budget.used != 3072 {
+		t.Fatalf("used %vMB, want 3072MB", budget.used)
+	}
+	// Nothing is released, the queued VM gives up after memBudgetWait.
+	memBudgetWait = 10 * time.Millisecond
+	if err := budget.acquire("timeout", 2048); err == nil {
+		t.Fatalf("over-budget VM is admitted")
+	}
+	if budget.used != 3072 {
+		t.Fatalf("used %vMB after refused VM, want 3072MB", budget.used)
+	}
+}
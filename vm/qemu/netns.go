@@ -0,0 +1,152 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/osutil"
+)
+
+// With netns config each VM runs in its own host network namespace with a dedicated bridge
+// and tap device instead of user-mode networking, so that VMs can't interfere via broadcast traffic
+// and ARP. The namespace is connected to the host with a veth pair: the host reaches sshd of the VM
+// at the namespace end of the pair (DNAT to the VM), and the VM reaches the host at the host end
+// (its connections are masqueraded as coming from the namespace end).
+// The VM network is the same in all namespaces: the bridge has hostAddr and the VM has netnsGuestAddr
+// configured with the kernel command line.
+
+const (
+	netnsGuestAddr = "10.0.2.15"
+	netnsBridge    = "syzbr0"
+	netnsTap       = "syztap0"
+)
+
+type netns struct {
+	name   string // namespace name
+	hostIf string // host end of the veth pair
+	nsIf   string // namespace end of the veth pair
+	hostIP string // address of the host end (the VM connects to the host at it)
+	nsIP   string // address of the namespace end (the host connects to sshd of the VM at it)
+}
+
+// Overridden in tests.
+var runNetnsCommand = func(args []string) error {
+	_, err := osutil.RunCmd(time.Minute, "", args[0], args[1:]...)
+	return err
+}
+
+func checkNetns(cfg *Config, targetOS string, archConfig *archConfig) error {
+	if !cfg.NetNS {
+		return nil
+	}
+	if runtime.GOOS != "linux" || targetOS != "linux" {
+		return fmt.Errorf("netns is supported only for linux on linux hosts")
+	}
+	if !hasKernel(cfg) {
+		return fmt.Errorf("netns requires kernel (the VM network is configured with the kernel command line)")
+	}
+	if cfg.RecordReplay != 0 {
+		return fmt.Errorf("netns is not supported with record_replay")
+	}
+	if archConfig.HostFuzzer {
+		return fmt.Errorf("netns is not supported for this target")
+	}
+	for _, bin := range []string{"ip", "iptables"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			return fmt.Errorf("netns requires %v: %v", bin, err)
+		}
+	}
+	return nil
+}
+
+// newNetns returns the namespace of VM index of the pool, names and addresses depend on the pool name,
+// so that several managers can run on the same host.
+func newNetns(pool string, index int) *netns {
+	hash := fnv.New32a()
+	hash.Write([]byte(pool))
+	sum := hash.Sum32()
+	id := fmt.Sprintf("%04x", sum&0xffff)
+	subnet := fmt.Sprintf("10.%v.%v", 128+sum>>16%64, index)
+	return &netns{
+		name:   fmt.Sprintf("syz-%v-%v", id, index),
+		hostIf: fmt.Sprintf("syz%vh%v", id, index),
+		nsIf:   fmt.Sprintf("syz%vn%v", id, index),
+		hostIP: subnet + ".1",
+		nsIP:   subnet + ".2",
+	}
+}
+
+// exec returns the command that runs bin with args in the namespace.
+func (ns *netns) exec(bin string, args ...string) []string {
+	return append([]string{"ip", "netns", "exec", ns.name, bin}, args...)
+}
+
+func (ns *netns) setupCommands() [][]string {
+	return [][]string{
+		{"ip", "netns", "add", ns.name},
+		{"ip", "link", "add", ns.hostIf, "type", "veth", "peer", "name", ns.nsIf},
+		{"ip", "link", "set", ns.nsIf, "netns", ns.name},
+		{"ip", "addr", "add", ns.hostIP + "/30", "dev", ns.hostIf},
+		{"ip", "link", "set", ns.hostIf, "up"},
+		ns.exec("ip", "link", "set", "lo", "up"),
+		ns.exec("ip", "addr", "add", ns.nsIP+"/30", "dev", ns.nsIf),
+		ns.exec("ip", "link", "set", ns.nsIf, "up"),
+		ns.exec("ip", "link", "add", netnsBridge, "type", "bridge"),
+		ns.exec("ip", "addr", "add", hostAddr+"/24", "dev", netnsBridge),
+		ns.exec("ip", "link", "set", netnsBridge, "up"),
+		ns.exec("ip", "tuntap", "add", netnsTap, "mode", "tap"),
+		ns.exec("ip", "link", "set", netnsTap, "master", netnsBridge),
+		ns.exec("ip", "link", "set", netnsTap, "up"),
+		ns.exec("sysctl", "-qw", "net.ipv4.ip_forward=1"),
+		ns.exec("iptables", "-t", "nat", "-A", "PREROUTING", "-i", ns.nsIf, "-p", "tcp", "--dport", "22",
+			"-j", "DNAT", "--to-destination", netnsGuestAddr+":22"),
+		ns.exec("iptables", "-t", "nat", "-A", "POSTROUTING", "-o", ns.nsIf, "-j", "MASQUERADE"),
+	}
+}
+
+func (ns *netns) teardownCommands() [][]string {
+	// Deletion of the namespace destroys the bridge, the tap and the namespace end of the veth pair,
+	// the host end is destroyed with its peer.
+	return [][]string{
+		{"ip", "netns", "del", ns.name},
+	}
+}
+
+// setup creates the namespace, a namespace left from a previous run is destroyed first.
+func (ns *netns) setup() error {
+	ns.teardown()
+	for _, args := range ns.setupCommands() {
+		if err := runNetnsCommand(args); err != nil {
+			ns.teardown()
+			return fmt.Errorf("failed to set up netns %v: %v", ns.name, err)
+		}
+	}
+	return nil
+}
+
+func (ns *netns) teardown() {
+	for _, args := range ns.teardownCommands() {
+		if err := runNetnsCommand(args); err != nil {
+			log.Logf(2, "netns %v teardown: %v", ns.name, err)
+		}
+	}
+}
+
+func netnsArgs(nicModel string) []string {
+	return []string{
+		"-net", "nic" + nicModel,
+		"-net", fmt.Sprintf("tap,ifname=%v,script=no,downscript=no", netnsTap),
+	}
+}
+
+// netnsCmdline configures the VM network (net.ifnames=0 is in the default command line).
+func netnsCmdline() string {
+	return fmt.Sprintf("ip=%v::%v:255.255.255.0::eth0:off", netnsGuestAddr, hostAddr)
+}
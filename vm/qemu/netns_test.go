@@ -0,0 +1,100 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func stubNetnsCommands(fail string) *[][]string {
+	var commands [][]string
+	runNetnsCommand = func(args []string) error {
+		commands = append(commands, args)
+		if fail != "" && strings.Contains(strings.Join(args, " "), fail) {
+			return errors.New("failed")
+		}
+		return nil
+	}
+	return &commands
+}
+
+func TestNetnsSetup(t *testing.T) {
+	defer func(run func([]string) error) { runNetnsCommand = run }(runNetnsCommand)
+	commands := stubNetnsCommands("")
+	ns := newNetns("ci-qemu", 3)
+	if err := ns.setup(); err != nil {
+		t.Fatal(err)
+	}
+	// A namespace left from a previous run is destroyed before setup.
+	want := append(ns.teardownCommands(), ns.setupCommands()...)
+	if !reflect.DeepEqual(*commands, want) {
+		t.Fatalf("got commands:\n%q\nwant:\n%q", *commands, want)
+	}
+	if (*commands)[1][2] != "add" || (*commands)[1][3] != ns.name {
+		t.Fatalf("namespace is not created first: %q", (*commands)[1])
+	}
+	// The namespace is configured from within it.
+	for _, args := range ns.setupCommands()[5:] {
+		if !reflect.DeepEqual(args[:4], []string{"ip", "netns", "exec", ns.name}) {
+			t.Errorf("command is not run in the namespace: %q", args)
+		}
+	}
+	*commands = nil
+	ns.teardown()
+	if want := [][]string{{"ip", "netns", "del", ns.name}}; !reflect.DeepEqual(*commands, want) {
+		t.Fatalf("got teardown commands %q, want %q", *commands, want)
+	}
+}
+
+func TestNetnsSetupFailure(t *testing.T) {
+	defer func(run func([]string) error) { runNetnsCommand = run }(runNetnsCommand)
+	commands := stubNetnsCommands("tuntap")
+	ns := newNetns("ci-qemu", 0)
+	if err := ns.setup(); err == nil {
+		t.Fatal("setup did not fail")
+	}
+	last := (*commands)[len(*commands)-1]
+	if !reflect.DeepEqual(last, []string{"ip", "netns", "del", ns.name}) {
+		t.Fatalf("namespace is not destroyed on failure, last command %q", last)
+	}
+	for _, args := range *commands {
+		if strings.Contains(strings.Join(args, " "), "iptables") {
+			t.Fatalf("setup continued after failure: %q", args)
+		}
+	}
+}
+
+func TestNetnsNames(t *testing.T) {
+	ns0, ns1 := newNetns("ci-qemu", 0), newNetns("ci-qemu", 1)
+	other := newNetns("ci-qemu2", 0)
+	if ns0.name == ns1.name || ns0.name == other.name || ns0.hostIP == ns1.hostIP || ns0.nsIP == ns1.nsIP {
+		t.Fatalf("namespaces are not unique: %+v %+v %+v", ns0, ns1, other)
+	}
+	for _, ns := range []*netns{ns0, ns1, other} {
+		// Interface names are limited to 15 chars.
+		if len(ns.hostIf) > 15 || len(ns.nsIf) > 15 {
+			t.Errorf("too long interface names: %+v", ns)
+		}
+	}
+}
+
+func TestNetnsForward(t *testing.T) {
+	ns := newNetns("ci-qemu", 2)
+	inst := &instance{netns: ns, sshAddr: ns.nsIP, archConfig: archConfigs["linux/amd64"]}
+	addr, err := inst.Forward(1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The VM connects to the host end of the veth pair of its namespace.
+	if want := ns.hostIP + ":1234"; addr != want {
+		t.Fatalf("got forward address %v, want %v", addr, want)
+	}
+	if cmd := ns.exec("qemu-system-x86_64", "-m", "2048"); !reflect.DeepEqual(cmd,
+		[]string{"ip", "netns", "exec", ns.name, "qemu-system-x86_64", "-m", "2048"}) {
+		t.Fatalf("got qemu command %q", cmd)
+	}
+}
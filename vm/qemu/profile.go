@@ -0,0 +1,198 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+)
+
+const (
+	defaultBootTimeout = 10 * time.Minute
+	// CI jobs have limited time, it's better to fail fast than to hang until the job is killed.
+	ciBootTimeout = 5 * time.Minute
+	// Default VM params for the ci profile if they are not specified in the config.
+	ciDefaultCPU = 2
+	ciDefaultMem = 2048
+	// Don't squeeze VMs below that much memory, run fewer VMs instead.
+	ciMinMem = 1024
+)
+
+// hostInfo describes the host resources relevant for the ci profile.
+type hostInfo struct {
+	CI   bool // running under a CI system
+	KVM  bool // /dev/kvm is usable
+	CPUs int
+	Mem  int // available memory in MBs (0 if unknown)
+}
+
+func detectHost() *hostInfo {
+	host := &hostInfo{
+		CPUs: runtime.NumCPU(),
+	}
+	for _, name := range []string{"CI", "GITHUB_ACTIONS", "GITLAB_CI", "TRAVIS", "BUILDKITE"} {
+		if val := os.Getenv(name); val != "" && val != "false" && val != "0" {
+			host.CI = true
+		}
+	}
+	if f, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0); err == nil {
+		f.Close()
+		host.KVM = true
+	}
+	if data, err := ioutil.ReadFile("/proc/meminfo"); err == nil {
+		host.Mem = parseMemAvailable(data)
+	}
+	return host
+}
+
+// parseMemAvailable returns MemAvailable from /proc/meminfo in MBs.
+func parseMemAvailable(data []byte) int {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kb, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return 0
+		}
+		return kb / 1024
+	}
+	return 0
+}
+
+// applyProfile adjusts cfg according to cfg.Profile and host resources.
+// Returns the boot timeout to use.
+func applyProfile(cfg *Config, host *hostInfo) (time.Duration, error) {
+	switch cfg.Profile {
+	case "":
+		return defaultBootTimeout, nil
+	case "auto":
+		if !host.CI {
+			return defaultBootTimeout, nil
+		}
+		log.Logf(0, "qemu: CI environment detected, using ci profile")
+	case "ci":
+	default:
+		return 0, fmt.Errorf("unknown qemu profile %q, want ci or auto", cfg.Profile)
+	}
+	if !host.KVM {
+		qemuArgs, dropped := disableKVM(cfg.QemuArgs)
+		if len(dropped) != 0 {
+			log.Logf(0, "qemu ci profile: kvm is not available, using tcg (dropped/replaced %q)",
+				strings.Join(dropped, " "))
+			cfg.QemuArgs = qemuArgs
+		}
+	}
+	if cfg.CPU == 0 {
+		cfg.CPU = ciDefaultCPU
+	}
+	if cfg.Mem == 0 {
+		cfg.Mem = ciDefaultMem
+	}
+	if host.CPUs > 0 {
+		if cfg.Count > host.CPUs {
+			log.Logf(0, "qemu ci profile: reducing count from %v to %v to fit %v host CPUs",
+				cfg.Count, host.CPUs, host.CPUs)
+			cfg.Count = host.CPUs
+		}
+		if cpu := host.CPUs / cfg.Count; cfg.CPU > cpu {
+			log.Logf(0, "qemu ci profile: reducing cpu from %v to %v to fit %v host CPUs",
+				cfg.CPU, cpu, host.CPUs)
+			cfg.CPU = cpu
+		}
+	}
+	if host.Mem > 0 {
+		// Leave some memory for the host (manager, qemu itself, etc).
+		budget := host.Mem * 3 / 4
+		if cfg.Count > 1 && budget/cfg.Count < ciMinMem {
+			count := budget / ciMinMem
+			if count < 1 {
+				count = 1
+			}
+			if count < cfg.Count {
+				log.Logf(0, "qemu ci profile: reducing count from %v to %v to fit %vMB of host memory",
+					cfg.Count, count, host.Mem)
+				cfg.Count = count
+			}
+		}
+		if mem := budget / cfg.Count; cfg.Mem > mem {
+			if mem < 128 {
+				return 0, fmt.Errorf("qemu ci profile: not enough host memory (%vMB available)", host.Mem)
+			}
+			log.Logf(0, "qemu ci profile: reducing mem from %vMB to %vMB to fit %vMB of host memory",
+				cfg.Mem, mem, host.Mem)
+			cfg.Mem = mem
+		}
+	}
+	log.Logf(0, "qemu ci profile: count=%v cpu=%v mem=%vMB boot timeout %v",
+		cfg.Count, cfg.CPU, cfg.Mem, ciBootTimeout)
+	return ciBootTimeout, nil
+}
+
+// disableKVM rewrites qemu args that require kvm to use tcg.
+// Returns the new args and the args that were dropped or replaced.
+func disableKVM(qemuArgs string) (string, []string) {
+	var res, dropped []string
+	args := strings.Fields(qemuArgs)
+	for i := 0; i < len(args); i++ {
+		arg, next := args[i], ""
+		if i+1 < len(args) {
+			next = args[i+1]
+		}
+		if !isKVMArg(arg, next) {
+			res = append(res, arg)
+			continue
+		}
+		if arg == "-enable-kvm" {
+			dropped = append(dropped, arg)
+			continue
+		}
+		i++
+		dropped = append(dropped, arg, next)
+		switch arg {
+		case "-accel":
+			res = append(res, arg, "tcg")
+		case "-machine", "-M":
+			res = append(res, arg, replaceAccel(next))
+		}
+	}
+	return strings.Join(res, " "), dropped
+}
+
+// isKVMArg returns true if qemu argument arg (followed by next) requires kvm.
+func isKVMArg(arg, next string) bool {
+	switch {
+	case arg == "-enable-kvm":
+	case arg == "-accel" && !strings.HasPrefix(next, "tcg"):
+	case (arg == "-machine" || arg == "-M") && strings.Contains(next, "accel=") &&
+		!strings.Contains(next, "accel=tcg"):
+	case arg == "-cpu" && strings.HasPrefix(next, "host"):
+		// -cpu host is supported only with kvm.
+	default:
+		return false
+	}
+	return true
+}
+
+// replaceAccel replaces accel=... in -machine options with accel=tcg.
+func replaceAccel(opts string) string {
+	parts := strings.Split(opts, ",")
+	for i, part := range parts {
+		if strings.HasPrefix(part, "accel=") {
+			parts[i] = "accel=tcg"
+		}
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,96 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCIProfile(t *testing.T) {
+	// A typical CI runner: no nested virtualization, 2 CPUs, 7GB of memory.
+	host := &hostInfo{CI: true, KVM: false, CPUs: 2, Mem: 7 * 1024}
+	cfg := &Config{
+		Count:    4,
+		QemuArgs: "-enable-kvm -cpu host,migratable=off -machine q35,accel=kvm -smp sockets=1",
+		Profile:  "auto",
+	}
+	timeout, err := applyProfile(cfg, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Config{
+		Count:    2,
+		CPU:      1,
+		Mem:      2048,
+		QemuArgs: "-machine q35,accel=tcg -smp sockets=1",
+		Profile:  "auto",
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("got config:\n%+v\nwant:\n%+v", cfg, want)
+	}
+	if timeout != ciBootTimeout {
+		t.Errorf("got boot timeout %v, want %v", timeout, ciBootTimeout)
+	}
+
+	// Very little memory: run fewer smaller VMs.
+	host = &hostInfo{KVM: true, CPUs: 8, Mem: 2048}
+	cfg = &Config{Count: 4, CPU: 2, Mem: 4096, QemuArgs: "-enable-kvm", Profile: "ci"}
+	if _, err := applyProfile(cfg, host); err != nil {
+		t.Fatal(err)
+	}
+	want = &Config{Count: 1, CPU: 2, Mem: 1536, QemuArgs: "-enable-kvm", Profile: "ci"}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("got config:\n%+v\nwant:\n%+v", cfg, want)
+	}
+
+	// Not in CI: auto profile does not change anything.
+	host = &hostInfo{CPUs: 1, Mem: 512}
+	cfg = &Config{Count: 4, CPU: 2, Mem: 4096, QemuArgs: "-enable-kvm", Profile: "auto"}
+	want = &Config{Count: 4, CPU: 2, Mem: 4096, QemuArgs: "-enable-kvm", Profile: "auto"}
+	timeout, err = applyProfile(cfg, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, want) || timeout != defaultBootTimeout {
+		t.Errorf("auto profile changed config outside of CI: %+v, timeout %v", cfg, timeout)
+	}
+
+	if _, err := applyProfile(&Config{Count: 1, Profile: "ci"}, &hostInfo{CPUs: 1, Mem: 100}); err == nil {
+		t.Errorf("ci profile accepted host without memory")
+	}
+	if _, err := applyProfile(&Config{Profile: "fast"}, host); err == nil {
+		t.Errorf("unknown profile is accepted")
+	}
+}
+
+func TestDisableKVM(t *testing.T) {
+	tests := []struct {
+		args    string
+		res     string
+		dropped []string
+	}{
+		{"", "", nil},
+		{"-machine virt", "-machine virt", nil},
+		{"-enable-kvm -cpu host,migratable=off", "", []string{"-enable-kvm", "-cpu", "host,migratable=off"}},
+		{"-accel kvm -cpu max", "-accel tcg -cpu max", []string{"-accel", "kvm"}},
+		{"-M q35,accel=kvm:tcg,smm=off", "-M q35,accel=tcg,smm=off", []string{"-M", "q35,accel=kvm:tcg,smm=off"}},
+	}
+	for _, test := range tests {
+		res, dropped := disableKVM(test.args)
+		if res != test.res || !reflect.DeepEqual(dropped, test.dropped) {
+			t.Errorf("%q: got %q/%q, want %q/%q", test.args, res, dropped, test.res, test.dropped)
+		}
+	}
+}
+
+func TestParseMemAvailable(t *testing.T) {
+	data := []byte("MemTotal:        7110656 kB\nMemFree:          265544 kB\nMemAvailable:    5242880 kB\n")
+	if mem := parseMemAvailable(data); mem != 5120 {
+		t.Errorf("got %vMB, want 5120MB", mem)
+	}
+	if mem := parseMemAvailable([]byte("MemTotal: 1 kB\n")); mem != 0 {
+		t.Errorf("got %vMB without MemAvailable, want 0", mem)
+	}
+}
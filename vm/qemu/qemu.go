@@ -4,8 +4,11 @@
 package qemu
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -13,6 +16,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/syzkaller/pkg/agent"
 	"github.com/google/syzkaller/pkg/config"
 	"github.com/google/syzkaller/pkg/log"
 	"github.com/google/syzkaller/pkg/osutil"
@@ -21,6 +25,8 @@ import (
 
 const (
 	hostAddr = "10.0.2.10"
+	// Where the shared directory is mounted in VMs.
+	sharedMountPoint = "/syz-shared"
 )
 
 func init() {
@@ -28,39 +34,150 @@ func init() {
 }
 
 type Config struct {
-	Count       int    `json:"count"`        // number of VMs to use
-	Qemu        string `json:"qemu"`         // qemu binary name (qemu-system-arch by default)
-	QemuArgs    string `json:"qemu_args"`    // additional command line arguments for qemu binary
-	Kernel      string `json:"kernel"`       // kernel for injected boot (e.g. arch/x86/boot/bzImage)
-	Cmdline     string `json:"cmdline"`      // kernel command line (can only be specified with kernel)
+	Count    int    `json:"count"`     // number of VMs to use
+	Qemu     string `json:"qemu"`      // qemu binary name (qemu-system-arch by default)
+	QemuArgs string `json:"qemu_args"` // additional command line arguments for qemu binary
+	Kernel   string `json:"kernel"`    // kernel for injected boot (e.g. arch/x86/boot/bzImage)
+	Cmdline  string `json:"cmdline"`   // kernel command line (can only be specified with kernel)
+	// Cmdline and QemuArgs are text/template templates that can refer to {{.OS}}, {{.Arch}},
+	// {{.Index}} (VM index), {{.Workdir}} (VM workdir) and {{.Kernel}} (tag of the kernel from kernels).
+	// They can also be overridden for a particular arch with cmdline_<arch> and qemu_args_<arch> fields
+	// (e.g. "cmdline_arm64").
 	Initrd      string `json:"initrd"`       // linux initial ramdisk. (optional)
 	ImageDevice string `json:"image_device"` // qemu image device (hda by default)
 	CPU         int    `json:"cpu"`          // number of VM CPUs
 	Mem         int    `json:"mem"`          // amount of VM memory in MBs
+	// Path to syz-agent binary. If set, VMs are controlled with syz-agent over virtio-serial instead of ssh.
+	// For 9p image the agent is started by init directly, other images must start syz-agent on boot
+	// (see tools/create-image.sh).
+	Agent string `json:"agent"`
+	// TCG plugins to load into qemu (e.g. for custom coverage instrumentation).
+	// Plugins require tcg accelerator, so qemu_args must not enable kvm.
+	// Output of all plugins goes to a per-VM file which path is attached to crash reports.
+	TCGPlugins []TCGPlugin `json:"tcg_plugins"`
+	// Additional block devices (e.g. scratch disks for filesystem fuzzing).
+	// Drives are attached as virtio disks in the order of declaration, so they appear in VM
+	// as /dev/vda, /dev/vdb, etc (if the root image is not virtio) and
+	// as /dev/disk/by-id/virtio-syzdisk0, /dev/disk/by-id/virtio-syzdisk1, etc.
+	Drives []Drive `json:"drives"`
+	// Emulated TPM attached to VMs (requires swtpm), each VM gets own TPM with a fresh state.
+	TPM *TPM `json:"tpm"`
+	// Image is a read-only squashfs or erofs rootfs. It is mounted by a generated initramfs
+	// with a tmpfs overlay on top, so every boot starts from a clean state without per-VM
+	// copies of the image. Requires kernel (with squashfs/erofs, overlayfs, virtio-blk and devtmpfs)
+	// and busybox.
+	RootfsOverlay bool   `json:"rootfs_overlay"`
+	Busybox       string `json:"busybox"` // static busybox binary for rootfs_overlay initramfs
+	// Number of VMs that run under qemu record/replay (-icount rr=record) with tcg.
+	// When such VM crashes, the recording is saved with the crash together with the replay command.
+	// Recording is very slow, so this should be a small fraction of count.
+	// Supported only on x86 pc and q35 machines.
+	RecordReplay int `json:"record_replay"`
+	// Profile adapts the config to the environment:
+	// "ci": use tcg if kvm is not available, fit count/cpu/mem into host resources
+	// (cpu and mem are optional then) and use shorter boot timeout, so that the same
+	// config works on CI runners (e.g. GitHub Actions) without nested virtualization;
+	// "auto": use "ci" if a CI environment is detected (CI, GITHUB_ACTIONS, etc env vars).
+	// What is changed is logged.
+	Profile string `json:"profile"`
+	// Several kernels to run instead of kernel, VMs are assigned to them round-robin (see Kernel).
+	// Crashes are tagged with the kernel the VM runs, which allows differential fuzzing of kernel builds.
+	Kernels []Kernel `json:"kernels"`
+	// Query I/O statistics of block devices over QMP at the end of each run and attach them
+	// to crash reports and the report log (e.g. to correlate block layer bugs with the workload).
+	BlockStats bool `json:"block_stats"`
+	// Max ratio of the total memory/CPUs of all VMs (including mem/cpu overrides in qemu_args)
+	// to the available host memory/CPUs, qemu refuses to start VMs if it's exceeded
+	// (default: 2, negative value disables the check).
+	HostOvercommit float64 `json:"host_overcommit"`
+	// Max total memory of running VMs in MBs (including mem overrides in qemu_args), creation of a VM that
+	// does not fit waits until other VMs are closed, so that VMs created on top of count (e.g. for
+	// reproduction) don't cause host OOM that kills random VMs (default: 0, no limit).
+	HostMemBudget int `json:"host_mem_budget"`
+	// Run each VM in its own host network namespace with a dedicated bridge and tap device
+	// instead of user-mode networking to isolate traffic of VMs (linux only, requires root,
+	// kernel, ip and iptables).
+	NetNS bool `json:"netns"`
+	// How console output is read: "event" (default) reads the serial console from a pipe as it's printed,
+	// "poll" lets qemu write it to a file in the VM workdir and polls the file every console_poll_interval
+	// milliseconds (100 by default), so a slow reader never blocks the VM.
+	ConsoleMode         string `json:"console_mode"`
+	ConsolePollInterval int    `json:"console_poll_interval"`
+	// Mirror the serial console to a host pty (linux only), so that external tools
+	// (e.g. screen or minicom) can attach to it. The pty path is printed in the VM info.
+	ConsolePty bool `json:"console_pty"`
+}
+
+type Drive struct {
+	// Existing disk image. Writes go to a temporary snapshot (unless readonly),
+	// so the image is not changed and can be shared by all VMs.
+	Path   string `json:"path"`
+	Format string `json:"format"` // format of the image at path (qemu guesses by default)
+	// Size in MB of an empty qcow2 image created for each VM and deleted when the VM is closed.
+	Size     int    `json:"size"`
+	Cache    string `json:"cache"` // qemu cache mode (writeback, none, unsafe, etc)
+	ReadOnly bool   `json:"readonly"`
+}
+
+type TCGPlugin struct {
+	Path string   `json:"path"` // plugin shared library
+	Args []string `json:"args"` // plugin arguments in name=value form
 }
 
 type Pool struct {
-	env        *vmimpl.Env
-	cfg        *Config
-	archConfig *archConfig
+	env         *vmimpl.Env
+	cfg         *Config
+	archConfig  *archConfig
+	sharedDir   string // host dir exported to all VMs (if any)
+	pluginDir   string // host dir for tcg plugin output (if any)
+	bootTimeout time.Duration
+	initrd      string     // cfg.Initrd or initramfs generated for rootfs_overlay
+	memBudget   *memBudget // nil if host_mem_budget is not configured
 }
 
 type instance struct {
-	cfg        *Config
-	archConfig *archConfig
-	image      string
-	debug      bool
-	os         string
-	workdir    string
-	sshkey     string
-	sshuser    string
-	port       int
-	rpipe      io.ReadCloser
-	wpipe      io.WriteCloser
-	qemu       *exec.Cmd
-	merger     *vmimpl.OutputMerger
-	files      map[string]string
-	diagnose   chan bool
+	cfg         *Config
+	archConfig  *archConfig
+	image       string
+	debug       bool
+	os          string
+	workdir     string
+	sshkey      string
+	sshuser     string
+	port        int
+	rpipe       io.ReadCloser
+	wpipe       io.WriteCloser
+	qemu        *exec.Cmd
+	qemuDone    chan struct{} // closed when qemu has exited
+	merger      *vmimpl.OutputMerger
+	stopConsole func() // stops console polling (if any)
+	files       map[string]string
+	diagnose    chan bool
+	agent       *agent.Client
+	sharedDir   string
+	sharedOK    bool // sharedDir is mounted in VM
+	readPstore  bool
+	pluginLog   string // output file of tcg plugins (if any)
+	index       int
+	qemuArgs    string   // expanded cfg.QemuArgs
+	cmdline     string   // expanded cfg.Cmdline
+	kernel      string   // cfg.Kernel or the kernel from cfg.Kernels selected for this VM
+	kernelTag   string   // tag of the kernel from cfg.Kernels (if any)
+	bootCmdline string   // full kernel command line (if kernel is specified)
+	drives      []string // files of cfg.Drives
+	created     []string // drive files created for this instance
+	swtpm       *exec.Cmd
+	tpmDir      string // swtpm state dir (if any)
+	initrd      string
+	rrFile      string   // file with recorded execution (if recording)
+	args        []string // qemu args of the current boot
+	bootTimeout time.Duration
+	qmp         *qmpConn // persistent QMP connection (if block_stats is enabled)
+	netns       *netns   // network namespace of the VM (if netns is enabled)
+	sshAddr     string   // where sshd of the VM is reachable from the host
+	memBudget   *memBudget
+	budgetMem   int                // MBs acquired from memBudget
+	consolePty  *vmimpl.ConsolePty // mirror of the serial console (if console_pty is enabled)
 }
 
 type archConfig struct {
@@ -69,6 +186,15 @@ type archConfig struct {
 	TargetDir string
 	NicModel  string
 	CmdLine   []string
+	// Device of the image in the VM (default: /dev/sda). The image is attached with -hda,
+	// which uses the default block interface of the machine (e.g. virtio on s390x).
+	RootDevice string
+	// Transport of virtio devices: "pci" (default) or "ccw" (s390x channel I/O).
+	VirtioBus string
+	// The machine can't boot the image (e.g. s390x needs zipl in the image), kernel is required.
+	NeedKernel bool
+	// Minimal qemu version (major, minor) that runs the arch correctly (zero if any version does).
+	MinQemu [2]int
 	// Weird mode for akaros.
 	// Currently akaros does not have support for building Go binaries.
 	// So we will run Go binaries (but not executor on host).
@@ -117,9 +243,31 @@ var archConfigs = map[string]*archConfig{
 		CmdLine:   linuxCmdline,
 	},
 	"linux/ppc64le": {
-		Qemu:      "qemu-system-ppc64",
+		Qemu: "qemu-system-ppc64",
+		// The spapr capabilities disable Spectre mitigations that tcg does not implement,
+		// otherwise pseries machines (since qemu 3.0) refuse to start without kvm.
+		QemuArgs:  "-machine pseries,cap-cfpc=broken,cap-sbbc=broken,cap-ibs=broken",
 		TargetDir: "/",
-		CmdLine:   linuxCmdline,
+		NicModel:  ",model=virtio-net-pci",
+		// The console is on the spapr vty (-serial), not on an 8250 uart.
+		CmdLine: linuxArchCmdline("hvc0"),
+		// The spapr capabilities appeared in qemu 2.12.
+		MinQemu: [2]int{2, 12},
+	},
+	"linux/s390x": {
+		Qemu:      "qemu-system-s390x",
+		QemuArgs:  "-machine s390-ccw-virtio -cpu max",
+		TargetDir: "/",
+		NicModel:  ",model=virtio-net-ccw",
+		// The console is on sclp (-serial), the image is a virtio-blk-ccw disk.
+		CmdLine:    linuxArchCmdline("ttysclp0"),
+		RootDevice: "/dev/vda",
+		VirtioBus:  "ccw",
+		// The s390-ccw firmware boots only zipl-prepared disks, and netboot needs a tftp setup,
+		// so the kernel is always injected with -kernel.
+		NeedKernel: true,
+		// tcg emulates the vector facility (used by kernels built for z13 and newer) since qemu 4.1.
+		MinQemu: [2]int{4, 1},
 	},
 	"freebsd/amd64": {
 		Qemu:      "qemu-system-x86_64",
@@ -166,6 +314,36 @@ var linuxCmdline = []string{
 	"biosdevname=0",
 }
 
+// linuxArchCmdline returns linuxCmdline for arches that have the console on another device
+// than the 8250 uart and don't support x86-specific options.
+func linuxArchCmdline(console string) []string {
+	cmdline := []string{"console=" + console}
+	for _, arg := range linuxCmdline {
+		if strings.HasPrefix(arg, "console=") || strings.HasPrefix(arg, "earlyprintk=") ||
+			strings.HasPrefix(arg, "vsyscall=") {
+			continue
+		}
+		cmdline = append(cmdline, arg)
+	}
+	return cmdline
+}
+
+// rootDevice returns the device of the image in the VM.
+func (ac *archConfig) rootDevice() string {
+	if ac.RootDevice == "" {
+		return "/dev/sda"
+	}
+	return ac.RootDevice
+}
+
+// virtioDevice returns name of the virtio device (e.g. "virtio-9p") on the transport of the arch.
+func (ac *archConfig) virtioDevice(name string) string {
+	if ac.VirtioBus == "" {
+		return name + "-pci"
+	}
+	return name + "-" + ac.VirtioBus
+}
+
 func ctor(env *vmimpl.Env) (vmimpl.Pool, error) {
 	archConfig := archConfigs[env.OS+"/"+env.Arch]
 	cfg := &Config{
@@ -173,10 +351,29 @@ func ctor(env *vmimpl.Env) (vmimpl.Pool, error) {
 		ImageDevice: "hda",
 		Qemu:        archConfig.Qemu,
 		QemuArgs:    archConfig.QemuArgs,
+		ConsoleMode: vmimpl.ConsoleModeEvent,
+		// A file poll is cheap, so poll often to keep the latency low.
+		ConsolePollInterval: 100,
 	}
-	if err := config.LoadData(env.Config, cfg); err != nil {
+	data, err := applyArchOverrides(env.Config, env.Arch)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.LoadData(data, cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse qemu vm config: %v", err)
 	}
+	host := detectHost()
+	bootTimeout, err := applyProfile(cfg, host)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKernels(cfg); err != nil {
+		return nil, err
+	}
+	// Templates are expanded for each VM, but check them early.
+	if _, _, err := expandConfig(cfg, env.OS, env.Arch, 0, env.Workdir); err != nil {
+		return nil, err
+	}
 	if cfg.Count < 1 || cfg.Count > 128 {
 		return nil, fmt.Errorf("invalid config param count: %v, want [1, 128]", cfg.Count)
 	}
@@ -187,11 +384,17 @@ func ctor(env *vmimpl.Env) (vmimpl.Pool, error) {
 	if _, err := exec.LookPath(cfg.Qemu); err != nil {
 		return nil, err
 	}
+	if err := checkQemuVersion(cfg.Qemu, env.OS+"/"+env.Arch, archConfig); err != nil {
+		return nil, err
+	}
+	if archConfig.NeedKernel && !hasKernel(cfg) {
+		return nil, fmt.Errorf("%v/%v VMs require kernel", env.OS, env.Arch)
+	}
 	if env.Image == "9p" {
 		if env.OS != "linux" {
 			return nil, fmt.Errorf("9p image is supported for linux only")
 		}
-		if cfg.Kernel == "" {
+		if !hasKernel(cfg) {
 			return nil, fmt.Errorf("9p image requires kernel")
 		}
 	} else {
@@ -199,27 +402,149 @@ func ctor(env *vmimpl.Env) (vmimpl.Pool, error) {
 			return nil, fmt.Errorf("image file '%v' does not exist", env.Image)
 		}
 	}
+	fstype, err := checkRootfsOverlay(cfg, env.Image)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRecordReplay(cfg, env.Image, env.Arch); err != nil {
+		return nil, err
+	}
 	if cfg.CPU <= 0 || cfg.CPU > 1024 {
 		return nil, fmt.Errorf("bad qemu cpu: %v, want [1-1024]", cfg.CPU)
 	}
 	if cfg.Mem < 128 || cfg.Mem > 1048576 {
 		return nil, fmt.Errorf("bad qemu mem: %v, want [128-1048576]", cfg.Mem)
 	}
+	if err := vmimpl.CheckConsoleMode(cfg.ConsoleMode); err != nil {
+		return nil, err
+	}
+	if cfg.ConsolePollInterval <= 0 {
+		return nil, fmt.Errorf("bad qemu console_poll_interval: %v, want > 0", cfg.ConsolePollInterval)
+	}
+	var qemuArgs []string
+	for i := 0; i < cfg.Count; i++ {
+		args, _, err := expandConfig(cfg, env.OS, env.Arch, i, env.Workdir)
+		if err != nil {
+			return nil, err
+		}
+		qemuArgs = append(qemuArgs, args)
+	}
+	if err := checkHostResources(cfg, host, qemuArgs); err != nil {
+		return nil, err
+	}
+	if cfg.HostMemBudget < 0 {
+		return nil, fmt.Errorf("bad qemu host_mem_budget: %v, want >= 0", cfg.HostMemBudget)
+	}
+	if cfg.HostMemBudget != 0 && cfg.Mem > cfg.HostMemBudget {
+		return nil, fmt.Errorf("qemu mem %vMB exceeds host_mem_budget %vMB", cfg.Mem, cfg.HostMemBudget)
+	}
+	if cfg.Agent != "" {
+		if archConfig.HostFuzzer {
+			return nil, fmt.Errorf("agent is not supported for %v/%v", env.OS, env.Arch)
+		}
+		if !osutil.IsExist(cfg.Agent) {
+			return nil, fmt.Errorf("agent binary '%v' does not exist", cfg.Agent)
+		}
+	}
+	if err := checkTCGPlugins(cfg); err != nil {
+		return nil, err
+	}
+	if err := checkNetns(cfg, env.OS, archConfig); err != nil {
+		return nil, err
+	}
+	for i := range cfg.TCGPlugins {
+		plugin := &cfg.TCGPlugins[i]
+		if !osutil.IsExist(plugin.Path) {
+			return nil, fmt.Errorf("tcg plugin '%v' does not exist", plugin.Path)
+		}
+		plugin.Path = osutil.Abs(plugin.Path)
+	}
+	if err := checkDrives(cfg.Drives); err != nil {
+		return nil, err
+	}
+	if cfg.TPM != nil {
+		setTPMDefaults(cfg.TPM, env.Arch)
+		if err := checkTPM(cfg.TPM); err != nil {
+			return nil, err
+		}
+	}
+	for i := range cfg.Drives {
+		drive := &cfg.Drives[i]
+		if drive.Path == "" {
+			if _, err := exec.LookPath("qemu-img"); err != nil {
+				return nil, fmt.Errorf("drives with size require qemu-img: %v", err)
+			}
+			continue
+		}
+		if !osutil.IsExist(drive.Path) {
+			return nil, fmt.Errorf("drive image '%v' does not exist", drive.Path)
+		}
+		drive.Path = osutil.Abs(drive.Path)
+	}
 	cfg.Kernel = osutil.Abs(cfg.Kernel)
 	cfg.Initrd = osutil.Abs(cfg.Initrd)
+	cfg.Agent = osutil.Abs(cfg.Agent)
 	pool := &Pool{
-		cfg:        cfg,
-		env:        env,
-		archConfig: archConfig,
+		cfg:         cfg,
+		env:         env,
+		archConfig:  archConfig,
+		bootTimeout: bootTimeout,
+		initrd:      cfg.Initrd,
+	}
+	if cfg.HostMemBudget != 0 {
+		pool.memBudget = newMemBudget(cfg.HostMemBudget)
+	}
+	if cfg.RootfsOverlay {
+		pool.initrd = filepath.Join(env.Workdir, "rootfs-initramfs.cpio")
+		if err := createRootfsInitramfs(pool.initrd, cfg.Busybox, fstype); err != nil {
+			return nil, fmt.Errorf("failed to create rootfs initramfs: %v", err)
+		}
+	}
+	if env.ShareFiles && env.OS == "linux" {
+		pool.sharedDir = filepath.Join(env.Workdir, "shared")
+		if err := osutil.MkdirAll(pool.sharedDir); err != nil {
+			return nil, fmt.Errorf("failed to create shared dir: %v", err)
+		}
+	}
+	if len(cfg.TCGPlugins) != 0 {
+		// Plugin output needs to survive the instance workdir which is removed on Close.
+		pool.pluginDir = filepath.Join(env.Workdir, "tcg-plugins")
+		if err := osutil.MkdirAll(pool.pluginDir); err != nil {
+			return nil, fmt.Errorf("failed to create tcg plugins dir: %v", err)
+		}
 	}
 	return pool, nil
 }
 
+// Share copies hostSrc into the dir that is exported to all VMs over 9p.
+func (pool *Pool) Share(hostSrc string) (string, error) {
+	if pool.sharedDir == "" {
+		return "", fmt.Errorf("file sharing is not supported for %v", pool.env.OS)
+	}
+	base := filepath.Base(hostSrc)
+	if err := osutil.CopyFile(hostSrc, filepath.Join(pool.sharedDir, base)); err != nil {
+		return "", err
+	}
+	return sharedMountPoint + "/" + base, nil
+}
+
 func (pool *Pool) Count() int {
 	return pool.cfg.Count
 }
 
 func (pool *Pool) Create(workdir string, index int) (vmimpl.Instance, error) {
+	return pool.create(workdir, index, "")
+}
+
+// CreateWithCmdline implements vmimpl.CmdlineCreator, it requires kernel (or kernels) in the config.
+func (pool *Pool) CreateWithCmdline(workdir string, index int, cmdline string) (vmimpl.Instance, error) {
+	if pool.cfg.Kernel == "" && len(pool.cfg.Kernels) == 0 {
+		return nil, fmt.Errorf("kernel command line can be changed only if kernel is specified")
+	}
+	return pool.create(workdir, index, cmdline)
+}
+
+func (pool *Pool) create(workdir string, index int, cmdline string) (vmimpl.Instance, error) {
 	sshkey := pool.env.SSHKey
 	sshuser := pool.env.SSHUser
 	if pool.env.Image == "9p" {
@@ -229,14 +554,20 @@ func (pool *Pool) Create(workdir string, index int) (vmimpl.Instance, error) {
 			"-N", "", "-C", "", "-f", sshkey); err != nil {
 			return nil, err
 		}
+		daemon := "/usr/sbin/sshd -e -D"
+		if pool.cfg.Agent != "" {
+			daemon = pool.cfg.Agent
+		}
+		script := strings.Replace(initScript, "{{KEY}}", sshkey, -1)
+		script = strings.Replace(script, "{{DAEMON}}", daemon, -1)
 		initFile := filepath.Join(workdir, "init.sh")
-		if err := osutil.WriteExecFile(initFile, []byte(strings.Replace(initScript, "{{KEY}}", sshkey, -1))); err != nil {
+		if err := osutil.WriteExecFile(initFile, []byte(script)); err != nil {
 			return nil, fmt.Errorf("failed to create init file: %v", err)
 		}
 	}
 
 	for i := 0; ; i++ {
-		inst, err := pool.ctor(workdir, sshkey, sshuser, index)
+		inst, err := pool.ctor(workdir, sshkey, sshuser, index, cmdline)
 		if err == nil {
 			return inst, nil
 		}
@@ -248,17 +579,41 @@ func (pool *Pool) Create(workdir string, index int) (vmimpl.Instance, error) {
 	}
 }
 
-func (pool *Pool) ctor(workdir, sshkey, sshuser string, index int) (vmimpl.Instance, error) {
+func (pool *Pool) ctor(workdir, sshkey, sshuser string, index int, cmdline string) (vmimpl.Instance, error) {
 	inst := &instance{
-		cfg:        pool.cfg,
-		archConfig: pool.archConfig,
-		image:      pool.env.Image,
-		debug:      pool.env.Debug,
-		os:         pool.env.OS,
-		workdir:    workdir,
-		sshkey:     sshkey,
-		sshuser:    sshuser,
-		diagnose:   make(chan bool, 1),
+		cfg:         pool.cfg,
+		archConfig:  pool.archConfig,
+		image:       pool.env.Image,
+		debug:       pool.env.Debug,
+		os:          pool.env.OS,
+		workdir:     workdir,
+		sshkey:      sshkey,
+		sshuser:     sshuser,
+		diagnose:    make(chan bool, 1),
+		sharedDir:   pool.sharedDir,
+		readPstore:  pool.env.ReadPstore && !pool.archConfig.HostFuzzer,
+		index:       index,
+		bootTimeout: pool.bootTimeout,
+		initrd:      pool.initrd,
+		sshAddr:     "localhost",
+	}
+	var err error
+	inst.qemuArgs, inst.cmdline, err = expandConfig(pool.cfg, pool.env.OS, pool.env.Arch, index, workdir)
+	if err != nil {
+		return nil, err
+	}
+	if cmdline != "" {
+		inst.cmdline = strings.TrimSpace(inst.cmdline + " " + cmdline)
+	}
+	inst.kernel, inst.kernelTag = selectKernel(pool.cfg, index)
+	if pool.pluginDir != "" {
+		// The file is overwritten when the VM with the same index is recreated.
+		inst.pluginLog = filepath.Join(pool.pluginDir, fmt.Sprintf("vm-%v.log", index))
+	}
+	if index < pool.cfg.RecordReplay {
+		inst.rrFile = filepath.Join(workdir, "record.rr")
+		// Record/replay works only with tcg.
+		inst.qemuArgs, _ = disableKVM(inst.qemuArgs)
 	}
 	if st, err := os.Stat(inst.image); err != nil && st.Size() == 0 {
 		// Some kernels may not need an image, however caller may still
@@ -266,6 +621,13 @@ func (pool *Pool) ctor(workdir, sshkey, sshuser string, index int) (vmimpl.Insta
 		// assumes that an image is mandatory. So if the image is empty, we ignore it.
 		inst.image = ""
 	}
+	if pool.memBudget != nil {
+		mem, _ := vmResources(pool.cfg, inst.qemuArgs)
+		if err := pool.memBudget.acquire(fmt.Sprintf("vm-%v", index), mem); err != nil {
+			return nil, err
+		}
+		inst.memBudget, inst.budgetMem = pool.memBudget, mem
+	}
 	closeInst := inst
 	defer func() {
 		if closeInst != nil {
@@ -273,11 +635,36 @@ func (pool *Pool) ctor(workdir, sshkey, sshuser string, index int) (vmimpl.Insta
 		}
 	}()
 
-	var err error
+	if pool.cfg.NetNS {
+		inst.netns = newNetns(pool.env.Name, index)
+		if err := inst.netns.setup(); err != nil {
+			return nil, err
+		}
+		inst.sshAddr = inst.netns.nsIP
+	}
+	if err := inst.createDrives(); err != nil {
+		return nil, err
+	}
+	if inst.cfg.TPM != nil {
+		if err := inst.startTPM(); err != nil {
+			return nil, err
+		}
+	}
+
 	inst.rpipe, inst.wpipe, err = osutil.LongPipe()
 	if err != nil {
 		return nil, err
 	}
+	if pool.cfg.ConsoleMode == vmimpl.ConsoleModePoll {
+		// Don't replay output of the previous VM in this workdir.
+		os.Remove(inst.consoleFile())
+		inst.created = append(inst.created, inst.consoleFile())
+	}
+	if pool.cfg.ConsolePty {
+		if inst.consolePty, err = vmimpl.OpenConsolePty(); err != nil {
+			return nil, err
+		}
+	}
 
 	if err := inst.Boot(); err != nil {
 		return nil, err
@@ -288,9 +675,18 @@ func (pool *Pool) ctor(workdir, sshkey, sshuser string, index int) (vmimpl.Insta
 }
 
 func (inst *instance) Close() {
+	if inst.agent != nil {
+		inst.agent.Close()
+	}
+	if inst.qmp != nil {
+		inst.qmp.close()
+	}
 	if inst.qemu != nil {
 		inst.qemu.Process.Kill()
-		inst.qemu.Wait()
+		<-inst.qemuDone
+	}
+	if inst.stopConsole != nil {
+		inst.stopConsole()
 	}
 	if inst.merger != nil {
 		inst.merger.Wait()
@@ -301,6 +697,39 @@ func (inst *instance) Close() {
 	if inst.wpipe != nil {
 		inst.wpipe.Close()
 	}
+	if inst.consolePty != nil {
+		inst.consolePty.Close()
+	}
+	for _, file := range inst.created {
+		os.Remove(file)
+	}
+	if inst.netns != nil {
+		inst.netns.teardown()
+	}
+	inst.stopTPM()
+	if inst.memBudget != nil {
+		inst.memBudget.release(inst.budgetMem)
+		inst.memBudget = nil
+	}
+}
+
+// createDrives creates fresh images for drives with size.
+func (inst *instance) createDrives() error {
+	for i, drive := range inst.cfg.Drives {
+		if drive.Path != "" {
+			inst.drives = append(inst.drives, drive.Path)
+			continue
+		}
+		file := filepath.Join(inst.workdir, fmt.Sprintf("drive%v.qcow2", i))
+		os.Remove(file)
+		if _, err := osutil.RunCmd(time.Minute, "", "qemu-img", "create", "-f", "qcow2",
+			file, fmt.Sprintf("%vM", drive.Size)); err != nil {
+			return fmt.Errorf("failed to create drive: %v", err)
+		}
+		inst.created = append(inst.created, file)
+		inst.drives = append(inst.drives, file)
+	}
+	return nil
 }
 
 func (inst *instance) Boot() error {
@@ -308,53 +737,106 @@ func (inst *instance) Boot() error {
 	args := []string{
 		"-m", strconv.Itoa(inst.cfg.Mem),
 		"-smp", strconv.Itoa(inst.cfg.CPU),
-		"-net", "nic" + inst.archConfig.NicModel,
-		"-net", fmt.Sprintf("user,host=%v,hostfwd=tcp::%v-:22", hostAddr, inst.port),
+	}
+	netUser := fmt.Sprintf("host=%v,hostfwd=tcp::%v-:22", hostAddr, inst.port)
+	if inst.netns != nil {
+		// sshd of the VM is reachable at the namespace end of the veth pair.
+		inst.port = 22
+		args = append(args, netnsArgs(inst.archConfig.NicModel)...)
+	} else if inst.rrFile != "" {
+		args = append(args, rrNetArgs(inst.archConfig.NicModel, netUser)...)
+	} else {
+		args = append(args,
+			"-net", "nic"+inst.archConfig.NicModel,
+			"-net", "user,"+netUser,
+		)
+	}
+	serial := "stdio"
+	if inst.cfg.ConsoleMode == vmimpl.ConsoleModePoll {
+		serial = "file:" + inst.consoleFile()
+	}
+	args = append(args,
 		"-display", "none",
-		"-serial", "stdio",
-		"-no-reboot",
+		"-serial", serial,
+	)
+	if inst.readPstore {
+		// Let the kernel reboot (e.g. on watchdog reset) and allow us to reset it,
+		// guest memory is preserved across resets so that ramoops records survive.
+		args = append(args, "-monitor", fmt.Sprintf("unix:%v,server,nowait", inst.monitorSocket()))
+	} else {
+		args = append(args, "-no-reboot")
 	}
-	if inst.cfg.QemuArgs != "" {
-		args = append(args, strings.Split(inst.cfg.QemuArgs, " ")...)
+	if inst.qemuArgs != "" {
+		args = append(args, strings.Split(inst.qemuArgs, " ")...)
 	}
 	if inst.image == "9p" {
 		args = append(args,
 			"-fsdev", "local,id=fsdev0,path=/,security_model=none,readonly",
-			"-device", "virtio-9p-pci,fsdev=fsdev0,mount_tag=/dev/root",
+			"-device", inst.archConfig.virtioDevice("virtio-9p")+",fsdev=fsdev0,mount_tag=/dev/root",
 		)
+	} else if inst.cfg.RootfsOverlay {
+		args = append(args, rootfsArgs(inst.image)...)
+	} else if inst.rrFile != "" && inst.image != "" {
+		args = append(args, rrImageArgs(inst.image)...)
 	} else if inst.image != "" {
 		args = append(args,
 			"-"+inst.cfg.ImageDevice, inst.image,
 			"-snapshot",
 		)
 	}
-	if inst.cfg.Initrd != "" {
+	if inst.cfg.Agent != "" {
+		args = append(args, agentArgs(inst.agentSocket())...)
+	}
+	if len(inst.drives) != 0 {
+		args = append(args, driveArgs(inst.cfg.Drives, inst.drives)...)
+	}
+	if inst.swtpm != nil {
+		args = append(args, tpmArgs(inst.cfg.TPM, inst.tpmSocket())...)
+	}
+	if inst.cfg.BlockStats {
+		args = append(args, qmpArgs(inst.qmpSocket())...)
+	}
+	if inst.pluginLog != "" {
+		args = append(args, tcgPluginArgs(inst.cfg.TCGPlugins, inst.pluginLog)...)
+	}
+	if inst.sharedDir != "" {
+		args = append(args,
+			"-fsdev", fmt.Sprintf("local,id=syzshared,path=%v,security_model=none,readonly", inst.sharedDir),
+			"-device", inst.archConfig.virtioDevice("virtio-9p")+",fsdev=syzshared,mount_tag=syz-shared",
+		)
+	}
+	if inst.initrd != "" {
 		args = append(args,
-			"-initrd", inst.cfg.Initrd,
+			"-initrd", inst.initrd,
 		)
 	}
-	if inst.cfg.Kernel != "" {
+	if inst.kernel != "" {
 		cmdline := append([]string{}, inst.archConfig.CmdLine...)
-		if inst.image == "9p" {
-			cmdline = append(cmdline,
-				"root=/dev/root",
-				"rootfstype=9p",
-				"rootflags=trans=virtio,version=9p2000.L,cache=loose",
-				"init="+filepath.Join(inst.workdir, "init.sh"),
-			)
-		} else {
-			cmdline = append(cmdline, "root=/dev/sda")
+		cmdline = append(cmdline, rootCmdline(inst.image, inst.workdir, inst.archConfig.rootDevice(),
+			inst.cfg.RootfsOverlay)...)
+		if inst.netns != nil {
+			cmdline = append(cmdline, netnsCmdline())
 		}
-		cmdline = append(cmdline, inst.cfg.Cmdline)
+		cmdline = append(cmdline, inst.cmdline)
+		inst.bootCmdline = strings.Join(cmdline, " ")
+		log.Logf(1, "vm-%v: kernel command line: %v", inst.index, inst.bootCmdline)
 		args = append(args,
-			"-kernel", inst.cfg.Kernel,
-			"-append", strings.Join(cmdline, " "),
+			"-kernel", inst.kernel,
+			"-append", inst.bootCmdline,
 		)
 	}
+	if inst.rrFile != "" {
+		args = append(args, rrArgs(inst.rrFile)...)
+	}
+	inst.args = args
 	if inst.debug {
 		log.Logf(0, "running command: %v %#v", inst.cfg.Qemu, args)
 	}
 	qemu := osutil.Command(inst.cfg.Qemu, args...)
+	if inst.netns != nil {
+		cmd := inst.netns.exec(inst.cfg.Qemu, args...)
+		qemu = osutil.Command(cmd[0], cmd[1:]...)
+	}
 	qemu.Stdout = inst.wpipe
 	qemu.Stderr = inst.wpipe
 	if err := qemu.Start(); err != nil {
@@ -363,6 +845,11 @@ func (inst *instance) Boot() error {
 	inst.wpipe.Close()
 	inst.wpipe = nil
 	inst.qemu = qemu
+	inst.qemuDone = make(chan struct{})
+	go func() {
+		qemu.Wait()
+		close(inst.qemuDone)
+	}()
 	// Qemu has started.
 
 	// Start output merger.
@@ -371,8 +858,25 @@ func (inst *instance) Boot() error {
 		tee = os.Stdout
 	}
 	inst.merger = vmimpl.NewOutputMerger(tee)
+	if inst.consolePty != nil && inst.cfg.ConsoleMode != vmimpl.ConsoleModePoll {
+		inst.rpipe = inst.consolePty.Tee(inst.rpipe)
+	}
 	inst.merger.Add("qemu", inst.rpipe)
 	inst.rpipe = nil
+	if inst.cfg.ConsoleMode == vmimpl.ConsoleModePoll {
+		// Qemu stdout/stderr still go to the pipe, so qemu errors are not lost.
+		src := &vmimpl.FileConsole{File: inst.consoleFile()}
+		interval := time.Duration(inst.cfg.ConsolePollInterval) * time.Millisecond
+		rc, stop, err := vmimpl.PollConsole("qemu console", src, interval, 10)
+		if err != nil {
+			return err
+		}
+		if inst.consolePty != nil {
+			rc = inst.consolePty.Tee(rc)
+		}
+		inst.merger.Add("console", rc)
+		inst.stopConsole = stop
+	}
 
 	var bootOutput []byte
 	bootOutputStop := make(chan bool)
@@ -387,17 +891,356 @@ func (inst *instance) Boot() error {
 			}
 		}
 	}()
-	if err := vmimpl.WaitForSSH(inst.debug, 10*time.Minute, "localhost",
-		inst.sshkey, inst.sshuser, inst.os, inst.port); err != nil {
+	if err := inst.waitForBoot(inst.bootTimeout); err != nil {
 		bootOutputStop <- true
 		<-bootOutputStop
 		return vmimpl.BootError{Title: err.Error(), Output: bootOutput}
 	}
 	bootOutputStop <- true
+	if inst.sharedDir != "" {
+		// The image may lack 9p support, then shared files are copied into the VM as usual.
+		if _, err := inst.runCommand(fmt.Sprintf("mkdir -p %[1]v && "+
+			"mount -t 9p -o ro,trans=virtio,version=9p2000.L syz-shared %[1]v", sharedMountPoint)); err != nil {
+			log.Logf(0, "vm-%v: failed to mount shared dir, copying files instead: %v", inst.index, err)
+		} else {
+			inst.sharedOK = true
+		}
+	}
 	return nil
 }
 
+func (inst *instance) SharedFiles() bool {
+	return inst.sharedOK
+}
+
+// consoleFile returns the file qemu writes serial console output to in the poll console mode.
+func (inst *instance) consoleFile() string {
+	return filepath.Join(inst.workdir, "console.log")
+}
+
+// rootCmdline returns kernel command line args that specify the root filesystem.
+func rootCmdline(image, workdir, rootDevice string, rootfsOverlay bool) []string {
+	switch {
+	case image == "9p":
+		return []string{
+			"root=/dev/root",
+			"rootfstype=9p",
+			"rootflags=trans=virtio,version=9p2000.L,cache=loose",
+			"init=" + filepath.Join(workdir, "init.sh"),
+		}
+	case rootfsOverlay:
+		// The initramfs mounts the rootfs, see rootfsInitScript.
+		return []string{"rdinit=/init"}
+	default:
+		return []string{"root=" + rootDevice}
+	}
+}
+
+// checkTCGPlugins checks that tcg plugins are used with tcg accelerator,
+// qemu silently ignores -plugin with kvm.
+func checkTCGPlugins(cfg *Config) error {
+	if len(cfg.TCGPlugins) == 0 {
+		return nil
+	}
+	for _, plugin := range cfg.TCGPlugins {
+		if plugin.Path == "" {
+			return fmt.Errorf("tcg plugin path is empty")
+		}
+	}
+	args := strings.Fields(cfg.QemuArgs)
+	for i, arg := range args {
+		next := ""
+		if i+1 < len(args) {
+			next = args[i+1]
+		}
+		if !isKVMArg(arg, next) {
+			continue
+		}
+		bad := arg
+		if arg != "-enable-kvm" {
+			bad += " " + next
+		}
+		return fmt.Errorf("tcg_plugins require tcg accelerator, but qemu_args contain %q", bad)
+	}
+	return nil
+}
+
+var driveCacheModes = map[string]bool{
+	"":             true,
+	"writeback":    true,
+	"writethrough": true,
+	"none":         true,
+	"directsync":   true,
+	"unsafe":       true,
+}
+
+func checkDrives(drives []Drive) error {
+	for i, drive := range drives {
+		if (drive.Path == "") == (drive.Size == 0) {
+			return fmt.Errorf("drive #%v: exactly one of path and size must be specified", i)
+		}
+		if drive.Size < 0 {
+			return fmt.Errorf("drive #%v: bad size %v", i, drive.Size)
+		}
+		if drive.Path == "" && (drive.ReadOnly || drive.Format != "") {
+			return fmt.Errorf("drive #%v: readonly and format can't be specified with size", i)
+		}
+		if !driveCacheModes[drive.Cache] {
+			return fmt.Errorf("drive #%v: unknown cache mode %q", i, drive.Cache)
+		}
+	}
+	return nil
+}
+
+// driveArgs returns qemu args for drives backed by files.
+// Drives go in the order of declaration, which makes VM device names stable.
+func driveArgs(drives []Drive, files []string) []string {
+	var args []string
+	for i, drive := range drives {
+		opts := []string{
+			"file=" + files[i],
+			"if=virtio",
+			fmt.Sprintf("serial=syzdisk%v", i),
+		}
+		switch {
+		case drive.Path == "":
+			// The image is created for this instance only, there is nothing to protect.
+			opts = append(opts, "format=qcow2", "snapshot=off")
+		case drive.ReadOnly:
+			opts = append(opts, "readonly=on")
+		default:
+			// Must not change the image even if the root image is not in snapshot mode (e.g. 9p).
+			opts = append(opts, "snapshot=on")
+		}
+		if drive.Format != "" {
+			opts = append(opts, "format="+drive.Format)
+		}
+		if drive.Cache != "" {
+			opts = append(opts, "cache="+drive.Cache)
+		}
+		args = append(args, "-drive", strings.Join(opts, ","))
+	}
+	return args
+}
+
+func tcgPluginArgs(plugins []TCGPlugin, logFile string) []string {
+	args := []string{"-d", "plugin", "-D", logFile}
+	for _, plugin := range plugins {
+		args = append(args, "-plugin", strings.Join(append([]string{plugin.Path}, plugin.Args...), ","))
+	}
+	return args
+}
+
+// Info returns the kernel (with its hash), the kernel command line, qemu args
+// and path of the tcg plugins output file.
+func (inst *instance) Info() ([]byte, error) {
+	info := new(bytes.Buffer)
+	if inst.kernel != "" {
+		hash, err := vmimpl.FileHash(inst.kernel)
+		if err != nil {
+			hash = err.Error()
+		}
+		fmt.Fprintf(info, "kernel: %v (sha1 %v)\n", inst.kernel, hash)
+	}
+	if inst.bootCmdline != "" {
+		fmt.Fprintf(info, "kernel command line: %v\n", inst.bootCmdline)
+	}
+	if len(inst.args) != 0 {
+		fmt.Fprintf(info, "qemu args: %v\n", strings.Join(inst.args, " "))
+	}
+	if inst.pluginLog != "" {
+		fmt.Fprintf(info, "tcg plugins output: %v\n", inst.pluginLog)
+	}
+	if inst.agent == nil && inst.port != 0 {
+		args := append(vmimpl.SSHArgs(false, inst.sshkey, inst.port), inst.sshuser+"@"+inst.sshAddr)
+		fmt.Fprintf(info, "ssh command: ssh %v\n", strings.Join(args, " "))
+	}
+	if inst.consolePty != nil {
+		fmt.Fprintf(info, "console pty: %v\n", inst.consolePty.Path)
+	}
+	return info.Bytes(), nil
+}
+
+// KernelCmdline implements vmimpl.CmdlineReporter.
+func (inst *instance) KernelCmdline() string {
+	return inst.bootCmdline
+}
+
+// Artifacts returns the tcg plugins output file.
+func (inst *instance) Artifacts() []string {
+	if inst.pluginLog == "" {
+		return nil
+	}
+	return []string{inst.pluginLog}
+}
+
+func (inst *instance) waitForBoot(timeout time.Duration) error {
+	if inst.cfg.Agent != "" {
+		return inst.connectAgent(timeout)
+	}
+	return vmimpl.WaitForSSH(inst.debug, timeout, inst.sshAddr,
+		inst.sshkey, inst.sshuser, inst.os, inst.port)
+}
+
+// runCommand runs a short auxiliary command in the VM and returns its output.
+func (inst *instance) runCommand(command string) ([]byte, error) {
+	if inst.agent != nil {
+		output := new(bytes.Buffer)
+		proc, err := inst.agent.Exec(command, output)
+		if err != nil {
+			return nil, err
+		}
+		if err := proc.Wait(); err != nil {
+			return nil, fmt.Errorf("%v\n%s", err, output.Bytes())
+		}
+		return output.Bytes(), nil
+	}
+	args := append(vmimpl.SSHArgs(inst.debug, inst.sshkey, inst.port),
+		inst.sshuser+"@"+inst.sshAddr, command)
+	return osutil.RunCmd(time.Minute, "", "ssh", args...)
+}
+
+func (inst *instance) monitorSocket() string {
+	return filepath.Join(inst.workdir, "monitor.sock")
+}
+
+func (inst *instance) ReadFile(file string) ([]byte, error) {
+	return inst.runCommand("cat " + file)
+}
+
+func (inst *instance) Exec(command string, timeout time.Duration) ([]byte, []byte, int, error) {
+	if inst.agent != nil {
+		return nil, nil, 0, fmt.Errorf("exec is not supported with the guest agent")
+	}
+	return vmimpl.SSHExec(inst.debug, timeout, inst.sshAddr, inst.sshkey, inst.sshuser, inst.port, command)
+}
+
+// Probe makes the kernel print backtraces of all active CPUs (sysrq-l): via the qemu monitor
+// if it's enabled (works even if the guest network is dead), or over ssh otherwise.
+func (inst *instance) Probe() bool {
+	if inst.os != "linux" {
+		return false
+	}
+	if inst.readPstore {
+		return inst.monitorCommand("sendkey alt-sysrq-l") == nil
+	}
+	if inst.agent != nil {
+		return false
+	}
+	return vmimpl.ProbeSysrq(inst)
+}
+
+// ReadPstore resets the VM (unless the kernel has already rebooted, e.g. by a watchdog)
+// and returns pstore records left by the crashed kernel.
+func (inst *instance) ReadPstore() ([]byte, error) {
+	if !inst.readPstore {
+		return nil, fmt.Errorf("VM is started without pstore support")
+	}
+	stop := inst.discardOutput()
+	defer close(stop)
+	if err := inst.monitorCommand("system_reset"); err != nil {
+		return nil, err
+	}
+	if inst.agent != nil {
+		inst.agent.Close()
+		inst.agent = nil
+	}
+	if err := inst.waitForBoot(5 * time.Minute); err != nil {
+		return nil, fmt.Errorf("VM did not come back after reset: %v", err)
+	}
+	return inst.runCommand(vmimpl.PstoreCommand)
+}
+
+// discardOutput consumes console output until the returned channel is closed.
+// It's used when nobody reads the output anymore, but the merger blocks if the output is not consumed.
+func (inst *instance) discardOutput() chan bool {
+	stop := make(chan bool)
+	go func() {
+		for {
+			select {
+			case _, ok := <-inst.merger.Output:
+				if !ok {
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return stop
+}
+
+// monitorCommand executes a command in the qemu human monitor.
+func (inst *instance) monitorCommand(command string) error {
+	conn, err := net.DialTimeout("unix", inst.monitorSocket(), time.Minute)
+	if err != nil {
+		return fmt.Errorf("failed to connect to qemu monitor: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(command + "\n")); err != nil {
+		return fmt.Errorf("failed to send qemu monitor command: %v", err)
+	}
+	// Wait for the command echo, closing the connection right away can drop the command.
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var output []byte
+	buf := make([]byte, 1024)
+	for !bytes.Contains(output, []byte(command)) {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return fmt.Errorf("qemu monitor did not respond: %v", err)
+		}
+		output = append(output, buf[:n]...)
+	}
+	return nil
+}
+
+// agentArgs returns qemu arguments that expose a virtio-serial port named syz-agent
+// to the guest and connect it to the unix socket sock on the host.
+func agentArgs(sock string) []string {
+	return []string{
+		"-device", "virtio-serial",
+		"-chardev", fmt.Sprintf("socket,id=syzagent,path=%v,server,nowait", sock),
+		"-device", "virtserialport,chardev=syzagent,name=syz-agent",
+	}
+}
+
+func (inst *instance) agentSocket() string {
+	return filepath.Join(inst.workdir, "agent.sock")
+}
+
+func (inst *instance) connectAgent(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	var conn net.Conn
+	var err error
+	for time.Now().Before(deadline) {
+		if conn, err = net.Dial("unix", inst.agentSocket()); err == nil {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	if err != nil {
+		return fmt.Errorf("can't connect to agent socket: %v", err)
+	}
+	// Requests are queued in the port until the agent opens it,
+	// so we just keep pinging until the agent starts.
+	client := agent.NewClient(conn)
+	for time.Now().Before(deadline) {
+		if err = client.Ping(10 * time.Second); err == nil {
+			inst.agent = client
+			return nil
+		}
+		if inst.debug {
+			log.Logf(0, "agent is not up yet: %v", err)
+		}
+	}
+	client.Close()
+	return fmt.Errorf("can't connect to agent: %v", err)
+}
+
 func (inst *instance) Forward(port int) (string, error) {
+	if inst.netns != nil {
+		return fmt.Sprintf("%v:%v", inst.netns.hostIP, port), nil
+	}
 	addr := hostAddr
 	if inst.archConfig.HostFuzzer {
 		addr = "127.0.0.1"
@@ -424,21 +1267,34 @@ func (inst *instance) Copy(hostSrc string) (string, error) {
 		}
 		inst.files[vmDst] = hostSrc
 	}
+	if inst.agent != nil {
+		data, err := ioutil.ReadFile(hostSrc)
+		if err != nil {
+			return "", err
+		}
+		if err := inst.agent.Push(vmDst, data); err != nil {
+			return "", fmt.Errorf("failed to copy %v: %v", hostSrc, err)
+		}
+		return vmDst, nil
+	}
 
 	args := append(vmimpl.SCPArgs(inst.debug, inst.sshkey, inst.port),
-		hostSrc, inst.sshuser+"@localhost:"+vmDst)
+		hostSrc, inst.sshuser+"@"+inst.sshAddr+":"+vmDst)
 	if inst.debug {
 		log.Logf(0, "running command: scp %#v", args)
 	}
 	_, err := osutil.RunCmd(3*time.Minute, "", "scp", args...)
 	if err != nil {
-		return "", err
+		return "", vmimpl.ClassifyCopyError(err)
 	}
 	return vmDst, nil
 }
 
 func (inst *instance) Run(timeout time.Duration, stop <-chan bool, command string) (
 	<-chan []byte, <-chan error, error) {
+	if inst.agent != nil {
+		return inst.runAgent(timeout, stop, command)
+	}
 	rpipe, wpipe, err := osutil.LongPipe()
 	if err != nil {
 		return nil, nil, err
@@ -455,7 +1311,7 @@ func (inst *instance) Run(timeout time.Duration, stop <-chan bool, command strin
 		for i, arg := range args {
 			if strings.HasPrefix(arg, "-executor=") {
 				args[i] = "-executor=" + "/usr/bin/ssh " + strings.Join(sshArgs, " ") +
-					" " + inst.sshuser + "@localhost " + arg[len("-executor="):]
+					" " + inst.sshuser + "@" + inst.sshAddr + " " + arg[len("-executor="):]
 			}
 			if host := inst.files[arg]; host != "" {
 				args[i] = host
@@ -464,7 +1320,7 @@ func (inst *instance) Run(timeout time.Duration, stop <-chan bool, command strin
 	} else {
 		args = []string{"ssh"}
 		args = append(args, sshArgs...)
-		args = append(args, inst.sshuser+"@localhost", "cd "+inst.targetDir()+" && "+command)
+		args = append(args, inst.sshuser+"@"+inst.sshAddr, "cd "+inst.targetDir()+" && "+command)
 	}
 	if inst.debug {
 		log.Logf(0, "running command: %#v", args)
@@ -502,6 +1358,8 @@ func (inst *instance) Run(timeout time.Duration, stop <-chan bool, command strin
 				// If the command exited successfully, we got EOF error from merger.
 				// But in this case no error has happened and the EOF is expected.
 				err = nil
+			} else if infraErr := inst.hostKilled(); infraErr != nil {
+				err = infraErr
 			}
 			signal(err)
 			return
@@ -512,6 +1370,58 @@ func (inst *instance) Run(timeout time.Duration, stop <-chan bool, command strin
 	return inst.merger.Output, errc, nil
 }
 
+func (inst *instance) runAgent(timeout time.Duration, stop <-chan bool, command string) (
+	<-chan []byte, <-chan error, error) {
+	rpipe, wpipe, err := osutil.LongPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	inst.merger.Add("agent", rpipe)
+	proc, err := inst.agent.Exec("cd "+inst.targetDir()+" && "+command, wpipe)
+	if err != nil {
+		wpipe.Close()
+		return nil, nil, err
+	}
+	go func() {
+		proc.Wait()
+		wpipe.Close()
+	}()
+	errc := make(chan error, 1)
+	signal := func(err error) {
+		select {
+		case errc <- err:
+		default:
+		}
+	}
+
+	go func() {
+	retry:
+		select {
+		case <-time.After(timeout):
+			signal(vmimpl.ErrTimeout)
+		case <-stop:
+			signal(vmimpl.ErrTimeout)
+		case <-inst.diagnose:
+			proc.Kill()
+			goto retry
+		case err := <-inst.merger.Err:
+			proc.Kill()
+			if cmdErr := proc.Wait(); cmdErr == nil {
+				// If the command exited successfully, we got EOF error from merger.
+				// But in this case no error has happened and the EOF is expected.
+				err = nil
+			} else if infraErr := inst.hostKilled(); infraErr != nil {
+				err = infraErr
+			}
+			signal(err)
+			return
+		}
+		proc.Kill()
+		proc.Wait()
+	}()
+	return inst.merger.Output, errc, nil
+}
+
 func (inst *instance) Diagnose() bool {
 	select {
 	case inst.diagnose <- true:
@@ -566,6 +1476,6 @@ cat > /etc/ssh/sshd_config <<EOF
           RSAAuthentication yes
           PubkeyAuthentication yes
 EOF
-/usr/sbin/sshd -e -D
+{{DAEMON}}
 /sbin/halt -f
 `
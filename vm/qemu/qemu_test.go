@@ -0,0 +1,177 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/agent"
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+func TestAgentArgs(t *testing.T) {
+	args := strings.Join(agentArgs("/workdir/agent.sock"), " ")
+	for _, want := range []string{
+		"-device virtio-serial",
+		"socket,id=syzagent,path=/workdir/agent.sock,server,nowait",
+		"virtserialport,chardev=syzagent,name=syz-agent",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("qemu args %q don't contain %q", args, want)
+		}
+	}
+}
+
+func TestAgentInstance(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("agent exec requires /bin/sh")
+	}
+	dir, err := ioutil.TempDir("", "syz-qemu-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hostConn, guestConn := net.Pipe()
+	go agent.Serve(guestConn)
+	inst := &instance{
+		cfg:        &Config{Agent: "syz-agent"},
+		archConfig: &archConfig{TargetDir: filepath.Join(dir, "target")},
+		workdir:    dir,
+		merger:     vmimpl.NewOutputMerger(nil),
+		diagnose:   make(chan bool, 1),
+		agent:      agent.NewClient(hostConn),
+	}
+	defer inst.Close()
+	if err := inst.agent.Ping(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(inst.archConfig.TargetDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	hostFile := filepath.Join(dir, "syz-test")
+	if err := ioutil.WriteFile(hostFile, []byte("#!/bin/sh\necho running in $PWD\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	vmFile, err := inst.Copy(hostFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(inst.archConfig.TargetDir, "syz-test"); vmFile != want {
+		t.Fatalf("copied to %v, want %v", vmFile, want)
+	}
+	outc, errc, err := inst.Run(time.Minute, nil, vmFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := new(bytes.Buffer)
+	if err := <-errc; err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	for len(outc) != 0 {
+		output.Write(<-outc)
+	}
+	if want := "running in " + inst.archConfig.TargetDir; !strings.Contains(output.String(), want) {
+		t.Fatalf("output %q does not contain %q", output.String(), want)
+	}
+}
+
+func TestTCGPluginArgs(t *testing.T) {
+	plugins := []TCGPlugin{
+		{Path: "/plugins/libcov.so", Args: []string{"mode=bb", "inline=on"}},
+		{Path: "/plugins/libtaint.so"},
+	}
+	got := strings.Join(tcgPluginArgs(plugins, "/workdir/vm-0.log"), " ")
+	want := "-d plugin -D /workdir/vm-0.log " +
+		"-plugin /plugins/libcov.so,mode=bb,inline=on -plugin /plugins/libtaint.so"
+	if got != want {
+		t.Fatalf("got args:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestCheckTCGPlugins(t *testing.T) {
+	plugins := []TCGPlugin{{Path: "/plugins/libcov.so"}}
+	tests := []struct {
+		args    string
+		plugins []TCGPlugin
+		ok      bool
+	}{
+		{"-enable-kvm -cpu host,migratable=off", nil, true},
+		{"", plugins, true},
+		{"-machine virt -cpu cortex-a57", plugins, true},
+		{"-accel tcg,thread=multi", plugins, true},
+		{"-machine q35,accel=tcg", plugins, true},
+		{"-enable-kvm", plugins, false},
+		{"-accel kvm", plugins, false},
+		{"-machine q35,accel=kvm", plugins, false},
+		{"-cpu host,migratable=off", plugins, false},
+		{"", []TCGPlugin{{Args: []string{"mode=bb"}}}, false},
+	}
+	for i, test := range tests {
+		err := checkTCGPlugins(&Config{QemuArgs: test.args, TCGPlugins: test.plugins})
+		if test.ok != (err == nil) {
+			t.Errorf("test #%v: args %q: got error %v, want ok %v", i, test.args, err, test.ok)
+		}
+	}
+}
+
+func TestDriveArgs(t *testing.T) {
+	drives := []Drive{
+		{Size: 64, Cache: "unsafe"},
+		{Path: "/images/fs.img", Format: "raw"},
+		{Path: "/images/data.img", ReadOnly: true},
+	}
+	files := []string{"/workdir/drive0.qcow2", "/images/fs.img", "/images/data.img"}
+	got := strings.Join(driveArgs(drives, files), " ")
+	want := "-drive file=/workdir/drive0.qcow2,if=virtio,serial=syzdisk0,format=qcow2,snapshot=off,cache=unsafe " +
+		"-drive file=/images/fs.img,if=virtio,serial=syzdisk1,snapshot=on,format=raw " +
+		"-drive file=/images/data.img,if=virtio,serial=syzdisk2,readonly=on"
+	if got != want {
+		t.Fatalf("got args:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestCheckDrives(t *testing.T) {
+	tests := []struct {
+		drive Drive
+		ok    bool
+	}{
+		{Drive{Size: 64}, true},
+		{Drive{Path: "fs.img", Cache: "none", ReadOnly: true}, true},
+		{Drive{}, false},
+		{Drive{Path: "fs.img", Size: 64}, false},
+		{Drive{Size: -1}, false},
+		{Drive{Size: 64, ReadOnly: true}, false},
+		{Drive{Path: "fs.img", Cache: "fast"}, false},
+	}
+	for i, test := range tests {
+		err := checkDrives([]Drive{test.drive})
+		if test.ok != (err == nil) {
+			t.Errorf("test #%v: got error %v, want ok %v", i, err, test.ok)
+		}
+	}
+}
+
+func TestConsolePtyInfo(t *testing.T) {
+	pty, err := vmimpl.OpenConsolePty()
+	if err != nil {
+		t.Skipf("can't create pty: %v", err)
+	}
+	defer pty.Close()
+	inst := &instance{cfg: &Config{ConsolePty: true}, consolePty: pty}
+	info, err := inst.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(info), "console pty: "+pty.Path+"\n") {
+		t.Fatalf("console pty is not published: %q", info)
+	}
+}
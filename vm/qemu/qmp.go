@@ -0,0 +1,158 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+// QMP (qemu machine protocol) is used to query block device statistics (block_stats config).
+// The connection is established on the first query and kept open while qemu runs.
+
+const qmpTimeout = 10 * time.Second
+
+type qmpConn struct {
+	conn net.Conn
+	dec  *json.Decoder
+}
+
+type qmpResponse struct {
+	Return json.RawMessage `json:"return"`
+	Error  *struct {
+		Class string `json:"class"`
+		Desc  string `json:"desc"`
+	} `json:"error"`
+	Event string `json:"event"`
+}
+
+func qmpArgs(sock string) []string {
+	return []string{"-qmp", fmt.Sprintf("unix:%v,server,nowait", sock)}
+}
+
+func (inst *instance) qmpSocket() string {
+	return filepath.Join(inst.workdir, "qmp.sock")
+}
+
+// newQMPConn performs the QMP handshake on conn (reads the greeting and enables commands).
+func newQMPConn(conn net.Conn) (*qmpConn, error) {
+	qmp := &qmpConn{
+		conn: conn,
+		dec:  json.NewDecoder(conn),
+	}
+	conn.SetDeadline(time.Now().Add(qmpTimeout))
+	var greeting struct {
+		QMP json.RawMessage `json:"QMP"`
+	}
+	if err := qmp.dec.Decode(&greeting); err != nil || greeting.QMP == nil {
+		return nil, fmt.Errorf("bad qmp greeting: %v", err)
+	}
+	if err := qmp.execute("qmp_capabilities", nil); err != nil {
+		return nil, err
+	}
+	return qmp, nil
+}
+
+// execute executes the QMP command and unmarshals the result into res (if not nil).
+// Asynchronous events received while waiting for the result are ignored.
+func (qmp *qmpConn) execute(command string, res interface{}) error {
+	qmp.conn.SetDeadline(time.Now().Add(qmpTimeout))
+	data, err := json.Marshal(map[string]string{"execute": command})
+	if err != nil {
+		return err
+	}
+	if _, err := qmp.conn.Write(data); err != nil {
+		return fmt.Errorf("failed to send qmp command: %v", err)
+	}
+	for {
+		resp := new(qmpResponse)
+		if err := qmp.dec.Decode(resp); err != nil {
+			return fmt.Errorf("failed to read qmp response: %v", err)
+		}
+		if resp.Event != "" {
+			continue
+		}
+		if resp.Error != nil {
+			return fmt.Errorf("qmp command %v failed: %v: %v", command, resp.Error.Class, resp.Error.Desc)
+		}
+		if res == nil {
+			return nil
+		}
+		return json.Unmarshal(resp.Return, res)
+	}
+}
+
+func (qmp *qmpConn) close() {
+	qmp.conn.Close()
+}
+
+// BlockStats returns I/O statistics of the VM block devices (nil if block_stats is not enabled).
+func (inst *instance) BlockStats() ([]vmimpl.BlockStats, error) {
+	if !inst.cfg.BlockStats || inst.qemu == nil {
+		return nil, nil
+	}
+	if inst.qmp == nil {
+		conn, err := net.DialTimeout("unix", inst.qmpSocket(), qmpTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to qmp: %v", err)
+		}
+		if inst.qmp, err = newQMPConn(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	var res []qmpBlockStats
+	if err := inst.qmp.execute("query-blockstats", &res); err != nil {
+		// Reconnect on the next query.
+		inst.qmp.close()
+		inst.qmp = nil
+		return nil, err
+	}
+	return convertBlockStats(res), nil
+}
+
+// qmpBlockStats is an element of query-blockstats result.
+type qmpBlockStats struct {
+	Device   string `json:"device"`
+	NodeName string `json:"node-name"`
+	Qdev     string `json:"qdev"`
+	Stats    struct {
+		ReadOps         uint64 `json:"rd_operations"`
+		ReadBytes       uint64 `json:"rd_bytes"`
+		WriteOps        uint64 `json:"wr_operations"`
+		WriteBytes      uint64 `json:"wr_bytes"`
+		FailedReadOps   uint64 `json:"failed_rd_operations"`
+		FailedWriteOps  uint64 `json:"failed_wr_operations"`
+		InvalidReadOps  uint64 `json:"invalid_rd_operations"`
+		InvalidWriteOps uint64 `json:"invalid_wr_operations"`
+	} `json:"stats"`
+}
+
+func convertBlockStats(res []qmpBlockStats) []vmimpl.BlockStats {
+	var stats []vmimpl.BlockStats
+	for _, dev := range res {
+		name := dev.Device
+		if name == "" {
+			name = dev.Qdev
+		}
+		if name == "" {
+			name = dev.NodeName
+		}
+		st := dev.Stats
+		stats = append(stats, vmimpl.BlockStats{
+			Device:     name,
+			ReadOps:    st.ReadOps,
+			ReadBytes:  st.ReadBytes,
+			WriteOps:   st.WriteOps,
+			WriteBytes: st.WriteBytes,
+			Errors:     st.FailedReadOps + st.FailedWriteOps + st.InvalidReadOps + st.InvalidWriteOps,
+		})
+	}
+	return stats
+}
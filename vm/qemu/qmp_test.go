@@ -0,0 +1,73 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+const cannedBlockStats = `{"return": [
+	{"device": "ide0-hd0", "node-name": "#block152", "qdev": "/machine/unattached/device[23]",
+		"stats": {"rd_operations": 1520, "rd_bytes": 41313280, "wr_operations": 210, "wr_bytes": 1208320,
+			"flush_operations": 12, "failed_rd_operations": 1, "failed_wr_operations": 0,
+			"invalid_rd_operations": 0, "invalid_wr_operations": 2, "rd_total_time_ns": 123456}},
+	{"device": "", "node-name": "drive0", "qdev": "/machine/peripheral/syzdisk0/virtio-backend",
+		"stats": {"rd_operations": 3, "rd_bytes": 12288, "wr_operations": 0, "wr_bytes": 0}}
+]}`
+
+func TestQMPBlockStats(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		dec := json.NewDecoder(r)
+		fmt.Fprintf(server, `{"QMP": {"version": {"qemu": {"micro": 0, "minor": 12, "major": 2}}, "capabilities": []}}`+"\n")
+		for {
+			var cmd struct {
+				Execute string `json:"execute"`
+			}
+			if err := dec.Decode(&cmd); err != nil {
+				return
+			}
+			switch cmd.Execute {
+			case "qmp_capabilities":
+				fmt.Fprintf(server, `{"return": {}}`+"\n")
+			case "query-blockstats":
+				fmt.Fprintf(server, `{"event": "RTC_CHANGE", "data": {"offset": 0}}`+"\n")
+				fmt.Fprintf(server, "%v\n", cannedBlockStats)
+			default:
+				fmt.Fprintf(server, `{"error": {"class": "CommandNotFound", "desc": "The command %v has not been found"}}`+"\n",
+					cmd.Execute)
+			}
+		}
+	}()
+	qmp, err := newQMPConn(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res []qmpBlockStats
+	if err := qmp.execute("query-blockstats", &res); err != nil {
+		t.Fatal(err)
+	}
+	want := []vmimpl.BlockStats{
+		{Device: "ide0-hd0", ReadOps: 1520, ReadBytes: 41313280, WriteOps: 210, WriteBytes: 1208320, Errors: 3},
+		{Device: "/machine/peripheral/syzdisk0/virtio-backend", ReadOps: 3, ReadBytes: 12288},
+	}
+	if got := convertBlockStats(res); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got stats:\n%+v\nwant:\n%+v", got, want)
+	}
+	err = qmp.execute("foo", nil)
+	if want := "qmp command foo failed: CommandNotFound: The command foo has not been found"; err == nil ||
+		err.Error() != want {
+		t.Fatalf("got error %v, want %v", err, want)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Record/replay runs VMs under qemu record/replay (icount rr), so that a crashing execution
+// can be replayed deterministically (e.g. to debug a race under gdb).
+// Recording requires tcg with instruction counting and is very slow,
+// so only the first cfg.RecordReplay VMs record their execution.
+
+// rrMachines are machine types (and prefixes of versioned machine types) that support
+// record/replay with the devices we use (ide disk with blkreplay, e1000 with filter-replay).
+var rrMachines = []string{"pc", "q35", "pc-i440fx-", "pc-q35-"}
+
+func checkRecordReplay(cfg *Config, image, arch string) error {
+	if cfg.RecordReplay == 0 {
+		return nil
+	}
+	if cfg.RecordReplay < 0 || cfg.RecordReplay > cfg.Count {
+		return fmt.Errorf("bad qemu record_replay: %v, want [0-%v]", cfg.RecordReplay, cfg.Count)
+	}
+	machine := qemuMachine(cfg.QemuArgs)
+	if machine == "" && (arch == "amd64" || arch == "386") {
+		machine = "pc"
+	}
+	if !rrMachineSupported(machine) {
+		return fmt.Errorf("record_replay is not supported on machine type %q (supported: pc, q35)", machine)
+	}
+	if image == "9p" || cfg.RootfsOverlay || len(cfg.Drives) != 0 || cfg.TPM != nil || cfg.Agent != "" {
+		return fmt.Errorf("record_replay can't be used with 9p image, rootfs_overlay, drives, tpm or agent")
+	}
+	if cfg.ImageDevice != "hda" {
+		return fmt.Errorf("record_replay requires image_device hda, have %v", cfg.ImageDevice)
+	}
+	return nil
+}
+
+func rrMachineSupported(machine string) bool {
+	for _, m := range rrMachines {
+		if machine == m || strings.HasSuffix(m, "-") && strings.HasPrefix(machine, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// qemuMachine returns machine type specified in qemu args, or "" if it's not specified.
+func qemuMachine(qemuArgs string) string {
+	args := strings.Fields(qemuArgs)
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] != "-machine" && args[i] != "-M" {
+			continue
+		}
+		for j, opt := range strings.Split(args[i+1], ",") {
+			if strings.HasPrefix(opt, "type=") {
+				return strings.TrimPrefix(opt, "type=")
+			}
+			if j == 0 && !strings.Contains(opt, "=") {
+				return opt
+			}
+		}
+	}
+	return ""
+}
+
+// rrArgs returns qemu args that record execution into file.
+func rrArgs(file string) []string {
+	return []string{"-icount", "shift=auto,rr=record,rrfile=" + file}
+}
+
+// rrImageArgs returns qemu args that attach image, block devices are replayed only via blkreplay.
+func rrImageArgs(image string) []string {
+	return []string{
+		"-drive", fmt.Sprintf("file=%v,if=none,snapshot=on,id=img-direct", image),
+		"-drive", "driver=blkreplay,if=none,image=img-direct,id=img-blkreplay",
+		"-device", "ide-hd,drive=img-blkreplay",
+	}
+}
+
+// rrNetArgs returns qemu network args, network traffic is recorded only via filter-replay.
+func rrNetArgs(nicModel, user string) []string {
+	nic := strings.TrimPrefix(nicModel, ",model=")
+	if nic == "" {
+		nic = "e1000"
+	}
+	return []string{
+		"-netdev", "user,id=net0," + user,
+		"-device", nic + ",netdev=net0",
+		"-object", "filter-replay,id=replay,netdev=net0",
+	}
+}
+
+// replayCommand returns the command that replays execution recorded by qemu started with args.
+func replayCommand(qemu string, args []string) string {
+	cmd := []string{shellQuote(qemu)}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case (arg == "-monitor" || arg == "-qmp") && i+1 < len(args):
+			// The monitor sockets are in the VM workdir that does not exist anymore.
+			i++
+			continue
+		case arg == "-icount" && i+1 < len(args):
+			i++
+			cmd = append(cmd, arg, shellQuote(strings.Replace(args[i], "rr=record", "rr=replay", 1)))
+			continue
+		}
+		cmd = append(cmd, shellQuote(arg))
+	}
+	return strings.Join(cmd, " ")
+}
+
+var shellSafeRe = regexp.MustCompile(`^[a-zA-Z0-9_./:,=+@%-]+$`)
+
+func shellQuote(arg string) string {
+	if shellSafeRe.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+// Recording stops qemu and returns the recorded execution.
+func (inst *instance) Recording() (string, string, error) {
+	if inst.rrFile == "" || inst.qemu == nil {
+		return "", "", nil
+	}
+	stop := inst.discardOutput()
+	defer close(stop)
+	// Qemu finalizes the recording only on graceful shutdown.
+	inst.qemu.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-inst.qemuDone:
+	case <-time.After(time.Minute):
+		inst.qemu.Process.Kill()
+		<-inst.qemuDone
+		inst.qemu = nil
+		return "", "", fmt.Errorf("qemu did not exit on SIGTERM, the recording is incomplete")
+	}
+	inst.qemu = nil
+	return inst.rrFile, replayCommand(inst.cfg.Qemu, inst.args), nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckRecordReplay(t *testing.T) {
+	tests := []struct {
+		cfg  Config
+		arch string
+		err  string
+	}{
+		{Config{Count: 4}, "arm64", ""},
+		{Config{Count: 4, RecordReplay: 1, ImageDevice: "hda"}, "amd64", ""},
+		{Config{Count: 4, RecordReplay: 4, ImageDevice: "hda", QemuArgs: "-machine q35,accel=kvm"}, "amd64", ""},
+		{Config{Count: 4, RecordReplay: 1, ImageDevice: "hda", QemuArgs: "-M type=pc-q35-2.12"}, "amd64", ""},
+		{Config{Count: 4, RecordReplay: 5, ImageDevice: "hda"}, "amd64", "bad qemu record_replay"},
+		{Config{Count: 4, RecordReplay: -1, ImageDevice: "hda"}, "amd64", "bad qemu record_replay"},
+		{Config{Count: 4, RecordReplay: 1, ImageDevice: "hda", QemuArgs: "-machine virt"}, "arm64",
+			`not supported on machine type "virt"`},
+		{Config{Count: 4, RecordReplay: 1, ImageDevice: "hda"}, "ppc64le",
+			`not supported on machine type ""`},
+		{Config{Count: 4, RecordReplay: 1, ImageDevice: "hda", QemuArgs: "-machine microvm"}, "amd64",
+			`not supported on machine type "microvm"`},
+		{Config{Count: 4, RecordReplay: 1, ImageDevice: "hda", Drives: []Drive{{Size: 10}}}, "amd64",
+			"can't be used with"},
+		{Config{Count: 4, RecordReplay: 1, ImageDevice: "sda"}, "amd64", "requires image_device hda"},
+	}
+	for i, test := range tests {
+		err := checkRecordReplay(&test.cfg, "/image", test.arch)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("#%v: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("#%v: got error %v, want %q", i, err, test.err)
+		}
+	}
+}
+
+func TestReplayCommand(t *testing.T) {
+	args := []string{"-m", "2048"}
+	args = append(args, rrNetArgs(",model=e1000", "host=10.0.2.10,hostfwd=tcp::1234-:22")...)
+	args = append(args, "-monitor", "unix:/workdir/monitor.sock,server,nowait")
+	args = append(args, rrImageArgs("/image")...)
+	args = append(args, "-append", "console=ttyS0 root=/dev/sda")
+	args = append(args, rrArgs("/workdir/record.rr")...)
+	want := "qemu-system-x86_64 -m 2048 " +
+		"-netdev user,id=net0,host=10.0.2.10,hostfwd=tcp::1234-:22 -device e1000,netdev=net0 " +
+		"-object filter-replay,id=replay,netdev=net0 " +
+		"-drive file=/image,if=none,snapshot=on,id=img-direct " +
+		"-drive driver=blkreplay,if=none,image=img-direct,id=img-blkreplay -device ide-hd,drive=img-blkreplay " +
+		"-append 'console=ttyS0 root=/dev/sda' " +
+		"-icount shift=auto,rr=replay,rrfile=/workdir/record.rr"
+	if got := replayCommand("qemu-system-x86_64", args); got != want {
+		t.Errorf("bad replay command:\n%v\nwant:\n%v", got, want)
+	}
+	if got, want := shellQuote("it's"), `'it'\''s'`; got != want {
+		t.Errorf("bad quoting: got %v, want %v", got, want)
+	}
+}
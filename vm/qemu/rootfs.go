@@ -0,0 +1,154 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/google/syzkaller/pkg/osutil"
+)
+
+// Read-only compressed rootfs images are attached as the first virtio disk and mounted by
+// the initramfs with a tmpfs overlay on top, so every boot starts from a clean state
+// and VMs don't need per-instance copies of the image.
+
+const rootfsDevice = "/dev/vda"
+
+// rootfsType detects type of a read-only rootfs image by its magic.
+func rootfsType(image string) (string, error) {
+	f, err := os.Open(image)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	header := make([]byte, 1028)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return "", fmt.Errorf("failed to read rootfs image %v: %v", image, err)
+	}
+	switch {
+	case binary.LittleEndian.Uint32(header[0:]) == 0x73717368:
+		return "squashfs", nil
+	case binary.LittleEndian.Uint32(header[1024:]) == 0xe0f5e1e2:
+		return "erofs", nil
+	}
+	return "", fmt.Errorf("rootfs image %v is neither squashfs nor erofs", image)
+}
+
+// rootfsArgs returns qemu args that attach the rootfs image.
+func rootfsArgs(image string) []string {
+	return []string{
+		"-drive", fmt.Sprintf("file=%v,if=virtio,format=raw,readonly=on,serial=syzrootfs", image),
+	}
+}
+
+// rootfsInitScript is /init of the initramfs that mounts the read-only rootfs
+// with a tmpfs overlay and switches to it.
+const rootfsInitScript = `#!/bin/busybox sh
+export PATH=/bin
+busybox mount -t proc proc /proc
+busybox mount -t sysfs sysfs /sys
+busybox mount -t devtmpfs devtmpfs /dev
+echo "syzkaller: mounting {{FSTYPE}} rootfs {{DEVICE}} with tmpfs overlay"
+busybox mount -t {{FSTYPE}} -o ro {{DEVICE}} /lower || exec busybox sh
+busybox mount -t tmpfs tmpfs /rw || exec busybox sh
+busybox mkdir -p /rw/upper /rw/work
+busybox mount -t overlay overlay -o lowerdir=/lower,upperdir=/rw/upper,workdir=/rw/work /newroot || exec busybox sh
+busybox umount /proc /sys /dev
+exec busybox switch_root /newroot /sbin/init
+`
+
+func rootfsInit(fstype string) string {
+	script := strings.Replace(rootfsInitScript, "{{FSTYPE}}", fstype, -1)
+	return strings.Replace(script, "{{DEVICE}}", rootfsDevice, -1)
+}
+
+// createRootfsInitramfs writes initramfs (cpio newc archive) that contains busybox
+// and the overlay setup init script for rootfs of the given type.
+func createRootfsInitramfs(file, busybox, fstype string) error {
+	bb, err := ioutil.ReadFile(busybox)
+	if err != nil {
+		return fmt.Errorf("failed to read busybox: %v", err)
+	}
+	w := newCpioWriter()
+	for _, dir := range []string{"bin", "dev", "proc", "sys", "lower", "rw", "newroot"} {
+		w.add(dir, cpioDir|0755, 0, 0, nil)
+	}
+	// The kernel opens /dev/console for init before devtmpfs is mounted.
+	w.add("dev/console", cpioCharDev|0600, 5, 1, nil)
+	w.add("bin/busybox", cpioFile|0755, 0, 0, bb)
+	w.add("init", cpioFile|0755, 0, 0, []byte(rootfsInit(fstype)))
+	return ioutil.WriteFile(file, w.finish(), 0644)
+}
+
+const (
+	cpioFile    = 0100000
+	cpioDir     = 0040000
+	cpioCharDev = 0020000
+)
+
+// cpioWriter writes cpio archives in the "new ASCII" (newc) format understood by the kernel.
+type cpioWriter struct {
+	buf bytes.Buffer
+	ino int
+}
+
+func newCpioWriter() *cpioWriter {
+	return &cpioWriter{ino: 1}
+}
+
+func (w *cpioWriter) add(name string, mode, rdevMajor, rdevMinor int, data []byte) {
+	nlink := 1
+	if mode&cpioDir != 0 {
+		nlink = 2
+	}
+	fmt.Fprintf(&w.buf, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+		w.ino, mode, 0, 0, nlink, 0, len(data), 0, 0, rdevMajor, rdevMinor, len(name)+1, 0)
+	w.ino++
+	w.buf.WriteString(name)
+	w.buf.WriteByte(0)
+	w.pad()
+	w.buf.Write(data)
+	w.pad()
+}
+
+func (w *cpioWriter) pad() {
+	for w.buf.Len()%4 != 0 {
+		w.buf.WriteByte(0)
+	}
+}
+
+func (w *cpioWriter) finish() []byte {
+	w.ino = 0
+	w.add("TRAILER!!!", 0, 0, 0, nil)
+	return w.buf.Bytes()
+}
+
+// checkRootfsOverlay validates rootfs_overlay config and returns the rootfs type (if enabled).
+func checkRootfsOverlay(cfg *Config, image string) (string, error) {
+	if !cfg.RootfsOverlay {
+		return "", nil
+	}
+	if image == "9p" {
+		return "", fmt.Errorf("rootfs_overlay is not supported for 9p image")
+	}
+	if !hasKernel(cfg) {
+		return "", fmt.Errorf("rootfs_overlay requires kernel")
+	}
+	if cfg.Initrd != "" {
+		return "", fmt.Errorf("rootfs_overlay can't be used with initrd")
+	}
+	if cfg.Busybox == "" {
+		return "", fmt.Errorf("rootfs_overlay requires busybox")
+	}
+	if !osutil.IsExist(cfg.Busybox) {
+		return "", fmt.Errorf("busybox binary '%v' does not exist", cfg.Busybox)
+	}
+	return rootfsType(image)
+}
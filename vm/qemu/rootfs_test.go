@@ -0,0 +1,163 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRootfsType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-qemu-rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		offset int
+		magic  uint32
+		fstype string
+	}{
+		{0, 0x73717368, "squashfs"},
+		{1024, 0xe0f5e1e2, "erofs"},
+		{0, 0xef53, ""},
+	}
+	for i, test := range tests {
+		data := make([]byte, 4096)
+		binary.LittleEndian.PutUint32(data[test.offset:], test.magic)
+		file := filepath.Join(dir, test.fstype+"image")
+		if err := ioutil.WriteFile(file, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		fstype, err := rootfsType(file)
+		if test.fstype == "" {
+			if err == nil {
+				t.Errorf("#%v: no error for bad image, got %v", i, fstype)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%v: %v", i, err)
+			continue
+		}
+		if fstype != test.fstype {
+			t.Errorf("#%v: got %v, want %v", i, fstype, test.fstype)
+		}
+	}
+	if _, err := rootfsType(filepath.Join(dir, "nonexistent")); err == nil {
+		t.Errorf("no error for nonexistent image")
+	}
+}
+
+func TestRootfsCmdline(t *testing.T) {
+	if got, want := strings.Join(rootfsArgs("/img.erofs"), " "),
+		"-drive file=/img.erofs,if=virtio,format=raw,readonly=on,serial=syzrootfs"; got != want {
+		t.Errorf("bad rootfs args:\n%v\nwant:\n%v", got, want)
+	}
+	tests := []struct {
+		image   string
+		overlay bool
+		cmdline string
+	}{
+		{"/img", false, "root=/dev/sda"},
+		{"/img", true, "rdinit=/init"},
+		{"9p", false, "root=/dev/root rootfstype=9p rootflags=trans=virtio,version=9p2000.L,cache=loose " +
+			"init=/workdir/init.sh"},
+	}
+	for _, test := range tests {
+		got := strings.Join(rootCmdline(test.image, "/workdir", "/dev/sda", test.overlay), " ")
+		if got != test.cmdline {
+			t.Errorf("image=%v overlay=%v: got %q, want %q", test.image, test.overlay, got, test.cmdline)
+		}
+	}
+}
+
+func TestRootfsInitramfs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-qemu-rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	busybox := filepath.Join(dir, "busybox")
+	if err := ioutil.WriteFile(busybox, []byte("busybox binary"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	initramfs := filepath.Join(dir, "initramfs.cpio")
+	if err := createRootfsInitramfs(initramfs, busybox, "squashfs"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(initramfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := parseCpio(t, data)
+	for _, name := range []string{"bin", "dev", "proc", "sys", "lower", "rw", "newroot", "dev/console"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("no %v in initramfs", name)
+		}
+	}
+	if got := string(files["bin/busybox"]); got != "busybox binary" {
+		t.Errorf("bad busybox in initramfs: %q", got)
+	}
+	script := string(files["init"])
+	if script != rootfsInit("squashfs") {
+		t.Errorf("bad init in initramfs:\n%v", script)
+	}
+	for _, want := range []string{
+		"mount -t squashfs -o ro /dev/vda /lower",
+		"mount -t tmpfs tmpfs /rw",
+		"mount -t overlay overlay -o lowerdir=/lower,upperdir=/rw/upper,workdir=/rw/work /newroot",
+		"switch_root /newroot /sbin/init",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("init does not contain %q:\n%v", want, script)
+		}
+	}
+	if strings.Contains(script, "{{") {
+		t.Errorf("init contains unexpanded templates:\n%v", script)
+	}
+}
+
+// parseCpio parses newc cpio archive and returns file names -> contents.
+func parseCpio(t *testing.T, data []byte) map[string][]byte {
+	files := make(map[string][]byte)
+	align := func(n int) int { return (n + 3) &^ 3 }
+	for pos := 0; ; {
+		if pos+110 > len(data) || !bytes.Equal(data[pos:pos+6], []byte("070701")) {
+			t.Fatalf("bad cpio header at %v", pos)
+		}
+		field := func(i int) int {
+			var v int
+			for _, c := range data[pos+6+i*8 : pos+6+i*8+8] {
+				v <<= 4
+				switch {
+				case c >= '0' && c <= '9':
+					v |= int(c - '0')
+				case c >= 'a' && c <= 'f':
+					v |= int(c-'a') + 10
+				default:
+					t.Fatalf("bad cpio header at %v", pos)
+				}
+			}
+			return v
+		}
+		size, namesize := field(6), field(11)
+		nameStart := pos + 110
+		name := string(data[nameStart : nameStart+namesize-1])
+		dataStart := align(nameStart + namesize)
+		if name == "TRAILER!!!" {
+			if dataStart != len(data) {
+				t.Fatalf("trailing garbage after cpio trailer")
+			}
+			return files
+		}
+		files[name] = data[dataStart : dataStart+size]
+		pos = align(dataStart + size)
+	}
+}
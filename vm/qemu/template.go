@@ -0,0 +1,112 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/google/syzkaller/sys/targets"
+)
+
+// archOverrides are config fields that can be overridden for a particular arch
+// with <field>_<arch> keys (e.g. "cmdline_arm64"), so that the same config
+// can be shared by managers for different arches.
+var archOverrides = []string{"cmdline", "qemu_args"}
+
+// applyArchOverrides replaces fields with their overrides for arch
+// and drops overrides for other arches.
+func applyArchOverrides(data []byte, arch string) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to parse qemu vm config: %v", err)
+	}
+	overrides := make(map[string]json.RawMessage)
+	changed := false
+	for key, val := range fields {
+		for _, field := range archOverrides {
+			if !strings.HasPrefix(key, field+"_") {
+				continue
+			}
+			keyArch := strings.TrimPrefix(key, field+"_")
+			if !knownArch(keyArch) {
+				return nil, fmt.Errorf("unknown arch %q in qemu vm config field %v", keyArch, key)
+			}
+			delete(fields, key)
+			changed = true
+			if keyArch == arch {
+				overrides[field] = val
+			}
+		}
+	}
+	if !changed {
+		return data, nil
+	}
+	for field, val := range overrides {
+		fields[field] = val
+	}
+	return json.Marshal(fields)
+}
+
+func knownArch(arch string) bool {
+	for _, arches := range targets.List {
+		if arches[arch] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// templateData is what templated config values (cmdline, qemu_args) can refer to,
+// e.g. "console={{if eq .Arch \"arm64\"}}ttyAMA0{{else}}ttyS0{{end}}".
+type templateData struct {
+	OS      string
+	Arch    string
+	Index   int    // VM index
+	Workdir string // VM workdir
+	Kernel  string // tag of the kernel the VM runs (see kernels, empty otherwise)
+}
+
+// expandTemplate expands text with data. References to unknown variables are errors.
+func expandTemplate(name, text string, data *templateData) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("bad %v template: %v", name, err)
+	}
+	buf := new(bytes.Buffer)
+	if err := t.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("failed to expand %v template: %v", name, err)
+	}
+	return buf.String(), nil
+}
+
+// expandConfig expands templated config values for the VM with the given index and workdir.
+// Returns expanded qemu_args and cmdline.
+func expandConfig(cfg *Config, targetOS, arch string, index int, workdir string) (string, string, error) {
+	data := &templateData{
+		OS:      targetOS,
+		Arch:    arch,
+		Index:   index,
+		Workdir: workdir,
+	}
+	_, data.Kernel = selectKernel(cfg, index)
+	qemuArgs, err := expandTemplate("qemu_args", cfg.QemuArgs, data)
+	if err != nil {
+		return "", "", err
+	}
+	cmdline, err := expandTemplate("cmdline", cfg.Cmdline, data)
+	if err != nil {
+		return "", "", err
+	}
+	return qemuArgs, cmdline, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"testing"
+
+	"github.com/google/syzkaller/pkg/config"
+)
+
+func TestArchOverrides(t *testing.T) {
+	data := []byte(`{
+		"count": 2,
+		"cmdline": "console=ttyS0",
+		"cmdline_arm64": "console=ttyAMA0 earlycon",
+		"qemu_args_arm64": "-machine virt"
+	}`)
+	tests := []struct {
+		arch     string
+		cmdline  string
+		qemuArgs string
+	}{
+		{"amd64", "console=ttyS0", "-enable-kvm"},
+		{"arm64", "console=ttyAMA0 earlycon", "-machine virt"},
+	}
+	for _, test := range tests {
+		data1, err := applyArchOverrides(data, test.arch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := &Config{QemuArgs: "-enable-kvm"}
+		if err := config.LoadData(data1, cfg); err != nil {
+			t.Fatalf("%v: %v", test.arch, err)
+		}
+		if cfg.Count != 2 || cfg.Cmdline != test.cmdline || cfg.QemuArgs != test.qemuArgs {
+			t.Errorf("%v: got count=%v cmdline=%q qemu_args=%q", test.arch, cfg.Count, cfg.Cmdline, cfg.QemuArgs)
+		}
+	}
+	if _, err := applyArchOverrides([]byte(`{"cmdline_arm46": ""}`), "arm64"); err == nil {
+		t.Errorf("override for unknown arch is accepted")
+	}
+}
+
+func TestExpandConfig(t *testing.T) {
+	cfg := &Config{
+		QemuArgs: "-machine virt -D {{.Workdir}}/qemu.log",
+		Cmdline:  `console={{if eq .Arch "arm64"}}ttyAMA0{{else}}ttyS0{{end}} syz.vm={{.OS}}-{{.Index}}`,
+	}
+	qemuArgs, cmdline, err := expandConfig(cfg, "linux", "arm64", 3, "/workdir/vm3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "-machine virt -D /workdir/vm3/qemu.log"; qemuArgs != want {
+		t.Errorf("got qemu_args %q, want %q", qemuArgs, want)
+	}
+	if want := "console=ttyAMA0 syz.vm=linux-3"; cmdline != want {
+		t.Errorf("got cmdline %q, want %q", cmdline, want)
+	}
+	for _, bad := range []string{"{{.Unknown}}", "{{.Arch", "{{template \"foo\"}}"} {
+		cfg := &Config{Cmdline: bad}
+		if _, _, err := expandConfig(cfg, "linux", "amd64", 0, ""); err == nil {
+			t.Errorf("bad template %q is accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/google/syzkaller/pkg/osutil"
+)
+
+// TPM is an emulated TPM (swtpm) attached to VMs (for fuzzing of the kernel TPM subsystem).
+type TPM struct {
+	Version string `json:"version"` // TPM version: "1.2" or "2.0" (default)
+	// qemu TPM frontend device: tpm-tis (default), tpm-crb (TPM 2.0 only),
+	// tpm-tis-device (default for arm64) or tpm-spapr (ppc64).
+	Device string `json:"device"`
+	Swtpm  string `json:"swtpm"` // swtpm binary (swtpm by default)
+}
+
+var tpmDevices = map[string]bool{
+	"tpm-tis":        true,
+	"tpm-crb":        true,
+	"tpm-tis-device": true,
+	"tpm-spapr":      true,
+}
+
+// setTPMDefaults fills in default TPM params for the arch.
+func setTPMDefaults(tpm *TPM, arch string) {
+	if tpm.Version == "" {
+		tpm.Version = "2.0"
+	}
+	if tpm.Device == "" {
+		switch arch {
+		case "arm64":
+			tpm.Device = "tpm-tis-device"
+		case "ppc64le":
+			tpm.Device = "tpm-spapr"
+		default:
+			tpm.Device = "tpm-tis"
+		}
+	}
+	if tpm.Swtpm == "" {
+		tpm.Swtpm = "swtpm"
+	}
+}
+
+func checkTPM(tpm *TPM) error {
+	if tpm.Version != "1.2" && tpm.Version != "2.0" {
+		return fmt.Errorf("bad tpm version %q, want 1.2 or 2.0", tpm.Version)
+	}
+	if !tpmDevices[tpm.Device] {
+		return fmt.Errorf("bad tpm device %q", tpm.Device)
+	}
+	if tpm.Device == "tpm-crb" && tpm.Version != "2.0" {
+		return fmt.Errorf("tpm-crb device requires tpm version 2.0")
+	}
+	if _, err := exec.LookPath(tpm.Swtpm); err != nil {
+		return fmt.Errorf("tpm requires swtpm: %v", err)
+	}
+	return nil
+}
+
+// swtpmArgs returns swtpm args for the TPM with state in stateDir and control socket.
+// swtpm terminates when qemu disconnects from the socket.
+func swtpmArgs(tpm *TPM, stateDir, socket string) []string {
+	args := []string{
+		"socket",
+		"--tpmstate", "dir=" + stateDir,
+		"--ctrl", "type=unixio,path=" + socket,
+		"--log", "file=" + filepath.Join(stateDir, "swtpm.log"),
+		"--terminate",
+	}
+	if tpm.Version == "2.0" {
+		args = append(args, "--tpm2")
+	}
+	return args
+}
+
+// tpmArgs returns qemu args that connect the TPM device to swtpm on socket.
+func tpmArgs(tpm *TPM, socket string) []string {
+	return []string{
+		"-chardev", "socket,id=chrtpm,path=" + socket,
+		"-tpmdev", "emulator,id=tpm0,chardev=chrtpm",
+		"-device", tpm.Device + ",tpmdev=tpm0",
+	}
+}
+
+func (inst *instance) tpmSocket() string {
+	return filepath.Join(inst.workdir, "swtpm.sock")
+}
+
+// startTPM starts swtpm with a fresh state for the instance.
+func (inst *instance) startTPM() error {
+	stateDir := filepath.Join(inst.workdir, "tpm")
+	os.RemoveAll(stateDir)
+	if err := osutil.MkdirAll(stateDir); err != nil {
+		return fmt.Errorf("failed to create tpm state dir: %v", err)
+	}
+	inst.tpmDir = stateDir
+	socket := inst.tpmSocket()
+	os.Remove(socket)
+	swtpm := osutil.Command(inst.cfg.TPM.Swtpm, swtpmArgs(inst.cfg.TPM, stateDir, socket)...)
+	if err := swtpm.Start(); err != nil {
+		return fmt.Errorf("failed to start swtpm: %v", err)
+	}
+	inst.swtpm = swtpm
+	// qemu fails to start if the socket does not exist yet.
+	for start := time.Now(); !osutil.IsExist(socket); {
+		if time.Since(start) > 10*time.Second {
+			output, _ := ioutil.ReadFile(filepath.Join(stateDir, "swtpm.log"))
+			return fmt.Errorf("swtpm did not create socket %v\n%s", socket, output)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
+
+func (inst *instance) stopTPM() {
+	if inst.swtpm != nil {
+		inst.swtpm.Process.Kill()
+		inst.swtpm.Wait()
+	}
+	if inst.tpmDir != "" {
+		os.RemoveAll(inst.tpmDir)
+	}
+}
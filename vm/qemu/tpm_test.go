@@ -0,0 +1,79 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTPMArgs(t *testing.T) {
+	tests := []struct {
+		tpm   TPM
+		arch  string
+		swtpm string
+		qemu  string
+	}{
+		{
+			TPM{},
+			"amd64",
+			"socket --tpmstate dir=/workdir/tpm --ctrl type=unixio,path=/workdir/swtpm.sock " +
+				"--log file=/workdir/tpm/swtpm.log --terminate --tpm2",
+			"-chardev socket,id=chrtpm,path=/workdir/swtpm.sock " +
+				"-tpmdev emulator,id=tpm0,chardev=chrtpm -device tpm-tis,tpmdev=tpm0",
+		},
+		{
+			TPM{Version: "1.2"},
+			"arm64",
+			"socket --tpmstate dir=/workdir/tpm --ctrl type=unixio,path=/workdir/swtpm.sock " +
+				"--log file=/workdir/tpm/swtpm.log --terminate",
+			"-chardev socket,id=chrtpm,path=/workdir/swtpm.sock " +
+				"-tpmdev emulator,id=tpm0,chardev=chrtpm -device tpm-tis-device,tpmdev=tpm0",
+		},
+		{
+			TPM{Device: "tpm-crb"},
+			"amd64",
+			"socket --tpmstate dir=/workdir/tpm --ctrl type=unixio,path=/workdir/swtpm.sock " +
+				"--log file=/workdir/tpm/swtpm.log --terminate --tpm2",
+			"-chardev socket,id=chrtpm,path=/workdir/swtpm.sock " +
+				"-tpmdev emulator,id=tpm0,chardev=chrtpm -device tpm-crb,tpmdev=tpm0",
+		},
+	}
+	for i, test := range tests {
+		tpm := test.tpm
+		setTPMDefaults(&tpm, test.arch)
+		if tpm.Swtpm != "swtpm" {
+			t.Errorf("#%v: got swtpm binary %q", i, tpm.Swtpm)
+		}
+		swtpm := strings.Join(swtpmArgs(&tpm, "/workdir/tpm", "/workdir/swtpm.sock"), " ")
+		if swtpm != test.swtpm {
+			t.Errorf("#%v: got swtpm args:\n%v\nwant:\n%v", i, swtpm, test.swtpm)
+		}
+		qemu := strings.Join(tpmArgs(&tpm, "/workdir/swtpm.sock"), " ")
+		if qemu != test.qemu {
+			t.Errorf("#%v: got qemu args:\n%v\nwant:\n%v", i, qemu, test.qemu)
+		}
+	}
+}
+
+func TestCheckTPM(t *testing.T) {
+	tests := []struct {
+		tpm TPM
+		ok  bool
+	}{
+		{TPM{Version: "2.0", Device: "tpm-tis", Swtpm: "sh"}, true},
+		{TPM{Version: "1.2", Device: "tpm-tis", Swtpm: "sh"}, true},
+		{TPM{Version: "2.0", Device: "tpm-crb", Swtpm: "sh"}, true},
+		{TPM{Version: "1.2", Device: "tpm-crb", Swtpm: "sh"}, false},
+		{TPM{Version: "3.0", Device: "tpm-tis", Swtpm: "sh"}, false},
+		{TPM{Version: "2.0", Device: "tpm-foo", Swtpm: "sh"}, false},
+		{TPM{Version: "2.0", Device: "tpm-tis", Swtpm: "swtpm-does-not-exist"}, false},
+	}
+	for i, test := range tests {
+		err := checkTPM(&test.tpm)
+		if test.ok != (err == nil) {
+			t.Errorf("test #%v: got error %v, want ok %v", i, err, test.ok)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/google/syzkaller/pkg/osutil"
+)
+
+// Overridden in tests.
+var qemuVersionOutput = func(bin string) ([]byte, error) {
+	return osutil.RunCmd(time.Minute, "", bin, "--version")
+}
+
+var qemuVersionRe = regexp.MustCompile(`QEMU emulator version ([0-9]+)\.([0-9]+)`)
+
+// parseQemuVersion returns major and minor version from qemu --version output.
+func parseQemuVersion(output []byte) (int, int, error) {
+	match := qemuVersionRe.FindSubmatch(output)
+	if match == nil {
+		return 0, 0, fmt.Errorf("failed to parse qemu version in %q", output)
+	}
+	major, _ := strconv.Atoi(string(match[1]))
+	minor, _ := strconv.Atoi(string(match[2]))
+	return major, minor, nil
+}
+
+// checkQemuVersion checks that the qemu binary is new enough for the arch (see archConfig.MinQemu).
+func checkQemuVersion(bin, arch string, archConfig *archConfig) error {
+	want := archConfig.MinQemu
+	if want[0] == 0 && want[1] == 0 {
+		return nil
+	}
+	output, err := qemuVersionOutput(bin)
+	if err != nil {
+		return fmt.Errorf("failed to get %v version: %v", bin, err)
+	}
+	major, minor, err := parseQemuVersion(output)
+	if err != nil {
+		return err
+	}
+	if major < want[0] || major == want[0] && minor < want[1] {
+		return fmt.Errorf("%v VMs require qemu %v.%v or newer, %v is %v.%v",
+			arch, want[0], want[1], bin, major, minor)
+	}
+	return nil
+}
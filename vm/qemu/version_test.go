@@ -0,0 +1,77 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckQemuVersion(t *testing.T) {
+	defer func(old func(string) ([]byte, error)) { qemuVersionOutput = old }(qemuVersionOutput)
+	tests := []struct {
+		arch   string
+		output string
+		ok     bool
+	}{
+		{"linux/amd64", "", true}, // any version
+		{"linux/ppc64le", "QEMU emulator version 2.12.0 (Debian 1:2.12+dfsg-3)\n", true},
+		{"linux/ppc64le", "QEMU emulator version 2.11.1\n", false},
+		{"linux/s390x", "QEMU emulator version 4.1.0\n", true},
+		{"linux/s390x", "QEMU emulator version 10.0.2\n", true},
+		{"linux/s390x", "QEMU emulator version 3.1.0\n", false},
+		{"linux/s390x", "garbage\n", false},
+	}
+	for _, test := range tests {
+		qemuVersionOutput = func(string) ([]byte, error) {
+			return []byte(test.output), nil
+		}
+		err := checkQemuVersion("qemu", test.arch, archConfigs[test.arch])
+		if test.ok != (err == nil) {
+			t.Errorf("%v %q: got error %v, want ok %v", test.arch, test.output, err, test.ok)
+		}
+	}
+}
+
+func TestArchConfigs(t *testing.T) {
+	tests := []struct {
+		arch     string
+		console  string
+		root     string
+		nic      string
+		virtio9p string
+	}{
+		{"linux/amd64", "console=ttyS0", "/dev/sda", ",model=e1000", "virtio-9p-pci"},
+		{"linux/ppc64le", "console=hvc0", "/dev/sda", ",model=virtio-net-pci", "virtio-9p-pci"},
+		{"linux/s390x", "console=ttysclp0", "/dev/vda", ",model=virtio-net-ccw", "virtio-9p-ccw"},
+	}
+	for _, test := range tests {
+		ac := archConfigs[test.arch]
+		cmdline := strings.Join(ac.CmdLine, " ")
+		if !strings.Contains(cmdline, test.console) || strings.Count(cmdline, "console=") != 1 {
+			t.Errorf("%v: bad console in cmdline %q, want %v", test.arch, cmdline, test.console)
+		}
+		if test.arch != "linux/amd64" && strings.Contains(cmdline, "vsyscall=") {
+			t.Errorf("%v: x86-specific args in cmdline %q", test.arch, cmdline)
+		}
+		if root := ac.rootDevice(); root != test.root {
+			t.Errorf("%v: got root device %v, want %v", test.arch, root, test.root)
+		}
+		if dev := ac.virtioDevice("virtio-9p"); dev != test.virtio9p {
+			t.Errorf("%v: got 9p device %v, want %v", test.arch, dev, test.virtio9p)
+		}
+		// Copy and Forward go over user-mode networking with the host forwarding,
+		// so they only depend on the NIC model the guest has a driver for.
+		if args := strings.Join(netnsArgs(ac.NicModel), " "); !strings.HasPrefix(args, "-net nic"+test.nic+" ") {
+			t.Errorf("%v: bad nic args %q", test.arch, args)
+		}
+		inst := &instance{archConfig: ac, sshAddr: "localhost"}
+		if addr, err := inst.Forward(1234); err != nil || addr != hostAddr+":1234" {
+			t.Errorf("%v: got forward address %v, %v", test.arch, addr, err)
+		}
+		if dir := inst.targetDir(); dir != "/" {
+			t.Errorf("%v: got target dir %v", test.arch, dir)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"sync"
+	"time"
+)
+
+// Rate limiting of crash reports (max_reports_per_minute config).
+// During a crash storm all VMs of the pool may hit the same bug over and over again, and reports
+// can be emitted faster than the manager and the dashboard ingest them. The pool emits at most
+// the configured number of reports per minute, excess reports are marked as throttled: they are only
+// counted and the count is added to the repeats of the next emitted report with the same title.
+// Distinct titles are prioritized: titles that were already reported in the current minute
+// can use only half of the budget, so that new bugs are not starved by the storm.
+
+type reportLimiter struct {
+	limit int
+
+	mu      sync.Mutex
+	window  time.Time       // start of the current minute
+	emitted int             // reports emitted in the current minute
+	titles  map[string]bool // titles emitted in the current minute
+	dropped map[string]int  // title -> throttled reports since the last emitted report
+}
+
+const reportLimitWindow = time.Minute
+
+func newReportLimiter(limit int) *reportLimiter {
+	if limit == 0 {
+		return nil
+	}
+	return &reportLimiter{
+		limit:   limit,
+		dropped: make(map[string]int),
+	}
+}
+
+// admit returns true if a report with the title can be emitted now and the number of reports
+// with the same title that were throttled before it, or false if the report is throttled.
+func (rl *reportLimiter) admit(title string, now time.Time) (bool, int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if now.Sub(rl.window) >= reportLimitWindow {
+		rl.window = now
+		rl.emitted = 0
+		rl.titles = make(map[string]bool)
+	}
+	budget := rl.limit
+	if rl.titles[title] {
+		budget = (rl.limit + 1) / 2
+	}
+	if rl.emitted >= budget {
+		rl.dropped[title]++
+		return false, 0
+	}
+	rl.emitted++
+	rl.titles[title] = true
+	repeats := rl.dropped[title]
+	delete(rl.dropped, title)
+	return true, repeats
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestReportLimiter(t *testing.T) {
+	if newReportLimiter(0) != nil {
+		t.Fatalf("limiter is created without max_reports_per_minute")
+	}
+	rl := newReportLimiter(4)
+	now := time.Now()
+	// A storm of the same bug: repeats get only half of the budget.
+	emitted := 0
+	for i := 0; i < 10; i++ {
+		if ok, _ := rl.admit("storm", now); ok {
+			emitted++
+		}
+	}
+	if emitted != 2 {
+		t.Fatalf("emitted %v storm reports, want 2", emitted)
+	}
+	// Distinct titles can use the rest of the budget...
+	for i := 0; i < 2; i++ {
+		if ok, _ := rl.admit(fmt.Sprintf("bug %v", i), now.Add(time.Second)); !ok {
+			t.Fatalf("distinct title %v is throttled", i)
+		}
+	}
+	// ...but not more than the limit.
+	if ok, _ := rl.admit("bug 2", now.Add(2*time.Second)); ok {
+		t.Fatalf("report over the limit is emitted")
+	}
+	// In the next minute throttled reports are coalesced into the next report of the same title.
+	next := now.Add(reportLimitWindow)
+	ok, repeats := rl.admit("storm", next)
+	if !ok || repeats != 8 {
+		t.Fatalf("next storm report: emitted %v with %v repeats, want 8 repeats", ok, repeats)
+	}
+	ok, repeats = rl.admit("storm", next)
+	if !ok || repeats != 0 {
+		t.Fatalf("second storm report: emitted %v with %v repeats, want 0 repeats", ok, repeats)
+	}
+	ok, repeats = rl.admit("bug 2", next)
+	if !ok || repeats != 1 {
+		t.Fatalf("next bug 2 report: emitted %v with %v repeats, want 1 repeat", ok, repeats)
+	}
+	if ok, _ := rl.admit("storm", next); ok {
+		t.Fatalf("storm report over the repeat budget is emitted")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// ReloadExecutor replaces the executor in the running VM with hostBinary,
+// so that the next Run uses the new executor without rebooting the VM
+// (e.g. between reproduction attempts with tweaked executors).
+// The executor must have been copied into the VM with Copy before (shared executors can't be reloaded),
+// and the VM must not be running commands. Reload is refused if MonitorExecution has detected a crash
+// or the kernel is tainted by a prior crash: kernel state can't be trusted then and the VM must be recreated.
+func (inst *Instance) ReloadExecutor(hostBinary string) error {
+	if inst.crashed {
+		return fmt.Errorf("VM has crashed, can't reload executor")
+	}
+	if inst.executor == "" {
+		return fmt.Errorf("executor is not copied into the VM")
+	}
+	if err := inst.checkTaint(); err != nil {
+		return err
+	}
+	vmBinary, err := inst.impl.Copy(hostBinary)
+	if err != nil {
+		return fmt.Errorf("failed to copy executor: %v", err)
+	}
+	if vmBinary == inst.executor {
+		return nil
+	}
+	// Commands refer to the executor by its old path.
+	if _, err := inst.runCommand(time.Minute, fmt.Sprintf("cp -f %v %v", vmBinary, inst.executor)); err != nil {
+		return fmt.Errorf("failed to replace executor: %v", err)
+	}
+	return nil
+}
+
+// Kernel taint flags that mean that the kernel has crashed or misbehaved:
+// TAINT_MACHINE_CHECK, TAINT_BAD_PAGE, TAINT_DIE, TAINT_WARN, TAINT_SOFTLOCKUP.
+const crashTaints = 1<<4 | 1<<5 | 1<<7 | 1<<9 | 1<<14
+
+var taintRe = regexp.MustCompile(`SYZ-TAINTED=([0-9]+)`)
+
+// checkTaint checks that the kernel was not tainted by a crash that we did not notice
+// (e.g. the crash happened during the previous run that was not monitored).
+func (inst *Instance) checkTaint() error {
+	if inst.pool.os != "linux" {
+		return nil
+	}
+	output, err := inst.runCommand(time.Minute, "echo SYZ-TAINTED=$(cat /proc/sys/kernel/tainted)")
+	if err != nil {
+		return fmt.Errorf("failed to check kernel taint: %v", err)
+	}
+	match := taintRe.FindSubmatch(output)
+	if match == nil {
+		return fmt.Errorf("failed to check kernel taint: no taint in output:\n%s", output)
+	}
+	taint, err := strconv.ParseUint(string(match[1]), 10, 64)
+	if err != nil {
+		return fmt.Errorf("failed to check kernel taint: %v", err)
+	}
+	if taint&crashTaints != 0 {
+		return fmt.Errorf("kernel is tainted (%v), can't reload executor", taint)
+	}
+	return nil
+}
+
+// runCommand runs a short auxiliary command in the VM and returns its output
+// (which also includes any console output printed meanwhile).
+func (inst *Instance) runCommand(timeout time.Duration, command string) ([]byte, error) {
+	outc, errc, err := inst.impl.Run(timeout, nil, command)
+	if err != nil {
+		return nil, err
+	}
+	var output []byte
+	for {
+		select {
+		case out, ok := <-outc:
+			if !ok {
+				outc = nil
+				continue
+			}
+			output = append(output, out...)
+		case err := <-errc:
+			// Collect output that is already available.
+		drain:
+			for outc != nil {
+				select {
+				case out, ok := <-outc:
+					if !ok {
+						break drain
+					}
+					output = append(output, out...)
+				default:
+					break drain
+				}
+			}
+			return output, err
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/osutil"
+	"github.com/google/syzkaller/pkg/report"
+)
+
+// ReplayOptions describe how Replay runs programs.
+type ReplayOptions struct {
+	// Indexes of VMs to use (all VMs of the pool if empty).
+	Indexes []int
+	// Host files that are copied into every VM before running programs (e.g. syz-execprog, syz-executor).
+	Files []string
+	// Command returns command that runs the program prog in VM,
+	// files are VM paths of Files (in the same order).
+	Command func(files []string, prog string) string
+	// Timeout for running a single program.
+	Timeout  time.Duration
+	Reporter report.Reporter
+}
+
+// ReplayCrash is a crash triggered by a replayed program.
+type ReplayCrash struct {
+	Prog   string // host file of the program
+	Report *report.Report
+}
+
+// Replay runs each program from dir once on the VMs of the pool and returns crashes
+// attributed to the programs that triggered them (sorted by program file name).
+// This is a deterministic regression test: programs don't change and no new programs are generated.
+// Each VM runs one program at a time; a VM is recreated after a crash,
+// so a program is never blamed for a crash of a previous program.
+func (pool *Pool) Replay(dir string, opts *ReplayOptions) ([]*ReplayCrash, error) {
+	files, err := osutil.ListDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	queue := new(replayQueue)
+	for _, file := range files {
+		queue.progs = append(queue.progs, filepath.Join(dir, file))
+	}
+	indexes := opts.Indexes
+	if len(indexes) == 0 {
+		for i := 0; i < pool.Count(); i++ {
+			indexes = append(indexes, i)
+		}
+	}
+	var mu sync.Mutex
+	var crashes []*ReplayCrash
+	var wg sync.WaitGroup
+	for _, index := range indexes {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			res := pool.replayWorker(index, queue, opts)
+			mu.Lock()
+			crashes = append(crashes, res...)
+			mu.Unlock()
+		}(index)
+	}
+	wg.Wait()
+	sort.Slice(crashes, func(i, j int) bool {
+		return crashes[i].Prog < crashes[j].Prog
+	})
+	// Programs are left in the queue only if all VMs failed.
+	for _, prog := range queue.progs {
+		queue.failed = append(queue.failed, fmt.Errorf("%v: no VMs left", prog))
+	}
+	if len(queue.failed) != 0 {
+		return crashes, fmt.Errorf("failed to replay %v programs: %v", len(queue.failed), queue.failed)
+	}
+	return crashes, nil
+}
+
+// replayQueue is the queue of programs shared by all replay workers.
+type replayQueue struct {
+	mu     sync.Mutex
+	progs  []string
+	failed []error
+}
+
+func (queue *replayQueue) next() (string, bool) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	if len(queue.progs) == 0 {
+		return "", false
+	}
+	prog := queue.progs[0]
+	queue.progs = queue.progs[1:]
+	return prog, true
+}
+
+// requeue returns a program that a worker failed to run, so that other workers run it.
+func (queue *replayQueue) requeue(prog string) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	queue.progs = append(queue.progs, prog)
+}
+
+func (queue *replayQueue) fail(prog string, err error) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	queue.failed = append(queue.failed, fmt.Errorf("%v: %v", prog, err))
+}
+
+// Number of consecutive failures to create a VM after which a replay worker gives up.
+const replayCreateAttempts = 3
+
+func (pool *Pool) replayWorker(index int, queue *replayQueue, opts *ReplayOptions) []*ReplayCrash {
+	var crashes []*ReplayCrash
+	var inst *Instance
+	var files []string
+	defer func() {
+		if inst != nil {
+			inst.Close()
+		}
+	}()
+	for {
+		prog, ok := queue.next()
+		if !ok {
+			return crashes
+		}
+		var err error
+		for attempt := 0; inst == nil; attempt++ {
+			if attempt == replayCreateAttempts {
+				queue.requeue(prog)
+				return crashes
+			}
+			inst, files, err = pool.replayInstance(index, opts)
+			if err != nil {
+				log.Logf(0, "vm-%v: failed to create replay instance: %v", index, err)
+			}
+		}
+		rep, err := inst.replay(prog, files, opts)
+		if err != nil {
+			log.Logf(0, "vm-%v: failed to replay %v: %v", index, prog, err)
+			queue.fail(prog, err)
+		}
+		if rep == nil && err == nil {
+			continue
+		}
+		if rep != nil && !rep.Suppressed {
+			log.Crashf("vm-%v: %v crashed: %v", index, prog, rep.Title)
+			crashes = append(crashes, &ReplayCrash{Prog: prog, Report: rep})
+		}
+		// The VM is in unknown state after a crash or a failure.
+		inst.Close()
+		inst = nil
+	}
+}
+
+func (pool *Pool) replayInstance(index int, opts *ReplayOptions) (*Instance, []string, error) {
+	inst, err := pool.Create(index)
+	if err != nil {
+		return nil, nil, err
+	}
+	var files []string
+	for _, file := range opts.Files {
+		vmFile, err := inst.Copy(file)
+		if err != nil {
+			inst.Close()
+			return nil, nil, fmt.Errorf("failed to copy %v: %v", file, err)
+		}
+		files = append(files, vmFile)
+	}
+	return inst, files, nil
+}
+
+func (inst *Instance) replay(prog string, files []string, opts *ReplayOptions) (*report.Report, error) {
+	vmProg, err := inst.Copy(prog)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy program: %v", err)
+	}
+	outc, errc, err := inst.Run(opts.Timeout, nil, opts.Command(files, vmProg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to run program: %v", err)
+	}
+	return inst.MonitorExecution(outc, errc, opts.Reporter, true), nil
+}
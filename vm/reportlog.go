@@ -0,0 +1,110 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/osutil"
+	"github.com/google/syzkaller/pkg/report"
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+// The report log (report_log config) records the outcome of every MonitorExecution call
+// as a line of JSON (reportLogEntry) for offline analysis, independent of the manager storage.
+
+// Outcomes of a VM run.
+const (
+	OutcomeCrash         = "crash"          // a crash was detected (including lost connection, no output, etc)
+	OutcomeSuppressed    = "suppressed"     // a crash was detected, but it's suppressed
+	OutcomeThrottled     = "throttled"      // a crash was detected, but max_reports_per_minute is exceeded
+	OutcomeSecurityEvent = "security-event" // a security event signature was detected (see security_events)
+	OutcomeBootWarning   = "boot-warning"   // a kernel oops was printed before the fuzzer executed any programs
+	OutcomeExit          = "exit"           // the program has exited and it was allowed to
+	OutcomeTimeout       = "timeout"        // the run has finished by timeout without crashes
+	OutcomeRecycle       = "recycle"        // the VM was preempted, restart was requested or shutdown is in progress
+	OutcomeInfraError    = "infra-error"    // the VM has failed because of the host (e.g. host OOM kill)
+)
+
+type reportLogEntry struct {
+	Time     time.Time      `json:"time"`
+	Start    time.Time      `json:"start"`
+	Duration time.Duration  `json:"duration"`
+	Outcome  string         `json:"outcome"`
+	Report   *bundleReport  `json:"report,omitempty"`
+	Machine  *bundleMachine `json:"machine"`
+	// I/O statistics of VM block devices during the run (if available).
+	BlockStats []vmimpl.BlockStats `json:"block_stats,omitempty"`
+}
+
+type reportLog struct {
+	cfg mgrconfig.ReportLog
+	mu  sync.Mutex
+}
+
+// logOutcome appends the outcome of the run that started at start to the report log (if configured).
+func (inst *Instance) logOutcome(start time.Time, outcome string, rep *report.Report,
+	blockStats []vmimpl.BlockStats) {
+	if inst.pool.reportLog == nil {
+		return
+	}
+	now := time.Now()
+	entry := &reportLogEntry{
+		Time:       now,
+		Start:      start,
+		Duration:   now.Sub(start),
+		Outcome:    outcome,
+		Machine:    inst.machineInfo(),
+		BlockStats: blockStats,
+	}
+	if rep != nil {
+		entry.Report = newBundleReport(rep)
+		entry.Machine.Info = string(rep.Info)
+	}
+	if err := inst.pool.reportLog.write(entry); err != nil {
+		log.Logf(0, "vm-%v: failed to write report log: %v", inst.index, err)
+	}
+}
+
+func (rl *reportLog) write(entry *reportLogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if st, err := os.Stat(rl.cfg.File); err == nil && st.Size()+int64(len(data)) > int64(rl.cfg.MaxSize)<<20 {
+		if err := rl.rotate(); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(rl.cfg.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, osutil.DefaultFilePerm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	return err
+}
+
+// rotate renames file.N-1 to file.N, ..., file to file.1 dropping the oldest file, rl.mu must be held.
+func (rl *reportLog) rotate() error {
+	if rl.cfg.Backups == 0 {
+		return os.Remove(rl.cfg.File)
+	}
+	for i := rl.cfg.Backups - 1; i > 0; i-- {
+		src := fmt.Sprintf("%v.%v", rl.cfg.File, i)
+		if err := os.Rename(src, fmt.Sprintf("%v.%v", rl.cfg.File, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(rl.cfg.File, rl.cfg.File+".1")
+}
@@ -0,0 +1,166 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+)
+
+func TestReportLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-vm-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "reports.ndjson")
+	cfg := &mgrconfig.Config{
+		Name:      "test-manager",
+		Workdir:   dir,
+		ReportLog: mgrconfig.ReportLog{File: logFile, MaxSize: 1, Backups: 1},
+	}
+	pool, reporter := createTestPool(t, cfg)
+	tests := []struct {
+		outcome string
+		canExit bool
+		body    func(outc chan []byte, errc chan error)
+		title   string
+	}{
+		{
+			outcome: OutcomeCrash,
+			body: func(outc chan []byte, errc chan error) {
+				outc <- []byte("executing program\n")
+				outc <- []byte("BUG: bad\n")
+			},
+			title: "BUG: bad",
+		},
+		{
+			outcome: OutcomeExit,
+			canExit: true,
+			body: func(outc chan []byte, errc chan error) {
+				errc <- nil
+			},
+		},
+		{
+			outcome: OutcomeTimeout,
+			body: func(outc chan []byte, errc chan error) {
+				errc <- ErrTimeout
+			},
+		},
+		{
+			outcome: OutcomeRecycle,
+			body: func(outc chan []byte, errc chan error) {
+				outc <- []byte(fuzzerPreemptedStr + "\n")
+				errc <- nil
+			},
+		},
+	}
+	for _, test := range tests {
+		runTestInstance(t, pool, reporter, test.canExit, func(inst *testInstance) {
+			test.body(inst.outc, inst.errc)
+		})
+	}
+	data, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != len(tests) {
+		t.Fatalf("got %v lines, want %v:\n%s", len(lines), len(tests), data)
+	}
+	for i, test := range tests {
+		// Check the shape of the line, not only what reportLogEntry can parse.
+		fields := make(map[string]json.RawMessage)
+		if err := json.Unmarshal([]byte(lines[i]), &fields); err != nil {
+			t.Fatalf("line %v is not JSON: %v\n%s", i, err, lines[i])
+		}
+		for _, field := range []string{"time", "start", "duration", "outcome", "machine"} {
+			if fields[field] == nil {
+				t.Errorf("%v: no %q in %s", test.outcome, field, lines[i])
+			}
+		}
+		if _, ok := fields["report"]; ok != (test.title != "") {
+			t.Errorf("%v: report presence %v, want %v", test.outcome, ok, test.title != "")
+		}
+		entry := new(reportLogEntry)
+		if err := json.Unmarshal([]byte(lines[i]), entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Outcome != test.outcome {
+			t.Errorf("got outcome %q, want %q", entry.Outcome, test.outcome)
+		}
+		// Duration uses monotonic clock, so it can slightly differ from the wall clock timestamps.
+		if entry.Start.After(entry.Time) || entry.Duration < 0 ||
+			entry.Duration > entry.Time.Sub(entry.Start)+time.Millisecond {
+			t.Errorf("%v: bad timestamps: start %v, time %v, duration %v",
+				test.outcome, entry.Start, entry.Time, entry.Duration)
+		}
+		if m := entry.Machine; m == nil || m.Type != "test" || m.Name != "test-manager" || m.Index != 0 {
+			t.Errorf("%v: bad machine info: %+v", test.outcome, m)
+		}
+		if test.title != "" && (entry.Report.Title != test.title || entry.Report.Report == "" ||
+			entry.Report.Time.IsZero()) {
+			t.Errorf("%v: bad report: %+v", test.outcome, entry.Report)
+		}
+	}
+}
+
+func TestReportLogRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-vm-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "reports.ndjson")
+	rl := &reportLog{cfg: mgrconfig.ReportLog{File: file, MaxSize: 1, Backups: 2}}
+	big := strings.Repeat("x", 400<<10)
+	for i := 0; i < 8; i++ {
+		entry := &reportLogEntry{
+			Outcome: OutcomeCrash,
+			Report:  &bundleReport{Title: string(rune('a' + i)), Report: big},
+		}
+		if err := rl.write(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 2 entries fit into 1MB, so the files have (g, h), (e, f), (c, d); (a, b) were dropped.
+	for _, f := range []struct {
+		suffix string
+		titles string
+	}{
+		{"", "gh"},
+		{".1", "ef"},
+		{".2", "cd"},
+	} {
+		data, err := ioutil.ReadFile(file + f.suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		titles := ""
+		s := bufio.NewScanner(bytes.NewReader(data))
+		s.Buffer(nil, 1<<20)
+		for s.Scan() {
+			entry := new(reportLogEntry)
+			if err := json.Unmarshal(s.Bytes(), entry); err != nil {
+				t.Fatal(err)
+			}
+			titles += entry.Report.Title
+		}
+		if titles != f.titles {
+			t.Errorf("file%v contains %q, want %q", f.suffix, titles, f.titles)
+		}
+	}
+	if _, err := os.Stat(file + ".3"); err == nil {
+		t.Errorf("too many backups are kept")
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/report"
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+// SanitizerOptions describe how RunOnSanitizers runs a reproducer.
+type SanitizerOptions struct {
+	// How the reproducer is run (Indexes are ignored, VMs are selected by Kernels).
+	ReplayOptions
+	// Tags of kernels of the pool built with sanitizers (e.g. "kasan" and "kmsan" kernels
+	// in qemu kernels config), see vmimpl.KernelIndexer.
+	Kernels []string
+	// Number of runs of the reproducer on each kernel until it crashes (default: 1),
+	// reproducers of races don't crash the kernel every time.
+	Attempts int
+}
+
+// SanitizerReport is the result of running a reproducer on one of the kernels.
+type SanitizerReport struct {
+	Kernel string // tag of the kernel
+	// Sanitizer that caught the bug (e.g. "KASAN"), empty if the kernel crashed without a sanitizer report
+	// (the sanitizer did not catch the bug) or did not crash.
+	Sanitizer string
+	Report    *report.Report // nil if the reproducer did not crash the kernel
+	Err       error          // failure to run the reproducer (e.g. VMs don't boot)
+}
+
+// RunOnSanitizers runs the reproducer repro (host file) on each of the kernels in parallel
+// and returns the results in the order of opts.Kernels. Once a crash is reproduced, kernels with
+// sanitizers often produce more detailed reports of the same bug (e.g. KASAN catches the bad access
+// that corrupted memory and crashed a kernel without KASAN later).
+func (pool *Pool) RunOnSanitizers(repro string, opts *SanitizerOptions) ([]*SanitizerReport, error) {
+	indexer, ok := pool.impl.(vmimpl.KernelIndexer)
+	if !ok {
+		return nil, fmt.Errorf("%v VMs don't support running several kernels", pool.typ)
+	}
+	if len(opts.Kernels) == 0 {
+		return nil, fmt.Errorf("no sanitizer kernels")
+	}
+	var indexes []int
+	for _, kernel := range opts.Kernels {
+		kernelIndexes := indexer.KernelIndexes(kernel)
+		if len(kernelIndexes) == 0 {
+			return nil, fmt.Errorf("no VMs run kernel %q", kernel)
+		}
+		indexes = append(indexes, kernelIndexes[0])
+	}
+	results := make([]*SanitizerReport, len(opts.Kernels))
+	var wg sync.WaitGroup
+	for i := range opts.Kernels {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = pool.runOnSanitizer(indexes[i], opts.Kernels[i], repro, opts)
+		}(i)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+func (pool *Pool) runOnSanitizer(index int, kernel, repro string, opts *SanitizerOptions) *SanitizerReport {
+	res := &SanitizerReport{Kernel: kernel}
+	var inst *Instance
+	var files []string
+	defer func() {
+		if inst != nil {
+			inst.Close()
+		}
+	}()
+	attempts := opts.Attempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	for attempt := 0; attempt < attempts; attempt++ {
+		var err error
+		if inst == nil {
+			if inst, files, err = pool.replayInstance(index, &opts.ReplayOptions); err != nil {
+				res.Err = fmt.Errorf("failed to create VM: %v", err)
+				continue
+			}
+		}
+		rep, err := inst.replay(repro, files, &opts.ReplayOptions)
+		if err == nil && rep == nil {
+			res.Err = nil
+			continue
+		}
+		// The VM is in unknown state after a crash or a failure.
+		inst.Close()
+		inst = nil
+		if err != nil {
+			res.Err = err
+			continue
+		}
+		if rep.Suppressed {
+			continue
+		}
+		log.Crashf("vm-%v: reproducer crashed kernel %v: %v", index, kernel, rep.Title)
+		res.Report = rep
+		res.Sanitizer = reportSanitizer(rep.Title)
+		res.Err = nil
+		break
+	}
+	return res
+}
+
+// Matches titles of reports produced by kernel sanitizers, e.g. "KASAN: use-after-free Read in foo".
+var sanitizerTitleRe = regexp.MustCompile(`^(KASAN|KMSAN|KCSAN|UBSAN|KFENCE):`)
+
+// reportSanitizer returns the sanitizer that produced the report with the title
+// (empty if the report is not a sanitizer report).
+func reportSanitizer(title string) string {
+	if match := sanitizerTitleRe.FindStringSubmatch(title); match != nil {
+		return match[1]
+	}
+	return ""
+}
@@ -0,0 +1,175 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+// testSanitizerPool runs a kernel without sanitizers (VM 0), a KASAN kernel (VM 1) and a KMSAN kernel (VM 2).
+// The reproducer crashes the plain kernel with a generic report, the KASAN kernel catches the bug earlier
+// with a detailed report and the KMSAN kernel does not notice anything.
+type testSanitizerPool struct {
+	mu   sync.Mutex
+	runs map[int]int // VM index -> number of runs of the reproducer
+}
+
+var testSanitizerKernels = []string{"plain", "kasan", "kmsan"}
+
+func (pool *testSanitizerPool) Count() int {
+	return len(testSanitizerKernels)
+}
+
+func (pool *testSanitizerPool) Create(workdir string, index int) (vmimpl.Instance, error) {
+	return &testSanitizerInstance{
+		testInstance: testInstance{outc: make(chan []byte, 10)},
+		pool:         pool,
+		index:        index,
+	}, nil
+}
+
+func (pool *testSanitizerPool) KernelIndexes(tag string) []int {
+	for index, kernel := range testSanitizerKernels {
+		if kernel == tag {
+			return []int{index}
+		}
+	}
+	return nil
+}
+
+type testSanitizerInstance struct {
+	testInstance
+	pool  *testSanitizerPool
+	index int
+}
+
+const testKasanReport = `==================================================================
+BUG: KASAN: use-after-free in sanitized_free+0x1c/0x40
+Read of size 8 at addr ffff88006bd4d8e8 by task syz-executor/1234
+
+Call Trace:
+ sanitized_free+0x1c/0x40
+ do_syscall_64+0x1b1/0x800
+==================================================================
+`
+
+func (inst *testSanitizerInstance) Run(timeout time.Duration, stop <-chan bool, command string) (
+	<-chan []byte, <-chan error, error) {
+	errc := make(chan error, 1)
+	if !strings.Contains(command, "repro") {
+		errc <- nil
+		return inst.outc, errc, nil
+	}
+	inst.pool.mu.Lock()
+	inst.pool.runs[inst.index]++
+	inst.pool.mu.Unlock()
+	switch testSanitizerKernels[inst.index] {
+	case "plain":
+		inst.outc <- []byte("executing program\nBUG: unable to handle kernel paging request at 00000000deadbeef\n")
+	case "kasan":
+		inst.outc <- []byte("executing program\n" + testKasanReport)
+	default:
+		inst.outc <- []byte("executing program\n")
+		errc <- nil
+	}
+	return inst.outc, errc, nil
+}
+
+func init() {
+	sanitizerCtor := func(env *vmimpl.Env) (vmimpl.Pool, error) {
+		return &testSanitizerPool{runs: make(map[int]int)}, nil
+	}
+	vmimpl.Register("test-sanitizers", sanitizerCtor, false)
+}
+
+func TestRunOnSanitizers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-vm-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	repro := filepath.Join(dir, "repro")
+	if err := ioutil.WriteFile(repro, []byte("getpid()\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &mgrconfig.Config{
+		Workdir: dir,
+		Type:    "test-sanitizers",
+	}
+	pool, reporter := createTestPool(t, cfg)
+	opts := &SanitizerOptions{
+		ReplayOptions: ReplayOptions{
+			Files: []string{"/bin/syz-execprog"},
+			Command: func(files []string, prog string) string {
+				return files[0] + " " + prog
+			},
+			Timeout:  time.Minute,
+			Reporter: reporter,
+		},
+		Kernels:  []string{"plain", "kasan", "kmsan"},
+		Attempts: 2,
+	}
+	results, err := pool.RunOnSanitizers(repro, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %v results, want 3", len(results))
+	}
+	for i, res := range results {
+		if res.Kernel != opts.Kernels[i] {
+			t.Errorf("result %v is for kernel %v, want %v", i, res.Kernel, opts.Kernels[i])
+		}
+		if res.Err != nil {
+			t.Errorf("kernel %v: %v", res.Kernel, res.Err)
+		}
+	}
+	plain, kasan, kmsan := results[0], results[1], results[2]
+	if plain.Report == nil || plain.Sanitizer != "" ||
+		!strings.HasPrefix(plain.Report.Title, "BUG: unable to handle kernel paging request") {
+		t.Errorf("bad plain kernel result: %+v", plain)
+	}
+	if kasan.Report == nil || kasan.Sanitizer != "KASAN" ||
+		kasan.Report.Title != "KASAN: use-after-free Read in sanitized_free" {
+		t.Errorf("bad KASAN kernel result: %+v", kasan)
+	}
+	if kasan.Report != nil && !strings.Contains(string(kasan.Report.Report), "Read of size 8 at addr") {
+		t.Errorf("KASAN report lacks the bad access:\n%s", kasan.Report.Report)
+	}
+	if kmsan.Report != nil || kmsan.Sanitizer != "" {
+		t.Errorf("bad KMSAN kernel result: %+v", kmsan)
+	}
+	// Crashing kernels run the reproducer once, the KMSAN kernel runs it Attempts times.
+	runs := pool.impl.(*testSanitizerPool).runs
+	if runs[0] != 1 || runs[1] != 1 || runs[2] != 2 {
+		t.Errorf("bad number of runs per kernel: %v", runs)
+	}
+	if _, err := pool.RunOnSanitizers(repro, &SanitizerOptions{Kernels: []string{"kcsan"}}); err == nil {
+		t.Errorf("no error for unknown kernel")
+	}
+}
+
+func TestReportSanitizer(t *testing.T) {
+	tests := map[string]string{
+		"KASAN: use-after-free Read in foo": "KASAN",
+		"KMSAN: uninit-value in foo":        "KMSAN",
+		"UBSAN: shift-out-of-bounds in foo": "UBSAN",
+		"general protection fault in foo":   "",
+		"WARNING: KASAN: foo":               "",
+	}
+	for title, want := range tests {
+		if got := reportSanitizer(title); got != want {
+			t.Errorf("reportSanitizer(%q) = %q, want %q", title, got, want)
+		}
+	}
+}
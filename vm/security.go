@@ -0,0 +1,85 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/report"
+)
+
+// Security events (security_events config) are console output signatures of security-relevant
+// events that are not kernel crashes, e.g. a fuzzer process that has escaped into the host
+// network namespace or out of a container. The VM can't be trusted after that, so the run ends
+// with a report of report.ClassSecurityEvent class that is triaged separately from crashes.
+
+const SecurityEventPrefix = "SECURITY: "
+
+type securityEvent struct {
+	name string
+	re   *regexp.Regexp
+}
+
+func compileSecurityEvents(events []mgrconfig.SecurityEvent) ([]securityEvent, error) {
+	var compiled []securityEvent
+	for _, event := range events {
+		re, err := regexp.Compile(event.Regexp)
+		if err != nil {
+			return nil, fmt.Errorf("bad security event %q regexp: %v", event.Name, err)
+		}
+		compiled = append(compiled, securityEvent{event.Name, re})
+	}
+	return compiled, nil
+}
+
+// securityEvent returns a report for the first output line at or after matchPos that matches
+// a security event signature, or nil if there are no such lines.
+func (mon *monitor) securityEvent() *report.Report {
+	events := mon.inst.pool.securityEvents
+	if len(events) == 0 {
+		return nil
+	}
+	// Lines are matched from the beginning, so that signatures can be anchored with ^.
+	for pos := bytes.LastIndexByte(mon.output[:mon.matchPos], '\n') + 1; pos < len(mon.output); {
+		end := bytes.IndexByte(mon.output[pos:], '\n')
+		next := pos + end + 1
+		if end == -1 {
+			next = len(mon.output)
+		}
+		line := mon.output[pos:next]
+		for _, event := range events {
+			if event.re.Match(line) {
+				return mon.securityEventReport(event, pos)
+			}
+		}
+		pos = next
+	}
+	return nil
+}
+
+// securityEventReport creates the report for the event matched in the output line starting at start.
+func (mon *monitor) securityEventReport(event securityEvent, start int) *report.Report {
+	// Collect the rest of the output (including the rest of the line if it's not yet finished).
+	mon.waitForOutput()
+	end := len(mon.output)
+	if pos := bytes.IndexByte(mon.output[start:], '\n'); pos != -1 {
+		end = start + pos + 1
+	}
+	line := append([]byte{}, mon.output[start:end]...)
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		line = append(line, '\n')
+	}
+	from := max0(start - beforeContext)
+	return &report.Report{
+		Title:    SecurityEventPrefix + event.name,
+		Class:    report.ClassSecurityEvent,
+		Report:   line,
+		Output:   mon.output[from:],
+		StartPos: start - from,
+		EndPos:   end - from,
+	}
+}
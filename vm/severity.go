@@ -0,0 +1,55 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+)
+
+type severityRule struct {
+	title    *regexp.Regexp
+	severity string
+}
+
+// defaultSeverities are checked after the configured rules (see severities config).
+// Security events and memory corruptions are the most severe since they are likely exploitable,
+// warnings and hangs are the least severe since they are frequently benign or flaky.
+var defaultSeverities = []mgrconfig.SeverityRule{
+	{Title: `^` + regexp.QuoteMeta(SecurityEventPrefix), Severity: mgrconfig.SeverityCritical},
+	{Title: `^KASAN: (use-after-free|slab-out-of-bounds|out-of-bounds|double-free|invalid-free|wild-memory-access)`,
+		Severity: mgrconfig.SeverityCritical},
+	{Title: `^(KASAN|KMSAN|UBSAN): `, Severity: mgrconfig.SeverityHigh},
+	{Title: `^(general protection fault|BUG: unable to handle kernel|unable to handle kernel paging request|` +
+		`PANIC: double fault|BUG: corrupted list|BUG: bad usercopy|BUG: Bad page|BUG: Object already free)`,
+		Severity: mgrconfig.SeverityHigh},
+	{Title: `^(WARNING|INFO: (task hung|rcu detected stall)|BUG: soft lockup|memory leak)`,
+		Severity: mgrconfig.SeverityLow},
+	{Title: `^(` + regexp.QuoteMeta(noOutputCrash) + `|` + regexp.QuoteMeta(lostConnectionCrash) + `)$`,
+		Severity: mgrconfig.SeverityLow},
+}
+
+func compileSeverities(rules []mgrconfig.SeverityRule) ([]severityRule, error) {
+	var compiled []severityRule
+	for _, rule := range append(append([]mgrconfig.SeverityRule{}, rules...), defaultSeverities...) {
+		re, err := regexp.Compile(rule.Title)
+		if err != nil {
+			return nil, fmt.Errorf("bad severity rule %q: %v", rule.Title, err)
+		}
+		compiled = append(compiled, severityRule{re, rule.Severity})
+	}
+	return compiled, nil
+}
+
+// severity returns severity of crashes with the title, the first matching rule wins.
+func (pool *Pool) severity(title string) string {
+	for _, rule := range pool.severities {
+		if rule.title.MatchString(title) {
+			return rule.severity
+		}
+	}
+	return mgrconfig.SeverityMedium
+}
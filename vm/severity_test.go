@@ -0,0 +1,56 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+)
+
+func TestSeverity(t *testing.T) {
+	cfg := &mgrconfig.Config{
+		Severities: []mgrconfig.SeverityRule{
+			{Title: `^WARNING in important_func$`, Severity: mgrconfig.SeverityCritical},
+			{Title: `in noisy_driver`, Severity: mgrconfig.SeverityLow},
+		},
+	}
+	pool, reporter := createTestPool(t, cfg)
+	defer os.RemoveAll(cfg.Workdir)
+	tests := map[string]string{
+		"WARNING in important_func":                      mgrconfig.SeverityCritical,
+		"KASAN: use-after-free Read in noisy_driver":     mgrconfig.SeverityLow,
+		"KASAN: use-after-free Read in foo":              mgrconfig.SeverityCritical,
+		"KASAN: slab-out-of-bounds Write in foo":         mgrconfig.SeverityCritical,
+		"KMSAN: uninit-value in foo":                     mgrconfig.SeverityHigh,
+		"general protection fault in foo":                mgrconfig.SeverityHigh,
+		"WARNING in foo":                                 mgrconfig.SeverityLow,
+		"INFO: task hung in foo":                         mgrconfig.SeverityLow,
+		noOutputCrash:                                    mgrconfig.SeverityLow,
+		"possible deadlock in foo":                       mgrconfig.SeverityMedium,
+		"BUG: sleeping function called from invalid ctx": mgrconfig.SeverityMedium,
+	}
+	for title, want := range tests {
+		if got := pool.severity(title); got != want {
+			t.Errorf("severity of %q: got %v, want %v", title, got, want)
+		}
+	}
+
+	rep := runTestInstance(t, pool, reporter, false, func(inst *testInstance) {
+		inst.outc <- []byte("BUG: KASAN: use-after-free in foo+0x10/0x20\n" +
+			"Read of size 8 at addr ffff8880a5b0a0f8 by task syz-executor/1\n")
+	})
+	if rep == nil {
+		t.Fatalf("got no report")
+	}
+	if rep.Severity != mgrconfig.SeverityCritical {
+		t.Fatalf("report %q got severity %q, want %q", rep.Title, rep.Severity, mgrconfig.SeverityCritical)
+	}
+
+	cfg.Severities = []mgrconfig.SeverityRule{{Title: `(`, Severity: mgrconfig.SeverityLow}}
+	if _, err := Create(cfg, false); err == nil {
+		t.Fatalf("bad severity regexp is accepted")
+	}
+}
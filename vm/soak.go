@@ -0,0 +1,302 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+// SoakOptions describe a stress test of VM creation and destruction (see Pool.Soak).
+type SoakOptions struct {
+	// Number of create/close cycles of each VM, 0 means unlimited.
+	Cycles int
+	// Time limit of the test, 0 means unlimited. At least one of Cycles and Duration must be set.
+	Duration time.Duration
+	// Indexes of VMs that are cycled in parallel (default: all VMs of the pool).
+	Indexes []int
+}
+
+// SoakResult is the summary of a soak test.
+type SoakResult struct {
+	Cycles   int           // number of finished create/close cycles (including failed ones)
+	Failures int           // number of cycles where VM creation failed
+	Errors   []string      // first maxSoakErrors creation errors
+	Duration time.Duration // total time of the test
+	Create   LatencyStats  // latency of successful VM creation
+	Close    LatencyStats  // latency of VM destruction
+	Before   HostResources // host resources after the warm-up cycle
+	After    HostResources // host resources after the last cycle
+}
+
+// LatencyStats summarize a latency distribution.
+type LatencyStats struct {
+	Count  int
+	Min    time.Duration
+	Median time.Duration
+	P90    time.Duration
+	Max    time.Duration
+}
+
+// HostResources are host resources that VMs can leak. Values are -1 if they can't be measured on the host.
+type HostResources struct {
+	FDs        int // open file descriptors of this process
+	Processes  int // child processes of this process
+	TempFiles  int // files and dirs in the manager workdir
+	Goroutines int
+}
+
+const maxSoakErrors = 10
+
+var (
+	// How long Soak waits for resources of closed VMs to be released
+	// before it considers them leaked (e.g. for VM processes to be reaped).
+	soakSettleTimeout = 10 * time.Second
+	soakSettlePeriod  = 100 * time.Millisecond
+)
+
+// Soak repeatedly creates and closes VMs to shake out leaks and flakiness of the VM backend.
+// The first cycle of the first VM is a warm-up (backends may create long-lived resources lazily,
+// e.g. shared images): host resources are sampled after it and again after the last cycle.
+// Soak fails only if the warm-up cycle fails, failures of subsequent cycles are counted in the result.
+func (pool *Pool) Soak(opts *SoakOptions) (*SoakResult, error) {
+	if opts.Cycles <= 0 && opts.Duration <= 0 {
+		return nil, fmt.Errorf("soak test needs either cycles or duration")
+	}
+	indexes := opts.Indexes
+	if len(indexes) == 0 {
+		for i := 0; i < pool.Count(); i++ {
+			indexes = append(indexes, i)
+		}
+	}
+	for _, index := range indexes {
+		if index < 0 || index >= pool.Count() {
+			return nil, fmt.Errorf("invalid VM index %v (count %v)", index, pool.Count())
+		}
+	}
+	inst, err := pool.Create(indexes[0])
+	if err != nil {
+		return nil, fmt.Errorf("warm-up VM creation failed: %v", err)
+	}
+	inst.Close()
+	s := &soak{
+		pool:   pool,
+		res:    &SoakResult{Before: pool.hostResources()},
+		cycles: opts.Cycles,
+		stop:   make(chan bool),
+	}
+	start := time.Now()
+	if opts.Duration > 0 {
+		timer := time.AfterFunc(opts.Duration, func() { close(s.stop) })
+		defer timer.Stop()
+	}
+	var wg sync.WaitGroup
+	for _, index := range indexes {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			s.loop(index)
+		}(index)
+	}
+	wg.Wait()
+	s.res.Duration = time.Since(start)
+	s.res.Create = latencyStats(s.create)
+	s.res.Close = latencyStats(s.close)
+	s.res.After = pool.settledResources(s.res.Before)
+	return s.res, nil
+}
+
+type soak struct {
+	pool   *Pool
+	cycles int
+	stop   chan bool
+
+	mu     sync.Mutex
+	res    *SoakResult
+	create []time.Duration
+	close  []time.Duration
+}
+
+func (s *soak) loop(index int) {
+	for cycle := 0; s.cycles <= 0 || cycle < s.cycles; cycle++ {
+		select {
+		case <-s.stop:
+			return
+		case <-vmimpl.Shutdown:
+			return
+		default:
+		}
+		start := time.Now()
+		inst, err := s.pool.Create(index)
+		created := time.Since(start)
+		var closed time.Duration
+		if err == nil {
+			start = time.Now()
+			inst.Close()
+			closed = time.Since(start)
+		}
+		s.mu.Lock()
+		s.res.Cycles++
+		if err != nil {
+			log.Logf(1, "vm-%v: soak cycle %v failed: %v", index, cycle, err)
+			s.res.Failures++
+			if len(s.res.Errors) < maxSoakErrors {
+				s.res.Errors = append(s.res.Errors, fmt.Sprintf("vm-%v: %v", index, err))
+			}
+		} else {
+			s.create = append(s.create, created)
+			s.close = append(s.close, closed)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// settledResources waits for host resources to drop to the baseline
+// and returns the last sample if they don't within soakSettleTimeout.
+func (pool *Pool) settledResources(baseline HostResources) HostResources {
+	deadline := time.Now().Add(soakSettleTimeout)
+	for {
+		res := pool.hostResources()
+		if len(res.grown(baseline)) == 0 || time.Now().After(deadline) {
+			return res
+		}
+		time.Sleep(soakSettlePeriod)
+	}
+}
+
+func (pool *Pool) hostResources() HostResources {
+	res := HostResources{
+		FDs:        -1,
+		Processes:  -1,
+		TempFiles:  -1,
+		Goroutines: runtime.NumGoroutine(),
+	}
+	if fds, err := ioutil.ReadDir("/proc/self/fd"); err == nil {
+		res.FDs = len(fds)
+	}
+	if procs, err := childProcesses(); err == nil {
+		res.Processes = procs
+	}
+	if pool.workdir != "" {
+		files := 0
+		err := filepath.Walk(pool.workdir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				// Files of concurrently closed VMs disappear while we walk.
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			files++
+			return nil
+		})
+		if err == nil {
+			res.TempFiles = files
+		}
+	}
+	return res
+}
+
+// childProcesses returns the number of child processes of this process.
+func childProcesses() (int, error) {
+	dirs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return 0, err
+	}
+	self := os.Getpid()
+	procs := 0
+	for _, dir := range dirs {
+		if _, err := strconv.Atoi(dir.Name()); err != nil {
+			continue
+		}
+		stat, err := ioutil.ReadFile(filepath.Join("/proc", dir.Name(), "stat"))
+		if err != nil {
+			continue // the process has exited
+		}
+		// The format is "pid (comm) state ppid ...", comm can contain spaces and parens.
+		pos := bytes.LastIndexByte(stat, ')')
+		if pos == -1 {
+			continue
+		}
+		fields := bytes.Fields(stat[pos+1:])
+		if len(fields) < 2 {
+			continue
+		}
+		if ppid, err := strconv.Atoi(string(fields[1])); err == nil && ppid == self {
+			procs++
+		}
+	}
+	return procs, nil
+}
+
+// grown returns descriptions of resources that grew since baseline.
+func (res HostResources) grown(baseline HostResources) []string {
+	var grown []string
+	check := func(what string, before, after int) {
+		if before >= 0 && after > before {
+			grown = append(grown, fmt.Sprintf("%v: %v -> %v", what, before, after))
+		}
+	}
+	check("fds", baseline.FDs, res.FDs)
+	check("processes", baseline.Processes, res.Processes)
+	check("temp files", baseline.TempFiles, res.TempFiles)
+	check("goroutines", baseline.Goroutines, res.Goroutines)
+	return grown
+}
+
+// Leaks returns descriptions of host resources that were leaked during the test (e.g. "fds: 10 -> 20").
+func (res *SoakResult) Leaks() []string {
+	return res.After.grown(res.Before)
+}
+
+func (res *SoakResult) String() string {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "cycles: %v, failures: %v, duration: %v\n",
+		res.Cycles, res.Failures, res.Duration.Round(time.Millisecond))
+	fmt.Fprintf(buf, "create latency: %v\n", res.Create)
+	fmt.Fprintf(buf, "close latency: %v\n", res.Close)
+	fmt.Fprintf(buf, "host resources: fds %v -> %v, processes %v -> %v, temp files %v -> %v, goroutines %v -> %v\n",
+		res.Before.FDs, res.After.FDs, res.Before.Processes, res.After.Processes,
+		res.Before.TempFiles, res.After.TempFiles, res.Before.Goroutines, res.After.Goroutines)
+	for _, leak := range res.Leaks() {
+		fmt.Fprintf(buf, "leaked %v\n", leak)
+	}
+	for _, err := range res.Errors {
+		fmt.Fprintf(buf, "error: %v\n", err)
+	}
+	return buf.String()
+}
+
+func latencyStats(samples []time.Duration) LatencyStats {
+	if len(samples) == 0 {
+		return LatencyStats{}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return LatencyStats{
+		Count:  len(samples),
+		Min:    samples[0],
+		Median: samples[len(samples)/2],
+		P90:    samples[len(samples)*9/10],
+		Max:    samples[len(samples)-1],
+	}
+}
+
+func (stats LatencyStats) String() string {
+	if stats.Count == 0 {
+		return "no samples"
+	}
+	return fmt.Sprintf("min %v, median %v, p90 %v, max %v",
+		stats.Min, stats.Median, stats.P90, stats.Max)
+}
@@ -0,0 +1,133 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+// testLeakyPool creates VMs that leak an open file each and fails every 5th creation.
+type testLeakyPool struct {
+	testPool
+	mu      sync.Mutex
+	created int
+	leaked  []*os.File
+}
+
+func (pool *testLeakyPool) Create(workdir string, index int) (vmimpl.Instance, error) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.created++
+	if pool.created%5 == 0 {
+		return nil, fmt.Errorf("failed to boot")
+	}
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		return nil, err
+	}
+	pool.leaked = append(pool.leaked, f)
+	return pool.testPool.Create(workdir, index)
+}
+
+func init() {
+	leakyCtor := func(env *vmimpl.Env) (vmimpl.Pool, error) {
+		return &testLeakyPool{}, nil
+	}
+	vmimpl.Register("test-leaky", leakyCtor, false)
+}
+
+func TestSoak(t *testing.T) {
+	cfg := &mgrconfig.Config{}
+	pool, _ := createTestPool(t, cfg)
+	defer os.RemoveAll(cfg.Workdir)
+	res, err := pool.Soak(&SoakOptions{Cycles: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%v", res)
+	if res.Cycles != 500 || res.Failures != 0 || len(res.Errors) != 0 {
+		t.Errorf("bad result: %+v", res)
+	}
+	if res.Create.Count != 500 || res.Close.Count != 500 ||
+		res.Create.Min > res.Create.Median || res.Create.Median > res.Create.P90 ||
+		res.Create.P90 > res.Create.Max {
+		t.Errorf("bad latencies: create %+v, close %+v", res.Create, res.Close)
+	}
+	if runtime.GOOS == "linux" && (res.Before.FDs <= 0 || res.Before.TempFiles <= 0) {
+		t.Errorf("host resources are not measured: %+v", res.Before)
+	}
+	if leaks := res.Leaks(); len(leaks) != 0 {
+		t.Errorf("test VMs leaked resources: %v", leaks)
+	}
+	if _, err := pool.Soak(&SoakOptions{}); err == nil {
+		t.Errorf("no error for unlimited soak test")
+	}
+	if _, err := pool.Soak(&SoakOptions{Cycles: 1, Indexes: []int{1}}); err == nil {
+		t.Errorf("no error for invalid VM index")
+	}
+}
+
+func TestSoakDuration(t *testing.T) {
+	cfg := &mgrconfig.Config{}
+	pool, _ := createTestPool(t, cfg)
+	defer os.RemoveAll(cfg.Workdir)
+	start := time.Now()
+	res, err := pool.Soak(&SoakOptions{Duration: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("soak test took %v", elapsed)
+	}
+	if res.Cycles == 0 || res.Failures != 0 {
+		t.Errorf("bad result: %+v", res)
+	}
+}
+
+func TestSoakLeaks(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fds are measured only on linux")
+	}
+	defer func(timeout time.Duration) {
+		soakSettleTimeout = timeout
+	}(soakSettleTimeout)
+	soakSettleTimeout = 0
+	cfg := &mgrconfig.Config{Type: "test-leaky"}
+	pool, _ := createTestPool(t, cfg)
+	defer os.RemoveAll(cfg.Workdir)
+	defer func() {
+		for _, f := range pool.impl.(*testLeakyPool).leaked {
+			f.Close()
+		}
+	}()
+	res, err := pool.Soak(&SoakOptions{Cycles: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The warm-up cycle is the 1st creation, so every 5th of the 50 cycles fails.
+	if res.Cycles != 50 || res.Failures != 10 || len(res.Errors) != maxSoakErrors ||
+		res.Errors[0] != "vm-0: failed to boot" {
+		t.Errorf("bad result: %+v", res)
+	}
+	if res.Create.Count != 40 {
+		t.Errorf("bad create latencies: %+v", res.Create)
+	}
+	leaks := res.Leaks()
+	if len(leaks) != 1 || !strings.HasPrefix(leaks[0], "fds: ") ||
+		res.After.FDs-res.Before.FDs != 40 {
+		t.Errorf("fd leak is not detected: %v (%+v -> %+v)", leaks, res.Before, res.After)
+	}
+	if !strings.Contains(res.String(), "leaked fds: ") {
+		t.Errorf("summary does not mention the leak:\n%v", res)
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/syzkaller/pkg/report"
+)
+
+// Timed console logs (timed_console_log config) keep console output of a run together with the time
+// each chunk of output arrived, so that the run can be replayed through MonitorExecution at the original
+// or accelerated speed (see MonitorTimedConsole). A log is timedConsoleMagic followed by frames:
+//	kind   uint8      - timedOutput, timedDiagnose, timedExit or timedTimeout
+//	offset uint64     - time since the start of the run in nanoseconds
+//	size   uint32     - size of data
+//	data   [size]byte - console output (timedOutput) or the command error (timedExit, empty on normal exit)
+// Integers are big endian. A log without an exit frame means that the run was stopped by the monitor
+// (e.g. a crash was detected).
+
+const timedConsoleMagic = "SYZTCON1"
+
+const (
+	timedOutput   = iota
+	timedDiagnose // the monitor called Diagnose
+	timedExit
+	timedTimeout
+)
+
+const (
+	timedFrameHeader = 1 + 8 + 4
+	// Recorders keep only the most recent frames of that total size.
+	maxTimedConsoleSize = 16 << 20
+	// How long a replayed Diagnose waits for the replay to reach the recorded Diagnose call.
+	timedDiagnoseTimeout = time.Second
+)
+
+// TimedFrame is a single frame of a timed console log.
+type TimedFrame struct {
+	Offset   time.Duration // since the start of the run
+	Output   []byte        // console output
+	Diagnose bool          // the monitor called Diagnose at this point
+	Exit     bool          // the command has finished with Err
+	Err      error         // nil if the command exited normally, ErrTimeout on timeout
+}
+
+func (frame *TimedFrame) data() (byte, []byte) {
+	switch {
+	case frame.Diagnose:
+		return timedDiagnose, nil
+	case frame.Exit && frame.Err == ErrTimeout:
+		return timedTimeout, nil
+	case frame.Exit && frame.Err != nil:
+		return timedExit, []byte(frame.Err.Error())
+	case frame.Exit:
+		return timedExit, nil
+	default:
+		return timedOutput, frame.Output
+	}
+}
+
+// SerializeTimedConsole encodes frames as a timed console log.
+func SerializeTimedConsole(frames []*TimedFrame) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString(timedConsoleMagic)
+	for _, frame := range frames {
+		kind, data := frame.data()
+		var hdr [timedFrameHeader]byte
+		hdr[0] = kind
+		binary.BigEndian.PutUint64(hdr[1:], uint64(frame.Offset))
+		binary.BigEndian.PutUint32(hdr[9:], uint32(len(data)))
+		buf.Write(hdr[:])
+		buf.Write(data)
+	}
+	return buf.Bytes()
+}
+
+// ParseTimedConsole decodes a timed console log.
+func ParseTimedConsole(data []byte) ([]*TimedFrame, error) {
+	if !bytes.HasPrefix(data, []byte(timedConsoleMagic)) {
+		return nil, fmt.Errorf("not a timed console log")
+	}
+	data = data[len(timedConsoleMagic):]
+	var frames []*TimedFrame
+	for len(data) != 0 {
+		if len(data) < timedFrameHeader {
+			return nil, fmt.Errorf("truncated header of frame %v", len(frames))
+		}
+		kind := data[0]
+		frame := &TimedFrame{Offset: time.Duration(binary.BigEndian.Uint64(data[1:]))}
+		size := int(binary.BigEndian.Uint32(data[9:]))
+		data = data[timedFrameHeader:]
+		if len(data) < size {
+			return nil, fmt.Errorf("truncated data of frame %v", len(frames))
+		}
+		payload := data[:size]
+		data = data[size:]
+		switch kind {
+		case timedOutput:
+			frame.Output = payload
+		case timedDiagnose:
+			frame.Diagnose = true
+		case timedExit:
+			frame.Exit = true
+			if len(payload) != 0 {
+				frame.Err = errors.New(string(payload))
+			}
+		case timedTimeout:
+			frame.Exit = true
+			frame.Err = ErrTimeout
+		default:
+			return nil, fmt.Errorf("unknown kind %v of frame %v", kind, len(frames))
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+// consoleRecorder records console output of a run as it's received by the monitor.
+// Methods of a nil recorder do nothing (timed_console_log is not configured).
+type consoleRecorder struct {
+	start  time.Time
+	frames []*TimedFrame
+	size   int
+}
+
+func newConsoleRecorder(start time.Time) *consoleRecorder {
+	return &consoleRecorder{start: start}
+}
+
+func (rec *consoleRecorder) output(out []byte) {
+	if rec == nil || len(out) == 0 {
+		return
+	}
+	rec.add(&TimedFrame{Output: append([]byte{}, out...)})
+}
+
+func (rec *consoleRecorder) diagnose() {
+	if rec == nil {
+		return
+	}
+	rec.add(&TimedFrame{Diagnose: true})
+}
+
+func (rec *consoleRecorder) exit(err error) {
+	if rec == nil {
+		return
+	}
+	rec.add(&TimedFrame{Exit: true, Err: err})
+}
+
+func (rec *consoleRecorder) add(frame *TimedFrame) {
+	frame.Offset = time.Since(rec.start)
+	rec.frames = append(rec.frames, frame)
+	rec.size += timedFrameSize(frame)
+	for rec.size > maxTimedConsoleSize && len(rec.frames) > 1 {
+		rec.size -= timedFrameSize(rec.frames[0])
+		rec.frames = rec.frames[1:]
+	}
+}
+
+func (rec *consoleRecorder) serialize() []byte {
+	if rec == nil {
+		return nil
+	}
+	return SerializeTimedConsole(rec.frames)
+}
+
+func timedFrameSize(frame *TimedFrame) int {
+	_, data := frame.data()
+	return timedFrameHeader + len(data)
+}
+
+// MonitorTimedConsole replays a timed console log through MonitorExecution with console monitoring
+// configuration of the pool (dedup, preemption markers, warnings, severities, security events)
+// and returns the detected report, or nil if the replayed run has finished without errors.
+// Speed is relative to the original timing (e.g. 10 replays 10 times faster), 0 replays without delays.
+// Timing-dependent detection (no output hangs) is reproduced only at the original speed.
+// Pool side effects (report log, crash bundles, pstore, memory state) are not involved.
+func (pool *Pool) MonitorTimedConsole(data []byte, speed float64, reporter report.Reporter,
+	canExit bool) (*report.Report, error) {
+	frames, err := ParseTimedConsole(data)
+	if err != nil {
+		return nil, err
+	}
+	replay := &Pool{
+		typ:            pool.typ,
+		name:           pool.name,
+		os:             pool.os,
+		dedupOutput:    pool.dedupOutput,
+		preempted:      pool.preempted,
+		timeouts:       pool.timeouts,
+		warnings:       pool.warnings,
+		warnState:      make(map[string]*warningState),
+		severities:     pool.severities,
+		securityEvents: pool.securityEvents,
+		unrecognized:   pool.unrecognized,
+	}
+	impl := &timedReplayInstance{
+		frames:    frames,
+		speed:     speed,
+		diagnosed: make(chan bool),
+		stop:      make(chan bool),
+	}
+	defer impl.Close()
+	inst := &Instance{
+		impl:        impl,
+		pool:        replay,
+		index:       -1,
+		dedupOutput: replay.dedupOutput,
+	}
+	outc, errc, err := impl.Run(0, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return inst.MonitorExecution(outc, errc, reporter, canExit), nil
+}
+
+// timedReplayInstance is a VM instance that replays a timed console log.
+// Recorded Diagnose calls are matched with Diagnose calls of the replay monitor,
+// so that Diagnose output is split off the same way as in the original run.
+type timedReplayInstance struct {
+	frames    []*TimedFrame
+	speed     float64
+	diagnosed chan bool
+	stop      chan bool
+}
+
+func (inst *timedReplayInstance) Copy(hostSrc string) (string, error) {
+	return "", fmt.Errorf("can't copy files into a replayed console")
+}
+
+func (inst *timedReplayInstance) Forward(port int) (string, error) {
+	return "", fmt.Errorf("can't forward ports of a replayed console")
+}
+
+func (inst *timedReplayInstance) Run(timeout time.Duration, stop <-chan bool, command string) (
+	<-chan []byte, <-chan error, error) {
+	outc := make(chan []byte)
+	errc := make(chan error, 1)
+	go inst.replay(outc, errc)
+	return outc, errc, nil
+}
+
+func (inst *timedReplayInstance) replay(outc chan<- []byte, errc chan<- error) {
+	start := time.Now()
+	var base time.Duration
+	if len(inst.frames) != 0 {
+		base = inst.frames[0].Offset
+	}
+	for _, frame := range inst.frames {
+		if frame.Diagnose {
+			select {
+			case <-inst.diagnosed:
+				continue
+			case <-inst.stop:
+				return
+			}
+		}
+		if inst.speed > 0 {
+			due := start.Add(time.Duration(float64(frame.Offset-base) / inst.speed))
+			timer := time.NewTimer(time.Until(due))
+			select {
+			case <-timer.C:
+			case <-inst.stop:
+				timer.Stop()
+				return
+			}
+		}
+		if frame.Exit {
+			errc <- frame.Err
+			continue
+		}
+		select {
+		case outc <- frame.Output:
+		case <-inst.stop:
+			return
+		}
+	}
+	close(outc)
+}
+
+func (inst *timedReplayInstance) Diagnose() bool {
+	timer := time.NewTimer(timedDiagnoseTimeout)
+	defer timer.Stop()
+	select {
+	case inst.diagnosed <- true:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (inst *timedReplayInstance) Close() {
+	close(inst.stop)
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+)
+
+func TestTimedConsoleFormat(t *testing.T) {
+	frames := []*TimedFrame{
+		{Offset: 0, Output: []byte("foo\n")},
+		{Offset: time.Second, Diagnose: true},
+		{Offset: 2 * time.Second, Output: []byte("bar\n")},
+		{Offset: 3 * time.Second, Exit: true, Err: errors.New("lost connection")},
+		{Offset: 4 * time.Second, Exit: true, Err: ErrTimeout},
+		{Offset: 5 * time.Second, Exit: true},
+	}
+	data := SerializeTimedConsole(frames)
+	got, err := ParseTimedConsole(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(frames) {
+		t.Fatalf("got %v frames, want %v", len(got), len(frames))
+	}
+	for i, frame := range frames {
+		if got[i].Offset != frame.Offset || !bytes.Equal(got[i].Output, frame.Output) ||
+			got[i].Diagnose != frame.Diagnose || got[i].Exit != frame.Exit ||
+			fmt.Sprint(got[i].Err) != fmt.Sprint(frame.Err) {
+			t.Fatalf("frame %v: got %+v, want %+v", i, got[i], frame)
+		}
+	}
+	if got[4].Err != ErrTimeout {
+		t.Fatalf("timeout is not decoded as ErrTimeout: %v", got[4].Err)
+	}
+	if _, err := ParseTimedConsole(data[:len(data)-1]); err == nil {
+		t.Fatalf("parsed a truncated log")
+	}
+	if _, err := ParseTimedConsole([]byte("foo\n")); err == nil {
+		t.Fatalf("parsed a text log")
+	}
+}
+
+func TestTimedConsoleReplay(t *testing.T) {
+	for _, test := range tests {
+		switch test.Name {
+		case "#875-diagnose-bugs-2", "kernel-crashes", "dedup-output":
+		default:
+			continue
+		}
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+			testTimedConsoleReplay(t, test)
+		})
+	}
+}
+
+func testTimedConsoleReplay(t *testing.T, test *Test) {
+	cfg := &mgrconfig.Config{
+		DedupOutput:     test.DedupOutput,
+		TimedConsoleLog: true,
+	}
+	pool, reporter := createTestPool(t, cfg)
+	defer os.RemoveAll(cfg.Workdir)
+	inst, err := pool.Create(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inst.Close()
+	outc, errc, err := inst.Run(time.Second, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testInst := inst.impl.(*testInstance)
+	done := make(chan bool)
+	go func() {
+		test.Body(testInst.outc, testInst.errc)
+		done <- true
+	}()
+	want := inst.MonitorExecution(outc, errc, reporter, test.CanExit)
+	<-done
+	if want == nil || len(want.TimedConsole) == 0 {
+		t.Fatalf("got no report with timed console: %+v", want)
+	}
+	for _, speed := range []float64{0, 10} {
+		rep, err := pool.MonitorTimedConsole(want.TimedConsole, speed, reporter, test.CanExit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep == nil {
+			t.Fatalf("speed %v: got no report", speed)
+		}
+		if rep.Title != want.Title || !bytes.Equal(rep.Report, want.Report) ||
+			!bytes.Equal(rep.Output, want.Output) || !bytes.Equal(rep.Diagnosis, want.Diagnosis) {
+			t.Fatalf("speed %v: replayed report differs:\n%q\n%s\n%q\n\nwant:\n%q\n%s\n%q",
+				speed, rep.Title, rep.Report, rep.Diagnosis, want.Title, want.Report, want.Diagnosis)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/report"
+)
+
+// Detection of crashes the reporter does not recognize (unrecognized_crashes config).
+// Sometimes output clearly looks like a crash (register dumps, RIP: lines, stack traces),
+// but the reporter does not know its header (e.g. a new oops format or a garbled first line).
+// Such output is reported with a generic, but stable title and the suspicious block of output
+// as the report, so that the crash is not lost and the reporter can be taught about it later.
+
+const unrecognizedCrash = "unrecognized crash"
+
+type unrecognizedCheck struct {
+	threshold int
+	markers   []*regexp.Regexp
+}
+
+var defaultUnrecognizedMarkers = []string{
+	`\bRIP: [0-9a-f]{4}:`,            // x86 instruction pointer
+	`\bR[ABCD]X: [0-9a-f]{16}`,       // x86 general purpose registers
+	`\bpc : \S+\+0x`,                 // arm64 instruction pointer
+	`\bx[0-9]+ ?: [0-9a-f]{16}`,      // arm64 general purpose registers
+	`Call [Tt]race:`,                 // stack trace
+	`\bCode: (\(?[0-9a-f]{2,8}\)? )`, // instruction bytes
+}
+
+const (
+	// Markers must match within that many consecutive lines.
+	unrecognizedLines = 64
+	// The block includes up to that many lines after the last matching line (e.g. stack frames).
+	unrecognizedTrail = 16
+	// Only that much of the latest output is checked for unrecognized crashes.
+	unrecognizedScan = 16 << 10
+)
+
+// compileUnrecognizedCheck returns nil if the check is not configured.
+func compileUnrecognizedCheck(cfg mgrconfig.UnrecognizedCrashes) (*unrecognizedCheck, error) {
+	if cfg.Threshold == 0 {
+		return nil, nil
+	}
+	markers := cfg.Markers
+	if len(markers) == 0 {
+		markers = defaultUnrecognizedMarkers
+	}
+	if cfg.Threshold > len(markers) {
+		return nil, fmt.Errorf("unrecognized_crashes threshold %v is larger than the number of markers %v",
+			cfg.Threshold, len(markers))
+	}
+	check := &unrecognizedCheck{threshold: cfg.Threshold}
+	for _, marker := range markers {
+		re, err := regexp.Compile(marker)
+		if err != nil {
+			return nil, fmt.Errorf("bad unrecognized_crashes marker %q: %v", marker, err)
+		}
+		check.markers = append(check.markers, re)
+	}
+	return check, nil
+}
+
+// match returns the block of output lines that looks like a crash: it starts with the first line
+// of a group of lines matching at least threshold different markers within unrecognizedLines lines,
+// and ends unrecognizedTrail lines (or at an empty line) after the last matching line that follows
+// the group closely.
+func (check *unrecognizedCheck) match(output []byte) (start, end int, ok bool) {
+	type hit struct {
+		marker, line, start int
+	}
+	var hits []hit
+	found := false
+	lastLine := 0
+	for pos, line := 0, 0; pos < len(output); line++ {
+		next := bytes.IndexByte(output[pos:], '\n') + pos + 1
+		if next == pos {
+			next = len(output)
+		}
+		if found && line-lastLine > unrecognizedLines {
+			break
+		}
+		for i, re := range check.markers {
+			if !re.Match(output[pos:next]) {
+				continue
+			}
+			lastLine, end = line, next
+			if found {
+				break
+			}
+			for len(hits) != 0 && line-hits[0].line > unrecognizedLines {
+				hits = hits[1:]
+			}
+			hits = append(hits, hit{i, line, pos})
+			distinct := make(map[int]bool)
+			for _, h := range hits {
+				distinct[h.marker] = true
+			}
+			if len(distinct) >= check.threshold {
+				found, start = true, hits[0].start
+			}
+			break
+		}
+		pos = next
+	}
+	if !found {
+		return 0, 0, false
+	}
+	for i := 0; i < unrecognizedTrail && end < len(output); i++ {
+		next := bytes.IndexByte(output[end:], '\n') + end + 1
+		if next == end {
+			next = len(output)
+		}
+		if len(bytes.TrimSpace(output[end:next])) == 0 {
+			break
+		}
+		end = next
+	}
+	return start, end, true
+}
+
+// unrecognizedCrash is called after every chunk of output that does not contain a crash recognized
+// by the reporter. If the latest output looks like a crash, it waits for the rest of the crash
+// and returns a report for it (if the reporter still does not recognize it), or nil otherwise.
+func (mon *monitor) unrecognizedCrash() *report.Report {
+	if mon.inst.pool.unrecognized == nil || !mon.warnWait.IsZero() {
+		return nil
+	}
+	from := mon.unrecognizedPos()
+	if _, _, ok := mon.inst.pool.unrecognized.match(mon.output[from:]); !ok {
+		return nil
+	}
+	mon.waitForOutput()
+	if mon.reporter.ContainsCrash(mon.output[mon.matchPos:]) {
+		return mon.extractError("unknown error")
+	}
+	rep := mon.unrecognizedReport()
+	if rep != nil {
+		rep.Suppressed = report.IsSuppressed(mon.reporter, mon.output)
+	}
+	return rep
+}
+
+// unrecognizedReport returns a report for the latest output that looks like a crash, or nil.
+func (mon *monitor) unrecognizedReport() *report.Report {
+	if mon.inst.pool.unrecognized == nil {
+		return nil
+	}
+	from := mon.unrecognizedPos()
+	start, end, ok := mon.inst.pool.unrecognized.match(mon.output[from:])
+	if !ok {
+		return nil
+	}
+	start += from
+	end += from
+	if mon.diagPos > start && mon.diagPos < end {
+		// Diagnose output is not a part of the crash.
+		end = mon.diagPos
+	}
+	block := append([]byte{}, mon.output[start:end]...)
+	if block[len(block)-1] != '\n' {
+		block = append(block, '\n')
+	}
+	outStart := max0(start - beforeContext)
+	outEnd := end + afterContext
+	if outEnd > len(mon.output) {
+		outEnd = len(mon.output)
+	}
+	rep := &report.Report{
+		Title:    unrecognizedCrash,
+		Report:   block,
+		Output:   mon.output[outStart:outEnd],
+		StartPos: start - outStart,
+		EndPos:   end - outStart,
+	}
+	if mon.beforeExec(start) {
+		rep.Class = report.ClassBootWarning
+	}
+	return rep
+}
+
+// unrecognizedPos returns the start of the line where the check of the latest output starts
+// (output before skipPos was already handled as non-fatal warnings).
+func (mon *monitor) unrecognizedPos() int {
+	from := max0(len(mon.output) - unrecognizedScan)
+	if from < mon.skipPos {
+		from = mon.skipPos
+	}
+	if from == 0 {
+		return 0
+	}
+	return bytes.LastIndexByte(mon.output[:from], '\n') + 1
+}
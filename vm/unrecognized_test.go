@@ -0,0 +1,58 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+)
+
+func TestUnrecognizedCheck(t *testing.T) {
+	if check, err := compileUnrecognizedCheck(mgrconfig.UnrecognizedCrashes{}); check != nil || err != nil {
+		t.Fatalf("disabled check is compiled: %v, %v", check, err)
+	}
+	if _, err := compileUnrecognizedCheck(mgrconfig.UnrecognizedCrashes{Threshold: 1,
+		Markers: []string{"("}}); err == nil {
+		t.Fatalf("bad regexp is accepted")
+	}
+	if _, err := compileUnrecognizedCheck(mgrconfig.UnrecognizedCrashes{Threshold: 2,
+		Markers: []string{"foo"}}); err == nil {
+		t.Fatalf("unreachable threshold is accepted")
+	}
+	check, err := compileUnrecognizedCheck(mgrconfig.UnrecognizedCrashes{Threshold: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	arm64Dump := "pc : mystery_func+0x1c/0x40\n" +
+		"lr : mystery_caller+0x12/0x30\n" +
+		"x29: ffff80001b3d7b50 x28: ffff000012340000\n" +
+		"Call trace:\n" +
+		" mystery_func+0x1c/0x40\n"
+	tests := []struct {
+		output string
+		block  string // expected block, empty if the output does not look like a crash
+	}{
+		{"", ""},
+		{"some output\n", ""},
+		{"before\n" + testRegisterDump + "\nafter\n", testRegisterDump},
+		{"before\n" + arm64Dump, arm64Dump},
+		// Markers must be close to each other.
+		{"RIP: 0010:foo+0x1/0x2\n" + strings.Repeat("filler\n", unrecognizedLines+1) +
+			"RAX: 0000000000000000 RBX: 0000000000000000\n" + "Call Trace:\n", ""},
+		// Repeats of the same marker are not different markers.
+		{"Call Trace:\nCall Trace:\nCall Trace:\n", ""},
+	}
+	for i, test := range tests {
+		start, end, ok := check.match([]byte(test.output))
+		if ok != (test.block != "") {
+			t.Errorf("#%v: matched %v, want %v", i, ok, !ok)
+			continue
+		}
+		if ok && test.output[start:end] != test.block {
+			t.Errorf("#%v: got block:\n%s\nwant:\n%s", i, test.output[start:end], test.block)
+		}
+	}
+}
@@ -12,8 +12,12 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/syzkaller/pkg/log"
 	"github.com/google/syzkaller/pkg/mgrconfig"
 	"github.com/google/syzkaller/pkg/osutil"
 	"github.com/google/syzkaller/pkg/report"
@@ -31,19 +35,74 @@ import (
 )
 
 type Pool struct {
-	impl    vmimpl.Pool
-	workdir string
+	impl           vmimpl.Pool
+	typ            string
+	name           string
+	image          string
+	os             string
+	workdir        string
+	dedupOutput    bool
+	readPstore     bool
+	crashMemState  bool
+	crashFtrace    bool
+	crashCmdline   bool
+	verifyForward  bool
+	outputFilter   func(line []byte) []byte // see vmimpl.OutputFilterer
+	timedConsole   bool
+	leakWatch      mgrconfig.LeakWatch
+	preempted      [][]byte        // console output markers of fuzzer preemption
+	timeouts       monitorTimeouts // timeouts of MonitorExecution
+	executor       string          // host executor binary
+	sharedExecutor string          // host executor binary that is shared between VMs (if any)
+
+	shareMu  sync.Mutex
+	shared   map[string]string // host file -> shared file in VM
+	shareErr error
+
+	bundle    mgrconfig.BundleCrashes
+	bundleMu  sync.Mutex
+	bundleSeq int // next crash bundle number to try
+
+	imageHashOnce sync.Once
+	imageHashVal  string
+
+	reportLog *reportLog // nil if report_log is not configured
+
+	warnings  mgrconfig.Warnings
+	warnMu    sync.Mutex
+	warnState map[string]*warningState // warning title -> state
+
+	severities     []severityRule     // configured and default severity rules
+	securityEvents []securityEvent    // configured security event signatures
+	liveness       *livenessCheck     // nil if liveness_check is not configured
+	unrecognized   *unrecognizedCheck // nil if unrecognized_crashes is not configured
+	reportLimit    *reportLimiter     // nil if max_reports_per_minute is not configured
+
+	placement PlacementStrategy // nil if the pool does not span several locations
+	placeMu   sync.Mutex
+	placed    map[int]vmimpl.Location // index -> location of live instances
+
+	copyRetries   uint64 // in-place retries of transient copy failures (atomic)
+	consoleStalls uint64 // no-output hangs that turned out to be console stalls (atomic)
+	infraErrors   uint64 // VM failures caused by the host (atomic)
 }
 
 type Instance struct {
-	impl    vmimpl.Instance
-	workdir string
-	index   int
+	impl        vmimpl.Instance
+	pool        *Pool
+	workdir     string
+	index       int
+	dedupOutput bool
+	executor    string // where the executor is copied in VM (if it is)
+	crashed     bool   // MonitorExecution has detected a crash
+	placed      bool   // the instance was placed by the placement strategy in location
+	location    vmimpl.Location
 }
 
 var (
-	Shutdown   = vmimpl.Shutdown
-	ErrTimeout = vmimpl.ErrTimeout
+	Shutdown       = vmimpl.Shutdown
+	ErrTimeout     = vmimpl.ErrTimeout
+	ErrUnsupported = vmimpl.ErrUnsupported
 )
 
 type BootErrorer interface {
@@ -78,15 +137,88 @@ func Create(cfg *mgrconfig.Config, debug bool) (*Pool, error) {
 		SSHUser: cfg.SSHUser,
 		Debug:   debug,
 		Config:  cfg.VM,
+
+		ShareFiles: cfg.SharedExecutor,
+		ReadPstore: cfg.ReadPstore,
+	}
+	placement, err := newPlacement(cfg.Placement)
+	if err != nil {
+		return nil, err
+	}
+	severities, err := compileSeverities(cfg.Severities)
+	if err != nil {
+		return nil, err
+	}
+	securityEvents, err := compileSecurityEvents(cfg.SecurityEvents)
+	if err != nil {
+		return nil, err
+	}
+	liveness, err := compileLivenessCheck(cfg.LivenessCheck)
+	if err != nil {
+		return nil, err
+	}
+	unrecognized, err := compileUnrecognizedCheck(cfg.UnrecognizedCrashes)
+	if err != nil {
+		return nil, err
 	}
 	impl, err := typ.Ctor(env)
 	if err != nil {
 		return nil, err
 	}
-	return &Pool{
-		impl:    impl,
-		workdir: env.Workdir,
-	}, nil
+	pool := &Pool{
+		impl:           impl,
+		typ:            cfg.Type,
+		name:           cfg.Name,
+		image:          cfg.Image,
+		os:             cfg.TargetOS,
+		executor:       cfg.SyzExecutorBin,
+		workdir:        env.Workdir,
+		dedupOutput:    cfg.DedupOutput,
+		readPstore:     cfg.ReadPstore,
+		crashMemState:  cfg.CrashMemState,
+		crashFtrace:    cfg.CrashFtrace,
+		crashCmdline:   cfg.CrashCmdline,
+		verifyForward:  cfg.VerifyForward,
+		timedConsole:   cfg.TimedConsoleLog,
+		leakWatch:      cfg.LeakWatch,
+		preempted:      [][]byte{[]byte(fuzzerPreemptedStr)},
+		timeouts:       defaultMonitorTimeouts(),
+		shared:         make(map[string]string),
+		bundle:         cfg.BundleCrashes,
+		warnings:       cfg.Warnings,
+		warnState:      make(map[string]*warningState),
+		severities:     severities,
+		securityEvents: securityEvents,
+		liveness:       liveness,
+		unrecognized:   unrecognized,
+		reportLimit:    newReportLimiter(cfg.MaxReportsPerMinute),
+		placed:         make(map[int]vmimpl.Location),
+	}
+	pool.timeouts.firstOutput = time.Duration(cfg.FirstOutputTimeout) * time.Second
+	if filterer, ok := impl.(vmimpl.OutputFilterer); ok {
+		pool.outputFilter = filterer.OutputFilter()
+	}
+	for _, marker := range cfg.PreemptionMarkers {
+		pool.preempted = append(pool.preempted, []byte(marker))
+	}
+	if cfg.ReportLog.File != "" {
+		pool.reportLog = &reportLog{cfg: cfg.ReportLog}
+	}
+	if placement != nil {
+		if _, ok := impl.(vmimpl.Placer); ok {
+			pool.placement = placement
+		} else {
+			log.Logf(0, "%v VMs don't support placement, ignoring %v placement", cfg.Type, cfg.Placement)
+		}
+	}
+	if cfg.SharedExecutor {
+		if _, ok := impl.(vmimpl.Sharer); ok {
+			pool.sharedExecutor = cfg.SyzExecutorBin
+		} else {
+			log.Logf(0, "%v VMs don't support shared executor, copying it into each VM", cfg.Type)
+		}
+	}
+	return pool, nil
 }
 
 func (pool *Pool) Count() int {
@@ -94,6 +226,19 @@ func (pool *Pool) Count() int {
 }
 
 func (pool *Pool) Create(index int) (*Instance, error) {
+	return pool.create(index, "")
+}
+
+// CreateWithCmdline creates a VM that boots with cmdline appended to the kernel command line
+// (the VM type must support vmimpl.CmdlineCreator). Such VMs are not subject to placement.
+func (pool *Pool) CreateWithCmdline(index int, cmdline string) (*Instance, error) {
+	if _, ok := pool.impl.(vmimpl.CmdlineCreator); !ok {
+		return nil, fmt.Errorf("%v VMs don't support changing kernel command line", pool.typ)
+	}
+	return pool.create(index, cmdline)
+}
+
+func (pool *Pool) create(index int, cmdline string) (*Instance, error) {
 	if index < 0 || index >= pool.Count() {
 		return nil, fmt.Errorf("invalid VM index %v (count %v)", index, pool.Count())
 	}
@@ -101,24 +246,160 @@ func (pool *Pool) Create(index int) (*Instance, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create instance temp dir: %v", err)
 	}
-	impl, err := pool.impl.Create(workdir, index)
+	inst := &Instance{
+		pool:        pool,
+		workdir:     workdir,
+		index:       index,
+		dedupOutput: pool.dedupOutput,
+	}
+	if cmdline != "" {
+		inst.impl, err = pool.impl.(vmimpl.CmdlineCreator).CreateWithCmdline(workdir, index, cmdline)
+	} else if pool.placement != nil {
+		inst.placed = true
+		inst.location = pool.place(index)
+		log.Logf(1, "vm-%v: placing in %v (zone %q)", index, inst.location.Name, inst.location.Zone)
+		inst.impl, err = pool.impl.(vmimpl.Placer).CreateAt(workdir, index, inst.location)
+	} else {
+		inst.impl, err = pool.impl.Create(workdir, index)
+	}
 	if err != nil {
+		if inst.placed {
+			pool.unplace(index, inst.location)
+		}
 		os.RemoveAll(workdir)
 		return nil, err
 	}
-	return &Instance{
-		impl:    impl,
-		workdir: workdir,
-		index:   index,
-	}, nil
+	return inst, nil
 }
 
 func (inst *Instance) Copy(hostSrc string) (string, error) {
-	return inst.impl.Copy(hostSrc)
+	if hostSrc != "" && hostSrc == inst.pool.sharedExecutor && inst.sharedFiles() {
+		if vmDst, err := inst.pool.share(hostSrc); err == nil {
+			return vmDst, nil
+		}
+	}
+	vmDst, err := inst.copy(hostSrc)
+	if err == nil && hostSrc != "" && hostSrc == inst.pool.executor {
+		inst.executor = vmDst
+	}
+	return vmDst, err
+}
+
+func (inst *Instance) sharedFiles() bool {
+	checker, ok := inst.impl.(vmimpl.SharedFilesChecker)
+	return !ok || checker.SharedFiles()
+}
+
+// Transient copy failures (see vmimpl.TransientError) are retried in place that many times
+// with exponential backoff starting at copyBackoff, that's much cheaper than recreation of the VM.
+var (
+	copyRetries = 3
+	copyBackoff = 5 * time.Second
+)
+
+func (inst *Instance) copy(hostSrc string) (string, error) {
+	backoff := copyBackoff
+	for retry := 0; ; retry++ {
+		vmDst, err := inst.impl.Copy(hostSrc)
+		if err == nil || !vmimpl.IsTransient(err) || retry == copyRetries {
+			return vmDst, err
+		}
+		log.Logf(0, "vm-%v: failed to copy %v, retrying in %v: %v", inst.index, hostSrc, backoff, err)
+		atomic.AddUint64(&inst.pool.copyRetries, 1)
+		if !vmimpl.SleepInterruptible(backoff) {
+			return "", err
+		}
+		backoff *= 2
+	}
+}
+
+// CopyRetries returns the number of in-place retries of transient copy failures.
+func (pool *Pool) CopyRetries() uint64 {
+	return atomic.LoadUint64(&pool.copyRetries)
+}
+
+// ConsoleStallsRecovered returns the number of times the console was silent,
+// but the kernel turned out to be alive when probed (see vmimpl.Prober).
+func (pool *Pool) ConsoleStallsRecovered() uint64 {
+	return atomic.LoadUint64(&pool.consoleStalls)
+}
+
+// InfraErrors returns the number of VM failures caused by the host rather than by the kernel
+// (e.g. the VM process was killed by the host OOM killer, see vmimpl.InfraError).
+func (pool *Pool) InfraErrors() uint64 {
+	return atomic.LoadUint64(&pool.infraErrors)
+}
+
+// TakenOver returns true if the machine of instance index is currently used by a human
+// (see vmimpl.TakeoverWatcher), fuzzing is paused on it until the human disconnects.
+func (pool *Pool) TakenOver(index int) bool {
+	watcher, ok := pool.impl.(vmimpl.TakeoverWatcher)
+	return ok && watcher.TakenOver(index)
+}
+
+// Type returns the VM type of the pool.
+func (pool *Pool) Type() string {
+	return pool.typ
+}
+
+// share shares hostSrc with all VMs once. If sharing fails,
+// the error is remembered and callers fall back to copying into each VM.
+func (pool *Pool) share(hostSrc string) (string, error) {
+	pool.shareMu.Lock()
+	defer pool.shareMu.Unlock()
+	if pool.shareErr != nil {
+		return "", pool.shareErr
+	}
+	if vmDst := pool.shared[hostSrc]; vmDst != "" {
+		return vmDst, nil
+	}
+	vmDst, err := pool.impl.(vmimpl.Sharer).Share(hostSrc)
+	if err != nil {
+		log.Logf(0, "failed to share %v, copying it into each VM: %v", hostSrc, err)
+		pool.shareErr = err
+		return "", err
+	}
+	pool.shared[hostSrc] = vmDst
+	return vmDst, nil
 }
 
+// Forward sets up forwarding from within VM to the given tcp port on the host and returns the address
+// to use in VM. If the VM can't forward ports, a reverse tunnel is used (see vmimpl.ReverseTunneler).
+// If verify_forward is configured, it also checks that the VM can actually connect to the address.
 func (inst *Instance) Forward(port int) (string, error) {
-	return inst.impl.Forward(port)
+	addr, err := inst.forward(port)
+	if err != nil || !inst.pool.verifyForward {
+		return addr, err
+	}
+	if err := inst.verifyForward(addr); err != nil {
+		return "", err
+	}
+	return addr, nil
+}
+
+func (inst *Instance) forward(port int) (string, error) {
+	addr, err := inst.impl.Forward(port)
+	if err != ErrUnsupported {
+		return addr, err
+	}
+	tunneler, ok := inst.impl.(vmimpl.ReverseTunneler)
+	if !ok {
+		return "", fmt.Errorf("%v VMs support neither port forwarding nor reverse tunnels", inst.pool.typ)
+	}
+	log.Logf(1, "vm-%v: port forwarding is not supported, using a reverse tunnel", inst.index)
+	return tunneler.ReverseTunnel(port)
+}
+
+// Exec runs command in the VM (unlike Run, it's meant for short setup and probe commands)
+// and returns its stdout, stderr and exit code separately. err is non-nil only if the command
+// can't be run or does not finish within timeout. The VM must support vmimpl.Execer.
+func (inst *Instance) Exec(command string, timeout time.Duration) (
+	stdout, stderr []byte, exitCode int, err error) {
+	execer, ok := inst.impl.(vmimpl.Execer)
+	if !ok {
+		return nil, nil, 0, fmt.Errorf("VM does not support executing commands")
+	}
+	return execer.Exec(command, timeout)
 }
 
 func (inst *Instance) Run(timeout time.Duration, stop <-chan bool, command string) (
@@ -126,12 +407,33 @@ func (inst *Instance) Run(timeout time.Duration, stop <-chan bool, command strin
 	return inst.impl.Run(timeout, stop, command)
 }
 
+// KernelTag returns the tag of the kernel the VM runs if the pool runs several kernels
+// (see vmimpl.KernelTagger), or an empty string otherwise.
+func (inst *Instance) KernelTag() string {
+	if tagger, ok := inst.impl.(vmimpl.KernelTagger); ok {
+		return tagger.KernelTag()
+	}
+	return ""
+}
+
+// KernelCmdline returns the kernel command line the VM was booted with (see vmimpl.CmdlineReporter),
+// or an empty string if the VM type does not control it.
+func (inst *Instance) KernelCmdline() string {
+	if reporter, ok := inst.impl.(vmimpl.CmdlineReporter); ok {
+		return reporter.KernelCmdline()
+	}
+	return ""
+}
+
 func (inst *Instance) Diagnose() bool {
 	return inst.impl.Diagnose()
 }
 
 func (inst *Instance) Close() {
 	inst.impl.Close()
+	if inst.placed {
+		inst.pool.unplace(inst.index, inst.location)
+	}
 	os.RemoveAll(inst.workdir)
 }
 
@@ -139,6 +441,10 @@ func (inst *Instance) Close() {
 // It detects kernel oopses in output, lost connections, hangs, etc.
 // outc/errc is what vm.Instance.Run returns, reporter parses kernel output for oopses.
 // If canExit is false and the program exits, it is treated as an error.
+// If canExit is false and leak_watch is configured, guest state is sampled during execution
+// and suspicious growth is reported when execution finishes by timeout (see MemoryGrowthPrefix).
+// If canExit is false and crash_mem_state is configured, guest memory state is attached to crash reports.
+// If canExit is false and crash_ftrace is configured, the kernel ftrace buffer is attached to crash reports.
 // Returns a non-symbolized crash report, or nil if no error happens.
 func (inst *Instance) MonitorExecution(outc <-chan []byte, errc <-chan error,
 	reporter report.Reporter, canExit bool) (rep *report.Report) {
@@ -148,48 +454,234 @@ func (inst *Instance) MonitorExecution(outc <-chan []byte, errc <-chan error,
 		errc:     errc,
 		reporter: reporter,
 		canExit:  canExit,
+		diagPos:  -1,
+		execPos:  -1,
+	}
+	mon.lines = newLineLimiter(reporter.ContainsCrash)
+	if inst.dedupOutput {
+		mon.dedup = new(outputDedup)
+	}
+	if inst.pool.outputFilter != nil {
+		mon.filter = &outputFilter{filter: inst.pool.outputFilter}
+	}
+	start := time.Now()
+	if inst.pool.timedConsole {
+		mon.console = newConsoleRecorder(start)
+	}
+	startBlockStats := inst.blockStats()
+	defer func() {
+		inst.logOutcome(start, mon.outcome(rep), rep, mon.blockStats)
+	}()
+	var leaks *leakWatch
+	var mem *memState
+	if !canExit {
+		leaks = inst.startLeakWatch()
+		defer leaks.close()
+		mem = inst.startMemState()
+	}
+	if inst.pool.bundle.Dir != "" {
+		defer func() {
+			if rep == nil || rep.Suppressed || rep.Throttled {
+				return
+			}
+			dir, err := inst.saveBundle(rep)
+			if err != nil {
+				log.Logf(0, "vm-%v: failed to save crash bundle: %v", inst.index, err)
+				return
+			}
+			log.Logf(0, "vm-%v: saved crash bundle to %v", inst.index, dir)
+		}()
 	}
+	if inst.pool.reportLimit != nil {
+		// After the crash report is finalized (pstore may change its title), but before it's saved in the bundle.
+		defer func() {
+			if rep == nil || rep.Suppressed {
+				return
+			}
+			ok, repeats := inst.pool.reportLimit.admit(rep.Title, time.Now())
+			if !ok {
+				rep.Throttled = true
+				log.Logf(1, "vm-%v: crash report %q is throttled", inst.index, rep.Title)
+				return
+			}
+			rep.Repeats += repeats
+		}()
+	}
+	// Queried after the crash report is finalized, but before it's saved in the bundle.
+	defer func() {
+		if startBlockStats == nil {
+			return
+		}
+		mon.blockStats = blockStatsDelta(startBlockStats, inst.blockStats())
+		if rep != nil && len(mon.blockStats) != 0 {
+			rep.Info = append(rep.Info, formatBlockStats(mon.blockStats)...)
+		}
+	}()
+	defer func() {
+		if rep != nil {
+			rep.TimedConsole = mon.console.serialize()
+		}
+	}()
+	defer func() {
+		if rep != nil {
+			inst.crashed = true
+			if rep.Time.IsZero() {
+				rep.Time = time.Now()
+			}
+			rep.GuestUptime = report.GuestUptime(crashOutput(rep))
+			rep.Severity = livenessSeverity(inst.pool.severity(rep.Title), rep.Liveness)
+			inst.attachInfo(rep)
+			if inst.pool.crashCmdline {
+				rep.Cmdline = inst.KernelCmdline()
+			}
+			if mon.filter != nil {
+				rep.RawOutput = mon.filter.rawOutput()
+			}
+			if reason := report.DetectOutputLoss(rep.Output); reason != "" {
+				rep.Incomplete = true
+				rep.IncompleteReason = reason
+			}
+		}
+	}()
+	if inst.pool.readPstore {
+		defer func() {
+			if rep != nil && !rep.Suppressed && rep.Class != report.ClassSecurityEvent &&
+				!strings.HasPrefix(rep.Title, MemoryGrowthPrefix) {
+				rep = inst.attachPstore(rep, reporter)
+			}
+		}()
+	}
+	if mem != nil {
+		// Before pstore is read, because it resets the VM.
+		defer func() {
+			if rep != nil && !rep.Suppressed && !mon.connLost && !strings.HasPrefix(rep.Title, MemoryGrowthPrefix) {
+				rep.MemState = mem.collect()
+			}
+		}()
+	}
+	if !canExit && inst.pool.crashFtrace {
+		// Before pstore is read, because it resets the VM.
+		defer func() {
+			if rep != nil && !rep.Suppressed && !mon.connLost && rep.Class != report.ClassSecurityEvent {
+				rep.Ftrace = inst.readFtrace()
+			}
+		}()
+	}
+	var maintenance <-chan bool
+	if watcher, ok := inst.impl.(vmimpl.MaintenanceWatcher); ok {
+		maintenance = watcher.Maintenance()
+	}
+	var maintenanceStart time.Time
 	lastExecuteTime := time.Now()
-	ticker := time.NewTicker(tickerPeriod)
+	gotOutput := false
+	probed := false // the kernel was probed since the last executed program
+	ticker := time.NewTicker(inst.pool.timeouts.ticker)
 	defer ticker.Stop()
+	defer func() {
+		if rep == nil && mon.warning != nil {
+			rep = mon.finishWarning()
+		}
+	}()
 	for {
 		select {
 		case err := <-errc:
+			mon.console.exit(err)
 			switch err {
 			case nil:
 				// The program has exited without errors,
 				// but wait for kernel output in case there is some delayed oops.
 				return mon.extractError("")
 			case ErrTimeout:
-				return nil
+				mon.timedOut = true
+				return leaks.finish(mon.output)
 			default:
+				if vmimpl.IsInfra(err) {
+					// The VM has failed because of the host, this is not a kernel bug.
+					log.Logf(0, "vm-%v: infrastructure error: %v", inst.index, err)
+					atomic.AddUint64(&inst.pool.infraErrors, 1)
+					mon.connLost = true
+					mon.infraError = true
+					return nil
+				}
 				// Note: connection lost can race with a kernel oops message.
 				// In such case we want to return the kernel oops.
+				mon.connLost = true
 				return mon.extractError(lostConnectionCrash)
 			}
 		case out, ok := <-outc:
 			if !ok {
 				outc = nil
+				mon.connLost = true
 				continue
 			}
-			lastPos := len(mon.output)
-			mon.output = append(mon.output, out...)
-			if bytes.Contains(mon.output[lastPos:], executingProgram1) ||
-				bytes.Contains(mon.output[lastPos:], executingProgram2) {
+			if !gotOutput && len(out) != 0 {
+				gotOutput = true
+				if inst.pool.timeouts.firstOutput != 0 {
+					// The first output grace period is over, now silence is measured from here.
+					lastExecuteTime = time.Now()
+				}
+			}
+			if bytes.Contains(out, executingProgram1) ||
+				bytes.Contains(out, executingProgram2) {
 				lastExecuteTime = time.Now()
+				probed = false
 			}
-			if reporter.ContainsCrash(mon.output[mon.matchPos:]) {
-				return mon.extractError("unknown error")
+			mon.appendOutput(out)
+			for reporter.ContainsCrash(mon.output[mon.matchPos:]) {
+				if !mon.handleWarning() {
+					liveness := mon.checkLiveness()
+					rep := mon.extractError("unknown error")
+					if rep != nil {
+						rep.Liveness = liveness
+					}
+					return rep
+				}
+				if !mon.warnWait.IsZero() {
+					break // wait for the rest of the warning
+				}
+			}
+			if rep := mon.securityEvent(); rep != nil {
+				return rep
+			}
+			if rep := mon.unrecognizedCrash(); rep != nil {
+				return rep
 			}
 			if len(mon.output) > 2*beforeContext {
-				copy(mon.output, mon.output[len(mon.output)-beforeContext:])
+				shift := len(mon.output) - beforeContext
+				copy(mon.output, mon.output[shift:])
 				mon.output = mon.output[:beforeContext]
+				mon.skipPos = max0(mon.skipPos - shift)
+				mon.warnPos = max0(mon.warnPos - shift)
+				if mon.execPos != -1 {
+					mon.execPos = max0(mon.execPos - shift)
+				}
 			}
-			mon.matchPos = len(mon.output) - maxErrorLength
-			if mon.matchPos < 0 {
-				mon.matchPos = 0
+			mon.matchPos = max0(len(mon.output) - maxErrorLength)
+			if mon.matchPos < mon.skipPos {
+				mon.matchPos = mon.skipPos
+			}
+			if !mon.warnWait.IsZero() && mon.matchPos > mon.warnPos {
+				mon.matchPos = mon.warnPos
+			}
+		case active := <-maintenance:
+			if active {
+				log.Logf(0, "vm-%v: host maintenance started, suspending hang detection", inst.index)
+				maintenanceStart = time.Now()
+			} else if !maintenanceStart.IsZero() {
+				log.Logf(0, "vm-%v: host maintenance finished after %v",
+					inst.index, time.Since(maintenanceStart))
+				// The VM was paused, so the time does not count towards the hang timeout.
+				lastExecuteTime = lastExecuteTime.Add(time.Since(maintenanceStart))
+				maintenanceStart = time.Time{}
 			}
 		case <-ticker.C:
+			if !mon.warnWait.IsZero() && time.Since(mon.warnWait) > inst.pool.timeouts.waitForOutput {
+				// The warning was not finished, the kernel has probably died in the middle of it.
+				return mon.extractError("unknown error")
+			}
+			if mon.warning != nil && time.Now().After(mon.warningDeadline) {
+				return mon.finishWarning()
+			}
 			// Detect both "not output whatsoever" and "kernel episodically prints
 			// something to console, but fuzzer is not actually executing programs".
 			// The timeout used to be 3 mins for a long time.
@@ -201,44 +693,210 @@ func (inst *Instance) MonitorExecution(outc <-chan []byte, errc <-chan error,
 			// in 140-280s detection delay.
 			// So the current timeout is 5 mins (300s).
 			// We don't want it to be too long too because it will waste time on real hangs.
-			if time.Since(lastExecuteTime) < noOutputTimeout {
+			// Slow-booting images may configure a longer timeout for the first output.
+			timeout := inst.pool.timeouts.noOutput
+			if !gotOutput && inst.pool.timeouts.firstOutput != 0 {
+				timeout = inst.pool.timeouts.firstOutput
+			}
+			if !maintenanceStart.IsZero() || time.Since(lastExecuteTime) < timeout {
+				break
+			}
+			// The kernel may be alive, but the console has stalled (e.g. a serial driver bug).
+			// The kernel is probed only once per silence period, otherwise a live kernel
+			// that does not execute programs would never be detected as hanged.
+			if !probed && mon.probe() {
+				probed = true
+				log.Logf(0, "vm-%v: console stall recovered after probing the kernel", inst.index)
+				atomic.AddUint64(&inst.pool.consoleStalls, 1)
+				lastExecuteTime = time.Now()
 				break
 			}
-			if inst.Diagnose() {
+			if mon.diagnose() {
 				mon.waitForOutput()
 			}
+			mon.flushOutput()
+			suppressed := report.IsSuppressed(mon.reporter, mon.output)
+			diagnosis := mon.takeDiagnosis()
 			rep := &report.Report{
 				Title:      noOutputCrash,
 				Output:     mon.output,
-				Suppressed: report.IsSuppressed(mon.reporter, mon.output),
+				Diagnosis:  diagnosis,
+				Suppressed: suppressed,
 			}
 			return rep
 		case <-Shutdown:
+			mon.warning = nil
+			mon.recycled = true
 			return nil
 		}
 	}
 }
 
+// crashOutput returns console output up to the end of the crash report.
+func crashOutput(rep *report.Report) []byte {
+	if rep.EndPos > rep.StartPos && rep.EndPos <= len(rep.Output) {
+		return rep.Output[:rep.EndPos]
+	}
+	return rep.Output
+}
+
+// Info returns additional information provided by the VM implementation
+// (see vmimpl.Infoer), or nil if the VM does not provide any.
+func (inst *Instance) Info() ([]byte, error) {
+	infoer, ok := inst.impl.(vmimpl.Infoer)
+	if !ok {
+		return nil, nil
+	}
+	return infoer.Info()
+}
+
+// attachInfo attaches additional information provided by the VM implementation to rep.
+func (inst *Instance) attachInfo(rep *report.Report) {
+	info, err := inst.Info()
+	if err != nil {
+		log.Logf(0, "vm-%v: failed to get VM info: %v", inst.index, err)
+		return
+	}
+	rep.Info = info
+}
+
+// SaveRecording stops the VM and moves its recorded execution (see vmimpl.Recorder) to dst.
+// Returns the command that replays the recording, or "" if the VM does not record its execution.
+func (inst *Instance) SaveRecording(dst string) (string, error) {
+	recorder, ok := inst.impl.(vmimpl.Recorder)
+	if !ok {
+		return "", nil
+	}
+	file, replay, err := recorder.Recording()
+	if err != nil || file == "" {
+		return "", err
+	}
+	if err := osutil.Rename(file, dst); err != nil {
+		return "", fmt.Errorf("failed to save recording: %v", err)
+	}
+	return strings.Replace(replay, file, dst, -1), nil
+}
+
+// attachPstore reboots the VM and attaches pstore records left by the crashed kernel to rep.
+// If the console did not show a kernel crash (e.g. the kernel hung and was reset by a watchdog),
+// but pstore records contain one, the recovered crash is returned instead.
+func (inst *Instance) attachPstore(rep *report.Report, reporter report.Reporter) *report.Report {
+	reader, ok := inst.impl.(vmimpl.PstoreReader)
+	if !ok {
+		return rep
+	}
+	pstore, err := reader.ReadPstore()
+	if err != nil {
+		log.Logf(0, "vm-%v: failed to read pstore: %v", inst.index, err)
+		return rep
+	}
+	if len(pstore) == 0 {
+		return rep
+	}
+	header := []byte(pstoreHeader)
+	output := append(append(append([]byte{}, rep.Output...), header...), pstore...)
+	if rep.Title == noOutputCrash || rep.Title == lostConnectionCrash {
+		if recovered := reporter.Parse(pstore); recovered != nil {
+			offset := len(output) - len(pstore)
+			recovered.Output = output
+			recovered.StartPos += offset
+			recovered.EndPos += offset
+			recovered.Diagnosis = rep.Diagnosis
+			return recovered
+		}
+	}
+	rep.Output = output
+	rep.Report = append(append(append([]byte{}, rep.Report...), header...), pstore...)
+	return rep
+}
+
 type monitor struct {
-	inst     *Instance
-	outc     <-chan []byte
-	errc     <-chan error
-	reporter report.Reporter
-	canExit  bool
-	output   []byte
-	matchPos int
+	inst       *Instance
+	outc       <-chan []byte
+	errc       <-chan error
+	reporter   report.Reporter
+	canExit    bool
+	output     []byte
+	matchPos   int
+	skipPos    int // output before skipPos was handled (see handleWarning)
+	lines      *lineLimiter
+	dedup      *outputDedup
+	connLost   bool // the command has lost connection to the VM (the VM can't be queried anymore)
+	timedOut   bool // the command has finished by timeout
+	recycled   bool // the run has finished without a crash because of preemption, restart request or shutdown
+	infraError bool // the VM has failed because of the host (see vmimpl.InfraError)
+	diagPos    int  // start of Diagnose output in output, or -1 if Diagnose was not called
+	execPos    int  // start of the first executing program marker in output, or -1 if not seen yet
+
+	warning         *report.Report // pending non-fatal warning report
+	warningRepeats  int            // repeats of the pending warning
+	warningDeadline time.Time      // when the pending warning is reported
+	warnWait        time.Time      // when started waiting for the end of the warning at warnPos
+	warnPos         int
+
+	blockStats []vmimpl.BlockStats // I/O statistics of VM block devices during the run (if available)
+	console    *consoleRecorder    // nil if timed_console_log is not configured
+	filter     *outputFilter       // nil if the VM type does not filter output
+}
+
+// outcome classifies the result of the run for the report log.
+func (mon *monitor) outcome(rep *report.Report) string {
+	switch {
+	case rep != nil && rep.Suppressed:
+		return OutcomeSuppressed
+	case rep != nil && rep.Throttled:
+		return OutcomeThrottled
+	case rep != nil && rep.Class == report.ClassSecurityEvent:
+		return OutcomeSecurityEvent
+	case rep != nil && rep.Class == report.ClassBootWarning:
+		return OutcomeBootWarning
+	case rep != nil:
+		return OutcomeCrash
+	case mon.infraError:
+		return OutcomeInfraError
+	case mon.recycled:
+		return OutcomeRecycle
+	case mon.timedOut:
+		return OutcomeTimeout
+	default:
+		return OutcomeExit
+	}
+}
+
+func (mon *monitor) preempted() bool {
+	for _, marker := range mon.inst.pool.preempted {
+		if bytes.Contains(mon.output, marker) {
+			return true
+		}
+	}
+	return false
 }
 
 func (mon *monitor) extractError(defaultError string) *report.Report {
 	crashed := defaultError != "" || !mon.canExit
-	if crashed {
-		mon.inst.Diagnose()
+	// If a crash is still being printed, Diagnose output would be interleaved with it
+	// (e.g. land in the middle of the Call Trace), so it's postponed until the crash is printed.
+	printing := mon.reporter.ContainsCrash(mon.output[mon.matchPos:])
+	if crashed && !printing {
+		mon.diagnose()
 	}
 	// Give it some time to finish writing the error message.
 	mon.waitForOutput()
-	if bytes.Contains(mon.output, []byte(fuzzerPreemptedStr)) {
+	mon.waitForLockdepChain()
+	mon.waitForKasanShadow()
+	if mon.preempted() || bytes.Contains(mon.output, []byte(fuzzerRestartStr)) {
+		mon.recycled = true
 		return nil
 	}
+	// Skip non-fatal warnings that were printed right before the end.
+	for mon.reporter.ContainsCrash(mon.output[mon.matchPos:]) {
+		if !mon.handleWarning() {
+			break // a crash that must be reported
+		}
+		if !mon.warnWait.IsZero() {
+			break // an unfinished warning
+		}
+	}
 	if !mon.reporter.ContainsCrash(mon.output[mon.matchPos:]) {
 		if defaultError == "" {
 			if mon.canExit {
@@ -246,15 +904,36 @@ func (mon *monitor) extractError(defaultError string) *report.Report {
 			}
 			defaultError = lostConnectionCrash
 		}
+		suppressed := report.IsSuppressed(mon.reporter, mon.output)
+		// The kernel has died with output the reporter does not recognize, it's better described
+		// by the output than by how the run has ended. The output may arrive after Diagnose was called,
+		// so it's checked before Diagnose output is cut off.
+		unrecognized := mon.unrecognizedReport()
+		diagnosis := mon.takeDiagnosis()
+		if unrecognized != nil {
+			unrecognized.Diagnosis = diagnosis
+			unrecognized.Suppressed = suppressed
+			return unrecognized
+		}
 		rep := &report.Report{
 			Title:      defaultError,
 			Output:     mon.output,
-			Suppressed: report.IsSuppressed(mon.reporter, mon.output),
+			Diagnosis:  diagnosis,
+			Suppressed: suppressed,
 		}
 		return rep
 	}
-	if !crashed && mon.inst.Diagnose() {
+	// If Diagnose was called before the crash was printed, the crash may come
+	// from the Diagnose output itself, so it's kept in the output.
+	var diagnosis []byte
+	if (!crashed || printing) && mon.diagnose() {
 		mon.waitForOutput()
+		diagnosis = mon.takeDiagnosis()
+	}
+	// With panic=1 (or panic_on_warn) the guest may reboot right after the crash,
+	// output of the new boot must not leak into the report.
+	if pos := mon.rebootPos(); pos != -1 {
+		mon.output = mon.output[:pos]
 	}
 	rep := mon.reporter.Parse(mon.output[mon.matchPos:])
 	if rep == nil {
@@ -265,25 +944,180 @@ func (mon *monitor) extractError(defaultError string) *report.Report {
 		start = 0
 	}
 	end := mon.matchPos + rep.EndPos + afterContext
+	// The KASAN shadow memory dump is a part of the crash, so if it starts within afterContext,
+	// it's included in full (but no more than maxKasanShadowLength).
+	crashStart := mon.matchPos + rep.StartPos
+	if shadowStart, shadowEnd, _ := kasanShadow(mon.output[crashStart:]); shadowStart != -1 &&
+		crashStart+shadowStart < end && crashStart+shadowEnd > end {
+		end = crashStart + shadowEnd
+		if limit := crashStart + shadowStart + maxKasanShadowLength; end > limit {
+			end = limit
+		}
+	}
 	if end > len(mon.output) {
 		end = len(mon.output)
 	}
 	rep.Output = mon.output[start:end]
 	rep.StartPos += mon.matchPos - start
 	rep.EndPos += mon.matchPos - start
+	rep.Diagnosis = diagnosis
+	if mon.beforeExec(crashStart) {
+		rep.Class = report.ClassBootWarning
+	}
 	return rep
 }
 
+// beforeExec returns true if output at pos was printed before the fuzzer executed any programs,
+// i.e. it can't be caused by fuzzing. If the fuzzer fails to start, the marker never appears
+// and the whole output is considered boot output. Runs that are allowed to exit
+// (repro, syz-execprog, etc) are not classified.
+func (mon *monitor) beforeExec(pos int) bool {
+	return !mon.canExit && (mon.execPos == -1 || pos < mon.execPos)
+}
+
+// probe asks the kernel to print something (see vmimpl.Prober) and returns true
+// if fresh output has arrived in response.
+func (mon *monitor) probe() bool {
+	prober, ok := mon.inst.impl.(vmimpl.Prober)
+	if !ok || !prober.Probe() {
+		return false
+	}
+	before := len(mon.output)
+	mon.waitForOutput()
+	return len(mon.output) > before
+}
+
+// diagnose calls Diagnose unless the guest has already rebooted after the crash:
+// debugging output would come from the new boot and corrupt the report.
+func (mon *monitor) diagnose() bool {
+	if mon.rebootPos() != -1 {
+		log.Logf(1, "vm-%v: guest rebooted after the crash, not diagnosing", mon.inst.index)
+		return false
+	}
+	// Output held by the line limiter and dedup precedes Diagnose output.
+	mon.flushOutput()
+	pos := len(mon.output)
+	if !mon.inst.Diagnose() {
+		return false
+	}
+	mon.console.diagnose()
+	if mon.diagPos == -1 {
+		mon.diagPos = pos
+	}
+	return true
+}
+
+// takeDiagnosis cuts Diagnose output off the output and returns it,
+// so that it does not get into the crash report.
+func (mon *monitor) takeDiagnosis() []byte {
+	if mon.diagPos == -1 || mon.diagPos >= len(mon.output) {
+		return nil
+	}
+	diagnosis := append([]byte{}, mon.output[mon.diagPos:]...)
+	mon.output = mon.output[:mon.diagPos]
+	return diagnosis
+}
+
+// rebootPos returns position of the line in output where the guest starts booting again
+// after the crash, or -1 if there is no crash or the guest has not rebooted.
+func (mon *monitor) rebootPos() int {
+	rep := mon.reporter.Parse(mon.output[mon.matchPos:])
+	if rep == nil || rep.Title == report.UnexpectedKernelReboot {
+		return -1
+	}
+	start := mon.matchPos + rep.StartPos
+	eol := bytes.IndexByte(mon.output[start:], '\n')
+	if eol == -1 {
+		return -1
+	}
+	start += eol + 1
+	pos := findReboot(mon.output[start:])
+	if pos == -1 {
+		return -1
+	}
+	return start + pos
+}
+
+// findReboot returns position of the first line of output that contains a boot banner, or -1.
+func findReboot(output []byte) int {
+	pos := -1
+	for _, banner := range rebootBanners {
+		if i := bytes.Index(output, banner); i != -1 && (pos == -1 || i < pos) {
+			pos = i
+		}
+	}
+	if pos == -1 {
+		return -1
+	}
+	return bytes.LastIndexByte(output[:pos], '\n') + 1
+}
+
+func max0(v int) int {
+	if v < 0 {
+		return 0
+	}
+	return v
+}
+
+func (mon *monitor) appendOutput(out []byte) {
+	mon.console.output(out)
+	if mon.filter != nil {
+		out = mon.filter.process(out)
+	}
+	if mon.lines != nil {
+		out = mon.lines.process(out)
+	}
+	if mon.dedup != nil {
+		out = mon.dedup.process(out)
+	}
+	mon.appendExecOutput(out)
+}
+
+// flushOutput appends output held by the line limiter and dedup to the output.
+func (mon *monitor) flushOutput() {
+	var out []byte
+	if mon.filter != nil {
+		out = mon.filter.flush()
+	}
+	if mon.lines != nil {
+		out = mon.lines.flush(mon.lines.process(out))
+	}
+	if mon.dedup != nil {
+		out = mon.dedup.flush(mon.dedup.process(out))
+	}
+	mon.appendExecOutput(out)
+}
+
+// appendExecOutput appends out to the output and remembers where the first executing program
+// marker is. The marker may be split between chunks of output, so the search starts
+// in the tail of the previous output.
+func (mon *monitor) appendExecOutput(out []byte) {
+	from := max0(len(mon.output) - len(executingProgram1) - len(executingProgram2))
+	mon.output = append(mon.output, out...)
+	if mon.execPos != -1 {
+		return
+	}
+	for _, marker := range [][]byte{executingProgram1, executingProgram2} {
+		if pos := bytes.Index(mon.output[from:], marker); pos != -1 &&
+			(mon.execPos == -1 || from+pos < mon.execPos) {
+			mon.execPos = from + pos
+		}
+	}
+}
+
 func (mon *monitor) waitForOutput() {
-	timer := time.NewTimer(waitForOutputTimeout)
+	timer := time.NewTimer(mon.inst.pool.timeouts.waitForOutput)
 	defer timer.Stop()
+	// Don't leave anything in the line limiter and dedup buffers, the output is about to be analyzed.
+	defer mon.flushOutput()
 	for {
 		select {
 		case out, ok := <-mon.outc:
 			if !ok {
+				mon.outc = nil
 				return
 			}
-			mon.output = append(mon.output, out...)
+			mon.appendOutput(out)
 		case <-timer.C:
 			return
 		case <-Shutdown:
@@ -292,6 +1126,65 @@ func (mon *monitor) waitForOutput() {
 	}
 }
 
+// waitForLockdepChain waits until lockdep finishes printing a report.
+// Lockdep reports contain the whole dependency chain with a stack for every link,
+// so they can be very long and take a while to be printed over a slow serial console.
+// The report title is derived from the locks in the chain, so we want it in full.
+func (mon *monitor) waitForLockdepChain() {
+	for start := time.Now(); mon.outc != nil && time.Since(start) < mon.inst.pool.timeouts.lockdep; {
+		if !lockdepIncomplete(mon.output[mon.matchPos:]) {
+			return
+		}
+		mon.waitForOutput()
+	}
+}
+
+// waitForKasanShadow waits until KASAN finishes printing the shadow memory dump.
+// The dump goes after the stacks and the object description, so it's printed
+// many lines after the crash header, but it's crucial for triage.
+func (mon *monitor) waitForKasanShadow() {
+	for start := time.Now(); mon.outc != nil && time.Since(start) < mon.inst.pool.timeouts.kasanShadow; {
+		if shadowStart, _, complete := kasanShadow(mon.output[mon.matchPos:]); shadowStart == -1 || complete {
+			return
+		}
+		mon.waitForOutput()
+	}
+}
+
+// kasanShadow returns the range of the KASAN shadow memory dump in output: from the header
+// to the end of the separator line that KASAN prints after the dump. If the dump is not finished,
+// end is len(output) and complete is false. If output does not contain the dump, start is -1.
+func kasanShadow(output []byte) (start, end int, complete bool) {
+	start = bytes.Index(output, kasanShadowHeader)
+	if start == -1 {
+		return -1, -1, false
+	}
+	sep := bytes.Index(output[start:], kasanSeparator)
+	if sep == -1 {
+		return start, len(output), false
+	}
+	end = start + sep + len(kasanSeparator)
+	if nl := bytes.IndexByte(output[end:], '\n'); nl != -1 {
+		end += nl + 1
+	} else {
+		end = len(output)
+	}
+	return start, end, true
+}
+
+// lockdepIncomplete returns true if output contains the beginning of a lockdep report
+// (header) but not its end (the stack backtrace that lockdep prints last).
+func lockdepIncomplete(output []byte) bool {
+	for _, header := range lockdepHeaders {
+		pos := bytes.Index(output, header)
+		if pos == -1 {
+			continue
+		}
+		return !bytes.Contains(output[pos:], lockdepEnd)
+	}
+	return false
+}
+
 const (
 	maxErrorLength = 512
 
@@ -300,16 +1193,63 @@ const (
 	executingProgramStr1 = "executing program"  // syz-fuzzer output
 	executingProgramStr2 = "executed programs:" // syz-execprog output
 	fuzzerPreemptedStr   = "SYZ-FUZZER: PREEMPTED"
+	fuzzerRestartStr     = "SYZ-FUZZER: RESTART REQUESTED"
+	pstoreHeader         = "\nsyzkaller: pstore records recovered after reboot:\n"
 )
 
 var (
 	executingProgram1 = []byte(executingProgramStr1)
 	executingProgram2 = []byte(executingProgramStr2)
 
+	lockdepHeaders = [][]byte{
+		[]byte("WARNING: possible circular locking dependency detected"),
+		[]byte("INFO: possible circular locking dependency detected"),
+	}
+	lockdepEnd = []byte("stack backtrace:")
+
+	kasanShadowHeader = []byte("Memory state around the buggy address:")
+	kasanSeparator    = []byte("==================================================================")
+
+	// rebootBanners are printed early during boot (by firmware, the kernel decompressor and the kernel)
+	// or inserted into console output on reboot.
+	rebootBanners = [][]byte{
+		[]byte("SeaBIOS (version"),
+		[]byte("SLOF *****"), // ppc64 firmware
+		[]byte("Booting the kernel."),
+		[]byte("Booting Linux via __start()"), // ppc64 prom_init
+		[]byte("Linux version "),
+		[]byte(vmimpl.KmsgRebootMarker),
+	}
+
 	beforeContext = 1024 << 10
 	afterContext  = 128 << 10
 
 	tickerPeriod         = 10 * time.Second
 	noOutputTimeout      = 5 * time.Minute
 	waitForOutputTimeout = 10 * time.Second
+	lockdepTimeout       = time.Minute
+	kasanShadowTimeout   = time.Minute
+
+	maxKasanShadowLength = 64 << 10
 )
+
+// monitorTimeouts are timeouts of MonitorExecution. Pools copy them from the package defaults
+// (and the first output timeout from the config) on creation, so that tests can shorten them for a single pool.
+type monitorTimeouts struct {
+	ticker        time.Duration
+	noOutput      time.Duration
+	waitForOutput time.Duration
+	lockdep       time.Duration
+	kasanShadow   time.Duration
+	firstOutput   time.Duration // for the first output after Run, 0 means noOutput
+}
+
+func defaultMonitorTimeouts() monitorTimeouts {
+	return monitorTimeouts{
+		ticker:        tickerPeriod,
+		noOutput:      noOutputTimeout,
+		waitForOutput: waitForOutputTimeout,
+		lockdep:       lockdepTimeout,
+		kasanShadow:   kasanShadowTimeout,
+	}
+}
@@ -5,9 +5,15 @@ package vm
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -25,19 +31,172 @@ func (pool *testPool) Count() int {
 
 func (pool *testPool) Create(workdir string, index int) (vmimpl.Instance, error) {
 	return &testInstance{
-		outc: make(chan []byte, 10),
-		errc: make(chan error, 1),
+		outc:        make(chan []byte, 10),
+		errc:        make(chan error, 1),
+		maintenance: make(chan bool, 2),
 	}, nil
 }
 
+// testSharedPool additionally supports sharing of files between VMs.
+type testSharedPool struct {
+	testPool
+	shared []string
+}
+
+func (pool *testSharedPool) Share(hostSrc string) (string, error) {
+	pool.shared = append(pool.shared, hostSrc)
+	return "/shared/" + filepath.Base(hostSrc), nil
+}
+
+// testUnmountedPool supports sharing, but its VMs fail to access the shared files.
+type testUnmountedPool struct {
+	testSharedPool
+}
+
+func (pool *testUnmountedPool) Create(workdir string, index int) (vmimpl.Instance, error) {
+	inst, err := pool.testSharedPool.Create(workdir, index)
+	if err != nil {
+		return nil, err
+	}
+	inst.(*testInstance).unmounted = true
+	return inst, nil
+}
+
+// testNoisyPool creates VMs that print vendor noise on the console and filters it out.
+type testNoisyPool struct {
+	testPool
+}
+
+func (pool *testNoisyPool) OutputFilter() func(line []byte) []byte {
+	return func(line []byte) []byte {
+		if bytes.HasPrefix(line, []byte("NOISE: ")) {
+			return nil
+		}
+		return line
+	}
+}
+
+// testReplayPool creates instances that run scripted commands:
+// commands that mention "crasher" crash the kernel, all others exit successfully.
+// Reading of kernel taint returns taint.
+type testReplayPool struct {
+	mu       sync.Mutex
+	creates  int
+	commands []string
+	taint    int
+}
+
+func (pool *testReplayPool) Count() int {
+	return 2
+}
+
+func (pool *testReplayPool) Create(workdir string, index int) (vmimpl.Instance, error) {
+	pool.mu.Lock()
+	pool.creates++
+	pool.mu.Unlock()
+	return &testReplayInstance{testInstance: testInstance{outc: make(chan []byte, 10)}, pool: pool}, nil
+}
+
+// testLimitedPool creates instances that support only the mandatory vmimpl.Instance operations:
+// they can't forward ports and can't read files. Instance 0 can set up reverse tunnels.
+type testLimitedPool struct{}
+
+func (pool *testLimitedPool) Count() int {
+	return 2
+}
+
+func (pool *testLimitedPool) Create(workdir string, index int) (vmimpl.Instance, error) {
+	inst := &testLimitedInstance{outc: make(chan []byte, 10), errc: make(chan error, 1)}
+	if index == 0 {
+		return &testTunnelInstance{testLimitedInstance: inst}, nil
+	}
+	return inst, nil
+}
+
+type testLimitedInstance struct {
+	outc chan []byte
+	errc chan error
+}
+
+func (inst *testLimitedInstance) Copy(hostSrc string) (string, error) {
+	return "/vm/" + filepath.Base(hostSrc), nil
+}
+
+func (inst *testLimitedInstance) Forward(port int) (string, error) {
+	return "", vmimpl.ErrUnsupported
+}
+
+func (inst *testLimitedInstance) Run(timeout time.Duration, stop <-chan bool, command string) (
+	<-chan []byte, <-chan error, error) {
+	return inst.outc, inst.errc, nil
+}
+
+func (inst *testLimitedInstance) Diagnose() bool {
+	return false
+}
+
+func (inst *testLimitedInstance) Close() {
+}
+
+type testTunnelInstance struct {
+	*testLimitedInstance
+}
+
+func (inst *testTunnelInstance) ReverseTunnel(port int) (string, error) {
+	return fmt.Sprintf("127.0.0.1:%v", port+1), nil
+}
+
+type testReplayInstance struct {
+	testInstance
+	pool *testReplayPool
+}
+
+func (inst *testReplayInstance) Run(timeout time.Duration, stop <-chan bool, command string) (
+	<-chan []byte, <-chan error, error) {
+	inst.pool.mu.Lock()
+	inst.pool.commands = append(inst.pool.commands, command)
+	inst.pool.mu.Unlock()
+	errc := make(chan error, 1)
+	if strings.Contains(command, "/proc/sys/kernel/tainted") {
+		inst.outc <- []byte(fmt.Sprintf("SYZ-TAINTED=%v\n", inst.pool.taint))
+		errc <- nil
+	} else if strings.Contains(command, "crasher") {
+		inst.outc <- []byte("executing program\nBUG: replayed crash\n")
+	} else {
+		inst.outc <- []byte("executing program\n")
+		errc <- nil
+	}
+	return inst.outc, errc, nil
+}
+
 type testInstance struct {
 	outc        chan []byte
 	errc        chan error
 	diagnoseBug bool
+	copied      []string
+	copyErrs    []error // errors of the next copies
+	pstore      []byte
+	maintenance chan bool
+	files       map[string][]byte // files in VM
+	commands    map[string]testCommand
+	artifacts   []string
+	probeOutput string // output produced by Probe, Probe fails if empty
+	cmdline     string
+	unmounted   bool // shared files are not accessible
 }
 
 func (inst *testInstance) Copy(hostSrc string) (string, error) {
-	return "", nil
+	if len(inst.copyErrs) != 0 {
+		err := inst.copyErrs[0]
+		inst.copyErrs = inst.copyErrs[1:]
+		return "", err
+	}
+	inst.copied = append(inst.copied, hostSrc)
+	return "/vm/" + filepath.Base(hostSrc), nil
+}
+
+func (inst *testInstance) SharedFiles() bool {
+	return !inst.unmounted
 }
 
 func (inst *testInstance) Forward(port int) (string, error) {
@@ -58,6 +217,52 @@ func (inst *testInstance) Diagnose() bool {
 	return true
 }
 
+func (inst *testInstance) Probe() bool {
+	if inst.probeOutput == "" {
+		return false
+	}
+	inst.outc <- []byte(inst.probeOutput)
+	return true
+}
+
+func (inst *testInstance) ReadPstore() ([]byte, error) {
+	return inst.pstore, nil
+}
+
+func (inst *testInstance) ReadFile(file string) ([]byte, error) {
+	data, ok := inst.files[file]
+	if !ok {
+		return nil, fmt.Errorf("no such file: %v", file)
+	}
+	return data, nil
+}
+
+type testCommand struct {
+	stdout   string
+	stderr   string
+	exitCode int
+}
+
+func (inst *testInstance) Exec(command string, timeout time.Duration) ([]byte, []byte, int, error) {
+	cmd, ok := inst.commands[command]
+	if !ok {
+		return nil, nil, 0, fmt.Errorf("can't run %q", command)
+	}
+	return []byte(cmd.stdout), []byte(cmd.stderr), cmd.exitCode, nil
+}
+
+func (inst *testInstance) Artifacts() []string {
+	return inst.artifacts
+}
+
+func (inst *testInstance) KernelCmdline() string {
+	return inst.cmdline
+}
+
+func (inst *testInstance) Maintenance() <-chan bool {
+	return inst.maintenance
+}
+
 func (inst *testInstance) Close() {
 }
 
@@ -71,13 +276,53 @@ func init() {
 		return &testPool{}, nil
 	}
 	vmimpl.Register("test", ctor, false)
+	sharedCtor := func(env *vmimpl.Env) (vmimpl.Pool, error) {
+		return &testSharedPool{}, nil
+	}
+	vmimpl.Register("test-shared", sharedCtor, false)
+	unmountedCtor := func(env *vmimpl.Env) (vmimpl.Pool, error) {
+		return &testUnmountedPool{}, nil
+	}
+	vmimpl.Register("test-unmounted", unmountedCtor, false)
+	replayCtor := func(env *vmimpl.Env) (vmimpl.Pool, error) {
+		return &testReplayPool{}, nil
+	}
+	vmimpl.Register("test-replay", replayCtor, false)
+	limitedCtor := func(env *vmimpl.Env) (vmimpl.Pool, error) {
+		return &testLimitedPool{}, nil
+	}
+	vmimpl.Register("test-limited", limitedCtor, false)
+	noisyCtor := func(env *vmimpl.Env) (vmimpl.Pool, error) {
+		return &testNoisyPool{}, nil
+	}
+	vmimpl.Register("test-noisy", noisyCtor, false)
+}
+
+// shortTimeouts let tests that wait for the no output timeout finish in less than a second.
+var shortTimeouts = &monitorTimeouts{
+	ticker:        10 * time.Millisecond,
+	noOutput:      250 * time.Millisecond,
+	waitForOutput: 100 * time.Millisecond,
 }
 
 type Test struct {
 	Name        string
-	CanExit     bool // if the program is allowed to exit normally
-	DiagnoseBug bool // Diagnose produces output that is detected as kernel crash
+	CanExit     bool                      // if the program is allowed to exit normally
+	DiagnoseBug bool                      // Diagnose produces output that is detected as kernel crash
+	DedupOutput bool                      // enable dedup of repeated output
+	Pstore      []byte                    // enable read_pstore, pstore records recovered after reboot
+	Preemption  []string                  // preemption_markers config
+	FirstOutput time.Duration             // overrides the first output timeout
+	ProbeOutput string                    // output of the kernel probe, the probe fails if empty
+	Security    []mgrconfig.SecurityEvent // security_events config
+	InfraError  bool                      // the VM fails because of the host
+	Liveness    int                       // liveness_check timeout
+	Unrecog     int                       // unrecognized_crashes threshold
+	Cmdline     string                    // enable crash_cmdline, the kernel command line of the VM
+	WaitOutput  time.Duration             // overrides waitForOutputTimeout
+	Timeouts    *monitorTimeouts          // overrides all timeouts of the pool
 	Body        func(outc chan []byte, errc chan error)
+	Maintenance func(maintenance chan bool) // simulates host maintenance events
 	Report      *report.Report
 }
 
@@ -100,6 +345,41 @@ var tests = []*Test{
 			Title: lostConnectionCrash,
 		},
 	},
+	{
+		Name:       "host-oom-kill",
+		InfraError: true,
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("executing program\n")
+			errc <- &vmimpl.InfraError{Err: errors.New("qemu was killed by the host OOM killer")}
+		},
+	},
+	{
+		// The same oops is fatal or non-fatal depending on whether the fuzzer continues after it.
+		Name:     "liveness-non-fatal",
+		Liveness: 5,
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("executing program\nBUG: bad\n")
+			time.Sleep(time.Second)
+			outc <- []byte("executing program\n")
+		},
+		Report: &report.Report{
+			Title:    "BUG: bad",
+			Report:   []byte("executing program\nBUG: bad\nexecuting program\n"),
+			Liveness: report.LivenessNonFatal,
+		},
+	},
+	{
+		Name:     "liveness-fatal",
+		Liveness: 1,
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("executing program\nBUG: bad\n")
+		},
+		Report: &report.Report{
+			Title:    "BUG: bad",
+			Report:   []byte("executing program\nBUG: bad\n"),
+			Liveness: report.LivenessFatal,
+		},
+	},
 	{
 		Name:        "#875-diagnose-bugs",
 		CanExit:     true,
@@ -114,14 +394,31 @@ var tests = []*Test{
 			errc <- nil
 		},
 		Report: &report.Report{
-			Title: lostConnectionCrash,
-			Output: []byte(
-				"DIAGNOSE\n",
-			),
+			Title:     lostConnectionCrash,
+			Output:    []byte{},
+			Diagnosis: []byte("DIAGNOSE\n"),
 		},
 	},
 	{
 		Name: "kernel-crashes",
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("executing program\n")
+			outc <- []byte("BUG: bad\n")
+			time.Sleep(time.Second)
+			outc <- []byte("other output\n")
+		},
+		Report: &report.Report{
+			Title: "BUG: bad",
+			Report: []byte(
+				"executing program\n" +
+					"BUG: bad\n" +
+					"other output\n",
+			),
+			Diagnosis: []byte("DIAGNOSE\n"),
+		},
+	},
+	{
+		Name: "boot-warning",
 		Body: func(outc chan []byte, errc chan error) {
 			outc <- []byte("BUG: bad\n")
 			time.Sleep(time.Second)
@@ -129,11 +426,142 @@ var tests = []*Test{
 		},
 		Report: &report.Report{
 			Title: "BUG: bad",
+			Class: report.ClassBootWarning,
 			Report: []byte(
 				"BUG: bad\n" +
-					"DIAGNOSE\n" +
 					"other output\n",
 			),
+			Diagnosis: []byte("DIAGNOSE\n"),
+		},
+	},
+	{
+		Name: "boot-warning-split-marker",
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("01:02:03 execut")
+			outc <- []byte("ing program 0:\n")
+			outc <- []byte("BUG: bad\n")
+			time.Sleep(time.Second)
+			outc <- []byte("other output\n")
+		},
+		Report: &report.Report{
+			Title: "BUG: bad",
+			Report: []byte(
+				"01:02:03 executing program 0:\n" +
+					"BUG: bad\n" +
+					"other output\n",
+			),
+			Diagnosis: []byte("DIAGNOSE\n"),
+		},
+	},
+	{
+		Name: "kernel-crashes-and-reboots",
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("executing program\n")
+			outc <- []byte("BUG: bad\n" +
+				"Kernel panic - not syncing: panic_on_warn set ...\n" +
+				"Rebooting in 1 seconds..\n" +
+				"SeaBIOS (version 1.10.2-1)\n" +
+				"Booting the kernel.\n")
+			time.Sleep(time.Second)
+			outc <- []byte("[    0.000000] Linux version 4.19.0\n")
+		},
+		Report: &report.Report{
+			Title: "BUG: bad",
+			Report: []byte(
+				"executing program\n" +
+					"BUG: bad\n" +
+					"Kernel panic - not syncing: panic_on_warn set ...\n" +
+					"Rebooting in 1 seconds..\n",
+			),
+			Output: []byte(
+				"executing program\n" +
+					"BUG: bad\n" +
+					"Kernel panic - not syncing: panic_on_warn set ...\n" +
+					"Rebooting in 1 seconds..\n",
+			),
+		},
+	},
+	{
+		// Diagnose is postponed until the crash is printed, by that time the guest has rebooted.
+		Name: "kernel-crashes-and-reboots-before-diagnose",
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("executing program\n")
+			outc <- []byte("BUG: bad\n")
+			time.Sleep(time.Second)
+			outc <- []byte("Rebooting in 1 seconds..\n" +
+				"[    0.000000] Linux version 4.19.0\n" +
+				"[    0.000000] Command line: console=ttyS0\n")
+		},
+		Report: &report.Report{
+			Title: "BUG: bad",
+			Report: []byte(
+				"executing program\n" +
+					"BUG: bad\n" +
+					"Rebooting in 1 seconds..\n",
+			),
+			Diagnosis: []byte{},
+		},
+	},
+	{
+		Name: "kernel-crashes-lossy-console",
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("executing program\n")
+			outc <- []byte("[   10.000001] kernel: some mes[   10.000002] kernel: other message\n")
+			outc <- []byte("BUG: bad\n")
+			time.Sleep(time.Second)
+			outc <- []byte("other output\n")
+		},
+		Report: &report.Report{
+			Title: "BUG: bad",
+			Report: []byte(
+				"executing program\n" +
+					"[   10.000001] kernel: some mes[   10.000002] kernel: other message\n" +
+					"BUG: bad\n" +
+					"other output\n",
+			),
+			Diagnosis:   []byte("DIAGNOSE\n"),
+			Incomplete:  true,
+			GuestUptime: 10*time.Second + 2*time.Microsecond,
+		},
+	},
+	{
+		Name: "kernel-crashes-with-timestamps",
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("executing program\n")
+			outc <- []byte("[ 3600.000001] kernel: some message\n")
+			outc <- []byte("[ 3723.500000][ T1234] BUG: bad\n")
+			time.Sleep(time.Second)
+			outc <- []byte("[ 3730.000000] other output\n")
+		},
+		Report: &report.Report{
+			Title: "BUG: bad",
+			Report: []byte(
+				"executing program\n" +
+					"[ 3600.000001] kernel: some message\n" +
+					"[ 3723.500000][ T1234] BUG: bad\n" +
+					"[ 3730.000000] other output\n",
+			),
+			Diagnosis:   []byte("DIAGNOSE\n"),
+			GuestUptime: 3723500 * time.Millisecond,
+		},
+	},
+	{
+		Name: "kernel-panics-diagnose-mid-trace",
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("executing program\n")
+			outc <- []byte(panicTrace1)
+			// Diagnose must not be inserted into the middle of the Call Trace.
+			time.Sleep(time.Second)
+			outc <- []byte(panicTrace2)
+		},
+		Report: &report.Report{
+			Title: "kernel panic: Fatal exception",
+			Report: []byte(
+				"executing program\n" +
+					panicTrace1 +
+					panicTrace2,
+			),
+			Diagnosis: []byte("DIAGNOSE\n"),
 		},
 	},
 	{
@@ -143,6 +571,93 @@ var tests = []*Test{
 			outc <- []byte(fuzzerPreemptedStr + "\n")
 		},
 	},
+	{
+		Name:       "custom-preemption-marker",
+		Preemption: []string{"EXECUTOR: HOST SHUTDOWN"},
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("BUG: bad\n")
+			outc <- []byte("EXECUTOR: HOST SHUTDOWN\n")
+		},
+	},
+	{
+		Name: "fuzzer-requests-restart",
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("proc 0: program prologue failed\n")
+			outc <- []byte(fuzzerRestartStr + "\n")
+			errc <- fmt.Errorf("fuzzer exited")
+		},
+	},
+	{
+		Name:       "lockdep-chain",
+		WaitOutput: 100 * time.Millisecond,
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("executing program\n")
+			outc <- []byte(lockdepReport1)
+			// Longer than WaitOutput, the monitor must wait for the rest of the chain.
+			time.Sleep(500 * time.Millisecond)
+			outc <- []byte(lockdepReport2)
+		},
+		Report: &report.Report{
+			Title: "possible deadlock in &xt[i].mutex -> sk_lock-AF_INET -> rtnl_mutex",
+			Report: []byte(
+				"executing program\n" +
+					lockdepReport1 +
+					lockdepReport2,
+			),
+			Diagnosis: []byte("DIAGNOSE\n"),
+		},
+	},
+	{
+		Name: "pstore-recovers-hang",
+		Body: func(outc chan []byte, errc chan error) {
+			errc <- fmt.Errorf("lost connection")
+		},
+		Pstore: []byte("Kernel panic - not syncing: Watchdog detected hard LOCKUP on cpu 0\n"),
+		Report: &report.Report{
+			Title:  "kernel panic: Watchdog detected hard LOCKUP on cpu 0",
+			Report: []byte("Kernel panic - not syncing: Watchdog detected hard LOCKUP on cpu 0\n"),
+			Output: []byte(pstoreHeader +
+				"Kernel panic - not syncing: Watchdog detected hard LOCKUP on cpu 0\n"),
+			Diagnosis: []byte("DIAGNOSE\n"),
+		},
+	},
+	{
+		Name: "pstore-attached-to-crash",
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("executing program\n")
+			outc <- []byte("BUG: bad\n")
+		},
+		Pstore: []byte("pstore record\n"),
+		Report: &report.Report{
+			Title:     "BUG: bad",
+			Report:    []byte("executing program\nBUG: bad\n" + pstoreHeader + "pstore record\n"),
+			Diagnosis: []byte("DIAGNOSE\n"),
+		},
+	},
+	{
+		Name:        "dedup-output",
+		DedupOutput: true,
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("executing program\n")
+			for i := 0; i < 5; i++ {
+				outc <- []byte("[  100.000001] flood\n[  100.000002] foo\n[  100.000003] bar\n")
+			}
+			outc <- []byte("BUG: bad\n")
+		},
+		Report: &report.Report{
+			Title: "BUG: bad",
+			Report: []byte(
+				"executing program\n" +
+					"[  100.000001] flood\n" +
+					"[  100.000002] foo\n" +
+					"[  100.000003] bar\n" +
+					"syzkaller: previous 3 lines repeated 4 more times\n" +
+					"BUG: bad\n",
+			),
+			Diagnosis:   []byte("DIAGNOSE\n"),
+			GuestUptime: 100*time.Second + 3*time.Microsecond,
+		},
+	},
 	{
 		Name:    "program-exits-but-kernel-crashes-afterwards",
 		CanExit: true,
@@ -154,9 +669,9 @@ var tests = []*Test{
 		Report: &report.Report{
 			Title: "BUG: bad",
 			Report: []byte(
-				"BUG: bad\n" +
-					"DIAGNOSE\n",
+				"BUG: bad\n",
 			),
+			Diagnosis: []byte("DIAGNOSE\n"),
 		},
 	},
 	{
@@ -182,6 +697,16 @@ var tests = []*Test{
 			Title: noOutputCrash,
 		},
 	},
+	{
+		Name:    "no-output-cmdline",
+		Cmdline: "console=ttyS0 root=/dev/sda1 ip=bad",
+		Body: func(outc chan []byte, errc chan error) {
+		},
+		Report: &report.Report{
+			Title:   noOutputCrash,
+			Cmdline: "console=ttyS0 root=/dev/sda1 ip=bad",
+		},
+	},
 	{
 		Name: "no-output-2",
 		Body: func(outc chan []byte, errc chan error) {
@@ -195,30 +720,118 @@ var tests = []*Test{
 		},
 	},
 	{
-		Name:    "no-no-output-1",
-		CanExit: true,
+		Name:     "no-no-output-maintenance",
+		CanExit:  true,
+		Timeouts: shortTimeouts,
 		Body: func(outc chan []byte, errc chan error) {
-			for i := 0; i < 5; i++ {
-				time.Sleep(time.Second)
-				outc <- []byte(executingProgramStr1 + "\n")
-			}
+			outc <- []byte(executingProgramStr1 + "\n")
+			time.Sleep(450 * time.Millisecond)
 			errc <- nil
 		},
+		Maintenance: func(maintenance chan bool) {
+			// The VM is paused for longer than the no output timeout.
+			time.Sleep(50 * time.Millisecond)
+			maintenance <- true
+			time.Sleep(350 * time.Millisecond)
+			maintenance <- false
+		},
 	},
 	{
-		Name:    "no-no-output-2",
-		CanExit: true,
+		Name:     "no-output-after-maintenance",
+		Timeouts: shortTimeouts,
 		Body: func(outc chan []byte, errc chan error) {
-			for i := 0; i < 5; i++ {
-				time.Sleep(time.Second)
-				outc <- []byte(executingProgramStr2 + "\n")
+			outc <- []byte(executingProgramStr1 + "\n")
+		},
+		Maintenance: func(maintenance chan bool) {
+			time.Sleep(50 * time.Millisecond)
+			maintenance <- true
+			time.Sleep(100 * time.Millisecond)
+			maintenance <- false
+		},
+		Report: &report.Report{
+			Title: noOutputCrash,
+		},
+	},
+	{
+		Name:        "no-no-output-first-output",
+		CanExit:     true,
+		Timeouts:    shortTimeouts,
+		FirstOutput: 500 * time.Millisecond,
+		Body: func(outc chan []byte, errc chan error) {
+			// Longer than the no output timeout, but shorter than the first output timeout (slow boot).
+			time.Sleep(350 * time.Millisecond)
+			for i := 0; i < 8; i++ {
+				time.Sleep(50 * time.Millisecond)
+				outc <- []byte(executingProgramStr1 + "\n")
 			}
 			errc <- nil
 		},
 	},
 	{
-		Name:    "outc-closed",
-		CanExit: true,
+		Name:        "no-output-console-stall-recovered",
+		CanExit:     true,
+		ProbeOutput: "sysrq: Show backtrace of all active CPUs\n",
+		Timeouts:    shortTimeouts,
+		Body: func(outc chan []byte, errc chan error) {
+			// Longer than the no output timeout, but the kernel responds to the probe.
+			time.Sleep(450 * time.Millisecond)
+			errc <- nil
+		},
+	},
+	{
+		Name:        "no-output-after-probe",
+		ProbeOutput: "sysrq: Show backtrace of all active CPUs\n",
+		Timeouts:    shortTimeouts,
+		Body: func(outc chan []byte, errc chan error) {
+			// The kernel is alive, but does not execute programs.
+			// It's probed only once, the second silence period is a hang.
+			outc <- []byte(executingProgramStr1 + "\n")
+			time.Sleep(800 * time.Millisecond)
+		},
+		Report: &report.Report{
+			Title: noOutputCrash,
+		},
+	},
+	{
+		Name:        "no-output-after-first-output",
+		Timeouts:    shortTimeouts,
+		FirstOutput: time.Minute,
+		Body: func(outc chan []byte, errc chan error) {
+			// After the first output only the no output timeout applies.
+			time.Sleep(50 * time.Millisecond)
+			outc <- []byte("booting\n")
+			time.Sleep(50 * time.Millisecond)
+			outc <- []byte(executingProgramStr1 + "\n")
+		},
+		Report: &report.Report{
+			Title: noOutputCrash,
+		},
+	},
+	{
+		Name:    "no-no-output-1",
+		CanExit: true,
+		Body: func(outc chan []byte, errc chan error) {
+			for i := 0; i < 5; i++ {
+				time.Sleep(time.Second)
+				outc <- []byte(executingProgramStr1 + "\n")
+			}
+			errc <- nil
+		},
+	},
+	{
+		Name:    "no-no-output-2",
+		CanExit: true,
+		Body: func(outc chan []byte, errc chan error) {
+			for i := 0; i < 5; i++ {
+				time.Sleep(time.Second)
+				outc <- []byte(executingProgramStr2 + "\n")
+			}
+			errc <- nil
+		},
+	},
+	{
+		Name:    "outc-closed",
+		CanExit: true,
 		Body: func(outc chan []byte, errc chan error) {
 			close(outc)
 			time.Sleep(time.Second)
@@ -236,8 +849,211 @@ var tests = []*Test{
 			errc <- vmimpl.ErrTimeout
 		},
 	},
+	{
+		Name:     "security-event",
+		Security: testSecurityEvents,
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("some output\n")
+			outc <- []byte("syz-executor3: escaped to host netns ")
+			outc <- []byte("(ino 4026531993)\nmore output\n")
+		},
+		Report: &report.Report{
+			Title: SecurityEventPrefix + "netns escape",
+			Class: report.ClassSecurityEvent,
+			Report: []byte(
+				"syz-executor3: escaped to host netns (ino 4026531993)\n",
+			),
+			Output: []byte(
+				"some output\n" +
+					"syz-executor3: escaped to host netns (ino 4026531993)\n" +
+					"more output\n",
+			),
+		},
+	},
+	{
+		// Crashes are still reported as crashes when security events are configured.
+		Name:     "security-event-crash",
+		Security: testSecurityEvents,
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("executing program\n")
+			outc <- []byte("BUG: bad\n")
+		},
+		Report: &report.Report{
+			Title: "BUG: bad",
+			Class: report.ClassCrash,
+			Report: []byte(
+				"executing program\n" +
+					"BUG: bad\n",
+			),
+			Diagnosis: []byte("DIAGNOSE\n"),
+		},
+	},
+	{
+		// Output looks like a crash, but the reporter does not know the header.
+		Name:    "unrecognized-crash",
+		Unrecog: 3,
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("executing program\n")
+			outc <- []byte("mysterious oops\n" + testRegisterDump)
+			outc <- []byte("\nsome output\n")
+		},
+		Report: &report.Report{
+			Title:  unrecognizedCrash,
+			Class:  report.ClassCrash,
+			Report: []byte(testRegisterDump),
+			Output: []byte("executing program\nmysterious oops\n" + testRegisterDump + "\nsome output\n"),
+		},
+	},
+	{
+		// Without the heuristic the same crash is lost.
+		Name:    "unrecognized-crash-disabled",
+		CanExit: true,
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("executing program\n")
+			outc <- []byte("mysterious oops\n" + testRegisterDump)
+			time.Sleep(time.Second)
+			errc <- nil
+		},
+	},
+	{
+		// And mislabeled as lost connection.
+		Name: "unrecognized-crash-disabled-lost-connection",
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("executing program\n")
+			outc <- []byte("mysterious oops\n" + testRegisterDump)
+			time.Sleep(time.Second)
+			errc <- nil
+		},
+		Report: &report.Report{
+			Title: lostConnectionCrash,
+		},
+	},
+	{
+		// The VM dies right after the output, it's still described by the output.
+		Name:    "unrecognized-crash-lost-connection",
+		Unrecog: 3,
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("executing program\n")
+			outc <- []byte("mysterious oops\n" + testRegisterDump)
+			time.Sleep(100 * time.Millisecond)
+			errc <- errors.New("lost connection")
+		},
+		Report: &report.Report{
+			Title:  unrecognizedCrash,
+			Class:  report.ClassCrash,
+			Report: []byte(testRegisterDump),
+		},
+	},
+	{
+		// A single marker is not enough to consider output a crash.
+		Name:    "unrecognized-crash-below-threshold",
+		CanExit: true,
+		Unrecog: 3,
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("executing program\n")
+			outc <- []byte("Call Trace: is a nice name for a file\n")
+			time.Sleep(time.Second)
+			errc <- nil
+		},
+	},
+	{
+		// Crashes that the reporter recognizes are still reported as usual.
+		Name:    "unrecognized-crash-recognized",
+		Unrecog: 3,
+		Body: func(outc chan []byte, errc chan error) {
+			outc <- []byte("executing program\n")
+			outc <- []byte("BUG: bad\n" + testRegisterDump)
+		},
+		Report: &report.Report{
+			Title: "BUG: bad",
+			Class: report.ClassCrash,
+			Report: []byte(
+				"executing program\n" +
+					"BUG: bad\n" + testRegisterDump,
+			),
+			Diagnosis: []byte("DIAGNOSE\n"),
+		},
+	},
 }
 
+const testRegisterDump = `RIP: 0010:mystery_func+0x1c/0x40
+RSP: 0018:ffffc90000a37d58 EFLAGS: 00010246
+RAX: 0000000000000000 RBX: ffff88806a5e0000 RCX: 0000000000000001
+Call Trace:
+ mystery_caller+0x12/0x30
+ do_syscall_64+0x1b1/0x800
+`
+
+var testSecurityEvents = []mgrconfig.SecurityEvent{
+	{Name: "netns escape", Regexp: `^syz-executor[0-9]*: escaped to host netns`},
+}
+
+const lockdepReport1 = `======================================================
+WARNING: possible circular locking dependency detected
+4.15.0+ #221 Not tainted
+------------------------------------------------------
+syz-executor5/5807 is trying to acquire lock:
+ (sk_lock-AF_INET){+.+.}, at: [<0000000046bbd7df>] do_ip_setsockopt.isra.12+0x1d9/0x3210
+
+but task is already holding lock:
+ (rtnl_mutex){+.+.}, at: [<00000000366b10e7>] rtnl_lock+0x17/0x20
+
+which lock already depends on the new lock.
+
+
+the existing dependency chain (in reverse order) is:
+`
+
+const lockdepReport2 = `
+-> #2 (rtnl_mutex){+.+.}:
+       __mutex_lock+0x16f/0x1a80
+       mutex_lock_nested+0x16/0x20
+       rtnl_lock+0x17/0x20
+       unregister_netdevice_notifier+0x91/0x4e0
+       clusterip_tg_destroy+0x389/0x6e0
+       SyS_setsockopt+0x189/0x360
+       entry_SYSCALL_64_fastpath+0x29/0xa0
+
+-> #1 (&xt[i].mutex){+.+.}:
+       __mutex_lock+0x16f/0x1a80
+       mutex_lock_nested+0x16/0x20
+       xt_find_table_lock+0x3e/0x3e0
+       do_ipt_get_ctl+0x159/0xac0
+       SyS_getsockopt+0x178/0x340
+       entry_SYSCALL_64_fastpath+0x29/0xa0
+
+-> #0 (sk_lock-AF_INET){+.+.}:
+       lock_acquire+0x1d5/0x580
+       lock_sock_nested+0xc2/0x110
+       do_ip_setsockopt.isra.12+0x1d9/0x3210
+       ip_setsockopt+0x3a/0xa0
+       SyS_setsockopt+0x189/0x360
+       entry_SYSCALL_64_fastpath+0x29/0xa0
+
+other info that might help us debug this:
+
+Chain exists of:
+  sk_lock-AF_INET --> &xt[i].mutex --> rtnl_mutex
+
+ *** DEADLOCK ***
+
+1 lock held by syz-executor5/5807:
+ #0:  (rtnl_mutex){+.+.}, at: [<00000000366b10e7>] rtnl_lock+0x17/0x20
+
+stack backtrace:
+CPU: 0 PID: 5807 Comm: syz-executor5 Not tainted 4.15.0+ #221
+Call Trace:
+ dump_stack+0x194/0x257
+ print_circular_bug.isra.38+0x2cd/0x2dc
+ __lock_acquire+0x30a8/0x3e00
+ lock_acquire+0x1d5/0x580
+ lock_sock_nested+0xc2/0x110
+ do_ip_setsockopt.isra.12+0x1d9/0x3210
+ ip_setsockopt+0x3a/0xa0
+ SyS_setsockopt+0x189/0x360
+ entry_SYSCALL_64_fastpath+0x29/0xa0
+`
+
 func TestMonitorExecution(t *testing.T) {
 	for _, test := range tests {
 		test := test
@@ -249,25 +1065,27 @@ func TestMonitorExecution(t *testing.T) {
 }
 
 func testMonitorExecution(t *testing.T, test *Test) {
-	dir, err := ioutil.TempDir("", "syz-vm-test")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.RemoveAll(dir)
 	cfg := &mgrconfig.Config{
-		Workdir:      dir,
-		TargetOS:     "linux",
-		TargetArch:   "amd64",
-		TargetVMArch: "amd64",
-		Type:         "test",
+		DedupOutput:         test.DedupOutput,
+		ReadPstore:          test.Pstore != nil,
+		PreemptionMarkers:   test.Preemption,
+		SecurityEvents:      test.Security,
+		LivenessCheck:       mgrconfig.LivenessCheck{Timeout: test.Liveness},
+		UnrecognizedCrashes: mgrconfig.UnrecognizedCrashes{Threshold: test.Unrecog},
+		CrashCmdline:        test.Cmdline != "",
 	}
-	pool, err := Create(cfg, false)
-	if err != nil {
-		t.Fatal(err)
+	pool, reporter := createTestPool(t, cfg)
+	defer os.RemoveAll(cfg.Workdir)
+	// The tests simulate output that comes with delays, so they need longer output waits.
+	pool.timeouts = defaultMonitorTimeouts()
+	if test.Timeouts != nil {
+		pool.timeouts = *test.Timeouts
 	}
-	reporter, err := report.NewReporter(cfg)
-	if err != nil {
-		t.Fatal(err)
+	if test.WaitOutput != 0 {
+		pool.timeouts.waitForOutput = test.WaitOutput
+	}
+	if test.FirstOutput != 0 {
+		pool.timeouts.firstOutput = test.FirstOutput
 	}
 	inst, err := pool.Create(0)
 	if err != nil {
@@ -280,6 +1098,12 @@ func testMonitorExecution(t *testing.T, test *Test) {
 	}
 	testInst := inst.impl.(*testInstance)
 	testInst.diagnoseBug = test.DiagnoseBug
+	testInst.pstore = test.Pstore
+	testInst.probeOutput = test.ProbeOutput
+	testInst.cmdline = test.Cmdline
+	if test.Maintenance != nil {
+		go test.Maintenance(testInst.maintenance)
+	}
 	done := make(chan bool)
 	go func() {
 		test.Body(testInst.outc, testInst.errc)
@@ -287,6 +1111,12 @@ func testMonitorExecution(t *testing.T, test *Test) {
 	}()
 	rep := inst.MonitorExecution(outc, errc, reporter, test.CanExit)
 	<-done
+	if stalls, want := pool.ConsoleStallsRecovered(), test.ProbeOutput != ""; (stalls != 0) != want {
+		t.Fatalf("got %v recovered console stalls", stalls)
+	}
+	if infra := pool.InfraErrors(); (infra != 0) != test.InfraError {
+		t.Fatalf("got %v infra errors", infra)
+	}
 	if test.Report != nil && rep == nil {
 		t.Fatalf("got no report")
 	}
@@ -299,10 +1129,624 @@ func testMonitorExecution(t *testing.T, test *Test) {
 	if test.Report.Title != rep.Title {
 		t.Fatalf("want title %q, got title %q", test.Report.Title, rep.Title)
 	}
+	if test.Report.Class != rep.Class {
+		t.Fatalf("want class %q, got class %q", test.Report.Class, rep.Class)
+	}
+	if test.Report.Liveness != rep.Liveness {
+		t.Fatalf("want liveness %q, got liveness %q", test.Report.Liveness, rep.Liveness)
+	}
+	if test.Report.Cmdline != rep.Cmdline {
+		t.Fatalf("want cmdline %q, got cmdline %q", test.Report.Cmdline, rep.Cmdline)
+	}
 	if !bytes.Equal(test.Report.Report, rep.Report) {
 		t.Fatalf("want report:\n%s\n\ngot report:\n%s\n", test.Report.Report, rep.Report)
 	}
 	if test.Report.Output != nil && !bytes.Equal(test.Report.Output, rep.Output) {
 		t.Fatalf("want output:\n%s\n\ngot output:\n%s\n", test.Report.Output, rep.Output)
 	}
+	if test.Report.Diagnosis != nil && !bytes.Equal(test.Report.Diagnosis, rep.Diagnosis) {
+		t.Fatalf("want diagnosis:\n%s\n\ngot diagnosis:\n%s\n", test.Report.Diagnosis, rep.Diagnosis)
+	}
+	if test.Report.Incomplete != rep.Incomplete {
+		t.Fatalf("want incomplete %v, got %v (%v)", test.Report.Incomplete, rep.Incomplete, rep.IncompleteReason)
+	}
+	if rep.Time.IsZero() {
+		t.Fatalf("report time is not set")
+	}
+	if test.Report.GuestUptime != rep.GuestUptime {
+		t.Fatalf("want guest uptime %v, got %v", test.Report.GuestUptime, rep.GuestUptime)
+	}
+}
+
+// createTestPool creates a pool for cfg (test VMs by default) in a new workdir, the caller removes cfg.Workdir.
+// Test VMs produce all output right away, so the pool waits for more output only briefly.
+func createTestPool(t *testing.T, cfg *mgrconfig.Config) (*Pool, report.Reporter) {
+	if cfg.Workdir == "" {
+		dir, err := ioutil.TempDir("", "syz-vm-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Workdir = dir
+	}
+	cfg.TargetOS = "linux"
+	cfg.TargetArch = "amd64"
+	cfg.TargetVMArch = "amd64"
+	if cfg.Type == "" {
+		cfg.Type = "test"
+	}
+	pool, err := Create(cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.timeouts.ticker = 100 * time.Millisecond
+	pool.timeouts.waitForOutput = 200 * time.Millisecond
+	reporter, err := report.NewReporter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool, reporter
+}
+
+// runTestInstance creates a test instance, lets body set it up and produce output
+// and returns the result of MonitorExecution.
+func runTestInstance(t *testing.T, pool *Pool, reporter report.Reporter, canExit bool,
+	body func(inst *testInstance)) *report.Report {
+	inst, err := pool.Create(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inst.Close()
+	outc, errc, err := inst.Run(time.Second, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body(inst.impl.(*testInstance))
+	return inst.MonitorExecution(outc, errc, reporter, canExit)
+}
+
+func TestSharedExecutor(t *testing.T) {
+	tests := []struct {
+		typ    string
+		shared bool
+		want   string
+		copies int // number of files copied into each instance
+		shares int // number of files shared by the pool
+	}{
+		{"test-shared", true, "/shared/syz-executor", 1, 1},
+		{"test-shared", false, "/vm/syz-executor", 2, 0},
+		{"test", true, "/vm/syz-executor", 2, 0},
+		// VMs that failed to mount the shared dir get the executor copied.
+		{"test-unmounted", true, "/vm/syz-executor", 2, 0},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%v-%v", test.typ, test.shared), func(t *testing.T) {
+			cfg := &mgrconfig.Config{
+				Type:           test.typ,
+				SharedExecutor: test.shared,
+				SyzFuzzerBin:   "/bin/syz-fuzzer",
+				SyzExecutorBin: "/bin/syz-executor",
+			}
+			pool, _ := createTestPool(t, cfg)
+			defer os.RemoveAll(cfg.Workdir)
+			for i := 0; i < 2; i++ {
+				inst, err := pool.Create(0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				fuzzer, err := inst.Copy(cfg.SyzFuzzerBin)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fuzzer != "/vm/syz-fuzzer" {
+					t.Fatalf("fuzzer copied to %v", fuzzer)
+				}
+				executor, err := inst.Copy(cfg.SyzExecutorBin)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if executor != test.want {
+					t.Fatalf("executor copied to %v, want %v", executor, test.want)
+				}
+				if copied := inst.impl.(*testInstance).copied; len(copied) != test.copies {
+					t.Fatalf("instance copied %v files, want %v", copied, test.copies)
+				}
+				inst.Close()
+			}
+			var shared []string
+			switch sharedPool := pool.impl.(type) {
+			case *testSharedPool:
+				shared = sharedPool.shared
+			case *testUnmountedPool:
+				shared = sharedPool.shared
+			}
+			if len(shared) != test.shares {
+				t.Fatalf("pool shared %v, want %v files", shared, test.shares)
+			}
+		})
+	}
+}
+
+func TestOutputDedup(t *testing.T) {
+	tests := []struct {
+		input  []string
+		output string
+	}{
+		{
+			input:  []string{"a\nb\nc\n"},
+			output: "a\nb\nc\n",
+		},
+		{
+			input:  []string{"a\n", "a\n", "a\n", "b\n"},
+			output: "a\nsyzkaller: previous 1 lines repeated 2 more times\nb\n",
+		},
+		{
+			// Timestamps are ignored.
+			input: []string{"[ 1.1] a\n[ 1.2] b\n", "[ 1.3] a\n[ 1.4] b\n", "[ 1.5] a\n[ 1.6] b\n", "c\n"},
+			output: "[ 1.1] a\n[ 1.2] b\n" +
+				"syzkaller: previous 2 lines repeated 2 more times\n" +
+				"c\n",
+		},
+		{
+			// Incomplete repeat is not suppressed.
+			input:  []string{"a\nb\nc\n", "a\nb\nc\n", "a\nb\nd\n"},
+			output: "a\nb\nc\nsyzkaller: previous 3 lines repeated 1 more times\na\nb\nd\n",
+		},
+		{
+			// Blocks that differ in addresses are not merged.
+			input: []string{
+				"BUG at ffff880000001000\nfoo+0x10/0x100\n",
+				"BUG at ffff880000002000\nfoo+0x10/0x100\n",
+				"BUG at ffff880000003000\nfoo+0x10/0x100\n",
+			},
+			output: "BUG at ffff880000001000\nfoo+0x10/0x100\n" +
+				"BUG at ffff880000002000\nfoo+0x10/0x100\n" +
+				"BUG at ffff880000003000\nfoo+0x10/0x100\n",
+		},
+	}
+	for i, test := range tests {
+		dd := new(outputDedup)
+		var output []byte
+		for _, input := range test.input {
+			output = append(output, dd.process([]byte(input))...)
+		}
+		output = dd.flush(output)
+		if string(output) != test.output {
+			t.Errorf("test #%v: got output:\n%s\nwant:\n%s", i, output, test.output)
+		}
+	}
+}
+
+func TestOutputFilter(t *testing.T) {
+	// The noise looks like a crash to the generic reporter, and a line may come in several chunks.
+	output := []string{
+		"executing program\n",
+		"NOISE: BUG: vendor firmware ",
+		"fault\n",
+		"NOISE: resetting ...\nexecuting program\n",
+		"BUG: real crash\n",
+	}
+	for _, typ := range []string{"test", "test-noisy"} {
+		cfg := &mgrconfig.Config{Type: typ}
+		pool, reporter := createTestPool(t, cfg)
+		defer os.RemoveAll(cfg.Workdir)
+		rep := runTestInstance(t, pool, reporter, true, func(inst *testInstance) {
+			for _, out := range output {
+				inst.outc <- []byte(out)
+			}
+		})
+		if typ == "test" {
+			// Without the filter the noise is taken for a crash.
+			if rep == nil || rep.Title != "BUG: vendor firmware fault" || rep.RawOutput != nil {
+				t.Fatalf("%v: got report %+v", typ, rep)
+			}
+			continue
+		}
+		if rep == nil || rep.Title != "BUG: real crash" {
+			t.Fatalf("%v: got report %+v", typ, rep)
+		}
+		if bytes.Contains(rep.Output, []byte("NOISE")) || bytes.Contains(rep.Report, []byte("NOISE")) {
+			t.Fatalf("%v: noise in the report:\n%s", typ, rep.Output)
+		}
+		// The raw output is preserved for the crash log.
+		if raw := strings.Join(output, ""); !bytes.HasPrefix(rep.RawOutput, []byte(raw)) {
+			t.Fatalf("%v: got raw output:\n%s\nwant:\n%s", typ, rep.RawOutput, raw)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-vm-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	progDir := filepath.Join(dir, "progs")
+	if err := os.Mkdir(progDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	progs := []string{"prog0", "prog1", "prog2-crasher", "prog3", "prog4"}
+	for _, prog := range progs {
+		if err := ioutil.WriteFile(filepath.Join(progDir, prog), []byte("getpid()\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &mgrconfig.Config{
+		Workdir: dir,
+		Type:    "test-replay",
+	}
+	pool, reporter := createTestPool(t, cfg)
+	opts := &ReplayOptions{
+		Files: []string{"/bin/syz-execprog"},
+		Command: func(files []string, prog string) string {
+			return files[0] + " " + prog
+		},
+		Timeout:  time.Minute,
+		Reporter: reporter,
+	}
+	crashes, err := pool.Replay(progDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crashes) != 1 {
+		t.Fatalf("got %v crashes, want 1", len(crashes))
+	}
+	if want := filepath.Join(progDir, "prog2-crasher"); crashes[0].Prog != want {
+		t.Fatalf("crash is attributed to %v, want %v", crashes[0].Prog, want)
+	}
+	if title := crashes[0].Report.Title; title != "BUG: replayed crash" {
+		t.Fatalf("bad crash title %q", title)
+	}
+	testPool := pool.impl.(*testReplayPool)
+	if len(testPool.commands) != len(progs) {
+		t.Fatalf("ran %v commands, want %v: %q", len(testPool.commands), len(progs), testPool.commands)
+	}
+	for _, prog := range progs {
+		want := "/vm/syz-execprog /vm/" + prog
+		found := false
+		for _, cmd := range testPool.commands {
+			found = found || cmd == want
+		}
+		if !found {
+			t.Fatalf("program %v was not run: %q", prog, testPool.commands)
+		}
+	}
+	// Both VMs are created once, plus the crashed VM is recreated if there are programs left.
+	if testPool.creates < 2 || testPool.creates > 3 {
+		t.Fatalf("created %v VMs", testPool.creates)
+	}
+}
+
+func TestReloadExecutor(t *testing.T) {
+	cfg := &mgrconfig.Config{
+		Type:           "test-replay",
+		SyzExecutorBin: "/bin/syz-executor",
+	}
+	pool, reporter := createTestPool(t, cfg)
+	defer os.RemoveAll(cfg.Workdir)
+	inst, err := pool.Create(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inst.Close()
+	testPool := pool.impl.(*testReplayPool)
+	testInst := inst.impl.(*testReplayInstance)
+	run := func(prog string) *report.Report {
+		outc, errc, err := inst.Run(time.Minute, nil, "/vm/syz-executor "+prog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return inst.MonitorExecution(outc, errc, reporter, true)
+	}
+	if err := inst.ReloadExecutor("/tmp/syz-executor.new"); err == nil {
+		t.Fatalf("reloaded executor that was not copied")
+	}
+	if _, err := inst.Copy(cfg.SyzExecutorBin); err != nil {
+		t.Fatal(err)
+	}
+	if rep := run("prog0"); rep != nil {
+		t.Fatalf("unexpected crash: %v", rep.Title)
+	}
+	if err := inst.ReloadExecutor("/tmp/syz-executor.new"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/bin/syz-executor", "/tmp/syz-executor.new"}; !reflect.DeepEqual(testInst.copied, want) {
+		t.Fatalf("copied %q, want %q", testInst.copied, want)
+	}
+	if cmd := testPool.commands[len(testPool.commands)-1]; cmd != "cp -f /vm/syz-executor.new /vm/syz-executor" {
+		t.Fatalf("executor is replaced with %q", cmd)
+	}
+	if rep := run("prog1"); rep != nil {
+		t.Fatalf("unexpected crash: %v", rep.Title)
+	}
+	if testPool.creates != 1 {
+		t.Fatalf("created %v instances, want 1", testPool.creates)
+	}
+	testPool.taint = 1 << 9
+	if err := inst.ReloadExecutor("/tmp/syz-executor.new"); err == nil {
+		t.Fatalf("reloaded executor in tainted VM")
+	}
+	testPool.taint = 1 << 12 // out-of-tree module, unrelated to crashes
+	if err := inst.ReloadExecutor("/tmp/syz-executor.new"); err != nil {
+		t.Fatal(err)
+	}
+	if rep := run("prog2-crasher"); rep == nil {
+		t.Fatalf("no crash")
+	}
+	testPool.taint = 0
+	if err := inst.ReloadExecutor("/tmp/syz-executor.new"); err == nil {
+		t.Fatalf("reloaded executor after crash")
+	}
+}
+
+func TestCrashBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-vm-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bundles := filepath.Join(dir, "bundles")
+	// Bundles left by a previous run must not be overwritten.
+	if err := os.MkdirAll(filepath.Join(bundles, "crash-0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	artifact := filepath.Join(dir, "plugins.log")
+	if err := ioutil.WriteFile(artifact, []byte("plugin output"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &mgrconfig.Config{
+		Name:    "test-manager",
+		Workdir: dir,
+		BundleCrashes: mgrconfig.BundleCrashes{
+			Dir:        bundles,
+			GuestFiles: []string{"/var/log/syslog", "/nonexistent"},
+		},
+	}
+	pool, reporter := createTestPool(t, cfg)
+	rep := runTestInstance(t, pool, reporter, false, func(inst *testInstance) {
+		inst.files = map[string][]byte{"/var/log/syslog": []byte("syslog")}
+		inst.artifacts = []string{artifact}
+		inst.outc <- []byte("BUG: bad\n")
+	})
+	if rep == nil || rep.Title != "BUG: bad" {
+		t.Fatalf("got bad report: %+v", rep)
+	}
+	bundle := filepath.Join(bundles, "crash-1")
+	var brep bundleReport
+	readJSON(t, filepath.Join(bundle, "report.json"), &brep)
+	if brep.Title != rep.Title || brep.Report != string(rep.Report) || brep.Time.IsZero() {
+		t.Fatalf("bad report.json: %+v", brep)
+	}
+	var machine bundleMachine
+	readJSON(t, filepath.Join(bundle, "machine-info.json"), &machine)
+	if machine.Type != "test" || machine.Name != "test-manager" || machine.Index != 0 {
+		t.Fatalf("bad machine-info.json: %+v", machine)
+	}
+	for file, want := range map[string]string{
+		"console.log":                    string(rep.Output),
+		"artifacts/plugins.log":          "plugin output",
+		"artifacts/guest_var_log_syslog": "syslog",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(bundle, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Fatalf("%v: got %q, want %q", file, data, want)
+		}
+	}
+	files, err := ioutil.ReadDir(filepath.Join(bundle, "artifacts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("want 2 artifacts, got %v", len(files))
+	}
 }
+
+func TestLimitedVM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-vm-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bundles := filepath.Join(dir, "bundles")
+	cfg := &mgrconfig.Config{
+		Workdir: dir,
+		Type:    "test-limited",
+		BundleCrashes: mgrconfig.BundleCrashes{
+			Dir:        bundles,
+			GuestFiles: []string{"/var/log/syslog", "/var/log/messages"},
+		},
+	}
+	pool, reporter := createTestPool(t, cfg)
+	inst, err := pool.Create(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inst.Close()
+	// Forwarding is not supported, so the reverse tunnel is used.
+	if addr, err := inst.Forward(1000); err != nil || addr != "127.0.0.1:1001" {
+		t.Fatalf("Forward: got %q, %v, want the reverse tunnel address", addr, err)
+	}
+	// Copying files out is not supported, so the bundle is saved without guest files.
+	if err := inst.CopyOut("/var/log/syslog", filepath.Join(dir, "syslog")); err != ErrUnsupported {
+		t.Fatalf("CopyOut: got %v, want ErrUnsupported", err)
+	}
+	outc, errc, err := inst.Run(time.Second, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst.impl.(*testTunnelInstance).outc <- []byte("executing program\nBUG: bad\n")
+	rep := inst.MonitorExecution(outc, errc, reporter, false)
+	if rep == nil || rep.Title != "BUG: bad" {
+		t.Fatalf("got bad report: %+v", rep)
+	}
+	files, err := ioutil.ReadDir(filepath.Join(bundles, "crash-0", "artifacts"))
+	if err != nil {
+		t.Fatalf("crash bundle is not saved: %v", err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("want no artifacts, got %v", len(files))
+	}
+	// Without reverse tunnels there is no way to connect to the host.
+	inst1, err := pool.Create(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inst1.Close()
+	if _, err := inst1.Forward(1000); err == nil || !strings.Contains(err.Error(), "reverse tunnels") {
+		t.Fatalf("Forward without reverse tunnels: got %v", err)
+	}
+}
+
+func readJSON(t *testing.T, file string, v interface{}) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("failed to parse %v: %v", file, err)
+	}
+}
+
+func TestCopyRetries(t *testing.T) {
+	defer func(backoff time.Duration) { copyBackoff = backoff }(copyBackoff)
+	copyBackoff = time.Millisecond
+	cfg := &mgrconfig.Config{}
+	pool, _ := createTestPool(t, cfg)
+	defer os.RemoveAll(cfg.Workdir)
+	transient := &vmimpl.TransientError{Err: errors.New("lost connection")}
+	permanent := errors.New("No space left on device")
+	for _, test := range []struct {
+		errs    []error
+		ok      bool
+		retries uint64
+	}{
+		{nil, true, 0},
+		{[]error{transient, transient}, true, 2},
+		{[]error{transient, transient, transient, transient}, false, 3},
+		{[]error{transient, permanent}, false, 1},
+		{[]error{permanent}, false, 0},
+	} {
+		inst, err := pool.Create(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inst.impl.(*testInstance).copyErrs = test.errs
+		before := pool.CopyRetries()
+		_, err = inst.Copy("/bin/syz-fuzzer")
+		if (err == nil) != test.ok {
+			t.Errorf("%v: copy failed: %v", test.errs, err)
+		}
+		if retries := pool.CopyRetries() - before; retries != test.retries {
+			t.Errorf("%v: retried %v times, want %v", test.errs, retries, test.retries)
+		}
+		inst.Close()
+	}
+}
+
+func TestExec(t *testing.T) {
+	cfg := &mgrconfig.Config{}
+	pool, _ := createTestPool(t, cfg)
+	defer os.RemoveAll(cfg.Workdir)
+	inst, err := pool.Create(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inst.Close()
+	inst.impl.(*testInstance).commands = map[string]testCommand{
+		"probe": {stdout: "some output\n", stderr: "some error\n", exitCode: 3},
+	}
+	stdout, stderr, exitCode, err := inst.Exec("probe", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stdout) != "some output\n" || string(stderr) != "some error\n" || exitCode != 3 {
+		t.Fatalf("got stdout %q, stderr %q, exit code %v", stdout, stderr, exitCode)
+	}
+	if _, _, _, err := inst.Exec("missing", time.Minute); err == nil {
+		t.Fatalf("no error for a command that can't be run")
+	}
+}
+
+func TestKasanShadow(t *testing.T) {
+	cfg := &mgrconfig.Config{}
+	pool, reporter := createTestPool(t, cfg)
+	defer os.RemoveAll(cfg.Workdir)
+	inst, err := pool.Create(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inst.Close()
+	outc, errc, err := inst.Run(time.Second, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testInst := inst.impl.(*testInstance)
+	// The shadow dump starts right before the end of afterContext and is printed slowly,
+	// but it must be captured in full.
+	filler := new(bytes.Buffer)
+	for filler.Len() < afterContext-400 {
+		fmt.Fprintf(filler, "[   21.000000]  ? some_function+0x%x/0x200\n", filler.Len())
+	}
+	go func() {
+		testInst.outc <- []byte(gpfReport)
+		testInst.outc <- filler.Bytes()
+		testInst.outc <- []byte(kasanShadow1)
+		// Longer than the pool waits for output, the monitor must wait for the rest of the dump.
+		time.Sleep(time.Second)
+		testInst.outc <- []byte(kasanShadow2)
+	}()
+	rep := inst.MonitorExecution(outc, errc, reporter, false)
+	if rep == nil {
+		t.Fatalf("got no report")
+	}
+	if want := "general protection fault in drm_legacy_newctx"; rep.Title != want {
+		t.Fatalf("want title %q, got %q", want, rep.Title)
+	}
+	if !bytes.HasSuffix(rep.Output, []byte(kasanShadow1+kasanShadow2)) {
+		t.Fatalf("shadow dump is not captured in full, output ends with:\n%s",
+			rep.Output[len(rep.Output)-1000:])
+	}
+	if !bytes.Contains(rep.Report, []byte("==================================================================")) {
+		t.Fatalf("shadow dump is not captured in the report")
+	}
+}
+
+const gpfReport = `[   20.362826] kasan: CONFIG_KASAN_INLINE enabled
+[   20.363613] kasan: GPF could be caused by NULL-ptr deref or user memory access
+[   20.364461] general protection fault: 0000 [#1] SMP KASAN
+[   20.366951] Modules linked in:
+[   20.366951] RIP: 0010:[<ffffffff83408ca0>]  [<ffffffff83408ca0>] drm_legacy_newctx+0x190/0x290
+[   20.366951] RSP: 0018:ffff8800634c7c50  EFLAGS: 00010246
+[   20.366951] Call Trace:
+[   20.366951]  [<ffffffff8340a9f2>] drm_ioctl+0x632/0xe10
+[   20.366951]  [<ffffffff81a4e8a4>] do_vfs_ioctl+0x1d4/0x1130
+`
+
+const panicTrace1 = `Kernel panic - not syncing: Fatal exception
+CPU: 1 PID: 4235 Comm: syz-executor0 Not tainted 4.19.0+ #1
+Call Trace:
+ dump_stack+0x244/0x39d
+ panic+0x2ad/0x55c
+ oops_end+0x1a1/0x1c0
+`
+
+const panicTrace2 = ` do_general_protection+0x2d1/0x4a0
+ general_protection+0x1e/0x30
+ sock_ioctl+0x32/0x640
+ do_vfs_ioctl+0x1de/0x1790
+ ksys_ioctl+0xa9/0xd0
+`
+
+const kasanShadow1 = `[   22.856045] Memory state around the buggy address:
+[   22.860948]  ffff8800b5a9f780: fb fb fb fb fb fb fb fb fb fb fb fb fb fb fb fb
+[   22.868279]  ffff8800b5a9f800: fb fb fb fb fb fb fb fb fb fb fb fb fb fb fb fb
+`
+
+const kasanShadow2 = `[   22.875621] >ffff8800b5a9f880: fb fb fb fb fb fb fb fb fb fb fb fb fb fb fb fb
+[   22.882964]                                            ^
+[   22.888387]  ffff8800b5a9f900: fb fb fb fb fb fb fb fb fb fb fb fb fb fb fb fb
+[   22.895717]  ffff8800b5a9f980: fb fb fb fb fb fb fb fb fb fb fb fb fb fb fb fb
+[   22.903055] ==================================================================
+`
@@ -0,0 +1,36 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vmimpl
+
+import (
+	"regexp"
+)
+
+// bootloaderRe matches lines printed by U-Boot on serial consoles of boards.
+// Some of them look like kernel oopses to the reporter (e.g. "data abort" with a register dump
+// when U-Boot faults, or "resetting ..." on watchdog resets), and all of them are useless in reports.
+var bootloaderRe = regexp.MustCompile(`^(?:` +
+	`U-Boot(?: SPL)? [0-9]{4}\.[0-9]{2}.*|` +
+	`(?:DRAM|MMC|Net|In|Out|Err|Model|Board|Loading Environment from [A-Za-z]+\.*):.*|` +
+	`Hit any key to stop autoboot:.*|` +
+	`## .*|` +
+	`data abort|` +
+	`pc : \[<[0-9a-f]+>\]\s+lr : \[<[0-9a-f]+>\].*|` +
+	`reloc pc : \[<[0-9a-f]+>\]\s+lr : \[<[0-9a-f]+>\].*|` +
+	`(?:sp|r[0-9]+|ip|fp) ?: [0-9a-f]{8}.*|` +
+	`Flags: [nN][zZ][cC][vV].*|` +
+	`Code: [0-9a-f]{8} [0-9a-f]{8}.*\([0-9a-f]{8}\).*|` +
+	`Resetting CPU \.\.\.|` +
+	`resetting \.\.\.|` +
+	`Starting kernel \.\.\.` +
+	`)\r?\n?$`)
+
+// StripBootloader is an output filter (see OutputFilterer) that drops lines printed by the bootloader
+// (U-Boot) on serial consoles of boards that are rebooted between runs.
+func StripBootloader(line []byte) []byte {
+	if bootloaderRe.Match(line) {
+		return nil
+	}
+	return line
+}
@@ -0,0 +1,44 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vmimpl
+
+import (
+	"testing"
+)
+
+func TestStripBootloader(t *testing.T) {
+	dropped := []string{
+		"U-Boot 2017.05-00001-g6c4c3b2 (Jun 02 2017 - 10:00:00 +0000)\n",
+		"U-Boot SPL 2018.01 (Jan 10 2018 - 11:22:33)\r\n",
+		"DRAM:  2 GiB\n",
+		"Hit any key to stop autoboot:  0 \n",
+		"## Booting kernel from Legacy Image at 40008000 ...\n",
+		"data abort\n",
+		"pc : [<7ff5a1c8>]          lr : [<7ff5a1b4>]\n",
+		"reloc pc : [<4300b1c8>]    lr : [<4300b1b4>]\n",
+		"sp : 7af3ec48  ip : 0000000c     fp : 00000000\n",
+		"r10: 7ffc2f3c  r9 : 7af40ee8     r8 : 7ffc2000\n",
+		"Flags: nZCv  IRQs off  FIQs off  Mode SVC_32\n",
+		"Resetting CPU ...\n",
+		"resetting ...\n",
+		"Starting kernel ...\n",
+	}
+	for _, line := range dropped {
+		if res := StripBootloader([]byte(line)); res != nil {
+			t.Errorf("line is not dropped: %q", line)
+		}
+	}
+	kept := []string{
+		"[   12.345678] Unable to handle kernel NULL pointer dereference at virtual address 00000000\n",
+		"[   12.345678] pc : [<c0123456>]    lr : [<c0123400>]    psr: 60000013\n",
+		"[   12.345678] Internal error: Oops: 17 [#1] PREEMPT SMP ARM\n",
+		"executing program 0:\n",
+		"data abort in the middle\n",
+	}
+	for _, line := range kept {
+		if res := StripBootloader([]byte(line)); string(res) != line {
+			t.Errorf("line is changed: %q -> %q", line, res)
+		}
+	}
+}
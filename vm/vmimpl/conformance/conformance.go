@@ -0,0 +1,366 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// Package conformance contains a test suite that checks that a VM implementation
+// satisfies expectations of the rest of syzkaller about vmimpl.Pool/Instance behavior:
+// console output is delivered over outc, command exit status and timeouts are reported over errc
+// independently of whether outc is consumed, Diagnose does not disturb running commands,
+// flooding output does not deadlock, and outc is closed when the instance is closed.
+// VM implementations (including out-of-tree ones) use it from their tests as:
+//
+//	func TestConformance(t *testing.T) {
+//		conformance.Run(t, &conformance.Config{Ctor: ctor, Env: env})
+//	}
+package conformance
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/vm/vmimpl"
+)
+
+type Config struct {
+	// Ctor creates the pool under test.
+	Ctor func(env *vmimpl.Env) (vmimpl.Pool, error)
+	// Env is passed to Ctor. Workdir is created by the suite if empty,
+	// Image can refer to a test image provided by the caller.
+	Env *vmimpl.Env
+	// CrashCommand makes the kernel print a crash message that contains CrashMessage
+	// on the console (by default a BUG line is written to /dev/kmsg, this requires root in VM).
+	CrashCommand string
+	CrashMessage string
+	// Pool boots real machines, the suite is skipped in -short mode.
+	RealBoot bool
+	// Run all scenarios on a single instance to avoid repeated boots of slow machines.
+	ReuseInstance bool
+	// Skip scenarios with these names (e.g. if the machine has no console).
+	Skip []string
+	// Timeout for any single expected event (1 minute by default).
+	Timeout time.Duration
+}
+
+type scenario struct {
+	name string
+	fn   func(t *testing.T, ctx *context)
+}
+
+var scenarios = []scenario{
+	{"output", testOutput},
+	{"crash", testCrash},
+	{"exit-error", testExitError},
+	{"early-errc", testEarlyErrc},
+	{"timeout", testTimeout},
+	{"stop", testStop},
+	{"diagnose", testDiagnose},
+	{"flood", testFlood},
+	{"copy", testCopy},
+	{"multiple-runs", testMultipleRuns},
+	{"closed-outc", testClosedOutc},
+}
+
+type context struct {
+	cfg  *Config
+	pool vmimpl.Pool
+	dir  string
+	inst vmimpl.Instance
+}
+
+// Run runs all scenarios against the pool created with cfg.Ctor.
+func Run(t *testing.T, cfg *Config) {
+	if cfg.RealBoot && testing.Short() {
+		t.Skip("skipping real boots in short mode")
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = time.Minute
+	}
+	if cfg.CrashMessage == "" {
+		cfg.CrashMessage = "BUG: syzkaller conformance crash"
+		cfg.CrashCommand = fmt.Sprintf("echo '%v' > /dev/kmsg", cfg.CrashMessage)
+	}
+	env := *cfg.Env
+	if env.Workdir == "" {
+		dir, err := ioutil.TempDir("", "syz-conformance")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		env.Workdir = dir
+	}
+	pool, err := cfg.Ctor(&env)
+	if err != nil {
+		t.Fatalf("failed to create pool: %v", err)
+	}
+	if pool.Count() < 1 {
+		t.Fatalf("pool has %v VMs", pool.Count())
+	}
+	ctx := &context{
+		cfg:  cfg,
+		pool: pool,
+		dir:  env.Workdir,
+	}
+	defer func() {
+		if ctx.inst != nil {
+			ctx.inst.Close()
+		}
+	}()
+	for _, sc := range scenarios {
+		sc := sc
+		t.Run(sc.name, func(t *testing.T) {
+			for _, skip := range cfg.Skip {
+				if skip == sc.name {
+					t.Skip("skipped by config")
+				}
+			}
+			sc.fn(t, ctx)
+		})
+	}
+}
+
+// instance returns an instance to run a scenario on.
+// Unless ReuseInstance is set, every scenario gets a freshly created instance.
+func (ctx *context) instance(t *testing.T) vmimpl.Instance {
+	if ctx.inst != nil {
+		if ctx.cfg.ReuseInstance {
+			return ctx.inst
+		}
+		ctx.inst.Close()
+		ctx.inst = nil
+	}
+	workdir, err := ioutil.TempDir(ctx.dir, "instance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst, err := ctx.pool.Create(workdir, 0)
+	if err != nil {
+		t.Fatalf("failed to create instance: %v", err)
+	}
+	ctx.inst = inst
+	return inst
+}
+
+// release is called by scenarios that leave the instance with unread output or a killed command.
+// The instance is closed unless ReuseInstance is set.
+func (ctx *context) release() {
+	if !ctx.cfg.ReuseInstance {
+		ctx.closeInstance()
+	}
+}
+
+// closeInstance closes the current instance, the next scenario gets a new one.
+func (ctx *context) closeInstance() {
+	if ctx.inst != nil {
+		ctx.inst.Close()
+		ctx.inst = nil
+	}
+}
+
+func (ctx *context) run(t *testing.T, timeout time.Duration, stop <-chan bool, command string) (
+	<-chan []byte, <-chan error) {
+	outc, errc, err := ctx.instance(t).Run(timeout, stop, command)
+	if err != nil {
+		t.Fatalf("failed to run %q: %v", command, err)
+	}
+	return outc, errc
+}
+
+// waitOutput reads outc until it contains all of want or errc is signaled.
+func (ctx *context) waitOutput(t *testing.T, outc <-chan []byte, errc <-chan error, want ...string) (
+	[]byte, error, bool) {
+	var output []byte
+	timeout := time.NewTimer(ctx.cfg.Timeout)
+	defer timeout.Stop()
+	var cmdErr error
+	exited := false
+	for {
+		if containsAll(output, want) {
+			return output, cmdErr, exited
+		}
+		select {
+		case out, ok := <-outc:
+			if !ok {
+				t.Fatalf("outc is closed before output %q is received, got:\n%s", want, output)
+			}
+			output = append(output, out...)
+		case err := <-errc:
+			if exited {
+				t.Fatalf("errc is signaled twice")
+			}
+			cmdErr, exited = err, true
+			errc = nil
+			// Output can still be in flight after the command exits.
+			timeout.Reset(ctx.cfg.Timeout)
+		case <-timeout.C:
+			t.Fatalf("did not receive output %q in %v, got:\n%s", want, ctx.cfg.Timeout, output)
+		}
+	}
+}
+
+func (ctx *context) waitErr(t *testing.T, errc <-chan error, timeout time.Duration) error {
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(timeout):
+		t.Fatalf("errc is not signaled in %v", timeout)
+	}
+	return nil
+}
+
+func containsAll(output []byte, want []string) bool {
+	for _, w := range want {
+		if !bytes.Contains(output, []byte(w)) {
+			return false
+		}
+	}
+	return true
+}
+
+func testOutput(t *testing.T, ctx *context) {
+	outc, errc := ctx.run(t, ctx.cfg.Timeout, nil, "echo syz-conformance-output")
+	_, err, exited := ctx.waitOutput(t, outc, errc, "syz-conformance-output")
+	if !exited {
+		err = ctx.waitErr(t, errc, ctx.cfg.Timeout)
+	}
+	if err != nil {
+		t.Fatalf("successful command returned error: %v", err)
+	}
+}
+
+func testCrash(t *testing.T, ctx *context) {
+	outc, errc := ctx.run(t, ctx.cfg.Timeout, nil, ctx.cfg.CrashCommand)
+	ctx.waitOutput(t, outc, errc, ctx.cfg.CrashMessage)
+}
+
+// testExitError checks that a failing command is reported with an error that is not ErrTimeout
+// (this is how lost connections to the machine are detected).
+func testExitError(t *testing.T, ctx *context) {
+	_, errc := ctx.run(t, ctx.cfg.Timeout, nil, "exit 1")
+	err := ctx.waitErr(t, errc, ctx.cfg.Timeout)
+	if err == nil || err == vmimpl.ErrTimeout {
+		t.Fatalf("failed command returned %v", err)
+	}
+	ctx.release()
+}
+
+// testEarlyErrc checks that errc is signaled even if nobody reads outc.
+func testEarlyErrc(t *testing.T, ctx *context) {
+	_, errc := ctx.run(t, ctx.cfg.Timeout, nil, "true")
+	if err := ctx.waitErr(t, errc, ctx.cfg.Timeout); err != nil {
+		t.Fatalf("successful command returned error: %v", err)
+	}
+	ctx.release()
+}
+
+func testTimeout(t *testing.T, ctx *context) {
+	const timeout = 10 * time.Second
+	start := time.Now()
+	_, errc := ctx.run(t, timeout, nil, "sleep 1000")
+	if err := ctx.waitErr(t, errc, timeout+ctx.cfg.Timeout); err != vmimpl.ErrTimeout {
+		t.Fatalf("hanged command returned %v, want ErrTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed < timeout {
+		t.Fatalf("command timed out after %v, want %v", elapsed, timeout)
+	}
+	ctx.release()
+}
+
+func testStop(t *testing.T, ctx *context) {
+	stop := make(chan bool, 1)
+	_, errc := ctx.run(t, time.Hour, stop, "sleep 1000")
+	stop <- true
+	if err := ctx.waitErr(t, errc, ctx.cfg.Timeout); err != vmimpl.ErrTimeout {
+		t.Fatalf("stopped command returned %v, want ErrTimeout", err)
+	}
+	ctx.release()
+}
+
+// testDiagnose checks that Diagnose does not disturb the running command and its output.
+func testDiagnose(t *testing.T, ctx *context) {
+	outc, errc := ctx.run(t, ctx.cfg.Timeout,
+		nil, "echo syz-before-diagnose; sleep 5; echo syz-after-diagnose")
+	ctx.waitOutput(t, outc, errc, "syz-before-diagnose")
+	ctx.inst.Diagnose()
+	_, err, exited := ctx.waitOutput(t, outc, errc, "syz-after-diagnose")
+	if exited && err != nil {
+		t.Fatalf("command failed after Diagnose: %v", err)
+	}
+	ctx.release()
+}
+
+// testFlood checks that lots of output is delivered without deadlocks.
+func testFlood(t *testing.T, ctx *context) {
+	outc, errc := ctx.run(t, ctx.cfg.Timeout,
+		nil, "i=0; while [ $i -lt 20000 ]; do echo syz-flood-$i-xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx; "+
+			"i=$((i+1)); done; echo syz-flood-done")
+	ctx.waitOutput(t, outc, errc, "syz-flood-done")
+	ctx.release()
+}
+
+func testCopy(t *testing.T, ctx *context) {
+	file := filepath.Join(ctx.dir, "syz-conformance-file")
+	if err := ioutil.WriteFile(file, []byte("#!/bin/sh\necho syz-copied-file\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	vmFile, err := ctx.instance(t).Copy(file)
+	if err != nil {
+		t.Fatalf("failed to copy file: %v", err)
+	}
+	outc, errc := ctx.run(t, ctx.cfg.Timeout, nil, "sh "+vmFile)
+	ctx.waitOutput(t, outc, errc, "syz-copied-file")
+}
+
+// testMultipleRuns checks that several commands can be run on the same instance one after another.
+func testMultipleRuns(t *testing.T, ctx *context) {
+	for i := 0; i < 3; i++ {
+		msg := fmt.Sprintf("syz-run-%v", i)
+		outc, errc := ctx.run(t, ctx.cfg.Timeout, nil, "echo "+msg)
+		_, err, exited := ctx.waitOutput(t, outc, errc, msg)
+		if !exited {
+			err = ctx.waitErr(t, errc, ctx.cfg.Timeout)
+		}
+		if err != nil {
+			t.Fatalf("run #%v failed: %v", i, err)
+		}
+	}
+}
+
+// testClosedOutc checks that outc is closed after the instance is closed,
+// so that readers don't block forever.
+func testClosedOutc(t *testing.T, ctx *context) {
+	outc, errc := ctx.run(t, ctx.cfg.Timeout, nil, "echo syz-closed-outc; sleep 1000")
+	ctx.waitOutput(t, outc, errc, "syz-closed-outc")
+	done := make(chan bool)
+	go func() {
+		ctx.closeInstance()
+		close(done)
+	}()
+	timeout := time.After(ctx.cfg.Timeout)
+	for {
+		select {
+		case _, ok := <-outc:
+			if !ok {
+				<-done
+				return
+			}
+		case <-timeout:
+			t.Fatalf("outc is not closed in %v after Close", ctx.cfg.Timeout)
+		}
+	}
+}
+
+// Make sure that scenario names are unique, they are used in Config.Skip.
+func init() {
+	names := make(map[string]bool)
+	for _, sc := range scenarios {
+		if names[sc.name] || strings.TrimSpace(sc.name) == "" {
+			panic(fmt.Sprintf("bad scenario name %q", sc.name))
+		}
+		names[sc.name] = true
+	}
+}
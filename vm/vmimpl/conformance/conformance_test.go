@@ -0,0 +1,107 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package conformance_test
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/osutil"
+	"github.com/google/syzkaller/vm/vmimpl"
+	"github.com/google/syzkaller/vm/vmimpl/conformance"
+)
+
+// localPool runs commands as host processes, this checks the suite itself.
+type localPool struct{}
+
+type localInstance struct {
+	workdir string
+	closed  chan bool
+}
+
+func (pool *localPool) Count() int {
+	return 1
+}
+
+func (pool *localPool) Create(workdir string, index int) (vmimpl.Instance, error) {
+	inst := &localInstance{
+		workdir: workdir,
+		closed:  make(chan bool),
+	}
+	return inst, nil
+}
+
+func (inst *localInstance) Copy(hostSrc string) (string, error) {
+	dst := filepath.Join(inst.workdir, filepath.Base(hostSrc))
+	if err := osutil.CopyFile(hostSrc, dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+func (inst *localInstance) Forward(port int) (string, error) {
+	return fmt.Sprintf("127.0.0.1:%v", port), nil
+}
+
+func (inst *localInstance) Run(timeout time.Duration, stop <-chan bool, command string) (
+	<-chan []byte, <-chan error, error) {
+	rpipe, wpipe, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Dir = inst.workdir
+	cmd.Stdout = wpipe
+	cmd.Stderr = wpipe
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		rpipe.Close()
+		wpipe.Close()
+		return nil, nil, err
+	}
+	wpipe.Close()
+	merger := vmimpl.NewOutputMerger(nil)
+	merger.Add("cmd", rpipe)
+	return vmimpl.Multiplex(cmd, merger, &groupCloser{cmd, rpipe}, timeout, stop, inst.closed, false)
+}
+
+// groupCloser kills children of the shell too, otherwise they hold the output pipe open.
+type groupCloser struct {
+	cmd   *exec.Cmd
+	rpipe *os.File
+}
+
+func (gc *groupCloser) Close() error {
+	syscall.Kill(-gc.cmd.Process.Pid, syscall.SIGKILL)
+	return gc.rpipe.Close()
+}
+
+func (inst *localInstance) Diagnose() bool {
+	return false
+}
+
+func (inst *localInstance) Close() {
+	close(inst.closed)
+}
+
+func TestLocal(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("local instances require /bin/sh")
+	}
+	conformance.Run(t, &conformance.Config{
+		Ctor: func(env *vmimpl.Env) (vmimpl.Pool, error) {
+			return new(localPool), nil
+		},
+		Env:          new(vmimpl.Env),
+		CrashCommand: "echo 'BUG: local crash'",
+		CrashMessage: "BUG: local crash",
+		Timeout:      20 * time.Second,
+	})
+}
@@ -81,18 +81,23 @@ func (t *tty) Close() error {
 
 // Open dmesg remotely
 func OpenRemoteConsole(bin string, args ...string) (rc io.ReadCloser, err error) {
+	return OpenRemoteCommand(bin, append(args, "dmesg -w")...)
+}
+
+// OpenRemoteCommand starts bin with args (e.g. ssh or adb shell with a remote command)
+// and returns its combined output; Close kills the command.
+func OpenRemoteCommand(bin string, args ...string) (rc io.ReadCloser, err error) {
 	rpipe, wpipe, err := osutil.LongPipe()
 	if err != nil {
 		return nil, err
 	}
-	args = append(args, "dmesg -w")
 	cmd := osutil.Command(bin, args...)
 	cmd.Stdout = wpipe
 	cmd.Stderr = wpipe
 	if err := cmd.Start(); err != nil {
 		rpipe.Close()
 		wpipe.Close()
-		return nil, fmt.Errorf("failed to start adb: %v", err)
+		return nil, fmt.Errorf("failed to start %v: %v", bin, err)
 	}
 	wpipe.Close()
 	con := &remoteCon{
@@ -0,0 +1,106 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vmimpl
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/osutil"
+)
+
+// Console reading modes of VM types that can read console output both ways (console_mode config).
+const (
+	// Console output is read as it arrives (e.g. from a pipe or a socket), it has the lowest latency.
+	ConsoleModeEvent = "event"
+	// Console output is buffered by the host (e.g. in a file or by a cloud API) and polled periodically.
+	// It has higher latency, but a slow or stuck reader does not block the VM, and a broken connection
+	// does not lose the buffered output.
+	ConsoleModePoll = "poll"
+)
+
+// CheckConsoleMode checks console_mode config, empty mode means the default mode of the VM type.
+func CheckConsoleMode(mode string) error {
+	switch mode {
+	case "", ConsoleModeEvent, ConsoleModePoll:
+		return nil
+	default:
+		return fmt.Errorf("bad console_mode %q, want %q or %q", mode, ConsoleModeEvent, ConsoleModePoll)
+	}
+}
+
+// ConsoleSource is a console which output is buffered and can be polled.
+type ConsoleSource interface {
+	// Poll writes output printed since the previous call to w.
+	Poll(w io.Writer) error
+}
+
+// PollConsole polls src every interval and returns a reader of the output, so that polled consoles
+// are read the same way as event-driven ones (e.g. added to OutputMerger). Polling stops and the reader
+// returns EOF after stop is called, or after maxErrors consecutive failed polls (the console is lost).
+func PollConsole(name string, src ConsoleSource, interval time.Duration, maxErrors int) (
+	io.ReadCloser, func(), error) {
+	rpipe, wpipe, err := osutil.LongPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	stop := make(chan bool)
+	go pollConsole(name, src, interval, maxErrors, wpipe, stop)
+	var once sync.Once
+	return rpipe, func() { once.Do(func() { close(stop) }) }, nil
+}
+
+func pollConsole(name string, src ConsoleSource, interval time.Duration, maxErrors int,
+	w io.WriteCloser, stop <-chan bool) {
+	defer w.Close()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			// Output printed right before the stop (e.g. the end of a crash) is not lost.
+			src.Poll(w)
+			return
+		}
+		if err := src.Poll(w); err != nil {
+			failures++
+			log.Logf(1, "%v: console poll failed: %v", name, err)
+			if failures >= maxErrors {
+				return
+			}
+			continue
+		}
+		failures = 0
+	}
+}
+
+// FileConsole is a console that is written to a file on the host (e.g. qemu -serial file:).
+type FileConsole struct {
+	File string
+	pos  int64
+}
+
+// Poll implements ConsoleSource. The file does not need to exist before the first output.
+func (con *FileConsole) Poll(w io.Writer) error {
+	f, err := os.Open(con.File)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(con.pos, io.SeekStart); err != nil {
+		return err
+	}
+	n, err := io.Copy(w, f)
+	con.pos += n
+	return err
+}
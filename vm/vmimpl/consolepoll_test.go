@@ -0,0 +1,151 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vmimpl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/osutil"
+)
+
+// testConsole buffers printed output until it's polled, fails polls while failing is set.
+type testConsole struct {
+	mu      sync.Mutex
+	buf     []byte
+	failing bool
+}
+
+func (con *testConsole) print(data string) {
+	con.mu.Lock()
+	defer con.mu.Unlock()
+	con.buf = append(con.buf, data...)
+}
+
+func (con *testConsole) Poll(w io.Writer) error {
+	con.mu.Lock()
+	defer con.mu.Unlock()
+	if con.failing {
+		return errors.New("console is broken")
+	}
+	_, err := w.Write(con.buf)
+	con.buf = nil
+	return err
+}
+
+func TestConsoleModes(t *testing.T) {
+	var lines []string
+	for i := 0; i < 100; i++ {
+		lines = append(lines, fmt.Sprintf("console line %v\n", i))
+	}
+	for _, mode := range []string{ConsoleModeEvent, ConsoleModePoll} {
+		merger := NewOutputMerger(nil)
+		var print func(string)
+		var stop func()
+		switch mode {
+		case ConsoleModeEvent:
+			rpipe, wpipe, err := osutil.LongPipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			merger.Add("console", rpipe)
+			print = func(data string) { wpipe.Write([]byte(data)) }
+			stop = func() { wpipe.Close() }
+		case ConsoleModePoll:
+			con := new(testConsole)
+			rc, stopPoll, err := PollConsole("test", con, time.Millisecond, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			merger.Add("console", rc)
+			print = con.print
+			stop = stopPoll
+		}
+		output := new(bytes.Buffer)
+		done := make(chan bool)
+		go func() {
+			for {
+				select {
+				case out := <-merger.Output:
+					output.Write(out)
+				case <-merger.Err:
+					// Output is sent before the error, but drain what is left.
+					for {
+						select {
+						case out := <-merger.Output:
+							output.Write(out)
+						default:
+							close(done)
+							return
+						}
+					}
+				}
+			}
+		}()
+		for i, line := range lines {
+			print(line)
+			if i%10 == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		stop()
+		<-done
+		merger.Wait()
+		// Output printed right before the stop must not be lost in either mode.
+		if want := strings.Join(lines, ""); output.String() != want {
+			t.Errorf("%v: got output:\n%s\nwant:\n%s", mode, output.Bytes(), want)
+		}
+	}
+}
+
+func TestPollConsoleLost(t *testing.T) {
+	con := &testConsole{failing: true}
+	rc, stop, err := PollConsole("test", con, time.Millisecond, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	// The reader must get EOF after maxErrors failed polls without an explicit stop.
+	if _, err := ioutil.ReadAll(rc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileConsole(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-console-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	con := &FileConsole{File: filepath.Join(dir, "console.log")}
+	output := new(bytes.Buffer)
+	// The file is created by the VM on boot.
+	if err := con.Poll(output); err != nil || output.Len() != 0 {
+		t.Fatalf("got %q, %v", output.Bytes(), err)
+	}
+	f, err := os.Create(con.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for i, data := range []string{"first line\nsecond ", "", "line\n"} {
+		f.WriteString(data)
+		before := output.Len()
+		if err := con.Poll(output); err != nil {
+			t.Fatal(err)
+		}
+		if got := output.String()[before:]; got != data {
+			t.Fatalf("poll #%v: got %q, want %q", i, got, data)
+		}
+	}
+}
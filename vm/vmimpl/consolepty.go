@@ -0,0 +1,50 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vmimpl
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// ConsolePty mirrors console output of a VM to a host pty, so that external tools
+// (e.g. screen or minicom) can attach to the console while syzkaller still monitors it.
+// The pty is read-only: input of attached tools is discarded.
+type ConsolePty struct {
+	Path string // the pty external tools attach to, e.g. /dev/pts/3
+
+	mu      sync.Mutex
+	master  int // -1 after Close
+	slave   int // keeps the pty open while no tool is attached
+	dropped uint64
+}
+
+// Tee returns a reader that reads console output from r and mirrors it to the pty.
+// Mirroring never blocks the reader: if the attached tool does not keep up (or no tool is attached
+// and the pty buffer is full), output is dropped for the pty only. Closing the returned reader closes r,
+// the pty stays open until Close, so that tools stay attached across console reconnects.
+func (pty *ConsolePty) Tee(r io.ReadCloser) io.ReadCloser {
+	return &ptyTee{ReadCloser: r, pty: pty}
+}
+
+// Dropped returns the number of bytes of output that were not mirrored to the pty.
+func (pty *ConsolePty) Dropped() uint64 {
+	return atomic.LoadUint64(&pty.dropped)
+}
+
+type ptyTee struct {
+	io.ReadCloser
+	pty *ConsolePty
+}
+
+func (t *ptyTee) Read(buf []byte) (int, error) {
+	n, err := t.ReadCloser.Read(buf)
+	if n > 0 {
+		if written := t.pty.write(buf[:n]); written < n {
+			atomic.AddUint64(&t.pty.dropped, uint64(n-written))
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vmimpl
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// OpenConsolePty creates a host pty for mirroring console output of a VM (see ConsolePty).
+func OpenConsolePty() (*ConsolePty, error) {
+	master, err := syscall.Open("/dev/ptmx",
+		syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open /dev/ptmx: %v", err)
+	}
+	pty := &ConsolePty{master: master, slave: -1}
+	if err := pty.setup(); err != nil {
+		pty.Close()
+		return nil, err
+	}
+	return pty, nil
+}
+
+func (pty *ConsolePty) setup() error {
+	var unlock int32
+	_, _, errno := syscall.Syscall(unix.SYS_IOCTL, uintptr(pty.master), unix.TIOCSPTLCK,
+		uintptr(unsafe.Pointer(&unlock)))
+	if errno != 0 {
+		return fmt.Errorf("failed to unlock pty: %v", errno)
+	}
+	n, err := unix.IoctlGetInt(pty.master, unix.TIOCGPTN)
+	if err != nil {
+		return fmt.Errorf("failed to get pty number: %v", err)
+	}
+	pty.Path = fmt.Sprintf("/dev/pts/%v", n)
+	pty.slave, err = syscall.Open(pty.Path, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %v: %v", pty.Path, err)
+	}
+	// Output is passed to tools as is: no echo, no line buffering, no newline translation.
+	term, err := unix.IoctlGetTermios(pty.slave, unix.TCGETS)
+	if err != nil {
+		return fmt.Errorf("failed to get pty termios: %v", err)
+	}
+	term.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR |
+		unix.IGNCR | unix.ICRNL | unix.IXON
+	term.Oflag &^= unix.OPOST
+	term.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	term.Cflag &^= unix.CSIZE | unix.PARENB
+	term.Cflag |= unix.CS8
+	if err := unix.IoctlSetTermios(pty.slave, unix.TCSETS, term); err != nil {
+		return fmt.Errorf("failed to set pty termios: %v", err)
+	}
+	return nil
+}
+
+// write writes data to the pty without blocking and returns the number of written bytes.
+func (pty *ConsolePty) write(data []byte) int {
+	pty.mu.Lock()
+	defer pty.mu.Unlock()
+	if pty.master == -1 {
+		return 0
+	}
+	// Discard input of attached tools, otherwise they block once the pty input buffer is full.
+	unix.IoctlSetInt(pty.master, unix.TCFLSH, unix.TCIFLUSH)
+	written := 0
+	for written < len(data) {
+		n, err := syscall.Write(pty.master, data[written:])
+		if n <= 0 || err != nil {
+			// EAGAIN: the pty buffer is full, nobody reads it fast enough.
+			break
+		}
+		written += n
+	}
+	return written
+}
+
+func (pty *ConsolePty) Close() error {
+	pty.mu.Lock()
+	defer pty.mu.Unlock()
+	if pty.slave != -1 {
+		syscall.Close(pty.slave)
+		pty.slave = -1
+	}
+	if pty.master != -1 {
+		syscall.Close(pty.master)
+		pty.master = -1
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vmimpl
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/osutil"
+)
+
+func TestConsolePty(t *testing.T) {
+	pty, err := OpenConsolePty()
+	if err != nil {
+		t.Skipf("can't create pty: %v", err)
+	}
+	defer pty.Close()
+	if !osutil.IsExist(pty.Path) {
+		t.Fatalf("pty %v does not exist", pty.Path)
+	}
+	rpipe, wpipe, err := osutil.LongPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wpipe.Close()
+	console := pty.Tee(rpipe)
+	defer console.Close()
+
+	// Nobody is attached, but syzkaller must still get all output.
+	line := bytes.Repeat([]byte("x"), 127)
+	line = append(line, '\n')
+	const lines = 1 << 10
+	go func() {
+		for i := 0; i < lines; i++ {
+			wpipe.Write(line)
+		}
+	}()
+	done := make(chan int)
+	go func() {
+		n, _ := io.CopyN(ioutil.Discard, console, lines*int64(len(line)))
+		done <- int(n)
+	}()
+	select {
+	case n := <-done:
+		if n != lines*len(line) {
+			t.Fatalf("read %v bytes, want %v", n, lines*len(line))
+		}
+	case <-time.After(time.Minute):
+		t.Fatalf("console reader is blocked by the pty")
+	}
+	if pty.Dropped() == 0 {
+		t.Fatalf("nothing is dropped while nobody reads the pty")
+	}
+
+	// An attached tool gets the output (after what was buffered in the pty).
+	tool, err := os.OpenFile(pty.Path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tool.Close()
+	attached := make(chan bool)
+	go func() {
+		buf := make([]byte, 1<<20)
+		for i := 0; ; i++ {
+			n, _ := tool.Read(buf)
+			if i == 0 {
+				close(attached)
+			}
+			if n == 0 || bytes.Contains(buf[:n], []byte("BUG: done")) {
+				break
+			}
+		}
+		done <- 0
+	}()
+	<-attached
+	// The tool's input does not block anything.
+	tool.Write([]byte("some input\n"))
+	wpipe.Write([]byte("BUG: done\n"))
+	buf := make([]byte, 100)
+	if n, err := console.Read(buf); err != nil || string(buf[:n]) != "BUG: done\n" {
+		t.Fatalf("console read %q, %v", buf[:n], err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Minute):
+		t.Fatalf("attached tool did not get the output")
+	}
+}
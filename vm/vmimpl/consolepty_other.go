@@ -0,0 +1,23 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// +build !linux
+
+package vmimpl
+
+import (
+	"fmt"
+)
+
+// OpenConsolePty creates a host pty for mirroring console output of a VM (see ConsolePty).
+func OpenConsolePty() (*ConsolePty, error) {
+	return nil, fmt.Errorf("console pty is supported only on linux hosts")
+}
+
+func (pty *ConsolePty) write(data []byte) int {
+	return 0
+}
+
+func (pty *ConsolePty) Close() error {
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vmimpl
+
+import (
+	"regexp"
+	"strings"
+)
+
+// TransientError wraps errors of VM operations that will likely succeed if retried in place
+// (e.g. sshd is not ready yet or a USB flake), as opposed to errors that require recreation of the VM
+// (e.g. the guest disk is full). See ClassifyCopyError.
+type TransientError struct {
+	Err error
+}
+
+func (err *TransientError) Error() string {
+	return err.Err.Error()
+}
+
+// IsTransient returns true if err is a TransientError.
+func IsTransient(err error) bool {
+	_, ok := err.(*TransientError)
+	return ok
+}
+
+var (
+	// Failures that won't go away without fixing the VM/image, checked first.
+	copyPermanentErrors = []string{
+		"No space left on device",
+		"Disk quota exceeded",
+		"Read-only file system",
+		"Permission denied",
+		"No such file or directory",
+		"Host key verification failed",
+	}
+	copyTransientRe = regexp.MustCompile(strings.Join([]string{
+		"Connection refused",
+		"Connection reset",
+		"Connection timed out",
+		"Connection closed",
+		"lost connection",
+		"No route to host",
+		"Broken pipe",
+		"kex_exchange_identification",
+		"ssh_exchange_identification",
+		"device offline",
+		"device '[^']*' not found",
+		"no devices/emulators found",
+		"protocol fault",
+		"^timedout", // see osutil.Run
+	}, "|"))
+	exitStatusRe = regexp.MustCompile(`exit status ([0-9]+)`)
+)
+
+// ClassifyCopyError classifies an error of copying a file into a VM with scp or adb push
+// (err includes the command output, see osutil.Run). Returns err wrapped in TransientError
+// if the failure is likely transient, otherwise err as is.
+func ClassifyCopyError(err error) error {
+	if err == nil || IsTransient(err) {
+		return err
+	}
+	text := err.Error()
+	for _, pattern := range copyPermanentErrors {
+		if strings.Contains(text, pattern) {
+			return err
+		}
+	}
+	if copyTransientRe.MatchString(text) {
+		return &TransientError{err}
+	}
+	// scp exits with 255 if the ssh connection fails.
+	if match := exitStatusRe.FindStringSubmatch(text); match != nil && match[1] == "255" {
+		return &TransientError{err}
+	}
+	return err
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vmimpl
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/syzkaller/pkg/osutil"
+)
+
+func TestClassifyCopyError(t *testing.T) {
+	for _, test := range []struct {
+		err       error
+		transient bool
+	}{
+		{&osutil.VerboseError{
+			Title:  `failed to run ["scp" "-P" "22" "syz-fuzzer" "root@localhost:/syz-fuzzer"]: exit status 1`,
+			Output: []byte("scp: /syz-fuzzer: No space left on device\n"),
+		}, false},
+		{&osutil.VerboseError{
+			Title:  `failed to run ["scp" "-P" "22" "syz-fuzzer" "root@localhost:/syz-fuzzer"]: exit status 1`,
+			Output: []byte("lost connection\n"),
+		}, true},
+		{&osutil.VerboseError{
+			Title:  `failed to run ["scp" "-P" "22" "syz-fuzzer" "root@localhost:/syz-fuzzer"]: exit status 255`,
+			Output: []byte("ssh: connect to host localhost port 22: Connection refused\n"),
+		}, true},
+		{&osutil.VerboseError{
+			Title: `failed to run ["scp" "-P" "22" "syz-fuzzer" "root@localhost:/syz-fuzzer"]: exit status 255`,
+		}, true},
+		{&osutil.VerboseError{
+			Title:  `failed to run ["scp" "-P" "22" "syz-fuzzer" "root@localhost:/syz-fuzzer"]: exit status 255`,
+			Output: []byte("root@localhost: Permission denied (publickey).\n"),
+		}, false},
+		{&osutil.VerboseError{
+			Title:  `failed to run ["adb" "-s" "X" "push" "syz-fuzzer" "/data/syz-fuzzer"]: exit status 1`,
+			Output: []byte("error: device 'X' not found\n"),
+		}, true},
+		{&osutil.VerboseError{
+			Title:  `failed to run ["adb" "-s" "X" "push" "syz-fuzzer" "/data/syz-fuzzer"]: exit status 1`,
+			Output: []byte("error: device offline\n"),
+		}, true},
+		{&osutil.VerboseError{
+			Title:  `failed to run ["adb" "-s" "X" "push" "syz-fuzzer" "/data/syz-fuzzer"]: exit status 1`,
+			Output: []byte("adb: error: failed to copy 'syz-fuzzer' to '/data/syz-fuzzer': remote Read-only file system\n"),
+		}, false},
+		{&osutil.VerboseError{Title: `timedout ["adb" "-s" "X" "push" "syz-fuzzer" "/data/syz-fuzzer"]`}, true},
+		{errors.New("unexpected failure"), false},
+	} {
+		err := ClassifyCopyError(test.err)
+		if IsTransient(err) != test.transient {
+			t.Errorf("%q: transient %v, want %v", test.err, IsTransient(err), test.transient)
+		}
+		if err.Error() != test.err.Error() {
+			t.Errorf("%q: classification changed the error: %q", test.err, err)
+		}
+		if IsTransient(err) && ClassifyCopyError(err) != err {
+			t.Errorf("%q: transient error is wrapped twice", test.err)
+		}
+	}
+	if ClassifyCopyError(nil) != nil {
+		t.Errorf("nil error is classified as non-nil")
+	}
+}
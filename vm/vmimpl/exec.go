@@ -0,0 +1,68 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vmimpl
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/osutil"
+)
+
+// SysrqProbeCommand makes linux kernel print backtraces of all active CPUs to the console.
+const SysrqProbeCommand = "echo l > /proc/sysrq-trigger"
+
+// ProbeSysrq runs SysrqProbeCommand with execer (see Prober),
+// returns true if the command has succeeded.
+func ProbeSysrq(execer Execer) bool {
+	_, _, exitCode, err := execer.Exec(SysrqProbeCommand, 30*time.Second)
+	if err != nil {
+		log.Logf(1, "sysrq probe failed: %v", err)
+		return false
+	}
+	return exitCode == 0
+}
+
+// RunExec runs cmd and returns its stdout, stderr and exit code separately.
+// err is non-nil only if cmd can't be started or does not finish within timeout,
+// a non-zero exit code is not an error.
+func RunExec(timeout time.Duration, cmd *exec.Cmd) (stdout, stderr []byte, exitCode int, err error) {
+	outbuf, errbuf := new(bytes.Buffer), new(bytes.Buffer)
+	cmd.Stdout = outbuf
+	cmd.Stderr = errbuf
+	if err := cmd.Start(); err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to start %v %+v: %v", cmd.Path, cmd.Args, err)
+	}
+	timer := time.AfterFunc(timeout, func() {
+		cmd.Process.Kill()
+	})
+	err = cmd.Wait()
+	if !timer.Stop() {
+		return outbuf.Bytes(), errbuf.Bytes(), 0, fmt.Errorf("timedout %q", cmd.Args)
+	}
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return outbuf.Bytes(), errbuf.Bytes(), 0, fmt.Errorf("failed to run %q: %v", cmd.Args, err)
+		}
+	}
+	return outbuf.Bytes(), errbuf.Bytes(), osutil.ProcessExitStatus(cmd.ProcessState), nil
+}
+
+// SSHExec runs command in the VM over ssh, see RunExec.
+// ssh exits with 255 if it fails to connect, this is returned as an error.
+func SSHExec(debug bool, timeout time.Duration, addr, sshKey, sshUser string, port int, command string) (
+	stdout, stderr []byte, exitCode int, err error) {
+	args := append(SSHArgs(debug, sshKey, port), sshUser+"@"+addr, command)
+	if debug {
+		log.Logf(0, "running ssh: %#v", args)
+	}
+	stdout, stderr, exitCode, err = RunExec(timeout, osutil.Command("ssh", args...))
+	if err == nil && exitCode == 255 {
+		err = fmt.Errorf("failed to ssh into the instance:\n%s", stderr)
+	}
+	return
+}
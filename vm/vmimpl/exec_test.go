@@ -0,0 +1,28 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vmimpl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/osutil"
+)
+
+func TestRunExec(t *testing.T) {
+	cmd := osutil.Command("sh", "-c", "echo out; echo err >&2; exit 7")
+	stdout, stderr, exitCode, err := RunExec(time.Minute, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stdout) != "out\n" || string(stderr) != "err\n" || exitCode != 7 {
+		t.Fatalf("got stdout %q, stderr %q, exit code %v", stdout, stderr, exitCode)
+	}
+	if _, _, _, err := RunExec(100*time.Millisecond, osutil.Command("sleep", "10")); err == nil {
+		t.Fatalf("no error for a command that timed out")
+	}
+	if _, _, _, err := RunExec(time.Minute, osutil.Command("non-existent-binary")); err == nil {
+		t.Fatalf("no error for a command that can't be started")
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vmimpl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+)
+
+// KmsgCommand prints the current boot id (used to detect reboots) and then follows /dev/kmsg.
+const KmsgCommand = "cat /proc/sys/kernel/random/boot_id && exec cat /dev/kmsg"
+
+// PstoreCommand mounts pstore (if it's not mounted yet) and dumps all records.
+const PstoreCommand = `mkdir -p /sys/fs/pstore; ` +
+	`mount -t pstore pstore /sys/fs/pstore 2>/dev/null; ` +
+	`for f in /sys/fs/pstore/*; do [ -f "$f" ] && echo "$f:" && cat "$f"; done; true`
+
+// PstoreClearCommand removes pstore records, so that stale records are not attached to later crashes
+// on machines that persist across VM instances.
+const PstoreClearCommand = `mkdir -p /sys/fs/pstore; ` +
+	`mount -t pstore pstore /sys/fs/pstore 2>/dev/null; ` +
+	`rm -f /sys/fs/pstore/*; true`
+
+// Delay between reconnection attempts starts at kmsgRetryPeriod and is doubled up to kmsgMaxRetryPeriod.
+var kmsgRetryPeriod = time.Second
+
+const kmsgMaxRetryPeriod = 10 * time.Second
+
+// OpenKmsgConsole provides console output for machines without a usable serial console.
+// It streams /dev/kmsg over a dedicated connection (opened by the open callback, e.g. ssh or adb shell
+// running KmsgCommand) and converts records to the usual "[  123.456789] message" form.
+// The connection is transparently re-established if it breaks (e.g. when the machine reboots),
+// records that were already read are not repeated after reconnection within the same boot.
+// Read does not fail until Close, so death of the log channel alone is never reported as
+// a lost connection to the machine; that is detected solely via the main command channel.
+func OpenKmsgConsole(open func() (io.ReadCloser, error)) (io.ReadCloser, error) {
+	con, err := open()
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	kc := &kmsgConsole{
+		open:    open,
+		pr:      pr,
+		pw:      pw,
+		con:     con,
+		retry:   kmsgRetryPeriod,
+		lastSeq: -1,
+	}
+	go kc.loop(con)
+	return kc, nil
+}
+
+type kmsgConsole struct {
+	open  func() (io.ReadCloser, error)
+	pr    *io.PipeReader
+	pw    *io.PipeWriter
+	retry time.Duration
+
+	mu     sync.Mutex
+	con    io.ReadCloser
+	closed bool
+
+	bootID  string
+	lastSeq int64
+}
+
+func (kc *kmsgConsole) Read(buf []byte) (int, error) {
+	return kc.pr.Read(buf)
+}
+
+func (kc *kmsgConsole) Close() error {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	if kc.closed {
+		return nil
+	}
+	kc.closed = true
+	kc.con.Close()
+	kc.pr.Close()
+	return nil
+}
+
+func (kc *kmsgConsole) loop(con io.ReadCloser) {
+	defer kc.pw.Close()
+	for {
+		err := kc.stream(con)
+		con.Close()
+		if err == io.ErrClosedPipe || kc.isClosed() {
+			return
+		}
+		log.Logf(1, "kmsg console: connection lost (%v), reconnecting", err)
+		if con = kc.reconnect(); con == nil {
+			return
+		}
+	}
+}
+
+// reconnect opens a new connection, returns nil if the console is closed in the meantime.
+func (kc *kmsgConsole) reconnect() io.ReadCloser {
+	delay := kc.retry
+	for {
+		time.Sleep(delay)
+		if delay *= 2; delay > kmsgMaxRetryPeriod {
+			delay = kmsgMaxRetryPeriod
+		}
+		if kc.isClosed() {
+			return nil
+		}
+		con, err := kc.open()
+		if err != nil {
+			log.Logf(1, "kmsg console: failed to reconnect: %v", err)
+			continue
+		}
+		kc.mu.Lock()
+		if kc.closed {
+			kc.mu.Unlock()
+			con.Close()
+			return nil
+		}
+		kc.con = con
+		kc.mu.Unlock()
+		return con
+	}
+}
+
+func (kc *kmsgConsole) isClosed() bool {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	return kc.closed
+}
+
+// stream reads a single connection until it fails.
+// Returns io.ErrClosedPipe if the console is closed.
+func (kc *kmsgConsole) stream(con io.Reader) error {
+	r := bufio.NewReader(con)
+	bootID, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	bootID = strings.TrimSpace(bootID)
+	if kc.bootID != "" && kc.bootID != bootID {
+		kc.lastSeq = -1
+		if _, err := io.WriteString(kc.pw, KmsgRebootMarker); err != nil {
+			return err
+		}
+	}
+	kc.bootID = bootID
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		seq, text, ok := parseKmsgRecord(line)
+		if !ok || seq <= kc.lastSeq {
+			continue
+		}
+		kc.lastSeq = seq
+		if _, err := io.WriteString(kc.pw, text); err != nil {
+			return err
+		}
+	}
+}
+
+// KmsgRebootMarker is inserted into the kmsg console output when the machine reboots.
+const KmsgRebootMarker = "syzkaller: kernel log: machine rebooted\n"
+
+// parseKmsgRecord parses a /dev/kmsg record of the form "prio,seq,usec,flags[,...];message"
+// and returns its sequence number and the message formatted as a console line.
+// Continuation lines with dictionary key/values (starting with a space) are not records.
+func parseKmsgRecord(line string) (int64, string, bool) {
+	semi := strings.IndexByte(line, ';')
+	if semi == -1 || strings.HasPrefix(line, " ") {
+		return 0, "", false
+	}
+	fields := strings.Split(line[:semi], ",")
+	if len(fields) < 3 {
+		return 0, "", false
+	}
+	seq, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	usec, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	msg := strings.TrimRight(line[semi+1:], "\r\n")
+	return seq, fmt.Sprintf("[%5d.%06d] %s\n", usec/1e6, usec%1e6, msg), true
+}
@@ -0,0 +1,113 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vmimpl
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseKmsgRecord(t *testing.T) {
+	tests := []struct {
+		line string
+		seq  int64
+		text string
+		ok   bool
+	}{
+		{
+			line: "6,1234,5678901,-;usb 1-1: new device\n",
+			seq:  1234,
+			text: "[    5.678901] usb 1-1: new device\n",
+			ok:   true,
+		},
+		{
+			line: "4,7,12345678123,c,caller=T123;WARNING: CPU: 0 PID: 1 at foo.c:1\n",
+			seq:  7,
+			text: "[12345.678123] WARNING: CPU: 0 PID: 1 at foo.c:1\n",
+			ok:   true,
+		},
+		{
+			line: " SUBSYSTEM=usb\n",
+		},
+		{
+			line: "garbage\n",
+		},
+		{
+			line: "6,x,1,-;foo\n",
+		},
+	}
+	for i, test := range tests {
+		seq, text, ok := parseKmsgRecord(test.line)
+		if seq != test.seq || text != test.text || ok != test.ok {
+			t.Errorf("#%v: got %v/%q/%v, want %v/%q/%v",
+				i, seq, text, ok, test.seq, test.text, test.ok)
+		}
+	}
+}
+
+func TestKmsgConsole(t *testing.T) {
+	defer func(period time.Duration) {
+		kmsgRetryPeriod = period
+	}(kmsgRetryPeriod)
+	kmsgRetryPeriod = time.Millisecond
+	conns := []string{
+		// Connection breaks.
+		"boot1\n6,0,1000000,-;a\n6,1,2000000,-;b\n",
+		// Reconnection attempt fails.
+		"",
+		// Reconnection replays the buffer, only new records are expected.
+		"boot1\n6,0,1000000,-;a\n6,1,2000000,-;b\n SUBSYSTEM=x\n6,2,3000000,-;c\n",
+		// The machine has rebooted.
+		"boot2\n6,0,500000,-;d\n",
+	}
+	var mu sync.Mutex
+	open := func() (io.ReadCloser, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(conns) == 0 {
+			return nil, fmt.Errorf("no more connections")
+		}
+		conn := conns[0]
+		conns = conns[1:]
+		if conn == "" {
+			return nil, fmt.Errorf("connection refused")
+		}
+		return ioutil.NopCloser(strings.NewReader(conn)), nil
+	}
+	con, err := OpenKmsgConsole(open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[    1.000000] a\n" +
+		"[    2.000000] b\n" +
+		"[    3.000000] c\n" +
+		KmsgRebootMarker +
+		"[    0.500000] d\n"
+	got := make([]byte, len(want))
+	done := make(chan error)
+	go func() {
+		_, err := io.ReadFull(con, got)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out reading console, got:\n%s", got)
+	}
+	if string(got) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+	con.Close()
+	if n, err := con.Read(got); n != 0 || err == nil {
+		t.Fatalf("read after close returned %v, %v", n, err)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vmimpl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+)
+
+// ConsoleLogin configures scripted login on serial consoles that are gated behind a getty login
+// (kernel output appears on such consoles only after somebody logs in).
+type ConsoleLogin struct {
+	User     string `json:"user"`     // login is not done if empty
+	Password string `json:"password"` // sent on the password prompt
+	// Regexps matched against the last (incomplete) line of console output.
+	LoginPrompt    string `json:"login_prompt"`    // default: "login: *$"
+	PasswordPrompt string `json:"password_prompt"` // default: "[Pp]assword: *$"
+	ShellPrompt    string `json:"shell_prompt"`    // default: "[#$] *$"
+	// How long to wait for the prompts in seconds (30 by default).
+	// If no prompt appears (e.g. the console is already logged in), the console is used as is.
+	Timeout int `json:"timeout"`
+}
+
+// Check validates the config and fills in defaults.
+func (cfg *ConsoleLogin) Check() error {
+	if cfg.User == "" {
+		return nil
+	}
+	if cfg.LoginPrompt == "" {
+		cfg.LoginPrompt = "login: *$"
+	}
+	if cfg.PasswordPrompt == "" {
+		cfg.PasswordPrompt = "[Pp]assword: *$"
+	}
+	if cfg.ShellPrompt == "" {
+		cfg.ShellPrompt = "[#$] *$"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 30
+	}
+	if cfg.Timeout < 0 {
+		return fmt.Errorf("invalid console_login timeout: %v", cfg.Timeout)
+	}
+	for _, re := range []string{cfg.LoginPrompt, cfg.PasswordPrompt, cfg.ShellPrompt} {
+		if _, err := regexp.Compile(re); err != nil {
+			return fmt.Errorf("invalid console_login prompt %q: %v", re, err)
+		}
+	}
+	return nil
+}
+
+// LoginConsole logs in on the console con (w is the console input) according to cfg
+// and returns the console output stream, which includes everything printed during login.
+// The login starts with a newline to make getty (or an already logged in shell) print a prompt.
+// LoginConsole returns when the shell prompt appears, or when no prompts appear within the timeout;
+// it fails only if the login is rejected. If cfg.User is empty, con is returned as is.
+func LoginConsole(con io.ReadCloser, w io.Writer, cfg *ConsoleLogin) (io.ReadCloser, error) {
+	if cfg.User == "" {
+		return con, nil
+	}
+	loginRe, err := regexp.Compile(cfg.LoginPrompt)
+	if err != nil {
+		return nil, err
+	}
+	passwordRe, err := regexp.Compile(cfg.PasswordPrompt)
+	if err != nil {
+		return nil, err
+	}
+	shellRe, err := regexp.Compile(cfg.ShellPrompt)
+	if err != nil {
+		return nil, err
+	}
+	lc := &loginCon{
+		con:    con,
+		chunks: make(chan []byte, 16),
+		closed: make(chan bool),
+	}
+	go lc.loop()
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		lc.Close()
+		return nil, fmt.Errorf("failed to write to console: %v", err)
+	}
+	timeout := time.NewTimer(time.Duration(cfg.Timeout) * time.Second)
+	defer timeout.Stop()
+	sentUser, sentPassword := false, false
+	matchPos := 0 // output before matchPos was already answered
+	for {
+		select {
+		case chunk, ok := <-lc.chunks:
+			if !ok {
+				lc.Close()
+				return nil, fmt.Errorf("console closed during login: %v", lc.err)
+			}
+			lc.buf = append(lc.buf, chunk...)
+		case <-timeout.C:
+			log.Logf(0, "console login: no prompt within %vs, assuming already logged in", cfg.Timeout)
+			return lc, nil
+		}
+		start := bytes.LastIndexByte(lc.buf, '\n') + 1
+		if start < matchPos {
+			start = matchPos
+		}
+		line := lc.buf[start:]
+		var reply string
+		switch {
+		case loginRe.Match(line):
+			if sentPassword {
+				lc.Close()
+				return nil, fmt.Errorf("console login as %v failed", cfg.User)
+			}
+			reply, sentUser = cfg.User, true
+		case passwordRe.Match(line) && sentUser && !sentPassword:
+			reply, sentPassword = cfg.Password, true
+		case shellRe.Match(line):
+			return lc, nil
+		default:
+			continue
+		}
+		matchPos = len(lc.buf)
+		if _, err := io.WriteString(w, reply+"\n"); err != nil {
+			lc.Close()
+			return nil, fmt.Errorf("failed to write to console: %v", err)
+		}
+	}
+}
+
+// loginCon is the console stream after login: the output read during login followed by the rest.
+type loginCon struct {
+	con    io.ReadCloser
+	chunks chan []byte
+	closed chan bool
+	once   sync.Once
+	err    error // set before chunks is closed
+
+	buf []byte // output that was read, but not returned from Read yet
+}
+
+func (lc *loginCon) loop() {
+	defer close(lc.chunks)
+	for {
+		buf := make([]byte, 4<<10)
+		n, err := lc.con.Read(buf)
+		if n != 0 {
+			select {
+			case lc.chunks <- buf[:n]:
+			case <-lc.closed:
+				lc.err = io.EOF
+				return
+			}
+		}
+		if err != nil {
+			lc.err = err
+			return
+		}
+	}
+}
+
+func (lc *loginCon) Read(data []byte) (int, error) {
+	if len(lc.buf) == 0 {
+		chunk, ok := <-lc.chunks
+		if !ok {
+			return 0, lc.err
+		}
+		lc.buf = chunk
+	}
+	n := copy(data, lc.buf)
+	lc.buf = lc.buf[n:]
+	return n, nil
+}
+
+func (lc *loginCon) Close() error {
+	lc.once.Do(func() { close(lc.closed) })
+	return lc.con.Close()
+}
@@ -0,0 +1,131 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vmimpl
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// testGetty emulates a console: it prints output and answers input lines according to script,
+// the script maps an input line to the console output it triggers.
+func testGetty(output string, script map[string]string) (io.ReadCloser, io.Writer, <-chan []string) {
+	outr, outw := io.Pipe()
+	inr, inw := io.Pipe()
+	inputc := make(chan []string, 1)
+	go func() {
+		var input []string
+		defer func() { inputc <- input }()
+		if _, err := io.WriteString(outw, output); err != nil {
+			return
+		}
+		for s := bufio.NewScanner(inr); s.Scan(); {
+			input = append(input, s.Text())
+			if _, err := io.WriteString(outw, script[s.Text()]); err != nil {
+				return
+			}
+			if s.Text() == "exit" {
+				break
+			}
+		}
+		outw.Close()
+	}()
+	return outr, inw, inputc
+}
+
+func TestLoginConsole(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		script map[string]string
+		input  []string
+		result string
+		err    bool
+	}{
+		{
+			name:   "login",
+			output: "[    1.000000] booting\n",
+			script: map[string]string{
+				"":       "\nsyzkaller login: ",
+				"root":   "Password: ",
+				"secret": "\nroot@syzkaller:~# ",
+				"exit":   "[    2.000000] kernel output\n",
+			},
+			input: []string{"", "root", "secret", "exit"},
+			result: "[    1.000000] booting\n\nsyzkaller login: Password: \nroot@syzkaller:~# " +
+				"[    2.000000] kernel output\n",
+		},
+		{
+			name: "already-logged-in",
+			script: map[string]string{
+				"":     "\nroot@syzkaller:~# ",
+				"exit": "[    2.000000] kernel output\n",
+			},
+			input:  []string{"", "exit"},
+			result: "\nroot@syzkaller:~# [    2.000000] kernel output\n",
+		},
+		{
+			name:   "no-prompt",
+			output: "[    1.000000] booting\n",
+			script: map[string]string{
+				"exit": "[    2.000000] kernel output\n",
+			},
+			input:  []string{"", "exit"},
+			result: "[    1.000000] booting\n[    2.000000] kernel output\n",
+		},
+		{
+			name: "wrong-password",
+			script: map[string]string{
+				"":       "\nsyzkaller login: ",
+				"root":   "Password: ",
+				"secret": "\nLogin incorrect\nsyzkaller login: ",
+			},
+			input: []string{"", "root", "secret"},
+			err:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &ConsoleLogin{
+				User:     "root",
+				Password: "secret",
+			}
+			if err := cfg.Check(); err != nil {
+				t.Fatal(err)
+			}
+			cfg.Timeout = 1
+			con, w, inputc := testGetty(test.output, test.script)
+			rc, err := LoginConsole(con, w, cfg)
+			if test.err {
+				if err == nil {
+					t.Fatalf("login did not fail")
+				}
+				w.(io.Closer).Close()
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Monitoring begins: the test program runs and finishes.
+				if _, err := io.WriteString(w, "exit\n"); err != nil {
+					t.Fatal(err)
+				}
+				result, err := ioutil.ReadAll(rc)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rc.Close()
+				if string(result) != test.result {
+					t.Fatalf("got output:\n%q\nwant:\n%q", result, test.result)
+				}
+			}
+			input := <-inputc
+			if strings.Join(input, "|") != strings.Join(test.input, "|") {
+				t.Fatalf("got input %q, want %q", input, test.input)
+			}
+		})
+	}
+}
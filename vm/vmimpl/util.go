@@ -4,7 +4,12 @@
 package vmimpl
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/google/syzkaller/pkg/log"
@@ -74,3 +79,41 @@ func sshArgs(debug bool, sshKey, portArg string, port int) []string {
 	}
 	return args
 }
+
+var fileHashes struct {
+	sync.Mutex
+	m map[string]string // "file/size/mtime" -> hash
+}
+
+// FileHash returns sha1 of the file contents in hex form (e.g. to identify the kernel of a crash).
+// Hashes are cached while the file does not change.
+func FileHash(file string) (string, error) {
+	stat, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("%v/%v/%v", file, stat.Size(), stat.ModTime().UnixNano())
+	fileHashes.Lock()
+	hash := fileHashes.m[key]
+	fileHashes.Unlock()
+	if hash != "" {
+		return hash, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	hash = hex.EncodeToString(h.Sum(nil))
+	fileHashes.Lock()
+	if fileHashes.m == nil {
+		fileHashes.m = make(map[string]string)
+	}
+	fileHashes.m[key] = hash
+	fileHashes.Unlock()
+	return hash, nil
+}
@@ -35,11 +35,13 @@ type Instance interface {
 
 	// Forward sets up forwarding from within VM to the given tcp
 	// port on the host and returns the address to use in VM.
+	// Returns ErrUnsupported if the VM can't forward ports (see ReverseTunneler).
 	Forward(port int) (string, error)
 
 	// Run runs cmd inside of the VM (think of ssh cmd).
 	// outc receives combined cmd and kernel console output.
-	// errc receives either command Wait return error or vmimpl.ErrTimeout.
+	// errc receives either command Wait return error, vmimpl.ErrTimeout
+	// or InfraError if the VM has failed because of the host.
 	// Command is terminated after timeout. Send on the stop chan can be used to terminate it earlier.
 	Run(timeout time.Duration, stop <-chan bool, command string) (outc <-chan []byte, errc <-chan error, err error)
 
@@ -52,6 +54,175 @@ type Instance interface {
 	Close()
 }
 
+// Sharer is optionally implemented by pools that can make a host file accessible
+// to all VMs at once (e.g. via a shared directory), so that it does not need
+// to be copied into every VM separately.
+type Sharer interface {
+	// Share makes hostSrc accessible to all VMs of the pool and returns file name in VM.
+	// Only pools created with Env.ShareFiles are required to support sharing.
+	Share(hostSrc string) (string, error)
+}
+
+// SharedFilesChecker is optionally implemented by instances of Sharer pools
+// that can lose access to the shared files (e.g. if the shared dir fails to mount).
+// Files are copied into such instances as usual.
+type SharedFilesChecker interface {
+	SharedFiles() bool
+}
+
+// Placer is optionally implemented by pools that span several physical hosts or cloud zones,
+// so that the placement of instances can be chosen by a placement strategy (see placement config).
+type Placer interface {
+	// Locations returns all locations the pool can place instances in.
+	Locations() []Location
+	// CreateAt creates and boots a new VM instance in loc (one of Locations).
+	CreateAt(workdir string, index int, loc Location) (Instance, error)
+}
+
+// CmdlineCreator is optionally implemented by pools that can boot individual instances
+// with additional kernel command line arguments (e.g. to toggle kernel features per instance).
+type CmdlineCreator interface {
+	// CreateWithCmdline creates and boots a new VM instance with cmdline appended to the kernel command line.
+	CreateWithCmdline(workdir string, index int, cmdline string) (Instance, error)
+}
+
+// TakeoverWatcher is optionally implemented by pools which machines can be taken over by humans
+// for interactive use (e.g. isolated with console_mux), fuzzing on such machines is paused meanwhile.
+type TakeoverWatcher interface {
+	// TakenOver returns true if the machine of instance index is currently used by a human.
+	TakenOver(index int) bool
+}
+
+// CmdlineReporter is optionally implemented by instances that boot the kernel with a command line
+// configured on the host (e.g. qemu with kernel), so that it can be recorded even if the guest prints nothing.
+type CmdlineReporter interface {
+	// KernelCmdline returns the full kernel command line the instance was booted with
+	// (empty if the command line is not controlled by the host).
+	KernelCmdline() string
+}
+
+// OutputFilterer is optionally implemented by pools of machines that mix backend-specific noise
+// (e.g. bootloader chatter on a serial console) into the kernel output, which the generic reporter
+// may take for a crash or that may corrupt crash reports.
+type OutputFilterer interface {
+	// OutputFilter returns a func that removes the noise from a line of console output
+	// (including the trailing newline, if any) before it's checked for crashes.
+	// The func may return the line as is, change it or return nil to drop it.
+	// The raw console output is still saved as the crash log.
+	OutputFilter() func(line []byte) []byte
+}
+
+// Location is a place where an instance can run.
+type Location struct {
+	Name string // e.g. host address or cloud zone
+	Zone string // failure domain of the location (e.g. rack or cloud zone), empty if unknown
+}
+
+// PstoreReader is optionally implemented by instances that can recover
+// pstore records left by a crashed kernel (e.g. a panic that did not make it to the console).
+type PstoreReader interface {
+	// ReadPstore reboots the VM and returns contents of pstore records saved by the previous kernel.
+	// The instance can't be used for anything else afterwards.
+	ReadPstore() ([]byte, error)
+}
+
+// Infoer is optionally implemented by instances that have additional information
+// worth attaching to crash reports (e.g. paths of files produced by instrumentation).
+type Infoer interface {
+	// Info returns the information in human-readable form.
+	Info() ([]byte, error)
+}
+
+// Artifacter is optionally implemented by instances that produce files worth preserving
+// with crashes (e.g. output of instrumentation), see bundle_crashes config.
+type Artifacter interface {
+	// Artifacts returns paths of the files on the host.
+	Artifacts() []string
+}
+
+// Recorder is optionally implemented by instances that record their execution
+// for deterministic replay (e.g. to debug races that are hard to reproduce).
+type Recorder interface {
+	// Recording stops the VM and returns the file with the recorded execution
+	// and the command that replays it (the command refers to the file by its path).
+	// Returns empty file if the VM does not record its execution.
+	// The instance can't be used for anything else afterwards.
+	Recording() (file, replay string, err error)
+}
+
+// MaintenanceWatcher is optionally implemented by instances that can detect host maintenance
+// events (e.g. live migration on clouds) during which the VM is paused and produces no output.
+type MaintenanceWatcher interface {
+	// Maintenance returns a channel that receives true when a maintenance event starts
+	// and false when it ends, or nil if maintenance events are not watched.
+	// Events are delivered only while a command started with Run is running.
+	Maintenance() <-chan bool
+}
+
+// ReverseTunneler is optionally implemented by instances that can't forward ports (Forward returns
+// ErrUnsupported), but can tunnel connections from the VM to the host over their control channel.
+type ReverseTunneler interface {
+	// ReverseTunnel sets up a tunnel from within VM to the given tcp port on the host
+	// and returns the address to use in VM.
+	ReverseTunnel(port int) (string, error)
+}
+
+// FileReader is optionally implemented by instances that can read files in the VM
+// while a command started with Run is running (used to sample guest state, see leak_watch).
+type FileReader interface {
+	// ReadFile returns contents of the file in the VM.
+	ReadFile(file string) ([]byte, error)
+}
+
+// Execer is optionally implemented by instances that can run setup and probe commands
+// in the VM (e.g. over ssh) and report their output streams and exit code separately.
+type Execer interface {
+	// Exec runs command in the VM and waits for it to finish.
+	// err is non-nil only if the command can't be run or does not finish within timeout,
+	// the command failure is reported with a non-zero exitCode.
+	Exec(command string, timeout time.Duration) (stdout, stderr []byte, exitCode int, err error)
+}
+
+// Prober is optionally implemented by instances that can make the kernel print something
+// to the console bypassing the fuzzing process (e.g. trigger sysrq-l over ssh), it's used to tell
+// a dead/hanged kernel from a live kernel with a stalled console when there is no output.
+type Prober interface {
+	// Probe asks the kernel to print something to the console and returns true
+	// if the request was sent. It must not wait for the output.
+	Probe() bool
+}
+
+// BlockStatser is optionally implemented by instances that can report I/O statistics
+// of their block devices (e.g. qemu with block_stats).
+type BlockStatser interface {
+	// BlockStats returns cumulative statistics since the VM start (nil if not enabled).
+	BlockStats() ([]BlockStats, error)
+}
+
+// BlockStats is I/O statistics of a VM block device.
+type BlockStats struct {
+	Device     string `json:"device"`
+	ReadOps    uint64 `json:"read_ops"`
+	ReadBytes  uint64 `json:"read_bytes"`
+	WriteOps   uint64 `json:"write_ops"`
+	WriteBytes uint64 `json:"write_bytes"`
+	Errors     uint64 `json:"errors"` // failed and invalid operations
+}
+
+// KernelTagger is optionally implemented by instances of pools that run several kernels
+// (e.g. to fuzz two kernel builds side-by-side for differential analysis).
+type KernelTagger interface {
+	// KernelTag returns the tag of the kernel the instance runs (empty if the pool runs one kernel).
+	KernelTag() string
+}
+
+// KernelIndexer is optionally implemented by pools that run several kernels (see KernelTagger),
+// it allows to create instances that run a particular kernel (e.g. a kernel with KASAN).
+type KernelIndexer interface {
+	// KernelIndexes returns indexes of instances that run the kernel with the tag.
+	KernelIndexes(tag string) []int
+}
+
 // Env contains global constant parameters for a pool of VMs.
 type Env struct {
 	// Unique name
@@ -65,6 +236,10 @@ type Env struct {
 	SSHUser string
 	Debug   bool
 	Config  []byte // json-serialized VM-type-specific config
+	// VMs need access to files shared with Sharer.Share.
+	ShareFiles bool
+	// Instances need to support PstoreReader.
+	ReadPstore bool
 }
 
 // BootError is returned by Pool.Create when VM does not boot.
@@ -81,6 +256,22 @@ func (err BootError) BootError() (string, []byte) {
 	return err.Title, err.Output
 }
 
+// InfraError is sent to the Run error channel when the VM has failed because of the host
+// (e.g. the VM process was killed by the host OOM killer) rather than because of the kernel.
+type InfraError struct {
+	Err error
+}
+
+func (err *InfraError) Error() string {
+	return err.Err.Error()
+}
+
+// IsInfra returns true if err is an InfraError.
+func IsInfra(err error) bool {
+	_, ok := err.(*InfraError)
+	return ok
+}
+
 // Register registers a new VM type within the package.
 func Register(typ string, ctor ctorFunc, allowsOvercommit bool) {
 	Types[typ] = Type{
@@ -100,6 +291,8 @@ var (
 	// Close to interrupt all pending operations in all VMs.
 	Shutdown   = make(chan struct{})
 	ErrTimeout = errors.New("timeout")
+	// Returned by optional operations that the VM does not support.
+	ErrUnsupported = errors.New("not supported by the VM")
 
 	Types = make(map[string]Type)
 )
@@ -0,0 +1,170 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vmimpl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/osutil"
+)
+
+// WarmPool keeps a number of pre-created (booted and idle) instances, so that Pool.Create
+// can hand them out immediately and the boot latency is hidden (used by cloud backends,
+// see warm_pool_size). Instances that were idle for longer than the TTL are closed to bound
+// the cost, they are not replaced until the next Get.
+type WarmPool struct {
+	size    int
+	ttl     time.Duration
+	workdir string
+	create  func(workdir string, seq int) (Instance, error)
+	stop    chan bool
+
+	mu      sync.Mutex
+	ready   []*warmInstance
+	booting int
+	seq     int
+	closed  bool
+}
+
+type warmInstance struct {
+	inst    Instance
+	created time.Time
+}
+
+// NewWarmPool starts creation of size instances with create. Each instance gets a fresh
+// workdir inside of workdir, the instance owns it and must remove it on Close.
+func NewWarmPool(size int, ttl time.Duration, workdir string,
+	create func(workdir string, seq int) (Instance, error)) (*WarmPool, error) {
+	if size <= 0 || ttl <= 0 {
+		return nil, fmt.Errorf("bad warm pool size %v or ttl %v", size, ttl)
+	}
+	if err := osutil.MkdirAll(workdir); err != nil {
+		return nil, fmt.Errorf("failed to create warm pool dir: %v", err)
+	}
+	wp := &WarmPool{
+		size:    size,
+		ttl:     ttl,
+		workdir: workdir,
+		create:  create,
+		stop:    make(chan bool),
+	}
+	wp.mu.Lock()
+	wp.replenishLocked()
+	wp.mu.Unlock()
+	go wp.loop()
+	return wp, nil
+}
+
+// Get returns a ready instance, or nil if there are no ready instances.
+// In both cases creation of new instances is started to refill the pool.
+func (wp *WarmPool) Get() Instance {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	wp.expireLocked()
+	var inst Instance
+	if len(wp.ready) != 0 {
+		inst = wp.ready[0].inst
+		wp.ready = wp.ready[1:]
+	}
+	wp.replenishLocked()
+	return inst
+}
+
+// Ready returns the number of ready instances.
+func (wp *WarmPool) Ready() int {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	return len(wp.ready)
+}
+
+// Close closes all ready instances, instances that are still booting are closed once they boot.
+// Close is called automatically on shutdown.
+func (wp *WarmPool) Close() {
+	wp.mu.Lock()
+	if wp.closed {
+		wp.mu.Unlock()
+		return
+	}
+	wp.closed = true
+	ready := wp.ready
+	wp.ready = nil
+	wp.mu.Unlock()
+	close(wp.stop)
+	for _, w := range ready {
+		w.inst.Close()
+	}
+}
+
+func (wp *WarmPool) loop() {
+	period := wp.ttl / 4
+	if period > time.Minute {
+		period = time.Minute
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			wp.mu.Lock()
+			wp.expireLocked()
+			wp.mu.Unlock()
+		case <-Shutdown:
+			wp.Close()
+			return
+		case <-wp.stop:
+			return
+		}
+	}
+}
+
+func (wp *WarmPool) expireLocked() {
+	ready := wp.ready[:0]
+	for _, w := range wp.ready {
+		if time.Since(w.created) < wp.ttl {
+			ready = append(ready, w)
+			continue
+		}
+		log.Logf(1, "closing warm instance idle for %v", time.Since(w.created))
+		go w.inst.Close()
+	}
+	wp.ready = ready
+}
+
+func (wp *WarmPool) replenishLocked() {
+	for ; !wp.closed && len(wp.ready)+wp.booting < wp.size; wp.booting++ {
+		go wp.boot(wp.seq)
+		wp.seq++
+	}
+}
+
+func (wp *WarmPool) boot(seq int) {
+	workdir := filepath.Join(wp.workdir, fmt.Sprintf("warm-%v", seq))
+	inst, err := wp.createInstance(workdir, seq)
+	wp.mu.Lock()
+	wp.booting--
+	closed := wp.closed
+	if err == nil && !closed {
+		wp.ready = append(wp.ready, &warmInstance{inst, time.Now()})
+	}
+	wp.mu.Unlock()
+	if err != nil {
+		// Not retried here, the next Get starts a new instance.
+		log.Logf(0, "failed to create warm instance: %v", err)
+		os.RemoveAll(workdir)
+	} else if closed {
+		inst.Close()
+	}
+}
+
+func (wp *WarmPool) createInstance(workdir string, seq int) (Instance, error) {
+	if err := osutil.MkdirAll(workdir); err != nil {
+		return nil, err
+	}
+	return wp.create(workdir, seq)
+}
@@ -0,0 +1,106 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vmimpl
+
+import (
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type warmTestInstance struct {
+	closed *int32
+}
+
+func (inst *warmTestInstance) Copy(hostSrc string) (string, error) { return hostSrc, nil }
+func (inst *warmTestInstance) Forward(port int) (string, error)    { return "", nil }
+func (inst *warmTestInstance) Diagnose() bool                      { return false }
+func (inst *warmTestInstance) Close()                              { atomic.AddInt32(inst.closed, 1) }
+
+func (inst *warmTestInstance) Run(timeout time.Duration, stop <-chan bool, command string) (
+	<-chan []byte, <-chan error, error) {
+	return nil, nil, nil
+}
+
+func newTestWarmPool(t *testing.T, size int, ttl time.Duration) (*WarmPool, *int32, *int32) {
+	dir, err := ioutil.TempDir("", "syz-warm-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, closed := new(int32), new(int32)
+	wp, err := NewWarmPool(size, ttl, dir, func(workdir string, seq int) (Instance, error) {
+		if _, err := os.Stat(workdir); err != nil {
+			t.Errorf("no instance workdir: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond) // boot
+		atomic.AddInt32(created, 1)
+		return &warmTestInstance{closed: closed}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wp, created, closed
+}
+
+func waitReady(t *testing.T, wp *WarmPool, ready int) {
+	for start := time.Now(); wp.Ready() != ready; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("have %v ready instances, want %v", wp.Ready(), ready)
+		}
+	}
+}
+
+func TestWarmPool(t *testing.T) {
+	wp, created, closed := newTestWarmPool(t, 2, time.Hour)
+	defer os.RemoveAll(wp.workdir)
+	defer wp.Close()
+	waitReady(t, wp, 2)
+	start := time.Now()
+	inst := wp.Get()
+	if inst == nil {
+		t.Fatalf("got no warm instance")
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Fatalf("getting a warm instance took %v", d)
+	}
+	// The pool is replenished in background.
+	if wp.Ready() != 1 {
+		t.Fatalf("have %v ready instances right after Get, want 1", wp.Ready())
+	}
+	waitReady(t, wp, 2)
+	if n := atomic.LoadInt32(created); n != 3 {
+		t.Fatalf("created %v instances, want 3", n)
+	}
+	// Empty pool returns nil and starts creation of instances.
+	wp.Get()
+	wp.Get()
+	if inst := wp.Get(); inst != nil {
+		t.Fatalf("got an instance from an empty pool")
+	}
+	waitReady(t, wp, 2)
+	wp.Close()
+	if n := atomic.LoadInt32(closed); n != 2 {
+		t.Fatalf("closed %v instances, want 2", n)
+	}
+}
+
+func TestWarmPoolTTL(t *testing.T) {
+	wp, created, closed := newTestWarmPool(t, 2, 500*time.Millisecond)
+	defer os.RemoveAll(wp.workdir)
+	defer wp.Close()
+	waitReady(t, wp, 2)
+	// Idle instances are closed and not replaced until the next Get.
+	waitReady(t, wp, 0)
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt32(closed); n != 2 || wp.Ready() != 0 || atomic.LoadInt32(created) != 2 {
+		t.Fatalf("closed %v, created %v, ready %v; want 2, 2, 0",
+			n, atomic.LoadInt32(created), wp.Ready())
+	}
+	if inst := wp.Get(); inst != nil {
+		t.Fatalf("got an expired instance")
+	}
+	waitReady(t, wp, 2)
+}
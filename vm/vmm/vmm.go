@@ -254,7 +254,7 @@ func (inst *instance) Copy(hostSrc string) (string, error) {
 	}
 	_, err := osutil.RunCmd(10*time.Minute, "", "scp", args...)
 	if err != nil {
-		return "", err
+		return "", vmimpl.ClassifyCopyError(err)
 	}
 	return vmDst, nil
 }
@@ -310,6 +310,10 @@ func (inst *instance) Run(timeout time.Duration, stop <-chan bool, command strin
 	return inst.merger.Output, errc, nil
 }
 
+func (inst *instance) Exec(command string, timeout time.Duration) ([]byte, []byte, int, error) {
+	return vmimpl.SSHExec(inst.debug, timeout, inst.sshhost, inst.sshkey, inst.sshuser, inst.sshport, command)
+}
+
 func (inst *instance) Diagnose() bool {
 	return vmimpl.DiagnoseOpenBSD(inst.consolew)
 }
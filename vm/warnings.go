@@ -0,0 +1,143 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/report"
+)
+
+// Rate limiting of non-fatal kernel warnings (warnings config).
+// A warning that is not followed by a panic does not stop the execution: it becomes the pending report
+// of the run, which is returned after the window expires or the run ends, with the number of repeats.
+// Each distinct warning is reported at most once per period (by any VM of the pool),
+// repeats in between are counted and added to the next report of the warning.
+
+type warningState struct {
+	reported time.Time // when the warning was last reported
+	repeats  int       // repeats since the last report
+}
+
+var (
+	// warningEnd is printed at the end of a non-fatal warning
+	// (with panic_on_warn the kernel panics before printing it).
+	warningEnd   = []byte("---[ end trace ")
+	warningPanic = []byte("Kernel panic")
+)
+
+func isWarning(rep *report.Report) bool {
+	return strings.HasPrefix(rep.Title, "WARNING")
+}
+
+// handleWarning is called when a crash is detected in mon.output[mon.matchPos:].
+// It returns false if the crash needs to be reported right away (it's not a non-fatal warning).
+// Otherwise the warning is counted or becomes the pending report, and mon.skipPos is moved past it;
+// or, if the warning is not printed completely yet, mon.warnWait is set to wait for the rest of it.
+func (mon *monitor) handleWarning() bool {
+	pool := mon.inst.pool
+	if pool.warnings.Period == 0 {
+		return false
+	}
+	rep := mon.reporter.Parse(mon.output[mon.matchPos:])
+	if rep == nil || !isWarning(rep) {
+		return false
+	}
+	start := mon.matchPos + rep.StartPos
+	if bytes.Contains(mon.output[start:], warningPanic) {
+		return false
+	}
+	end := bytes.Index(mon.output[start:], warningEnd)
+	if end == -1 {
+		if mon.warnWait.IsZero() {
+			mon.warnWait = time.Now()
+		}
+		mon.warnPos = start
+		return true
+	}
+	mon.warnWait = time.Time{}
+	end += start
+	if eol := bytes.IndexByte(mon.output[end:], '\n'); eol != -1 {
+		end += eol + 1
+	} else {
+		end = len(mon.output)
+	}
+	switch {
+	case mon.warning != nil && mon.warning.Title == rep.Title:
+		mon.warningRepeats++
+	case mon.warning != nil:
+		pool.countWarning(rep.Title)
+	case pool.claimWarning(rep.Title):
+		ctxStart := start - beforeContext
+		if ctxStart < 0 {
+			ctxStart = 0
+		}
+		rep.Output = append([]byte{}, mon.output[ctxStart:end]...)
+		rep.EndPos += mon.matchPos - ctxStart
+		rep.StartPos = start - ctxStart
+		rep.Time = time.Now()
+		if mon.beforeExec(start) {
+			rep.Class = report.ClassBootWarning
+		}
+		mon.warning = rep
+		mon.warningDeadline = rep.Time.Add(time.Duration(pool.warnings.Window) * time.Second)
+		log.Logf(1, "vm-%v: kernel warning %q, continuing", mon.inst.index, rep.Title)
+	}
+	mon.skipPos = end
+	mon.matchPos = end
+	return true
+}
+
+// finishWarning returns the pending warning report with the number of repeats.
+func (mon *monitor) finishWarning() *report.Report {
+	rep := mon.warning
+	mon.warning = nil
+	rep.Repeats = mon.warningRepeats + mon.inst.pool.takeWarningRepeats(rep.Title)
+	mon.warningRepeats = 0
+	return rep
+}
+
+// claimWarning returns true if the warning can be reported now (marking it as reported),
+// otherwise it's counted as a repeat.
+func (pool *Pool) claimWarning(title string) bool {
+	pool.warnMu.Lock()
+	defer pool.warnMu.Unlock()
+	w := pool.warnState[title]
+	if w == nil {
+		w = new(warningState)
+		pool.warnState[title] = w
+	}
+	if !w.reported.IsZero() && time.Since(w.reported) < time.Duration(pool.warnings.Period)*time.Second {
+		w.repeats++
+		return false
+	}
+	w.reported = time.Now()
+	return true
+}
+
+func (pool *Pool) countWarning(title string) {
+	pool.warnMu.Lock()
+	defer pool.warnMu.Unlock()
+	w := pool.warnState[title]
+	if w == nil {
+		w = new(warningState)
+		pool.warnState[title] = w
+	}
+	w.repeats++
+}
+
+func (pool *Pool) takeWarningRepeats(title string) int {
+	pool.warnMu.Lock()
+	defer pool.warnMu.Unlock()
+	w := pool.warnState[title]
+	if w == nil {
+		return 0
+	}
+	repeats := w.repeats
+	w.repeats = 0
+	return repeats
+}
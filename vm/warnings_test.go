@@ -0,0 +1,67 @@
+// Copyright 2018 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/mgrconfig"
+	"github.com/google/syzkaller/pkg/report"
+)
+
+func TestWarningRateLimit(t *testing.T) {
+	cfg := &mgrconfig.Config{
+		Warnings: mgrconfig.Warnings{
+			Period: 3600,
+			Window: 60,
+		},
+	}
+	pool, reporter := createTestPool(t, cfg)
+	defer os.RemoveAll(cfg.Workdir)
+	warning := "WARNING: CPU: 0 PID: 4525 at net/core/dev.c:123 foo_bar+0x10/0x20 net/core/dev.c:123\n" +
+		"Modules linked in:\n" +
+		"Call Trace:\n" +
+		" foo_bar+0x10/0x20 net/core/dev.c:123\n" +
+		" baz+0x10/0x20 net/core/dev.c:456\n" +
+		"---[ end trace 5a3c2b1e0f9d8c7b ]---\n"
+	panicking := "WARNING: CPU: 0 PID: 4525 at net/core/dev.c:123 foo_bar+0x10/0x20 net/core/dev.c:123\n" +
+		"Kernel panic - not syncing: panic_on_warn set ...\n"
+	const title = "WARNING in foo_bar"
+	run := func(exit bool, output ...string) *report.Report {
+		return runTestInstance(t, pool, reporter, true, func(inst *testInstance) {
+			for _, out := range output {
+				inst.outc <- []byte(out)
+			}
+			if exit {
+				inst.errc <- nil
+			}
+		})
+	}
+	// Identical warnings: the first one is reported with the number of the rest when the run ends.
+	rep := run(true, warning, executingProgramStr1+"\n", warning, warning, executingProgramStr1+"\n")
+	if rep == nil || rep.Title != title || rep.Repeats != 2 {
+		t.Fatalf("got bad report: %+v", rep)
+	}
+	// Within the period the warning is only counted.
+	if rep := run(true, warning, warning); rep != nil {
+		t.Fatalf("got unexpected report: %v", rep.Title)
+	}
+	// After the period it's reported again with the repeats counted in between,
+	// the window expires while the VM is still running.
+	pool.warnState[title].reported = time.Now().Add(-2 * time.Hour)
+	pool.warnings.Window = 1
+	rep = run(false, warning, executingProgramStr1+"\n")
+	if rep == nil || rep.Title != title || rep.Repeats != 2 {
+		t.Fatalf("got bad report: %+v", rep)
+	}
+	// Warnings that panic (panic_on_warn) and other crashes are reported right away.
+	if rep := run(false, panicking); rep == nil || rep.Title != title || rep.Repeats != 0 {
+		t.Fatalf("got bad report: %+v", rep)
+	}
+	if rep := run(false, warning, "BUG: bad\n"); rep == nil || rep.Title != "BUG: bad" {
+		t.Fatalf("got bad report: %+v", rep)
+	}
+}